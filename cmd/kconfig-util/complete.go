@@ -1,25 +1,102 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/jphx/kconfig/common"
 	"github.com/jphx/kconfig/config"
 )
 
 type completeCommandOptions struct {
+	Namespaces   bool `long:"namespaces" description:"Complete namespace names for the nickname, instead of nicknames."`
+	Users        bool `long:"users" description:"Complete user names for the nickname, instead of nicknames."`
+	Contexts     bool `long:"contexts" description:"Complete context names for the nickname, instead of nicknames."`
+	Clusters     bool `long:"clusters" description:"Complete cluster names for the nickname, instead of nicknames."`
+	Files        bool `long:"files" description:"Complete file paths, such as for the --kubeconfig option, instead of nicknames."`
+	Options      bool `long:"options" description:"Complete the long option names of the kset command, instead of nicknames."`
+	Live         bool `long:"live" description:"With --namespaces, also ask the cluster for its namespaces."`
+	Descriptions bool `long:"descriptions" description:"Print each completion as the value, a tab character, and a description of it, for shells that can display them.  It's the same as \"--format tab\"."`
+
+	Format string `long:"format" value-name:"FORMAT" description:"How to print the completions:  \"plain\" for just the values, \"tab\" for each value, a tab character, and a description, \"zsh\" for the \"value:description\" form of the zsh _describe function, or \"json\" for an array of objects with \"value\" and \"description\" fields."`
+	Limit  int    `long:"limit" value-name:"N" description:"Print at most N completions, the first ones in sorted order.  Zero means no limit."`
+
+	Tags []string `long:"tag" value-name:"TAG" description:"Only complete nicknames that have this tag.  If the option is repeated, the nicknames must have all the tags."`
 }
 
 var completeOptions completeCommandOptions
 
+// The formats of the completions printed by the complete subcommand.
+const (
+	completeFormatPlain = "plain"
+	completeFormatTab   = "tab"
+	completeFormatZsh   = "zsh"
+	completeFormatJSON  = "json"
+)
+
+// completion is one completion result, with a description of it for the shells that can display
+// them, which can be empty.
+type completion struct {
+	Value       string `json:"value"`
+	Description string `json:"description,omitempty"`
+}
+
 func (o *completeCommandOptions) Usage() string {
-	return "nickname-prefix"
+	return "[--format FORMAT] [--limit N] [--tag TAG]... nickname-prefix | --namespaces|--users|--contexts|--clusters nickname [prefix] | --files|--options [prefix]"
 }
 
 func (o *completeCommandOptions) Execute(args []string) error {
 	commandProcessor = completeProcessor
 	commandName = "complete"
 
+	modeCount := 0
+	for _, mode := range []bool{o.Namespaces, o.Users, o.Contexts, o.Clusters, o.Files, o.Options} {
+		if mode {
+			modeCount++
+		}
+	}
+	if modeCount > 1 {
+		return fmt.Errorf("Only one of --namespaces, --users, --contexts, --clusters, --files, and --options can be specified.")
+	}
+
+	switch o.Format {
+	case "", completeFormatPlain, completeFormatTab, completeFormatZsh, completeFormatJSON:
+		// Good
+	default:
+		return fmt.Errorf("The --format option must be \"plain\", \"tab\", \"zsh\", or \"json\".")
+	}
+	if o.Descriptions && o.Format != "" && o.Format != completeFormatTab {
+		return fmt.Errorf("The --descriptions option can't be used with --format %s.", o.Format)
+	}
+	if o.Limit < 0 {
+		return fmt.Errorf("The --limit option can't be negative.")
+	}
+
+	if o.Files || o.Options {
+		if len(args) > 1 {
+			return fmt.Errorf("Unrecognized positional argument provided after the prefix.")
+		}
+		return nil
+	}
+
+	if o.Namespaces || o.Users || o.Contexts || o.Clusters {
+		switch len(args) {
+		case 0:
+			return fmt.Errorf("A kconfig nickname must be specified.")
+		case 1, 2:
+			// Good
+		default:
+			return fmt.Errorf("Unrecognized positional argument provided after the prefix.")
+		}
+		return nil
+	}
+
 	switch len(args) {
 	case 0:
 		return fmt.Errorf("A kconfig nickname must be specified.")
@@ -32,22 +109,303 @@ func (o *completeCommandOptions) Execute(args []string) error {
 	return nil
 }
 
+var completeLogger = common.CreateLogger("complete")
+
 func completeProcessor(positionalArgs []string) {
-	nicknamePrefix := positionalArgs[0]
+	var prefix string
+	if len(positionalArgs) > 0 {
+		prefix = positionalArgs[len(positionalArgs)-1]
+	}
 
+	switch {
+	case completeOptions.Namespaces || completeOptions.Users || completeOptions.Contexts || completeOptions.Clusters:
+		completeNicknameValues(positionalArgs)
+	case completeOptions.Files:
+		completeFiles(prefix)
+	case completeOptions.Options:
+		completeKsetOptions(prefix)
+	default:
+		completeNicknames(prefix)
+	}
+}
+
+// completeFormat returns the format the completions are printed in, from the --format and
+// --descriptions options.
+func completeFormat() string {
+	switch {
+	case completeOptions.Format != "":
+		return completeOptions.Format
+	case completeOptions.Descriptions:
+		return completeFormatTab
+	default:
+		return completeFormatPlain
+	}
+}
+
+// limitCompletions returns the completions that the --limit option allows to be printed.
+func limitCompletions(completions []completion) []completion {
+	if completeOptions.Limit > 0 && len(completions) > completeOptions.Limit {
+		return completions[:completeOptions.Limit]
+	}
+	return completions
+}
+
+// printCompletions prints the completions, as many as the --limit option allows, in the format
+// chosen by the --format or --descriptions option.  Descriptions that are empty are left out.
+func printCompletions(completions []completion) {
+	completions = limitCompletions(completions)
+	format := completeFormat()
+	if format == completeFormatJSON {
+		if completions == nil {
+			completions = []completion{}
+		}
+		contents, err := json.Marshal(completions)
+		if err != nil {
+			completeLogger.Debugf("Unable to format the completions as JSON: %v", err)
+			return
+		}
+		fmt.Println(string(contents))
+		return
+	}
+
+	for _, entry := range completions {
+		switch {
+		case format == completeFormatTab && entry.Description != "":
+			fmt.Printf("%s\t%s\n", entry.Value, entry.Description)
+		case format == completeFormatZsh:
+			// Colons in the value would end it early, so they're escaped.
+			value := strings.ReplaceAll(entry.Value, ":", `\:`)
+			if entry.Description != "" {
+				value += ":" + entry.Description
+			}
+			fmt.Println(value)
+		default:
+			fmt.Println(entry.Value)
+		}
+	}
+}
+
+// completeNicknames prints the nicknames and aliases that start with the prefix, sorted, so shells
+// that show them in the order given don't shuffle them.  The description of each nickname is its
+// entry in the nickname_descriptions block, if it has one, followed by the cluster and namespace it
+// selects, which requires reading the kubectl configuration, so it's only done when descriptions
+// are requested, and only for the nicknames the --limit option lets through.  The description of
+// each alias names its nickname.
+func completeNicknames(nicknamePrefix string) {
 	kconfig := config.GetKconfig()
+	var completions []completion
+	for alias, nickname := range kconfig.NicknameAliases() {
+		if strings.HasPrefix(alias, nicknamePrefix) && kconfig.NicknameHasTags(nickname, completeOptions.Tags) {
+			completions = append(completions, completion{Value: alias, Description: fmt.Sprintf("alias of %s", nickname)})
+		}
+	}
 	for nickname := range kconfig.Nicknames {
-		if strings.HasPrefix(nickname, nicknamePrefix) {
-			fmt.Println(nickname)
+		if strings.HasPrefix(nickname, nicknamePrefix) && kconfig.NicknameHasTags(nickname, completeOptions.Tags) {
+			completions = append(completions, completion{Value: nickname})
+		}
+	}
+	sort.Slice(completions, func(i, j int) bool { return completions[i].Value < completions[j].Value })
+	completions = limitCompletions(completions)
+
+	if completeFormat() != completeFormatPlain {
+		for idx, entry := range completions {
+			if _, isNickname := kconfig.Nicknames[entry.Value]; !isNickname || entry.Description != "" {
+				continue
+			}
+			description := kconfig.NicknameDescription(entry.Value)
+			createResults, err := kconfig.ResolveLocalKubectlConfig(entry.Value, nil)
+			if err != nil {
+				completeLogger.Debugf("Unable to resolve nickname \"%s\" for its description: %v", entry.Value, err)
+			} else {
+				merged := createResults.MergedConfig()
+				context := describeContext(merged.Contexts[merged.CurrentContext], createResults.ContextNamespace)
+				if description == "" {
+					description = context
+				} else if context != "" {
+					description = fmt.Sprintf("%s (%s)", description, context)
+				}
+			}
+			completions[idx].Description = description
+		}
+	}
+	printCompletions(completions)
+}
+
+// describeContext returns a description of the cluster and namespace a context refers to.
+func describeContext(context *clientcmdapi.Context, namespace string) string {
+	if context == nil {
+		return ""
+	}
+	if namespace == "" {
+		namespace = context.Namespace
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+	return fmt.Sprintf("%s/%s", context.Cluster, namespace)
+}
+
+// completeFiles prints the files and directories that start with the prefix.  Directories are
+// printed with a trailing slash so that completion can continue into them.
+func completeFiles(prefix string) {
+	matches, err := filepath.Glob(globEscape(prefix) + "*")
+	if err != nil {
+		completeLogger.Debugf("Unable to match files with prefix \"%s\": %v", prefix, err)
+		return
+	}
+
+	var completions []completion
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			completions = append(completions, completion{Value: match + string(filepath.Separator), Description: "directory"})
+		} else {
+			completions = append(completions, completion{Value: match, Description: "file"})
+		}
+	}
+	printCompletions(completions)
+}
+
+// globEscape escapes the characters that filepath.Glob treats specially.
+func globEscape(s string) string {
+	var escaped strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[\`, r) {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
+
+// completeKsetOptions prints the long option names of the kset command that start with the prefix.
+// The description of each is the first sentence of its help text.
+func completeKsetOptions(prefix string) {
+	ksetCommand := parser.Find("kset")
+	if ksetCommand == nil {
+		return
+	}
+
+	var completions []completion
+	for _, option := range ksetCommand.Options() {
+		if option.LongName == "" {
+			continue
+		}
+		name := "--" + option.LongName
+		if strings.HasPrefix(name, prefix) {
+			description, _, _ := strings.Cut(option.Description, ".  ")
+			completions = append(completions, completion{Value: name, Description: strings.TrimSuffix(description, ".")})
+		}
+	}
+	sort.Slice(completions, func(i, j int) bool { return completions[i].Value < completions[j].Value })
+	printCompletions(completions)
+}
+
+// completeNicknameValues prints the namespaces, users, contexts, or clusters that are valid
+// completions for the prefix, when used with the nickname.  An empty nickname means the nickname of
+// the current kset environment.  The candidates come from the kubectl configuration the nickname
+// resolves to.  Namespaces also come from the nickname's namespace history and kset history and, if
+// requested, from the cluster, whose namespaces are cached briefly.
+func completeNicknameValues(positionalArgs []string) {
+	nickname := positionalArgs[0]
+	if nickname == "" {
+		nickname = config.KsetNickname(os.Getenv("_KCONFIG_KSET"))
+		if nickname == "" {
+			return
 		}
 	}
+
+	var prefix string
+	if len(positionalArgs) > 1 {
+		prefix = positionalArgs[1]
+	}
+
+	// Completion shouldn't produce error messages, so quietly do nothing for unknown nicknames.
+	kconfig := config.GetKconfig()
+	createResults, err := kconfig.ResolveLocalKubectlConfig(nickname, nil)
+	if err != nil {
+		completeLogger.Debugf("Unable to resolve nickname \"%s\" for completion: %v", nickname, err)
+		return
+	}
+
+	// The candidates map each value to its description, if it has one.
+	candidates := make(map[string]string)
+	if completeOptions.Users {
+		for user := range createResults.BaseConfig.AuthInfos {
+			candidates[user] = ""
+		}
+
+	} else if completeOptions.Contexts {
+		for name, context := range createResults.BaseConfig.Contexts {
+			candidates[name] = describeContext(context, "")
+		}
+
+	} else if completeOptions.Clusters {
+		for name, cluster := range createResults.BaseConfig.Clusters {
+			candidates[name] = cluster.Server
+		}
+
+	} else {
+		candidates[createResults.ContextNamespace] = "current namespace"
+		for _, context := range createResults.BaseConfig.Contexts {
+			if _, exists := candidates[context.Namespace]; context.Namespace != "" && !exists {
+				candidates[context.Namespace] = ""
+			}
+		}
+
+		history := config.ReadKconfigState().NamespaceHistory[nickname]
+		if history != nil {
+			if _, exists := candidates[history.Previous]; history.Previous != "" && !exists {
+				candidates[history.Previous] = "previous namespace"
+			}
+		}
+		for _, namespace := range config.GetNamespacesSeen(nickname) {
+			if _, exists := candidates[namespace]; !exists {
+				candidates[namespace] = ""
+			}
+		}
+
+		if completeOptions.Live || kconfig.Preferences.CompleteFromCluster {
+			namespaces, err := createResults.CachedClusterNamespaces()
+			if err != nil {
+				completeLogger.Debugf("Unable to list namespaces from the cluster: %v", err)
+			}
+			for _, namespace := range namespaces {
+				if _, exists := candidates[namespace]; !exists {
+					candidates[namespace] = ""
+				}
+			}
+		}
+	}
+
+	var matches []string
+	for candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) {
+			matches = append(matches, candidate)
+		}
+	}
+	sort.Strings(matches)
+
+	var completions []completion
+	for _, match := range matches {
+		completions = append(completions, completion{Value: match, Description: candidates[match]})
+	}
+	printCompletions(completions)
 }
 
 func init() {
 	_, err := parser.AddCommand("complete",
 		"Print eligible auto-completion results",
 		"To be used for shell autocompletion.  It prints the list of nicknames that are valid "+
-			"completions for the part that has been entered so far",
+			"completions for the part that has been entered so far.  With --namespaces, --users, "+
+			"--contexts, or --clusters, it instead prints the namespaces, users, contexts, or clusters "+
+			"that are valid for the nickname.  With --files or --options, it prints file paths or "+
+			"kset option names.  The results are sorted.  With --descriptions, each result is "+
+			"followed by a tab character and a description, and --format chooses other formats, "+
+			"like \"zsh\" or \"json\".  With --limit, only the first results are printed.",
 		&completeOptions)
 
 	if err != nil {
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestComplete(t *testing.T) {
+	testCases := []struct {
+		Name      string
+		Arguments []string
+		Expect    []string
+	}{
+		{
+			Name:      "Nicknames",
+			Arguments: []string{"dev-with-k"},
+			Expect: []string{
+				"dev-with-kubeconfig",
+				"dev-with-kubeconfig-and-context",
+				"dev-with-kubeconfig-and-context-and-namespace",
+			},
+		},
+		{
+			Name:      "Nicknames with a tag",
+			Arguments: []string{"--tag", "prod", "dev"},
+			Expect:    []string{"dev-extends", "dev-namespace"},
+		},
+		{
+			Name:      "Nicknames with two tags",
+			Arguments: []string{"--tag", "prod", "--tag", "team-a", "dev"},
+			Expect:    []string{"dev-extends"},
+		},
+		{
+			Name:      "Nicknames with a limit",
+			Arguments: []string{"--limit", "2", "dev-with-k"},
+			Expect:    []string{"dev-with-kubeconfig", "dev-with-kubeconfig-and-context"},
+		},
+		{
+			Name:      "Namespaces",
+			Arguments: []string{"--namespaces", "dev"},
+			Expect:    []string{"devnamespace1", "namespace-override", "prodnamespace1", "stagenamespace1"},
+		},
+		{
+			Name:      "Namespaces with prefix",
+			Arguments: []string{"--namespaces", "dev", "dev"},
+			Expect:    []string{"devnamespace1"},
+		},
+		{
+			Name:      "Users with prefix",
+			Arguments: []string{"--users", "dev", "dev"},
+			Expect:    []string{"devuser1", "devuser2"},
+		},
+		{
+			Name:      "Contexts with descriptions",
+			Arguments: []string{"--contexts", "--descriptions", "dev", "dev"},
+			Expect:    []string{"dev\tdev/devnamespace1", "devnonamespace\tdev/default"},
+		},
+		{
+			Name:      "Clusters with descriptions",
+			Arguments: []string{"--clusters", "--descriptions", "dev", "pro"},
+			Expect:    []string{"prod\thttp://prod-cluster/"},
+		},
+		{
+			Name:      "Clusters for zsh",
+			Arguments: []string{"--clusters", "--format", "zsh", "dev", "pro"},
+			Expect:    []string{`prod:http://prod-cluster/`},
+		},
+		{
+			Name:      "Clusters as JSON",
+			Arguments: []string{"--clusters", "--format", "json", "dev"},
+			Expect:    []string{`[{"value":"dev","description":"http://dev-cluster/"},{"value":"prod","description":"http://prod-cluster/"},{"value":"stage","description":"http://stage-cluster/"}]`},
+		},
+		{
+			Name:      "Options",
+			Arguments: []string{"--options", "--", "--out"},
+			Expect:    []string{"--output-file"},
+		},
+		{
+			Name:      "Undefined nickname",
+			Arguments: []string{"--users", "doesnt-exist"},
+			Expect:    nil,
+		},
+	}
+
+	err := copyConfigFile(t, "kconfig.yaml", nil)
+	if err != nil {
+		t.Fatalf("Error copying \"kconfig.yaml\": %v", err)
+	}
+	err = copyConfigFile(t, "kconfig-state.yaml", nil)
+	if err != nil {
+		t.Fatalf("Error copying \"kconfig-state.yaml\": %v", err)
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			args := append([]string{"complete"}, testCase.Arguments...)
+			cmd := exec.Command(kconfigUtilCommand, args...)
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			outputBytes, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("complete command failed: %v\n%s", err, stderr.String())
+			}
+
+			// The completions are sorted, so they're compared in order.
+			actual := strings.Split(strings.TrimSuffix(string(outputBytes), "\n"), "\n")
+			if len(actual) == 1 && actual[0] == "" {
+				actual = nil
+			}
+			if !reflect.DeepEqual(actual, testCase.Expect) {
+				t.Errorf("Expected completions %v, but got %v", testCase.Expect, actual)
+			}
+		})
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		t.Run(shell, func(t *testing.T) {
+			outputBytes, err := exec.Command(kconfigUtilCommand, "completion", shell).Output()
+			if err != nil {
+				t.Fatalf("completion command failed: %v", err)
+			}
+			if !strings.Contains(string(outputBytes), "kconfig-util complete") {
+				t.Errorf("The %s completion script doesn't call the complete subcommand:\n%s", shell, outputBytes)
+			}
+		})
+	}
+
+	err := exec.Command(kconfigUtilCommand, "completion", "csh").Run()
+	if err == nil {
+		t.Errorf("Expected the completion command to fail for an unsupported shell")
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/template"
+)
+
+type completionCommandOptions struct {
+	shellFunctionNames
+}
+
+var completionOptions completionCommandOptions
+
+// completionScripts maps each supported shell to the template of the completion script for it.
+// The scripts call the complete subcommand to do the real work.
+var completionScripts = map[string]*template.Template{
+	"bash": template.Must(template.New("bash").Parse(bashCompletionScript)),
+	"zsh":  template.Must(template.New("zsh").Parse(zshCompletionScript)),
+	"fish": template.Must(template.New("fish").Parse(fishCompletionScript)),
+}
+
+func (o *completionCommandOptions) Usage() string {
+	return "bash|zsh|fish [--kset-name NAME] [--koff-name NAME]"
+}
+
+func (o *completionCommandOptions) Execute(args []string) error {
+	commandProcessor = completionProcessor
+	commandName = "completion"
+
+	switch len(args) {
+	case 0:
+		return fmt.Errorf("A shell name must be specified.")
+	case 1:
+		// Good
+	default:
+		return fmt.Errorf("Unrecognized positional argument provided after the shell name.")
+	}
+
+	if _, exists := completionScripts[args[0]]; !exists {
+		return fmt.Errorf("Shell \"%s\" isn't supported.  Use one of: bash, zsh, fish.", args[0])
+	}
+
+	return o.check()
+}
+
+func completionProcessor(positionalArgs []string) {
+	err := completionScripts[positionalArgs[0]].Execute(os.Stdout, &completionOptions)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error printing the completion script: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func init() {
+	_, err := parser.AddCommand("completion",
+		"Print a shell completion script",
+		"Prints a script that sets up command-line completion of the kset, koff, and kubectl "+
+			"commands for the bash, zsh, or fish shell.  Source its output from your shell "+
+			"initialization file.  The bash script also works with the bashcompinit emulation of zsh.  "+
+			"The --kset-name and --koff-name options name the shell functions, if they were given "+
+			"other names with the init subcommand.",
+		&completionOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
+
+const bashCompletionScript = `# kconfig completion for bash.  Source the output of "kconfig-util completion bash".
+
+# Completes the arguments of kset.  After a -n (--namespace), --user, --context, or --cluster
+# option, it completes namespace, user, context, or cluster names for the nickname being typed, or
+# for the current nickname if none is being typed.
+function _kconfig_cmpl {
+   local -i idx=0
+   local mode=""
+   case "$3" in
+      -n|--namespace) mode="--namespaces" ;;
+      --user) mode="--users" ;;
+      --context) mode="--contexts" ;;
+      --cluster) mode="--clusters" ;;
+   esac
+
+   local -a completions
+   if [[ "$3" == "--kubeconfig" || "$3" == "--prefer-file" || "$3" == "--output-file" || "$3" == "--certificate-authority" ]]; then
+      compopt -o filenames 2>/dev/null
+      completions=($(kconfig-util complete --files -- "$2"))
+   elif [[ "$2" == -* ]]; then
+      completions=($(kconfig-util complete --options -- "$2"))
+   elif [[ -n "$mode" ]]; then
+      local nickname=""
+      if [[ $COMP_CWORD -gt 2 && "${COMP_WORDS[1]}" != -* ]]; then
+         nickname="${COMP_WORDS[1]}"
+      fi
+      completions=($(kconfig-util complete $mode -- "$nickname" "$2"))
+   else
+      completions=($(kconfig-util complete -- "$2"))
+   fi
+
+   for name in "${completions[@]}"; do
+      COMPREPLY[$idx]="$name"
+      idx=$idx+1
+   done
+}
+
+# Completes the nickname after the -k (--kconfig) option of the kconfig kubectl program, and defers
+# to the kubectl completion function otherwise, if it's loaded.
+function _kconfig_kubectl_cmpl {
+   if [[ "$3" == "-k" || "$3" == "--kconfig" ]]; then
+      COMPREPLY=($(kconfig-util complete -- "$2"))
+   elif declare -F __start_kubectl >/dev/null; then
+      __start_kubectl "$@"
+   fi
+}
+
+complete -F _kconfig_cmpl {{.KsetName}}
+complete -W "" {{.KoffName}}
+complete -o default -F _kconfig_kubectl_cmpl kubectl
+`
+
+const zshCompletionScript = `# kconfig completion for zsh.  Source the output of "kconfig-util completion zsh" after running
+# compinit.
+
+# Offers the completions printed by "kconfig-util complete", in the "value:description" form used
+# by _describe.
+function _kconfig_describe {
+   local -a described
+   described=(${(f)"$(kconfig-util complete --format zsh "$@")"})
+   _describe -t kconfig 'kconfig value' described
+}
+
+# Completes the arguments of kset.
+function _kconfig_kset {
+   local mode=""
+   case "${words[CURRENT-1]}" in
+      -n|--namespace) mode="--namespaces" ;;
+      --user) mode="--users" ;;
+      --context) mode="--contexts" ;;
+      --cluster) mode="--clusters" ;;
+      --kubeconfig|--prefer-file|--output-file|--certificate-authority) _files; return ;;
+   esac
+
+   if [[ "$PREFIX" == -* ]]; then
+      _kconfig_describe --options -- "$PREFIX"
+   elif [[ -n "$mode" ]]; then
+      local nickname=""
+      if (( CURRENT > 3 )) && [[ "${words[2]}" != -* ]]; then
+         nickname="${words[2]}"
+      fi
+      _kconfig_describe $mode -- "$nickname" "$PREFIX"
+   else
+      _kconfig_describe -- "$PREFIX"
+   fi
+}
+
+# Completes the nickname after the -k (--kconfig) option of the kconfig kubectl program, and defers
+# to the kubectl completion function otherwise, if it's loaded.
+function _kconfig_kubectl {
+   case "${words[CURRENT-1]}" in
+      -k|--kconfig) _kconfig_describe -- "$PREFIX" ;;
+      *) (( $+functions[_kubectl] )) && _kubectl ;;
+   esac
+}
+
+compdef _kconfig_kset {{.KsetName}}
+compdef _nothing {{.KoffName}}
+compdef _kconfig_kubectl kubectl
+`
+
+const fishCompletionScript = `# kconfig completion for fish.  Source the output of "kconfig-util completion fish".
+
+# Completes the arguments of kset.
+function __kconfig_kset_complete
+    set -l tokens (commandline -opc)
+    set -l current (commandline -ct)
+    set -l mode
+    switch $tokens[-1]
+        case -n --namespace
+            set mode --namespaces
+        case --user
+            set mode --users
+        case --context
+            set mode --contexts
+        case --cluster
+            set mode --clusters
+        case --kubeconfig --prefer-file --output-file --certificate-authority
+            __fish_complete_path $current
+            return
+    end
+
+    if string match -q -- '-*' $current
+        kconfig-util complete --descriptions --options -- $current
+    else if test -n "$mode"
+        set -l nickname ""
+        if test (count $tokens) -gt 2; and not string match -q -- '-*' $tokens[2]
+            set nickname $tokens[2]
+        end
+        kconfig-util complete --descriptions $mode -- $nickname $current
+    else
+        kconfig-util complete --descriptions -- $current
+    end
+end
+
+# Says whether the word being completed is the nickname after the -k (--kconfig) option of the
+# kconfig kubectl program.
+function __kconfig_kubectl_needs_nickname
+    set -l tokens (commandline -opc)
+    contains -- $tokens[-1] -k --kconfig
+end
+
+complete -c {{.KsetName}} -f -a '(__kconfig_kset_complete)'
+complete -c {{.KoffName}} -f
+complete -c kubectl -n __kconfig_kubectl_needs_nickname -f -a '(kconfig-util complete --descriptions -- (commandline -ct))'
+`
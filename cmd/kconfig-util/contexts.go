@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type contextsCommandOptions struct {
+	KubeConfig string `long:"kubeconfig" value-name:"SEARCH-PATH" description:"List the contexts of this kubectl config search path, in the format of the KUBECONFIG environment variable, instead of a nickname's."`
+	Names      bool   `long:"names" description:"Only print the names of the contexts, one per line."`
+}
+
+var contextsOptions contextsCommandOptions
+
+func (o *contextsCommandOptions) Usage() string {
+	return "[--names] [nickname | --kubeconfig SEARCH-PATH]"
+}
+
+func (o *contextsCommandOptions) Execute(args []string) error {
+	commandProcessor = contextsProcessor
+	commandName = "contexts"
+
+	if len(args) > 1 {
+		return fmt.Errorf("Unrecognized positional arguments provided after the nickname.")
+	}
+	if len(args) > 0 && o.KubeConfig != "" {
+		return fmt.Errorf("A nickname can't be given with the --kubeconfig option.")
+	}
+
+	return nil
+}
+
+// contextsProcessor lists the contexts that kubectl sees after merging the kubectl config files in
+// the search path of the nickname, of the current kset environment's nickname if none is given, or
+// given by the --kubeconfig option.  The context the nickname selects, or the current context of
+// the search path, is marked.  A warning is printed for each context that's also defined in a file
+// later in the search path, since kubectl ignores those definitions.
+func contextsProcessor(positionalArgs []string) {
+	searchPath := contextsOptions.KubeConfig
+	var selectedContext string
+	if searchPath == "" {
+		var nickname string
+		if len(positionalArgs) > 0 {
+			nickname = config.ExpandNickname(positionalArgs[0])
+		} else {
+			nickname = config.KsetNickname(os.Getenv("_KCONFIG_KSET"))
+			if nickname == "" {
+				config.ExitWithError(config.NewCodedError(config.ErrorCodeUsage,
+					errors.New("A nickname or the --kubeconfig option must be specified when no kset environment is in effect.")))
+			}
+		}
+
+		kconfig := config.GetKconfig()
+		var err error
+		searchPath, err = kconfig.NicknameSearchPath(nickname)
+		if err != nil {
+			config.ExitWithError(err)
+		}
+		selectedContext = config.ResolveNickname(nickname).Options.Context
+	}
+
+	contexts, currentContext, err := config.ListKubeconfigContexts(searchPath)
+	if err != nil {
+		config.ExitWithError(err)
+	}
+	if selectedContext == "" {
+		selectedContext = currentContext
+	}
+
+	if contextsOptions.Names {
+		for _, context := range contexts {
+			fmt.Println(context.Name)
+		}
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "CURRENT\tNAME\tCLUSTER\tNAMESPACE\tUSER\tFILE")
+	for _, context := range contexts {
+		marker := ""
+		if context.Name == selectedContext {
+			marker = "*"
+		}
+		fmt.Fprintln(writer, strings.Join([]string{marker, context.Name, context.Cluster, context.Namespace, context.User, context.File}, "\t"))
+	}
+	writer.Flush()
+
+	for _, context := range contexts {
+		for _, filename := range context.ShadowedFiles {
+			fmt.Fprintf(os.Stderr, "Warning: Context \"%s\" is also defined in \"%s\", but kubectl uses the one in \"%s\".\n", context.Name, filename, context.File)
+		}
+	}
+}
+
+func init() {
+	_, err := parser.AddCommand("contexts",
+		"List the contexts of a nickname's kubectl config files",
+		"Lists the contexts that kubectl sees after merging the kubectl config files in the search "+
+			"path of the nickname, of the current kset environment's nickname if none is given, or "+
+			"given by the --kubeconfig option, with their clusters, namespaces, and users, and the "+
+			"file each one comes from.  The context the nickname selects is marked with \"*\".  When "+
+			"several files define a context with the same name, kubectl uses the first one, so a "+
+			"warning names the others.  With --names, only the names are printed, for scripts and "+
+			"shell completion.",
+		&contextsOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
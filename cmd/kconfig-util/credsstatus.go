@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type credsStatusCommandOptions struct {
+	NoInteractive bool `long:"no-interactive" description:"Don't let the exec credential plugin prompt for a login."`
+}
+
+var credsStatusOptions credsStatusCommandOptions
+
+func (o *credsStatusCommandOptions) Usage() string {
+	return "[--no-interactive]"
+}
+
+func (o *credsStatusCommandOptions) Execute(args []string) error {
+	commandProcessor = credsStatusProcessor
+	commandName = "creds-status"
+
+	if len(args) > 0 {
+		return fmt.Errorf("Unrecognized positional arguments provided.")
+	}
+
+	return nil
+}
+
+// credsStatusProcessor reports on the credentials of the user of the current kubectl context, as
+// selected by the KUBECONFIG environment variable that kset sets.  If the user gets its credentials
+// from an exec credential plugin, the plugin is run to find out when they expire.
+func credsStatusProcessor(positionalArgs []string) {
+	kubeconfig := config.ReadKubeConfig()
+	if kubeconfig.CurrentContext == "" {
+		fmt.Fprintln(os.Stderr, "There's no current kubectl context.")
+		os.Exit(1)
+	}
+
+	userName, execConfig := config.ExecCredentialUser(kubeconfig)
+	fmt.Printf("Context: %s\n", kubeconfig.CurrentContext)
+	fmt.Printf("User:    %s\n", userName)
+	if execConfig == nil {
+		fmt.Println("Plugin:  none (the user doesn't use an exec credential plugin)")
+		return
+	}
+
+	status, err := config.GetExecCredentialStatus(kubeconfig, !credsStatusOptions.NoInteractive)
+	if err != nil {
+		config.ExitWithError(err)
+	}
+
+	fmt.Printf("Plugin:  %s\n", status.Command)
+	if status.Token {
+		fmt.Println("Type:    token")
+	} else {
+		fmt.Println("Type:    client certificate")
+	}
+	fmt.Printf("Expires: %s\n", describeCredentialExpiration(status))
+}
+
+// prewarmCredentials runs the exec credential plugin, if any, of the user selected by a new kset
+// environment, and reports on standard error when its credentials expire.  This moves any login
+// prompt, and the discovery of expired credentials, from the first kubectl command to kset.
+// Failures are only warnings.
+func prewarmCredentials(createResults *config.CreateConfigResults) {
+	status, err := config.GetExecCredentialStatus(createResults.MergedConfig(), true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		return
+	}
+	if status == nil {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Credentials for user \"%s\": %s\n", status.User, describeCredentialExpiration(status))
+}
+
+// describeCredentialExpiration describes when the credentials expire, relative to now.
+func describeCredentialExpiration(status *config.CredentialStatus) string {
+	if status.Expiration.IsZero() {
+		return "no expiration reported by the plugin"
+	}
+
+	at := status.Expiration.Local().Format("2006-01-02 15:04:05")
+	remaining := time.Until(status.Expiration).Round(time.Second)
+	if remaining <= 0 {
+		return fmt.Sprintf("expired %s ago (at %s)", -remaining, at)
+	}
+	return fmt.Sprintf("expires in %s (at %s)", remaining, at)
+}
+
+func init() {
+	_, err := parser.AddCommand("creds-status",
+		"Show when the credentials of the current environment expire",
+		"Reports on the credentials of the user of the current kubectl context.  If the user gets "+
+			"its credentials from an exec credential plugin (like \"aws eks get-token\" or "+
+			"\"kubectl oidc-login\"), the plugin is run once, the way kubectl would run it, to find out "+
+			"when its credentials expire.  Plugins that cache credentials refresh them if needed, "+
+			"which might involve a login prompt.",
+		&credsStatusOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
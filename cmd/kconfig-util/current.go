@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type currentCommandOptions struct {
+}
+
+var currentOptions currentCommandOptions
+
+func (o *currentCommandOptions) Usage() string {
+	return ""
+}
+
+func (o *currentCommandOptions) Execute(args []string) error {
+	commandProcessor = currentProcessor
+	commandName = "current"
+
+	if len(args) > 0 {
+		return fmt.Errorf("Unrecognized positional arguments provided.")
+	}
+
+	return nil
+}
+
+// currentProcessor prints the nickname of the current kset environment.  It's meant for shell
+// prompts and scripts, so it only looks at the _KCONFIG_KSET environment variable, without reading
+// the kconfig.yaml file or any kubectl configuration.  If no kset environment is in effect, it
+// prints nothing and exits with a nonzero status.
+func currentProcessor(positionalArgs []string) {
+	nickname := config.KsetNickname(os.Getenv("_KCONFIG_KSET"))
+	if nickname == "" {
+		os.Exit(1)
+	}
+
+	fmt.Println(nickname)
+}
+
+func init() {
+	_, err := parser.AddCommand("current",
+		"Print the nickname of the current kset environment",
+		"Prints the nickname of the current kset environment, or nothing, with a nonzero exit "+
+			"status, if there isn't one.  It doesn't read any configuration files, so it's fast "+
+			"enough to use in a shell prompt.",
+		&currentOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type daemonCommandOptions struct {
+	Status bool `long:"status" description:"Print whether the daemon is running, and exit with status 1 if it isn't."`
+	Stop   bool `long:"stop" description:"Stop the running daemon."`
+}
+
+var daemonOptions daemonCommandOptions
+
+func (o *daemonCommandOptions) Usage() string {
+	return "[--status | --stop]"
+}
+
+func (o *daemonCommandOptions) Execute(args []string) error {
+	commandProcessor = daemonProcessor
+	commandName = "daemon"
+
+	if len(args) != 0 {
+		return fmt.Errorf("No arguments are expected.")
+	}
+
+	if o.Status && o.Stop {
+		return fmt.Errorf("The --status and --stop options can't be used together.")
+	}
+
+	return nil
+}
+
+// daemonProcessor runs the daemon in the foreground until it's interrupted or stopped with --stop,
+// or, with --status or --stop, reports on or stops the one that's running.
+func daemonProcessor(positionalArgs []string) {
+	if daemonOptions.Status || daemonOptions.Stop {
+		status, err := config.DaemonStatus()
+		if err != nil {
+			fmt.Println("The kconfig daemon isn't running.")
+			os.Exit(1)
+		}
+		if daemonOptions.Status {
+			fmt.Printf("The kconfig daemon is running:  %s.\n", status)
+			return
+		}
+		err = config.StopDaemon()
+		if err != nil {
+			config.ExitWithError(err)
+		}
+		fmt.Println("Stopped the kconfig daemon.")
+		return
+	}
+
+	daemon, err := config.NewDaemon()
+	if err != nil {
+		config.ExitWithError(err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		<-signals
+		daemon.Close()
+	}()
+
+	fmt.Fprintf(os.Stderr, "The kconfig daemon is listening on \"%s\".\n", config.DaemonSocketFilename())
+	err = daemon.Serve()
+	if err != nil {
+		config.ExitWithError(err)
+	}
+}
+
+func init() {
+	_, err := parser.AddCommand("daemon",
+		"Keep kconfig.yaml and kubectl config files in memory",
+		"Runs until it's interrupted, keeping kconfig.yaml and the merged kubectl configurations of "+
+			"the search paths it's asked about in memory, and handing them to the kset, completion, "+
+			"and prompt commands over a Unix socket in the state directory, so they don't each have "+
+			"to read and merge the files.  The files are checked for changes on every request.  If "+
+			"the daemon isn't running, or with the --no-cache option, the files are read as usual.  "+
+			"With --status, prints whether it's running, and with --stop, stops it.",
+		&daemonOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
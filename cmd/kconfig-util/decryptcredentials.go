@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type decryptCredentialsCommandOptions struct {
+}
+
+var decryptCredentialsOptions decryptCredentialsCommandOptions
+
+func (o *decryptCredentialsCommandOptions) Usage() string {
+	return "credentials-file user"
+}
+
+func (o *decryptCredentialsCommandOptions) Execute(args []string) error {
+	commandProcessor = decryptCredentialsProcessor
+	commandName = "decrypt-credentials"
+
+	if len(args) != 2 {
+		return fmt.Errorf("A credentials file and a user name must be specified.")
+	}
+
+	return nil
+}
+
+// decryptCredentialsProcessor prints an ExecCredential holding the credentials of the user, as
+// decrypted from the credentials file.  kubectl runs it as an exec credential plugin for the users
+// of local kubectl config files written with the encrypt_credentials preference.
+func decryptCredentialsProcessor(positionalArgs []string) {
+	apiVersion, _ := getExecCredentialRequest()
+
+	stored, err := config.DecryptCredentials(positionalArgs[0], positionalArgs[1])
+	if err != nil {
+		config.ExitWithError(err)
+	}
+
+	response := execCredentialResponse{
+		APIVersion: apiVersion,
+		Kind:       "ExecCredential",
+		Status: execCredentialResponseStatus{
+			Token:                 stored.Token,
+			ClientCertificateData: string(stored.ClientCertificateData),
+			ClientKeyData:         string(stored.ClientKeyData),
+		},
+	}
+	printExecCredential(&response)
+}
+
+func init() {
+	_, err := parser.AddCommand("decrypt-credentials",
+		"Decrypt the credentials of a kubectl user for kubectl",
+		"Run by kubectl as an exec credential plugin for the users of the local kubectl config files "+
+			"that kconfig writes with the encrypt_credentials preference.  Prints the token or client "+
+			"certificate and key of the user, decrypted from the credentials file next to the local "+
+			"kubectl config file, with the age command or a key from the OS keychain.",
+		&decryptCredentialsOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type diffCommandOptions struct {
+	Current bool `long:"current" description:"Compare the nickname with the kset environment in effect, instead of with another nickname."`
+}
+
+var diffOptions diffCommandOptions
+
+func (o *diffCommandOptions) Usage() string {
+	return "nickname (other-nickname | --current)"
+}
+
+func (o *diffCommandOptions) Execute(args []string) error {
+	commandProcessor = diffProcessor
+	commandName = "diff"
+
+	if o.Current {
+		if len(args) != 1 {
+			return fmt.Errorf("A single nickname must be specified with the --current option.")
+		}
+	} else if len(args) != 2 {
+		return fmt.Errorf("Two nicknames, or a nickname and the --current option, must be specified.")
+	}
+
+	return nil
+}
+
+// environmentSettings are the settings of a kset environment that the diff subcommand compares.
+type environmentSettings struct {
+	label  string
+	values map[string]string
+}
+
+// diffFields are the names of the settings that the diff subcommand compares, in the order they're
+// printed.
+var diffFields = []string{"kubeconfig", "context", "server", "namespace", "user", "kubectl"}
+
+// diffProcessor prints the settings that differ between the kset environments of two nicknames, or
+// of a nickname and the kset environment in effect:  the kubectl config search path, the context,
+// the API server, the namespace, the user, and the kubectl executable.  Like diff(1), it exits with
+// a status of 1 if there are any differences.
+func diffProcessor(positionalArgs []string) {
+	first := nicknameSettings(config.ExpandNickname(positionalArgs[0]))
+	var second *environmentSettings
+	if diffOptions.Current {
+		second = currentSettings()
+	} else {
+		second = nicknameSettings(config.ExpandNickname(positionalArgs[1]))
+	}
+
+	var same []string
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.Join([]string{"", first.label, second.label}, "\t"))
+	differences := 0
+	for _, field := range diffFields {
+		if first.values[field] == second.values[field] {
+			same = append(same, field)
+			continue
+		}
+		differences++
+		fmt.Fprintln(writer, strings.Join([]string{field, diffValue(first.values[field]), diffValue(second.values[field])}, "\t"))
+	}
+
+	if differences == 0 {
+		fmt.Println("No differences.")
+		return
+	}
+	writer.Flush()
+	if len(same) > 0 {
+		fmt.Printf("Same: %s\n", strings.Join(same, ", "))
+	}
+	os.Exit(1)
+}
+
+// nicknameSettings returns the settings of the kset environment that kset would set up for the
+// nickname.
+func nicknameSettings(nickname string) *environmentSettings {
+	createResults, err := config.GetKconfig().ResolveLocalKubectlConfig(nickname, nil)
+	if err != nil {
+		config.ExitWithError(err)
+	}
+	return resultsSettings(nickname, createResults)
+}
+
+// currentSettings returns the settings of the kset environment in effect, resolving its nickname
+// and override options again.  The namespace is the one currently set in the session-local kubectl
+// config file, if there is one, in case another tool changed it, and the kubectl executable is the
+// one kset recorded.
+func currentSettings() *environmentSettings {
+	ksetEnvValue := os.Getenv("_KCONFIG_KSET")
+	if config.KsetNickname(ksetEnvValue) == "" {
+		config.ExitWithError(config.NewCodedError(config.ErrorCodeUsage,
+			errors.New("The --current option can't be used when no kset environment is in effect.")))
+	}
+	ksetArgs := config.SplitKsetArgs(ksetEnvValue)
+	nickname := ksetArgs[0]
+	kconfigOptions, _, err := config.ParseKsetArgs(ksetArgs)
+	if err != nil {
+		config.ExitWithError(err)
+	}
+	createResults, err := config.GetKconfig().ResolveLocalKubectlConfig(nickname, kconfigOptions)
+	if err != nil {
+		config.ExitWithError(err)
+	}
+
+	settings := resultsSettings(fmt.Sprintf("current (%s)", strings.Join(ksetArgs, " ")), createResults)
+	kubeconfigEnvVar := os.Getenv("KUBECONFIG")
+	if config.GetExistingSessionLocalFilename(kubeconfigEnvVar) != "" {
+		namespace, err := config.CurrentNamespace(kubeconfigEnvVar)
+		if err != nil {
+			config.ExitWithError(err)
+		}
+		settings.values["namespace"] = namespace
+	}
+	if kubectl := os.Getenv("_KCONFIG_KUBECTL"); kubectl != "" {
+		settings.values["kubectl"] = kubectl
+	}
+	return settings
+}
+
+// resultsSettings returns the settings of the kset environment described by the results.
+func resultsSettings(label string, createResults *config.CreateConfigResults) *environmentSettings {
+	return &environmentSettings{
+		label: label,
+		values: map[string]string{
+			"kubeconfig": createResults.SearchPath,
+			"context":    createResults.BaseContext,
+			"server":     createResults.ServerURL(),
+			"namespace":  createResults.ContextNamespace,
+			"user":       createResults.UserName(),
+			"kubectl":    createResults.KubectlExecutable,
+		},
+	}
+}
+
+// diffValue returns the value as it's printed by the diff subcommand.
+func diffValue(value string) string {
+	if value == "" {
+		return "(none)"
+	}
+	return value
+}
+
+func init() {
+	_, err := parser.AddCommand("diff",
+		"Show how the kset environments of two nicknames differ",
+		"Prints the settings that differ between the kset environments of two nicknames, or, with "+
+			"--current, between a nickname's and the kset environment in effect:  the kubectl config "+
+			"search path, the context, the API server, the namespace, the user, and the kubectl "+
+			"executable.  The settings that are the same are listed after them.  It helps answer what "+
+			"exactly changes before switching to a nickname.  Like diff(1), the exit status is 1 if "+
+			"there are differences.",
+		&diffOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
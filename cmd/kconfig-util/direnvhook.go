@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type direnvHookCommandOptions struct {
+	shellFunctionNames
+}
+
+var direnvHookOptions direnvHookCommandOptions
+
+func (o *direnvHookCommandOptions) Usage() string {
+	return "[--kset-name NAME] [--koff-name NAME]"
+}
+
+func (o *direnvHookCommandOptions) Execute(args []string) error {
+	commandProcessor = direnvHookProcessor
+	commandName = "direnv-hook"
+
+	if len(args) > 0 {
+		return fmt.Errorf("Unrecognized positional arguments provided.")
+	}
+
+	return o.check()
+}
+
+// direnvHookProcessor is run from a shell prompt hook.  It prints shell commands that switch to the
+// nickname named by the ".kconfig" file governing the current directory, if that's different than
+// the one last applied by the hook.  The name of the ".kconfig" file that was last applied is kept
+// in the _KCONFIG_DIRKSET environment variable.
+func direnvHookProcessor(positionalArgs []string) {
+	filename, directoryArgs := config.FindDirectoryKconfig()
+	previousFilename := os.Getenv("_KCONFIG_DIRKSET")
+	if filename == previousFilename {
+		return
+	}
+
+	if filename != "" {
+		// Record the file before running kset, so that a failing kset isn't retried at every prompt.
+		fmt.Printf("export _KCONFIG_DIRKSET=%s\n", shellQuote(filename))
+		fmt.Printf("%s %s\n", direnvHookOptions.KsetName, shellQuoteArgs(directoryArgs))
+		return
+	}
+
+	// We've left the directory tree governed by the previous ".kconfig" file.  Turn off the kset
+	// environment, but only if it's still the one the hook selected.
+	fmt.Println("unset _KCONFIG_DIRKSET")
+	previousArgs, err := config.ReadDirectoryKconfig(previousFilename)
+	if err != nil {
+		return
+	}
+
+	if config.KsetNickname(os.Getenv("_KCONFIG_KSET")) == previousArgs[0] {
+		fmt.Println(direnvHookOptions.KoffName)
+	}
+}
+
+// shellQuote quotes a string so it's interpreted literally by the shell.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// shellQuoteArgs quotes each argument with shellQuote() and joins them with blanks.
+func shellQuoteArgs(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	return strings.Join(quoted, " ")
+}
+
+func init() {
+	_, err := parser.AddCommand("direnv-hook",
+		"Print shell commands for the directory prompt hook",
+		"Called by the _kconfig_direnv_hook shell function, typically before each shell prompt, to "+
+			"switch to the nickname named by a \".kconfig\" file in the current directory or its "+
+			"ancestors when entering the directory tree, and to turn it off when leaving it.",
+		&direnvHookOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/jphx/kconfig/config"
+)
+
+// defaultContainerKubeconfig is where the kubectl config file is mounted in the container if the
+// --container-path option isn't given.
+const defaultContainerKubeconfig = "/kconfig/config"
+
+// plainShellWord matches the words that the shell doesn't split or expand, so they don't need to
+// be quoted.
+var plainShellWord = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+type dockerArgsCommandOptions struct {
+	config.KconfigOptions
+	ContainerPath string `long:"container-path" value-name:"PATH" description:"The path of the kubectl config file in the container.  If not specified, \"/kconfig/config\" is used."`
+	MountOptions  string `long:"mount-options" value-name:"OPTIONS" default:"ro" description:"The options of the volume mount, such as \"ro,z\" to relabel the file for SELinux."`
+}
+
+var dockerArgsOptions dockerArgsCommandOptions
+
+func (o *dockerArgsCommandOptions) Usage() string {
+	return "nickname [override-options] [--container-path PATH] [--mount-options OPTIONS]"
+}
+
+func (o *dockerArgsCommandOptions) Execute(args []string) error {
+	commandProcessor = dockerArgsProcessor
+	commandName = "docker-args"
+
+	if len(args) != 1 {
+		return fmt.Errorf("A single nickname must be specified.")
+	}
+	if o.ContainerPath != "" && !path.IsAbs(o.ContainerPath) {
+		return fmt.Errorf("The --container-path option must name an absolute path.")
+	}
+
+	return nil
+}
+
+// dockerArgsProcessor prints the options of "docker run" or "podman run" that let kubectl, helm,
+// and the like in the container use the nickname, possibly modified by override options.  The
+// paths in the search path and the session-local file mean nothing in the container, so a
+// self-contained kubectl config file is written to the state directory, mounted at a fixed path in
+// the container, and named by KUBECONFIG there.  Warnings are printed for things that won't work
+// the same in the container, like a user that gets its credentials by running a command, or an API
+// server on the loopback interface.
+func dockerArgsProcessor(positionalArgs []string) {
+	nickname := config.ExpandNickname(positionalArgs[0])
+	createResults, err := config.GetKconfig().ResolveLocalKubectlConfig(nickname, &dockerArgsOptions.KconfigOptions)
+	if err == nil && !createResults.SelfContained {
+		err = createResults.MakeSelfContained()
+	}
+	if err != nil {
+		config.ExitWithError(err)
+	}
+
+	hostFilename, err := createResults.WriteContainerConfigFile(nickname)
+	if err != nil {
+		config.ExitWithError(err)
+	}
+
+	content := createResults.ConfigContent
+	if context := content.Contexts[content.CurrentContext]; context != nil {
+		warnAboutCredentialPlugins(content.AuthInfos[context.AuthInfo])
+	}
+	if serverURL, err := url.Parse(createResults.ServerURL()); err == nil && isLoopbackHost(serverURL.Hostname()) {
+		fmt.Fprintf(os.Stderr, "Warning: The API server \"%s\" is on the loopback interface, which is the container's own unless it's run with \"--network host\".\n", serverURL.Host)
+	}
+
+	containerFilename := dockerArgsOptions.ContainerPath
+	if containerFilename == "" {
+		containerFilename = defaultContainerKubeconfig
+	}
+	volume := hostFilename + ":" + containerFilename
+	if dockerArgsOptions.MountOptions != "" {
+		volume += ":" + dockerArgsOptions.MountOptions
+	}
+
+	args := []string{"-v", volume, "-e", "KUBECONFIG=" + containerFilename}
+	if createResults.TeleportProxyEnvVar != "" {
+		args = append(args, "-e", "TELEPORT_PROXY="+createResults.TeleportProxyEnvVar)
+	}
+	words := make([]string, 0, len(args))
+	for _, arg := range args {
+		if !plainShellWord.MatchString(arg) {
+			arg = shellQuote(arg)
+		}
+		words = append(words, arg)
+	}
+	fmt.Println(strings.Join(words, " "))
+}
+
+// isLoopbackHost says whether the host name or IP address refers to the loopback interface.
+func isLoopbackHost(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func init() {
+	_, err := parser.AddCommand("docker-args",
+		"Print the docker or podman run options to use a nickname in a container",
+		"Prints the options of \"docker run\" or \"podman run\" that let kubectl, helm, and the like "+
+			"in the container use the nickname, possibly modified by override options, like "+
+			"\"docker run $(kconfig-util docker-args dev) image kubectl get pods\".  Since the kubectl "+
+			"config files on this host aren't in the container, a self-contained kubectl config file, "+
+			"like the --self-contained option creates, is written to the state directory and mounted "+
+			"at the path given by --container-path, \"/kconfig/config\" by default, which KUBECONFIG "+
+			"names.  The file is replaced each time the command is run for the nickname.  Only the "+
+			"user can read it, so the container must run as the same user, or as root in a rootless "+
+			"podman container.  Any option with characters that the shell would split or expand is "+
+			"quoted, so the output must be given to eval in that case.",
+		&dockerArgsOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/google/shlex"
+	"golang.org/x/term"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type editCommandOptions struct{}
+
+var editOptions editCommandOptions
+
+// newKconfigFileContents is what the editor starts with when there's no kconfig.yaml file yet.
+var newKconfigFileContents = fmt.Sprintf("apiVersion: %s\nkind: %s\npreferences: {}\nnicknames: {}\n", config.KconfigAPIVersion, config.KconfigKind)
+
+func (o *editCommandOptions) Usage() string {
+	return ""
+}
+
+func (o *editCommandOptions) Execute(args []string) error {
+	commandProcessor = editProcessor
+	commandName = "edit"
+
+	if len(args) != 0 {
+		return fmt.Errorf("No arguments are expected.")
+	}
+
+	return nil
+}
+
+// editProcessor opens a copy of the kconfig.yaml file in the user's editor.  When the editor exits,
+// the copy is checked, and it replaces the file only if it has no problems.  Otherwise, the user
+// is asked whether to edit it again, or if there's no terminal to ask with, the copy is left for
+// them to fix.  Like "kubectl edit", nothing is changed if the copy isn't.
+func editProcessor(positionalArgs []string) {
+	filename := config.DefaultKconfigFilename()
+	original, err := os.ReadFile(filename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		config.ExitWithError(err)
+	}
+	initial := original
+	if original == nil {
+		initial = []byte(newKconfigFileContents)
+	}
+
+	editFile, err := os.CreateTemp("", "kconfig-*.yaml")
+	if err == nil {
+		_, err = editFile.Write(initial)
+		closeErr := editFile.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		config.ExitWithError(fmt.Errorf("Unable to create the file to edit: %v", err))
+	}
+	editFilename := editFile.Name()
+
+	for {
+		err = runEditor(editFilename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintf(os.Stderr, "The edited file is kept in \"%s\".\n", editFilename)
+			os.Exit(1)
+		}
+
+		edited, err := os.ReadFile(editFilename)
+		if err != nil {
+			config.ExitWithError(err)
+		}
+		if bytes.Equal(edited, initial) {
+			os.Remove(editFilename)
+			fmt.Println("Edit cancelled, no changes made.")
+			return
+		}
+
+		problems := config.ValidateKconfig(edited)
+		if len(problems) == 0 {
+			err = config.ReplaceKconfigFile(filename, original, edited)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				fmt.Fprintf(os.Stderr, "The edited file is kept in \"%s\".\n", editFilename)
+				os.Exit(1)
+			}
+			os.Remove(editFilename)
+			config.ForgetKconfig()
+			err = config.RemoveNicknameKubectlConfigFiles()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: Unable to remove the kubectl config files of the nicknames: %v\n", err)
+			}
+			fmt.Printf("Updated \"%s\".\n", filename)
+			return
+		}
+
+		fmt.Fprintln(os.Stderr, "The edited file has problems:")
+		for _, problem := range problems {
+			fmt.Fprintf(os.Stderr, "  %s\n", problem)
+		}
+		if !askToEditAgain() {
+			fmt.Fprintf(os.Stderr, "The file \"%s\" wasn't changed.  The edited file is kept in \"%s\".\n", filename, editFilename)
+			os.Exit(1)
+		}
+	}
+}
+
+// runEditor runs the editor named by the VISUAL or EDITOR environment variable, or vi, on the
+// file.  The variables can include arguments, like "code --wait".
+func runEditor(filename string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	editorArgs, err := shlex.Split(editor)
+	if err != nil || len(editorArgs) == 0 {
+		return fmt.Errorf("Unable to parse the editor command \"%s\": %v", editor, err)
+	}
+
+	cmd := exec.Command(editorArgs[0], append(editorArgs[1:], filename)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("The editor \"%s\" failed: %v", editor, err)
+	}
+	return nil
+}
+
+// askToEditAgain asks the user on the terminal whether to edit the file again.  It returns false if
+// standard input isn't a terminal.
+func askToEditAgain() bool {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false
+	}
+	fmt.Fprint(os.Stderr, "Edit it again? [Y/n] ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "" || answer == "y" || answer == "yes"
+}
+
+func init() {
+	_, err := parser.AddCommand("edit",
+		"Edit the kconfig.yaml file",
+		"Opens a copy of the kconfig.yaml file in the editor named by the VISUAL or EDITOR environment "+
+			"variable, or vi.  When the editor exits, the copy is checked for YAML that can't be "+
+			"parsed, unrecognized entries like misspelled preferences, and nickname definitions that "+
+			"can't be resolved.  The file is only replaced if there are no problems.  Otherwise, "+
+			"you're asked whether to edit it again, and if you don't, the copy is kept for you to fix.",
+		&editOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
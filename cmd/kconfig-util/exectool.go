@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/google/shlex"
+
+	"github.com/jphx/kconfig/config"
+)
+
+// defaultExecTool is the command that exec-tool runs if neither the --tool option nor the exec_tool
+// preference names one.
+const defaultExecTool = "k9s"
+
+type execToolCommandOptions struct {
+	config.KconfigOptions
+	Tool string `long:"tool" value-name:"COMMAND" description:"The command to run, which can include arguments.  If not specified, the exec_tool preference is used, or \"k9s\"."`
+}
+
+var execToolOptions execToolCommandOptions
+
+func (o *execToolCommandOptions) Usage() string {
+	return "[--tool COMMAND] nickname [override-options] [-- tool-arguments...]"
+}
+
+func (o *execToolCommandOptions) Execute(args []string) error {
+	commandProcessor = execToolProcessor
+	commandName = "exec-tool"
+
+	if len(args) == 0 {
+		return fmt.Errorf("A nickname must be specified.")
+	}
+
+	return nil
+}
+
+// execToolProcessor runs a tool, like k9s, against the nickname, possibly modified by override
+// options, without changing the kset environment of the shell.  The nickname's kubectl config file
+// is written to a private temporary directory that's removed when the tool exits, and the tool is
+// run with the environment kset would set up.  The process exits with the tool's exit status.
+func execToolProcessor(positionalArgs []string) {
+	nickname := config.ExpandNickname(positionalArgs[0])
+	kconfig := config.GetKconfig()
+
+	tool := execToolOptions.Tool
+	if tool == "" {
+		tool = kconfig.Preferences.ExecTool
+	}
+	if tool == "" {
+		tool = defaultExecTool
+	}
+	toolArgs, err := shlex.Split(tool)
+	if err == nil && len(toolArgs) == 0 {
+		err = errors.New("The command is empty.")
+	}
+	if err != nil {
+		config.ExitWithError(config.NewCodedError(config.ErrorCodeUsage, fmt.Errorf("Error parsing the tool command \"%s\": %v", tool, err)))
+	}
+	toolArgs = append(toolArgs, positionalArgs[1:]...)
+
+	createResults, err := kconfig.ResolveLocalKubectlConfig(nickname, &execToolOptions.KconfigOptions)
+	if err != nil {
+		config.ExitWithError(err)
+	}
+
+	workDir, err := os.MkdirTemp("", "kconfig-exec-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to create a temporary directory: %v\n", err)
+		os.Exit(1)
+	}
+	exitStatus := runWithNickname(toolArgs, nickname, &execToolOptions.KconfigOptions, createResults, workDir)
+	os.RemoveAll(workDir)
+	os.Exit(exitStatus)
+}
+
+// runWithNickname writes the kubectl config file for the results of resolving the nickname with the
+// override options to the work directory, and runs the command with the environment kset would set
+// up, returning its exit status.
+func runWithNickname(commandArgs []string, nickname string, kconfigOptions *config.KconfigOptions, createResults *config.CreateConfigResults, workDir string) int {
+	err := config.WriteLocalKubectlConfigFile(filepath.Join(workDir, "config.yaml"), createResults)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ksetArgs := append([]string{nickname}, kconfigOptions.Args()...)
+	cmd := exec.Command(commandArgs[0], commandArgs[1:]...)
+	cmd.Env = ksetEnvironment(createResults, createKsetArgs(ksetArgs))
+	return runAttached(cmd)
+}
+
+// runAttached runs the command with this process's standard input, output, and error, returning
+// its exit status, or 128 plus the signal number if it's killed by a signal.  It's used for
+// commands run in an environment that must be cleaned up after they exit.
+func runAttached(cmd *exec.Cmd) int {
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// The terminal sends interrupts to the command too, so they're only caught here to keep this
+	// process running long enough to clean up.  Other signals are forwarded.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	err := cmd.Start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to run \"%s\": %v\n", cmd.Args[0], err)
+		return 1
+	}
+
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGTERM || sig == syscall.SIGHUP {
+				_ = cmd.Process.Signal(sig)
+			}
+		}
+	}()
+
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return exitErr.ExitCode()
+	default:
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+}
+
+func init() {
+	_, err := parser.AddCommand("exec-tool",
+		"Run a tool like k9s against a nickname",
+		"Runs a tool, like k9s, against the nickname, possibly modified by override options, "+
+			"without changing the kset environment of the shell.  The nickname's kubectl config file "+
+			"is written to a temporary directory that's removed when the tool exits, and the tool is "+
+			"run with the environment kset would set up.  The tool is given by the --tool option, or "+
+			"the exec_tool preference, or is k9s.  Arguments after \"--\" are passed on to the tool.",
+		&execToolOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
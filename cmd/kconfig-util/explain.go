@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type explainCommandOptions struct {
+}
+
+var explainOptions explainCommandOptions
+
+func (o *explainCommandOptions) Usage() string {
+	return "nickname"
+}
+
+func (o *explainCommandOptions) Execute(args []string) error {
+	commandProcessor = explainProcessor
+	commandName = "explain"
+
+	switch len(args) {
+	case 0:
+		return fmt.Errorf("A kconfig nickname must be specified.")
+	case 1:
+		// Good
+	default:
+		return fmt.Errorf("Unrecognized positional argument provided after the kconfig nickname.")
+	}
+
+	return nil
+}
+
+func explainProcessor(positionalArgs []string) {
+	resolution := config.ResolveNickname(config.ExpandNickname(positionalArgs[0]))
+
+	for idx, nickname := range resolution.Chain {
+		fmt.Printf("%s%s: %s\n", strings.Repeat("  ", idx), nickname, resolution.Definitions[idx])
+	}
+
+	effective := append([]string{resolution.KubectlExecutable}, resolution.Options.Args()...)
+	fmt.Printf("Effective definition: %s\n", strings.Join(effective, " "))
+	if description := config.GetKconfig().NicknameDescription(resolution.Chain[0]); description != "" {
+		fmt.Printf("Description: %s\n", description)
+	}
+	if aliases := config.GetKconfig().AliasesOf(resolution.Chain[0]); len(aliases) > 0 {
+		fmt.Printf("Aliases: %s\n", strings.Join(aliases, ", "))
+	}
+	if len(resolution.Tags) > 0 {
+		fmt.Printf("Tags: %s\n", strings.Join(resolution.Tags, ", "))
+	}
+	if resolution.PluginPath != "" {
+		fmt.Printf("Plugin path: %s\n", resolution.PluginPath)
+	}
+	if resolution.KeychainToken != "" {
+		fmt.Printf("Keychain token: %s\n", resolution.KeychainToken)
+	}
+	if resolution.VaultPath != "" {
+		fmt.Printf("Vault path: %s\n", resolution.VaultPath)
+	}
+}
+
+func init() {
+	_, err := parser.AddCommand("explain",
+		"Show how a nickname's definition is resolved",
+		"Prints the definition of the nickname, followed by the definitions of any nicknames it "+
+			"extends with the --extends option, and then the effective definition that results, "+
+			"along with the nickname's description from the nickname_descriptions block, if it has one.",
+		&explainOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
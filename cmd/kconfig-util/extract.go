@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type extractCommandOptions struct {
+	config.KconfigOptions
+	OutputFile  string `long:"output-file" value-name:"FILE" description:"Write the kubectl config file to this path, instead of to standard output."`
+	ContextName string `long:"context-name" value-name:"NAME" description:"The name of the context, cluster, and user in the kubectl config file.  If not specified, the nickname is used."`
+}
+
+var extractOptions extractCommandOptions
+
+func (o *extractCommandOptions) Usage() string {
+	return "nickname [override-options] [--output-file FILE] [--context-name NAME]"
+}
+
+func (o *extractCommandOptions) Execute(args []string) error {
+	commandProcessor = extractProcessor
+	commandName = "extract"
+
+	if len(args) != 1 {
+		return fmt.Errorf("A single nickname must be specified.")
+	}
+
+	return nil
+}
+
+// extractProcessor writes a self-contained kubectl config file for the nickname, possibly modified
+// by override options, to standard output or the file named by --output-file.  It has just the
+// nickname's context, cluster, and user, with any certificates, keys, and tokens they refer to
+// embedded, so it can be used where the kubectl config files in the search path aren't available.
+// A warning is printed if the user gets its credentials by running a command, since the command
+// has to be available wherever the file is used.
+func extractProcessor(positionalArgs []string) {
+	nickname := config.ExpandNickname(positionalArgs[0])
+	createResults, err := config.GetKconfig().ResolveLocalKubectlConfig(nickname, &extractOptions.KconfigOptions)
+	if err != nil {
+		config.ExitWithError(err)
+	}
+
+	contextName := extractOptions.ContextName
+	if contextName == "" {
+		contextName = nickname
+	}
+	standalone, err := createResults.StandaloneConfig(contextName)
+	if err != nil {
+		config.ExitWithError(err)
+	}
+
+	warnAboutCredentialPlugins(standalone.AuthInfos[contextName])
+
+	if extractOptions.OutputFile != "" {
+		err = createResults.WriteStandaloneConfigFile(extractOptions.OutputFile, standalone)
+		if err != nil {
+			config.ExitWithError(err)
+		}
+		return
+	}
+
+	content, err := clientcmd.Write(*standalone)
+	if err != nil {
+		config.ExitWithError(fmt.Errorf("Error formatting the kubectl configuration: %v", err))
+	}
+	os.Stdout.Write(content)
+}
+
+// warnAboutCredentialPlugins prints a warning if the user, which can be nil, gets its credentials
+// by running a command or from an auth provider, since a kubectl config file that's used somewhere
+// else only works if that's available there too.
+func warnAboutCredentialPlugins(user *clientcmdapi.AuthInfo) {
+	if user == nil {
+		return
+	}
+	if user.Exec != nil {
+		fmt.Fprintf(os.Stderr, "Warning: The user gets its credentials by running \"%s\", which must be available wherever the file is used.\n", user.Exec.Command)
+	}
+	if user.AuthProvider != nil {
+		fmt.Fprintf(os.Stderr, "Warning: The user gets its credentials from the \"%s\" auth provider, which must be available wherever the file is used.\n", user.AuthProvider.Name)
+	}
+}
+
+func init() {
+	_, err := parser.AddCommand("extract",
+		"Write a self-contained kubectl config file for a nickname",
+		"Writes a kubectl config file for the nickname, possibly modified by override options, to "+
+			"standard output or the file named by --output-file.  Unlike the session-local file that "+
+			"kset writes, which refers to the kubectl config files in the search path, it holds just "+
+			"the nickname's context, cluster, and user, with the certificates, keys, and tokens they "+
+			"refer to embedded, so it can be handed to a CI system, a container, or a colleague.  "+
+			"The context, cluster, and user are named after the nickname, or the --context-name "+
+			"option.  The file holds credentials, so it's only readable by you.",
+		&extractOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type featuresCommandOptions struct {
+}
+
+var featuresOptions featuresCommandOptions
+
+func (o *featuresCommandOptions) Usage() string {
+	return ""
+}
+
+func (o *featuresCommandOptions) Execute(args []string) error {
+	commandProcessor = featuresProcessor
+	commandName = "features"
+
+	if len(args) > 0 {
+		return fmt.Errorf("Unrecognized positional arguments provided.")
+	}
+
+	return nil
+}
+
+func featuresProcessor(positionalArgs []string) {
+	if len(config.KnownFeatures) == 0 {
+		fmt.Println("No optional features are available in this version of kconfig.")
+	}
+
+	for _, feature := range config.KnownFeatures {
+		enabled, source, err := config.GetFeatureSetting(feature.Name)
+		if err != nil {
+			config.ExitWithError(err)
+		}
+		state := "disabled"
+		if enabled {
+			state = "enabled"
+		}
+		fmt.Printf("%s: %s (%s)\n    %s\n", feature.Name, state, source, feature.Description)
+	}
+
+	for _, name := range config.GetUnknownFeatures() {
+		fmt.Printf("%s: unknown to this version of kconfig\n", name)
+	}
+}
+
+func init() {
+	_, err := parser.AddCommand("features",
+		"List optional features and whether they're enabled",
+		"Lists the optional, behavior-changing features known to this version of kconfig, and "+
+			"whether each is enabled by the \"features\" preference or the KCONFIG_FEATURES "+
+			"environment variable.",
+		&featuresOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type fetchKubectlCommandOptions struct {
+	Force bool `long:"force" description:"Download the version even if it's already installed."`
+}
+
+var fetchKubectlOptions fetchKubectlCommandOptions
+
+func (o *fetchKubectlCommandOptions) Usage() string {
+	return "[--force] VERSION"
+}
+
+func (o *fetchKubectlCommandOptions) Execute(args []string) error {
+	commandProcessor = fetchKubectlProcessor
+	commandName = "fetch-kubectl"
+
+	if len(args) != 1 {
+		return fmt.Errorf("A single kubectl version must be specified.")
+	}
+
+	return nil
+}
+
+// fetchKubectlProcessor downloads a version of kubectl into the kubectl bin directory, where
+// nickname definitions can name it like "kubectl@1.28".
+func fetchKubectlProcessor(positionalArgs []string) {
+	version := positionalArgs[0]
+	kconfig := config.GetKconfig()
+	filename, err := kconfig.ManagedKubectlFilename(version)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if _, err := os.Stat(filename); err == nil && !fetchKubectlOptions.Force {
+		fmt.Printf("kubectl %s is already installed as \"%s\".\n", version, filename)
+		return
+	}
+
+	release, filename, err := kconfig.FetchKubectl(version)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Installed kubectl %s as \"%s\".\n", release, filename)
+}
+
+func init() {
+	_, err := parser.AddCommand("fetch-kubectl",
+		"Download a version of kubectl",
+		"Downloads an official kubectl release for this host's operating system and architecture, "+
+			"verifies it with its published SHA-256 checksum, and installs it in the kubectl bin "+
+			"directory (the kubectl_bin_directory preference, or ~/.kube/kubectl-bins).  The "+
+			"version is a minor version, like 1.28, for its latest patch release, or a release, "+
+			"like 1.28.3.  Nickname definitions can then name the executable by version, like "+
+			"\"kubectl@1.28\".  A version that's already installed isn't downloaded again unless "+
+			"--force is given.",
+		&fetchKubectlOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
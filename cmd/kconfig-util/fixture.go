@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/jphx/kconfig/config"
+)
+
+const (
+	fixtureKubeconfigFilename = "kubeconfig.yaml"
+	fixtureKconfigFilename    = "kconfig.yaml"
+)
+
+type fixtureCommandOptions struct {
+	Contexts  int    `long:"contexts" value-name:"N" default:"3" description:"The number of synthetic clusters, users, and contexts to generate.  A nickname is generated for each."`
+	OutputDir string `long:"output-dir" value-name:"DIR" default:"." description:"The directory in which to write the kubeconfig.yaml and kconfig.yaml files."`
+	Force     bool   `long:"force" description:"Replace the files if they already exist."`
+}
+
+var fixtureOptions fixtureCommandOptions
+
+func (o *fixtureCommandOptions) Usage() string {
+	return "[--contexts N] [--output-dir DIR]"
+}
+
+func (o *fixtureCommandOptions) Execute(args []string) error {
+	commandProcessor = fixtureProcessor
+	commandName = "fixture"
+
+	if len(args) > 0 {
+		return fmt.Errorf("Unrecognized positional arguments provided.")
+	}
+
+	if o.Contexts < 1 {
+		return fmt.Errorf("The number of contexts must be at least 1.")
+	}
+
+	return nil
+}
+
+func fixtureProcessor(positionalArgs []string) {
+	outputDir, err := filepath.Abs(fixtureOptions.OutputDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to determine the absolute path of \"%s\": %v\n", fixtureOptions.OutputDir, err)
+		os.Exit(1)
+	}
+
+	kubeconfigFilename := filepath.Join(outputDir, fixtureKubeconfigFilename)
+	kconfigFilename := filepath.Join(outputDir, fixtureKconfigFilename)
+	if !fixtureOptions.Force {
+		for _, filename := range []string{kubeconfigFilename, kconfigFilename} {
+			_, err := os.Stat(filename)
+			if !errors.Is(err, os.ErrNotExist) {
+				fmt.Fprintf(os.Stderr, "File \"%s\" already exists.  Use --force to replace it.\n", filename)
+				os.Exit(1)
+			}
+		}
+	}
+
+	err = os.MkdirAll(outputDir, os.ModePerm)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to create directory \"%s\": %v\n", outputDir, err)
+		os.Exit(1)
+	}
+
+	kubeconfig, kconfig := generateFixture(fixtureOptions.Contexts, kubeconfigFilename)
+
+	err = clientcmd.WriteToFile(*kubeconfig, kubeconfigFilename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing kubectl config file \"%s\": %v\n", kubeconfigFilename, err)
+		os.Exit(1)
+	}
+	fmt.Println(kubeconfigFilename)
+
+	content, err := yaml.Marshal(kconfig)
+	if err == nil {
+		err = os.WriteFile(kconfigFilename, content, 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing kconfig file \"%s\": %v\n", kconfigFilename, err)
+		os.Exit(1)
+	}
+	fmt.Println(kconfigFilename)
+}
+
+// generateFixture creates a kubectl configuration with the requested number of fake clusters,
+// users, and contexts, along with a kconfig configuration that defines a nickname for each context.
+// Every third nickname also overrides the namespace, so both kinds of session-local file get
+// exercised.
+func generateFixture(count int, kubeconfigFilename string) (*clientcmdapi.Config, *config.Kconfig) {
+	width := len(fmt.Sprint(count))
+	kubeconfig := clientcmdapi.NewConfig()
+	kconfig := &config.Kconfig{
+		Nicknames: make(map[string]string),
+	}
+
+	for i := 1; i <= count; i++ {
+		suffix := fmt.Sprintf("%0*d", width, i)
+		clusterName := "cluster-" + suffix
+		userName := "user-" + suffix
+		contextName := "context-" + suffix
+
+		cluster := clientcmdapi.NewCluster()
+		cluster.Server = fmt.Sprintf("https://%s.example.com:6443", clusterName)
+		kubeconfig.Clusters[clusterName] = cluster
+
+		user := clientcmdapi.NewAuthInfo()
+		user.Token = "token-" + suffix
+		kubeconfig.AuthInfos[userName] = user
+
+		context := clientcmdapi.NewContext()
+		context.Cluster = clusterName
+		context.AuthInfo = userName
+		context.Namespace = "namespace-" + suffix
+		kubeconfig.Contexts[contextName] = context
+
+		definition := fmt.Sprintf("--kubeconfig %s --context %s", config.QuoteDefinitionArg(kubeconfigFilename), contextName)
+		if i%3 == 0 {
+			definition += " -n alternate-" + suffix
+		}
+		kconfig.Nicknames["nick-"+suffix] = definition
+	}
+
+	kubeconfig.CurrentContext = fmt.Sprintf("context-%0*d", width, 1)
+	return kubeconfig, kconfig
+}
+
+func init() {
+	_, err := parser.AddCommand("fixture",
+		"Generate synthetic kubectl and kconfig configuration files",
+		"Generates a kubectl configuration file with fake clusters, users, and contexts, and a "+
+			"kconfig.yaml file defining a nickname for each context.  These are useful for demos, "+
+			"sandboxes, and testing kconfig with a large number of nicknames.  To try them, copy "+
+			"kconfig.yaml to ~/.kube, or generate the files there with --output-dir.",
+		&fixtureOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
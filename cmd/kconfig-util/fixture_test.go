@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFixture(t *testing.T) {
+	// Generate the files into a scratch home directory, so the nicknames can then be used.  Its name
+	// has characters that have to be quoted in the nickname definitions.
+	homeDir := filepath.Join(t.TempDir(), "it's home")
+	kubeDir := filepath.Join(homeDir, ".kube")
+	environmentVars := append(os.Environ(), fmt.Sprintf("HOME=%s", homeDir), "KUBECONFIG=")
+
+	cmd := exec.Command(kconfigUtilCommand, "fixture", "--contexts", "12", "--output-dir", kubeDir)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Env = environmentVars
+	err := cmd.Run()
+	if err != nil {
+		t.Fatalf("fixture command failed: %v\n%s", err, stderr.String())
+	}
+
+	// A second run should refuse to replace the files.
+	cmd = exec.Command(kconfigUtilCommand, "fixture", "--output-dir", kubeDir)
+	cmd.Env = environmentVars
+	err = cmd.Run()
+	if err == nil {
+		t.Error("fixture command should fail when the files already exist")
+	}
+
+	cmd = exec.Command(kconfigUtilCommand, "kset", "nick-09", "--print-only")
+	stderr.Reset()
+	cmd.Stderr = &stderr
+	cmd.Env = environmentVars
+	outputBytes, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("kset of generated nickname failed: %v\n%s", err, stderr.String())
+	}
+
+	output := string(outputBytes)
+	for _, expected := range []string{"cluster: cluster-09", "namespace: alternate-09", "user: user-09"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Output doesn't contain \"%s\".  It's:\n%s", expected, output)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type foreachCommandOptions struct {
+	Parallel int  `short:"p" long:"parallel" value-name:"N" default:"1" description:"Run the kubectl command for up to N nicknames at a time."`
+	NoPrefix bool `long:"no-prefix" description:"Don't prefix each line of output with the nickname."`
+
+	Tags []string `long:"tag" value-name:"TAG" description:"Only use the matching nicknames that have this tag.  If the option is repeated, the nicknames must have all the tags."`
+}
+
+var foreachOptions foreachCommandOptions
+
+func (o *foreachCommandOptions) Usage() string {
+	return "[--parallel N] [--no-prefix] [--tag TAG]... PATTERN -- kubectl-arguments..."
+}
+
+func (o *foreachCommandOptions) Execute(args []string) error {
+	commandProcessor = foreachProcessor
+	commandName = "foreach"
+
+	if len(args) < 2 {
+		return fmt.Errorf("A nickname pattern and the kubectl arguments must be specified.")
+	}
+
+	if o.Parallel < 1 {
+		return fmt.Errorf("The --parallel option must be at least 1.")
+	}
+
+	return nil
+}
+
+// foreachProcessor runs a kubectl command for each nickname that matches the pattern.  Each
+// nickname's kubectl config file is written to a private temporary directory that's removed
+// afterward, and the nickname's kubectl executable is run with the environment kset would set up.
+// The process exits with a failure status if the command fails for any nickname.
+func foreachProcessor(positionalArgs []string) {
+	pattern := positionalArgs[0]
+	kubectlArgs := positionalArgs[1:]
+
+	kconfig := config.GetKconfig()
+	matchingNicknames, err := kconfig.MatchNicknames(pattern)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	var nicknames []string
+	for _, nickname := range matchingNicknames {
+		if kconfig.NicknameHasTags(nickname, foreachOptions.Tags) {
+			nicknames = append(nicknames, nickname)
+		}
+	}
+	if len(nicknames) == 0 {
+		fmt.Fprintf(os.Stderr, "No nicknames%s match \"%s\".\n", describeTags(foreachOptions.Tags), pattern)
+		os.Exit(1)
+	}
+	err = checkForeachMutations(nicknames, kubectlArgs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	workDir, err := os.MkdirTemp("", "kconfig-foreach-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to create a temporary directory: %v\n", err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(workDir)
+
+	var outputLock sync.Mutex
+	var failed []string
+	var failedLock sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, foreachOptions.Parallel)
+	for _, nickname := range nicknames {
+		nickname := nickname
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			stdout := newPrefixWriter(os.Stdout, nickname, &outputLock)
+			stderr := newPrefixWriter(os.Stderr, nickname, &outputLock)
+			err := runForNickname(nickname, kubectlArgs, workDir, stdout, stderr)
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+			}
+			stdout.Flush()
+			stderr.Flush()
+
+			if err != nil {
+				failedLock.Lock()
+				failed = append(failed, nickname)
+				failedLock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		fmt.Fprintf(os.Stderr, "The command failed for %d of %d nicknames: %s\n", len(failed), len(nicknames), strings.Join(failed, ", "))
+		os.Exit(1)
+	}
+}
+
+// describeTags returns a phrase describing the tags that nicknames are required to have, for
+// messages, or an empty string if no tags are required.
+func describeTags(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" with tags %s", strings.Join(tags, ", "))
+}
+
+// checkForeachMutations returns an error if the kubectl arguments describe a command that changes
+// the cluster, and any of the nicknames is read-only, or requires confirmation without the
+// KCONFIG_NO_CONFIRM environment variable set.  The nicknames' kubectl executables are run
+// directly, and there's no way to confirm the commands of several nicknames at once, so the
+// command is refused before it's run for any of them.
+func checkForeachMutations(nicknames []string, kubectlArgs []string) error {
+	if !config.IsMutatingKubectlCommand(kubectlArgs) {
+		return nil
+	}
+
+	kconfig := config.GetKconfig()
+	var guarded []string
+	for _, nickname := range nicknames {
+		policy, err := kconfig.NicknameMutationPolicy(nickname)
+		if err != nil {
+			return err
+		}
+		if policy == config.MutationsRefused || (policy == config.MutationsNeedConfirmation && os.Getenv(config.NoConfirmEnvVar) == "") {
+			guarded = append(guarded, nickname)
+		}
+	}
+	if len(guarded) > 0 {
+		return fmt.Errorf("Refusing to run \"kubectl %s\", since it changes the cluster and these nicknames are read-only or require confirmation: %s", strings.Join(kubectlArgs, " "), strings.Join(guarded, ", "))
+	}
+	return nil
+}
+
+// runForNickname writes the kubectl config file for the nickname to the work directory and runs
+// the nickname's kubectl executable with the arguments.
+func runForNickname(nickname string, kubectlArgs []string, workDir string, stdout io.Writer, stderr io.Writer) error {
+	createResults, err := config.GetKconfig().ResolveLocalKubectlConfig(nickname, nil)
+	if err != nil {
+		return err
+	}
+	err = config.WriteLocalKubectlConfigFile(filepath.Join(workDir, nickname+".yaml"), createResults)
+	if err != nil {
+		return err
+	}
+
+	// The environment is the one kset would set up.  Any confirmation or read-only policy of the
+	// nickname has already been applied by checkForeachMutations().
+	cmd := exec.Command(createResults.KubectlExecutable, kubectlArgs...)
+	cmd.Env = ksetEnvironment(createResults, nickname)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return cmd.Run()
+}
+
+// ksetEnvironment returns the environment of this process with the environment variables that kset
+// would set for the results, whose local kubectl config file has been written, and the kset
+// environment description.  Those that the shell's kset environment set for its nickname's --env
+// and --cache-dir options, and for helm, are left out.
+func ksetEnvironment(createResults *config.CreateConfigResults, ksetDescription string) []string {
+	// The variables that the shell's kset environment set for its nickname don't apply to this one.
+	previousNames := make(map[string]bool)
+	for _, name := range strings.Fields(os.Getenv(nicknameEnvEnvVar)) {
+		previousNames[name] = true
+	}
+	var env []string
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		if !previousNames[name] && name != nicknameEnvEnvVar {
+			env = append(env, entry)
+		}
+	}
+
+	env = append(env,
+		"KUBECONFIG="+createResults.NewKubeconfigEnvVar,
+		"_KCONFIG_KUBECTL="+createResults.KubectlExecutable,
+		"_KCONFIG_KSET="+ksetDescription,
+		namespaceEnvVar+"="+createResults.ContextNamespace,
+		contextEnvVar+"="+createResults.BaseContext,
+		clusterEnvVar+"="+createResults.ClusterName,
+		serverEnvVar+"="+createResults.ServerURL())
+	if createResults.OpenShift {
+		env = append(env, openShiftEnvVar+"=true")
+	}
+	if createResults.TeleportProxyEnvVar != "" {
+		env = append(env, "TELEPORT_PROXY="+createResults.TeleportProxyEnvVar)
+	}
+	variables := ksetVariables(createResults)
+	env = append(env, variables...)
+	if len(variables) > 0 {
+		var names []string
+		for _, entry := range variables {
+			name, _, _ := strings.Cut(entry, "=")
+			names = append(names, name)
+		}
+		env = append(env, nicknameEnvEnvVar+"="+strings.Join(names, " "))
+	}
+	return env
+}
+
+// prefixWriter writes complete lines to the underlying writer, each prefixed with a label.  The
+// lock is shared by the writers of all nicknames, so lines from commands running in parallel
+// aren't mixed together.
+type prefixWriter struct {
+	out     io.Writer
+	prefix  string
+	lock    *sync.Mutex
+	partial bytes.Buffer
+}
+
+func newPrefixWriter(out io.Writer, nickname string, lock *sync.Mutex) *prefixWriter {
+	prefix := ""
+	if !foreachOptions.NoPrefix {
+		prefix = nickname + ": "
+	}
+	return &prefixWriter{out: out, prefix: prefix, lock: lock}
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.partial.Write(p)
+	for {
+		line, err := w.partial.ReadBytes('\n')
+		if err != nil {
+			// No complete line is left.  Keep the rest for the next write.
+			remaining := append([]byte(nil), line...)
+			w.partial.Reset()
+			w.partial.Write(remaining)
+			return len(p), nil
+		}
+		w.writeLine(line)
+	}
+}
+
+// Flush writes any incomplete last line.
+func (w *prefixWriter) Flush() {
+	if w.partial.Len() > 0 {
+		w.writeLine(append(w.partial.Bytes(), '\n'))
+		w.partial.Reset()
+	}
+}
+
+func (w *prefixWriter) writeLine(line []byte) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	fmt.Fprintf(w.out, "%s%s", w.prefix, line)
+}
+
+func init() {
+	_, err := parser.AddCommand("foreach",
+		"Run a kubectl command for each nickname that matches a pattern",
+		"Runs kubectl with the given arguments once for each nickname that matches the pattern, in "+
+			"the syntax of shell wildcards, like \"prod-*\", and have the tags given with --tag.  Put \"--\" before the kubectl "+
+			"arguments if any of them are options.  Each nickname's kubectl executable is run with "+
+			"the environment kset would set up, one nickname at a time unless --parallel is given.  "+
+			"Each line of output is prefixed with the nickname.",
+		&foreachOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
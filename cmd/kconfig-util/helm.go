@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jphx/kconfig/config"
+)
+
+// helmNamespaceEnvVar names the environment variable that helm reads its namespace from, which kset
+// sets to the namespace of the kset environment, so helm and kubectl use the same one.
+const helmNamespaceEnvVar = "HELM_NAMESPACE"
+
+// helmKubeContextEnvVar names the environment variable that helm reads the name of its kubectl
+// context from, which kset sets with the helm_kubecontext preference.
+const helmKubeContextEnvVar = "HELM_KUBECONTEXT"
+
+// helmEnvEnabled says whether kset sets helm's environment variables, which it does unless the
+// helm_env preference is false.
+func helmEnvEnabled() bool {
+	helmEnv := config.GetKconfig().Preferences.HelmEnv
+	return helmEnv == nil || *helmEnv
+}
+
+// ksetVariables returns the environment variables, like "NAME=VALUE", that kset sets for the
+// results besides KUBECONFIG and its own:  those of the nickname's --env and --cache-dir options,
+// and those that make helm use the same namespace, and possibly context, as kubectl.  A helm
+// variable set with the --env option keeps the nickname's value.
+func ksetVariables(createResults *config.CreateConfigResults) []string {
+	variables := append([]string{}, createResults.Environment...)
+	if !helmEnvEnabled() {
+		return variables
+	}
+
+	setNames := make(map[string]bool)
+	for _, env := range variables {
+		name, _, _ := strings.Cut(env, "=")
+		setNames[name] = true
+	}
+	if createResults.ContextNamespace != "" && !setNames[helmNamespaceEnvVar] {
+		variables = append(variables, helmNamespaceEnvVar+"="+createResults.ContextNamespace)
+	}
+	if config.GetKconfig().Preferences.HelmKubeContext && createResults.ConfigContent != nil && !setNames[helmKubeContextEnvVar] {
+		variables = append(variables, helmKubeContextEnvVar+"="+createResults.ConfigContent.CurrentContext)
+	}
+	return variables
+}
+
+// printHelmNamespaceUpdate prints the shell commands that make HELM_NAMESPACE follow a namespace
+// change that another tool made to the session-local kubectl config file, unless the helm_env
+// preference is false or the nickname's --env option set it.
+func printHelmNamespaceUpdate(namespace string) {
+	if !helmEnvEnabled() {
+		return
+	}
+	resolution := config.ResolveNickname(config.KsetNickname(os.Getenv("_KCONFIG_KSET")))
+	for _, env := range resolution.Environment {
+		if strings.HasPrefix(env, helmNamespaceEnvVar+"=") {
+			return
+		}
+	}
+
+	names := strings.Fields(os.Getenv(nicknameEnvEnvVar))
+	tracked := false
+	for _, name := range names {
+		tracked = tracked || name == helmNamespaceEnvVar
+	}
+	if namespace == "" {
+		if tracked {
+			fmt.Printf("unset %s\n", helmNamespaceEnvVar)
+		}
+		return
+	}
+
+	fmt.Printf("export %s=%s\n", helmNamespaceEnvVar, shellQuote(namespace))
+	if !tracked {
+		names = append(names, helmNamespaceEnvVar)
+		fmt.Printf("export %s=%s\n", nicknameEnvEnvVar, shellQuote(strings.Join(names, " ")))
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type historyCommandOptions struct {
+	Count int `short:"c" long:"count" value-name:"N" description:"List only the N most recent kset environments."`
+}
+
+var historyOptions historyCommandOptions
+
+// ksetHistoryReferenceRegexp matches a reference to an entry in the kset history, like "@2".
+var ksetHistoryReferenceRegexp = regexp.MustCompile(`^@[0-9]+$`)
+
+func (o *historyCommandOptions) Usage() string {
+	return "[--count N]"
+}
+
+func (o *historyCommandOptions) Execute(args []string) error {
+	commandProcessor = historyProcessor
+	commandName = "history"
+
+	if len(args) > 0 {
+		return fmt.Errorf("Unrecognized positional arguments provided.")
+	}
+
+	if o.Count < 0 {
+		return fmt.Errorf("The --count option can't be negative.")
+	}
+
+	return nil
+}
+
+// historyProcessor lists the kset history, most recent first.  Each entry is numbered so it can be
+// re-activated with "kset @N".  Entry 0 is the most recent one, which is usually the current
+// environment.
+func historyProcessor(positionalArgs []string) {
+	history := config.GetKsetHistory()
+	if historyOptions.Count > 0 && len(history) > historyOptions.Count {
+		history = history[:historyOptions.Count]
+	}
+
+	for idx, entry := range history {
+		fmt.Printf("%3d  %s  %s\n", idx, entry.Time.Local().Format("2006-01-02 15:04:05"), formatKsetHistoryArgs(entry.Args))
+	}
+}
+
+// formatKsetHistoryArgs joins the arguments of a kset history entry with blanks, quoting those
+// that contain blanks.
+func formatKsetHistoryArgs(args []string) string {
+	formatted := make([]string, 0, len(args))
+	for _, arg := range args {
+		if strings.ContainsAny(arg, " \t") {
+			arg = shellQuote(arg)
+		}
+		formatted = append(formatted, arg)
+	}
+	return strings.Join(formatted, " ")
+}
+
+// isKsetHistoryReference says whether the kset argument refers to an entry in the kset history,
+// like "@2".
+func isKsetHistoryReference(arg string) bool {
+	return ksetHistoryReferenceRegexp.MatchString(arg)
+}
+
+// getArgsFromKsetHistory returns the kset arguments (the nickname followed by any override options)
+// of the kset history entry named by a reference like "@2".
+func getArgsFromKsetHistory(reference string) ([]string, error) {
+	index, err := strconv.Atoi(strings.TrimPrefix(reference, "@"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid kset history reference \"%s\".", reference)
+	}
+
+	history := config.GetKsetHistory()
+	if index >= len(history) {
+		return nil, fmt.Errorf("There is no entry %d in the kset history.  It has %d entries.", index, len(history))
+	}
+
+	return history[index].Args, nil
+}
+
+func init() {
+	_, err := parser.AddCommand("history",
+		"List recent kset environments",
+		"Lists the most recent kset environments, most recent first.  Each is numbered, so that "+
+			"it can be activated again with \"kset @N\".",
+		&historyOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"text/template"
+
+	"github.com/jphx/kconfig/common"
+)
+
+// shellFunctionNameRegexp matches the names that can be given to the shell functions.
+var shellFunctionNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// shellFunctionNames holds the names of the kset and koff shell functions, for subcommands that
+// print shell code that defines them, sets up their completion, or calls them.
+type shellFunctionNames struct {
+	KsetName string `long:"kset-name" value-name:"NAME" default:"kset" description:"The name of the shell function that runs kset."`
+	KoffName string `long:"koff-name" value-name:"NAME" default:"koff" description:"The name of the shell function that runs koff."`
+}
+
+// FunctionArgs returns the options that pass the names on to another kconfig-util subcommand, each
+// preceded by a blank, or an empty string if the default names are used.
+func (n *shellFunctionNames) FunctionArgs() string {
+	var args string
+	if n.KsetName != "kset" {
+		args += " --kset-name " + n.KsetName
+	}
+	if n.KoffName != "koff" {
+		args += " --koff-name " + n.KoffName
+	}
+	return args
+}
+
+// check returns an error if the names can't be used as shell function names.
+func (n *shellFunctionNames) check(names ...string) error {
+	for _, name := range append([]string{n.KsetName, n.KoffName}, names...) {
+		if !shellFunctionNameRegexp.MatchString(name) {
+			return fmt.Errorf("\"%s\" can't be used as the name of a shell function.", name)
+		}
+	}
+	return nil
+}
+
+type initCommandOptions struct {
+	shellFunctionNames
+	KcurrentName string `long:"kcurrent-name" value-name:"NAME" default:"kcurrent" description:"The name of the shell function that runs kcurrent."`
+}
+
+var initOptions initCommandOptions
+
+// ShellProtocolVersion returns the version of the interface between kconfig-util and the shell
+// functions, for the template.
+func (o *initCommandOptions) ShellProtocolVersion() int {
+	return common.ShellProtocolVersion
+}
+
+var initScriptTemplate = template.Must(template.New("init").Parse(initScript))
+
+func (o *initCommandOptions) Usage() string {
+	return "bash|zsh [--kset-name NAME] [--koff-name NAME] [--kcurrent-name NAME]"
+}
+
+func (o *initCommandOptions) Execute(args []string) error {
+	commandProcessor = initProcessor
+	commandName = "init"
+
+	switch len(args) {
+	case 0:
+		return fmt.Errorf("A shell name must be specified.")
+	case 1:
+		// Good
+	default:
+		return fmt.Errorf("Unrecognized positional argument provided after the shell name.")
+	}
+
+	if args[0] != "bash" && args[0] != "zsh" {
+		return fmt.Errorf("Shell \"%s\" isn't supported.  Use one of: bash, zsh.", args[0])
+	}
+
+	err := o.check(o.KcurrentName)
+	if err != nil {
+		return err
+	}
+	if o.KsetName == o.KoffName || o.KsetName == o.KcurrentName || o.KoffName == o.KcurrentName {
+		return fmt.Errorf("The shell functions must have different names.")
+	}
+
+	return nil
+}
+
+// initProcessor prints the shell functions of the setup script, with the names requested.  With
+// the default names, the output is the same as setup/kconfig-setup.sh.
+func initProcessor(positionalArgs []string) {
+	err := initScriptTemplate.Execute(os.Stdout, &initOptions)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error printing the shell functions: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func init() {
+	_, err := parser.AddCommand("init",
+		"Print the shell functions of the setup script",
+		"Prints the kset, koff, and kcurrent shell functions, the prompt hooks, and the setup of "+
+			"command completion, as in the setup script, for the bash or zsh shell.  Evaluate its "+
+			"output from your shell initialization file, e.g., eval \"$(kconfig-util init bash)\".  "+
+			"The --kset-name, --koff-name, and --kcurrent-name options give the functions other "+
+			"names, for when a command of the same name already exists.",
+		&initOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
+
+// initScript is the template of the setup script.  With the default names, it must produce the
+// contents of setup/kconfig-setup.sh, which TestInit checks.
+const initScript = `# The kset shell function is intended to be run as:  kconfig name [override-options]
+# Where name is an entry in the kconfig.yaml file, where each entry has
+# the format:
+#
+# kubectl-options
+#  or
+# kubectl-executable-name kubectl-options
+#
+# E.g.,
+# stage=--context staging
+#  or
+# stage=kubectl-1.21.0 --context staging --namespace testing
+#
+# The script creates or updates a session-local kubectl config file to provide a current context
+# that is configured as specified.  The KUBECONFIG environment variable is updated to describe the
+# appropriate search path, starting with the session-local config file.  The _KCONFIG_KUBECTL env
+# var is to set name the kubectl executable to use for this nickname, to allow the kubectl
+# executable supplied with kconfig to forward the operation to the selected kubectl executable.  If
+# an executable name isn't provided, "kubectl" is used.
+
+# The version of the interface between these shell functions and kconfig-util.  kconfig-util warns
+# when it doesn't match its own, which means these functions need to be sourced again after an
+# upgrade.  "kconfig-util version --check" checks it too.
+export _KCONFIG_SHELL_PROTOCOL={{.ShellProtocolVersion}}
+
+# The user can type "koff" to undo the effects of kconfig and to restore the command prompt.
+function {{.KoffName}}() {
+   # With the --dry-run option, kconfig-util only says what would be done, so don't evaluate it.
+   local _KARG
+   for _KARG in "$@"; do
+      if [[ "$_KARG" == "--dry-run" ]]; then
+         kconfig-util koff "$@"
+         return
+      fi
+   done
+
+   # Restore the shell prompt, which also removes any coloring for a dangerous nickname.
+   if [[ -n "$_KCONFIG_OLD_PS1" ]]; then
+      PS1="$_KCONFIG_OLD_PS1"
+      unset _KCONFIG_OLD_PS1
+   fi
+
+   # Remove any session-local kubectl configuration file and unset or restore the KUBECONFIG env var.
+   # With --all, the previous kset environments are forgotten too.
+   if [[ -n "$KUBECONFIG" || "$1" == "--all" ]]; then
+      eval "$(kconfig-util koff "$@")"
+   fi
+
+   # Close the file descriptor of any session-local kubectl configuration file kept open by kset.
+   _kconfig_close_config_fd
+
+   # More cleanup
+   unset _KCONFIG_KUBECTL _KCONFIG_KSET _KCONFIG_NAMESPACE _KCONFIG_CONTEXT _KCONFIG_CLUSTER _KCONFIG_SERVER _KCONFIG_ORIG_KUBECONFIG _KCONFIG_ENV _KCONFIG_OPENSHIFT TELEPORT_PROXY
+}
+
+# The main kset command.  See the prologue comments.
+function {{.KsetName}}() {
+   # With the --print-only option, kconfig-util only prints information, so don't evaluate it.
+   local _KARG
+   for _KARG in "$@"; do
+      if [[ "$_KARG" == "--print-only" ]]; then
+         kconfig-util kset "$@"
+         return
+      fi
+   done
+
+   # Run the service utility to create the session-local config file.  Evaluate any statements it
+   # sends to standard output, which we expect are to set environment variables.  The exit status
+   # of kconfig-util is returned, so scripts can tell whether it failed.
+   local _KP _KPC _KCFG _KSP _KOUT _KSTATUS
+   _KOUT="$(kconfig-util kset "$@")"
+   _KSTATUS=$?
+   eval "$_KOUT"
+   _kconfig_set_kubeconfig || _KSTATUS=$?
+   _kconfig_set_prompt
+   return $_KSTATUS
+}
+
+# Keeps the session-local kubectl config file open on a file descriptor when kconfig-util prints
+# its content, base64-encoded, in the _KCFG variable, because of the --emit-config option or the
+# emit_config preference.  It's written to a file in memory-backed storage that's removed as soon
+# as it's opened, so it doesn't remain in any directory.  The KUBECONFIG env var refers to it as
+# /dev/fd/N, followed by the search path in the _KSP variable, if there is one.  This relies on
+# Linux, where each kubectl command opens /dev/fd/N afresh.  When kset writes a file instead, the
+# file descriptor is closed.
+function _kconfig_set_kubeconfig() {
+   if [[ -z "$_KCFG" ]]; then
+      if [[ -n "$_KCONFIG_FD" && "$KUBECONFIG" != "/dev/fd/$_KCONFIG_FD" && "$KUBECONFIG" != "/dev/fd/$_KCONFIG_FD:"* ]]; then
+         _kconfig_close_config_fd
+      fi
+      return 0
+   fi
+
+   if [[ -z "$_KCONFIG_FD" ]]; then
+      local _KFILE
+      _KFILE="$(mktemp "${XDG_RUNTIME_DIR:-/dev/shm}/kconfig.XXXXXX")" || return 1
+      exec {_KCONFIG_FD}<>"$_KFILE"
+      rm -f "$_KFILE"
+   fi
+   printf '%s' "$_KCFG" | base64 -d >"/dev/fd/$_KCONFIG_FD" || return 1
+   export KUBECONFIG="/dev/fd/$_KCONFIG_FD${_KSP:+:$_KSP}"
+}
+
+# Closes the file descriptor of the session-local kubectl config file, if kset keeps one open.
+function _kconfig_close_config_fd() {
+   if [[ -n "$_KCONFIG_FD" ]]; then
+      exec {_KCONFIG_FD}>&-
+      unset _KCONFIG_FD
+   fi
+}
+
+# Updates the shell prompt from the variables set by kconfig-util.  kconfig-util sets the _KP
+# variable with the shell prompt info.  For a dangerous nickname, it also sets the _KPC variable
+# with the ANSI color parameters for it.  The escape sequences are wrapped so the shell doesn't
+# count them in the length of the prompt.
+function _kconfig_set_prompt() {
+   if [[ -n "$_KP" ]]; then
+      if [[ -n "$_KPC" ]]; then
+         if [[ -n "$ZSH_VERSION" ]]; then
+            _KP=$'%{\e['"$_KPC"$'m%}'"$_KP"$'%{\e[0m%}'
+         else
+            _KP='\[\e['"$_KPC"'m\]'"$_KP"'\[\e[0m\]'
+         fi
+      fi
+
+      if [[ -z "$_KCONFIG_OLD_PS1" ]]; then
+         _KCONFIG_OLD_PS1="$PS1"
+         PS1="($_KP) $PS1"
+      else
+         PS1="($_KP) $_KCONFIG_OLD_PS1"
+      fi
+   fi
+}
+
+# Prints the nickname of the current kset environment, or nothing, with a nonzero exit status, if
+# there isn't one.  It's fast enough to use in a shell prompt.
+function {{.KcurrentName}}() {
+   kconfig-util current
+}
+
+# A shell prompt hook that switches to the nickname named by a ".kconfig" file in the current
+# directory or its ancestors, when the directory_kconfig preference is enabled.  To use it, add it
+# to PROMPT_COMMAND (bash) or precmd_functions (zsh).
+function _kconfig_direnv_hook() {
+   eval "$(kconfig-util direnv-hook{{.FunctionArgs}})"
+}
+
+# A shell prompt hook that updates the shell prompt when another tool changes the namespace of the
+# kset environment, like "kubectl config set-context --current --namespace foo" does.  To use it,
+# add it to PROMPT_COMMAND (bash) or precmd_functions (zsh).
+function _kconfig_prompt_hook() {
+   if [[ -n "$_KCONFIG_KSET" ]]; then
+      local _KP _KPC
+      eval "$(kconfig-util prompt --fast)"
+      _kconfig_set_prompt
+   fi
+}
+
+# Set up command completion for kset, koff, and kubectl.  The completion functions are generated by
+# kconfig-util.  In zsh, the native completion script is used if compinit has been run.  Otherwise
+# the bash script is used, which zsh can run after bashcompinit has been run.
+if command -v kconfig-util >/dev/null 2>&1; then
+   if [[ -n "$ZSH_VERSION" ]] && (( $+functions[compdef] )); then
+      eval "$(kconfig-util completion zsh{{.FunctionArgs}})"
+   else
+      eval "$(kconfig-util completion bash{{.FunctionArgs}})"
+   fi
+fi
+
+# In a new tmux pane, re-apply the kset environment last used in the pane's window, when the
+# tmux_integration preference is enabled.
+if [[ -n "$TMUX" && -z "$_KCONFIG_KSET" && "$1" != "clean" ]] && command -v kconfig-util >/dev/null 2>&1; then
+   eval "$(kconfig-util tmux restore{{.FunctionArgs}})"
+fi
+
+if [[ "$1" == "clean" ]]; then
+   {{.KoffName}}
+   unset {{.KsetName}}
+   unset {{.KcurrentName}}
+   unset _kconfig_set_prompt _kconfig_set_kubeconfig _kconfig_close_config_fd _kconfig_direnv_hook _kconfig_prompt_hook _KCONFIG_SHELL_PROTOCOL
+   unset {{.KoffName}}
+   if [[ -n "$ZSH_VERSION" ]] && (( $+functions[compdef] )); then
+      compdef -d {{.KsetName}} {{.KoffName}} kubectl
+      unset _kconfig_describe _kconfig_kset _kconfig_kubectl
+   else
+      complete -r {{.KsetName}} {{.KoffName}} kubectl
+      unset _kconfig_cmpl _kconfig_kubectl_cmpl
+      if declare -F __start_kubectl >/dev/null; then
+         complete -o default -F __start_kubectl kubectl
+      fi
+   fi
+fi
+`
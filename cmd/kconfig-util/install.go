@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// installBeginMarker and installEndMarker surround the lines that install writes to a shell
+// initialization file, so they can be found again to be replaced or removed.
+const (
+	installBeginMarker = "# >>> kconfig >>>"
+	installEndMarker   = "# <<< kconfig <<<"
+)
+
+// installShells lists the shells that install supports.
+var installShells = []string{"bash", "zsh", "fish"}
+
+type installCommandOptions struct {
+	shellFunctionNames
+	KcurrentName string `long:"kcurrent-name" value-name:"NAME" default:"kcurrent" description:"The name of the shell function that runs kcurrent."`
+	Shell        string `long:"shell" value-name:"SHELL" required:"true" description:"The shell to set up."`
+	RcFile       string `long:"rc-file" value-name:"FILE" description:"The shell initialization file to change.  If not specified, it's ~/.bashrc, ~/.zshrc, or ~/.config/fish/config.fish."`
+}
+
+var installOptions installCommandOptions
+
+func (o *installCommandOptions) Usage() string {
+	return "--shell bash|zsh|fish [--rc-file FILE] [--kset-name NAME] [--koff-name NAME] [--kcurrent-name NAME]"
+}
+
+func (o *installCommandOptions) Execute(args []string) error {
+	commandProcessor = installProcessor
+	commandName = "install"
+
+	if len(args) > 0 {
+		return fmt.Errorf("Unrecognized positional arguments provided.")
+	}
+	err := checkInstallShell(o.Shell)
+	if err != nil {
+		return err
+	}
+
+	if o.Shell == "fish" {
+		return o.check()
+	}
+	err = o.check(o.KcurrentName)
+	if err != nil {
+		return err
+	}
+	if o.KsetName == o.KoffName || o.KsetName == o.KcurrentName || o.KoffName == o.KcurrentName {
+		return fmt.Errorf("The shell functions must have different names.")
+	}
+
+	return nil
+}
+
+// installProcessor adds lines that set up the shell functions and command completion to the
+// shell's initialization file, between markers, or replaces the lines between the markers if
+// they're already there, so it can be run again, such as with other function names.  The lines
+// run "kconfig-util init" or "kconfig-util completion" each time the shell starts, rather than
+// holding a copy of the functions, so they don't have to be installed again after an upgrade.
+func installProcessor(positionalArgs []string) {
+	rcFilename := installOptions.RcFile
+	if rcFilename == "" {
+		rcFilename = defaultRcFilename(installOptions.Shell)
+	}
+
+	var block string
+	if installOptions.Shell == "fish" {
+		block = fmt.Sprintf("if status is-interactive\n    kconfig-util completion fish%s | source\nend\n", installOptions.FunctionArgs())
+	} else {
+		args := installOptions.FunctionArgs()
+		if installOptions.KcurrentName != "kcurrent" {
+			args += " --kcurrent-name " + installOptions.KcurrentName
+		}
+		block = fmt.Sprintf("if [[ $- == *i* ]]; then\n   eval \"$(kconfig-util init %s%s)\"\nfi\n", installOptions.Shell, args)
+	}
+	block = installBeginMarker + "\n" +
+		"# Added by \"kconfig-util install\".  Remove it with \"kconfig-util uninstall\".\n" +
+		block + installEndMarker + "\n"
+
+	contents, err := os.ReadFile(rcFilename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "Unable to read \"%s\": %v\n", rcFilename, err)
+		os.Exit(1)
+	}
+	before, existing, after, found := findInstalledBlock(string(contents))
+	if found && existing == block {
+		fmt.Printf("kconfig is already set up in \"%s\".\n", rcFilename)
+		return
+	}
+
+	var newContents string
+	if found {
+		newContents = before + block + after
+	} else {
+		newContents = string(contents)
+		if newContents != "" && !strings.HasSuffix(newContents, "\n") {
+			newContents += "\n"
+		}
+		if newContents != "" {
+			newContents += "\n"
+		}
+		newContents += block
+	}
+
+	err = os.MkdirAll(filepath.Dir(rcFilename), 0755)
+	if err == nil {
+		err = writeRcFile(rcFilename, newContents)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to write \"%s\": %v\n", rcFilename, err)
+		os.Exit(1)
+	}
+
+	verb := "Added"
+	if found {
+		verb = "Updated"
+	}
+	fmt.Printf("%s the kconfig setup in \"%s\".  Start a new shell to use it.\n", verb, rcFilename)
+	if _, err := exec.LookPath("kconfig-util"); err != nil {
+		fmt.Fprintln(os.Stderr, "Warning: kconfig-util isn't in a directory in your PATH, so the shell can't run it.")
+	}
+}
+
+// checkInstallShell returns an error if install doesn't support the shell.
+func checkInstallShell(shell string) error {
+	for _, supported := range installShells {
+		if shell == supported {
+			return nil
+		}
+	}
+	return fmt.Errorf("Shell \"%s\" isn't supported.  Use one of: %s.", shell, strings.Join(installShells, ", "))
+}
+
+// defaultRcFilename returns the initialization file of the shell that install and uninstall
+// change when the --rc-file option isn't given.
+func defaultRcFilename(shell string) string {
+	home, _ := os.UserHomeDir()
+	switch shell {
+	case "zsh":
+		if zdotdir := os.Getenv("ZDOTDIR"); zdotdir != "" {
+			return filepath.Join(zdotdir, ".zshrc")
+		}
+		return filepath.Join(home, ".zshrc")
+	case "fish":
+		configHome := os.Getenv("XDG_CONFIG_HOME")
+		if configHome == "" {
+			configHome = filepath.Join(home, ".config")
+		}
+		return filepath.Join(configHome, "fish", "config.fish")
+	default:
+		return filepath.Join(home, ".bashrc")
+	}
+}
+
+// findInstalledBlock looks for the lines that install wrote in the contents of a shell
+// initialization file.  If they're found, it returns the contents before them, the lines
+// themselves, including the markers, and the contents after them.
+func findInstalledBlock(contents string) (string, string, string, bool) {
+	begin := strings.Index(contents, installBeginMarker+"\n")
+	if begin == -1 || (begin > 0 && contents[begin-1] != '\n') {
+		return contents, "", "", false
+	}
+	end := strings.Index(contents[begin:], installEndMarker)
+	if end == -1 {
+		return contents, "", "", false
+	}
+	end += begin + len(installEndMarker)
+	if end < len(contents) && contents[end] == '\n' {
+		end++
+	}
+	return contents[:begin], contents[begin:end], contents[end:], true
+}
+
+// writeRcFile replaces the contents of a shell initialization file, keeping its permissions.  It's
+// written in place, rather than replaced, so a symbolic link to it, like those that dotfile
+// managers create, is kept.
+func writeRcFile(filename string, contents string) error {
+	return os.WriteFile(filename, []byte(contents), 0644)
+}
+
+func init() {
+	_, err := parser.AddCommand("install",
+		"Set up the shell functions in a shell initialization file",
+		"Adds lines to the initialization file of the bash, zsh, or fish shell (~/.bashrc, "+
+			"~/.zshrc, or ~/.config/fish/config.fish, or the file named by --rc-file) that set up "+
+			"the kset, koff, and kcurrent shell functions and command completion in interactive "+
+			"shells, by evaluating the output of \"kconfig-util init\".  For fish, only command "+
+			"completion is set up.  The lines are marked, so running it again replaces them, and "+
+			"the uninstall subcommand removes them.  The --kset-name, --koff-name, and "+
+			"--kcurrent-name options are passed on to \"kconfig-util init\".",
+		&installOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
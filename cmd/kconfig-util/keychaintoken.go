@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type keychainTokenCommandOptions struct {
+}
+
+var keychainTokenOptions keychainTokenCommandOptions
+
+func (o *keychainTokenCommandOptions) Usage() string {
+	return "name"
+}
+
+func (o *keychainTokenCommandOptions) Execute(args []string) error {
+	commandProcessor = keychainTokenProcessor
+	commandName = "keychain-token"
+
+	if len(args) != 1 {
+		return fmt.Errorf("The name of the token must be specified.")
+	}
+
+	return nil
+}
+
+// keychainTokenProcessor prints an ExecCredential holding the token kept in the OS keychain under
+// the name.  kubectl runs it as an exec credential plugin for nicknames whose definitions have the
+// --keychain-token option.
+func keychainTokenProcessor(positionalArgs []string) {
+	apiVersion, _ := getExecCredentialRequest()
+
+	token, err := config.GetSecret(positionalArgs[0])
+	if err != nil {
+		config.ExitWithError(config.NewCodedError(config.ErrorCodeAuthFailure, err))
+	}
+
+	response := execCredentialResponse{
+		APIVersion: apiVersion,
+		Kind:       "ExecCredential",
+		Status: execCredentialResponseStatus{
+			Token: token,
+		},
+	}
+	printExecCredential(&response)
+}
+
+func init() {
+	_, err := parser.AddCommand("keychain-token",
+		"Get a token from the OS keychain for kubectl",
+		"Run by kubectl as an exec credential plugin for nicknames whose definitions have the "+
+			"--keychain-token option.  Prints the token stored in the OS keychain under the name "+
+			"with \"kconfig-util secret set\".",
+		&keychainTokenOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
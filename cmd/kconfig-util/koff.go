@@ -1,20 +1,22 @@
 package main
 
 import (
-	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/jphx/kconfig/config"
 )
 
 type koffCommandOptions struct {
+	All    bool `long:"all" description:"Also forget the previous kset environments, so \"kset -\" and kstack have nothing to go back to, returning the shell to the state it had before kset was first used."`
+	DryRun bool `long:"dry-run" description:"Only say which session-local kubectl config file would be removed and what KUBECONFIG would be restored to, without changing anything."`
 }
 
 var koffOptions koffCommandOptions
 
 func (o *koffCommandOptions) Usage() string {
-	return ""
+	return "[--all] [--dry-run]"
 }
 
 func (o *koffCommandOptions) Execute(args []string) error {
@@ -31,43 +33,112 @@ func (o *koffCommandOptions) Execute(args []string) error {
 func koffProcessor(positionalArgs []string) {
 	kubeconfigEnvVar := os.Getenv("KUBECONFIG")
 	if kubeconfigEnvVar == "" {
+		if koffOptions.DryRun {
+			fmt.Println("KUBECONFIG isn't set, so there's nothing to remove.")
+			if koffOptions.All {
+				fmt.Println("Would forget the previous kset environments.")
+			}
+			return
+		}
+		if koffOptions.All {
+			printKsetStackUpdate(nil)
+		}
 		return
 	}
 
+	// Only a file that kset created is removed, not one that just happens to be in the session
+	// directory.
 	localConfigFilename := config.GetExistingSessionLocalFilename(kubeconfigEnvVar)
 	if localConfigFilename != "" {
-		err := os.Remove(localConfigFilename)
-		if err != nil && !errors.Is(err, os.ErrNotExist) {
-			fmt.Fprintf(os.Stderr, "Error removing session-local kubectl configuration file: %v\n", err)
+		err := config.CheckSessionLocalFile(localConfigFilename)
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Warning: Not removing the file named by KUBECONFIG: %v\n", err)
+		case koffOptions.DryRun:
+			fmt.Printf("Would remove the session-local kubectl config file \"%s\".\n", localConfigFilename)
+		default:
+			err = config.RemoveLocalKubectlConfigFile(localConfigFilename)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error removing session-local kubectl configuration file: %v\n", err)
+			}
+		}
+	} else if koffOptions.DryRun {
+		fmt.Println("KUBECONFIG doesn't name a session-local kubectl config file, so no file would be removed.")
+	}
+
+	// KUBECONFIG gets back the value it had before the first kset, or if it wasn't set then, the
+	// base_kubeconfig preference.
+	restoredKubeconfig := os.Getenv(config.OrigKubeconfigEnvVar)
+	if restoredKubeconfig == "" {
+		restoredKubeconfig = config.GetKconfig().Preferences.BaseKubeconfig
+	}
+	if koffOptions.DryRun {
+		if restoredKubeconfig != "" {
+			fmt.Printf("Would set KUBECONFIG to \"%s\".\n", restoredKubeconfig)
+		} else {
+			fmt.Println("Would unset KUBECONFIG.")
+		}
+		if koffOptions.All {
+			fmt.Println("Would forget the previous kset environments.")
 		}
+		return
 	}
 
-	baseKubeconfig := config.GetKconfig().Preferences.BaseKubeconfig
-	if baseKubeconfig != "" {
-		fmt.Printf("export KUBECONFIG=%s\n", baseKubeconfig)
+	if restoredKubeconfig != "" {
+		fmt.Printf("export KUBECONFIG=%s\n", shellQuote(restoredKubeconfig))
 	} else {
 		fmt.Println("unset KUBECONFIG")
 	}
 
-	// Transfer the description of the most-recent kset environment to the _KCONFIG_OLDKSET env var.
+	// Unset the environment variables that kset set for the nickname's --env and --cache-dir
+	// options.
+	for _, name := range strings.Fields(os.Getenv(nicknameEnvEnvVar)) {
+		fmt.Printf("unset %s\n", name)
+	}
+
+	// Push the description of the most-recent kset environment onto the stack of previous
+	// environments, or with --all, clear the stack.  kset reuses the shell's session-local kubectl
+	// config file, so there are no others to remove.
 	previousKset := os.Getenv("_KCONFIG_KSET")
+	if koffOptions.All {
+		printKsetStackUpdate(nil)
+	} else if previousKset != "" {
+		printKsetStackUpdate(pushKsetStack(getKsetStack(), previousKset))
+	}
+
 	if previousKset != "" {
-		fmt.Println("export _KCONFIG_OLDKSET=\"$_KCONFIG_KSET\"")
+		printTerminalTitleRestore()
 	}
 
+	updateTmux("", "")
+
+	runOnSwitchCommand("koff", map[string]string{
+		"KCONFIG_PREVIOUS": strings.Join(config.SplitKsetArgs(previousKset), " "),
+	})
+
 	// The koff shell function will unset the following environment variables:
 	//   - _KCONFIG_KUBECTL
 	//   - TELEPORT_PROXY
 	//   - _KCONFIG_KSET
-	// Note that _KCONFIG_OLDKSET is allowed to remain so that the user can run "kset -" to regain
-	// the last environment.
+	//   - _KCONFIG_NAMESPACE
+	//   - _KCONFIG_CONTEXT
+	//   - _KCONFIG_CLUSTER
+	//   - _KCONFIG_SERVER
+	//   - _KCONFIG_ORIG_KUBECONFIG
+	//   - _KCONFIG_ENV
+	//   - _KCONFIG_OPENSHIFT
+	// Note that _KCONFIG_OLDKSET and _KCONFIG_KSTACK are allowed to remain so that the user can run
+	// "kset -" to regain the last environment, unless --all is given.
 }
 
 func init() {
 	_, err := parser.AddCommand("koff",
 		"Clean up session-local kubectl config file",
 		"Called by koff shell function to remove any session-local kubectl config file and to "+
-			"restore the KUBECONFIG env var to it's \"normal\" value.",
+			"restore the KUBECONFIG env var to the value it had before the first kset, or to its "+
+			"\"normal\" value.  With --all, the stack of "+
+			"previous kset environments is cleared too.  Only a file that kset created is removed.  "+
+			"With --dry-run, nothing is changed; it only says what would be done.",
 		&koffOptions)
 
 	if err != nil {
@@ -1,30 +1,68 @@
 package main
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
+	"text/tabwriter"
+
+	"k8s.io/client-go/tools/clientcmd"
 
 	"github.com/jphx/kconfig/common"
 	"github.com/jphx/kconfig/config"
 )
 
-const ksetEnvVarDelimiter = "\x1F"
-
 type ksetCommandOptions struct {
 	config.KconfigOptions
+	OutputFile string `long:"output-file" value-name:"FILE" description:"Write the kubectl config file to this path, instead of a temporary session-local file.  It isn't removed by koff."`
+	EmitConfig bool   `long:"emit-config" description:"Print the session-local kubectl config file, base64-encoded, for the kset shell function to keep open on a file descriptor, instead of writing it to a file.  This is the default with the emit_config preference."`
+	PrintOnly  bool   `long:"print-only" description:"Print the would-be session-local kubectl config file and environment variable settings without changing anything."`
+	Save       string `long:"save" value-name:"NAME" description:"Save the resulting kset environment, the nickname and any overrides, under this name so it can be restored with --restore."`
+	Restore    string `long:"restore" value-name:"NAME" description:"Activate the kset environment saved under this name.  Override options given with it take precedence over the saved ones."`
+
+	Also []string `long:"also" value-name:"NICKNAME" description:"Also add a context named after this nickname to the session-local kubectl config file, so tools like \"kubectl config use-context\" or k9s can switch to it.  This option can be repeated."`
+
+	Quiet   bool `long:"quiet" description:"Only print the commands that set environment variables, for scripts that evaluate the output.  The shell prompt and terminal title aren't changed, and kset's warnings aren't printed."`
+	Verbose bool `long:"verbose" description:"Also print a summary of the resolved environment to standard error:  the context, cluster, API server URL, namespace, user, and kubectl executable."`
 }
 
 var ksetOptions ksetCommandOptions
 
 func (o *ksetCommandOptions) Usage() string {
-	return "[nickname|-] [override-options]"
+	return "[nickname|-|@N] [override-options] | --restore NAME [override-options]"
 }
 
 func (o *ksetCommandOptions) Execute(args []string) error {
 	commandProcessor = ksetProcessor
 	commandName = "kset"
 
+	if o.Save != "" && o.PrintOnly {
+		return fmt.Errorf("The --save option can't be used with the --print-only option.")
+	}
+
+	if o.EmitConfig && o.OutputFile != "" {
+		return fmt.Errorf("The --emit-config option can't be used with the --output-file option.")
+	}
+
+	if o.Quiet && o.Verbose {
+		return fmt.Errorf("The --quiet option can't be used with the --verbose option.")
+	}
+
+	if o.Quiet && o.EmitConfig {
+		return fmt.Errorf("The --emit-config option can't be used with the --quiet option.")
+	}
+
+	if o.Restore != "" {
+		if len(args) > 0 {
+			return fmt.Errorf("A kconfig nickname can't be specified with the --restore option.")
+		}
+		return nil
+	}
+
 	switch len(args) {
 	case 0:
 		if os.Getenv("_KCONFIG_KSET") == "" {
@@ -48,12 +86,24 @@ func (o *ksetCommandOptions) Execute(args []string) error {
 var ksetLogger = common.CreateLogger("kset")
 
 func ksetProcessor(positionalArgs []string) {
+	// Shell functions from an older version of kconfig don't export their protocol version, and
+	// usually still work, so they're only reported by "kconfig-util version --check".
+	if value := os.Getenv(shellProtocolEnvVar); value != "" {
+		if problem := checkShellProtocol(); problem != "" {
+			ksetWarnf("%s", problem)
+		}
+	}
+
 	var nickname string
-	if len(positionalArgs) == 0 {
-		nickname = getNicknameFromKsetArgs(os.Getenv("_KCONFIG_KSET"))
+	if ksetOptions.Restore != "" {
+		nickname = restoreSavedSession(ksetOptions.Restore, &ksetOptions.KconfigOptions, &ksetOptions.Also)
+		ksetLogger.Debugf("Processing --restore in kset.  Deduced nickname \"%s\".", nickname)
+
+	} else if len(positionalArgs) == 0 {
+		nickname = config.KsetNickname(os.Getenv("_KCONFIG_KSET"))
 		if nickname == "" {
-			fmt.Fprintln(os.Stderr, "A kconfig nickname must be specified unless one is already in effect.")
-			os.Exit(1)
+			config.ExitWithError(config.NewCodedError(config.ErrorCodeUsage,
+				errors.New("A kconfig nickname must be specified unless one is already in effect.")))
 		}
 		ksetLogger.Debugf("Processing missing nickname in kset.  Deduced nickname \"%s\".", nickname)
 
@@ -65,121 +115,439 @@ func ksetProcessor(positionalArgs []string) {
 			// A plain "kset -" would be handled in main.go and transformed into (essentially)
 			// "kset $_KCONFIG_OLDKSET" before the arguments are parsed.  So we're dealing with
 			// something like "kset - -n xxx" instead, where only the previous nickname is used.
-			nickname = getNicknameFromKsetArgs(os.Getenv("_KCONFIG_OLDKSET"))
+			nickname = config.KsetNickname(os.Getenv("_KCONFIG_OLDKSET"))
 			if nickname == "" {
-				fmt.Fprintln(os.Stderr, "A kconfig nickname of \"-\" can only be used when a previous kconfig environment is in effect.")
-				os.Exit(1)
+				config.ExitWithError(config.NewCodedError(config.ErrorCodeUsage,
+					errors.New("A kconfig nickname of \"-\" can only be used when a previous kconfig environment is in effect.")))
 			}
+			poppingKsetStack = true
 
 			ksetLogger.Debugf("Processing nickname of \"-\" in kset.  Deduced nickname \"%s\".", nickname)
+
+		} else if isKsetHistoryReference(nickname) {
+			// Like "-", but for an entry of the kset history, in a command like "kset @2 -n xxx".  A
+			// plain "kset @2" is handled in main.go, and uses the arguments of the entry as well.
+			historyArgs, err := getArgsFromKsetHistory(nickname)
+			if err != nil {
+				config.ExitWithError(err)
+			}
+			nickname = historyArgs[0]
+
+			ksetLogger.Debugf("Processing kset history reference in kset.  Deduced nickname \"%s\".", nickname)
 		}
 	}
 
-	createResults := config.CreateLocalKubectlConfigFile(nickname, &ksetOptions.KconfigOptions, true)
+	nickname = config.ExpandNickname(nickname)
+
+	// Check that the nickname is defined first, so a mistyped one gets suggestions.
+	if _, err := config.GetKconfig().ResolveNickname(nickname); err != nil {
+		config.ExitWithError(config.GetKconfig().AddNicknameSuggestions(nickname, err))
+	}
+
+	// A namespace of "-" says to use the namespace that was previously in use with this nickname.
+	if ksetOptions.Namespace == "-" {
+		previousNamespace := config.GetPreviousNamespace(nickname)
+		if previousNamespace == "" {
+			config.ExitWithError(config.NewCodedError(config.ErrorCodeMissingNamespace,
+				fmt.Errorf("No previous namespace is recorded for nickname \"%s\".", nickname)))
+		}
+
+		ksetLogger.Debugf("Processing namespace of \"-\" in kset.  Deduced namespace \"%s\".", previousNamespace)
+		ksetOptions.Namespace = previousNamespace
+	}
+
+	if ksetOptions.PrintOnly {
+		printResolvedKset(nickname)
+		return
+	}
+
+	if config.ResolveNickname(nickname).OCLogin {
+		refreshOpenShiftLogin(nickname)
+	}
+
+	if ksetOptions.Namespace != "" && config.GetKconfig().Preferences.ValidateNamespace {
+		validateNamespace(nickname)
+	}
+
+	// The emit_config preference doesn't apply with --quiet, since only the kset shell function can
+	// use its output.
+	emitConfig := ksetOptions.EmitConfig || (config.GetKconfig().Preferences.EmitConfig && ksetOptions.OutputFile == "" && !ksetOptions.Quiet)
+	var createResults *config.CreateConfigResults
+	if emitConfig {
+		createResults = emitLocalKubectlConfig(nickname)
+	} else {
+		createResults = config.CreateLocalKubectlConfigFile(nickname, &ksetOptions.KconfigOptions, ksetOptions.Also, true, ksetOptions.OutputFile)
 
-	// Print to standard output any shell operations that should be performed.
-	fmt.Printf("export KUBECONFIG=%s\n", createResults.NewKubeconfigEnvVar)
+		// Print to standard output any shell operations that should be performed.
+		fmt.Printf("export KUBECONFIG=%s\n", shellQuote(createResults.NewKubeconfigEnvVar))
+	}
+	config.RecordNamespace(nickname, createResults.ContextNamespace)
+	config.RecordKset(getKsetArgs(nickname))
+	if ksetOptions.Save != "" {
+		config.SaveSession(ksetOptions.Save, getKsetArgs(nickname))
+	}
 
 	// If the user is using Teleport, see if they've asked for us to set the TELEPORT_PROXY
 	// environment variable that Teleport uses when it proxies a Kubernetes connection.
 	if createResults.TeleportProxyEnvVar != "" {
-		fmt.Printf("export TELEPORT_PROXY=%s\n", createResults.TeleportProxyEnvVar)
+		fmt.Printf("export TELEPORT_PROXY=%s\n", shellQuote(createResults.TeleportProxyEnvVar))
 	}
+	printNicknameEnvironment(ksetVariables(createResults))
 
-	kconfig := config.GetKconfig()
-	if kconfig.Preferences.ChangePrompt == nil || *kconfig.Preferences.ChangePrompt {
-		promptPrefix := nickname
-		if createResults.OverridesDescription != "" && (kconfig.Preferences.ShowOverridesInPrompt == nil || *kconfig.Preferences.ShowOverridesInPrompt) {
-			if kconfig.Preferences.AlwaysShowNamespaceInPrompt && !strings.Contains(createResults.OverridesDescription, "ns=") {
-				createResults.OverridesDescription = fmt.Sprintf("ns=%s,%s", createResults.ContextNamespace, createResults.OverridesDescription)
-			}
-			promptPrefix = fmt.Sprintf("%s[%s]", nickname, createResults.OverridesDescription)
-
-		} else if kconfig.Preferences.AlwaysShowNamespaceInPrompt {
-			promptPrefix = fmt.Sprintf("%s[ns=%s]", nickname, createResults.ContextNamespace)
+	if !ksetOptions.Quiet {
+		printPromptUpdate(nickname, createResults)
+		if createResults.Danger && config.GetKconfig().Preferences.AnnounceTarget {
+			announceTarget(createResults)
 		}
+	}
 
-		// Emit a temporary shell variable that describes the prefix to use on the shell prompt.
-		fmt.Printf("_KP=%s\n", promptPrefix)
+	// Remember the namespace configured, so the prompt hook can tell when another tool changes it,
+	// and the context, cluster, and API server, for other tools to use.
+	fmt.Printf("export %s=%s\n", namespaceEnvVar, shellQuote(createResults.ContextNamespace))
+	fmt.Printf("export %s=%s\n", contextEnvVar, shellQuote(createResults.BaseContext))
+	fmt.Printf("export %s=%s\n", clusterEnvVar, shellQuote(createResults.ClusterName))
+	fmt.Printf("export %s=%s\n", serverEnvVar, shellQuote(createResults.ServerURL()))
+	if createResults.OpenShift {
+		fmt.Printf("export %s=true\n", openShiftEnvVar)
+	} else {
+		fmt.Printf("unset %s\n", openShiftEnvVar)
 	}
 
 	// Set an environment variable used by the kubectl executable included with this package.
-	fmt.Printf("export _KCONFIG_KUBECTL=%s\n", createResults.KubectlExecutable)
+	fmt.Printf("export _KCONFIG_KUBECTL=%s\n", shellQuote(createResults.KubectlExecutable))
 
 	// Figure out the description of the new kset environment.
-	ksetDescription := createKsetArgs(nickname, &ksetOptions.KconfigOptions)
-
-	// Transfer the description of the most-recent kset environment to the _KCONFIG_OLDKSET env var.
-	previousKset := os.Getenv("_KCONFIG_KSET")
-	if previousKset != "" && previousKset != ksetDescription {
-		fmt.Println("export _KCONFIG_OLDKSET=\"$_KCONFIG_KSET\"")
+	ksetDescription := createKsetArgs(getKsetArgs(nickname))
+
+	// Update the stack of previous kset environments.  Activating the previous environment pops it
+	// off the stack.  Otherwise the environment being replaced is pushed onto the stack.  Without
+	// the "kset-stack" feature, the stack holds only the previous environment, so "kset -" toggles
+	// between it and the current one.
+	stack := getKsetStack()
+	currentKset := os.Getenv("_KCONFIG_KSET")
+	if poppingKsetStack && ksetStackEnabled() {
+		stack = stack[1:]
+	} else if currentKset != "" && currentKset != ksetDescription {
+		stack = pushKsetStack(stack, currentKset)
 	}
+	printKsetStackUpdate(stack)
 
 	// Set an environment variable that says what the current kset request is.  We might use this
-	// later, once it gets transferred to the _KCONFIG_OLDKSET environment variable, when processing
-	// a "kset -" command, which says to switch the last kset environment.
-	fmt.Printf("export _KCONFIG_KSET=\"%s\"\n", ksetDescription)
+	// later, once it gets pushed onto the stack of previous environments, when processing a
+	// "kset -" command, which says to switch back to the last kset environment.
+	fmt.Printf("export _KCONFIG_KSET=%s\n", shellQuote(ksetDescription))
+
+	// Remember the KUBECONFIG the user had when entering a kset environment from none, so koff can
+	// restore it.
+	if currentKset == "" {
+		if kubeconfig := os.Getenv("KUBECONFIG"); kubeconfig != "" {
+			fmt.Printf("export %s=%s\n", config.OrigKubeconfigEnvVar, shellQuote(kubeconfig))
+		} else {
+			fmt.Printf("unset %s\n", config.OrigKubeconfigEnvVar)
+		}
+	}
+
+	// Save the terminal title when entering a kset environment from none, so koff can restore it.
+	if !ksetOptions.Quiet {
+		printTerminalTitleUpdate(nickname, currentKset == "")
+	}
+
+	updateTmux(ksetDescription, fmt.Sprintf("%s/%s", nickname, createResults.ContextNamespace))
+
+	if config.GetKconfig().Preferences.PrewarmCredentials {
+		prewarmCredentials(createResults)
+	}
+
+	if ksetOptions.Verbose {
+		printKsetSummary(nickname, createResults)
+	}
+
+	merged := createResults.MergedConfig()
+	var cluster string
+	if context := merged.Contexts[merged.CurrentContext]; context != nil {
+		cluster = context.Cluster
+	}
+	runOnSwitchCommand("kset", map[string]string{
+		"KCONFIG_NICKNAME":  nickname,
+		"KCONFIG_KSET":      strings.Join(config.SplitKsetArgs(ksetDescription), " "),
+		"KCONFIG_PREVIOUS":  strings.Join(config.SplitKsetArgs(currentKset), " "),
+		"KCONFIG_CLUSTER":   cluster,
+		"KCONFIG_NAMESPACE": createResults.ContextNamespace,
+		"KCONFIG_DANGER":    fmt.Sprintf("%v", createResults.Danger),
+		"KUBECONFIG":        createResults.NewKubeconfigEnvVar,
+	})
 }
 
-// createKsetArgs creates a string that describes the kset environment, the nickname and any
-// overrides.  We'd like to properly quote the values in this string as a shell would so that we can
-// parse them again later, but sadly the github.com/google/shlex library that we use for parsing a
-// quoted string doesn't support quoting a string.  So instead we delimit the fields with a simple
-// blank character, *unless* a blank appears in any of the values.  In that case, we use a delimiter
-// that should not appear in the string, namely the "unit separator" ASCII/Unicode control code, 0x1F.
-func createKsetArgs(nickname string, kconfigOptions *config.KconfigOptions) string {
-	// Fast path for common case when no override options are specified.
-	if kconfigOptions.KubeConfig == "" && kconfigOptions.Context == "" &&
-		kconfigOptions.Namespace == "" && kconfigOptions.User == "" &&
-		kconfigOptions.TeleportProxy == "" {
-		return nickname
+// emitLocalKubectlConfig handles the --emit-config option and the emit_config preference.  Instead
+// of writing the session-local kubectl config file, it prints its content, base64-encoded, in the
+// _KCFG shell variable, and the rest of the search path in the _KSP shell variable, which is empty
+// with --self-contained.  The kset shell function writes the content to a deleted file it keeps
+// open on a file descriptor, and sets the KUBECONFIG environment variable to refer to it, so the
+// content never appears in the state directory.  Any session-local file of the kset environment
+// being replaced is removed.
+func emitLocalKubectlConfig(nickname string) *config.CreateConfigResults {
+	createResults := config.ResolveLocalKubectlConfig(nickname, &ksetOptions.KconfigOptions, ksetOptions.Also)
+	content, err := clientcmd.Write(*createResults.ConfigContent)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error formatting the session-local kubectl configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	if localConfigFilename := config.GetExistingSessionLocalFilename(os.Getenv("KUBECONFIG")); localConfigFilename != "" {
+		err = config.CheckSessionLocalFile(localConfigFilename)
+		if err == nil {
+			err = config.RemoveLocalKubectlConfigFile(localConfigFilename)
+		}
+		if err != nil {
+			ksetWarnf("Unable to remove the local kubectl config file \"%s\": %v", localConfigFilename, err)
+		}
+	}
+
+	fmt.Printf("_KCFG=%s\n", base64.StdEncoding.EncodeToString(content))
+	searchPath := createResults.SearchPath
+	if createResults.SelfContained {
+		searchPath = ""
 	}
+	fmt.Printf("_KSP=%s\n", shellQuote(searchPath))
+	// The on-switch command doesn't get the file descriptor, so it only gets the search path.
+	createResults.NewKubeconfigEnvVar = createResults.SearchPath
+	return createResults
+}
 
-	var args []string
-	args = append(args, nickname)
-	if kconfigOptions.KubeConfig != "" {
-		args = append(args, "--kubeconfig", kconfigOptions.KubeConfig)
+// validateNamespace handles the validate_namespace preference.  It asks the cluster of the nickname
+// whether the namespace given with the -n option exists, and exits the process if it doesn't, or if
+// the cluster rejects the nickname's credentials.  If the cluster can't be asked, a warning is
+// printed.
+func validateNamespace(nickname string) {
+	createResults := config.ResolveLocalKubectlConfig(nickname, &ksetOptions.KconfigOptions, nil)
+	exists, err := createResults.NamespaceExists(ksetOptions.Namespace)
+	if err != nil && config.GetErrorCode(err) == config.ErrorCodeAuthFailure {
+		config.ExitWithError(fmt.Errorf("Unable to check that namespace \"%s\" exists: %w", ksetOptions.Namespace, err))
 	}
-	if kconfigOptions.Context != "" {
-		args = append(args, "--context", kconfigOptions.Context)
+	if err != nil {
+		ksetWarnf("Unable to check that namespace \"%s\" exists: %v", ksetOptions.Namespace, err)
+		return
 	}
-	if kconfigOptions.Namespace != "" {
-		args = append(args, "-n", kconfigOptions.Namespace)
+	if !exists {
+		config.ExitWithError(config.NewCodedError(config.ErrorCodeMissingNamespace,
+			fmt.Errorf("Namespace \"%s\" doesn't exist in the cluster of nickname \"%s\".", ksetOptions.Namespace, nickname)))
 	}
-	if kconfigOptions.User != "" {
-		args = append(args, "--user", kconfigOptions.User)
+}
+
+// refreshOpenShiftLogin handles the --oc-login option.  If the OpenShift API server rejects the
+// user's token, "oc login" is run so the user can get a new one before the kset environment is set
+// up.  If the API server can't be asked, there's only a warning.
+func refreshOpenShiftLogin(nickname string) {
+	createResults := config.ResolveLocalKubectlConfig(nickname, &ksetOptions.KconfigOptions, nil)
+	needsLogin, err := createResults.NeedsOpenShiftLogin()
+	if err != nil {
+		ksetWarnf("Unable to check the OpenShift credentials of nickname \"%s\": %v", nickname, err)
+		return
 	}
-	if kconfigOptions.TeleportProxy != "" {
-		args = append(args, "--teleport-proxy", kconfigOptions.TeleportProxy)
+	if !needsLogin {
+		return
 	}
 
-	delimiter := " "
-	if strings.Contains(nickname, " ") || strings.Contains(kconfigOptions.KubeConfig, " ") ||
-		strings.Contains(kconfigOptions.Context, " ") ||
-		strings.Contains(kconfigOptions.Namespace, " ") ||
-		strings.Contains(kconfigOptions.User, " ") ||
-		strings.Contains(kconfigOptions.TeleportProxy, " ") {
-		delimiter = ksetEnvVarDelimiter
+	err = createResults.OpenShiftLogin()
+	if err != nil {
+		config.ExitWithError(err)
 	}
+}
 
-	return strings.Join(args, delimiter)
+// ksetWarnf prints a warning to standard error, unless the --quiet option was given.
+func ksetWarnf(format string, args ...interface{}) {
+	if !ksetOptions.Quiet {
+		fmt.Fprintf(os.Stderr, "Warning: "+format+"\n", args...)
+	}
+}
+
+// printKsetSummary handles the --verbose option.  It prints a description of the kset environment
+// to standard error, where the kset shell function doesn't evaluate it.
+func printKsetSummary(nickname string, createResults *config.CreateConfigResults) {
+	merged := createResults.MergedConfig()
+	var user string
+	if context := merged.Contexts[merged.CurrentContext]; context != nil {
+		user = context.AuthInfo
+	}
+	namespace := createResults.ContextNamespace
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	writer := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(writer, "Nickname:\t%s\n", nickname)
+	fmt.Fprintf(writer, "Context:\t%s\n", createResults.BaseContext)
+	fmt.Fprintf(writer, "Cluster:\t%s\n", createResults.ClusterName)
+	fmt.Fprintf(writer, "Server:\t%s\n", createResults.ServerURL())
+	fmt.Fprintf(writer, "Namespace:\t%s\n", namespace)
+	fmt.Fprintf(writer, "User:\t%s\n", user)
+	fmt.Fprintf(writer, "kubectl:\t%s\n", createResults.KubectlExecutable)
+	fmt.Fprintf(writer, "KUBECONFIG:\t%s\n", createResults.NewKubeconfigEnvVar)
+	writer.Flush()
 }
 
-func getNicknameFromKsetArgs(ksetEnvValue string) string {
-	ksetArgs := getArgsFromKsetArgs(ksetEnvValue)
-	if len(ksetArgs) == 0 {
+// printPromptUpdate prints the temporary shell variables that the shell functions use to update
+// the shell prompt for the kset environment.
+func printPromptUpdate(nickname string, createResults *config.CreateConfigResults) {
+	promptPrefix := getPromptPrefix(nickname, createResults)
+	if promptPrefix != "" {
+		// Emit a temporary shell variable that describes the prefix to use on the shell prompt.
+		fmt.Printf("_KP=%s\n", shellQuote(promptPrefix))
+
+		// For a dangerous nickname, also emit a temporary shell variable with the ANSI SGR
+		// parameters the shell function uses to color the prompt prefix.
+		if createResults.Danger {
+			fmt.Printf("_KPC='%s'\n", config.GetKconfig().DangerPromptColor())
+		}
+	}
+}
+
+// getPromptPrefix returns the text to show in the shell prompt for the kset environment, or an
+// empty string if the prompt shouldn't be changed.
+func getPromptPrefix(nickname string, createResults *config.CreateConfigResults) string {
+	kconfig := config.GetKconfig()
+	if kconfig.Preferences.ChangePrompt != nil && !*kconfig.Preferences.ChangePrompt {
 		return ""
 	}
 
-	return ksetArgs[0]
+	promptPrefix := nickname
+	overridesDescription := createResults.OverridesDescription
+	if overridesDescription != "" && (kconfig.Preferences.ShowOverridesInPrompt == nil || *kconfig.Preferences.ShowOverridesInPrompt) {
+		if kconfig.Preferences.AlwaysShowNamespaceInPrompt && !strings.Contains(overridesDescription, "ns=") {
+			overridesDescription = fmt.Sprintf("ns=%s,%s", createResults.ContextNamespace, overridesDescription)
+		}
+		promptPrefix = fmt.Sprintf("%s[%s]", nickname, overridesDescription)
+
+	} else if kconfig.Preferences.AlwaysShowNamespaceInPrompt {
+		promptPrefix = fmt.Sprintf("%s[ns=%s]", nickname, createResults.ContextNamespace)
+	}
+
+	return promptPrefix
+}
+
+// printResolvedKset handles the --print-only option.  It prints the environment variable settings
+// that kset would make, as YAML comments, followed by the content of the session-local kubectl
+// config file that kset would write.  The output is therefore usable as a kubectl config file.
+// Nothing is written and no shell commands are emitted.
+func printResolvedKset(nickname string) {
+	createResults := config.ResolveLocalKubectlConfig(nickname, &ksetOptions.KconfigOptions, ksetOptions.Also)
+
+	localConfigFilename := config.GetExistingSessionLocalFilename(os.Getenv("KUBECONFIG"))
+	if localConfigFilename == "" {
+		localConfigFilename = "<new-session-file>"
+	}
+
+	fmt.Printf("# KUBECONFIG=%s\n", createResults.KubeconfigEnvVar(localConfigFilename))
+	if createResults.TeleportProxyEnvVar != "" {
+		fmt.Printf("# TELEPORT_PROXY=%s\n", createResults.TeleportProxyEnvVar)
+	}
+	for _, env := range ksetVariables(createResults) {
+		fmt.Printf("# %s\n", env)
+	}
+	fmt.Printf("# _KCONFIG_KUBECTL=%s\n", createResults.KubectlExecutable)
+	fmt.Printf("# _KCONFIG_KSET=%s\n", createKsetArgs(getKsetArgs(nickname)))
+	fmt.Printf("# %s=%s\n", namespaceEnvVar, createResults.ContextNamespace)
+	fmt.Printf("# %s=%s\n", contextEnvVar, createResults.BaseContext)
+	fmt.Printf("# %s=%s\n", clusterEnvVar, createResults.ClusterName)
+	fmt.Printf("# %s=%s\n", serverEnvVar, createResults.ServerURL())
+	if createResults.OpenShift {
+		fmt.Printf("# %s=true\n", openShiftEnvVar)
+	}
+	if promptPrefix := getPromptPrefix(nickname, createResults); promptPrefix != "" {
+		fmt.Printf("# prompt: (%s)\n", promptPrefix)
+	}
+	if createResults.Danger {
+		fmt.Println("# danger: true")
+	}
+
+	content, err := clientcmd.Write(*createResults.ConfigContent)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error formatting the session-local kubectl configuration: %v\n", err)
+		os.Exit(1)
+	}
+	os.Stdout.Write(content)
+}
+
+// getKsetArgs returns the arguments that describe the kset environment being set up: the nickname,
+// any overrides, any --also options, and any --output-file option, whose file is named by its
+// absolute path, so "kset -" writes the same file from any directory.
+func getKsetArgs(nickname string) []string {
+	args := append([]string{nickname}, ksetOptions.KconfigOptions.Args()...)
+	for _, alsoNickname := range ksetOptions.Also {
+		args = append(args, "--also", alsoNickname)
+	}
+	if ksetOptions.OutputFile != "" {
+		outputFile, err := filepath.Abs(ksetOptions.OutputFile)
+		if err != nil {
+			outputFile = ksetOptions.OutputFile
+		}
+		args = append(args, "--output-file", outputFile)
+	}
+	return args
 }
 
-func getArgsFromKsetArgs(ksetEnvValue string) []string {
+// printNicknameEnvironment prints the shell commands that set the environment variables of the
+// nickname's --env and --cache-dir options, and helm's, and unset those that the previous kset
+// environment set but this one doesn't.  Their names are recorded in the environment variable named
+// by nicknameEnvEnvVar, so the next kset, or koff, knows to unset them.
+func printNicknameEnvironment(environment []string) {
+	var names []string
+	setNames := make(map[string]bool)
+	for _, env := range environment {
+		name, value, _ := strings.Cut(env, "=")
+		names = append(names, name)
+		setNames[name] = true
+		fmt.Printf("export %s=%s\n", name, shellQuote(value))
+	}
+	for _, name := range strings.Fields(os.Getenv(nicknameEnvEnvVar)) {
+		if !setNames[name] {
+			fmt.Printf("unset %s\n", name)
+		}
+	}
+
+	if len(names) > 0 {
+		fmt.Printf("export %s=%s\n", nicknameEnvEnvVar, shellQuote(strings.Join(names, " ")))
+	} else {
+		fmt.Printf("unset %s\n", nicknameEnvEnvVar)
+	}
+}
+
+// announceTarget prints a one-line summary of the API server, namespace, and user of the new kset
+// environment to standard error, for the announce_target preference.  The server is given by its
+// host and port, which is what tells clusters apart.
+func announceTarget(createResults *config.CreateConfigResults) {
+	server := createResults.ServerURL()
+	if serverURL, err := url.Parse(server); err == nil && serverURL.Host != "" {
+		server = serverURL.Host
+	}
+	namespace := createResults.ContextNamespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	user := createResults.UserName()
+	if user == "" {
+		user = "(none)"
+	}
+	fmt.Fprintf(os.Stderr, "→ cluster %s, ns %s, user %s\n", server, namespace, user)
+}
+
+// createKsetArgs creates a string that describes the kset environment from its arguments, as
+// returned by getKsetArgs().  We'd like to properly quote the values in this string as a shell
+// would so that we can parse them again later, but sadly the github.com/google/shlex library that
+// we use for parsing a quoted string doesn't support quoting a string.  So instead we delimit the
+// fields with a simple blank character, *unless* a blank appears in any of the values.  In that
+// case, we use a delimiter that should not appear in the string, namely the "unit separator"
+// ASCII/Unicode control code, 0x1F.
+func createKsetArgs(args []string) string {
 	delimiter := " "
-	if strings.Contains(ksetEnvValue, ksetEnvVarDelimiter) {
-		delimiter = ksetEnvVarDelimiter
+	for _, arg := range args {
+		if strings.Contains(arg, " ") {
+			delimiter = config.KsetArgsDelimiter
+			break
+		}
 	}
-	return strings.Split(ksetEnvValue, delimiter)
+
+	return strings.Join(args, delimiter)
 }
 
 func init() {
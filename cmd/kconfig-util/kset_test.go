@@ -2,36 +2,52 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"go.uber.org/zap"
 	"gopkg.in/yaml.v3"
+	"k8s.io/client-go/tools/clientcmd"
 
 	"github.com/jphx/kconfig/common"
 	"github.com/jphx/kconfig/config"
 )
 
 const kconfigUtilCommand = "../../bin/kconfig-util"
+const kubectlCommand = "../../bin/kubectl"
 
 type TestCase struct {
 	Name                  string
 	Preferences           config.KconfigPreferences
 	CopyKconfigYaml       bool
+	CopyStateFile         bool
 	Arguments             []string
 	KsetEnvVar            string
 	OldKsetEnvVar         string
+	WorkingDir            string
 	ExpectError           string
 	ExpectKubeconfig      string
 	ExpectKubectlExe      string
 	ExpectPrompt          string
+	ExpectPromptColor     string
 	ExpectLocalConfigFile string
 	ExpectTeleportProxy   string
 }
@@ -385,6 +401,181 @@ var casesToTest = []TestCase{
 		ExpectLocalConfigFile: "1",
 		ExpectTeleportProxy:   "tport-proxy1",
 	},
+	{
+		Name:                  "Nickname extends another",
+		Preferences:           config.KconfigPreferences{},
+		CopyKconfigYaml:       true,
+		Arguments:             []string{"dev-extends"},
+		ExpectKubeconfig:      ".kube/config",
+		ExpectKubectlExe:      "kubectl",
+		ExpectPrompt:          "dev-extends",
+		ExpectLocalConfigFile: "4",
+	},
+	{
+		Name:                  "Nickname tagged as dangerous",
+		Preferences:           config.KconfigPreferences{},
+		CopyKconfigYaml:       true,
+		Arguments:             []string{"dev-danger"},
+		ExpectKubeconfig:      ".kube/config",
+		ExpectKubectlExe:      "kubectl",
+		ExpectPrompt:          "dev-danger",
+		ExpectPromptColor:     config.DefaultDangerPromptColor,
+		ExpectLocalConfigFile: "1",
+	},
+	{
+		Name: "Nickname matches danger pattern",
+		Preferences: config.KconfigPreferences{
+			DangerNicknames:   []string{"prod*", "dev-n*"},
+			DangerPromptColor: "41",
+		},
+		CopyKconfigYaml:       true,
+		Arguments:             []string{"dev-namespace"},
+		ExpectKubeconfig:      ".kube/config",
+		ExpectKubectlExe:      "kubectl",
+		ExpectPrompt:          "dev-namespace",
+		ExpectPromptColor:     "41",
+		ExpectLocalConfigFile: "2",
+	},
+	{
+		Name:                  "Nickname extends another with executable",
+		Preferences:           config.KconfigPreferences{},
+		CopyKconfigYaml:       true,
+		Arguments:             []string{"dev-extends-executable"},
+		ExpectKubeconfig:      ".kube/config",
+		ExpectKubectlExe:      "kubectl-99",
+		ExpectPrompt:          "dev-extends-executable",
+		ExpectLocalConfigFile: "2",
+	},
+	{
+		Name:            "Nickname extends cycle",
+		Preferences:     config.KconfigPreferences{},
+		CopyKconfigYaml: true,
+		Arguments:       []string{"cycle-one"},
+		ExpectError:     "cycle: cycle-one -> cycle-two -> cycle-one",
+	},
+	{
+		Name:            "Nickname extends undefined nickname",
+		Preferences:     config.KconfigPreferences{},
+		CopyKconfigYaml: true,
+		Arguments:       []string{"extends-undefined"},
+		ExpectError:     "Nickname \"doesnt-exist\" is not defined.  It's referenced by: extends-undefined",
+	},
+	{
+		Name:                  "Previous namespace for nickname",
+		Preferences:           config.KconfigPreferences{},
+		CopyKconfigYaml:       true,
+		CopyStateFile:         true,
+		Arguments:             []string{"dev", "-n", "-"},
+		ExpectKubeconfig:      ".kube/config",
+		ExpectKubectlExe:      "kubectl",
+		ExpectPrompt:          "dev[ns=namespace-override]",
+		ExpectLocalConfigFile: "2",
+	},
+	{
+		Name:            "No previous namespace for nickname",
+		Preferences:     config.KconfigPreferences{},
+		CopyKconfigYaml: true,
+		CopyStateFile:   true,
+		Arguments:       []string{"dev-user", "-n", "-"},
+		ExpectError:     "No previous namespace is recorded for nickname \"dev-user\".",
+	},
+	{
+		Name:                  "Environment from kset history",
+		Preferences:           config.KconfigPreferences{},
+		CopyKconfigYaml:       true,
+		CopyStateFile:         true,
+		Arguments:             []string{"@1"},
+		ExpectKubeconfig:      ".kube/config",
+		ExpectKubectlExe:      "kubectl",
+		ExpectPrompt:          "dev[ns=namespace-override]",
+		ExpectLocalConfigFile: "2",
+	},
+	{
+		Name:            "Missing kset history entry",
+		Preferences:     config.KconfigPreferences{},
+		CopyKconfigYaml: true,
+		CopyStateFile:   true,
+		Arguments:       []string{"@2"},
+		ExpectError:     "There is no entry 2 in the kset history.  It has 2 entries.",
+	},
+	{
+		Name:                  "Restore saved environment",
+		Preferences:           config.KconfigPreferences{},
+		CopyKconfigYaml:       true,
+		CopyStateFile:         true,
+		Arguments:             []string{"--restore", "work"},
+		ExpectKubeconfig:      ".kube/config",
+		ExpectKubectlExe:      "kubectl",
+		ExpectPrompt:          "dev[ns=namespace-override]",
+		ExpectLocalConfigFile: "2",
+	},
+	{
+		Name:                  "Restore saved environment with an output file",
+		Preferences:           config.KconfigPreferences{},
+		CopyKconfigYaml:       true,
+		CopyStateFile:         true,
+		Arguments:             []string{"--restore", "work-file"},
+		ExpectKubeconfig:      ".kube/config",
+		ExpectKubectlExe:      "kubectl",
+		ExpectPrompt:          "dev[ns=namespace-override]",
+		ExpectLocalConfigFile: "2",
+	},
+	{
+		Name:            "Restore missing saved environment",
+		Preferences:     config.KconfigPreferences{},
+		CopyKconfigYaml: true,
+		CopyStateFile:   true,
+		Arguments:       []string{"--restore", "doesnt-exist"},
+		ExpectError:     "No kset environment is saved as \"doesnt-exist\".",
+	},
+	{
+		Name: "Nickname from directory kconfig file",
+		Preferences: config.KconfigPreferences{
+			DirectoryKconfig: true,
+		},
+		CopyKconfigYaml:       true,
+		Arguments:             []string{},
+		WorkingDir:            filepath.Join("testdata", "project", "subdir"),
+		ExpectKubeconfig:      ".kube/config",
+		ExpectKubectlExe:      "kubectl",
+		ExpectPrompt:          "dev-namespace[ns=namespace-override]",
+		ExpectLocalConfigFile: "2",
+	},
+	{
+		Name:            "Directory kconfig file ignored without preference",
+		Preferences:     config.KconfigPreferences{},
+		CopyKconfigYaml: true,
+		Arguments:       []string{},
+		WorkingDir:      filepath.Join("testdata", "project", "subdir"),
+		ExpectError:     "A kconfig nickname must be specified",
+	},
+	{
+		Name:                  "Nickname has cluster",
+		Preferences:           config.KconfigPreferences{},
+		CopyKconfigYaml:       true,
+		Arguments:             []string{"dev-cluster"},
+		ExpectKubeconfig:      ".kube/config",
+		ExpectKubectlExe:      "kubectl",
+		ExpectPrompt:          "dev-cluster",
+		ExpectLocalConfigFile: "8",
+	},
+	{
+		Name:                  "Override cluster on command",
+		Preferences:           config.KconfigPreferences{},
+		CopyKconfigYaml:       true,
+		Arguments:             []string{"dev", "--cluster", "prod"},
+		ExpectKubeconfig:      ".kube/config",
+		ExpectKubectlExe:      "kubectl",
+		ExpectPrompt:          "dev[c=prod]",
+		ExpectLocalConfigFile: "8",
+	},
+	{
+		Name:            "Override cluster that doesn't exist",
+		Preferences:     config.KconfigPreferences{},
+		CopyKconfigYaml: true,
+		Arguments:       []string{"dev", "--cluster", "doesnt-exist"},
+		ExpectError:     "Cluster \"doesnt-exist\" doesn't exist.",
+	},
 }
 
 var testHomeDir string
@@ -406,6 +597,11 @@ func TestMain(m *testing.M) {
 		os.Exit(1)
 	}
 
+	// Make sure the locations of the files aren't affected by the environment of the tests.
+	for _, name := range []string{"KCONFIG_CONFIG", "KCONFIG_STATE_DIR", "XDG_CONFIG_HOME", "XDG_STATE_HOME", "XDG_RUNTIME_DIR"} {
+		os.Unsetenv(name)
+	}
+
 	// Enable debug-level logging
 	common.LoggingLevel.SetLevel(zap.DebugLevel)
 
@@ -413,16 +609,22 @@ func TestMain(m *testing.M) {
 	os.Exit(m.Run())
 }
 
-var extractKubeconfigEnvVar = regexp.MustCompile(`(?m)^export KUBECONFIG=(.*)$`)
-var extractTeleportProxyEnvVar = regexp.MustCompile(`(?m)^export TELEPORT_PROXY=(.*)$`)
-var extractKubectlExe = regexp.MustCompile(`(?m)^export _KCONFIG_KUBECTL=(.*)$`)
-var extractPrompt = regexp.MustCompile(`(?m)^_KP=(.*)$`)
+var extractKubeconfigEnvVar = regexp.MustCompile(`(?m)^export KUBECONFIG='(.*)'$`)
+var extractTeleportProxyEnvVar = regexp.MustCompile(`(?m)^export TELEPORT_PROXY='(.*)'$`)
+var extractKubectlExe = regexp.MustCompile(`(?m)^export _KCONFIG_KUBECTL='(.*)'$`)
+var extractPrompt = regexp.MustCompile(`(?m)^_KP='(.*)'$`)
+var extractPromptColor = regexp.MustCompile(`(?m)^_KPC='(.*)'$`)
 
 func TestKsetResults(t *testing.T) {
 	// Create a special /tmp directory for the files that are produced, to avoid the same /tmp
 	// directories that might be in use for real uses of the utility.
 	workarea := t.TempDir()
 
+	// The work area is removed after this test, and later tests use t.TempDir(), so TMPDIR is put
+	// back afterward.
+	originalTmpdir := os.Getenv("TMPDIR")
+	t.Cleanup(func() { os.Setenv("TMPDIR", originalTmpdir) })
+
 	fmt.Printf("Setting TMPDIR env var to test work area: %s\n", workarea)
 	err := os.Setenv("TMPDIR", workarea)
 	if err != nil {
@@ -430,12 +632,18 @@ func TestKsetResults(t *testing.T) {
 		os.Exit(1)
 	}
 
+	// The command may run in a different working directory, so find it by absolute path.
+	kconfigUtilPath, err := filepath.Abs(kconfigUtilCommand)
+	if err != nil {
+		t.Fatalf("Error calculating absolute path of \"%s\": %v", kconfigUtilCommand, err)
+	}
+
 	unscrubbedEnvVars := os.Environ()
 	environmentVars := unscrubbedEnvVars[:0] // Slice that shared underlying array
 
 	// Scrub some env vars from array, so they can't affect the tests
 	for _, value := range unscrubbedEnvVars {
-		if !strings.HasPrefix(value, "_KCONFIG_KSET") && !strings.HasPrefix(value, "_KCONFIG_OLDKSET") {
+		if !strings.HasPrefix(value, "_KCONFIG_KSET") && !strings.HasPrefix(value, "_KCONFIG_OLDKSET") && !strings.HasPrefix(value, "_KCONFIG_KSTACK") {
 			environmentVars = append(environmentVars, value)
 		}
 	}
@@ -450,6 +658,13 @@ func TestKsetResults(t *testing.T) {
 					return
 				}
 			}
+			if testCase.CopyStateFile {
+				err = copyConfigFile(t, "kconfig-state.yaml", nil)
+				if err != nil {
+					t.Errorf("Error copying \"kconfig-state.yaml\": %v", err)
+					return
+				}
+			}
 
 			argv := []string{
 				kconfigUtilCommand,
@@ -467,10 +682,11 @@ func TestKsetResults(t *testing.T) {
 				envVarsForTest = append(envVarsForTest, fmt.Sprintf("_KCONFIG_KSET=%s", testCase.KsetEnvVar))
 			}
 
-			cmd := exec.Command(argv[0], argv[1:]...)
+			cmd := exec.Command(kconfigUtilPath, argv[1:]...)
 			var stderr bytes.Buffer
 			cmd.Stderr = &stderr
 			cmd.Env = envVarsForTest
+			cmd.Dir = testCase.WorkingDir
 			outputBytes, err := cmd.Output()
 			if err != nil {
 				if testCase.ExpectError == "" {
@@ -528,250 +744,4844 @@ func TestKsetResults(t *testing.T) {
 	}
 }
 
-func verifyKubeconfigEnvVar(t *testing.T, output string, testCase *TestCase) (string, bool) {
-	match := extractKubeconfigEnvVar.FindStringSubmatch(output)
-	if match == nil {
-		t.Log("Couldn't find the KUBECONFIG environment variable in the output.")
-		t.Logf("output: %s", output)
-		t.Fail()
-		return "", true
-	}
-	value := match[1]
-	//t.Logf("Actual   KUBECONFIG: %s", value)
-	//t.Logf("Expected KUBECONFIG: %s", testCase.ExpectKubeconfig)
-	searchPath := strings.Split(value, string(os.PathListSeparator))
-	expectedPath := strings.Split(testCase.ExpectKubeconfig, string(os.PathListSeparator))
-	if len(expectedPath)+1 != len(searchPath) {
-		t.Log("The KUBECONFIG environment variable is not as expected.")
-		t.Logf("Expected: %s", testCase.ExpectKubeconfig)
-		t.Logf("Actual  : %s", value)
-		t.Fail()
-		return "", true
+func TestKsetStack(t *testing.T) {
+	testCases := []struct {
+		Name      string
+		Arguments []string
+		Env       []string
+		Expect    []string
+	}{
+		{
+			Name:      "Push the replaced environment",
+			Arguments: []string{"dev-namespace"},
+			Env:       []string{"KCONFIG_FEATURES=kset-stack", "_KCONFIG_KSET=dev", "_KCONFIG_OLDKSET=dev-user", "_KCONFIG_KSTACK="},
+			Expect:    []string{"export _KCONFIG_OLDKSET='dev'", "export _KCONFIG_KSTACK='dev-user'"},
+		},
+		{
+			Name:      "Pop the previous environment",
+			Arguments: []string{"-"},
+			Env:       []string{"KCONFIG_FEATURES=kset-stack", "_KCONFIG_KSET=dev", "_KCONFIG_OLDKSET=dev-namespace", "_KCONFIG_KSTACK=dev-user" + ksetStackDelimiter + "dev -n other"},
+			Expect:    []string{"export _KCONFIG_OLDKSET='dev-user'", "export _KCONFIG_KSTACK='dev -n other'", "export _KCONFIG_KSET='dev-namespace'"},
+		},
+		{
+			Name:      "Pop the last previous environment",
+			Arguments: []string{"-", "-n", "other"},
+			Env:       []string{"KCONFIG_FEATURES=kset-stack", "_KCONFIG_KSET=dev", "_KCONFIG_OLDKSET=dev-namespace", "_KCONFIG_KSTACK="},
+			Expect:    []string{"unset _KCONFIG_OLDKSET", "export _KCONFIG_KSET='dev-namespace -n other'"},
+		},
+		{
+			Name:      "Push without the kset-stack feature",
+			Arguments: []string{"dev-namespace"},
+			Env:       []string{"KCONFIG_FEATURES=", "_KCONFIG_KSET=dev", "_KCONFIG_OLDKSET=dev-user", "_KCONFIG_KSTACK="},
+			Expect:    []string{"export _KCONFIG_OLDKSET='dev'", "export _KCONFIG_KSET='dev-namespace'"},
+		},
+		{
+			Name:      "Toggle without the kset-stack feature",
+			Arguments: []string{"-"},
+			Env:       []string{"KCONFIG_FEATURES=", "_KCONFIG_KSET=dev", "_KCONFIG_OLDKSET=dev-namespace", "_KCONFIG_KSTACK="},
+			Expect:    []string{"export _KCONFIG_OLDKSET='dev'", "export _KCONFIG_KSET='dev-namespace'"},
+		},
 	}
 
-	for idx, actualElement := range searchPath[1:] {
-		expectedElement := expectedPath[idx]
-		if !strings.HasSuffix(actualElement, expectedElement) {
-			t.Logf("The KUBECONFIG environment variable is not as expected.  Element %d is different.", idx)
-			t.Logf("Expected suffix: %s", expectedElement)
-			t.Logf("Actual         : %s", actualElement)
-			t.Fail()
-			return "", true
-		}
+	workarea := t.TempDir()
+	err := copyConfigFile(t, "kconfig.yaml", nil)
+	if err != nil {
+		t.Fatalf("Error copying \"kconfig.yaml\": %v", err)
 	}
 
-	// Return the first element, which is the local kubectl config file, so the caller can examine
-	// it to make sure it's correct.
-	return searchPath[0], false
-}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			cmd := exec.Command(kconfigUtilCommand, append([]string{"kset"}, testCase.Arguments...)...)
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			cmd.Env = append(os.Environ(), fmt.Sprintf("TMPDIR=%s", workarea), "KUBECONFIG=")
+			cmd.Env = append(cmd.Env, testCase.Env...)
+			outputBytes, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("kset failed: %v\n%s", err, stderr.String())
+			}
 
-func verifyTeleportProxyEnvVar(t *testing.T, output string, testCase *TestCase) bool {
-	match := extractTeleportProxyEnvVar.FindStringSubmatch(output)
-	if match == nil {
-		if testCase.ExpectTeleportProxy == "" {
-			return false
-		}
-		t.Log("Couldn't find the TELEPORT_PROXY environment variable in the output.")
-		t.Logf("output: %s", output)
-		t.Fail()
-		return true
-	}
-	value := match[1]
-	if value != testCase.ExpectTeleportProxy {
-		t.Log("The TELEPORT_PROXY environment variable is not as expected.")
-		t.Logf("Expected: %s", testCase.ExpectTeleportProxy)
-		t.Logf("Actual  : %s", value)
-		t.Fail()
-		return true
+			output := string(outputBytes)
+			for _, expected := range testCase.Expect {
+				if !strings.Contains(output, expected+"\n") {
+					t.Errorf("Output doesn't contain \"%s\".  It's:\n%s", expected, output)
+				}
+			}
+		})
 	}
-	return false
 }
 
-func verifyKubectlExe(t *testing.T, output string, testCase *TestCase) bool {
-	match := extractKubectlExe.FindStringSubmatch(output)
-	if match == nil {
-		t.Log("Couldn't find the kubectl executable name in the output.")
-		t.Logf("output: %s", output)
-		t.Fail()
-		return true
-	}
-	value := match[1]
-	if value != testCase.ExpectKubectlExe {
-		t.Log("The kubectl executable name is not as expected.")
-		t.Logf("Expected: %s", testCase.ExpectKubectlExe)
-		t.Logf("Actual  : %s", value)
-		t.Fail()
-		return true
+func TestKoffAll(t *testing.T) {
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte("nicknames:\n  dev: --context dev\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
 	}
-	return false
-}
+	stackEnv := []string{"KCONFIG_FEATURES=kset-stack", "_KCONFIG_KSET=dev", "_KCONFIG_OLDKSET=dev -n other", "_KCONFIG_KSTACK=dev -n third"}
 
-func verifyPrompt(t *testing.T, output string, testCase *TestCase) bool {
-	match := extractPrompt.FindStringSubmatch(output)
-	if match == nil {
-		if testCase.ExpectPrompt == "" {
-			// This is expected.
-			return false
-		}
-		t.Log("Couldn't find the prompt info in the output.")
-		t.Logf("output: %s", output)
-		t.Fail()
-		return true
+	testCases := []struct {
+		Name     string
+		Args     []string
+		Env      []string
+		Expect   []string
+		Unexpect []string
+	}{
+		{
+			Name:     "Keep the stack",
+			Args:     []string{"koff"},
+			Env:      append([]string{"KUBECONFIG=/some/config"}, stackEnv...),
+			Expect:   []string{"unset KUBECONFIG", "export _KCONFIG_OLDKSET='dev'"},
+			Unexpect: []string{"unset _KCONFIG_KSTACK"},
+		},
+		{
+			Name:   "Clear the stack",
+			Args:   []string{"koff", "--all"},
+			Env:    append([]string{"KUBECONFIG=/some/config"}, stackEnv...),
+			Expect: []string{"unset KUBECONFIG", "unset _KCONFIG_OLDKSET", "unset _KCONFIG_KSTACK"},
+		},
+		{
+			Name:   "Clear the stack without an environment",
+			Args:   []string{"koff", "--all"},
+			Env:    []string{"KUBECONFIG=", "_KCONFIG_KSET=", "_KCONFIG_OLDKSET=dev", "_KCONFIG_KSTACK="},
+			Expect: []string{"unset _KCONFIG_OLDKSET"},
+		},
 	}
-	value := match[1]
-	if value != testCase.ExpectPrompt {
-		t.Log("The kset prompt is not as expected.")
-		t.Logf("Expected: %s", testCase.ExpectPrompt)
-		t.Logf("Actual  : %s", value)
-		t.Fail()
-		return true
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			cmd := exec.Command(kconfigUtilCommand, testCase.Args...)
+			cmd.Env = append(os.Environ(), testCase.Env...)
+			output, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("%v failed: %v", testCase.Args, err)
+			}
+			for _, expected := range testCase.Expect {
+				if !strings.Contains(string(output), expected+"\n") {
+					t.Errorf("Output doesn't contain \"%s\".  It's:\n%s", expected, output)
+				}
+			}
+			for _, unexpected := range testCase.Unexpect {
+				if strings.Contains(string(output), unexpected+"\n") {
+					t.Errorf("Output shouldn't contain \"%s\".  It's:\n%s", unexpected, output)
+				}
+			}
+		})
 	}
-	return false
 }
 
-func verifyLocalKubectlConfigFile(t *testing.T, localConfigFileName string, testCase *TestCase) bool {
-	expectedLocalConfigFilePath := filepath.Join("testdata", "configs", fmt.Sprintf("local-config.%s.yaml", testCase.ExpectLocalConfigFile))
-	expectedContents, err := readYamlFile(expectedLocalConfigFilePath)
+func TestKoffRestoresKubeconfig(t *testing.T) {
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte("nicknames:\n  dev: --context dev\n"), 0644)
 	if err != nil {
-		t.Errorf("Error examining expected local kubectl config file: %v", err)
-		if errors.Is(err, os.ErrNotExist) {
-			// Dump the actual file, to help the developer prime the "expected" file after the first run.
-			t.Log("Actual local config file:")
-			rawContents, err := os.ReadFile(localConfigFileName)
-			if err != nil {
-				t.Logf("... Error reading local config file \"%s\":  %v", localConfigFileName, err)
-			} else {
-				t.Log("\n" + string(rawContents))
-			}
-		}
-		return true
+		t.Fatal(err)
 	}
 
-	actualContents, err := readYamlFile(localConfigFileName)
-	if err != nil {
-		t.Errorf("Error examining actual local kubectl config file: %v", err)
+	testCases := []struct {
+		Name     string
+		Args     []string
+		Env      []string
+		Expect   []string
+		Unexpect []string
+	}{
+		{
+			Name:   "First kset records KUBECONFIG",
+			Args:   []string{"kset", "dev"},
+			Env:    []string{"KUBECONFIG=/orig/a.yaml:/orig/b.yaml", "_KCONFIG_KSET="},
+			Expect: []string{"export _KCONFIG_ORIG_KUBECONFIG='/orig/a.yaml:/orig/b.yaml'"},
+		},
+		{
+			Name:   "First kset without KUBECONFIG",
+			Args:   []string{"kset", "dev"},
+			Env:    []string{"KUBECONFIG=", "_KCONFIG_KSET="},
+			Expect: []string{"unset _KCONFIG_ORIG_KUBECONFIG"},
+		},
+		{
+			Name:     "Later kset keeps the recorded KUBECONFIG",
+			Args:     []string{"kset", "dev"},
+			Env:      []string{"KUBECONFIG=", "_KCONFIG_KSET=dev", "_KCONFIG_ORIG_KUBECONFIG=/orig/a.yaml"},
+			Unexpect: []string{"_KCONFIG_ORIG_KUBECONFIG"},
+		},
+		{
+			Name:   "koff restores the recorded KUBECONFIG",
+			Args:   []string{"koff"},
+			Env:    []string{"KUBECONFIG=/some/config", "_KCONFIG_ORIG_KUBECONFIG=/orig/a.yaml:/orig/b.yaml"},
+			Expect: []string{"export KUBECONFIG='/orig/a.yaml:/orig/b.yaml'"},
+		},
+		{
+			Name:   "koff without a recorded KUBECONFIG",
+			Args:   []string{"koff"},
+			Env:    []string{"KUBECONFIG=/some/config", "_KCONFIG_ORIG_KUBECONFIG="},
+			Expect: []string{"unset KUBECONFIG"},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			cmd := exec.Command(kconfigUtilCommand, testCase.Args...)
+			cmd.Env = append(os.Environ(), testCase.Env...)
+			output, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("%v failed: %v", testCase.Args, err)
+			}
+			for _, expected := range testCase.Expect {
+				if !strings.Contains(string(output), expected+"\n") {
+					t.Errorf("Output doesn't contain \"%s\".  It's:\n%s", expected, output)
+				}
+			}
+			for _, unexpected := range testCase.Unexpect {
+				if strings.Contains(string(output), unexpected) {
+					t.Errorf("Output shouldn't contain \"%s\".  It's:\n%s", unexpected, output)
+				}
+			}
+		})
+	}
+}
+
+func TestInheritKubeconfigEnv(t *testing.T) {
+	extraFilename := filepath.Join(t.TempDir(), "extra.yaml")
+	err := os.WriteFile(extraFilename, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: extra
+  cluster:
+    server: http://extra-cluster/
+contexts:
+- name: extra
+  context:
+    cluster: extra
+    user: extrauser
+users:
+- name: extrauser
+  user: {}
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Name        string
+		Preferences string
+		Env         []string
+		Success     bool
+	}{
+		{
+			Name:        "Not inherited",
+			Preferences: "{}",
+			Env:         []string{"KUBECONFIG=" + extraFilename, "_KCONFIG_KSET="},
+		},
+		{
+			Name:        "Inherited",
+			Preferences: "{inherit_kubeconfig_env: true, features: {inherit-kubeconfig-env: true}}",
+			Env:         []string{"KUBECONFIG=" + extraFilename, "_KCONFIG_KSET="},
+			Success:     true,
+		},
+		{
+			Name:        "Inherited from before the first kset",
+			Preferences: "{inherit_kubeconfig_env: true}",
+			Env:         []string{"KCONFIG_FEATURES=inherit-kubeconfig-env", "KUBECONFIG=/some/session.yaml:/other/config", "_KCONFIG_KSET=dev", config.OrigKubeconfigEnvVar + "=" + extraFilename},
+			Success:     true,
+		},
+		{
+			Name:        "Not inherited without the feature",
+			Preferences: "{inherit_kubeconfig_env: true}",
+			Env:         []string{"KUBECONFIG=" + extraFilename, "_KCONFIG_KSET="},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte("preferences: "+testCase.Preferences+"\nnicknames:\n  ex: --context extra\n"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cmd := exec.Command(kconfigUtilCommand, "kset", "ex")
+			cmd.Env = append(os.Environ(), testCase.Env...)
+			output, err := cmd.Output()
+			if !testCase.Success {
+				if err == nil {
+					t.Errorf("kset should fail, since the context isn't in the default search path.  The output is:\n%s", output)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("kset failed: %v", err)
+			}
+			matches := extractKubeconfigEnvVar.FindStringSubmatch(string(output))
+			if matches == nil || !strings.HasSuffix(matches[1], string(os.PathListSeparator)+extraFilename) {
+				t.Errorf("The session-local file isn't prepended to the inherited search path.  The output is:\n%s", output)
+			}
+		})
+	}
+}
+
+func TestPreferFile(t *testing.T) {
+	dir := t.TempDir()
+	var filenames []string
+	for _, name := range []string{"first", "second"} {
+		filename := filepath.Join(dir, name+".yaml")
+		err := os.WriteFile(filename, []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: dup
+  cluster:
+    server: http://%s-cluster/
+contexts:
+- name: dup
+  context:
+    cluster: dup
+    user: dupuser
+    namespace: %s-namespace
+users:
+- name: dupuser
+  user:
+    token: %s-token
+`, name, name, name)), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filenames = append(filenames, filename)
+	}
+	searchPath := strings.Join(filenames, string(os.PathListSeparator))
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(fmt.Sprintf(`nicknames:
+  dup: --kubeconfig %s --context dup
+  dup-second: --kubeconfig %s --context dup --prefer-file %s
+`, searchPath, searchPath, filenames[1])), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Name     string
+		Args     []string
+		Expect   []string
+		Unexpect []string
+	}{
+		{
+			Name:     "First file wins",
+			Args:     []string{"kset", "dup", "--print-only"},
+			Expect:   []string{"current-context: dup"},
+			Unexpect: []string{"second-"},
+		},
+		{
+			Name:     "Preferred file in the definition",
+			Args:     []string{"kset", "dup-second", "--print-only"},
+			Expect:   []string{"server: http://second-cluster/", "namespace: second-namespace", "token: second-token", "# _KCONFIG_NAMESPACE=second-namespace"},
+			Unexpect: []string{"first-"},
+		},
+		{
+			Name:     "Preferred file on the command line",
+			Args:     []string{"kset", "dup", "--prefer-file", filenames[1], "--print-only"},
+			Expect:   []string{"server: http://second-cluster/", "token: second-token"},
+			Unexpect: []string{"first-"},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			cmd := exec.Command(kconfigUtilCommand, testCase.Args...)
+			output, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("%v failed: %v", testCase.Args, err)
+			}
+			for _, expected := range testCase.Expect {
+				if !strings.Contains(string(output), expected+"\n") {
+					t.Errorf("Output doesn't contain \"%s\".  It's:\n%s", expected, output)
+				}
+			}
+			for _, unexpected := range testCase.Unexpect {
+				if strings.Contains(string(output), unexpected) {
+					t.Errorf("Output shouldn't contain \"%s\".  It's:\n%s", unexpected, output)
+				}
+			}
+		})
+	}
+
+	err = exec.Command(kconfigUtilCommand, "kset", "dup", "--prefer-file", filepath.Join(dir, "missing.yaml"), "--print-only").Run()
+	if err == nil {
+		t.Errorf("kset should fail when the preferred file doesn't exist.")
+	}
+}
+
+func TestContexts(t *testing.T) {
+	dir := t.TempDir()
+	firstFilename := filepath.Join(dir, "first.yaml")
+	secondFilename := filepath.Join(dir, "second.yaml")
+	err := os.WriteFile(firstFilename, []byte(`apiVersion: v1
+kind: Config
+current-context: one
+contexts:
+- name: one
+  context: {cluster: c1, user: u1, namespace: ns1}
+- name: dup
+  context: {cluster: c1, user: u1}
+`), 0600)
+	if err == nil {
+		err = os.WriteFile(secondFilename, []byte(`apiVersion: v1
+kind: Config
+contexts:
+- name: dup
+  context: {cluster: c2, user: u2}
+- name: two
+  context: {cluster: c2, user: u2, namespace: ns2}
+`), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	searchPath := firstFilename + string(os.PathListSeparator) + secondFilename
+	err = os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(fmt.Sprintf("nicknames:\n  both: --kubeconfig %s --context two\n", searchPath)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(kconfigUtilCommand, "contexts", "both")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("contexts failed: %v\n%s", err, stderr.String())
+	}
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	expected := [][]string{
+		{"CURRENT", "NAME", "CLUSTER", "NAMESPACE", "USER", "FILE"},
+		{"dup", "c1", "u1", firstFilename},
+		{"one", "c1", "ns1", "u1", firstFilename},
+		{"*", "two", "c2", "ns2", "u2", secondFilename},
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines.  The output is:\n%s", len(expected), output)
+	}
+	for idx, fields := range expected {
+		if actual := strings.Fields(lines[idx]); strings.Join(actual, " ") != strings.Join(fields, " ") {
+			t.Errorf("Expected line %d to have the fields %v.  It's: %s", idx, fields, lines[idx])
+		}
+	}
+	expectedWarning := fmt.Sprintf("Warning: Context \"dup\" is also defined in \"%s\", but kubectl uses the one in \"%s\".\n", secondFilename, firstFilename)
+	if stderr.String() != expectedWarning {
+		t.Errorf("Expected the warning:\n%s\nActual standard error:\n%s", expectedWarning, stderr.String())
+	}
+
+	output, err = exec.Command(kconfigUtilCommand, "contexts", "--names", "--kubeconfig", searchPath).Output()
+	if err != nil || string(output) != "dup\none\ntwo\n" {
+		t.Errorf("contexts --names printed (%v):\n%s", err, output)
+	}
+
+	err = exec.Command(kconfigUtilCommand, "contexts", "both", "--kubeconfig", searchPath).Run()
+	if err == nil {
+		t.Errorf("contexts should fail when both a nickname and --kubeconfig are given.")
+	}
+}
+
+func TestExtract(t *testing.T) {
+	dir := t.TempDir()
+	kubeconfigFilename := filepath.Join(dir, "config.yaml")
+	files := map[string]string{
+		"ca.crt": "the CA certificate",
+		"token":  "the-token\n",
+		"config.yaml": `apiVersion: v1
+kind: Config
+clusters:
+- name: remote
+  cluster:
+    server: https://remote-cluster/
+    certificate-authority: ca.crt
+contexts:
+- name: remote
+  context: {cluster: remote, user: remote-user, namespace: apps}
+users:
+- name: remote-user
+  user:
+    tokenFile: token
+`,
+	}
+	for name, contents := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(fmt.Sprintf("nicknames:\n  remote: --kubeconfig %s --context remote\n", kubeconfigFilename)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputFilename := filepath.Join(dir, "extracted.yaml")
+	cmd := exec.Command(kconfigUtilCommand, "extract", "remote", "-n", "other", "--context-name", "shared", "--output-file", outputFilename)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		t.Fatalf("extract failed: %v\n%s", err, stderr.String())
+	}
+
+	info, err := os.Stat(outputFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		t.Errorf("The extracted file can be read by other users.  Its mode is %v.", info.Mode())
+	}
+	extracted, err := clientcmd.LoadFromFile(outputFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	context := extracted.Contexts["shared"]
+	cluster := extracted.Clusters["shared"]
+	user := extracted.AuthInfos["shared"]
+	switch {
+	case extracted.CurrentContext != "shared" || context == nil || cluster == nil || user == nil:
+		t.Errorf("The extracted file doesn't define the context, cluster, and user \"shared\": %+v", extracted)
+	case len(extracted.Contexts) != 1 || len(extracted.Clusters) != 1 || len(extracted.AuthInfos) != 1:
+		t.Errorf("The extracted file defines more than the nickname's context, cluster, and user: %+v", extracted)
+	case context.Namespace != "other" || context.Cluster != "shared" || context.AuthInfo != "shared":
+		t.Errorf("The extracted context is wrong: %+v", context)
+	case cluster.Server != "https://remote-cluster/" || string(cluster.CertificateAuthorityData) != "the CA certificate" || cluster.CertificateAuthority != "":
+		t.Errorf("The certificate authority isn't embedded in the extracted cluster: %+v", cluster)
+	case user.Token != "the-token" || user.TokenFile != "":
+		t.Errorf("The token isn't embedded in the extracted user: %+v", user)
+	}
+
+	err = exec.Command(kconfigUtilCommand, "extract", "remote", "--output-file", kubeconfigFilename).Run()
+	if err == nil {
+		t.Errorf("extract should refuse to replace a file in the search path.")
+	}
+}
+
+func TestKsetSelfContained(t *testing.T) {
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte("nicknames:\n  dev: --context dev\n  dev-sc: --context dev --self-contained\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{{"kset", "dev", "--self-contained"}, {"kset", "dev-sc"}} {
+		cmd := exec.Command(kconfigUtilCommand, args...)
+		cmd.Env = append(os.Environ(), "KUBECONFIG=", "_KCONFIG_KSET=", "KCONFIG_STATE_DIR="+t.TempDir(), "KCONFIG_FEATURES=self-contained")
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%v failed: %v", args, err)
+		}
+		matches := extractKubeconfigEnvVar.FindStringSubmatch(string(output))
+		if matches == nil || strings.ContainsRune(matches[1], os.PathListSeparator) {
+			t.Fatalf("%v didn't set KUBECONFIG to just the session-local file.  The output is:\n%s", args, output)
+		}
+
+		sessionConfig, err := clientcmd.LoadFromFile(matches[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		context := sessionConfig.Contexts[sessionConfig.CurrentContext]
+		if context == nil || sessionConfig.Clusters[context.Cluster] == nil || sessionConfig.AuthInfos[context.AuthInfo] == nil {
+			t.Fatalf("%v: The session-local file doesn't define the context, cluster, and user: %+v", args, sessionConfig)
+		}
+		if server := sessionConfig.Clusters[context.Cluster].Server; server != "http://dev-cluster/" {
+			t.Errorf("%v: The server is \"%s\".", args, server)
+		}
+		if token := sessionConfig.AuthInfos[context.AuthInfo].Token; token != "devuser1-token" {
+			t.Errorf("%v: The token is \"%s\".", args, token)
+		}
+	}
+
+	cmd := exec.Command(kconfigUtilCommand, "kset", "dev-sc")
+	cmd.Env = append(os.Environ(), "KUBECONFIG=", "_KCONFIG_KSET=", "KCONFIG_STATE_DIR="+t.TempDir(), "KCONFIG_FEATURES=")
+	output, err := cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(output), `requires the "self-contained" feature`) {
+		t.Errorf("kset of a self-contained nickname should fail without the feature.  The output is:\n%s", output)
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	testCases := []struct {
+		Name        string
+		Preferences string
+		Env         string
+		Expect      []string
+	}{
+		{
+			Name:        "Default",
+			Preferences: "{}",
+			Expect:      []string{"kset-stack: disabled (default)", "self-contained: disabled (default)"},
+		},
+		{
+			Name:        "Preferences",
+			Preferences: "{features: {kset-stack: true, self-contained: false}}",
+			Expect:      []string{"kset-stack: enabled (preferences)", "self-contained: disabled (preferences)"},
+		},
+		{
+			Name:        "Environment",
+			Preferences: "{}",
+			Env:         "also-contexts,self-contained=true",
+			Expect:      []string{"also-contexts: enabled (environment)", "self-contained: enabled (environment)", "kset-stack: disabled (default)"},
+		},
+		{
+			Name:        "Environment over preferences",
+			Preferences: "{features: {kset-stack: true, self-contained: false}}",
+			Env:         "-kset-stack,self-contained",
+			Expect:      []string{"kset-stack: disabled (environment)", "self-contained: enabled (environment)"},
+		},
+		{
+			Name:        "Unknown",
+			Preferences: "{features: {no-such-feature: true}}",
+			Env:         "another-feature",
+			Expect:      []string{"another-feature: unknown to this version of kconfig", "no-such-feature: unknown to this version of kconfig"},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte("preferences: "+testCase.Preferences+"\nnicknames:\n  dev: --context dev\n"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cmd := exec.Command(kconfigUtilCommand, "features")
+			cmd.Env = append(os.Environ(), "KCONFIG_FEATURES="+testCase.Env)
+			output, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("features failed: %v", err)
+			}
+			for _, expected := range testCase.Expect {
+				if !strings.Contains(string(output), expected+"\n") {
+					t.Errorf("Output doesn't contain \"%s\".  It's:\n%s", expected, output)
+				}
+			}
+		})
+	}
+}
+
+func TestDockerArgs(t *testing.T) {
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte("nicknames:\n  dev: --context dev\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateDir := t.TempDir()
+
+	cmd := exec.Command(kconfigUtilCommand, "docker-args", "dev", "--container-path", "/home/tools/.kube/config")
+	cmd.Env = append(os.Environ(), "KCONFIG_STATE_DIR="+stateDir)
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("docker-args failed: %v", err)
+	}
+	hostFilename := filepath.Join(stateDir, "containers", "dev.yaml")
+	expected := fmt.Sprintf("-v %s:/home/tools/.kube/config:ro -e KUBECONFIG=/home/tools/.kube/config\n", hostFilename)
+	if string(output) != expected {
+		t.Fatalf("The output of docker-args is \"%s\" instead of \"%s\".", output, expected)
+	}
+
+	containerConfig, err := clientcmd.LoadFromFile(hostFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	context := containerConfig.Contexts[containerConfig.CurrentContext]
+	if context == nil || containerConfig.Clusters[context.Cluster] == nil || containerConfig.AuthInfos[context.AuthInfo] == nil {
+		t.Fatalf("The kubectl config file doesn't define the context, cluster, and user: %+v", containerConfig)
+	}
+	if server := containerConfig.Clusters[context.Cluster].Server; server != "http://dev-cluster/" {
+		t.Errorf("The server is \"%s\".", server)
+	}
+	if token := containerConfig.AuthInfos[context.AuthInfo].Token; token != "devuser1-token" {
+		t.Errorf("The token is \"%s\".", token)
+	}
+
+	cmd = exec.Command(kconfigUtilCommand, "docker-args", "dev", "--container-path", "config")
+	cmd.Env = append(os.Environ(), "KCONFIG_STATE_DIR="+stateDir)
+	if err := cmd.Run(); err == nil {
+		t.Error("docker-args accepted a relative --container-path.")
+	}
+}
+
+func TestRelocate(t *testing.T) {
+	kconfigFilename := filepath.Join(testHomeDir, ".kube", "kconfig.yaml")
+	err := os.WriteFile(kconfigFilename, []byte("nicknames:\n  dev: --context dev\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateDir := t.TempDir()
+	env := append(os.Environ(), "KCONFIG_STATE_DIR="+stateDir)
+
+	// Two shells have kset environments before the session_dir preference is set.
+	var kubeconfigs []string
+	for i := 0; i < 2; i++ {
+		cmd := exec.Command(kconfigUtilCommand, "kset", "dev")
+		cmd.Env = append(env, "KUBECONFIG=", "_KCONFIG_KSET=")
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("kset failed: %v", err)
+		}
+		matches := extractKubeconfigEnvVar.FindStringSubmatch(string(output))
+		if matches == nil {
+			t.Fatalf("kset didn't set KUBECONFIG.  The output is:\n%s", output)
+		}
+		kubeconfigs = append(kubeconfigs, matches[1])
+	}
+
+	sessionDir := filepath.Join(t.TempDir(), "sessions")
+	err = os.WriteFile(kconfigFilename, []byte(fmt.Sprintf("preferences:\n  session_dir: %s\nnicknames:\n  dev: --context dev\n", sessionDir)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, kubeconfig := range kubeconfigs {
+		oldFilename := filepath.SplitList(kubeconfig)[0]
+		cmd := exec.Command(kconfigUtilCommand, "relocate")
+		cmd.Env = append(env, "KUBECONFIG="+kubeconfig)
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("relocate failed: %v", err)
+		}
+		newFilename := filepath.Join(sessionDir, filepath.Base(oldFilename))
+		expected := fmt.Sprintf("export KUBECONFIG='%s%s'\n", newFilename, strings.TrimPrefix(kubeconfig, oldFilename))
+		if string(output) != expected {
+			t.Errorf("The output of relocate is \"%s\" instead of \"%s\".", output, expected)
+		}
+		if _, err := clientcmd.LoadFromFile(newFilename); err != nil {
+			t.Errorf("The session-local file wasn't moved: %v", err)
+		}
+		if _, err := os.Lstat(oldFilename); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("The old session-local file \"%s\" wasn't removed: %v", oldFilename, err)
+		}
+
+		// The other shell's environment still works until relocate is run there too.
+		if i == 0 {
+			otherFilename := filepath.SplitList(kubeconfigs[1])[0]
+			if target, err := os.Readlink(otherFilename); err != nil || filepath.Dir(target) != sessionDir {
+				t.Errorf("\"%s\" isn't a link to the new session directory: %s, %v", otherFilename, target, err)
+			}
+		}
+	}
+}
+
+func TestKubectlWindowsPaths(t *testing.T) {
+	workarea := t.TempDir()
+	binDir := filepath.Join(workarea, "bin")
+	err := os.MkdirAll(binDir, 0755)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(binDir, "kubectl.exe"), []byte("#!/bin/sh\necho \"$KUBECONFIG\"\necho \"$WSLENV\"\n"), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(binDir, "wslpath"), []byte("#!/bin/sh\necho \"W:$2\"\n"), 0755)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kubeconfig := filepath.Join(testHomeDir, ".kube", "config")
+	missing := filepath.Join(workarea, "missing.yaml")
+	for _, testCase := range []struct {
+		pathStyle  string
+		translated bool
+	}{
+		{"", true},
+		{"wsl", false},
+	} {
+		kconfigYaml := fmt.Sprintf("preferences:\n  path_style: %s\nnicknames:\n  win: kubectl.exe --kubeconfig %s:%s --context dev\n", testCase.pathStyle, kubeconfig, missing)
+		err = os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cmd := exec.Command(kubectlCommand, "-k", "win", "version")
+		cmd.Env = append(os.Environ(), "TMPDIR="+workarea, "PATH="+binDir+":/usr/bin:/bin", "WSL_DISTRO_NAME=Test", "WSLENV=GOPATH/l")
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("kubectl -k win failed with path_style \"%s\": %v", testCase.pathStyle, err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("Unexpected output with path_style \"%s\": %s", testCase.pathStyle, output)
+		}
+		if testCase.translated {
+			if !strings.HasPrefix(lines[0], "W:") || !strings.HasSuffix(lines[0], ";W:"+kubeconfig) {
+				t.Errorf("KUBECONFIG wasn't translated to Windows paths: %s", lines[0])
+			}
+			if lines[1] != "GOPATH/l:KUBECONFIG:TELEPORT_PROXY" {
+				t.Errorf("WSLENV is \"%s\".", lines[1])
+			}
+		} else {
+			if !strings.HasSuffix(lines[0], ":"+kubeconfig+":"+missing) || lines[1] != "GOPATH/l" {
+				t.Errorf("The environment shouldn't change with path_style \"wsl\": %s", output)
+			}
+		}
+	}
+}
+
+func TestInstall(t *testing.T) {
+	rcFilename := filepath.Join(t.TempDir(), ".bashrc")
+	original := "export EDITOR=vi"
+	err := os.WriteFile(rcFilename, []byte(original), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) string {
+		output, err := exec.Command(kconfigUtilCommand, args...).Output()
+		if err != nil {
+			t.Fatalf("%v failed: %v", args, err)
+		}
+		contents, err := os.ReadFile(rcFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(contents), original) {
+			t.Errorf("%v changed the rest of the file: %s", args, output)
+		}
+		return string(contents)
+	}
+
+	contents := run("install", "--shell", "bash", "--rc-file", rcFilename)
+	if !strings.Contains(contents, "# >>> kconfig >>>\n") || !strings.Contains(contents, `eval "$(kconfig-util init bash)"`) {
+		t.Errorf("install didn't add the setup:\n%s", contents)
+	}
+	if again := run("install", "--shell", "bash", "--rc-file", rcFilename); again != contents {
+		t.Errorf("Running install again changed the file:\n%s", again)
+	}
+
+	contents = run("install", "--shell", "bash", "--rc-file", rcFilename, "--kset-name", "kc")
+	if strings.Count(contents, "# >>> kconfig >>>") != 1 || !strings.Contains(contents, `eval "$(kconfig-util init bash --kset-name kc)"`) {
+		t.Errorf("install didn't replace the setup:\n%s", contents)
+	}
+	if info, err := os.Stat(rcFilename); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("install changed the permissions of the file: %v, %v", info.Mode(), err)
+	}
+
+	if contents = run("uninstall", "--rc-file", rcFilename); contents != original+"\n" {
+		t.Errorf("uninstall left this behind:\n%s", contents)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	home := t.TempDir()
+	kconfigFilename := filepath.Join(home, ".kube", "kconfig.yaml")
+	kubeconfig := filepath.Join(testHomeDir, ".kube", "config")
+	missing := filepath.Join(home, "missing.yaml")
+	kconfigYaml := fmt.Sprintf(`nicknames:
+  dev: --kubeconfig %s --context dev
+  gone: --kubeconfig %s --context gone
+  # The prod cluster.
+  prod: --kubeconfig %s --context prod
+  moved: --kubeconfig %s --context dev
+  stage: --kubeconfig %s --context stage
+`, kubeconfig, kubeconfig, kubeconfig, missing, kubeconfig)
+	err := os.MkdirAll(filepath.Join(home, ".kube", "kconfig-cache"), 0700)
+	if err == nil {
+		err = os.WriteFile(kconfigFilename, []byte(kconfigYaml), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The prod API server has been unreachable for 40 days, and the stage one for only 10.
+	pingHistory := fmt.Sprintf(`{"http://prod-cluster/":{"unreachable_since":"%s","last_error":"no such host"},"http://stage-cluster/":{"unreachable_since":"%s","last_error":"no such host"}}`,
+		time.Now().Add(-40*24*time.Hour).Format(time.RFC3339), time.Now().Add(-10*24*time.Hour).Format(time.RFC3339))
+	err = os.WriteFile(filepath.Join(home, ".kube", "kconfig-cache", "ping.json"), []byte(pingHistory), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) (string, error) {
+		cmd := exec.Command(kconfigUtilCommand, args...)
+		cmd.Env = append(os.Environ(), "HOME="+home)
+		output, err := cmd.Output()
+		return string(output), err
+	}
+
+	output, err := run("prune", "--check")
+	if err == nil {
+		t.Errorf("prune --check didn't fail.")
+	}
+	for _, expected := range []string{"gone: Context \"gone\" doesn't exist.", "moved: The kubectl config file \"" + missing + "\" no longer exists.", "prod: The API server http://prod-cluster/ has been unreachable since"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("The output of prune --check doesn't contain \"%s\":\n%s", expected, output)
+		}
+	}
+	if strings.Contains(output, "dev:") || strings.Contains(output, "stage:") {
+		t.Errorf("prune --check lists nicknames that aren't stale:\n%s", output)
+	}
+
+	_, err = run("prune", "--comment")
+	if err != nil {
+		t.Fatalf("prune --comment failed: %v", err)
+	}
+	contents, err := os.ReadFile(kconfigFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"# gone: --kubeconfig", "# The prod cluster.\n  # prod: --kubeconfig", "# moved: --kubeconfig", "\n  dev: --kubeconfig", "\n  stage: --kubeconfig"} {
+		if !strings.Contains(string(contents), expected) {
+			t.Errorf("kconfig.yaml doesn't contain \"%s\" after prune --comment:\n%s", expected, contents)
+		}
+	}
+
+	output, err = run("prune", "--check")
+	if err != nil || !strings.Contains(output, "No nicknames look stale.") {
+		t.Errorf("Nicknames still look stale after prune --comment (%v):\n%s", err, output)
+	}
+}
+
+func TestRemapContext(t *testing.T) {
+	home := t.TempDir()
+	kconfigFilename := filepath.Join(home, ".kube", "kconfig.yaml")
+	kubeconfig := filepath.Join(home, "config")
+	original, err := os.ReadFile(filepath.Join(testHomeDir, ".kube", "config"))
+	if err == nil {
+		err = os.WriteFile(kubeconfig, original, 0600)
+	}
+	kconfigYaml := fmt.Sprintf(`nicknames:
+  # The dev cluster.
+  dev: --kubeconfig %s --context dev
+  devns: --kubeconfig %s --context=dev -n kube-system
+  prod: --kubeconfig %s --context prod
+`, kubeconfig, kubeconfig, kubeconfig)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(kconfigFilename), 0700)
+	}
+	if err == nil {
+		err = os.WriteFile(kconfigFilename, []byte(kconfigYaml), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) (string, string, error) {
+		cmd := exec.Command(kconfigUtilCommand, args...)
+		cmd.Env = append(os.Environ(), "HOME="+home)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		return string(output), stderr.String(), err
+	}
+
+	// Without --kubeconfig, the definitions are changed, but the context doesn't exist.
+	output, warnings, err := run("remap-context", "dev", "development")
+	if err != nil {
+		t.Fatalf("remap-context failed: %v", err)
+	}
+	if !strings.Contains(output, "context \"dev\": dev, devns") {
+		t.Errorf("Unexpected output from remap-context:\n%s", output)
+	}
+	if !strings.Contains(warnings, "Nickname \"dev\" can't be resolved") {
+		t.Errorf("remap-context didn't warn about the missing context:\n%s", warnings)
+	}
+	contents, err := os.ReadFile(kconfigFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"# The dev cluster.\n  dev: --kubeconfig " + kubeconfig + " --context development\n", "--context=development -n kube-system", "--context prod\n"} {
+		if !strings.Contains(string(contents), expected) {
+			t.Errorf("kconfig.yaml doesn't contain \"%s\" after remap-context:\n%s", expected, contents)
+		}
+	}
+
+	// With --kubeconfig, the context is renamed in the file too.
+	_, _, err = run("remap-context", "prod", "production", "--kubeconfig", kubeconfig)
+	if err != nil {
+		t.Fatalf("remap-context --kubeconfig failed: %v", err)
+	}
+	renamed, err := clientcmd.LoadFromFile(kubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := renamed.Contexts["production"]; !exists || renamed.Contexts["prod"] != nil {
+		t.Errorf("Context \"prod\" wasn't renamed in the kubectl config file.")
+	}
+	contents, err = os.ReadFile(kconfigFilename)
+	if err != nil || !strings.Contains(string(contents), "--context production\n") {
+		t.Errorf("kconfig.yaml wasn't changed by remap-context --kubeconfig (%v):\n%s", err, contents)
+	}
+
+	// A context that the file doesn't define is an error, and nothing is changed.
+	_, _, err = run("remap-context", "prod", "prod2", "--kubeconfig", kubeconfig)
+	if err == nil {
+		t.Errorf("remap-context didn't fail for a context the kubectl config file doesn't define.")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte("nicknames:\n  dev: --context dev\n  devother: kubectl-1.28 --context dev -n other\n  prod: --context prod\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Name     string
+		Args     []string
+		Env      []string
+		Differ   bool
+		Expect   []string
+		Unexpect []string
+	}{
+		{
+			Name:     "Different clusters",
+			Args:     []string{"diff", "dev", "prod"},
+			Differ:   true,
+			Expect:   []string{"http://dev-cluster/", "http://prod-cluster/", "devuser1", "produser1", "Same: kubeconfig, kubectl"},
+			Unexpect: []string{"\nkubeconfig "},
+		},
+		{
+			Name:     "Same cluster",
+			Args:     []string{"diff", "dev", "devother"},
+			Differ:   true,
+			Expect:   []string{"devnamespace1", "other", "kubectl-1.28", "Same: kubeconfig, context, server, user"},
+			Unexpect: []string{"\nserver "},
+		},
+		{
+			Name:   "No differences",
+			Args:   []string{"diff", "dev", "dev"},
+			Expect: []string{"No differences."},
+		},
+		{
+			Name:   "Current environment",
+			Args:   []string{"diff", "dev", "--current"},
+			Env:    []string{"KUBECONFIG=", "_KCONFIG_KSET=dev -n other", "_KCONFIG_KUBECTL=kubectl"},
+			Differ: true,
+			Expect: []string{"current (dev -n other)", "devnamespace1", "other", "Same: kubeconfig, context, server, user, kubectl"},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			cmd := exec.Command(kconfigUtilCommand, testCase.Args...)
+			cmd.Env = append(os.Environ(), testCase.Env...)
+			output, err := cmd.Output()
+			if testCase.Differ {
+				var exitErr *exec.ExitError
+				if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+					t.Errorf("%v didn't exit with status 1: %v", testCase.Args, err)
+				}
+			} else if err != nil {
+				t.Errorf("%v failed: %v", testCase.Args, err)
+			}
+			for _, expected := range testCase.Expect {
+				if !strings.Contains(string(output), expected) {
+					t.Errorf("Output doesn't contain \"%s\".  It's:\n%s", expected, output)
+				}
+			}
+			for _, unexpected := range testCase.Unexpect {
+				if strings.Contains(string(output), unexpected) {
+					t.Errorf("Output shouldn't contain \"%s\".  It's:\n%s", unexpected, output)
+				}
+			}
+		})
+	}
+
+	cmd := exec.Command(kconfigUtilCommand, "diff", "dev", "--current")
+	cmd.Env = append(os.Environ(), "_KCONFIG_KSET=")
+	err = cmd.Run()
+	if err == nil {
+		t.Errorf("diff --current didn't fail without a kset environment.")
+	}
+}
+
+func TestAnnounceTarget(t *testing.T) {
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte("preferences:\n  announce_target: true\n  danger_nicknames: [prod*]\nnicknames:\n  dev: --context dev\n  prod: --context prod\n  stage: --context stage --danger -n other\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := append(os.Environ(), "KCONFIG_STATE_DIR="+t.TempDir(), "KUBECONFIG=", "_KCONFIG_KSET=")
+
+	testCases := []struct {
+		Args     []string
+		Expected string
+	}{
+		{[]string{"kset", "prod"}, "→ cluster prod-cluster, ns prodnamespace1, user produser1\n"},
+		{[]string{"kset", "stage"}, "→ cluster stage-cluster, ns other, user stageuser1\n"},
+		{[]string{"kset", "dev"}, ""},
+		{[]string{"kset", "prod", "--quiet"}, ""},
+	}
+	for _, testCase := range testCases {
+		cmd := exec.Command(kconfigUtilCommand, testCase.Args...)
+		cmd.Env = env
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		err := cmd.Run()
+		if err != nil {
+			t.Fatalf("%v failed: %v\n%s", testCase.Args, err, stderr.String())
+		}
+		if stderr.String() != testCase.Expected {
+			t.Errorf("%v printed \"%s\" to standard error, instead of \"%s\".", testCase.Args, stderr.String(), testCase.Expected)
+		}
+	}
+}
+
+func TestKsetSet(t *testing.T) {
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte("nicknames:\n  dev: --context dev --set context.namespace=fromdefn --set user.username=alice\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) (string, error) {
+		cmd := exec.Command(kconfigUtilCommand, append([]string{"kset", "--print-only", "dev"}, args...)...)
+		cmd.Env = append(os.Environ(), "KUBECONFIG=", "_KCONFIG_KSET=")
+		output, err := cmd.Output()
+		return string(output), err
+	}
+
+	output, err := run("--set", "cluster.tls-server-name=api.internal", "--set", "clusters[prod].insecure-skip-tls-verify=true", "--set", "user.username=")
+	if err != nil {
+		t.Fatalf("kset --set failed: %v", err)
+	}
+	content, err := clientcmd.Load([]byte(output))
+	if err != nil {
+		t.Fatalf("Unable to parse the output of kset --print-only: %v\n%s", err, output)
+	}
+	context := content.Contexts[content.CurrentContext]
+	if context == nil || context.Namespace != "fromdefn" {
+		t.Errorf("The --set option in the definition didn't set the namespace:\n%s", output)
+	}
+	if cluster := content.Clusters[context.Cluster]; cluster == nil || cluster.TLSServerName != "api.internal" || cluster.Server != "http://dev-cluster/" {
+		t.Errorf("The --set option didn't set the context's cluster's tls-server-name:\n%s", output)
+	}
+	if cluster := content.Clusters["prod"]; cluster == nil || !cluster.InsecureSkipTLSVerify {
+		t.Errorf("The --set option didn't set the prod cluster's insecure-skip-tls-verify:\n%s", output)
+	}
+	if user := content.AuthInfos[context.AuthInfo]; user == nil || user.Username != "" || user.Token != "devuser1-token" {
+		t.Errorf("The override --set option didn't remove the username set by the definition:\n%s", output)
+	}
+	if !strings.Contains(output, "# prompt: (dev[set=cluster.tls-server-name,") {
+		t.Errorf("The prompt doesn't describe the --set options:\n%s", output)
+	}
+
+	for _, set := range []string{"cluster.tls-server=api.internal", "cluster=api.internal", "clusters.server=x", "cluster[prod].server=x", "clusters[missing].server=x", "cluster.server.host=x"} {
+		_, err = run("--set", set)
+		if err == nil {
+			t.Errorf("kset --set %s didn't fail.", set)
+		}
+	}
+}
+
+func TestOpenShift(t *testing.T) {
+	workarea := t.TempDir()
+	loggedIn := filepath.Join(workarea, "logged-in")
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/apis/user.openshift.io/v1/users/~", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := os.Stat(loggedIn); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"kind":"Status","reason":"Unauthorized"}`)
+			return
+		}
+		fmt.Fprint(w, `{"metadata":{"name":"developer"}}`)
+	})
+	mux.HandleFunc("/apis/project.openshift.io/v1/projects", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"items":[{"metadata":{"name":"proj-b"}},{"metadata":{"name":"proj-a"}}]}`)
+	})
+	mux.HandleFunc("/apis/project.openshift.io/v1/projects/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/proj-a") {
+			fmt.Fprint(w, `{"metadata":{"name":"proj-a"}}`)
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"kind":"Status","reason":"Forbidden"}`)
+	})
+
+	fakeOC := filepath.Join(workarea, "bin", "oc")
+	ocLog := filepath.Join(workarea, "oc.log")
+	err := os.MkdirAll(filepath.Dir(fakeOC), 0755)
+	if err == nil {
+		err = os.WriteFile(fakeOC, []byte(fmt.Sprintf("#!/bin/sh\necho \"$*\" >> %s\nif [ \"$1\" = login ]; then touch %s; echo \"Login successful.\"; fi\n", ocLog, loggedIn)), 0755)
+	}
+	kubeconfig := filepath.Join(workarea, "config")
+	if err == nil {
+		err = os.WriteFile(kubeconfig, []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: ocp
+  cluster:
+    server: %s
+contexts:
+- name: ocp
+  context:
+    cluster: ocp
+    user: developer
+current-context: ocp
+users:
+- name: developer
+  user:
+    token: expired-token
+`, server.URL)), 0600)
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(fmt.Sprintf(`preferences:
+  validate_namespace: true
+nicknames:
+  ocp: %s --kubeconfig %s --context ocp --oc-login
+  plain: --kubeconfig %s --context ocp --oc-login
+`, fakeOC, kubeconfig, kubeconfig)), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The projects are listed as the namespaces.
+	namespaces, err := exec.Command(kconfigUtilCommand, "namespaces", "ocp", "--no-cache").Output()
+	if err != nil || string(namespaces) != "proj-a\nproj-b\n" {
+		t.Errorf("The namespaces of an OpenShift nickname aren't its projects (%v):\n%s", err, namespaces)
+	}
+
+	env := append(os.Environ(), "KCONFIG_STATE_DIR="+t.TempDir(), "KUBECONFIG=", "_KCONFIG_KSET=")
+	runKset := func(args ...string) (string, string, error) {
+		cmd := exec.Command(kconfigUtilCommand, append([]string{"kset"}, args...)...)
+		cmd.Env = env
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		return string(output), stderr.String(), err
+	}
+
+	// The expired token makes kset run "oc login", whose output goes to standard error.
+	output, errorOutput, err := runKset("ocp", "-n", "proj-a")
+	if err != nil {
+		t.Fatalf("kset failed: %v\n%s", err, errorOutput)
+	}
+	if !strings.Contains(errorOutput, "Login successful.") || strings.Contains(output, "Login successful.") {
+		t.Errorf("The output of \"oc login\" didn't go to standard error.\nStandard output:\n%s\nStandard error:\n%s", output, errorOutput)
+	}
+	if !strings.Contains(output, "export _KCONFIG_OPENSHIFT=true\n") {
+		t.Errorf("kset didn't set _KCONFIG_OPENSHIFT:\n%s", output)
+	}
+	ocCommands, err := os.ReadFile(ocLog)
+	if err != nil || string(ocCommands) != "login --server="+server.URL+"\n" {
+		t.Errorf("kset didn't run \"oc login\" as expected (%v):\n%s", err, ocCommands)
+	}
+
+	// Now that the token is accepted, there's no login, and projects the user can't see don't exist.
+	_, _, err = runKset("ocp", "-n", "proj-a")
+	if err != nil {
+		t.Errorf("kset failed: %v", err)
+	}
+	_, _, err = runKset("ocp", "-n", "proj-c")
+	if err == nil {
+		t.Errorf("kset didn't fail for a project the user can't see.")
+	}
+	ocCommands, _ = os.ReadFile(ocLog)
+	if strings.Count(string(ocCommands), "login") != 1 {
+		t.Errorf("kset ran \"oc login\" again:\n%s", ocCommands)
+	}
+
+	// --oc-login needs oc.
+	_, errorOutput, err = runKset("plain", "--print-only")
+	if err == nil || !strings.Contains(errorOutput, "--oc-login option needs") {
+		t.Errorf("kset didn't fail for --oc-login without oc (%v):\n%s", err, errorOutput)
+	}
+}
+
+func TestHelmEnv(t *testing.T) {
+	writeKconfig := func(preferences string) {
+		err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte("preferences:\n"+preferences+"nicknames:\n  dev: --context dev\n  other: --context dev --env HELM_NAMESPACE=mine\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	runKset := func(extraEnv []string, args ...string) string {
+		cmd := exec.Command(kconfigUtilCommand, append([]string{"kset"}, args...)...)
+		cmd.Env = append(append(os.Environ(), "KCONFIG_STATE_DIR="+t.TempDir(), "KUBECONFIG=", "_KCONFIG_KSET="), extraEnv...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("kset %v failed: %v\n%s", args, err, stderr.String())
+		}
+		return string(output)
+	}
+
+	writeKconfig("")
+	output := runKset(nil, "dev", "-n", "apps")
+	if !strings.Contains(output, "export HELM_NAMESPACE='apps'\n") || !strings.Contains(output, "export _KCONFIG_ENV='HELM_NAMESPACE'\n") {
+		t.Errorf("kset didn't set HELM_NAMESPACE:\n%s", output)
+	}
+	if strings.Contains(output, "HELM_KUBECONTEXT") {
+		t.Errorf("kset set HELM_KUBECONTEXT without the helm_kubecontext preference:\n%s", output)
+	}
+
+	// The nickname's --env option takes precedence.
+	output = runKset(nil, "other")
+	if !strings.Contains(output, "export HELM_NAMESPACE='mine'\n") || strings.Count(output, "HELM_NAMESPACE=") != 1 {
+		t.Errorf("kset didn't keep the HELM_NAMESPACE of the --env option:\n%s", output)
+	}
+
+	writeKconfig("  helm_kubecontext: true\n")
+	output = runKset(nil, "dev")
+	if !strings.Contains(output, "export HELM_NAMESPACE='devnamespace1'\n") || !strings.Contains(output, "export HELM_KUBECONTEXT='dev'\n") {
+		t.Errorf("kset didn't set HELM_NAMESPACE and HELM_KUBECONTEXT:\n%s", output)
+	}
+
+	// With helm_env false, the variables that the previous kset set are unset.
+	writeKconfig("  helm_env: false\n  helm_kubecontext: true\n")
+	output = runKset([]string{"_KCONFIG_ENV=HELM_NAMESPACE HELM_KUBECONTEXT"}, "dev")
+	if strings.Contains(output, "export HELM_") || !strings.Contains(output, "unset HELM_NAMESPACE\n") || !strings.Contains(output, "unset HELM_KUBECONTEXT\n") {
+		t.Errorf("kset didn't leave helm's variables alone with helm_env false:\n%s", output)
+	}
+
+	cmd := exec.Command(kconfigUtilCommand, "koff")
+	cmd.Env = append(os.Environ(), "KCONFIG_STATE_DIR="+t.TempDir(), "KUBECONFIG=/some/config", "_KCONFIG_ENV=HELM_NAMESPACE")
+	koffOutput, err := cmd.Output()
+	if err != nil || !strings.Contains(string(koffOutput), "unset HELM_NAMESPACE\n") {
+		t.Errorf("koff didn't unset HELM_NAMESPACE (%v):\n%s", err, koffOutput)
+	}
+}
+
+func TestNicknameEnv(t *testing.T) {
+	kconfigFilename := filepath.Join(testHomeDir, ".kube", "kconfig.yaml")
+	err := os.WriteFile(kconfigFilename, []byte(`preferences:
+  helm_env: false
+nicknames:
+  dev: --context dev
+  dev-flux: --extends dev --env FLUX_SYSTEM_NAMESPACE=gitops
+  bad: --context dev
+nickname_env:
+  dev:
+    ARGOCD_SERVER: argocd.dev.example.com
+    ARGOCD_OPTS: --grpc-web
+    FLUX_SYSTEM_NAMESPACE: flux-system
+  bad:
+    KUBECONFIG: /tmp/x
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runKset := func(nickname string) (string, error) {
+		cmd := exec.Command(kconfigUtilCommand, "kset", nickname)
+		cmd.Env = append(os.Environ(), "KCONFIG_STATE_DIR="+t.TempDir(), "KUBECONFIG=", "_KCONFIG_KSET=", "_KCONFIG_ENV=ARGOCD_SERVER STALE")
+		output, err := cmd.Output()
+		return string(output), err
+	}
+
+	output, err := runKset("dev")
+	if err != nil {
+		t.Fatalf("kset failed: %v", err)
+	}
+	for _, expected := range []string{
+		"export ARGOCD_OPTS='--grpc-web'\nexport ARGOCD_SERVER='argocd.dev.example.com'\nexport FLUX_SYSTEM_NAMESPACE='flux-system'\nunset STALE\n",
+		"export _KCONFIG_ENV='ARGOCD_OPTS ARGOCD_SERVER FLUX_SYSTEM_NAMESPACE'\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("The output of kset doesn't contain %q:\n%s", expected, output)
+		}
+	}
+
+	// The --env option of a nickname that extends another takes precedence.
+	output, err = runKset("dev-flux")
+	if err != nil {
+		t.Fatalf("kset failed: %v", err)
+	}
+	if !strings.Contains(output, "export ARGOCD_SERVER='argocd.dev.example.com'\n") || !strings.Contains(output, "export FLUX_SYSTEM_NAMESPACE='gitops'\n") {
+		t.Errorf("kset didn't combine the nickname_env entry with the --env option:\n%s", output)
+	}
+
+	_, err = runKset("bad")
+	if err == nil {
+		t.Errorf("kset didn't fail for a nickname_env entry that sets KUBECONFIG.")
+	}
+
+	// Renaming the nickname renames its entry.
+	_, err = exec.Command(kconfigUtilCommand, "rename", "dev", "devel").Output()
+	if err != nil {
+		t.Fatalf("rename failed: %v", err)
+	}
+	kconfig, err := config.LoadKconfig(kconfigFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := kconfig.NicknameEnv["dev"]; exists || kconfig.NicknameEnv["devel"]["ARGOCD_SERVER"] != "argocd.dev.example.com" {
+		t.Errorf("The nickname_env entry wasn't renamed: %v", kconfig.NicknameEnv)
+	}
+}
+
+func TestNicknameDescriptions(t *testing.T) {
+	kconfigFilename := filepath.Join(testHomeDir, ".kube", "kconfig.yaml")
+	err := os.WriteFile(kconfigFilename, []byte(`nicknames:
+  dev-e2: --context dev --alias e2
+  dev-other: --extends dev-e2
+  prod: --context prod
+nickname_descriptions:
+  dev-e2: >
+    End-to-end tests,
+    rebuilt nightly
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := exec.Command(kconfigUtilCommand, "complete", "--descriptions", "dev").Output()
+	if err != nil || string(output) != "dev-e2\tEnd-to-end tests, rebuilt nightly (dev/devnamespace1)\ndev-other\tdev/devnamespace1\n" {
+		t.Errorf("Unexpected completions (%v):\n%s", err, output)
+	}
+
+	output, err = exec.Command(kconfigUtilCommand, "explain", "e2").Output()
+	if err != nil || !strings.Contains(string(output), "\nDescription: End-to-end tests, rebuilt nightly\n") {
+		t.Errorf("explain doesn't show the description (%v):\n%s", err, output)
+	}
+
+	cmd := exec.Command(kubectlCommand, "--kconfig-help")
+	cmd.Env = append(os.Environ(), "_KCONFIG_KSET=")
+	output, err = cmd.Output()
+	if err != nil || !strings.Contains(string(output), "Nicknames:\n  dev-e2 (aliases: e2) - End-to-end tests, rebuilt nightly\n  dev-other\n  prod\n") {
+		t.Errorf("kubectl --kconfig-help doesn't show the description (%v):\n%s", err, output)
+	}
+
+	// Renaming the nickname renames its entry, and removing it removes the entry.
+	_, err = exec.Command(kconfigUtilCommand, "rename", "dev-e2", "e2e").Output()
+	if err != nil {
+		t.Fatalf("rename failed: %v", err)
+	}
+	kconfig, err := config.LoadKconfig(kconfigFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kconfig.NicknameDescription("e2e") != "End-to-end tests, rebuilt nightly" || kconfig.NicknameDescription("dev-e2") != "" {
+		t.Errorf("The nickname_descriptions entry wasn't renamed: %v", kconfig.NicknameDescriptions)
+	}
+	err = config.EditKconfigFile(kconfigFilename, func(editor *config.KconfigEditor) error {
+		editor.RemoveNickname("e2e")
+		return nil
+	})
+	if err == nil {
+		kconfig, err = config.LoadKconfig(kconfigFilename)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kconfig.NicknameDescriptions) != 0 {
+		t.Errorf("The nickname_descriptions entry wasn't removed: %v", kconfig.NicknameDescriptions)
+	}
+}
+
+func TestKoffSessionFileCheck(t *testing.T) {
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte("nicknames:\n  dev: --context dev\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateDir := t.TempDir()
+	env := append(os.Environ(), "KCONFIG_STATE_DIR="+stateDir)
+
+	cmd := exec.Command(kconfigUtilCommand, "kset", "dev")
+	cmd.Env = append(env, "KUBECONFIG=")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("kset failed: %v", err)
+	}
+	matches := extractKubeconfigEnvVar.FindStringSubmatch(string(output))
+	if matches == nil {
+		t.Fatalf("kset didn't set KUBECONFIG.  The output is:\n%s", output)
+	}
+	sessionFilename := filepath.SplitList(matches[1])[0]
+
+	// A file written by hand in the session directory is neither removed nor replaced.
+	handcraftedFilename := filepath.Join(stateDir, "sessions", "mine.yaml")
+	err = os.WriteFile(handcraftedFilename, []byte("handcrafted"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{{"koff"}, {"kset", "dev"}} {
+		cmd = exec.Command(kconfigUtilCommand, args...)
+		cmd.Env = append(env, "KUBECONFIG="+handcraftedFilename)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		output, err = cmd.Output()
+		if err != nil {
+			t.Fatalf("%v failed: %v\n%s", args, err, stderr.String())
+		}
+		if !strings.Contains(stderr.String(), "isn't named like a session-local kubectl config file") {
+			t.Errorf("%v doesn't warn about the file.  Its standard error is:\n%s", args, stderr.String())
+		}
+		contents, err := os.ReadFile(handcraftedFilename)
+		if err != nil || string(contents) != "handcrafted" {
+			t.Errorf("%v changed the handcrafted file: %v", args, err)
+		}
+	}
+	if newMatches := extractKubeconfigEnvVar.FindStringSubmatch(string(output)); newMatches == nil || strings.Contains(newMatches[1], handcraftedFilename) {
+		t.Errorf("kset didn't create a new session-local file.  The output is:\n%s", output)
+	}
+
+	// With --dry-run, the session-local file is only reported.
+	cmd = exec.Command(kconfigUtilCommand, "koff", "--dry-run")
+	cmd.Env = append(env, "KUBECONFIG="+matches[1])
+	output, err = cmd.Output()
+	if err != nil {
+		t.Fatalf("koff --dry-run failed: %v", err)
+	}
+	expected := fmt.Sprintf("Would remove the session-local kubectl config file \"%s\".\nWould unset KUBECONFIG.\n", sessionFilename)
+	if string(output) != expected {
+		t.Errorf("Expected output:\n%s\nActual output:\n%s", expected, output)
+	}
+	if _, err = os.Stat(sessionFilename); err != nil {
+		t.Errorf("koff --dry-run removed the session-local file: %v", err)
+	}
+
+	cmd = exec.Command(kconfigUtilCommand, "koff")
+	cmd.Env = append(env, "KUBECONFIG="+matches[1])
+	err = cmd.Run()
+	if err != nil {
+		t.Fatalf("koff failed: %v", err)
+	}
+	if _, err = os.Stat(sessionFilename); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("koff didn't remove the session-local file: %v", err)
+	}
+}
+
+func TestKsetTerminalTitle(t *testing.T) {
+	testCases := []struct {
+		Name      string
+		Arguments []string
+		Env       []string
+		Expect    []string
+	}{
+		{
+			Name:      "Save the title when entering an environment",
+			Arguments: []string{"kset", "dev"},
+			Env:       []string{"_KCONFIG_KSET="},
+			Expect:    []string{`printf '\033[22;0t'`, `printf '\033]0;%s\007' 'dev'`},
+		},
+		{
+			Name:      "Only set the title when switching environments",
+			Arguments: []string{"kset", "dev-namespace"},
+			Env:       []string{"_KCONFIG_KSET=dev"},
+			Expect:    []string{`printf '\033]0;%s\007' 'dev-namespace'`},
+		},
+		{
+			Name:      "Restore the title when leaving an environment",
+			Arguments: []string{"koff"},
+			Env:       []string{"_KCONFIG_KSET=dev", "KUBECONFIG=/tmp/kconfig/dev"},
+			Expect:    []string{`printf '\033]0;\007\033[23;0t'`},
+		},
+	}
+
+	workarea := t.TempDir()
+	err := copyConfigFile(t, "kconfig.yaml", &config.KconfigPreferences{SetTerminalTitle: true})
+	if err != nil {
+		t.Fatalf("Error copying \"kconfig.yaml\": %v", err)
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			cmd := exec.Command(kconfigUtilCommand, testCase.Arguments...)
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			cmd.Env = append(os.Environ(), fmt.Sprintf("TMPDIR=%s", workarea), "KUBECONFIG=")
+			cmd.Env = append(cmd.Env, testCase.Env...)
+			outputBytes, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("%s failed: %v\n%s", testCase.Arguments[0], err, stderr.String())
+			}
+
+			output := string(outputBytes)
+			for _, expected := range testCase.Expect {
+				if !strings.Contains(output, expected+"\n") {
+					t.Errorf("Output doesn't contain \"%s\".  It's:\n%s", expected, output)
+				}
+			}
+			if len(testCase.Expect) == 1 && strings.Contains(output, "22;0t") {
+				t.Errorf("Output shouldn't save the title.  It's:\n%s", output)
+			}
+		})
+	}
+}
+
+func TestKsetKubeconfigCache(t *testing.T) {
+	workarea := t.TempDir()
+	cacheHome := t.TempDir()
+	err := copyConfigFile(t, "kconfig.yaml", &config.KconfigPreferences{CacheKubeconfig: true})
+	if err != nil {
+		t.Fatalf("Error copying \"kconfig.yaml\": %v", err)
+	}
+
+	for _, arguments := range [][]string{
+		{"kset", "dev-namespace", "--print-only"},
+		{"kset", "dev-namespace", "--print-only"},
+		{"kset", "dev-namespace", "--print-only", "--no-cache"},
+	} {
+		cmd := exec.Command(kconfigUtilCommand, arguments...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		cmd.Env = append(os.Environ(), fmt.Sprintf("TMPDIR=%s", workarea), fmt.Sprintf("XDG_CACHE_HOME=%s", cacheHome),
+			"KUBECONFIG=")
+		outputBytes, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%s failed: %v\n%s", strings.Join(arguments, " "), err, stderr.String())
+		}
+		if !strings.Contains(string(outputBytes), "namespace: namespace-override\n") {
+			t.Errorf("Output of %s doesn't select the namespace.  It's:\n%s", strings.Join(arguments, " "),
+				string(outputBytes))
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(cacheHome, "kconfig", "kubeconfig"))
+	if err != nil {
+		t.Fatalf("Error reading the cache directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected one cached kubectl configuration, but found %d.", len(entries))
+	}
+}
+
+func TestKsetConcurrent(t *testing.T) {
+	workarea := t.TempDir()
+	err := copyConfigFile(t, "kconfig.yaml", nil)
+	if err != nil {
+		t.Fatalf("Error copying \"kconfig.yaml\": %v", err)
+	}
+
+	runKconfigUtil := func(kubeconfigEnvVar string, arguments ...string) (string, error) {
+		cmd := exec.Command(kconfigUtilCommand, arguments...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		cmd.Env = append(os.Environ(), fmt.Sprintf("TMPDIR=%s", workarea), "KUBECONFIG="+kubeconfigEnvVar)
+		outputBytes, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("%s failed: %v\n%s", strings.Join(arguments, " "), err, stderr.String())
+		}
+		return string(outputBytes), nil
+	}
+
+	output, err := runKconfigUtil("", "kset", "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	matches := extractKubeconfigEnvVar.FindStringSubmatch(output)
+	if matches == nil {
+		t.Fatalf("kset didn't set KUBECONFIG.  Its output is:\n%s", output)
+	}
+	kubeconfigEnvVar := matches[1]
+	sessionFilename := filepath.SplitList(kubeconfigEnvVar)[0]
+
+	// Replace the same session-local file from several ksets at once.  Each must leave a complete
+	// file.
+	errs := make(chan error)
+	for i := 0; i < 8; i++ {
+		nickname := []string{"dev", "dev-namespace"}[i%2]
+		go func() {
+			_, err := runKconfigUtil(kubeconfigEnvVar, "kset", nickname)
+			errs <- err
+		}()
+	}
+	for i := 0; i < 8; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	contents, err := os.ReadFile(sessionFilename)
+	if err != nil {
+		t.Fatalf("Error reading the session-local file: %v", err)
+	}
+	var parsed map[string]interface{}
+	if err = yaml.Unmarshal(contents, &parsed); err != nil || parsed["current-context"] == nil {
+		t.Errorf("The session-local file isn't complete (%v):\n%s", err, string(contents))
+	}
+
+	_, err = runKconfigUtil(kubeconfigEnvVar, "koff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(sessionFilename))
+	if err != nil {
+		t.Fatalf("Error reading the sessions directory: %v", err)
+	}
+	for _, entry := range entries {
+		// The lock file is left in place on purpose.
+		if strings.HasSuffix(entry.Name(), ".lock") {
+			continue
+		}
+		t.Errorf("koff left the file \"%s\" in the sessions directory.", entry.Name())
+	}
+}
+
+func TestKsetLocations(t *testing.T) {
+	// The state directory is the user's choice, so its permissions are left alone.
+	stateDir := t.TempDir()
+	err := os.Chmod(stateDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kconfigFilename := filepath.Join(t.TempDir(), "my-kconfig.yaml")
+	err = os.WriteFile(kconfigFilename, []byte("nicknames:\n  mine: --context dev -n mine\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(kconfigUtilCommand, "kset", "mine")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), "KUBECONFIG=", "KCONFIG_STATE_DIR="+stateDir, "KCONFIG_CONFIG="+kconfigFilename)
+	outputBytes, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("kset failed: %v\n%s", err, stderr.String())
+	}
+
+	matches := extractKubeconfigEnvVar.FindStringSubmatch(string(outputBytes))
+	if matches == nil || !strings.HasPrefix(matches[1], filepath.Join(stateDir, "sessions")+string(os.PathSeparator)) {
+		t.Fatalf("The session-local file isn't in the state directory.  The output is:\n%s", string(outputBytes))
+	}
+
+	info, err := os.Stat(stateDir)
+	if err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0755 {
+		t.Errorf("The permissions of the state directory were changed to %v.", info.Mode())
+	}
+
+	// The state file is in the state directory, rather than in ~/.kube.
+	if _, err = os.Stat(filepath.Join(stateDir, "kconfig-state.yaml")); err != nil {
+		t.Errorf("The state file isn't in the state directory: %v", err)
+	}
+
+	// The files can hold credentials, so only the user can read them.
+	for _, filename := range []string{filepath.Join(stateDir, "sessions"), filepath.SplitList(matches[1])[0]} {
+		info, err := os.Stat(filename)
+		if err != nil {
+			t.Errorf("Error checking \"%s\": %v", filename, err)
+		} else if info.Mode().Perm()&0077 != 0 {
+			t.Errorf("\"%s\" can be read by other users.  Its mode is %v.", filename, info.Mode())
+		}
+	}
+}
+
+func TestDirectoryKconfigQuoting(t *testing.T) {
+	kconfigYaml := "preferences:\n  directory_kconfig: true\nnicknames:\n  dev: --context dev\n"
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	projectDir := t.TempDir()
+	runKset := func(directoryKconfig string) (string, string, error) {
+		err := os.WriteFile(filepath.Join(projectDir, ".kconfig"), []byte(directoryKconfig), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kconfigUtilPath, err := filepath.Abs(kconfigUtilCommand)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.Command(kconfigUtilPath, "kset")
+		cmd.Dir = projectDir
+		cmd.Env = append(os.Environ(), "KCONFIG_STATE_DIR="+t.TempDir(), "KUBECONFIG=", "_KCONFIG_KSET=")
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		return string(output), stderr.String(), err
+	}
+
+	// The values from the file are quoted, so the shell doesn't run anything in them.
+	output, errorOutput, err := runKset("dev -n 'x$(echo INJECTED >&2)'\n")
+	if err != nil {
+		t.Fatalf("kset failed: %v\n%s", err, errorOutput)
+	}
+	for _, expected := range []string{"export _KCONFIG_KSET='dev\x1F-n\x1Fx$(echo INJECTED >&2)'\n", "_KP='dev[ns=x$(echo INJECTED >&2)]'\n"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("The output doesn't contain \"%s\":\n%s", expected, output)
+		}
+	}
+	evalOutput, err := exec.Command("sh", "-c", `eval "$1" && printf '%s' "$_KCONFIG_NAMESPACE"`, "sh", output).CombinedOutput()
+	if err != nil || string(evalOutput) != "x$(echo INJECTED >&2)" {
+		t.Errorf("Evaluating the output of kset didn't set the namespace literally (%v):\n%s", err, evalOutput)
+	}
+
+	// Anything but a nickname and kset options is refused.
+	for _, directoryKconfig := range []string{"dev --no-such-option\n", "dev extra\n", "dev --output-file /tmp/x\n"} {
+		_, errorOutput, err = runKset(directoryKconfig)
+		if err == nil || !strings.Contains(errorOutput, "Error reading directory kconfig file") {
+			t.Errorf("kset should refuse the directory kconfig file \"%s\" (%v):\n%s", strings.TrimSpace(directoryKconfig), err, errorOutput)
+		}
+	}
+}
+
+func TestKsetPrintOnly(t *testing.T) {
+	workarea := t.TempDir()
+	err := copyConfigFile(t, "kconfig.yaml", nil)
+	if err != nil {
+		t.Fatalf("Error copying \"kconfig.yaml\": %v", err)
+	}
+
+	cmd := exec.Command(kconfigUtilCommand, "kset", "dev-namespace", "--print-only")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), fmt.Sprintf("TMPDIR=%s", workarea), "KUBECONFIG=")
+	outputBytes, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("kset --print-only failed: %v\n%s", err, stderr.String())
+	}
+
+	output := string(outputBytes)
+	for _, expected := range []string{"# _KCONFIG_KUBECTL=kubectl", "# _KCONFIG_KSET=dev-namespace", "namespace: namespace-override"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Output doesn't contain \"%s\".  It's:\n%s", expected, output)
+		}
+	}
+	if strings.Contains(output, "export ") {
+		t.Errorf("Output shouldn't contain export statements.  It's:\n%s", output)
+	}
+
+	entries, err := os.ReadDir(workarea)
+	if err != nil || len(entries) > 0 {
+		t.Errorf("kset --print-only shouldn't create any files, but \"%s\" isn't empty", workarea)
+	}
+}
+
+func TestKsetAlso(t *testing.T) {
+	workarea := t.TempDir()
+	err := copyConfigFile(t, "kconfig.yaml", nil)
+	if err != nil {
+		t.Fatalf("Error copying \"kconfig.yaml\": %v", err)
+	}
+
+	runKset := func(arguments ...string) (string, string, error) {
+		cmd := exec.Command(kconfigUtilCommand, append([]string{"kset"}, arguments...)...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		cmd.Env = append(os.Environ(), fmt.Sprintf("TMPDIR=%s", workarea), "KUBECONFIG=", "KCONFIG_FEATURES=also-contexts")
+		outputBytes, err := cmd.Output()
+		return string(outputBytes), stderr.String(), err
+	}
+
+	output, stderr, err := runKset("dev-namespace", "--also", "dev-user", "--also", "dev-tunnel", "--print-only")
+	if err != nil {
+		t.Fatalf("kset --also failed: %v\n%s", err, stderr)
+	}
+	for _, expected := range []string{
+		"# _KCONFIG_KSET=dev-namespace --also dev-user --also dev-tunnel",
+		"current-context: kconfig_context\n",
+		"    cluster: kconfig_cluster_dev-user\n    namespace: devnamespace1\n    user: kconfig_user_dev-user\n  name: dev-user\n",
+		"    server: https://localhost:6443\n    tls-server-name: dev-cluster\n  name: kconfig_cluster_dev-tunnel\n",
+		"    token: devuser2-token\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Output doesn't contain \"%s\".  It's:\n%s", expected, output)
+		}
+	}
+
+	_, stderr, err = runKset("dev", "--also", "dev-namespace", "--also", "dev", "--print-only")
+	if err == nil || !strings.Contains(stderr, "since it's the name of the current context") {
+		t.Errorf("Expected kset to reject a nickname named like the current context, but got: %v\n%s", err, stderr)
+	}
+
+	err = os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(`preferences:
+  danger_nicknames: [prod*]
+nicknames:
+  dev: --context dev
+  stage: --context stage
+  stage-ro: --context stage --read-only
+  stage-confirm: --context stage --confirm-mutations
+  prod: --context prod
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nickname := range []string{"stage-ro", "stage-confirm", "prod"} {
+		_, stderr, err = runKset("dev", "--also", "stage", "--also", nickname, "--print-only")
+		if err == nil || !strings.Contains(stderr, fmt.Sprintf("The nickname \"%s\" can't be added with --also", nickname)) {
+			t.Errorf("Expected kset to reject the guarded nickname \"%s\" for --also, but got: %v\n%s", nickname, err, stderr)
+		}
+	}
+}
+
+func TestKsetOutputFile(t *testing.T) {
+	workarea := t.TempDir()
+	err := copyConfigFile(t, "kconfig.yaml", nil)
+	if err != nil {
+		t.Fatalf("Error copying \"kconfig.yaml\": %v", err)
+	}
+
+	outputFile := filepath.Join(workarea, "output", "dev.yaml")
+	cmd := exec.Command(kconfigUtilCommand, "kset", "dev-namespace", "--output-file", outputFile)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), fmt.Sprintf("TMPDIR=%s", workarea), "KUBECONFIG=")
+	outputBytes, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("kset --output-file failed: %v\n%s", err, stderr.String())
+	}
+
+	testCase := TestCase{
+		ExpectKubeconfig:      ".kube/config",
+		ExpectLocalConfigFile: "2",
+	}
+	localKubectlConfigFile, failed := verifyKubeconfigEnvVar(t, string(outputBytes), &testCase)
+	if failed {
+		return
+	}
+
+	if localKubectlConfigFile != outputFile {
+		t.Errorf("KUBECONFIG should start with \"%s\", but starts with \"%s\"", outputFile, localKubectlConfigFile)
+	}
+
+	verifyLocalKubectlConfigFile(t, outputFile, &testCase)
+
+	// The file is recorded with the environment, so "kset -" writes it again.
+	ksetDescription := "dev-namespace --output-file " + outputFile
+	if !strings.Contains(string(outputBytes), fmt.Sprintf("export _KCONFIG_KSET='%s'\n", ksetDescription)) {
+		t.Fatalf("The --output-file option isn't recorded in _KCONFIG_KSET:\n%s", outputBytes)
+	}
+	err = os.Remove(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd = exec.Command(kconfigUtilCommand, "kset", "-")
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), fmt.Sprintf("TMPDIR=%s", workarea), "KUBECONFIG=", "_KCONFIG_KSET=dev", "_KCONFIG_OLDKSET="+ksetDescription)
+	outputBytes, err = cmd.Output()
+	if err != nil {
+		t.Fatalf("kset - failed: %v\n%s", err, stderr.String())
+	}
+	localKubectlConfigFile, failed = verifyKubeconfigEnvVar(t, string(outputBytes), &testCase)
+	if !failed && localKubectlConfigFile != outputFile {
+		t.Errorf("After kset -, KUBECONFIG should start with \"%s\", but starts with \"%s\"", outputFile, localKubectlConfigFile)
+	}
+	verifyLocalKubectlConfigFile(t, outputFile, &testCase)
+}
+
+func verifyKubeconfigEnvVar(t *testing.T, output string, testCase *TestCase) (string, bool) {
+	match := extractKubeconfigEnvVar.FindStringSubmatch(output)
+	if match == nil {
+		t.Log("Couldn't find the KUBECONFIG environment variable in the output.")
+		t.Logf("output: %s", output)
+		t.Fail()
+		return "", true
+	}
+	value := match[1]
+	//t.Logf("Actual   KUBECONFIG: %s", value)
+	//t.Logf("Expected KUBECONFIG: %s", testCase.ExpectKubeconfig)
+	searchPath := strings.Split(value, string(os.PathListSeparator))
+	expectedPath := strings.Split(testCase.ExpectKubeconfig, string(os.PathListSeparator))
+	if len(expectedPath)+1 != len(searchPath) {
+		t.Log("The KUBECONFIG environment variable is not as expected.")
+		t.Logf("Expected: %s", testCase.ExpectKubeconfig)
+		t.Logf("Actual  : %s", value)
+		t.Fail()
+		return "", true
+	}
+
+	for idx, actualElement := range searchPath[1:] {
+		expectedElement := expectedPath[idx]
+		if !strings.HasSuffix(actualElement, expectedElement) {
+			t.Logf("The KUBECONFIG environment variable is not as expected.  Element %d is different.", idx)
+			t.Logf("Expected suffix: %s", expectedElement)
+			t.Logf("Actual         : %s", actualElement)
+			t.Fail()
+			return "", true
+		}
+	}
+
+	// Return the first element, which is the local kubectl config file, so the caller can examine
+	// it to make sure it's correct.
+	return searchPath[0], false
+}
+
+func verifyTeleportProxyEnvVar(t *testing.T, output string, testCase *TestCase) bool {
+	match := extractTeleportProxyEnvVar.FindStringSubmatch(output)
+	if match == nil {
+		if testCase.ExpectTeleportProxy == "" {
+			return false
+		}
+		t.Log("Couldn't find the TELEPORT_PROXY environment variable in the output.")
+		t.Logf("output: %s", output)
+		t.Fail()
+		return true
+	}
+	value := match[1]
+	if value != testCase.ExpectTeleportProxy {
+		t.Log("The TELEPORT_PROXY environment variable is not as expected.")
+		t.Logf("Expected: %s", testCase.ExpectTeleportProxy)
+		t.Logf("Actual  : %s", value)
+		t.Fail()
+		return true
+	}
+	return false
+}
+
+func verifyKubectlExe(t *testing.T, output string, testCase *TestCase) bool {
+	match := extractKubectlExe.FindStringSubmatch(output)
+	if match == nil {
+		t.Log("Couldn't find the kubectl executable name in the output.")
+		t.Logf("output: %s", output)
+		t.Fail()
+		return true
+	}
+	value := match[1]
+	if value != testCase.ExpectKubectlExe {
+		t.Log("The kubectl executable name is not as expected.")
+		t.Logf("Expected: %s", testCase.ExpectKubectlExe)
+		t.Logf("Actual  : %s", value)
+		t.Fail()
+		return true
+	}
+	return false
+}
+
+func verifyPrompt(t *testing.T, output string, testCase *TestCase) bool {
+	match := extractPrompt.FindStringSubmatch(output)
+	if match == nil {
+		if testCase.ExpectPrompt == "" {
+			// This is expected.
+			return false
+		}
+		t.Log("Couldn't find the prompt info in the output.")
+		t.Logf("output: %s", output)
+		t.Fail()
+		return true
+	}
+	value := match[1]
+	if value != testCase.ExpectPrompt {
+		t.Log("The kset prompt is not as expected.")
+		t.Logf("Expected: %s", testCase.ExpectPrompt)
+		t.Logf("Actual  : %s", value)
+		t.Fail()
+		return true
+	}
+
+	var color string
+	if match := extractPromptColor.FindStringSubmatch(output); match != nil {
+		color = match[1]
+	}
+	if color != testCase.ExpectPromptColor {
+		t.Log("The kset prompt color is not as expected.")
+		t.Logf("Expected: %s", testCase.ExpectPromptColor)
+		t.Logf("Actual  : %s", color)
+		t.Fail()
+		return true
+	}
+	return false
+}
+
+func verifyLocalKubectlConfigFile(t *testing.T, localConfigFileName string, testCase *TestCase) bool {
+	expectedLocalConfigFilePath := filepath.Join("testdata", "configs", fmt.Sprintf("local-config.%s.yaml", testCase.ExpectLocalConfigFile))
+	expectedContents, err := readYamlFile(expectedLocalConfigFilePath)
+	if err != nil {
+		t.Errorf("Error examining expected local kubectl config file: %v", err)
+		if errors.Is(err, os.ErrNotExist) {
+			// Dump the actual file, to help the developer prime the "expected" file after the first run.
+			t.Log("Actual local config file:")
+			rawContents, err := os.ReadFile(localConfigFileName)
+			if err != nil {
+				t.Logf("... Error reading local config file \"%s\":  %v", localConfigFileName, err)
+			} else {
+				t.Log("\n" + string(rawContents))
+			}
+		}
+		return true
+	}
+
+	actualContents, err := readYamlFile(localConfigFileName)
+	if err != nil {
+		t.Errorf("Error examining actual local kubectl config file: %v", err)
+		return true
+	}
+
+	if !reflect.DeepEqual(expectedContents, actualContents) {
+		t.Log("The local kubectl configuration file is not as expected.")
+		t.Logf("Expected file \"%s\":", expectedLocalConfigFilePath)
+		rawContents, err := os.ReadFile(expectedLocalConfigFilePath)
+		if err != nil {
+			t.Logf("... Error reading file:  %v", err)
+		} else {
+			t.Log(string(rawContents))
+		}
+		t.Logf("Actual file \"%s\":", localConfigFileName)
+		rawContents, err = os.ReadFile(localConfigFileName)
+		if err != nil {
+			t.Logf("... Error reading file:  %v", err)
+		} else {
+			t.Log(string(rawContents))
+		}
+		t.Fail()
 		return true
 	}
 
-	if !reflect.DeepEqual(expectedContents, actualContents) {
-		t.Log("The local kubectl configuration file is not as expected.")
-		t.Logf("Expected file \"%s\":", expectedLocalConfigFilePath)
-		rawContents, err := os.ReadFile(expectedLocalConfigFilePath)
-		if err != nil {
-			t.Logf("... Error reading file:  %v", err)
-		} else {
-			t.Log(string(rawContents))
+	return false
+}
+
+func readYamlFile(path string) (map[string]interface{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	context := make(map[string]interface{})
+	err = yaml.NewDecoder(file).Decode(context)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing file \"%s\": %v", path, err)
+	}
+	return context, nil
+}
+
+var emptyPreferences = config.KconfigPreferences{}
+
+func copyConfigFile(t *testing.T, filename string, preferences *config.KconfigPreferences) error {
+	target := filepath.Join(testHomeDir, ".kube", filename)
+	err := os.Remove(target)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	sourcePath := filepath.Join("testdata", "configs", filename)
+	//sourceFile, err := os.Open(sourcePath)
+	//if err != nil {
+	//	if err == os.ErrNotExist {
+	//		return nil
+	//	}
+	//	return err
+	//}
+	//
+	//defer sourceFile.Close()
+
+	targetFile, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+
+	defer targetFile.Close()
+
+	if preferences != nil && !reflect.DeepEqual(*preferences, emptyPreferences) {
+		kconfig := config.Kconfig{}
+		kconfig.Preferences = *preferences
+		if kconfig.Preferences.BaseKubeconfig != "" {
+			kconfig.Preferences.BaseKubeconfig = strings.ReplaceAll(kconfig.Preferences.BaseKubeconfig, "$HOME", testHomeDir)
+		}
+		err = yaml.NewEncoder(targetFile).Encode(kconfig)
+		if err != nil {
+			return fmt.Errorf("Error encoding preferences to file \"%s\": %v", target, err)
+		}
+	}
+
+	// Read contents into memory so we can easy string substitutions
+	contents, err := os.ReadFile(sourcePath)
+	if err != nil {
+		return fmt.Errorf("Error reading from file \"%s\": %v", sourcePath, err)
+	}
+
+	contents = bytes.ReplaceAll(contents, []byte("$HOME"), []byte(testHomeDir))
+	_, err = targetFile.Write(contents)
+	if err != nil {
+		return fmt.Errorf("Error writing to file \"%s\": %v", target, err)
+	}
+
+	//_, err = io.Copy(targetFile, sourceFile)
+	//if err != nil {
+	//	return fmt.Errorf("Error copying from file \"%s\" to \"%s\": %v", sourcePath, target, err)
+	//}
+	////t.Logf("Copied file \"%s\" to \"%s\"", sourcePath, target)
+
+	t.Cleanup(func() {
+		err := os.Remove(target)
+		if err != nil && err != os.ErrNotExist {
+			t.Logf("Error removing file \"%s\" after testcase: %v", target, err)
+		}
+		//t.Logf("Removed file \"%s\"", target)
+	})
+
+	return nil
+}
+
+var (
+	trueVal = true
+	truePtr = &trueVal
+
+	falseVal = false
+	falsePtr = &falseVal
+)
+
+// GetBoolPtr is a convenience function for returning a boolean pointer, useful
+// for optional boolean fields.  The caller should NOT change the value of the
+// boolean that is referenced by the pointer.
+func GetBoolPtr(val bool) *bool {
+	if val {
+		return truePtr
+	}
+	return falsePtr
+}
+
+func TestCredsStatus(t *testing.T) {
+	workarea := t.TempDir()
+	pluginPath := filepath.Join(workarea, "plugin")
+	plugin := "#!/bin/sh\n" +
+		`echo '{"apiVersion":"client.authentication.k8s.io/v1","kind":"ExecCredential",` +
+		`"status":{"token":"abc","expirationTimestamp":"2000-01-01T00:00:00Z"}}'` + "\n"
+	err := os.WriteFile(pluginPath, []byte(plugin), 0755)
+	if err != nil {
+		t.Fatalf("Error writing plugin: %v", err)
+	}
+
+	kubeconfigPath := filepath.Join(workarea, "config")
+	kubeconfig := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: cluster
+  cluster:
+    server: https://cluster.example.com
+users:
+- name: exec-user
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1
+      command: %s
+contexts:
+- name: exec-context
+  context:
+    cluster: cluster
+    user: exec-user
+current-context: exec-context
+`, pluginPath)
+	err = os.WriteFile(kubeconfigPath, []byte(kubeconfig), 0644)
+	if err != nil {
+		t.Fatalf("Error writing kubectl config file: %v", err)
+	}
+
+	cmd := exec.Command(kconfigUtilCommand, "creds-status", "--no-interactive")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), fmt.Sprintf("KUBECONFIG=%s", kubeconfigPath))
+	outputBytes, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("creds-status failed: %v\n%s", err, stderr.String())
+	}
+
+	output := string(outputBytes)
+	for _, expected := range []string{"User:    exec-user", "Type:    token", "Expires: expired "} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Output doesn't contain \"%s\".  It's:\n%s", expected, output)
+		}
+	}
+}
+
+func TestKsetOIDC(t *testing.T) {
+	workarea := t.TempDir()
+	err := copyConfigFile(t, "kconfig.yaml", nil)
+	if err != nil {
+		t.Fatalf("Error copying \"kconfig.yaml\": %v", err)
+	}
+
+	cmd := exec.Command(kconfigUtilCommand, "kset", "dev-oidc", "--print-only")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), fmt.Sprintf("TMPDIR=%s", workarea), "KUBECONFIG=")
+	outputBytes, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("kset --print-only failed: %v\n%s", err, stderr.String())
+	}
+
+	output := string(outputBytes)
+	for _, expected := range []string{"user: kconfig_oidc_user", "command: kconfig-util", "- oidc-token", "- https://login.example.com", "- openid,offline_access"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Output doesn't contain \"%s\".  It's:\n%s", expected, output)
+		}
+	}
+}
+
+func TestKsetImpersonation(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		Arguments    []string
+		Expect       []string
+		ExpectPrompt string
+	}{
+		{
+			Name:         "Impersonation in the definition",
+			Arguments:    []string{"dev-as"},
+			Expect:       []string{"user: kconfig_user", "token: devuser1-token", "as: admin", "- system:masters"},
+			ExpectPrompt: "dev-as",
+		},
+		{
+			Name:         "Impersonation overrides",
+			Arguments:    []string{"dev", "--user", "devuser2", "--as", "viewer", "--as-group", "viewers", "--as-group", "auditors"},
+			Expect:       []string{"user: kconfig_user", "token: devuser2-token", "as: viewer", "- viewers", "- auditors"},
+			ExpectPrompt: "dev[u=devuser2,as=viewer,as-group=viewers,as-group=auditors]",
+		},
+	}
+
+	workarea := t.TempDir()
+	err := copyConfigFile(t, "kconfig.yaml", nil)
+	if err != nil {
+		t.Fatalf("Error copying \"kconfig.yaml\": %v", err)
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			cmd := exec.Command(kconfigUtilCommand, append([]string{"kset", "--print-only"}, testCase.Arguments...)...)
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			cmd.Env = append(os.Environ(), fmt.Sprintf("TMPDIR=%s", workarea), "KUBECONFIG=")
+			outputBytes, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("kset --print-only failed: %v\n%s", err, stderr.String())
+			}
+
+			output := string(outputBytes)
+			for _, expected := range append(testCase.Expect, "# prompt: ("+testCase.ExpectPrompt+")") {
+				if !strings.Contains(output, expected) {
+					t.Errorf("Output doesn't contain \"%s\".  It's:\n%s", expected, output)
+				}
+			}
+		})
+	}
+}
+
+func TestKsetServer(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		Arguments    []string
+		Expect       []string
+		ExpectPrompt string
+	}{
+		{
+			Name:         "Server in the definition",
+			Arguments:    []string{"dev-tunnel"},
+			Expect:       []string{"cluster: kconfig_cluster", "server: https://localhost:6443", "tls-server-name: dev-cluster"},
+			ExpectPrompt: "dev-tunnel",
+		},
+		{
+			Name:         "Insecure override",
+			Arguments:    []string{"dev-tunnel", "--insecure-skip-tls-verify"},
+			Expect:       []string{"server: https://localhost:6443", "insecure-skip-tls-verify: true"},
+			ExpectPrompt: "dev-tunnel[insecure]",
+		},
+		{
+			Name:         "New cluster with a server",
+			Arguments:    []string{"dev", "--cluster", "tunnel", "--server", "https://localhost:7443"},
+			Expect:       []string{"cluster: kconfig_cluster", "server: https://localhost:7443"},
+			ExpectPrompt: "dev[c=tunnel,server=https://localhost:7443]",
+		},
+	}
+
+	workarea := t.TempDir()
+	err := copyConfigFile(t, "kconfig.yaml", nil)
+	if err != nil {
+		t.Fatalf("Error copying \"kconfig.yaml\": %v", err)
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			cmd := exec.Command(kconfigUtilCommand, append([]string{"kset", "--print-only"}, testCase.Arguments...)...)
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			cmd.Env = append(os.Environ(), fmt.Sprintf("TMPDIR=%s", workarea), "KUBECONFIG=")
+			outputBytes, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("kset --print-only failed: %v\n%s", err, stderr.String())
+			}
+
+			output := string(outputBytes)
+			for _, expected := range append(testCase.Expect, "# prompt: ("+testCase.ExpectPrompt+")") {
+				if !strings.Contains(output, expected) {
+					t.Errorf("Output doesn't contain \"%s\".  It's:\n%s", expected, output)
+				}
+			}
+		})
+	}
+}
+
+func TestOIDCToken(t *testing.T) {
+	claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, time.Now().Add(time.Hour).Unix())))
+	idToken := "header." + claims + ".signature"
+
+	var tokenRequests int
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"token_endpoint":"%s/token","device_authorization_endpoint":"%s/device"}`, server.URL, server.URL)
+	})
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"device_code":"device-code","user_code":"USER-CODE","verification_uri":"%s/verify","interval":1}`, server.URL)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+		w.Header().Set("Content-Type", "application/json")
+		if r.FormValue("device_code") != "device-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant"}`)
+		} else if tokenRequests == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"authorization_pending"}`)
+		} else {
+			fmt.Fprintf(w, `{"id_token":"%s","refresh_token":"refresh-token"}`, idToken)
+		}
+	})
+
+	homeDir := t.TempDir()
+	runOIDCToken := func(clientID string, execInfo string) (string, string, error) {
+		cmd := exec.Command(kconfigUtilCommand, "oidc-token", "--issuer", server.URL, "--client-id", clientID)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		cmd.Env = append(os.Environ(), fmt.Sprintf("HOME=%s", homeDir), fmt.Sprintf("KUBERNETES_EXEC_INFO=%s", execInfo))
+		outputBytes, err := cmd.Output()
+		return string(outputBytes), stderr.String(), err
+	}
+
+	// The first request logs in with the device flow.
+	output, errorOutput, err := runOIDCToken("kubernetes", "")
+	if err != nil {
+		t.Fatalf("oidc-token failed: %v\n%s", err, errorOutput)
+	}
+	if !strings.Contains(errorOutput, "USER-CODE") {
+		t.Errorf("Login instructions don't contain the user code.  They're:\n%s", errorOutput)
+	}
+	if !strings.Contains(output, `"token":"`+idToken+`"`) || !strings.Contains(output, `"expirationTimestamp":`) {
+		t.Errorf("Output doesn't contain the ID token and its expiration.  It's:\n%s", output)
+	}
+
+	// The second request uses the cached token.
+	output, errorOutput, err = runOIDCToken("kubernetes", `{"apiVersion":"client.authentication.k8s.io/v1beta1","kind":"ExecCredential","spec":{"interactive":false}}`)
+	if err != nil {
+		t.Fatalf("oidc-token failed: %v\n%s", err, errorOutput)
+	}
+	if tokenRequests != 2 {
+		t.Errorf("The cached token wasn't used.  There were %d token requests.", tokenRequests)
+	}
+	if !strings.Contains(output, `"apiVersion":"client.authentication.k8s.io/v1beta1"`) || !strings.Contains(output, `"token":"`+idToken+`"`) {
+		t.Errorf("Output doesn't contain the cached ID token.  It's:\n%s", output)
+	}
+
+	// A login can't be done when kubectl says the plugin can't interact with the user.
+	_, errorOutput, err = runOIDCToken("other-client", `{"apiVersion":"client.authentication.k8s.io/v1","kind":"ExecCredential","spec":{"interactive":false}}`)
+	if err == nil || !strings.Contains(errorOutput, "isn't running interactively") {
+		t.Errorf("oidc-token should fail when a login is needed but it isn't interactive.  Error: %v\n%s", err, errorOutput)
+	}
+}
+
+func TestCurrent(t *testing.T) {
+	testCases := []struct {
+		Name        string
+		KsetEnvVar  string
+		Expect      string
+		ExpectError bool
+	}{
+		{Name: "Nickname only", KsetEnvVar: "dev", Expect: "dev\n"},
+		{Name: "Nickname with overrides", KsetEnvVar: "dev -n other", Expect: "dev\n"},
+		{Name: "No kset environment", KsetEnvVar: "", Expect: "", ExpectError: true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			cmd := exec.Command(kconfigUtilCommand, "current")
+			cmd.Env = append(os.Environ(), fmt.Sprintf("_KCONFIG_KSET=%s", testCase.KsetEnvVar))
+			outputBytes, err := cmd.Output()
+			if (err != nil) != testCase.ExpectError {
+				t.Errorf("Unexpected error result: %v", err)
+			}
+			if string(outputBytes) != testCase.Expect {
+				t.Errorf("Expected output \"%s\", but got \"%s\"", testCase.Expect, string(outputBytes))
+			}
+		})
+	}
+}
+
+func TestForeach(t *testing.T) {
+	err := copyConfigFile(t, "kconfig.yaml", nil)
+	if err != nil {
+		t.Fatalf("Error copying \"kconfig.yaml\": %v", err)
+	}
+
+	// A fake kubectl executable that shows what it was run with, and fails for one nickname.
+	binDir := t.TempDir()
+	script := "#!/bin/sh\n" +
+		"echo \"$_KCONFIG_KSET $*\"\n" +
+		"[ \"$_KCONFIG_KSET\" = dev-user ] && { echo failed >&2; exit 3; }\n" +
+		"exit 0\n"
+	err = os.WriteFile(filepath.Join(binDir, "kubectl"), []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(kconfigUtilCommand, "foreach", "--parallel", "2", "dev-*user", "--", "get", "-o", "name")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), "PATH="+binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	err = cmd.Run()
+	if err == nil {
+		t.Errorf("foreach should fail when the command fails for a nickname.")
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	sort.Strings(lines)
+	expected := []string{"dev-namespace-user: dev-namespace-user get -o name", "dev-user: dev-user get -o name"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Unexpected output:\n%s", stdout.String())
+	}
+	for _, expected := range []string{"dev-user: failed\n", "The command failed for 1 of 2 nicknames: dev-user\n"} {
+		if !strings.Contains(stderr.String(), expected) {
+			t.Errorf("Standard error doesn't contain \"%s\".  It's:\n%s", expected, stderr.String())
+		}
+	}
+
+	output, err := exec.Command(kconfigUtilCommand, "foreach", "--tag", "no-such-tag", "dev-*user", "--", "get").CombinedOutput()
+	if err == nil || string(output) != "No nicknames with tags no-such-tag match \"dev-*user\".\n" {
+		t.Errorf("Unexpected result of foreach when no nicknames have the tag (%v):\n%s", err, string(output))
+	}
+
+	// Commands that change the cluster are refused for read-only nicknames, and for ones that
+	// require confirmation unless it's skipped, before they're run for any nickname.
+	kconfigYaml := `nicknames:
+  guard-ro: --context dev --read-only
+  guard-confirm: --context dev --confirm-mutations
+  guard-open: --context dev
+`
+	err = os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		pattern   string
+		env       []string
+		kubectlOK bool
+	}{
+		{"guard-*", nil, false},
+		{"guard-[co]*", nil, false},
+		{"guard-[co]*", []string{config.NoConfirmEnvVar + "=1"}, true},
+		{"guard-open", nil, true},
+	}
+	for _, testCase := range testCases {
+		cmd := exec.Command(kconfigUtilCommand, "foreach", testCase.pattern, "--", "delete", "pod", "foo")
+		stdout.Reset()
+		stderr.Reset()
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		cmd.Env = append(append(os.Environ(), "PATH="+binDir+string(os.PathListSeparator)+os.Getenv("PATH")), testCase.env...)
+		err = cmd.Run()
+		if testCase.kubectlOK {
+			if err != nil || !strings.Contains(stdout.String(), "delete pod foo") {
+				t.Errorf("foreach %s should run the command (%v):\n%s%s", testCase.pattern, err, stdout.String(), stderr.String())
+			}
+		} else if err == nil || stdout.Len() != 0 || !strings.Contains(stderr.String(), "Refusing to run \"kubectl delete pod foo\"") {
+			t.Errorf("foreach %s should refuse the command (%v):\n%s%s", testCase.pattern, err, stdout.String(), stderr.String())
+		}
+	}
+
+	cmd = exec.Command(kconfigUtilCommand, "foreach", "guard-*", "--", "get", "pods")
+	cmd.Env = append(os.Environ(), "PATH="+binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	output, err = cmd.CombinedOutput()
+	if err != nil {
+		t.Errorf("foreach should run a read-only command for guarded nicknames (%v):\n%s", err, string(output))
+	}
+}
+
+func TestSearch(t *testing.T) {
+	err := copyConfigFile(t, "kconfig.yaml", nil)
+	if err != nil {
+		t.Fatalf("Error copying \"kconfig.yaml\": %v", err)
+	}
+
+	testCases := []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"PROD-CLUSTER"}, []string{"dev-cluster"}},
+		{[]string{"devuser2"}, []string{"dev-extends", "dev-namespace-user", "dev-user"}},
+		{[]string{"--tag", "team-a", "namespace-override"}, []string{"dev-extends"}},
+		{[]string{"no-such-thing"}, nil},
+	}
+	for _, testCase := range testCases {
+		cmd := exec.Command(kconfigUtilCommand, append([]string{"search"}, testCase.args...)...)
+		var stdout bytes.Buffer
+		cmd.Stdout = &stdout
+		err := cmd.Run()
+		if testCase.expected == nil {
+			if err == nil {
+				t.Errorf("search %v should fail when no nicknames match.", testCase.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("search %v failed: %v", testCase.args, err)
+			continue
+		}
+
+		var nicknames []string
+		lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+		for _, line := range lines[1:] {
+			nicknames = append(nicknames, strings.Fields(line)[0])
+		}
+		if !reflect.DeepEqual(nicknames, testCase.expected) {
+			t.Errorf("search %v found %v, not %v.  Output:\n%s", testCase.args, nicknames, testCase.expected, stdout.String())
+		}
+	}
+}
+
+func TestPing(t *testing.T) {
+	// An API server that only accepts devuser1's token.  Under /old, it's too old to support
+	// SelfSubjectReview requests.  It uses TLS, since kubectl only sends credentials over TLS.
+	mux := http.NewServeMux()
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+	for _, prefix := range []string{"", "/old"} {
+		prefix := prefix
+		mux.HandleFunc(prefix+"/version", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"gitVersion":"v1.30.2"}`)
+		})
+		mux.HandleFunc(prefix+"/api", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"kind":"APIVersions","versions":["v1"]}`)
+		})
+		mux.HandleFunc(prefix+"/apis/authentication.k8s.io/v1/selfsubjectreviews", func(w http.ResponseWriter, r *http.Request) {
+			if prefix != "" {
+				http.NotFound(w, r)
+			} else if r.Header.Get("Authorization") != "Bearer devuser1-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprint(w, `{"kind":"Status","message":"Unauthorized"}`)
+			} else {
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprint(w, `{"status":{"userInfo":{"username":"dev-person"}}}`)
+			}
+		})
+	}
+
+	kconfigYaml := fmt.Sprintf(`nicknames:
+  up: --context dev --server %s --insecure-skip-tls-verify
+  old: --context dev --server %s/old --insecure-skip-tls-verify
+  denied: --context dev --server %s --insecure-skip-tls-verify --user devuser2
+  down: --context dev --server http://127.0.0.1:1
+`, server.URL, server.URL, server.URL)
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := exec.Command(kconfigUtilCommand, "ping", "up", "old").Output()
+	if err != nil {
+		t.Errorf("ping failed: %v\n%s", err, output)
+	}
+	for _, expected := range []string{"up: ok, version v1.30.2, ", ", user dev-person\n", "old: ok, version v1.30.2, "} {
+		if !strings.Contains(string(output), expected) {
+			t.Errorf("Output doesn't contain \"%s\".  It's:\n%s", expected, output)
+		}
+	}
+
+	output, err = exec.Command(kconfigUtilCommand, "ping", "--all").Output()
+	if err == nil {
+		t.Errorf("ping --all should fail when a nickname fails the check.")
+	}
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "denied: FAILED: The API server didn't accept the credentials") ||
+		!strings.HasPrefix(lines[1], "down: FAILED: ") || !strings.HasPrefix(lines[2], "old: ok") || !strings.HasPrefix(lines[3], "up: ok") {
+		t.Errorf("Unexpected output:\n%s", output)
+	}
+
+	// A nickname that takes too long is given up on, without holding up the others.
+	mux.HandleFunc("/slow/version", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(2 * time.Second)
+	})
+	kconfigYaml += fmt.Sprintf("  slow: --context dev --server %s/slow --insecure-skip-tls-verify\n", server.URL)
+	err = os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := time.Now()
+	output, err = exec.Command(kconfigUtilCommand, "ping", "--parallel", "2", "--timeout", "500ms", "slow", "up", "old").Output()
+	lines = strings.Split(strings.TrimSpace(string(output)), "\n")
+	if err == nil || len(lines) != 3 || lines[0] != "slow: FAILED: Gave up after 500ms." ||
+		!strings.HasPrefix(lines[1], "up: ok") || !strings.HasPrefix(lines[2], "old: ok") {
+		t.Errorf("Unexpected output of ping with a timeout (%v):\n%s", err, output)
+	}
+	if elapsed := time.Since(started); elapsed > 1500*time.Millisecond {
+		t.Errorf("ping with a timeout took %s.", elapsed)
+	}
+
+	_, err = exec.Command(kconfigUtilCommand, "ping", "--parallel", "0", "up").Output()
+	if err == nil {
+		t.Errorf("ping --parallel 0 didn't fail.")
+	}
+}
+
+func TestKsetValidateNamespace(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/api/v1/namespaces/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/existing" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"kind":"Namespace","metadata":{"name":"existing"}}`)
+	})
+
+	kconfigYaml := fmt.Sprintf(`preferences:
+  validate_namespace: true
+nicknames:
+  up: --context dev --server %s --insecure-skip-tls-verify
+  down: --context dev --server https://127.0.0.1:1
+`, server.URL)
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runKset := func(args ...string) (string, error) {
+		cmd := exec.Command(kconfigUtilCommand, append([]string{"kset"}, args...)...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		cmd.Env = append(os.Environ(), "KUBECONFIG=")
+		_, err := cmd.Output()
+		return stderr.String(), err
+	}
+
+	errorOutput, err := runKset("up", "-n", "existing")
+	if err != nil || errorOutput != "" {
+		t.Errorf("kset with an existing namespace failed: %v\n%s", err, errorOutput)
+	}
+
+	errorOutput, err = runKset("up", "-n", "typo")
+	if err == nil || !strings.Contains(errorOutput, `Namespace "typo" doesn't exist in the cluster of nickname "up".`) {
+		t.Errorf("kset with a missing namespace should fail.  Error: %v\n%s", err, errorOutput)
+	}
+
+	errorOutput, err = runKset("down", "-n", "typo")
+	if err != nil || !strings.Contains(errorOutput, `Warning: Unable to check that namespace "typo" exists: `) {
+		t.Errorf("kset should only warn when the cluster can't be asked.  Error: %v\n%s", err, errorOutput)
+	}
+}
+
+func TestPrompt(t *testing.T) {
+	workarea := t.TempDir()
+	kconfigYaml := "preferences:\n  helm_env: false\nnicknames:\n  dev: --context dev\n"
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(kconfigUtilCommand, "kset", "dev", "-n", "other")
+	cmd.Env = append(os.Environ(), fmt.Sprintf("TMPDIR=%s", workarea), "KUBECONFIG=")
+	outputBytes, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("kset failed: %v", err)
+	}
+	if !strings.Contains(string(outputBytes), "export _KCONFIG_NAMESPACE='other'\n") {
+		t.Errorf("kset didn't record the namespace.  Its output is:\n%s", outputBytes)
+	}
+	matches := extractKubeconfigEnvVar.FindStringSubmatch(string(outputBytes))
+	if matches == nil {
+		t.Fatalf("kset didn't set KUBECONFIG.  Its output is:\n%s", outputBytes)
+	}
+	kubeconfigEnvVar := matches[1]
+	sessionFilename := filepath.SplitList(kubeconfigEnvVar)[0]
+
+	runPrompt := func(ksetEnvVar string, args ...string) string {
+		cmd := exec.Command(kconfigUtilCommand, append([]string{"prompt"}, args...)...)
+		cmd.Env = append(os.Environ(), fmt.Sprintf("TMPDIR=%s", workarea), "KUBECONFIG="+kubeconfigEnvVar,
+			"_KCONFIG_KSET="+ksetEnvVar, "_KCONFIG_NAMESPACE=other")
+		outputBytes, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("kconfig-util prompt failed: %v", err)
+		}
+		return string(outputBytes)
+	}
+
+	for _, args := range [][]string{{"--fast"}, nil} {
+		if output := runPrompt("dev -n other", args...); output != "_KP='dev[ns=other]'\n" {
+			t.Errorf("Unexpected output of prompt %v before the namespace changed: %s", args, output)
+		}
+	}
+	if output := runPrompt(""); output != "" {
+		t.Errorf("Unexpected output of prompt without a kset environment: %s", output)
+	}
+
+	// Change the namespace the way "kubectl config set-context --current --namespace changed" would.
+	contents, err := os.ReadFile(sessionFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(sessionFilename, bytes.Replace(contents, []byte("namespace: other"), []byte("namespace: changed"), 1), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{{"--fast"}, nil} {
+		if output := runPrompt("dev -n other", args...); output != "_KP='dev[ns=changed]'\n" {
+			t.Errorf("Unexpected output of prompt %v after the namespace changed: %s", args, output)
+		}
+	}
+
+	// With the adopt_namespace_changes preference, the namespace becomes part of the environment.
+	kconfigYaml = "preferences:\n  adopt_namespace_changes: true\n  helm_env: false\nnicknames:\n  dev: --context dev\n"
+	err = os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "export _KCONFIG_NAMESPACE='changed'\nexport _KCONFIG_KSET='dev -n changed'\n_KP='dev[ns=changed]'\n"
+	if output := runPrompt("dev --namespace other", "--fast"); output != expected {
+		t.Errorf("Unexpected output of prompt when adopting the namespace: %s", output)
+	}
+}
+
+func TestKsetRefresh(t *testing.T) {
+	workarea := t.TempDir()
+	kubeconfigFilename := filepath.Join(workarea, "config")
+	kubeconfig, err := os.ReadFile(filepath.Join(testHomeDir, ".kube", "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(kubeconfigFilename, kubeconfig, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kconfigYaml := fmt.Sprintf("preferences:\n  base_kubeconfig: %s\nnicknames:\n  dev-user: --context dev --user devuser2\n", kubeconfigFilename)
+	err = os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A fake kubectl executable for the kubectl program to run.
+	fakeKubectl := filepath.Join(workarea, "fake-kubectl")
+	err = os.WriteFile(fakeKubectl, []byte("#!/bin/sh\nexit 0\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(kconfigUtilCommand, "kset", "dev-user", "-n", "other")
+	cmd.Env = append(os.Environ(), fmt.Sprintf("TMPDIR=%s", workarea), "KUBECONFIG=")
+	outputBytes, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("kset failed: %v", err)
+	}
+	matches := extractKubeconfigEnvVar.FindStringSubmatch(string(outputBytes))
+	if matches == nil {
+		t.Fatalf("kset didn't set KUBECONFIG.  Its output is:\n%s", outputBytes)
+	}
+	kubeconfigEnvVar := matches[1]
+	sessionFilename := filepath.SplitList(kubeconfigEnvVar)[0]
+
+	runKubectl := func() string {
+		cmd := exec.Command(kubectlCommand, "get", "pods")
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		cmd.Env = append(os.Environ(), fmt.Sprintf("TMPDIR=%s", workarea), "KUBECONFIG="+kubeconfigEnvVar,
+			"_KCONFIG_KSET=dev-user --namespace other", "_KCONFIG_KUBECTL="+fakeKubectl)
+		err := cmd.Run()
+		if err != nil {
+			t.Fatalf("kubectl failed: %v\n%s", err, stderr.String())
+		}
+		return stderr.String()
+	}
+
+	// Change the cluster of the context.  The kubectl program must update the session-local file.
+	changed := bytes.Replace(kubeconfig, []byte("    cluster: dev\n    namespace: devnamespace1"), []byte("    cluster: prod\n    namespace: devnamespace1"), 1)
+	err = os.WriteFile(kubeconfigFilename, changed, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	err = os.Chtimes(kubeconfigFilename, later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errorOutput := runKubectl()
+	if strings.Contains(errorOutput, "Warning") {
+		t.Errorf("kubectl printed a warning:\n%s", errorOutput)
+	}
+	contents, err := os.ReadFile(sessionFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(contents), "cluster: prod") || !strings.Contains(string(contents), "namespace: other") {
+		t.Errorf("The session-local file wasn't updated:\n%s", contents)
+	}
+
+	// Remove the context.  The kubectl program can only warn.
+	err = os.WriteFile(kubeconfigFilename, bytes.ReplaceAll(changed, []byte("name: dev\n"), []byte("name: renamed\n")), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Minute)
+	err = os.Chtimes(kubeconfigFilename, later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errorOutput = runKubectl()
+	expected := fmt.Sprintf("Warning: %s changed since kset set up this environment, and the environment couldn't be updated: Context \"dev\" doesn't exist.  Run kset again.", kubeconfigFilename)
+	if !strings.Contains(errorOutput, expected) {
+		t.Errorf("kubectl didn't warn about the change.  Its error output is:\n%s", errorOutput)
+	}
+}
+
+func TestRename(t *testing.T) {
+	err := copyConfigFile(t, "kconfig.yaml", &config.KconfigPreferences{DefaultNickname: "dev", DangerNicknames: []string{"prod-*", "dev"}})
+	if err == nil {
+		err = copyConfigFile(t, "kconfig-state.yaml", nil)
+	}
+	if err != nil {
+		t.Fatalf("Error copying the config files: %v", err)
+	}
+
+	cmd := exec.Command(kconfigUtilCommand, "rename", "dev", "devel")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), "_KCONFIG_KSET=dev -n other")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("rename failed: %v\n%s", err, stderr.String())
+	}
+	if string(output) != "Changed the definitions that extend \"dev\": dev-danger\n"+
+		"Changed the preferences that name \"dev\": danger_nicknames, default_nickname\n" {
+		t.Errorf("Unexpected output: %s", output)
+	}
+	if !strings.Contains(stderr.String(), "Warning: The kset environment of this shell uses the old nickname \"dev\".") {
+		t.Errorf("rename didn't warn about the kset environment.  Its error output is:\n%s", stderr.String())
+	}
+
+	kconfig, err := config.LoadKconfig(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := kconfig.Nicknames["dev"]; exists || kconfig.Nicknames["devel"] != "--context dev" ||
+		kconfig.Nicknames["dev-danger"] != "--extends devel --danger" {
+		t.Errorf("The nicknames weren't renamed: %v", kconfig.Nicknames)
+	}
+	if kconfig.Preferences.DefaultNickname != "devel" || !reflect.DeepEqual(kconfig.Preferences.DangerNicknames, []string{"prod-*", "devel"}) {
+		t.Errorf("The preferences weren't changed to use the new nickname: %+v", kconfig.Preferences)
+	}
+
+	state := config.ReadKconfigState()
+	if state.NamespaceHistory["devel"] == nil || state.NamespaceHistory["dev"] != nil ||
+		state.KsetHistory[1].Args[0] != "devel" || state.SavedSessions["work"].Args[0] != "devel" {
+		t.Errorf("The state wasn't changed to use the new nickname.")
+	}
+
+	err = exec.Command(kconfigUtilCommand, "rename", "dev", "devel").Run()
+	if err == nil {
+		t.Errorf("Renaming a nickname that isn't defined should fail.")
+	}
+}
+
+func TestEdit(t *testing.T) {
+	workarea := t.TempDir()
+	kconfigFilename := filepath.Join(testHomeDir, ".kube", "kconfig.yaml")
+	err := os.WriteFile(kconfigFilename, []byte("nicknames:\n  dev: --context dev\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nicknameFilename := filepath.Join(workarea, "state", "nicks", "dev.yaml")
+	err = os.MkdirAll(filepath.Dir(nicknameFilename), 0700)
+	if err == nil {
+		err = os.WriteFile(nicknameFilename, []byte("current-context: dev\n"), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The editors replace the file with the contents given.
+	writeEditor := func(name string, contents string) string {
+		filename := filepath.Join(workarea, name)
+		script := fmt.Sprintf("#!/bin/sh\ncat > \"$1\" <<'EOF'\n%sEOF\n", contents)
+		err := os.WriteFile(filename, []byte(script), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+	runEdit := func(editor string) (string, string, error) {
+		cmd := exec.Command(kconfigUtilCommand, "edit")
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		cmd.Env = append(os.Environ(), "VISUAL=", "EDITOR="+editor, "KCONFIG_STATE_DIR="+filepath.Join(workarea, "state"))
+		err := cmd.Run()
+		return stdout.String(), stderr.String(), err
+	}
+
+	// A broken file isn't installed.
+	_, errorOutput, err := runEdit(writeEditor("broken.sh", "preferences:\n  defualt_kubectl: oc\nnicknames:\n  dev: --extends missing\n"))
+	if err == nil || !strings.Contains(errorOutput, "unknown preference \"defualt_kubectl\"") ||
+		!strings.Contains(errorOutput, "Nickname \"missing\" is not defined.") {
+		t.Errorf("The broken file should be refused (%v):\n%s", err, errorOutput)
+	}
+	contents, _ := os.ReadFile(kconfigFilename)
+	if string(contents) != "nicknames:\n  dev: --context dev\n" {
+		t.Errorf("The broken file was installed:\n%s", contents)
+	}
+
+	output, errorOutput, err := runEdit("true")
+	if err != nil || output != "Edit cancelled, no changes made.\n" {
+		t.Errorf("Unexpected output without changes (%v): %s%s", err, output, errorOutput)
+	}
+
+	output, errorOutput, err = runEdit(writeEditor("good.sh", "nicknames:\n  dev: --context dev -n devnamespace2\n"))
+	if err != nil || !strings.HasPrefix(output, "Updated ") {
+		t.Fatalf("The edit failed (%v): %s%s", err, output, errorOutput)
+	}
+	contents, _ = os.ReadFile(kconfigFilename)
+	if string(contents) != "nicknames:\n  dev: --context dev -n devnamespace2\n" {
+		t.Errorf("The edited file wasn't installed:\n%s", contents)
+	}
+	if _, err := os.Stat(nicknameFilename); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("The kubectl config file of the nickname wasn't removed: %v", err)
+	}
+}
+
+func TestEditSymlinkedKconfig(t *testing.T) {
+	// The kconfig.yaml file is a relative link into a repository of dotfiles, which is where the
+	// changes should go.
+	workarea := t.TempDir()
+	kconfigFilename := filepath.Join(testHomeDir, ".kube", "kconfig.yaml")
+	targetFilename := filepath.Join(workarea, "dotfiles", "kconfig.yaml")
+	err := os.MkdirAll(filepath.Dir(targetFilename), 0700)
+	if err == nil {
+		err = os.WriteFile(targetFilename, []byte("nicknames:\n  dev: --context dev\n"), 0644)
+	}
+	if err == nil {
+		err = os.Remove(kconfigFilename)
+	}
+	var linkTarget string
+	if err == nil || errors.Is(err, os.ErrNotExist) {
+		linkTarget, err = filepath.Rel(filepath.Dir(kconfigFilename), targetFilename)
+	}
+	if err == nil {
+		err = os.Symlink(linkTarget, kconfigFilename)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(kconfigFilename) })
+
+	checkFiles := func(expected string) {
+		t.Helper()
+		info, err := os.Lstat(kconfigFilename)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("The kconfig.yaml link was replaced (%v).", err)
+		}
+		contents, err := os.ReadFile(targetFilename)
+		if err != nil || string(contents) != expected {
+			t.Errorf("Unexpected contents of the linked file (%v):\n%s", err, contents)
+		}
+	}
+
+	env := append(os.Environ(), "_KCONFIG_KSET=", "KCONFIG_STATE_DIR="+filepath.Join(workarea, "state"))
+	cmd := exec.Command(kconfigUtilCommand, "rename", "dev", "devel")
+	cmd.Env = env
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("rename failed: %v\n%s", err, output)
+	}
+	checkFiles("nicknames:\n  devel: --context dev\n")
+
+	editor := filepath.Join(workarea, "editor.sh")
+	err = os.WriteFile(editor, []byte("#!/bin/sh\nprintf 'nicknames:\\n  dev: --context dev\\n' > \"$1\"\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd = exec.Command(kconfigUtilCommand, "edit")
+	cmd.Env = append(env, "VISUAL=", "EDITOR="+editor)
+	output, err = cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("edit failed: %v\n%s", err, output)
+	}
+	checkFiles("nicknames:\n  dev: --context dev\n")
+}
+
+func TestWatch(t *testing.T) {
+	workarea := t.TempDir()
+	kconfigFilename := filepath.Join(testHomeDir, ".kube", "kconfig.yaml")
+	err := os.WriteFile(kconfigFilename, []byte("nicknames:\n  dev: --context dev\n  prod: --context prod\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := append(os.Environ(), "KCONFIG_STATE_DIR="+filepath.Join(workarea, "state"))
+
+	// The files as kubectl -k would have created them.
+	nicknameFilename := filepath.Join(workarea, "state", "nicks", "dev.yaml")
+	err = os.MkdirAll(filepath.Dir(nicknameFilename), 0700)
+	if err == nil {
+		err = os.WriteFile(nicknameFilename, []byte("current-context: dev\n"), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(kconfigFilename, []byte("nicknames:\n  dev: --context prod\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(workarea, "state", "nicks", "prod.yaml"), []byte("current-context: prod\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(kconfigUtilCommand, "watch", "--once")
+	cmd.Env = env
+	output, err := cmd.CombinedOutput()
+	if err != nil || string(output) != "Regenerated the kubectl config files of nicknames: dev\nRemoved the kubectl config files of nicknames: prod\n" {
+		t.Errorf("Unexpected output of watch (%v):\n%s", err, output)
+	}
+	contents, err := os.ReadFile(nicknameFilename)
+	if err != nil || !strings.Contains(string(contents), "current-context: prod\n") {
+		t.Errorf("The file of the nickname wasn't regenerated (%v):\n%s", err, contents)
+	}
+	if _, err := os.Stat(filepath.Join(workarea, "state", "nicks", "prod.yaml")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("The file of the undefined nickname wasn't removed: %v", err)
+	}
+}
+
+func TestPrecompute(t *testing.T) {
+	workarea := t.TempDir()
+	fakeKubectl := filepath.Join(workarea, "fake-kubectl")
+	err := os.WriteFile(fakeKubectl, []byte("#!/bin/sh\necho \"$KUBECONFIG\"\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kconfigYaml := fmt.Sprintf("preferences:\n  default_kubectl: %s\nnicknames:\n  dev: --context dev\n  missing: --context doesnt-exist\n", fakeKubectl)
+	err = os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := append(os.Environ(), "KCONFIG_STATE_DIR="+filepath.Join(workarea, "state"))
+
+	cmd := exec.Command(kconfigUtilCommand, "precompute", "--all")
+	cmd.Env = env
+	output, err := cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "Precomputed the kubectl config files of nicknames: dev\n") ||
+		!strings.Contains(string(output), "Unable to precompute the kubectl config files of nicknames: missing\n") {
+		t.Errorf("Unexpected output of precompute (%v):\n%s", err, output)
+	}
+
+	// The nickname's file links to the precomputed file, which kubectl -k uses.
+	linkTarget, err := filepath.EvalSymlinks(filepath.Join(workarea, "state", "nicks", "dev.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runKubectl := func() (string, string) {
+		cmd := exec.Command(kubectlCommand, "-k", "dev")
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		cmd.Env = env
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("kubectl -k failed: %v\n%s", err, stderr.String())
+		}
+		return strings.Split(strings.TrimSpace(string(output)), string(os.PathListSeparator))[0], stderr.String()
+	}
+	kubeconfig, _ := runKubectl()
+	if kubeconfig != linkTarget || filepath.Base(filepath.Dir(kubeconfig)) != "precomputed" {
+		t.Errorf("kubectl -k used \"%s\" rather than the precomputed \"%s\".", kubeconfig, linkTarget)
+	}
+
+	cmd = exec.Command(kconfigUtilCommand, "precompute", "dev")
+	cmd.Env = env
+	output, err = cmd.CombinedOutput()
+	if err != nil || string(output) != "Already up to date: dev\n" {
+		t.Errorf("Unexpected output of a second precompute (%v):\n%s", err, output)
+	}
+
+	// A precomputed file that's been changed is generated again.
+	file, err := os.OpenFile(linkTarget, os.O_APPEND|os.O_WRONLY, 0)
+	if err == nil {
+		_, err = file.WriteString("current-context: prod\n")
+		file.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	kubeconfig, errorOutput := runKubectl()
+	if !strings.Contains(errorOutput, "has been changed, so it's being generated again.") {
+		t.Errorf("The changed file wasn't reported:\n%s", errorOutput)
+	}
+	contents, err := os.ReadFile(kubeconfig)
+	if err != nil || !strings.Contains(string(contents), "current-context: dev\n") {
+		t.Errorf("The changed file wasn't generated again (%v):\n%s", err, contents)
+	}
+
+	// A change to the kubectl config files gives a different precomputed file.
+	kubeconfigFilename := filepath.Join(testHomeDir, ".kube", "config")
+	now := time.Now()
+	err = os.Chtimes(kubeconfigFilename, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changedKubeconfig, _ := runKubectl(); changedKubeconfig == kubeconfig {
+		t.Errorf("The precomputed file wasn't replaced after the kubectl config file changed.")
+	}
+	if _, err := os.Stat(kubeconfig); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("The previous precomputed file wasn't removed: %v", err)
+	}
+}
+
+func TestPrecomputeSelfContained(t *testing.T) {
+	workarea := t.TempDir()
+	tokenFilename := filepath.Join(workarea, "token")
+	kubeconfigFilename := filepath.Join(workarea, "config")
+	err := os.WriteFile(tokenFilename, []byte("first-token\n"), 0600)
+	if err == nil {
+		err = os.WriteFile(kubeconfigFilename, []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: tf
+  cluster:
+    server: http://tf-cluster/
+contexts:
+- name: tf
+  context:
+    cluster: tf
+    user: tf
+users:
+- name: tf
+  user:
+    tokenFile: %s
+`, tokenFilename)), 0600)
+	}
+	if err == nil {
+		kconfigYaml := fmt.Sprintf("nicknames:\n  sc: --kubeconfig %s --context tf --self-contained\n", kubeconfigFilename)
+		err = os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := append(os.Environ(), "KCONFIG_STATE_DIR="+filepath.Join(workarea, "state"), "KCONFIG_FEATURES=self-contained")
+
+	precompute := func(expectedOutput string, expectedToken string) {
+		cmd := exec.Command(kconfigUtilCommand, "precompute", "sc")
+		cmd.Env = env
+		output, err := cmd.CombinedOutput()
+		if err != nil || string(output) != expectedOutput {
+			t.Errorf("Unexpected output of precompute (%v):\n%s", err, output)
+		}
+		contents, err := os.ReadFile(filepath.Join(workarea, "state", "nicks", "sc.yaml"))
+		if err != nil || !strings.Contains(string(contents), "token: "+expectedToken+"\n") {
+			t.Errorf("The precomputed file doesn't have the token \"%s\" (%v):\n%s", expectedToken, err, contents)
+		}
+	}
+	precompute("Precomputed the kubectl config files of nicknames: sc\n", "first-token")
+	precompute("Already up to date: sc\n", "first-token")
+
+	// A rotated token is embedded again, even though the kubectl config file hasn't changed.
+	err = os.WriteFile(tokenFilename, []byte("second-token-after-rotation\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	precompute("Precomputed the kubectl config files of nicknames: sc\n", "second-token-after-rotation")
+}
+
+func TestMigrate(t *testing.T) {
+	kconfigFilename := filepath.Join(testHomeDir, ".kube", "kconfig.yaml")
+	kconfigYaml := "# Nicknames for the lab.\npreferences:\n  defualt_kubectl: kubectl-1.28\nnicknames:\n  dev: --context dev\n"
+	err := os.WriteFile(kconfigFilename, []byte(kconfigYaml), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A misspelled preference is reported rather than silently ignored.
+	cmd := exec.Command(kconfigUtilCommand, "kset", "dev")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), "KUBECONFIG=", "_KCONFIG_KSET=")
+	err = cmd.Run()
+	if err != nil || !strings.Contains(stderr.String(), "line 3: unknown preference \"defualt_kubectl\".") {
+		t.Errorf("The unknown preference wasn't reported (%v):\n%s", err, stderr.String())
+	}
+
+	output, err := exec.Command(kconfigUtilCommand, "migrate").Output()
+	if err != nil || string(output) != fmt.Sprintf("Upgraded \"%s\" to version %s.\n", kconfigFilename, config.KconfigAPIVersion) {
+		t.Errorf("Unexpected output of migrate (%v): %s", err, output)
+	}
+	contents, err := os.ReadFile(kconfigFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(contents), "# Nicknames for the lab.\napiVersion: kconfig/v1\nkind: Kconfig\npreferences:\n") {
+		t.Errorf("Unexpected migrated file:\n%s", contents)
+	}
+
+	output, err = exec.Command(kconfigUtilCommand, "migrate").Output()
+	if err != nil || !strings.Contains(string(output), "is already at version") {
+		t.Errorf("Unexpected output of a second migrate (%v): %s", err, output)
+	}
+
+	// A file that's at the current version, but without the kind, only has its header fixed.
+	err = os.WriteFile(kconfigFilename, []byte("apiVersion: "+config.KconfigAPIVersion+"\nnicknames:\n  dev: --context dev\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err = exec.Command(kconfigUtilCommand, "migrate").Output()
+	if err != nil || string(output) != fmt.Sprintf("Upgraded \"%s\" to version %s.\n", kconfigFilename, config.KconfigAPIVersion) {
+		t.Errorf("Unexpected output of migrate for a file without the kind (%v): %s", err, output)
+	}
+	contents, err = os.ReadFile(kconfigFilename)
+	if err != nil || string(contents) != "apiVersion: "+config.KconfigAPIVersion+"\nkind: Kconfig\nnicknames:\n  dev: --context dev\n" {
+		t.Errorf("Unexpected file after migrating a file without the kind (%v):\n%s", err, contents)
+	}
+
+	// A file written for a newer version of kconfig is used, with a warning, but not migrated.
+	err = os.WriteFile(kconfigFilename, []byte("apiVersion: kconfig/v99\nfuture_preference: true\nnicknames:\n  dev: --context dev\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr.Reset()
+	cmd = exec.Command(kconfigUtilCommand, "kset", "dev")
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), "KUBECONFIG=", "_KCONFIG_KSET=")
+	err = cmd.Run()
+	if err != nil || !strings.Contains(stderr.String(), "is newer than") || strings.Contains(stderr.String(), "future_preference") {
+		t.Errorf("Unexpected warnings for a newer file (%v):\n%s", err, stderr.String())
+	}
+	err = exec.Command(kconfigUtilCommand, "migrate").Run()
+	if err == nil {
+		t.Errorf("Migrating a newer file should fail.")
+	}
+}
+
+func TestInit(t *testing.T) {
+	// With the default names, the output is the setup script.
+	output, err := exec.Command(kconfigUtilCommand, "init", "bash").Output()
+	if err != nil {
+		t.Fatalf("init failed: %v", err)
+	}
+	setupScript, err := os.ReadFile(filepath.Join("..", "..", "setup", "kconfig-setup.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != string(setupScript) {
+		t.Errorf("The output of \"init bash\" isn't the same as setup/kconfig-setup.sh.")
+	}
+
+	output, err = exec.Command(kconfigUtilCommand, "init", "zsh", "--kset-name", "kc", "--koff-name", "kcoff").Output()
+	if err != nil {
+		t.Fatalf("init failed: %v", err)
+	}
+	for _, expected := range []string{"function kc() {", "function kcoff() {", "function kcurrent() {",
+		`eval "$(kconfig-util completion zsh --kset-name kc --koff-name kcoff)"`,
+		`eval "$(kconfig-util direnv-hook --kset-name kc --koff-name kcoff)"`, "complete -r kc kcoff kubectl"} {
+		if !strings.Contains(string(output), expected) {
+			t.Errorf("The output of init doesn't contain \"%s\".", expected)
+		}
+	}
+	if strings.Contains(string(output), "function kset()") {
+		t.Errorf("The output of init defines the kset function.")
+	}
+
+	output, err = exec.Command(kconfigUtilCommand, "completion", "bash", "--kset-name", "kc").Output()
+	if err != nil || !strings.Contains(string(output), "complete -F _kconfig_cmpl kc\n") || !strings.Contains(string(output), `complete -W "" koff`) {
+		t.Errorf("The completion script doesn't use the function names (%v):\n%s", err, output)
+	}
+
+	for _, args := range [][]string{{"init", "bash", "--kset-name", "k;rm"}, {"init", "bash", "--koff-name", "kset"}, {"init", "fish"}} {
+		err = exec.Command(kconfigUtilCommand, args...).Run()
+		if err == nil {
+			t.Errorf("%v should fail.", args)
+		}
+	}
+}
+
+func TestVersion(t *testing.T) {
+	// Scripts parse the single line of the default output.
+	output, err := exec.Command(kconfigUtilCommand, "version").Output()
+	if err != nil || strings.Count(string(output), "\n") != 1 {
+		t.Errorf("version should print a single line (%v):\n%s", err, output)
+	}
+
+	output, err = exec.Command(kconfigUtilCommand, "version", "--long").Output()
+	if err != nil {
+		t.Fatalf("version failed: %v", err)
+	}
+	for _, expected := range []string{"Version:", "Commit:", "Build date:", "Go version:", fmt.Sprintf("Shell protocol: %d\n", common.ShellProtocolVersion)} {
+		if !strings.Contains(string(output), expected) {
+			t.Errorf("The output of version doesn't contain \"%s\":\n%s", expected, output)
+		}
+	}
+
+	command := exec.Command(kconfigUtilCommand, "version", "--check")
+	command.Env = append(os.Environ(), fmt.Sprintf("_KCONFIG_SHELL_PROTOCOL=%d", common.ShellProtocolVersion))
+	output, err = command.Output()
+	if err != nil || !strings.Contains(string(output), "match") {
+		t.Errorf("version --check should succeed (%v): %s", err, output)
+	}
+
+	for _, value := range []string{"", fmt.Sprint(common.ShellProtocolVersion - 1)} {
+		command = exec.Command(kconfigUtilCommand, "version", "--check")
+		command.Env = append(os.Environ(), "_KCONFIG_SHELL_PROTOCOL="+value)
+		var stderr bytes.Buffer
+		command.Stderr = &stderr
+		err = command.Run()
+		if err == nil || !strings.HasPrefix(stderr.String(), "Warning: ") {
+			t.Errorf("version --check should fail with a warning for \"%s\" (%v): %s", value, err, stderr.String())
+		}
+	}
+}
+
+func TestKsetErrorCodes(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	kconfigYaml := fmt.Sprintf(`preferences:
+  validate_namespace: true
+nicknames:
+  no-context: --context doesnt-exist
+  rejected: --context dev --server %s --insecure-skip-tls-verify
+`, server.URL)
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runKset := func(env string, args ...string) string {
+		cmd := exec.Command(kconfigUtilCommand, args...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		cmd.Env = append(os.Environ(), "KUBECONFIG=", env)
+		_, err := cmd.Output()
+		if err == nil {
+			t.Errorf("%v should fail.", args)
+		}
+		return stderr.String()
+	}
+
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"kset", "doesnt-exist"}, "unknown-nickname"},
+		{[]string{"kset", "no-context"}, "missing-context"},
+		{[]string{"kset", "rejected", "-n", "default"}, "auth-failure"},
+		{[]string{"kset", "--bad-option"}, "usage"},
+	}
+	for _, testCase := range testCases {
+		errorOutput := runKset(config.ErrorCodeEnvVar+"=1", testCase.args...)
+		if !strings.HasSuffix(errorOutput, "\n_KCONFIG_ERROR="+testCase.expected+"\n") {
+			t.Errorf("%v should end with error code \"%s\":\n%s", testCase.args, testCase.expected, errorOutput)
+		}
+	}
+
+	errorOutput := runKset("", "--error-codes", "kset", "doesnt-exist")
+	if errorOutput != "Nickname \"doesnt-exist\" is not defined.\n_KCONFIG_ERROR=unknown-nickname\n" {
+		t.Errorf("Unexpected output with --error-codes:\n%s", errorOutput)
+	}
+
+	errorOutput = runKset("", "kset", "doesnt-exist")
+	if strings.Contains(errorOutput, "_KCONFIG_ERROR") {
+		t.Errorf("Error codes should only be printed when enabled:\n%s", errorOutput)
+	}
+}
+
+func TestKsetNicknameSuggestions(t *testing.T) {
+	kconfigYaml := `nicknames:
+  staging: --context stage
+  staging-east: --context stage
+  staging-west: --context stage
+  staging-north: --context stage
+  stage-old: --context stage
+  prod: --context prod
+  broken: --extends missing
+`
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		nickname string
+		expected string
+	}{
+		{"stagign", "Nickname \"stagign\" is not defined.\nDid you mean: staging?\n_KCONFIG_ERROR=unknown-nickname\n"},
+		{"stagin", "Nickname \"stagin\" is not defined.\nDid you mean: staging, staging-east, staging-west?\n_KCONFIG_ERROR=unknown-nickname\n"},
+		{"rpod", "Nickname \"rpod\" is not defined.\nDid you mean: prod?\n_KCONFIG_ERROR=unknown-nickname\n"},
+		{"unrelated", "Nickname \"unrelated\" is not defined.\n_KCONFIG_ERROR=unknown-nickname\n"},
+		{"broken", "Nickname \"missing\" is not defined.  It's referenced by: broken\n_KCONFIG_ERROR=unknown-nickname\n"},
+	}
+	for _, testCase := range testCases {
+		cmd := exec.Command(kconfigUtilCommand, "--error-codes", "kset", testCase.nickname)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		cmd.Env = append(os.Environ(), "KUBECONFIG=")
+		_, err := cmd.Output()
+		if err == nil {
+			t.Errorf("kset %s should fail.", testCase.nickname)
+		}
+		if stderr.String() != testCase.expected {
+			t.Errorf("Unexpected error for kset %s:\n%s", testCase.nickname, stderr.String())
+		}
+	}
+}
+
+func TestKsetAbbreviatedNicknames(t *testing.T) {
+	writeKconfig := func(abbreviated bool) {
+		kconfigYaml := fmt.Sprintf(`preferences:
+  abbreviated_nicknames: %v
+nicknames:
+  prod: --context prod
+  stage: --context stage
+  stage-east: --context stage
+  Dev: --context dev
+`, abbreviated)
+		err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	runKset := func(nickname string) (string, string, error) {
+		cmd := exec.Command(kconfigUtilCommand, "--error-codes", "kset", "--print-only", nickname)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		cmd.Env = append(os.Environ(), "KUBECONFIG=")
+		err := cmd.Run()
+		return stdout.String(), stderr.String(), err
+	}
+
+	writeKconfig(true)
+	for abbreviation, nickname := range map[string]string{"pr": "prod", "PROD": "prod", "dev": "Dev", "stage": "stage", "STAGE-": "stage-east"} {
+		stdout, stderr, err := runKset(abbreviation)
+		if err != nil {
+			t.Errorf("kset %s failed: %v: %s", abbreviation, err, stderr)
+		} else if !strings.Contains(stdout, "# _KCONFIG_KSET="+nickname+"\n") {
+			t.Errorf("kset %s should use nickname \"%s\":\n%s", abbreviation, nickname, stdout)
+		}
+	}
+
+	_, stderr, err := runKset("st")
+	if err == nil || stderr != "Nickname \"st\" is ambiguous.  It matches: stage, stage-east\n_KCONFIG_ERROR=ambiguous-nickname\n" {
+		t.Errorf("An ambiguous abbreviation should fail (%v):\n%s", err, stderr)
+	}
+
+	writeKconfig(false)
+	_, stderr, err = runKset("pr")
+	if err == nil || !strings.Contains(stderr, "_KCONFIG_ERROR=unknown-nickname") {
+		t.Errorf("Abbreviations should only be accepted with the preference (%v):\n%s", err, stderr)
+	}
+}
+
+func TestNicknameAliases(t *testing.T) {
+	kconfigYaml := `nicknames:
+  prod: --context prod --alias p --alias production --tag live
+  prod-ro: --extends p --read-only
+  stage: --context stage --alias s --alias stg
+  dev: --context dev --alias s --alias prod
+`
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runKconfigUtil := func(args ...string) (string, error) {
+		cmd := exec.Command(kconfigUtilCommand, args...)
+		cmd.Env = append(os.Environ(), "KUBECONFIG=")
+		output, err := cmd.CombinedOutput()
+		return string(output), err
+	}
+
+	for alias, nickname := range map[string]string{"p": "prod", "production": "prod", "stg": "stage", "prod": "prod"} {
+		output, err := runKconfigUtil("kset", "--print-only", alias)
+		if err != nil {
+			t.Errorf("kset %s failed: %v: %s", alias, err, output)
+		} else if !strings.Contains(output, "# _KCONFIG_KSET="+nickname+"\n") {
+			t.Errorf("kset %s should use nickname \"%s\":\n%s", alias, nickname, output)
+		}
+	}
+
+	output, err := runKconfigUtil("kset", "--print-only", "s")
+	if err == nil {
+		t.Errorf("An alias given by more than one nickname should be ignored:\n%s", output)
+	}
+
+	output, err = runKconfigUtil("explain", "prod-ro")
+	if err != nil || !strings.Contains(output, "  prod: --context prod") {
+		t.Errorf("--extends should accept an alias (%v):\n%s", err, output)
+	}
+	output, err = runKconfigUtil("explain", "p")
+	if err != nil || !strings.Contains(output, "Aliases: p, production\n") {
+		t.Errorf("explain should list the aliases (%v):\n%s", err, output)
+	}
+
+	output, err = runKconfigUtil("complete", "--descriptions", "--tag", "live", "p")
+	if err != nil {
+		t.Fatalf("complete failed: %v: %s", err, output)
+	}
+	completions := strings.Split(strings.TrimSpace(output), "\n")
+	sort.Strings(completions)
+	expected := []string{"p\talias of prod", "prod\tprod/prodnamespace1", "prod-ro\tprod/prodnamespace1", "production\talias of prod"}
+	if !reflect.DeepEqual(completions, expected) {
+		t.Errorf("Expected completions %q, got %q.", expected, completions)
+	}
+}
+
+func TestDefaultNickname(t *testing.T) {
+	fakeKubectl := filepath.Join(t.TempDir(), "fake-kubectl")
+	err := os.WriteFile(fakeKubectl, []byte("#!/bin/sh\necho \"ran $*\"\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKconfig := func(defaultNickname string) {
+		kconfigYaml := fmt.Sprintf("preferences:\n  default_kubectl: %s\n  default_nickname: %s\nnicknames:\n  dev: --context dev\n", fakeKubectl, defaultNickname)
+		err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeKconfig("dev")
+	cmd := exec.Command(kconfigUtilCommand, "kset")
+	cmd.Env = append(os.Environ(), "KUBECONFIG=", "_KCONFIG_KSET=")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("kset without a nickname should use the default nickname: %v", err)
+	}
+	if !strings.Contains(string(output), "export _KCONFIG_KSET='dev'\n") {
+		t.Errorf("kset should activate the default nickname:\n%s", output)
+	}
+
+	cmd = exec.Command(kubectlCommand, "-k", "-n", "kube-system", "get", "pods")
+	cmd.Env = append(os.Environ(), "_KCONFIG_KSET=")
+	output, err = cmd.Output()
+	if err != nil || string(output) != "ran -n kube-system get pods\n" {
+		t.Errorf("kubectl -k without a nickname should use the default nickname (%v): %s", err, output)
+	}
+
+	writeKconfig("")
+	cmd = exec.Command(kconfigUtilCommand, "kset")
+	cmd.Env = append(os.Environ(), "KUBECONFIG=", "_KCONFIG_KSET=")
+	if err = cmd.Run(); err == nil {
+		t.Errorf("kset without a nickname should fail without the default_nickname preference.")
+	}
+	cmd = exec.Command(kubectlCommand, "-k")
+	cmd.Env = append(os.Environ(), "_KCONFIG_KSET=")
+	if err = cmd.Run(); err == nil {
+		t.Errorf("kubectl -k without a nickname should fail without the default_nickname preference.")
+	}
+}
+
+func TestKsetVerbosity(t *testing.T) {
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte("preferences:\n  set_terminal_title: true\nnicknames:\n  dev: --context dev\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runKset := func(args ...string) (string, string, error) {
+		cmd := exec.Command(kconfigUtilCommand, append([]string{"kset", "dev"}, args...)...)
+		cmd.Env = append(os.Environ(), "KUBECONFIG=", "_KCONFIG_KSET=")
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		err := cmd.Run()
+		return stdout.String(), stderr.String(), err
+	}
+
+	stdout, stderr, err := runKset("--quiet")
+	if err != nil {
+		t.Fatalf("kset --quiet failed: %v: %s", err, stderr)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+		if !strings.HasPrefix(line, "export ") && !strings.HasPrefix(line, "unset ") {
+			t.Errorf("kset --quiet should only print export commands, not: %s", line)
+		}
+	}
+	for _, expected := range []string{"export _KCONFIG_NAMESPACE='devnamespace1'\n", "export _KCONFIG_CONTEXT='dev'\n",
+		"export _KCONFIG_CLUSTER='dev'\n", "export _KCONFIG_SERVER='http://dev-cluster/'\n"} {
+		if !strings.Contains(stdout, expected) {
+			t.Errorf("kset should print \"%s\":\n%s", strings.TrimSpace(expected), stdout)
+		}
+	}
+
+	stdout, stderr, err = runKset("--verbose")
+	if err != nil {
+		t.Fatalf("kset --verbose failed: %v: %s", err, stderr)
+	}
+	for _, expected := range []string{"Nickname:    dev\n", "Context:     dev\n", "Cluster:     dev\n", "Server:      http://dev-cluster/\n",
+		"Namespace:   devnamespace1\n", "User:        devuser1\n", "kubectl:     kubectl\n"} {
+		if !strings.Contains(stderr, expected) {
+			t.Errorf("The summary doesn't contain \"%s\":\n%s", expected, stderr)
+		}
+	}
+	if !strings.Contains(stdout, "_KP='dev'\n") {
+		t.Errorf("kset --verbose should still update the prompt:\n%s", stdout)
+	}
+
+	_, _, err = runKset("--quiet", "--verbose")
+	if err == nil {
+		t.Errorf("--quiet and --verbose can't be used together.")
+	}
+}
+
+func TestLogging(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "kconfig.log")
+	cmd := exec.Command(kconfigUtilCommand, "kset", "--debug", "doesnt-exist")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), "KUBECONFIG=", common.LogFileEnvVar+"="+logFile, common.LogFormatEnvVar+"=json")
+	_, err := cmd.Output()
+	if err == nil {
+		t.Fatalf("kset of an undefined nickname should fail.")
+	}
+	if stderr.String() != "Nickname \"doesnt-exist\" is not defined.\n" {
+		t.Errorf("Only the error should be written to standard error:\n%s", stderr.String())
+	}
+
+	contents, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	for _, line := range lines {
+		var entry map[string]interface{}
+		err = json.Unmarshal([]byte(line), &entry)
+		if err != nil || entry["level"] != "debug" {
+			t.Errorf("Unexpected log message (%v): %s", err, line)
+		}
+	}
+	if !strings.Contains(string(contents), "Invoking command: kset") {
+		t.Errorf("The log file doesn't have the expected messages:\n%s", contents)
+	}
+
+	err = exec.Command(kconfigUtilCommand, "--log-format", "xml", "version").Run()
+	if err == nil {
+		t.Errorf("An unrecognized log format should fail.")
+	}
+}
+
+func TestKubectlHelp(t *testing.T) {
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte("nicknames:\n  dev: --context dev\n  prod: --context prod\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeKubectl := filepath.Join(t.TempDir(), "fake-kubectl")
+	err = os.WriteFile(fakeKubectl, []byte("#!/bin/sh\nexit 0\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(kubectlCommand, "--kconfig-help")
+	cmd.Env = append(os.Environ(), "_KCONFIG_KSET=dev", "_KCONFIG_KUBECTL="+fakeKubectl)
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("kubectl --kconfig-help failed: %v", err)
+	}
+	for _, expected := range []string{"-k, --kconfig NICKNAME", "Nickname in effect:       dev (kset environment)\n",
+		"kubectl executable:       " + fakeKubectl + "\n", "Nicknames:\n  dev\n  prod\n"} {
+		if !strings.Contains(string(output), expected) {
+			t.Errorf("The help doesn't contain \"%s\":\n%s", expected, output)
+		}
+	}
+
+	cmd = exec.Command(kubectlCommand, "-k")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err == nil || !strings.Contains(stderr.String(), "kubectl --kconfig-help") {
+		t.Errorf("kubectl -k without a nickname should fail with a hint (%v): %s", err, stderr.String())
+	}
+}
+
+func TestKubectlUnknownNickname(t *testing.T) {
+	fakeKubectl := filepath.Join(t.TempDir(), "fake-kubectl")
+	err := os.WriteFile(fakeKubectl, []byte("#!/bin/sh\necho \"ran $*\"\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kconfigYaml := fmt.Sprintf("preferences:\n  default_kubectl: %s\nnicknames:\n  dev: --context dev\n  dev-east: --context dev\n  prod: --context prod\n", fakeKubectl)
+	err = os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runKubectl := func(nickname string, env ...string) (string, string, error) {
+		cmd := exec.Command(kubectlCommand, "-k", nickname, "get", "pods")
+		cmd.Env = append(os.Environ(), env...)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		err := cmd.Run()
+		return stdout.String(), stderr.String(), err
+	}
+
+	stdout, stderr, err := runKubectl("dve")
+	if err == nil || stdout != "" {
+		t.Errorf("kubectl -k with an unknown nickname should fail without running kubectl: %s", stdout)
+	}
+	if !strings.Contains(stderr, "Nickname \"dve\" is not defined.\nDid you mean: dev?") {
+		t.Errorf("Expected nickname suggestions, got: %s", stderr)
+	}
+
+	_, stderr, _ = runKubectl("east")
+	if !strings.Contains(stderr, "Did you mean: dev-east?") {
+		t.Errorf("Nicknames that contain the nickname should be suggested, got: %s", stderr)
+	}
+
+	stdout, stderr, err = runKubectl("zzzzzz")
+	if err == nil || strings.Contains(stderr, "Did you mean") {
+		t.Errorf("Nothing should be suggested for a nickname that isn't close to any (%v): %s", err, stderr)
+	}
+
+	stdout, stderr, err = runKubectl("dve", "KCONFIG_WRAPPER_LENIENT=1")
+	if err != nil {
+		t.Fatalf("kubectl -k with an unknown nickname should run kubectl when lenient: %v: %s", err, stderr)
+	}
+	if stdout != "ran get pods\n" {
+		t.Errorf("kubectl should be run without the nickname, got: %s", stdout)
+	}
+	if !strings.Contains(stderr, "Warning: Nickname \"dve\" is not defined.") || !strings.Contains(stderr, "KCONFIG_WRAPPER_LENIENT") {
+		t.Errorf("Expected a warning about the nickname, got: %s", stderr)
+	}
+}
+
+func TestKsetAutoKubectlVersion(t *testing.T) {
+	var versionRequests int
+	apiServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/version" {
+			http.NotFound(w, r)
+			return
+		}
+		versionRequests++
+		fmt.Fprint(w, `{"gitVersion":"v1.27.3-eks-a5565ad"}`)
+	}))
+	defer apiServer.Close()
+
+	fakeKubectl := []byte("#!/bin/sh\necho kubectl 1.27\n")
+	downloadServer := newKubectlDownloadServer("v1.27.9", fakeKubectl)
+	defer downloadServer.Close()
+
+	homeDir := t.TempDir()
+	binDir := filepath.Join(homeDir, "kubectl-bins")
+	kconfigYaml := filepath.Join(homeDir, "kconfig.yaml")
+	writeKconfigYaml := func(download bool) {
+		contents := fmt.Sprintf(`preferences:
+  auto_kubectl_version: true
+  kubectl_bin_directory: %s
+  download_kubectl: %v
+  kubectl_download_url: %s
+nicknames:
+  dev: --kubeconfig %s --context dev --server %s --insecure-skip-tls-verify
+  explicit: kubectl-custom --kubeconfig %s --context dev --server %s --insecure-skip-tls-verify
+`, binDir, download, downloadServer.URL, filepath.Join(testHomeDir, ".kube", "config"), apiServer.URL,
+			filepath.Join(testHomeDir, ".kube", "config"), apiServer.URL)
+		err := os.WriteFile(kconfigYaml, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runKset := func(nickname string) (string, string) {
+		cmd := exec.Command(kconfigUtilCommand, "kset", nickname)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		cmd.Env = append(os.Environ(), "HOME="+homeDir, "KCONFIG_CONFIG="+kconfigYaml, "TMPDIR="+homeDir, "KUBECONFIG=")
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("kset failed: %v\n%s", err, stderr.String())
+		}
+		matches := extractKubectlExe.FindStringSubmatch(string(output))
+		if matches == nil {
+			t.Fatalf("kset didn't set _KCONFIG_KUBECTL:\n%s", output)
+		}
+		return matches[1], stderr.String()
+	}
+
+	// Without downloading, the default kubectl is used with a warning.
+	writeKconfigYaml(false)
+	kubectl, errorOutput := runKset("dev")
+	if kubectl != "kubectl" || !strings.Contains(errorOutput, "download_kubectl preference") {
+		t.Errorf("Expected the default kubectl with a warning, got \"%s\":\n%s", kubectl, errorOutput)
+	}
+
+	writeKconfigYaml(true)
+	kubectl, _ = runKset("dev")
+	expected := filepath.Join(binDir, "kubectl-1.27")
+	if kubectl != expected {
+		t.Errorf("Expected kubectl executable \"%s\", got \"%s\".", expected, kubectl)
+	}
+	contents, err := os.ReadFile(expected)
+	if err != nil || !bytes.Equal(contents, fakeKubectl) {
+		t.Errorf("The kubectl executable wasn't downloaded (%v).", err)
+	}
+
+	kubectl, errorOutput = runKset("explicit")
+	if kubectl != "kubectl-custom" || errorOutput != "" {
+		t.Errorf("A kubectl executable named by the definition should be used, got \"%s\":\n%s", kubectl, errorOutput)
+	}
+
+	// The API server's version is cached.
+	if versionRequests != 1 {
+		t.Errorf("Expected the API server's version to be requested once, not %d times.", versionRequests)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	var namespaceRequests int
+	apiServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces" {
+			http.NotFound(w, r)
+			return
+		}
+		namespaceRequests++
+		fmt.Fprint(w, `{"items":[{"metadata":{"name":"team-a"}},{"metadata":{"name":"default"}}]}`)
+	}))
+	defer apiServer.Close()
+
+	homeDir := t.TempDir()
+	kconfigYaml := filepath.Join(homeDir, "kconfig.yaml")
+	contents := fmt.Sprintf("nicknames:\n  dev: --kubeconfig %s --context dev --server %s --insecure-skip-tls-verify\n",
+		filepath.Join(testHomeDir, ".kube", "config"), apiServer.URL)
+	err := os.WriteFile(kconfigYaml, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateYaml := `namespace_history:
+  dev:
+    current: team-b
+    previous: team-c
+kset_history:
+  - args: [dev, -n, team-d]
+    time: 2024-01-02T03:04:05Z
+  - args: [other, -n, team-e]
+    time: 2024-01-02T03:04:05Z
+`
+	err = os.MkdirAll(filepath.Join(homeDir, ".kube"), 0755)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(homeDir, ".kube", "kconfig-state.yaml"), []byte(stateYaml), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runNamespaces := func(args ...string) (string, error) {
+		cmd := exec.Command(kconfigUtilCommand, append([]string{"namespaces"}, args...)...)
+		cmd.Env = append(os.Environ(), "HOME="+homeDir, "KCONFIG_CONFIG="+kconfigYaml, "_KCONFIG_KSET=")
+		output, err := cmd.Output()
+		return string(output), err
+	}
+
+	// The cluster's namespaces are cached.
+	for i := 0; i < 2; i++ {
+		output, err := runNamespaces("dev")
+		if err != nil || output != "default\nteam-a\n" {
+			t.Errorf("Unexpected result of namespaces (%v): %s", err, output)
+		}
+	}
+	if namespaceRequests != 1 {
+		t.Errorf("Expected the namespaces to be requested once, not %d times.", namespaceRequests)
+	}
+
+	output, err := runNamespaces("--no-network", "dev")
+	if err != nil || output != "devnamespace1\nteam-b\nteam-c\nteam-d\n" {
+		t.Errorf("Unexpected result of namespaces --no-network (%v): %s", err, output)
+	}
+
+	_, err = runNamespaces()
+	if err == nil {
+		t.Errorf("namespaces without a nickname or a kset environment should fail.")
+	}
+}
+
+func TestExecTool(t *testing.T) {
+	workarea := t.TempDir()
+	kconfigYaml := "preferences:\n  exec_tool: sh -c\nnicknames:\n  dev: --context dev\n"
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script := `echo "$_KCONFIG_KSET"; grep "namespace:" "${KUBECONFIG%%:*}"; echo "$KUBECONFIG" >&2; exit 3`
+	cmd := exec.Command(kconfigUtilCommand, "exec-tool", "dev", "-n", "other", "--", script)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), fmt.Sprintf("TMPDIR=%s", workarea), "_KCONFIG_KSET=", "KUBECONFIG=")
+	output, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Fatalf("Expected the tool's exit status of 3: %v\n%s", err, stderr.String())
+	}
+	if string(output) != "dev -n other\n    namespace: other\n" {
+		t.Errorf("Unexpected output of the tool: %s", output)
+	}
+
+	// The kubectl config file is removed when the tool exits.
+	kubeconfigFilename := filepath.SplitList(strings.TrimSpace(stderr.String()))[0]
+	if !strings.HasPrefix(kubeconfigFilename, workarea) {
+		t.Errorf("Unexpected kubectl config file \"%s\".", kubeconfigFilename)
+	}
+	if _, err := os.Stat(filepath.Dir(kubeconfigFilename)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("The temporary directory of the kubectl config file wasn't removed: %v", err)
+	}
+
+	cmd = exec.Command(kconfigUtilCommand, "exec-tool", "--tool", "true", "dev")
+	cmd.Env = append(os.Environ(), fmt.Sprintf("TMPDIR=%s", workarea))
+	err = cmd.Run()
+	if err != nil {
+		t.Errorf("The --tool option should be used instead of the exec_tool preference: %v", err)
+	}
+}
+
+func TestLogs(t *testing.T) {
+	workarea := t.TempDir()
+	sternDir := filepath.Join(workarea, "stern-bin")
+	script := []byte("#!/bin/sh\necho \"${0##*/} $* ns=$_KCONFIG_NAMESPACE\"\n")
+	err := os.MkdirAll(sternDir, 0755)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(sternDir, "stern"), script, 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(workarea, "kubectl"), script, 0755)
+	}
+	if err == nil {
+		kconfigYaml := fmt.Sprintf("nicknames:\n  dev: %s --context dev\n", filepath.Join(workarea, "kubectl"))
+		err = os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Path     string
+		Args     []string
+		Expected string
+	}{
+		{sternDir, []string{"dev", "app=web", "--", "--since", "10m"}, "stern --selector app=web --since 10m ns=devnamespace1\n"},
+		{sternDir, []string{"dev", "-n", "other", "web-.*"}, "stern web-.* ns=other\n"},
+		{sternDir, []string{"--kubectl", "dev", "app=web"}, "kubectl logs --follow --selector app=web --prefix --all-containers ns=devnamespace1\n"},
+		{workarea, []string{"dev", "web-1"}, "kubectl logs --follow web-1 ns=devnamespace1\n"},
+	}
+	for _, testCase := range testCases {
+		cmd := exec.Command(kconfigUtilCommand, append([]string{"logs"}, testCase.Args...)...)
+		cmd.Env = append(os.Environ(), "PATH="+testCase.Path, "TMPDIR="+workarea, "_KCONFIG_KSET=", "KUBECONFIG=")
+		output, err := cmd.Output()
+		if err != nil || string(output) != testCase.Expected {
+			t.Errorf("Unexpected output of logs %v (%v): %s", testCase.Args, err, output)
+		}
+	}
+
+	err = exec.Command(kconfigUtilCommand, "logs", "dev").Run()
+	if err == nil {
+		t.Errorf("logs without a pod selector should fail.")
+	}
+}
+
+func TestPortForward(t *testing.T) {
+	workarea := t.TempDir()
+	fakeKubectl := filepath.Join(workarea, "kubectl")
+	script := "#!/bin/sh\ncase \"$2\" in svc/missing) echo \"error: services \\\"missing\\\" not found\" >&2; exit 1;; esac\n" +
+		"echo \"Forwarding from 127.0.0.1:${3%%:*} -> ${3#*:}\"\nexec sleep 60\n"
+	err := os.WriteFile(fakeKubectl, []byte(script), 0755)
+	if err == nil {
+		kconfigYaml := fmt.Sprintf("nicknames:\n  dev: %s --context dev\n  stage: %s --context stage\n", fakeKubectl, fakeKubectl)
+		err = os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stateDir := t.TempDir()
+	env := append(os.Environ(), "KCONFIG_STATE_DIR="+stateDir, "_KCONFIG_KSET=", "KUBECONFIG=")
+	runPf := func(args ...string) (string, string, error) {
+		cmd := exec.Command(kconfigUtilCommand, append([]string{"pf"}, args...)...)
+		cmd.Env = env
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		return string(output), stderr.String(), err
+	}
+	t.Cleanup(func() { _, _, _ = runPf("stop", "--all") })
+
+	startedRegexp := regexp.MustCompile(`Started port forward ([0-9]+) for nickname "([a-z]+)"`)
+	var ids []string
+	for _, args := range [][]string{{"dev", "svc/web", "8080:80"}, {"stage", "-n", "other", "pod/api", "9090:90"}} {
+		output, errorOutput, err := runPf(args...)
+		if err != nil {
+			t.Fatalf("pf %v failed: %v\n%s", args, err, errorOutput)
+		}
+		match := startedRegexp.FindStringSubmatch(output)
+		if !strings.HasPrefix(output, "Forwarding from 127.0.0.1:") || match == nil || match[2] != args[0] {
+			t.Fatalf("Unexpected output of pf %v:\n%s", args, output)
+		}
+		ids = append(ids, match[1])
+	}
+
+	_, errorOutput, err := runPf("dev", "svc/missing", "8081:80")
+	if err == nil || !strings.Contains(errorOutput, `error: services "missing" not found`) {
+		t.Errorf("pf didn't fail with kubectl's output when kubectl exited (%v):\n%s", err, errorOutput)
+	}
+
+	output, _, err := runPf("list")
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if err != nil || len(lines) != 3 || !strings.HasPrefix(lines[0], "ID ") ||
+		!regexp.MustCompile(`^`+ids[0]+` +dev +devnamespace1 +svc/web +8080:80 .* running$`).MatchString(lines[1]) ||
+		!regexp.MustCompile(`^`+ids[1]+` +stage +other +pod/api +9090:90 .* running$`).MatchString(lines[2]) {
+		t.Errorf("Unexpected output of pf list (%v):\n%s", err, output)
+	}
+
+	output, _, err = runPf("stop", ids[0])
+	if err != nil || output != fmt.Sprintf("Stopped port forward %s to svc/web for nickname \"dev\".\n", ids[0]) {
+		t.Errorf("Unexpected result of pf stop (%v):\n%s", err, output)
+	}
+	output, _, err = runPf("stop", "stage")
+	if err != nil || output != fmt.Sprintf("Stopped port forward %s to pod/api for nickname \"stage\".\n", ids[1]) {
+		t.Errorf("Unexpected result of pf stop for a nickname (%v):\n%s", err, output)
+	}
+	output, _, err = runPf("list")
+	if err != nil || output != "" {
+		t.Errorf("pf list still lists port forwards (%v):\n%s", err, output)
+	}
+
+	_, _, err = runPf("stop", ids[0])
+	if err == nil {
+		t.Errorf("pf stop didn't fail for a port forward that was already stopped.")
+	}
+
+	// A process that has the ID of a port forward, but didn't start when it did, as if the ID were
+	// reused, isn't stopped.
+	output, errorOutput, err = runPf("dev", "svc/web", "8080:80")
+	match := startedRegexp.FindStringSubmatch(output)
+	if err != nil || match == nil {
+		t.Fatalf("pf failed (%v):\n%s%s", err, output, errorOutput)
+	}
+	records, err := filepath.Glob(filepath.Join(stateDir, "port-forwards", "*", "forward.yaml"))
+	if err != nil || len(records) != 1 {
+		t.Fatalf("Expected one port forward record, but found %v (%v).", records, err)
+	}
+	record, err := os.ReadFile(records[0])
+	if err != nil || !strings.Contains(string(record), "process_started: ") {
+		t.Fatalf("The port forward record doesn't have the process start time (%v):\n%s", err, string(record))
+	}
+	record = regexp.MustCompile(`process_started: .*`).ReplaceAll(record, []byte("process_started: Thu Jan  1 00:00:00 1970"))
+	err = os.WriteFile(records[0], record, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, _, err = runPf("stop", match[1])
+	if err != nil {
+		t.Errorf("pf stop failed for a port forward whose process ID was reused (%v):\n%s", err, output)
+	}
+	pid, _ := strconv.Atoi(match[1])
+	if syscall.Kill(pid, 0) != nil {
+		t.Errorf("pf stop ended the process that was given the port forward's process ID.")
+	}
+	_ = syscall.Kill(-pid, syscall.SIGTERM)
+}
+
+func TestDaemon(t *testing.T) {
+	kconfigYaml := "nicknames:\n  dev: --context dev\n"
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stateDir := t.TempDir()
+	logFile := filepath.Join(t.TempDir(), "kconfig.log")
+	env := append(os.Environ(), "KCONFIG_STATE_DIR="+stateDir, "_KCONFIG_KSET=", "KUBECONFIG=", common.LogFileEnvVar+"="+logFile)
+	runCommand := func(args ...string) (string, error) {
+		cmd := exec.Command(kconfigUtilCommand, args...)
+		cmd.Env = env
+		output, err := cmd.Output()
+		return string(output), err
+	}
+
+	output, err := runCommand("daemon", "--status")
+	if err == nil || output != "The kconfig daemon isn't running.\n" {
+		t.Errorf("Unexpected status before the daemon was started (%v):\n%s", err, output)
+	}
+
+	daemon := exec.Command(kconfigUtilCommand, "daemon")
+	daemon.Env = env
+	err = daemon.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- daemon.Wait() }()
+	t.Cleanup(func() {
+		_ = daemon.Process.Kill()
+		<-exited
+	})
+	for i := 0; i < 100; i++ {
+		if _, err = runCommand("daemon", "--status"); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("The daemon didn't start.")
+	}
+
+	if _, err = runCommand("daemon"); err == nil {
+		t.Errorf("A second daemon was started.")
+	}
+
+	for i := 0; i < 2; i++ {
+		output, err = runCommand("kset", "dev", "--debug")
+		if err != nil || !strings.Contains(output, `export _KCONFIG_KSET='dev'`) {
+			t.Fatalf("kset with the daemon failed (%v):\n%s", err, output)
+		}
+	}
+	contents, _ := os.ReadFile(logFile)
+	if !strings.Contains(string(contents), "kconfig.yaml") || !strings.Contains(string(contents), "from the daemon") {
+		t.Errorf("kset didn't get the files from the daemon:\n%s", contents)
+	}
+	output, err = runCommand("daemon", "--status")
+	if err != nil || !regexp.MustCompile(`^The kconfig daemon is running:  process [0-9]+, .*, 2 cached files and search paths, 2 requests answered from memory\.\n$`).MatchString(output) {
+		t.Errorf("Unexpected status of the daemon (%v):\n%s", err, output)
+	}
+
+	// A change to kconfig.yaml is seen right away.
+	err = os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml+"  prod: --context prod\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err = runCommand("kset", "prod")
+	if err != nil || !strings.Contains(output, `export _KCONFIG_KSET='prod'`) {
+		t.Errorf("kset didn't see the change to kconfig.yaml (%v):\n%s", err, output)
+	}
+
+	output, err = runCommand("daemon", "--stop")
+	if err != nil || output != "Stopped the kconfig daemon.\n" {
+		t.Errorf("Unexpected result of stopping the daemon (%v):\n%s", err, output)
+	}
+	select {
+	case err = <-exited:
+		exited <- err
+		if err != nil {
+			t.Errorf("The daemon exited with an error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("The daemon didn't exit.")
+	}
+	if _, err = os.Stat(filepath.Join(stateDir, "daemon.sock")); err == nil {
+		t.Errorf("The daemon's socket wasn't removed.")
+	}
+
+	output, err = runCommand("kset", "prod")
+	if err != nil || !strings.Contains(output, `export _KCONFIG_KSET='prod'`) {
+		t.Errorf("kset without the daemon failed (%v):\n%s", err, output)
+	}
+}
+
+func TestRun(t *testing.T) {
+	workarea := t.TempDir()
+	kconfigYaml := "nicknames:\n  dev: --context dev\n"
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(kconfigUtilCommand, "run", "dev", "--", "sh", "-c", `echo "$_KCONFIG_KSET $_KCONFIG_CONTEXT $_KCONFIG_NAMESPACE $_KCONFIG_CLUSTER $_KCONFIG_SERVER"; kubeconfig="${KUBECONFIG%%:*}"; grep "current-context:" "$kubeconfig"; echo "$kubeconfig" >&2; exit 5`)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), fmt.Sprintf("TMPDIR=%s", workarea))
+	output, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 5 {
+		t.Errorf("Expected the command's exit status of 5: %v", err)
+	}
+	if string(output) != "dev dev devnamespace1 dev http://dev-cluster/\ncurrent-context: dev\n" {
+		t.Errorf("Unexpected output of the command: %s", output)
+	}
+	if _, err := os.Stat(strings.TrimSpace(stderr.String())); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("The kubectl config file \"%s\" wasn't removed: %v", strings.TrimSpace(stderr.String()), err)
+	}
+
+	cmd = exec.Command(kconfigUtilCommand, "run", "dev")
+	err = cmd.Run()
+	if err == nil {
+		t.Errorf("run without a command should fail.")
+	}
+}
+
+func TestShell(t *testing.T) {
+	workarea := t.TempDir()
+	kconfigYaml := "nicknames:\n  dev: --context dev\n"
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runShell := func(shell string) (string, string) {
+		cmd := exec.Command(kconfigUtilCommand, "shell", "dev", "-n", "other")
+		cmd.Stdin = strings.NewReader(`echo "$_KCONFIG_KSET $_KCONFIG_NAMESPACE $_KCONFIG_SUBSHELL"; grep "namespace:" "${KUBECONFIG%%:*}"; echo "KUBECONFIG=$KUBECONFIG" >&2; exit 4` + "\n")
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		cmd.Env = append(os.Environ(), "SHELL="+shell, fmt.Sprintf("TMPDIR=%s", workarea), "KUBECONFIG=", "_KCONFIG_KSET=", "PS1=$ ")
+		output, err := cmd.Output()
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 4 {
+			t.Errorf("Expected the shell's exit status of 4: %v\n%s", err, stderr.String())
 		}
-		t.Logf("Actual file \"%s\":", localConfigFileName)
-		rawContents, err = os.ReadFile(localConfigFileName)
-		if err != nil {
-			t.Logf("... Error reading file:  %v", err)
-		} else {
-			t.Log(string(rawContents))
+		if string(output) != "dev -n other other dev\n    namespace: other\n" {
+			t.Errorf("Unexpected output of the shell: %s", output)
 		}
-		t.Fail()
-		return true
+
+		// The session-local kubectl config file is removed when the shell exits.
+		matches := regexp.MustCompile(`KUBECONFIG=(/[^:\n]*)`).FindStringSubmatch(stderr.String())
+		if matches == nil || !strings.HasPrefix(matches[1], workarea) {
+			t.Fatalf("Unexpected error output of the shell: %s", stderr.String())
+		}
+		if _, err := os.Stat(matches[1]); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("The session-local kubectl config file wasn't removed: %v", err)
+		}
+		return string(output), stderr.String()
 	}
 
-	return false
+	_, errorOutput := runShell("/bin/sh")
+	if !strings.Contains(errorOutput, "(dev[ns=other]) $ ") {
+		t.Errorf("The shell prompt wasn't changed: %s", errorOutput)
+	}
+
+	if bash, err := exec.LookPath("bash"); err == nil {
+		_, errorOutput := runShell(bash)
+		if !strings.Contains(errorOutput, "(dev[ns=other]) ") {
+			t.Errorf("The bash prompt wasn't changed: %s", errorOutput)
+		}
+	}
 }
 
-func readYamlFile(path string) (map[string]interface{}, error) {
-	file, err := os.Open(path)
+func TestKsetEmitConfig(t *testing.T) {
+	bash, err := exec.LookPath("bash")
 	if err != nil {
-		return nil, err
+		t.Skip("The test needs bash.")
 	}
-	defer file.Close()
-	context := make(map[string]interface{})
-	err = yaml.NewDecoder(file).Decode(context)
+	workarea := t.TempDir()
+	kconfigYaml := "nicknames:\n  dev: --context dev\n"
+	err = os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
 	if err != nil {
-		return nil, fmt.Errorf("Error parsing file \"%s\": %v", path, err)
+		t.Fatal(err)
+	}
+	binDir, err := filepath.Abs(filepath.Dir(kconfigUtilCommand))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(bash, "-c", `source ../../setup/kconfig-setup.sh
+kset --emit-config dev -n other || exit
+echo "$KUBECONFIG"
+grep "namespace:" "${KUBECONFIG%%:*}"
+sh -c 'grep "current-context:" "${KUBECONFIG%%:*}"'
+kset --emit-config dev -n third || exit
+grep "namespace:" "${KUBECONFIG%%:*}"
+koff
+echo "[$KUBECONFIG] [$_KCONFIG_FD]"`)
+	stateDir := filepath.Join(workarea, "state")
+	cmd.Env = append(os.Environ(), "PATH="+binDir+string(os.PathListSeparator)+os.Getenv("PATH"),
+		"XDG_RUNTIME_DIR="+workarea, "KCONFIG_STATE_DIR="+stateDir, "KUBECONFIG=", "_KCONFIG_KSET=", "TMUX=")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("The shell functions failed: %v\n%s", err, output)
+	}
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	expected := []string{"    namespace: other", "current-context: kconfig_context", "    namespace: third", "[] []"}
+	if len(lines) != 5 || !regexp.MustCompile(`^/dev/fd/[0-9]+:`).MatchString(lines[0]) || strings.Join(lines[1:], "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected output of the shell functions:\n%s", output)
+	}
+
+	// Nothing is left in the state directory or the runtime directory.
+	for _, dir := range []string{filepath.Join(stateDir, "sessions"), workarea} {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if entry.Name() != "state" {
+				t.Errorf("Unexpected file \"%s\" in \"%s\".", entry.Name(), dir)
+			}
+		}
+	}
+
+	cmd = exec.Command(kconfigUtilCommand, "kset", "--emit-config", "--output-file", filepath.Join(workarea, "config"), "dev")
+	if err = cmd.Run(); err == nil {
+		t.Errorf("kset with --emit-config and --output-file should fail.")
 	}
-	return context, nil
 }
 
-var emptyPreferences = config.KconfigPreferences{}
+func TestKsetRestrictions(t *testing.T) {
+	kconfigYaml := `preferences:
+  allowed_contexts:
+    - dev.*
+    - prod
+  denied_clusters:
+    - prod
+    - http://stage-cluster/
+nicknames:
+  dev: --context dev
+  stage: --context stage
+  prod: --context prod
+  sneaky: --context dev --server http://stage-cluster/
+  renamed: --context dev --cluster prod
+  reset: --context dev --set context.cluster=prod
+`
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-func copyConfigFile(t *testing.T, filename string, preferences *config.KconfigPreferences) error {
-	target := filepath.Join(testHomeDir, ".kube", filename)
-	err := os.Remove(target)
-	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		return err
+	testCases := []struct {
+		nickname string
+		expected string
+	}{
+		{"dev", ""},
+		{"stage", `Nickname "stage" can't be used: context "stage" isn't allowed by the allowed_contexts preference.`},
+		{"prod", `Nickname "prod" can't be used: cluster "prod" isn't allowed by the denied_clusters preference.`},
+		{"sneaky", `Nickname "sneaky" can't be used: cluster "http://stage-cluster/" isn't allowed by the denied_clusters preference.`},
+		{"renamed", `Nickname "renamed" can't be used: cluster "prod" isn't allowed by the denied_clusters preference.`},
+		{"reset", `Nickname "reset" can't be used: cluster "prod" isn't allowed by the denied_clusters preference.`},
+	}
+	checkRestrictionCases := func() {
+		for _, testCase := range testCases {
+			cmd := exec.Command(kconfigUtilCommand, "kset", testCase.nickname)
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			cmd.Env = append(os.Environ(), "KUBECONFIG=", "_KCONFIG_KSET=", config.ErrorCodeEnvVar+"=1")
+			err := cmd.Run()
+			if testCase.expected == "" {
+				if err != nil {
+					t.Errorf("kset %s failed: %v\n%s", testCase.nickname, err, stderr.String())
+				}
+				continue
+			}
+			if err == nil || stderr.String() != testCase.expected+"\n_KCONFIG_ERROR=restricted\n" {
+				t.Errorf("kset %s should be refused (%v):\n%s", testCase.nickname, err, stderr.String())
+			}
+		}
 	}
+	checkRestrictionCases()
 
-	sourcePath := filepath.Join("testdata", "configs", filename)
-	//sourceFile, err := os.Open(sourcePath)
-	//if err != nil {
-	//	if err == os.ErrNotExist {
-	//		return nil
-	//	}
-	//	return err
-	//}
-	//
-	//defer sourceFile.Close()
+	// An allow list has to match both the cluster's name and its server, so a cluster with an
+	// allowed name can't point at another server.
+	kconfigYaml = `preferences:
+  allowed_clusters:
+    - dev
+    - http://dev-cluster/
+nicknames:
+  dev: --context dev
+  mismatched: --context dev --server http://prod-cluster/
+`
+	err = os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases = []struct {
+		nickname string
+		expected string
+	}{
+		{"dev", ""},
+		{"mismatched", `Nickname "mismatched" can't be used: cluster "http://prod-cluster/" isn't allowed by the allowed_clusters preference.`},
+	}
+	checkRestrictionCases()
 
-	targetFile, err := os.Create(target)
+	// An invalid pattern refuses everything.
+	kconfigYaml = "preferences:\n  denied_contexts:\n    - \"(\"\nnicknames:\n  dev: --context dev\n"
+	err = os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
 	if err != nil {
-		return err
+		t.Fatal(err)
 	}
+	cmd := exec.Command(kconfigUtilCommand, "kset", "dev")
+	cmd.Env = append(os.Environ(), "KUBECONFIG=", "_KCONFIG_KSET=")
+	output, err := cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "Invalid pattern in the denied_contexts preference") {
+		t.Errorf("An invalid pattern should be an error (%v):\n%s", err, output)
+	}
+}
 
-	defer targetFile.Close()
+func TestEncryptCredentials(t *testing.T) {
+	workarea := t.TempDir()
+	binDir := writeFakeSecretTool(t, workarea)
+	kconfigYaml := "preferences:\n  encrypt_credentials: keychain\nnicknames:\n  dev: --context dev\n"
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := append(os.Environ(), "PATH="+binDir+string(os.PathListSeparator)+os.Getenv("PATH"),
+		"KCONFIG_STATE_DIR="+filepath.Join(workarea, "state"), "KUBECONFIG=", "_KCONFIG_KSET=")
 
-	if preferences != nil && *preferences != emptyPreferences {
-		kconfig := config.Kconfig{}
-		kconfig.Preferences = *preferences
-		if kconfig.Preferences.BaseKubeconfig != "" {
-			kconfig.Preferences.BaseKubeconfig = strings.ReplaceAll(kconfig.Preferences.BaseKubeconfig, "$HOME", testHomeDir)
+	// Impersonation copies the user, with its token, to the session-local file.
+	cmd := exec.Command(kconfigUtilCommand, "kset", "dev", "--as", "admin")
+	cmd.Env = env
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("kset failed: %v\n%s", err, output)
+	}
+	localConfigFilename := strings.Split(extractKubeconfigEnvVar.FindStringSubmatch(string(output))[1], string(os.PathListSeparator))[0]
+
+	// Neither the session-local file nor the credentials file has the token in it.
+	contents, err := os.ReadFile(localConfigFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(contents), "devuser1-token") || !strings.Contains(string(contents), "decrypt-credentials") {
+		t.Errorf("The token wasn't replaced by the credential plugin:\n%s", contents)
+	}
+	credentials, err := os.ReadFile(localConfigFilename + ".credentials")
+	if err != nil || !strings.HasPrefix(string(credentials), "keychain\n") || strings.Contains(string(credentials), "devuser1-token") {
+		t.Errorf("Unexpected credentials file (%v):\n%s", err, credentials)
+	}
+
+	cmd = exec.Command(kconfigUtilCommand, "decrypt-credentials", localConfigFilename+".credentials", "kconfig_user")
+	cmd.Env = env
+	output, err = cmd.Output()
+	if err != nil || !strings.Contains(string(output), `"token":"devuser1-token"`) || !strings.Contains(string(output), `"kind":"ExecCredential"`) {
+		t.Errorf("Unexpected output of decrypt-credentials (%v): %s", err, output)
+	}
+
+	// koff removes the credentials file too.
+	cmd = exec.Command(kconfigUtilCommand, "koff")
+	cmd.Env = append(env, "KUBECONFIG="+localConfigFilename)
+	err = cmd.Run()
+	if err != nil {
+		t.Fatalf("koff failed: %v", err)
+	}
+	if _, err := os.Stat(localConfigFilename + ".credentials"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("The credentials file wasn't removed: %v", err)
+	}
+}
+
+func TestKeychainToken(t *testing.T) {
+	workarea := t.TempDir()
+	binDir := writeFakeSecretTool(t, workarea)
+	kconfigYaml := "nicknames:\n  dev: --context dev\n  dev-kc: --extends dev --keychain-token dev-token\n"
+	err := os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := append(os.Environ(), "PATH="+binDir+string(os.PathListSeparator)+os.Getenv("PATH"),
+		"KCONFIG_STATE_DIR="+filepath.Join(workarea, "state"), "KUBECONFIG=", "_KCONFIG_KSET=")
+	runKconfigUtil := func(stdin string, args ...string) (string, error) {
+		cmd := exec.Command(kconfigUtilCommand, args...)
+		cmd.Env = env
+		cmd.Stdin = strings.NewReader(stdin)
+		output, err := cmd.Output()
+		return string(output), err
+	}
+
+	_, err = runKconfigUtil("s3cret\n", "secret", "set", "dev-token")
+	if err != nil {
+		t.Fatalf("secret set failed: %v", err)
+	}
+	output, err := runKconfigUtil("", "secret", "get", "dev-token")
+	if err != nil || output != "s3cret\n" {
+		t.Errorf("Unexpected output of secret get (%v): %s", err, output)
+	}
+
+	output, err = runKconfigUtil("", "kset", "dev-kc")
+	if err != nil {
+		t.Fatalf("kset failed: %v", err)
+	}
+	localConfigFilename := strings.Split(extractKubeconfigEnvVar.FindStringSubmatch(output)[1], string(os.PathListSeparator))[0]
+	contents, err := os.ReadFile(localConfigFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(contents), "user: kconfig_keychain_user") || !strings.Contains(string(contents), "- keychain-token\n      - dev-token\n") {
+		t.Errorf("The session-local file doesn't use the keychain token:\n%s", contents)
+	}
+
+	output, err = runKconfigUtil("", "keychain-token", "dev-token")
+	if err != nil || !strings.Contains(output, `"token":"s3cret"`) {
+		t.Errorf("Unexpected output of keychain-token (%v): %s", err, output)
+	}
+
+	_, err = runKconfigUtil("", "secret", "rm", "dev-token")
+	if err != nil {
+		t.Fatalf("secret rm failed: %v", err)
+	}
+	if _, err = runKconfigUtil("", "keychain-token", "dev-token"); err == nil {
+		t.Errorf("keychain-token should fail for a removed secret.")
+	}
+}
+
+func TestVaultCredentials(t *testing.T) {
+	var secretRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secretRequests++
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
 		}
-		err = yaml.NewEncoder(targetFile).Encode(kconfig)
-		if err != nil {
-			return fmt.Errorf("Error encoding preferences to file \"%s\": %v", target, err)
+		switch r.URL.Path {
+		case "/v1/secret/data/k8s/dev":
+			fmt.Fprint(w, `{"lease_duration":0,"data":{"data":{"token":"dev-token"},"metadata":{"version":3}}}`)
+		case "/v1/kubernetes/creds/dev":
+			fmt.Fprint(w, `{"lease_duration":3600,"data":{"service_account_token":"sa-token"}}`)
+		default:
+			http.NotFound(w, r)
 		}
+	}))
+	defer server.Close()
+
+	homeDir := t.TempDir()
+	binDir := writeFakeSecretTool(t, homeDir)
+	runVaultCredentials := func(path string, vaultToken string) (string, error) {
+		cmd := exec.Command(kconfigUtilCommand, "vault-credentials", path)
+		cmd.Env = append(os.Environ(), "HOME="+homeDir, "VAULT_ADDR="+server.URL, "VAULT_TOKEN="+vaultToken,
+			"PATH="+binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+		output, err := cmd.Output()
+		return string(output), err
 	}
 
-	// Read contents into memory so we can easy string substitutions
-	contents, err := os.ReadFile(sourcePath)
+	// The KV version 2 secret is read once, and then taken from the cache.
+	for idx := 0; idx < 2; idx++ {
+		output, err := runVaultCredentials("secret/data/k8s/dev", "vault-token")
+		if err != nil || !strings.Contains(output, `"token":"dev-token"`) || !strings.Contains(output, `"expirationTimestamp":`) {
+			t.Errorf("Unexpected output of vault-credentials (%v): %s", err, output)
+		}
+	}
+	if secretRequests != 1 {
+		t.Errorf("The cached credentials weren't used.  There were %d requests.", secretRequests)
+	}
+
+	output, err := runVaultCredentials("kubernetes/creds/dev", "vault-token")
+	if err != nil || !strings.Contains(output, `"token":"sa-token"`) {
+		t.Errorf("Unexpected output of vault-credentials (%v): %s", err, output)
+	}
+	if _, err = runVaultCredentials("secret/data/k8s/other", "wrong-token"); err == nil {
+		t.Errorf("vault-credentials should fail when Vault rejects the token.")
+	}
+
+	// With the encrypt_credentials preference, the plain text cache isn't used, and the credentials
+	// are cached encrypted.
+	err = os.MkdirAll(filepath.Join(homeDir, ".kube"), 0755)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(homeDir, ".kube", "kconfig.yaml"), []byte("preferences:\n  encrypt_credentials: keychain\n"), 0644)
+	}
 	if err != nil {
-		return fmt.Errorf("Error reading from file \"%s\": %v", sourcePath, err)
+		t.Fatal(err)
+	}
+	secretRequests = 0
+	for idx := 0; idx < 2; idx++ {
+		output, err = runVaultCredentials("secret/data/k8s/dev", "vault-token")
+		if err != nil || !strings.Contains(output, `"token":"dev-token"`) {
+			t.Errorf("Unexpected output of vault-credentials with encryption (%v): %s", err, output)
+		}
+	}
+	if secretRequests != 1 {
+		t.Errorf("The encrypted cache wasn't used as expected.  There were %d requests.", secretRequests)
+	}
+	cacheFiles, _ := filepath.Glob(filepath.Join(homeDir, ".kube", "kconfig-cache", "vault", "*"))
+	for _, cacheFilename := range cacheFiles {
+		contents, err := os.ReadFile(cacheFilename)
+		if err != nil || strings.Contains(string(contents), "dev-token") {
+			t.Errorf("The cache file \"%s\" holds the token in plain text (%v).", cacheFilename, err)
+		}
 	}
 
-	contents = bytes.ReplaceAll(contents, []byte("$HOME"), []byte(testHomeDir))
-	_, err = targetFile.Write(contents)
+	// The local kubectl config file of a nickname with --vault-path runs vault-credentials.
+	kconfigYaml := "nicknames:\n  dev: --context dev --vault-path secret/data/k8s/dev\n  both: --extends dev --keychain-token dev-token\n"
+	err = os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
 	if err != nil {
-		return fmt.Errorf("Error writing to file \"%s\": %v", target, err)
+		t.Fatal(err)
 	}
+	output, err = runKsetPrintOnly("dev")
+	if err != nil || !strings.Contains(output, "user: kconfig_vault_user") || !strings.Contains(output, "- vault-credentials\n      - secret/data/k8s/dev\n") {
+		t.Errorf("Unexpected kubectl config for a nickname with --vault-path (%v):\n%s", err, output)
+	}
+	if _, err = runKsetPrintOnly("both"); err == nil {
+		t.Errorf("A nickname with both --vault-path and --keychain-token should fail.")
+	}
+}
 
-	//_, err = io.Copy(targetFile, sourceFile)
-	//if err != nil {
-	//	return fmt.Errorf("Error copying from file \"%s\" to \"%s\": %v", sourcePath, target, err)
-	//}
-	////t.Logf("Copied file \"%s\" to \"%s\"", sourcePath, target)
+// runKsetPrintOnly runs "kconfig-util kset --print-only" for the nickname, returning its output.
+func runKsetPrintOnly(nickname string) (string, error) {
+	cmd := exec.Command(kconfigUtilCommand, "kset", "--print-only", nickname)
+	cmd.Env = append(os.Environ(), "KUBECONFIG=", "_KCONFIG_KSET=")
+	output, err := cmd.Output()
+	return string(output), err
+}
 
-	t.Cleanup(func() {
-		err := os.Remove(target)
-		if err != nil && err != os.ErrNotExist {
-			t.Logf("Error removing file \"%s\" after testcase: %v", target, err)
-		}
-		//t.Logf("Removed file \"%s\"", target)
+// writeFakeSecretTool writes a stand-in for the secret-tool command to a "bin" directory in the
+// work area, and returns the directory.  It keeps the items in files in the work area.
+func writeFakeSecretTool(t *testing.T, workarea string) string {
+	binDir := filepath.Join(workarea, "bin")
+	err := os.Mkdir(binDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fakeSecretTool := fmt.Sprintf(`#!/bin/sh
+command="$1"
+shift
+while [ $# -gt 0 ]; do
+   case "$1" in
+   service) service="$2"; shift ;;
+   account) account="$2"; shift ;;
+   esac
+   shift
+done
+item="%s/keychain-$service-$account"
+case "$command" in
+lookup) cat "$item" 2>/dev/null || exit 1 ;;
+store) cat > "$item" ;;
+clear) rm -f "$item" ;;
+esac
+`, workarea)
+	err = os.WriteFile(filepath.Join(binDir, "secret-tool"), []byte(fakeSecretTool), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return binDir
+}
+
+// newKubectlDownloadServer starts a server like the one kubectl releases are downloaded from, with
+// the release as the latest one of its minor version, and the contents as its executable.
+func newKubectlDownloadServer(release string, contents []byte) *httptest.Server {
+	checksum := sha256.Sum256(contents)
+	binaryPath := fmt.Sprintf("/%s/bin/%s/%s/kubectl", release, runtime.GOOS, runtime.GOARCH)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stable-"+release[1:strings.LastIndex(release, ".")]+".txt", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, release)
+	})
+	mux.HandleFunc(binaryPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(contents)
+	})
+	mux.HandleFunc(binaryPath+".sha256", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, hex.EncodeToString(checksum[:]))
 	})
+	return httptest.NewServer(mux)
+}
 
-	return nil
+func TestFetchKubectl(t *testing.T) {
+	fakeKubectl := []byte("#!/bin/sh\necho kubectl 1.28\n")
+	downloadServer := newKubectlDownloadServer("v1.28.3", fakeKubectl)
+	defer downloadServer.Close()
+
+	homeDir := t.TempDir()
+	binDir := filepath.Join(homeDir, "kubectl-bins")
+	kconfigYaml := filepath.Join(homeDir, "kconfig.yaml")
+	err := os.WriteFile(kconfigYaml, []byte(fmt.Sprintf(`preferences:
+  kubectl_bin_directory: %s
+  kubectl_download_url: %s
+nicknames:
+  dev: kubectl@1.28 --kubeconfig %s --context dev
+  missing: kubectl@1.29 --kubeconfig %s --context dev
+`, binDir, downloadServer.URL, filepath.Join(testHomeDir, ".kube", "config"), filepath.Join(testHomeDir, ".kube", "config"))), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) (string, string, error) {
+		cmd := exec.Command(kconfigUtilCommand, args...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		cmd.Env = append(os.Environ(), "HOME="+homeDir, "KCONFIG_CONFIG="+kconfigYaml, "TMPDIR="+homeDir, "KUBECONFIG=")
+		output, err := cmd.Output()
+		return string(output), stderr.String(), err
+	}
+
+	for _, version := range []string{"1.28", "v1.28.3"} {
+		output, errorOutput, err := run("fetch-kubectl", version)
+		if err != nil || !strings.Contains(output, "Installed kubectl v1.28.3") {
+			t.Errorf("fetch-kubectl %s failed: %v\n%s%s", version, err, output, errorOutput)
+		}
+	}
+	for _, filename := range []string{"kubectl-1.28", "kubectl-1.28.3"} {
+		contents, err := os.ReadFile(filepath.Join(binDir, filename))
+		if err != nil || !bytes.Equal(contents, fakeKubectl) {
+			t.Errorf("\"%s\" wasn't installed (%v).", filename, err)
+		}
+	}
+
+	output, _, err := run("fetch-kubectl", "1.28")
+	if err != nil || !strings.Contains(output, "already installed") {
+		t.Errorf("fetch-kubectl of an installed version should do nothing (%v): %s", err, output)
+	}
+
+	_, errorOutput, err := run("fetch-kubectl", "1.30")
+	if err == nil || !strings.Contains(errorOutput, "stable-1.30.txt") {
+		t.Errorf("fetch-kubectl of an unknown version should fail (%v): %s", err, errorOutput)
+	}
+	_, _, err = run("fetch-kubectl", "latest")
+	if err == nil {
+		t.Errorf("fetch-kubectl of an invalid version should fail.")
+	}
+
+	output, errorOutput, err = run("kset", "dev")
+	if err != nil || !strings.Contains(output, "export _KCONFIG_KUBECTL='"+filepath.Join(binDir, "kubectl-1.28")+"'\n") || errorOutput != "" {
+		t.Errorf("kset of a nickname using kubectl@1.28 failed (%v):\n%s%s", err, output, errorOutput)
+	}
+
+	_, errorOutput, err = run("kset", "missing")
+	if err != nil || !strings.Contains(errorOutput, `Run "kconfig-util fetch-kubectl 1.29" to install it.`) {
+		t.Errorf("kset of a nickname using a missing kubectl version should warn (%v): %s", err, errorOutput)
+	}
 }
 
-var (
-	trueVal = true
-	truePtr = &trueVal
+func TestKubectlPluginPath(t *testing.T) {
+	workarea := t.TempDir()
+	fakeKubectl := filepath.Join(workarea, "bin", "fake-kubectl")
+	err := os.MkdirAll(filepath.Dir(fakeKubectl), 0755)
+	if err == nil {
+		err = os.WriteFile(fakeKubectl, []byte("#!/bin/sh\necho \"$PATH\"\n"), 0755)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	falseVal = false
-	falsePtr = &falseVal
-)
+	kubeconfig := filepath.Join(testHomeDir, ".kube", "config")
+	kconfigYaml := fmt.Sprintf(`nicknames:
+  plugins: %s --plugin-path plugins:/opt/kubectl-plugins --kubeconfig %s --context dev
+  child: --extends plugins
+  none: %s --kubeconfig %s --context dev
+`, fakeKubectl, kubeconfig, fakeKubectl, kubeconfig)
+	err = os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-// GetBoolPtr is a convenience function for returning a boolean pointer, useful
-// for optional boolean fields.  The caller should NOT change the value of the
-// boolean that is referenced by the pointer.
-func GetBoolPtr(val bool) *bool {
-	if val {
-		return truePtr
+	runKubectl := func(nickname string) string {
+		cmd := exec.Command(kubectlCommand, "-k", nickname, "plugin", "list")
+		cmd.Env = append(os.Environ(), "TMPDIR="+workarea, "PATH=/usr/bin:/bin")
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("kubectl -k %s failed: %v", nickname, err)
+		}
+		return strings.TrimSpace(string(output))
+	}
+
+	expected := filepath.Join(workarea, "bin", "plugins") + ":/opt/kubectl-plugins:/usr/bin:/bin"
+	for _, nickname := range []string{"plugins", "child"} {
+		if path := runKubectl(nickname); path != expected {
+			t.Errorf("Expected PATH \"%s\" for nickname \"%s\", got \"%s\".", expected, nickname, path)
+		}
+	}
+	if path := runKubectl("none"); path != "/usr/bin:/bin" {
+		t.Errorf("PATH shouldn't change without the --plugin-path option, got \"%s\".", path)
+	}
+}
+
+func TestClientSettings(t *testing.T) {
+	workarea := t.TempDir()
+	fakeKubectl := filepath.Join(workarea, "fake-kubectl")
+	err := os.WriteFile(fakeKubectl, []byte("#!/bin/sh\necho \"$*\"\necho \"$KUBECACHEDIR $KUBECTL_QPS $OTHER\"\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kubeconfig := filepath.Join(testHomeDir, ".kube", "config")
+	kconfigYaml := fmt.Sprintf(`preferences:
+  helm_env: false
+nicknames:
+  busy: %s --kubeconfig %s --context dev --request-timeout 30s --cache-dir /tmp/busy-cache --env KUBECTL_QPS=50 --env OTHER=1
+  busier: --extends busy --env KUBECTL_QPS=100 --request-timeout 1m
+  badenv: --context dev --env KUBECONFIG=/tmp/x
+  badtimeout: --context dev --request-timeout soon
+`, fakeKubectl, kubeconfig)
+	err = os.WriteFile(filepath.Join(testHomeDir, ".kube", "kconfig.yaml"), []byte(kconfigYaml), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kubectlTestCases := []struct {
+		Args     []string
+		Expected string
+	}{
+		{[]string{"-k", "busy", "get", "pods"}, "get pods --request-timeout=30s\n/tmp/busy-cache 50 1\n"},
+		{[]string{"-k", "busier", "exec", "pod", "--", "ls"}, "exec pod --request-timeout=1m -- ls\n/tmp/busy-cache 100 1\n"},
+		{[]string{"-k", "busy", "get", "pods", "--request-timeout=5s"}, "get pods --request-timeout=5s\n/tmp/busy-cache 50 1\n"},
+		{[]string{"-k", "busy", "__complete", "get", ""}, "__complete get \n/tmp/busy-cache 50 1\n"},
+	}
+	for _, testCase := range kubectlTestCases {
+		cmd := exec.Command(kubectlCommand, testCase.Args...)
+		cmd.Env = append(os.Environ(), "TMPDIR="+workarea, "KUBECTL_QPS=", "OTHER=")
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("kubectl %v failed: %v", testCase.Args, err)
+		}
+		if string(output) != testCase.Expected {
+			t.Errorf("kubectl %v ran the kubectl executable with:\n%s\ninstead of:\n%s", testCase.Args, output, testCase.Expected)
+		}
+	}
+
+	stateEnv := append(os.Environ(), "KCONFIG_STATE_DIR="+t.TempDir(), "KUBECONFIG=", "_KCONFIG_KSET=", "_KCONFIG_ENV=STALE OTHER")
+	cmd := exec.Command(kconfigUtilCommand, "kset", "busier")
+	cmd.Env = stateEnv
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("kset failed: %v", err)
+	}
+	for _, expected := range []string{"export KUBECACHEDIR='/tmp/busy-cache'", "export KUBECTL_QPS='100'", "export OTHER='1'", "unset STALE", "export _KCONFIG_ENV='KUBECACHEDIR KUBECTL_QPS OTHER'"} {
+		if !strings.Contains(string(output), expected+"\n") {
+			t.Errorf("The output of kset doesn't contain \"%s\":\n%s", expected, output)
+		}
+	}
+
+	cmd = exec.Command(kconfigUtilCommand, "koff")
+	cmd.Env = append(stateEnv, "KUBECONFIG=/some/config", "_KCONFIG_ENV=KUBECTL_QPS OTHER")
+	output, err = cmd.Output()
+	if err != nil {
+		t.Fatalf("koff failed: %v", err)
+	}
+	if !strings.Contains(string(output), "unset KUBECTL_QPS\nunset OTHER\n") {
+		t.Errorf("koff doesn't unset the nickname's environment variables:\n%s", output)
+	}
+
+	for _, nickname := range []string{"badenv", "badtimeout"} {
+		err = exec.Command(kconfigUtilCommand, "kset", "--print-only", nickname).Run()
+		if err == nil {
+			t.Errorf("kset %s didn't fail.", nickname)
+		}
 	}
-	return falsePtr
 }
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jphx/kconfig/config"
+)
+
+// ksetStackDelimiter separates the entries of the _KCONFIG_KSTACK environment variable.  It's the
+// "record separator" ASCII/Unicode control code, 0x1E, which shouldn't appear in an entry.
+const ksetStackDelimiter = "\x1E"
+
+// maxKsetStackDepth is the maximum number of previous kset environments kept on the stack.
+const maxKsetStackDepth = 20
+
+// poppingKsetStack is set when the kset command is activating the previous kset environment, as
+// for "kset -".  That environment is then popped from the stack instead of the current one being
+// pushed.
+var poppingKsetStack bool
+
+type kstackCommandOptions struct {
+}
+
+var kstackOptions kstackCommandOptions
+
+func (o *kstackCommandOptions) Usage() string {
+	return ""
+}
+
+func (o *kstackCommandOptions) Execute(args []string) error {
+	commandProcessor = kstackProcessor
+	commandName = "kstack"
+
+	if len(args) > 0 {
+		return fmt.Errorf("Unrecognized positional arguments provided.")
+	}
+
+	return nil
+}
+
+// kstackProcessor lists the current kset environment, numbered 0, followed by the stack of
+// previous environments, most recent first.
+func kstackProcessor(positionalArgs []string) {
+	if current := os.Getenv("_KCONFIG_KSET"); current != "" {
+		fmt.Printf("%3d  %s\n", 0, formatKsetHistoryArgs(config.SplitKsetArgs(current)))
+	}
+
+	for idx, entry := range getKsetStack() {
+		fmt.Printf("%3d  %s\n", idx+1, formatKsetHistoryArgs(config.SplitKsetArgs(entry)))
+	}
+}
+
+// getKsetStack returns the stack of previous kset environments, most recent first.  The most recent
+// one is kept in the _KCONFIG_OLDKSET environment variable, and the rest in the _KCONFIG_KSTACK
+// environment variable.
+func getKsetStack() []string {
+	var stack []string
+	if previousKset := os.Getenv("_KCONFIG_OLDKSET"); previousKset != "" {
+		stack = append(stack, previousKset)
+	}
+	if rest := os.Getenv("_KCONFIG_KSTACK"); rest != "" {
+		stack = append(stack, strings.Split(rest, ksetStackDelimiter)...)
+	}
+	return stack
+}
+
+// pushKsetStack returns the stack with the kset environment added to the top.  The oldest
+// environments are dropped to keep at most maxKsetStackDepth of them, or only the one just pushed
+// without the "kset-stack" feature.
+func pushKsetStack(stack []string, ksetDescription string) []string {
+	maxDepth := maxKsetStackDepth
+	if !ksetStackEnabled() {
+		maxDepth = 1
+	}
+
+	stack = append([]string{ksetDescription}, stack...)
+	if len(stack) > maxDepth {
+		stack = stack[:maxDepth]
+	}
+	return stack
+}
+
+// ksetStackEnabled says whether or not the "kset-stack" feature is enabled, which keeps more than
+// one previous kset environment.
+func ksetStackEnabled() bool {
+	enabled, err := config.FeatureEnabled(config.FeatureKsetStack)
+	if err != nil {
+		config.ExitWithError(err)
+	}
+	return enabled
+}
+
+// printKsetStackUpdate prints the shell commands that set the _KCONFIG_OLDKSET and _KCONFIG_KSTACK
+// environment variables to describe the stack, for those that change.
+func printKsetStackUpdate(stack []string) {
+	var previousKset, rest string
+	if len(stack) > 0 {
+		previousKset = stack[0]
+		rest = strings.Join(stack[1:], ksetStackDelimiter)
+	}
+
+	printEnvVarUpdate("_KCONFIG_OLDKSET", previousKset)
+	printEnvVarUpdate("_KCONFIG_KSTACK", rest)
+}
+
+// printEnvVarUpdate prints the shell command that sets the environment variable to the value, or
+// unsets it if the value is empty, unless it already has that value.
+func printEnvVarUpdate(name string, value string) {
+	if os.Getenv(name) == value {
+		return
+	}
+
+	if value == "" {
+		fmt.Printf("unset %s\n", name)
+	} else {
+		fmt.Printf("export %s=%s\n", name, shellQuote(value))
+	}
+}
+
+func init() {
+	_, err := parser.AddCommand("kstack",
+		"List the stack of previous kset environments",
+		"Lists the current kset environment, numbered 0, followed by the previous ones, most "+
+			"recent first.  Each kset command pushes the environment it replaces onto the stack, "+
+			"and \"kset -\" pops the most recent one off the stack and activates it again.",
+		&kstackOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/jphx/kconfig/config"
+)
+
+// sternExecutable is the name of the stern executable, which the logs command runs if it's found in
+// PATH.
+const sternExecutable = "stern"
+
+type logsCommandOptions struct {
+	config.KconfigOptions
+	Kubectl bool `long:"kubectl" description:"Run \"kubectl logs -f\" even if stern is installed."`
+}
+
+var logsOptions logsCommandOptions
+
+func (o *logsCommandOptions) Usage() string {
+	return "[--kubectl] nickname [override-options] pod-selector [-- tool-arguments...]"
+}
+
+func (o *logsCommandOptions) Execute(args []string) error {
+	commandProcessor = logsProcessor
+	commandName = "logs"
+
+	if len(args) < 2 {
+		return fmt.Errorf("A nickname and a pod selector must be specified.")
+	}
+
+	return nil
+}
+
+// logsProcessor follows the logs of the pods that the selector picks in the nickname's cluster,
+// possibly modified by override options, without changing the kset environment of the shell.  Like
+// exec-tool, the nickname's kubectl config file is written to a private temporary directory that's
+// removed when the command exits, and the command is run with the environment kset would set up.
+func logsProcessor(positionalArgs []string) {
+	nickname := config.ExpandNickname(positionalArgs[0])
+	createResults, err := config.GetKconfig().ResolveLocalKubectlConfig(nickname, &logsOptions.KconfigOptions)
+	if err != nil {
+		config.ExitWithError(err)
+	}
+
+	commandArgs := logsCommand(createResults.KubectlExecutable, positionalArgs[1], positionalArgs[2:])
+
+	workDir, err := os.MkdirTemp("", "kconfig-logs-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to create a temporary directory: %v\n", err)
+		os.Exit(1)
+	}
+	exitStatus := runWithNickname(commandArgs, nickname, &logsOptions.KconfigOptions, createResults, workDir)
+	os.RemoveAll(workDir)
+	os.Exit(exitStatus)
+}
+
+// logsCommand returns the command that follows the logs of the pods the selector picks:  stern, if
+// it's installed and --kubectl isn't given, or else the nickname's kubectl executable.  A selector
+// with an "=" in it, like "app=web", is a label selector, and otherwise it's a pod name, which for
+// stern can be a regular expression matching any number of pods.  The tool arguments are added at
+// the end.
+func logsCommand(kubectlExecutable string, selector string, toolArgs []string) []string {
+	isLabelSelector := strings.Contains(selector, "=")
+
+	var commandArgs []string
+	if _, err := exec.LookPath(sternExecutable); err == nil && !logsOptions.Kubectl {
+		commandArgs = []string{sternExecutable}
+		if isLabelSelector {
+			commandArgs = append(commandArgs, "--selector", selector)
+		} else {
+			commandArgs = append(commandArgs, selector)
+		}
+	} else {
+		commandArgs = []string{kubectlExecutable, "logs", "--follow"}
+		if isLabelSelector {
+			// Without --prefix, the lines of different pods can't be told apart.
+			commandArgs = append(commandArgs, "--selector", selector, "--prefix", "--all-containers")
+		} else {
+			commandArgs = append(commandArgs, selector)
+		}
+	}
+	return append(commandArgs, toolArgs...)
+}
+
+func init() {
+	_, err := parser.AddCommand("logs",
+		"Follow the logs of pods in a nickname's cluster",
+		"Follows the logs of the pods that the selector picks in the nickname's cluster, possibly "+
+			"modified by override options, without changing the kset environment of the shell.  A "+
+			"selector like \"app=web\" is a label selector, and otherwise it's the name of a pod.  "+
+			"stern is run if it's installed, in which case the name can be a regular expression "+
+			"matching any number of pods, and otherwise, or with --kubectl, \"kubectl logs --follow\" "+
+			"is run.  Arguments after \"--\" are passed on to stern or kubectl, like \"--since 10m\".",
+		&logsOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
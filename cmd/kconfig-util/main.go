@@ -1,13 +1,14 @@
 package main
 
 import (
-	"fmt"
+	"errors"
 	"os"
 
 	"github.com/jessevdk/go-flags"
 	"go.uber.org/zap"
 
 	"github.com/jphx/kconfig/common"
+	"github.com/jphx/kconfig/config"
 )
 
 // parser is the command-line parser.  It is modified by init() functions of other files to add
@@ -20,6 +21,10 @@ var commandName string
 
 func main() {
 	positionalArgs := parseOptions()
+	err := common.ConfigureLogging()
+	if err != nil {
+		config.ExitWithError(config.NewCodedError(config.ErrorCodeUsage, err))
+	}
 	if common.CommonOptions.Debug {
 		common.LoggingLevel.SetLevel(zap.DebugLevel)
 	}
@@ -39,19 +44,43 @@ func parseOptions() []string {
 	if len(argsToParse) == 2 && argsToParse[0] == "kset" && argsToParse[1] == "-" {
 		previousKset := os.Getenv("_KCONFIG_OLDKSET")
 		if previousKset == "" {
-			fmt.Fprintln(os.Stderr, "A kconfig nickname of \"-\" can only be used when a kconfig environment was previously in effect.")
-			os.Exit(1)
+			config.ExitWithError(config.NewCodedError(config.ErrorCodeUsage,
+				errors.New("A kconfig nickname of \"-\" can only be used when a kconfig environment was previously in effect.")))
 		}
 
 		argsToParse = []string{"kset"}
-		argsToParse = append(argsToParse, getArgsFromKsetArgs(previousKset)...)
+		argsToParse = append(argsToParse, config.SplitKsetArgs(previousKset)...)
+		poppingKsetStack = true
+	}
+
+	// Special case handling for the "kset @N" subcommand, where we fetch the arguments of the Nth
+	// entry in the kset history and parse those instead.
+	if len(argsToParse) == 2 && argsToParse[0] == "kset" && isKsetHistoryReference(argsToParse[1]) {
+		historyArgs, err := getArgsFromKsetHistory(argsToParse[1])
+		if err != nil {
+			config.ExitWithError(err)
+		}
+
+		argsToParse = append([]string{"kset"}, historyArgs...)
+	}
+
+	// Special case handling for a plain "kset" subcommand when no kset environment is in effect.  If
+	// a ".kconfig" file is found in the current directory or one of its ancestors, we parse its
+	// contents as if they had been provided on the command line.  Otherwise we use the nickname
+	// named by the default_nickname preference, if there is one.
+	if len(argsToParse) == 1 && argsToParse[0] == "kset" && os.Getenv("_KCONFIG_KSET") == "" {
+		filename, directoryArgs := config.FindDirectoryKconfig()
+		if filename != "" {
+			argsToParse = append(argsToParse, directoryArgs...)
+		} else if defaultNickname := config.GetKconfig().Preferences.DefaultNickname; defaultNickname != "" {
+			argsToParse = append(argsToParse, defaultNickname)
+		}
 	}
 
 	positionalArgs, err := parser.ParseArgs(argsToParse)
 	if err != nil {
 		// Print errors, and even help output, to stderr.
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		config.ExitWithError(config.NewCodedError(config.ErrorCodeUsage, err))
 	}
 
 	// Subcommands generally define an Execute() method that will check if positional arguments are
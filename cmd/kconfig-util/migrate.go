@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type migrateCommandOptions struct{}
+
+var migrateOptions migrateCommandOptions
+
+func (o *migrateCommandOptions) Usage() string {
+	return ""
+}
+
+func (o *migrateCommandOptions) Execute(args []string) error {
+	commandProcessor = migrateProcessor
+	commandName = "migrate"
+
+	if len(args) != 0 {
+		return fmt.Errorf("No arguments are expected.")
+	}
+
+	return nil
+}
+
+// migrateProcessor upgrades the kconfig.yaml file to the current version of its format.
+func migrateProcessor(positionalArgs []string) {
+	filename := config.DefaultKconfigFilename()
+	applied, err := config.MigrateKconfigFile(filename)
+	if err != nil {
+		config.ExitWithError(err)
+	}
+
+	if len(applied) == 0 {
+		fmt.Printf("The file \"%s\" is already at version %s.\n", filename, config.KconfigAPIVersion)
+		return
+	}
+	fmt.Printf("Upgraded \"%s\" to version %s.\n", filename, applied[len(applied)-1])
+}
+
+func init() {
+	_, err := parser.AddCommand("migrate",
+		"Upgrade the kconfig.yaml file",
+		"Upgrades the kconfig.yaml file to the version of its format that this version of kconfig "+
+			"understands, keeping its comments and the order of its entries.  The apiVersion and kind "+
+			"entries that identify the format are added to the top of the file if they're missing.",
+		&migrateOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
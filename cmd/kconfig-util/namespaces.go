@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type namespacesCommandOptions struct {
+	NoNetwork bool `long:"no-network" description:"Don't ask the cluster.  List the nickname's namespace and the namespaces used with it in the kset history instead."`
+}
+
+var namespacesOptions namespacesCommandOptions
+
+func (o *namespacesCommandOptions) Usage() string {
+	return "[--no-network] [nickname]"
+}
+
+func (o *namespacesCommandOptions) Execute(args []string) error {
+	commandProcessor = namespacesProcessor
+	commandName = "namespaces"
+
+	if len(args) > 1 {
+		return fmt.Errorf("Unrecognized positional arguments provided after the nickname.")
+	}
+
+	return nil
+}
+
+// namespacesProcessor prints the namespaces of the nickname's cluster, one per line, or those of
+// the current kset environment's nickname if none is given.  The cluster's namespaces are cached
+// for a couple of minutes, so the command is fast enough for shell completion.  With --no-network,
+// the nickname's namespace and the namespaces used with it are printed instead.
+func namespacesProcessor(positionalArgs []string) {
+	var nickname string
+	if len(positionalArgs) > 0 {
+		nickname = config.ExpandNickname(positionalArgs[0])
+	} else {
+		nickname = config.KsetNickname(os.Getenv("_KCONFIG_KSET"))
+		if nickname == "" {
+			config.ExitWithError(config.NewCodedError(config.ErrorCodeUsage,
+				errors.New("A nickname must be specified when no kset environment is in effect.")))
+		}
+	}
+
+	createResults, err := config.GetKconfig().ResolveLocalKubectlConfig(nickname, nil)
+	if err != nil {
+		config.ExitWithError(err)
+	}
+
+	var namespaces []string
+	if namespacesOptions.NoNetwork {
+		namespaces = append(config.GetNamespacesSeen(nickname), createResults.ContextNamespace)
+		sort.Strings(namespaces)
+		namespaces = uniqueStrings(namespaces)
+
+	} else {
+		namespaces, err = createResults.CachedClusterNamespaces()
+		if err != nil {
+			config.ExitWithError(fmt.Errorf("Unable to list the namespaces of nickname \"%s\": %w", nickname, err))
+		}
+	}
+
+	for _, namespace := range namespaces {
+		fmt.Println(namespace)
+	}
+}
+
+// uniqueStrings returns the sorted strings without duplicates.
+func uniqueStrings(sorted []string) []string {
+	var unique []string
+	for idx, value := range sorted {
+		if idx == 0 || value != sorted[idx-1] {
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
+func init() {
+	_, err := parser.AddCommand("namespaces",
+		"List the namespaces of a nickname's cluster",
+		"Lists the namespaces of the cluster of the nickname, or of the current kset environment's "+
+			"nickname if none is given.  They're cached for a couple of minutes, so it's fast enough "+
+			"for shell completion.  With --no-network, the cluster isn't asked, and the nickname's "+
+			"namespace and the namespaces used with it in the kset history are listed instead.",
+		&namespacesOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	clientauthv1 "k8s.io/client-go/pkg/apis/clientauthentication/v1"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type oidcTokenCommandOptions struct {
+	Issuer   string `long:"issuer" value-name:"URL" required:"true" description:"The URL of the OpenID Connect provider."`
+	ClientID string `long:"client-id" value-name:"ID" required:"true" description:"The OAuth client ID to use."`
+	Scopes   string `long:"scopes" value-name:"SCOPES" description:"A comma-separated list of the scopes to request."`
+}
+
+var oidcTokenOptions oidcTokenCommandOptions
+
+// execCredentialResponse is the ExecCredential that the oidc-token subcommand, and the other
+// subcommands kubectl runs as exec credential plugins, print for kubectl.
+type execCredentialResponse struct {
+	APIVersion string                       `json:"apiVersion"`
+	Kind       string                       `json:"kind"`
+	Status     execCredentialResponseStatus `json:"status"`
+}
+
+type execCredentialResponseStatus struct {
+	Token                 string `json:"token,omitempty"`
+	ClientCertificateData string `json:"clientCertificateData,omitempty"`
+	ClientKeyData         string `json:"clientKeyData,omitempty"`
+	ExpirationTimestamp   string `json:"expirationTimestamp,omitempty"`
+}
+
+func (o *oidcTokenCommandOptions) Usage() string {
+	return "--issuer URL --client-id ID [--scopes SCOPES]"
+}
+
+func (o *oidcTokenCommandOptions) Execute(args []string) error {
+	commandProcessor = oidcTokenProcessor
+	commandName = "oidc-token"
+
+	if len(args) > 0 {
+		return fmt.Errorf("Unrecognized positional arguments provided.")
+	}
+
+	return nil
+}
+
+// oidcTokenProcessor prints an ExecCredential holding an ID token from the OIDC provider, logging
+// in if needed.  kubectl runs it as an exec credential plugin for nicknames whose definitions have
+// the --oidc-issuer option.  It reads the KUBERNETES_EXEC_INFO environment variable set by kubectl
+// to find out whether it can interact with the user.
+func oidcTokenProcessor(positionalArgs []string) {
+	apiVersion, interactive := getExecCredentialRequest()
+
+	token, err := config.GetOIDCToken(&config.OIDCSettings{
+		Issuer:   oidcTokenOptions.Issuer,
+		ClientID: oidcTokenOptions.ClientID,
+		Scopes:   oidcTokenOptions.Scopes,
+	}, interactive)
+	if err != nil {
+		config.ExitWithError(err)
+	}
+
+	response := execCredentialResponse{
+		APIVersion: apiVersion,
+		Kind:       "ExecCredential",
+		Status: execCredentialResponseStatus{
+			Token: token.IDToken,
+		},
+	}
+	if !token.Expiry.IsZero() {
+		response.Status.ExpirationTimestamp = token.Expiry.UTC().Format(time.RFC3339)
+	}
+
+	printExecCredential(&response)
+}
+
+// getExecCredentialRequest returns the API version of the ExecCredential that kubectl expects from
+// an exec credential plugin, and whether the plugin can interact with the user, from the
+// KUBERNETES_EXEC_INFO environment variable set by kubectl.
+func getExecCredentialRequest() (string, bool) {
+	apiVersion := "client.authentication.k8s.io/v1"
+	interactive := true
+	if execInfo := os.Getenv("KUBERNETES_EXEC_INFO"); execInfo != "" {
+		var request clientauthv1.ExecCredential
+		err := json.Unmarshal([]byte(execInfo), &request)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing the KUBERNETES_EXEC_INFO environment variable: %v\n", err)
+			os.Exit(1)
+		}
+		if request.APIVersion != "" {
+			apiVersion = request.APIVersion
+		}
+		interactive = request.Spec.Interactive
+	}
+	return apiVersion, interactive
+}
+
+// printExecCredential prints the ExecCredential for kubectl.
+func printExecCredential(response *execCredentialResponse) {
+	err := json.NewEncoder(os.Stdout).Encode(response)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing the credentials: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func init() {
+	_, err := parser.AddCommand("oidc-token",
+		"Get an OIDC ID token for kubectl",
+		"Run by kubectl as an exec credential plugin for nicknames whose definitions have the "+
+			"--oidc-issuer option.  Prints an ID token from the OpenID Connect provider, taken from "+
+			"the cache in ~/.kube/kconfig-cache if it's still valid, refreshed with a cached refresh "+
+			"token, or obtained by asking the user to log in with a browser using the OAuth device "+
+			"authorization flow.",
+		&oidcTokenOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/jphx/kconfig/config"
+)
+
+// defaultOnSwitchTimeout is how long the on_switch_command can run, if the on_switch_timeout
+// preference isn't set.
+const defaultOnSwitchTimeout = 5 * time.Second
+
+// runOnSwitchCommand runs the command given by the on_switch_command preference, if any, after kset
+// or koff switches the environment.  The event ("kset" or "koff") and the details are passed to
+// it as environment variables whose names start with "KCONFIG_".  The command's output goes to
+// standard error, since standard output is evaluated by the shell.  Failures and timeouts are
+// reported as warnings, and don't affect the switch.
+func runOnSwitchCommand(event string, details map[string]string) {
+	kconfig := config.GetKconfig()
+	command := kconfig.Preferences.OnSwitchCommand
+	if command == "" {
+		return
+	}
+
+	timeout := defaultOnSwitchTimeout
+	if kconfig.Preferences.OnSwitchTimeout != "" {
+		parsedTimeout, err := time.ParseDuration(kconfig.Preferences.OnSwitchTimeout)
+		if err != nil || parsedTimeout <= 0 {
+			fmt.Fprintf(os.Stderr, "Warning: the on_switch_timeout preference \"%s\" isn't a positive duration like \"5s\".\n", kconfig.Preferences.OnSwitchTimeout)
+		} else {
+			timeout = parsedTimeout
+		}
+	}
+
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "KCONFIG_EVENT="+event)
+
+	names := make([]string, 0, len(details))
+	for name := range details {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", name, details[name]))
+	}
+
+	// Run the command in its own process group, so that any processes it starts are stopped along
+	// with it when it times out.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	err := cmd.Start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: unable to run the on_switch_command: %v\n", err)
+		return
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-done:
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: the on_switch_command failed: %v\n", err)
+		}
+
+	case <-time.After(timeout):
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		fmt.Fprintf(os.Stderr, "Warning: the on_switch_command didn't finish within %v, so it was stopped.\n", timeout)
+	}
+}
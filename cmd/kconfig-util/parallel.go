@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// parallelOptions holds the options of the subcommands that resolve many nicknames, which they do
+// several at a time, since a nickname whose user runs a credential plugin can take seconds.
+type parallelOptions struct {
+	Parallel int           `long:"parallel" value-name:"N" default:"8" description:"How many nicknames are resolved at a time."`
+	Timeout  time.Duration `long:"timeout" value-name:"DURATION" default:"30s" description:"How long a nickname can take before it's given up on and reported as failed."`
+}
+
+// check returns an error if the options have values that can't be used.
+func (o *parallelOptions) check() error {
+	if o.Parallel < 1 {
+		return fmt.Errorf("The --parallel option must be at least 1.")
+	}
+	if o.Timeout <= 0 {
+		return fmt.Errorf("The --timeout option must be a positive duration, like \"30s\".")
+	}
+	return nil
+}
+
+// nicknameOutcome holds what the work on a nickname returned.
+type nicknameOutcome[R any] struct {
+	result R
+	err    error
+}
+
+// forEachNickname calls work for each of the nicknames, as many at a time as the --parallel option
+// allows, and calls report, on the calling goroutine, with the outcome of each nickname in the
+// order of the nicknames.  The outcomes are streamed:  a nickname is reported as soon as it and
+// those before it are done.  The work on a nickname that takes longer than the --timeout option is
+// abandoned, though it keeps running in the background, and it's reported with an error.
+func forEachNickname[R any](nicknames []string, options *parallelOptions, work func(nickname string) (R, error), report func(nickname string, result R, err error)) {
+	outcomes := make([]chan nicknameOutcome[R], len(nicknames))
+	for idx := range outcomes {
+		outcomes[idx] = make(chan nicknameOutcome[R], 1)
+	}
+
+	go func() {
+		slots := make(chan struct{}, options.Parallel)
+		for idx, nickname := range nicknames {
+			idx, nickname := idx, nickname
+			slots <- struct{}{}
+			go func() {
+				defer func() { <-slots }()
+				done := make(chan nicknameOutcome[R], 1)
+				go func() {
+					result, err := work(nickname)
+					done <- nicknameOutcome[R]{result: result, err: err}
+				}()
+
+				timer := time.NewTimer(options.Timeout)
+				defer timer.Stop()
+				select {
+				case outcome := <-done:
+					outcomes[idx] <- outcome
+				case <-timer.C:
+					outcomes[idx] <- nicknameOutcome[R]{err: fmt.Errorf("Gave up after %s.", options.Timeout)}
+				}
+			}()
+		}
+	}()
+
+	for idx, nickname := range nicknames {
+		outcome := <-outcomes[idx]
+		report(nickname, outcome.result, outcome.err)
+	}
+}
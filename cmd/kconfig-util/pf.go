@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jphx/kconfig/config"
+)
+
+// pfReadyMessage starts the lines that "kubectl port-forward" prints once it's listening.
+const pfReadyMessage = "Forwarding from "
+
+// pfStartTimeout is how long the pf command waits for "kubectl port-forward" to start listening
+// before leaving it to start in the background.
+const pfStartTimeout = 10 * time.Second
+
+type pfCommandOptions struct {
+	config.KconfigOptions
+}
+
+type pfListCommandOptions struct {
+}
+
+type pfStopCommandOptions struct {
+	All bool `long:"all" description:"Stop all the port forwards."`
+}
+
+var pfOptions pfCommandOptions
+var pfListOptions pfListCommandOptions
+var pfStopOptions pfStopCommandOptions
+
+func (o *pfCommandOptions) Usage() string {
+	return "nickname [override-options] resource ports... [-- port-forward-arguments...]"
+}
+
+func (o *pfCommandOptions) Execute(args []string) error {
+	commandProcessor = pfProcessor
+	commandName = "pf"
+
+	if len(args) < 3 {
+		return fmt.Errorf("A nickname, a resource, and the ports to forward must be specified.")
+	}
+
+	return nil
+}
+
+func (o *pfListCommandOptions) Usage() string {
+	return ""
+}
+
+func (o *pfListCommandOptions) Execute(args []string) error {
+	commandProcessor = pfListProcessor
+	commandName = "pf list"
+
+	if len(args) > 0 {
+		return fmt.Errorf("Unrecognized positional arguments provided.")
+	}
+
+	return nil
+}
+
+func (o *pfStopCommandOptions) Usage() string {
+	return "[--all] [id-or-nickname...]"
+}
+
+func (o *pfStopCommandOptions) Execute(args []string) error {
+	commandProcessor = pfStopProcessor
+	commandName = "pf stop"
+
+	if len(args) == 0 && !o.All {
+		return fmt.Errorf("The ID or nickname of a port forward, or --all, must be specified.")
+	}
+
+	return nil
+}
+
+// pfProcessor starts "kubectl port-forward" for the nickname, possibly modified by override
+// options, as a background process that keeps running after this one exits, and records it so
+// "pf list" and "pf stop" can find it.  The nickname's kubectl config file and the output of the
+// process are kept in a private directory of the port forward's own, which is removed when it's
+// stopped.  It waits for kubectl to start listening, and fails, with kubectl's output, if kubectl
+// exits first.
+func pfProcessor(positionalArgs []string) {
+	nickname := config.ExpandNickname(positionalArgs[0])
+	createResults, err := config.GetKconfig().ResolveLocalKubectlConfig(nickname, &pfOptions.KconfigOptions)
+	if err != nil {
+		config.ExitWithError(err)
+	}
+
+	dir, err := config.NewPortForwardDirectory()
+	if err != nil {
+		config.ExitWithError(err)
+	}
+	portForward, exited, err := startPortForward(dir, nickname, createResults, positionalArgs[1], positionalArgs[2:])
+	if err != nil {
+		config.RemovePortForwardDirectory(dir)
+		config.ExitWithError(err)
+	}
+
+	deadline := time.After(pfStartTimeout)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-exited:
+			output, _ := os.ReadFile(portForward.LogFilename())
+			os.Stderr.Write(output)
+			config.RemovePortForwardDirectory(dir)
+			config.ExitWithError(fmt.Errorf("The port forward to %s for nickname \"%s\" exited.", portForward.Resource, nickname))
+
+		case <-deadline:
+			fmt.Fprintf(os.Stderr, "Warning: The port forward to %s for nickname \"%s\" isn't listening yet.  Its output is in \"%s\".\n", portForward.Resource, nickname, portForward.LogFilename())
+
+		case <-ticker.C:
+			output, _ := os.ReadFile(portForward.LogFilename())
+			if !strings.Contains(string(output), pfReadyMessage) {
+				continue
+			}
+			for _, line := range strings.Split(string(output), "\n") {
+				if strings.HasPrefix(line, pfReadyMessage) {
+					fmt.Println(line)
+				}
+			}
+		}
+		break
+	}
+	fmt.Printf("Started port forward %d for nickname \"%s\".  Stop it with \"kconfig-util pf stop %d\".\n", portForward.PID, nickname, portForward.PID)
+}
+
+// startPortForward starts "kubectl port-forward" for the resource in a session of its own, so it
+// isn't ended along with the terminal, and records it in the directory.  The returned channel is
+// closed when the process exits.
+func startPortForward(dir string, nickname string, createResults *config.CreateConfigResults, resource string, args []string) (*config.PortForward, chan struct{}, error) {
+	err := config.WriteLocalKubectlConfigFile(filepath.Join(dir, "config.yaml"), createResults)
+	if err != nil {
+		return nil, nil, err
+	}
+	logFile, err := os.OpenFile(config.PortForwardLogFilename(dir), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to create the output file of the port forward: %v", err)
+	}
+	defer logFile.Close()
+
+	ksetArgs := append([]string{nickname}, pfOptions.KconfigOptions.Args()...)
+	cmd := exec.Command(createResults.KubectlExecutable, append([]string{"port-forward", resource}, args...)...)
+	cmd.Env = ksetEnvironment(createResults, createKsetArgs(ksetArgs))
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to run \"%s\": %v", createResults.KubectlExecutable, err)
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+
+	portForward := &config.PortForward{
+		PID:            cmd.Process.Pid,
+		Nickname:       nickname,
+		Namespace:      createResults.ContextNamespace,
+		Resource:       resource,
+		Args:           args,
+		Started:        time.Now(),
+		ProcessStarted: config.ProcessStartTime(cmd.Process.Pid),
+	}
+	err = config.SavePortForward(dir, portForward)
+	if err != nil {
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+		return nil, nil, err
+	}
+	return portForward, exited, nil
+}
+
+// pfListProcessor lists the port forwards started by the pf command, oldest first.  Those whose
+// process has exited are listed once, as "exited", and then forgotten.
+func pfListProcessor(positionalArgs []string) {
+	portForwards, err := config.ListPortForwards()
+	if err != nil {
+		config.ExitWithError(err)
+	}
+	if len(portForwards) == 0 {
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tNICKNAME\tNAMESPACE\tRESOURCE\tPORTS\tSTARTED\tSTATUS")
+	for _, portForward := range portForwards {
+		status := "running"
+		if !portForward.IsRunning() {
+			status = "exited"
+		}
+		fmt.Fprintln(writer, strings.Join([]string{strconv.Itoa(portForward.PID), portForward.Nickname, portForward.Namespace,
+			portForward.Resource, strings.Join(portForward.Args, " "), portForward.Started.Local().Format("2006-01-02 15:04:05"), status}, "\t"))
+	}
+	writer.Flush()
+
+	for _, portForward := range portForwards {
+		if !portForward.IsRunning() {
+			err = portForward.Stop()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+		}
+	}
+}
+
+// pfStopProcessor stops the port forwards with the IDs, or for the nicknames, given, or all of them
+// with --all.  It fails if an ID or nickname doesn't match any port forward.
+func pfStopProcessor(positionalArgs []string) {
+	portForwards, err := config.ListPortForwards()
+	if err != nil {
+		config.ExitWithError(err)
+	}
+
+	// An argument that isn't an abbreviation of just one nickname can still be an ID.
+	expanded := make(map[string]string)
+	for _, arg := range positionalArgs {
+		expanded[arg] = arg
+		if nickname, err := config.GetKconfig().ExpandNickname(arg); err == nil {
+			expanded[arg] = nickname
+		}
+	}
+
+	matched := make(map[string]bool)
+	failed := false
+	for _, portForward := range portForwards {
+		selected := pfStopOptions.All
+		for _, arg := range positionalArgs {
+			if arg == strconv.Itoa(portForward.PID) || expanded[arg] == portForward.Nickname {
+				selected = true
+				matched[arg] = true
+			}
+		}
+		if !selected {
+			continue
+		}
+
+		err = portForward.Stop()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("Stopped port forward %d to %s for nickname \"%s\".\n", portForward.PID, portForward.Resource, portForward.Nickname)
+	}
+
+	for _, arg := range positionalArgs {
+		if !matched[arg] {
+			fmt.Fprintf(os.Stderr, "No port forward has the ID or nickname \"%s\".\n", arg)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func init() {
+	pfCommand, err := parser.AddCommand("pf",
+		"Manage kubectl port forwards for nicknames",
+		"Starts \"kubectl port-forward\" for the nickname, possibly modified by override options, "+
+			"as a background process that keeps running after the command exits, like \"kconfig-util "+
+			"pf prod svc/foo 8080:80\".  Arguments after \"--\" are passed on to \"kubectl "+
+			"port-forward\", like \"--address 0.0.0.0\".  The port forwards started this way, across "+
+			"all nicknames, are listed with \"pf list\" and stopped with \"pf stop\".",
+		&pfOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+	pfCommand.SubcommandsOptional = true
+
+	_, err = pfCommand.AddCommand("list",
+		"List the port forwards",
+		"Lists the port forwards started with the pf command, with their IDs, which are the process "+
+			"IDs of the kubectl processes.  Those that have exited are listed once, and then forgotten.",
+		&pfListOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+
+	_, err = pfCommand.AddCommand("stop",
+		"Stop port forwards",
+		"Stops the port forwards with the IDs given, or those for the nicknames given, or all of "+
+			"them with --all.",
+		&pfStopOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type pingCommandOptions struct {
+	parallelOptions
+	All bool `long:"all" description:"Check every nickname."`
+}
+
+var pingOptions pingCommandOptions
+
+func (o *pingCommandOptions) Usage() string {
+	return "[--parallel N] [--timeout DURATION] --all | nickname..."
+}
+
+func (o *pingCommandOptions) Execute(args []string) error {
+	commandProcessor = pingProcessor
+	commandName = "ping"
+
+	if o.All && len(args) > 0 {
+		return fmt.Errorf("Nicknames can't be specified with the --all option.")
+	}
+	if !o.All && len(args) == 0 {
+		return fmt.Errorf("A nickname or the --all option must be specified.")
+	}
+
+	return o.check()
+}
+
+// pingProcessor checks that the Kubernetes API server of each nickname can be reached and accepts
+// the nickname's credentials, printing a line for each nickname with the API server's version and
+// latency, or the problem found.  The nicknames are checked several at a time, and each line is
+// printed as soon as it and the ones before it are known.  The process exits with a failure status
+// if the check fails for any nickname.
+func pingProcessor(positionalArgs []string) {
+	nicknames := positionalArgs
+	if pingOptions.All {
+		var err error
+		nicknames, err = config.GetKconfig().MatchNicknames("*")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	failed := 0
+	forEachNickname(nicknames, &pingOptions.parallelOptions, pingNickname, func(nickname string, result string, err error) {
+		if err != nil {
+			result = fmt.Sprintf("FAILED: %v", err)
+			failed++
+		}
+		fmt.Printf("%s: %s\n", nickname, result)
+	})
+	if failed > 0 {
+		if len(nicknames) > 1 {
+			fmt.Fprintf(os.Stderr, "The check failed for %d of %d nicknames.\n", failed, len(nicknames))
+		}
+		os.Exit(1)
+	}
+}
+
+// pingNickname checks the API server of the nickname, returning a description of the results, or
+// an error if the check failed.
+func pingNickname(nickname string) (string, error) {
+	createResults, err := config.GetKconfig().ResolveLocalKubectlConfig(nickname, nil)
+	if err != nil {
+		return "", err
+	}
+
+	ping, err := createResults.Ping()
+	config.RecordPing(createResults.ServerURL(), err)
+	if err != nil {
+		return "", err
+	}
+
+	result := fmt.Sprintf("ok, version %s, %s", ping.ServerVersion, ping.Latency.Round(time.Millisecond))
+	if ping.Username != "" {
+		result += fmt.Sprintf(", user %s", ping.Username)
+	}
+	return result, nil
+}
+
+func init() {
+	_, err := parser.AddCommand("ping",
+		"Check that the API servers of nicknames can be reached",
+		"Resolves each nickname and checks that its Kubernetes API server can be reached, by "+
+			"asking for its version, and that it accepts the nickname's credentials, with a "+
+			"SelfSubjectReview request.  The API server's version and the latency of the version "+
+			"request are reported, or the problem found, like a dead tunnel or expired credentials.  "+
+			"With --all, every nickname is checked.  Several nicknames are checked at a time "+
+			"(--parallel), and one that takes longer than --timeout is reported as failed.  The results are recorded, so the prune "+
+			"subcommand can tell which API servers have been unreachable for a while.",
+		&pingOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type precomputeCommandOptions struct {
+	All bool `long:"all" description:"Precompute the kubectl config files of every nickname."`
+}
+
+var precomputeOptions precomputeCommandOptions
+
+func (o *precomputeCommandOptions) Usage() string {
+	return "--all | PATTERN..."
+}
+
+func (o *precomputeCommandOptions) Execute(args []string) error {
+	commandProcessor = precomputeProcessor
+	commandName = "precompute"
+
+	if o.All && len(args) > 0 {
+		return fmt.Errorf("Nickname patterns can't be given with the --all option.")
+	}
+	if !o.All && len(args) == 0 {
+		return fmt.Errorf("Either nickname patterns or the --all option must be given.")
+	}
+
+	return nil
+}
+
+// precomputeProcessor generates the kubectl config files that "kubectl -k" uses for the nicknames
+// that match the patterns, unless they're already up to date, so the kubectl program doesn't have
+// to.  It fails if any of them can't be generated.
+func precomputeProcessor(positionalArgs []string) {
+	if precomputeOptions.All {
+		positionalArgs = []string{"*"}
+	}
+
+	kconfig := config.GetKconfig()
+	var nicknames []string
+	seen := make(map[string]bool)
+	for _, pattern := range positionalArgs {
+		matchingNicknames, err := kconfig.MatchNicknames(pattern)
+		if err != nil {
+			config.ExitWithError(err)
+		}
+		if len(matchingNicknames) == 0 {
+			fmt.Fprintf(os.Stderr, "Warning: No nicknames match \"%s\".\n", pattern)
+		}
+		for _, nickname := range matchingNicknames {
+			if !seen[nickname] {
+				seen[nickname] = true
+				nicknames = append(nicknames, nickname)
+			}
+		}
+	}
+
+	var generated, current, failed []string
+	for _, nickname := range nicknames {
+		_, wasGenerated, err := kconfig.PrecomputeNicknameKubectlConfigFile(nickname)
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "%s: %v\n", nickname, err)
+			failed = append(failed, nickname)
+		case wasGenerated:
+			generated = append(generated, nickname)
+		default:
+			current = append(current, nickname)
+		}
+	}
+
+	if len(generated) > 0 {
+		fmt.Printf("Precomputed the kubectl config files of nicknames: %s\n", strings.Join(generated, ", "))
+	}
+	if len(current) > 0 {
+		fmt.Printf("Already up to date: %s\n", strings.Join(current, ", "))
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(os.Stderr, "Unable to precompute the kubectl config files of nicknames: %s\n", strings.Join(failed, ", "))
+		os.Exit(1)
+	}
+}
+
+func init() {
+	_, err := parser.AddCommand("precompute",
+		"Precompute the kubectl config files of nicknames",
+		"Generates the kubectl config files that \"kubectl -k\" uses for the nicknames that match the "+
+			"patterns, or for every nickname with --all, so the kubectl program can use them without "+
+			"reading and merging the kubectl config files, or asking the cluster for its version.  A "+
+			"precomputed file is named by a hash of the nickname's definition, the preferences, and "+
+			"the versions of the kubectl config files in its search path, so it's only used while "+
+			"they're unchanged.  Its contents are also checked each time it's used.",
+		&precomputeOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
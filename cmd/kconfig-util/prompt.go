@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jphx/kconfig/common"
+	"github.com/jphx/kconfig/config"
+)
+
+// namespaceEnvVar names the environment variable in which kset records the namespace it configured,
+// so the prompt subcommand can tell when another tool has changed it.  Scripts and other tools can
+// use it too.
+const namespaceEnvVar = "_KCONFIG_NAMESPACE"
+
+// contextEnvVar names the environment variable in which kset records the name of the kubectl
+// context it configured, as it's named in the kubectl config files, for scripts and other tools.
+const contextEnvVar = "_KCONFIG_CONTEXT"
+
+// clusterEnvVar names the environment variable in which kset records the name of the cluster it
+// configured, as it's named in the kubectl config files, for prompts, scripts, and other tools.
+const clusterEnvVar = "_KCONFIG_CLUSTER"
+
+// serverEnvVar names the environment variable in which kset records the URL of the Kubernetes API
+// server it configured, for prompts, scripts, and other tools.
+const serverEnvVar = "_KCONFIG_SERVER"
+
+// openShiftEnvVar names the environment variable that kset sets to "true" when the cluster is an
+// OpenShift cluster, whose namespaces are projects, for prompts, scripts, and other tools.
+const openShiftEnvVar = "_KCONFIG_OPENSHIFT"
+
+// nicknameEnvEnvVar names the environment variable in which kset records the names of the
+// environment variables it set for the nickname's --env and --cache-dir options, and for helm,
+// separated by spaces, so they can be unset when the kset environment changes.
+const nicknameEnvEnvVar = "_KCONFIG_ENV"
+
+type promptCommandOptions struct {
+	Fast bool `long:"fast" description:"Don't resolve the nickname's kubectl configuration again, so it's fast enough to run for every shell prompt.  The override options and the namespace recorded by kset are used instead."`
+}
+
+var promptOptions promptCommandOptions
+
+var promptLogger = common.CreateLogger("prompt")
+
+func (o *promptCommandOptions) Usage() string {
+	return "[--fast]"
+}
+
+func (o *promptCommandOptions) Execute(args []string) error {
+	commandProcessor = promptProcessor
+	commandName = "prompt"
+
+	if len(args) > 0 {
+		return fmt.Errorf("Unrecognized positional arguments provided.")
+	}
+
+	return nil
+}
+
+// promptProcessor prints the shell variables that the shell functions use to update the shell
+// prompt, as kset does, for the kset environment in effect.  The namespace comes from the
+// session-local kubectl config file as it is now, so the prompt reflects a change made by another
+// tool, like "kubectl config set-context --current --namespace foo" or k9s.  With the
+// adopt_namespace_changes preference, such a change also becomes part of the kset environment.
+// Nothing is printed if no kset environment with a session-local kubectl config file is in effect.
+func promptProcessor(positionalArgs []string) {
+	ksetEnvValue := os.Getenv("_KCONFIG_KSET")
+	kubeconfigEnvVar := os.Getenv("KUBECONFIG")
+	if config.KsetNickname(ksetEnvValue) == "" || config.GetExistingSessionLocalFilename(kubeconfigEnvVar) == "" {
+		return
+	}
+
+	ksetArgs := config.SplitKsetArgs(ksetEnvValue)
+	nickname := ksetArgs[0]
+	kconfigOptions, alsoNicknames, err := config.ParseKsetArgs(ksetArgs)
+	if err != nil {
+		config.ExitWithError(err)
+	}
+
+	createResults, err := getPromptResults(nickname, kconfigOptions)
+	if err != nil {
+		config.ExitWithError(err)
+	}
+
+	namespace, err := config.CurrentNamespace(kubeconfigEnvVar)
+	if err != nil {
+		config.ExitWithError(err)
+	}
+	if namespace != createResults.ContextNamespace {
+		promptLogger.Debugf("The namespace changed from \"%s\" to \"%s\".", createResults.ContextNamespace, namespace)
+		createResults.OverridesDescription = withNamespaceOverride(createResults.OverridesDescription, namespace)
+		createResults.ContextNamespace = namespace
+		printHelmNamespaceUpdate(namespace)
+		if config.GetKconfig().Preferences.AdoptNamespaceChanges {
+			kconfigOptions.Namespace = namespace
+			adoptNamespace(nickname, kconfigOptions, alsoNicknames)
+		}
+	}
+
+	printPromptUpdate(nickname, createResults)
+}
+
+// adoptNamespace handles the adopt_namespace_changes preference.  It prints the shell commands that
+// make the namespace a namespace override of the kset environment, as if kset had been run with
+// the -n option, records it as the namespace last used with the nickname, and updates any tmux
+// integration.  The session-local kubectl config file already uses it.
+func adoptNamespace(nickname string, kconfigOptions *config.KconfigOptions, alsoNicknames []string) {
+	args := append([]string{nickname}, kconfigOptions.Args()...)
+	for _, alsoNickname := range alsoNicknames {
+		args = append(args, "--also", alsoNickname)
+	}
+	promptLogger.Debugf("Adopting namespace \"%s\" in the kset environment.", kconfigOptions.Namespace)
+
+	ksetDescription := createKsetArgs(args)
+	config.RecordNamespace(nickname, kconfigOptions.Namespace)
+	fmt.Printf("export %s=%s\n", namespaceEnvVar, shellQuote(kconfigOptions.Namespace))
+	fmt.Printf("export _KCONFIG_KSET=%s\n", shellQuote(ksetDescription))
+	updateTmux(ksetDescription, fmt.Sprintf("%s/%s", nickname, kconfigOptions.Namespace))
+}
+
+// getPromptResults returns the results that kset used for the prompt of the kset environment.  With
+// --fast, they're worked out from the override options and the namespace recorded by kset, without
+// resolving the nickname's kubectl configuration, unless the namespace wasn't recorded.
+func getPromptResults(nickname string, kconfigOptions *config.KconfigOptions) (*config.CreateConfigResults, error) {
+	kconfig := config.GetKconfig()
+	recordedNamespace := os.Getenv(namespaceEnvVar)
+	if !promptOptions.Fast || recordedNamespace == "" {
+		return kconfig.ResolveLocalKubectlConfig(nickname, kconfigOptions)
+	}
+
+	resolution, err := kconfig.ResolveNickname(nickname)
+	if err != nil {
+		return nil, err
+	}
+	return &config.CreateConfigResults{
+		OverridesDescription: kconfigOptions.OverridesDescription(),
+		ContextNamespace:     recordedNamespace,
+		Danger:               kconfig.IsDangerNickname(nickname, resolution),
+	}, nil
+}
+
+// withNamespaceOverride returns the overrides description with its namespace override replaced by
+// one for the namespace, which is added at the start if there isn't one.
+func withNamespaceOverride(overridesDescription string, namespace string) string {
+	overrides := []string{fmt.Sprintf("ns=%s", namespace)}
+	for _, override := range strings.Split(overridesDescription, ",") {
+		if override != "" && !strings.HasPrefix(override, "ns=") {
+			overrides = append(overrides, override)
+		}
+	}
+	return strings.Join(overrides, ",")
+}
+
+func init() {
+	_, err := parser.AddCommand("prompt",
+		"Print the shell prompt information of the kset environment",
+		"Prints the shell variables that the shell functions use to update the shell prompt, as "+
+			"kset does, but with the namespace currently set in the session-local kubectl config "+
+			"file.  The _kconfig_prompt_hook shell function runs it with --fast from a shell prompt "+
+			"hook, so the prompt reflects namespace changes made by other tools, like \"kubectl "+
+			"config set-context --current --namespace foo\".",
+		&promptOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
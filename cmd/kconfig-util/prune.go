@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type pruneCommandOptions struct {
+	parallelOptions
+	Check   bool `long:"check" description:"Only list the nicknames that look stale, exiting with a failure status if there are any."`
+	Remove  bool `long:"remove" description:"Remove the definitions of the nicknames that look stale without asking."`
+	Comment bool `long:"comment" description:"Comment out the definitions of the nicknames that look stale without asking."`
+	Days    int  `long:"days" value-name:"N" default:"30" description:"How many days the API server of a nickname must have been unreachable, according to the results recorded by the ping subcommand, for the nickname to look stale."`
+}
+
+var pruneOptions pruneCommandOptions
+
+func (o *pruneCommandOptions) Usage() string {
+	return "[--check | --remove | --comment] [--days N] [--parallel N] [--timeout DURATION]"
+}
+
+func (o *pruneCommandOptions) Execute(args []string) error {
+	commandProcessor = pruneProcessor
+	commandName = "prune"
+
+	if len(args) > 0 {
+		return fmt.Errorf("Unrecognized positional arguments provided.")
+	}
+	chosen := 0
+	for _, option := range []bool{o.Check, o.Remove, o.Comment} {
+		if option {
+			chosen++
+		}
+	}
+	if chosen > 1 {
+		return fmt.Errorf("Only one of the --check, --remove, and --comment options can be given.")
+	}
+	if o.Days < 1 {
+		return fmt.Errorf("The --days option must be at least 1.")
+	}
+
+	return o.check()
+}
+
+// staleNickname describes a nickname that looks like it's no longer useful.
+type staleNickname struct {
+	nickname string
+	reason   string
+}
+
+// pruneCheck holds what the prune subcommand found out about a nickname:  the chain of nicknames it
+// extends, and why it looks stale, if it does.
+type pruneCheck struct {
+	chain  []string
+	reason string
+}
+
+// pruneProcessor lists the nicknames that look stale:  those whose kubectl config files, or whose
+// context, cluster, or user, no longer exist, and those whose API server has been unreachable for
+// the number of days given by --days, according to the ping history.  Unless --check is given,
+// their definitions are then removed from kconfig.yaml or commented out, as chosen by the --remove
+// or --comment option, or for each nickname on the terminal.  The nicknames are checked several at
+// a time, and each stale one is listed as soon as it and the ones before it have been checked.
+func pruneProcessor(positionalArgs []string) {
+	kconfig := config.GetKconfig()
+	nicknames, err := kconfig.MatchNicknames("*")
+	if err != nil {
+		config.ExitWithError(err)
+	}
+
+	var stale []staleNickname
+	chains := make(map[string][]string)
+	check := func(nickname string) (pruneCheck, error) {
+		resolution, err := kconfig.ResolveNickname(nickname)
+		if err != nil {
+			return pruneCheck{}, err
+		}
+		reason, err := staleNicknameReason(kconfig, nickname, resolution)
+		return pruneCheck{chain: resolution.Chain, reason: reason}, err
+	}
+	forEachNickname(nicknames, &pruneOptions.parallelOptions, check, func(nickname string, result pruneCheck, err error) {
+		if err != nil {
+			// A definition with a mistake is fixed, not pruned, and one that can't be checked is
+			// left alone.
+			fmt.Fprintf(os.Stderr, "Warning: Nickname \"%s\": %v\n", nickname, err)
+			return
+		}
+		chains[nickname] = result.chain
+		if result.reason != "" {
+			stale = append(stale, staleNickname{nickname: nickname, reason: result.reason})
+			fmt.Printf("%s: %s\n", nickname, result.reason)
+		}
+	})
+
+	if len(stale) == 0 {
+		fmt.Println("No nicknames look stale.")
+		return
+	}
+	if pruneOptions.Check {
+		os.Exit(1)
+	}
+
+	var toRemove, toComment []string
+	switch {
+	case pruneOptions.Remove:
+		for _, entry := range stale {
+			toRemove = append(toRemove, entry.nickname)
+		}
+	case pruneOptions.Comment:
+		for _, entry := range stale {
+			toComment = append(toComment, entry.nickname)
+		}
+	case term.IsTerminal(int(os.Stdin.Fd())):
+		reader := bufio.NewReader(os.Stdin)
+		for _, entry := range stale {
+			fmt.Fprintf(os.Stderr, "Remove, comment out, or keep \"%s\"? [r/c/K] ", entry.nickname)
+			answer, _ := reader.ReadString('\n')
+			switch strings.ToLower(strings.TrimSpace(answer)) {
+			case "r", "remove":
+				toRemove = append(toRemove, entry.nickname)
+			case "c", "comment":
+				toComment = append(toComment, entry.nickname)
+			}
+		}
+	default:
+		config.ExitWithError(config.NewCodedError(config.ErrorCodeUsage,
+			errors.New("Standard input isn't a terminal, so give the --remove or --comment option to change kconfig.yaml, or --check to only list the nicknames.")))
+	}
+	if len(toRemove) == 0 && len(toComment) == 0 {
+		return
+	}
+
+	err = config.EditKconfigFile(config.DefaultKconfigFilename(), func(editor *config.KconfigEditor) error {
+		for _, nickname := range toRemove {
+			editor.RemoveNickname(nickname)
+		}
+		for _, nickname := range toComment {
+			editor.CommentOutNickname(nickname)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	pruned := make(map[string]bool)
+	for _, nickname := range append(append([]string{}, toRemove...), toComment...) {
+		pruned[nickname] = true
+		err = config.RemoveNicknameKubectlConfigFile(nickname)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Unable to remove the kubectl config file of nickname \"%s\": %v\n", nickname, err)
+		}
+	}
+	if len(toRemove) > 0 {
+		fmt.Printf("Removed nicknames: %s\n", strings.Join(toRemove, ", "))
+	}
+	if len(toComment) > 0 {
+		fmt.Printf("Commented out nicknames: %s\n", strings.Join(toComment, ", "))
+	}
+
+	for _, nickname := range nicknames {
+		if pruned[nickname] {
+			continue
+		}
+		for _, extended := range chains[nickname] {
+			if pruned[extended] {
+				fmt.Fprintf(os.Stderr, "Warning: Nickname \"%s\" extends \"%s\", which is no longer defined.\n", nickname, extended)
+				break
+			}
+		}
+	}
+}
+
+// staleNicknameReason returns why the nickname looks stale, or an empty string if it doesn't.  An
+// error is returned if it can't be told, such as when the nickname has a problem that isn't a
+// sign that it's stale.
+func staleNicknameReason(kconfig *config.Kconfig, nickname string, resolution *config.NicknameResolution) (string, error) {
+	for _, filename := range filepath.SplitList(resolution.Options.KubeConfig) {
+		if _, err := os.Stat(filename); errors.Is(err, os.ErrNotExist) {
+			return fmt.Sprintf("The kubectl config file \"%s\" no longer exists.", filename), nil
+		}
+	}
+
+	createResults, err := kconfig.ResolveLocalKubectlConfig(nickname, nil)
+	if err != nil {
+		switch config.GetErrorCode(err) {
+		case config.ErrorCodeMissingContext, config.ErrorCodeMissingCluster, config.ErrorCodeMissingUser:
+			return err.Error(), nil
+		}
+		return "", err
+	}
+
+	serverURL := createResults.ServerURL()
+	history := config.GetPingHistory(serverURL)
+	if history == nil || history.UnreachableSince.IsZero() {
+		return "", nil
+	}
+	if time.Since(history.UnreachableSince) < time.Duration(pruneOptions.Days)*24*time.Hour {
+		return "", nil
+	}
+	return fmt.Sprintf("The API server %s has been unreachable since %s: %s", serverURL, history.UnreachableSince.Local().Format("2006-01-02"), history.LastError), nil
+}
+
+func init() {
+	_, err := parser.AddCommand("prune",
+		"Remove the nicknames of clusters that no longer exist",
+		"Resolves every nickname and lists those that look stale:  those whose kubectl config "+
+			"files, or whose context, cluster, or user, no longer exist, and those whose API server "+
+			"has been unreachable for the number of days given by --days, 30 by default, according "+
+			"to the results recorded by the ping subcommand.  With --check, that's all it does, and "+
+			"it fails if any look stale.  Otherwise, you're asked whether to remove each one from "+
+			"kconfig.yaml, comment it out, or keep it, unless --remove or --comment is given.  "+
+			"Several nicknames are checked at a time (--parallel), and one that takes longer than "+
+			"--timeout is skipped with a warning.",
+		&pruneOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
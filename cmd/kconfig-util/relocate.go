@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type relocateCommandOptions struct {
+	From string `long:"from" value-name:"DIR" description:"The directory to move the session-local kubectl config files from.  If not specified, the \"sessions\" subdirectory of the state directory is used."`
+}
+
+var relocateOptions relocateCommandOptions
+
+func (o *relocateCommandOptions) Usage() string {
+	return "[--from DIR]"
+}
+
+func (o *relocateCommandOptions) Execute(args []string) error {
+	commandProcessor = relocateProcessor
+	commandName = "relocate"
+
+	if len(args) > 0 {
+		return fmt.Errorf("Unrecognized positional arguments provided.")
+	}
+
+	return nil
+}
+
+// relocateProcessor moves the session-local kubectl config files of the active kset environments
+// to the directory named by the session_dir preference, leaving symbolic links behind so they keep
+// working in every shell.  If the KUBECONFIG environment variable of this shell names one of them,
+// or a link left behind by an earlier relocate, a statement that sets it to the new name is printed
+// to standard output, to be evaluated by the shell, and the link is removed.
+func relocateProcessor(positionalArgs []string) {
+	fromDir := relocateOptions.From
+	if fromDir == "" {
+		fromDir = config.DefaultSessionDirectory()
+	}
+	moved, err := config.RelocateSessionFiles(fromDir)
+	if err != nil {
+		config.ExitWithError(err)
+	}
+	if len(moved) > 0 {
+		fmt.Fprintf(os.Stderr, "Moved %d session-local kubectl config files from \"%s\".\n", len(moved), fromDir)
+	}
+
+	kubeconfigEnvVar := os.Getenv("KUBECONFIG")
+	filename := kubeconfigEnvVar
+	if pathSeparator := strings.IndexByte(filename, os.PathListSeparator); pathSeparator != -1 {
+		filename = filename[:pathSeparator]
+	}
+	absFromDir, err := filepath.Abs(fromDir)
+	if filename == "" || err != nil || filepath.Dir(filename) != absFromDir {
+		return
+	}
+	newFilename := moved[filename]
+	if newFilename == "" {
+		newFilename = config.RelocatedSessionFile(filename)
+	}
+	if newFilename == "" {
+		return
+	}
+
+	// Nothing else refers to the link once this shell stops using it.
+	err = os.Remove(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Unable to remove \"%s\": %v\n", filename, err)
+	}
+	fmt.Printf("export KUBECONFIG=%s\n", shellQuote(newFilename+kubeconfigEnvVar[len(filename):]))
+}
+
+func init() {
+	_, err := parser.AddCommand("relocate",
+		"Move the session-local kubectl config files to the session directory",
+		"Moves the session-local kubectl config files of the active kset environments to the "+
+			"directory named by the session_dir preference, from the \"sessions\" subdirectory of "+
+			"the state directory, or the directory given by --from.  Symbolic links are left behind, "+
+			"so the environments keep working in every shell.  Run it as "+
+			"'eval \"$(kconfig-util relocate)\"', so the KUBECONFIG environment variable of the shell "+
+			"names the new file.  Running it that way in the other shells does the same for them.",
+		&relocateOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type remapContextCommandOptions struct {
+	KubeConfig string `long:"kubeconfig" value-name:"FILE" description:"Also rename the context in this kubectl config file."`
+}
+
+var remapContextOptions remapContextCommandOptions
+
+func (o *remapContextCommandOptions) Usage() string {
+	return "old-context new-context [--kubeconfig FILE]"
+}
+
+func (o *remapContextCommandOptions) Execute(args []string) error {
+	commandProcessor = remapContextProcessor
+	commandName = "remap-context"
+
+	if len(args) != 2 {
+		return fmt.Errorf("The old and new context names must be specified.")
+	}
+
+	if args[0] == args[1] {
+		return fmt.Errorf("The new context name is the same as the old one.")
+	}
+
+	return nil
+}
+
+// remapContextProcessor changes the nickname definitions in the kconfig.yaml file that select the
+// old context to select the new one, after renaming the context in the kubectl config file named
+// by --kubeconfig, if given.  The local kubectl config files created by the kubectl program's
+// --kconfig option are removed, since they may name the old context.  A warning is printed for
+// each changed nickname that can't be resolved afterward.
+func remapContextProcessor(positionalArgs []string) {
+	oldContext := positionalArgs[0]
+	newContext := positionalArgs[1]
+
+	if remapContextOptions.KubeConfig != "" {
+		err := config.RenameKubeconfigContext(remapContextOptions.KubeConfig, oldContext, newContext)
+		if err != nil {
+			config.ExitWithError(err)
+		}
+		fmt.Printf("Renamed context \"%s\" to \"%s\" in \"%s\".\n", oldContext, newContext, remapContextOptions.KubeConfig)
+	}
+
+	var changed []string
+	err := config.EditKconfigFile(config.DefaultKconfigFilename(), func(editor *config.KconfigEditor) error {
+		changed = editor.RemapContext(oldContext, newContext)
+		return nil
+	})
+	if err != nil {
+		config.ExitWithError(err)
+	}
+	if len(changed) == 0 {
+		fmt.Printf("No nicknames select context \"%s\".\n", oldContext)
+		return
+	}
+	fmt.Printf("Changed the definitions that select context \"%s\": %s\n", oldContext, strings.Join(changed, ", "))
+
+	err = config.RemoveNicknameKubectlConfigFiles()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Unable to remove the kubectl config files of nicknames: %v\n", err)
+	}
+
+	kconfig, err := config.ReloadKconfig()
+	if err != nil {
+		config.ExitWithError(err)
+	}
+	for _, nickname := range changed {
+		_, err := kconfig.ResolveLocalKubectlConfig(nickname, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Nickname \"%s\" can't be resolved: %v\n", nickname, err)
+		}
+	}
+}
+
+func init() {
+	_, err := parser.AddCommand("remap-context",
+		"Change the context that nicknames select",
+		"Changes the definitions of the nicknames in the kconfig.yaml file that select the old "+
+			"context with the --context option to select the new one, keeping the rest of the file "+
+			"and its comments as they are.  With --kubeconfig, the context is first renamed in that "+
+			"kubectl config file, like \"kubectl config rename-context\" does.  This helps when a "+
+			"cluster migration renames contexts.  A warning names each changed nickname that can't be "+
+			"resolved afterward, such as when no kubectl config file in its search path defines the "+
+			"new context.",
+		&remapContextOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
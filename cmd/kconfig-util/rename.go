@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type renameCommandOptions struct{}
+
+var renameOptions renameCommandOptions
+
+func (o *renameCommandOptions) Usage() string {
+	return "old-nickname new-nickname"
+}
+
+func (o *renameCommandOptions) Execute(args []string) error {
+	commandProcessor = renameProcessor
+	commandName = "rename"
+
+	if len(args) != 2 {
+		return fmt.Errorf("The old and new nicknames must be specified.")
+	}
+
+	if args[0] == args[1] {
+		return fmt.Errorf("The new nickname is the same as the old one.")
+	}
+
+	return nil
+}
+
+// renameProcessor renames a nickname in the kconfig.yaml file, changing the definitions of the
+// nicknames that extend it, the preferences that name it, and the references to it in the state.
+// The local kubectl config file created for the old name by the kubectl program's --kconfig option
+// is removed.  Since the shell's kset environment can't be changed, there's only a warning if it
+// uses the old name.
+func renameProcessor(positionalArgs []string) {
+	oldNickname := positionalArgs[0]
+	newNickname := positionalArgs[1]
+
+	var extending, preferences []string
+	err := config.EditKconfigFile(config.DefaultKconfigFilename(), func(editor *config.KconfigEditor) error {
+		var err error
+		extending, err = editor.RenameNickname(oldNickname, newNickname)
+		if err != nil {
+			return err
+		}
+		preferences = editor.RenameNicknameInPreferences(oldNickname, newNickname)
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if len(extending) > 0 {
+		fmt.Printf("Changed the definitions that extend \"%s\": %s\n", oldNickname, strings.Join(extending, ", "))
+	}
+	if len(preferences) > 0 {
+		fmt.Printf("Changed the preferences that name \"%s\": %s\n", oldNickname, strings.Join(preferences, ", "))
+	}
+
+	config.RenameNicknameInState(oldNickname, newNickname)
+
+	err = config.RemoveNicknameKubectlConfigFile(oldNickname)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Unable to remove the kubectl config file of nickname \"%s\": %v\n", oldNickname, err)
+	}
+
+	ksetArgs := config.SplitKsetArgs(os.Getenv("_KCONFIG_KSET"))
+	for idx, arg := range ksetArgs {
+		if arg == oldNickname && (idx == 0 || ksetArgs[idx-1] == "--also") {
+			fmt.Fprintf(os.Stderr, "Warning: The kset environment of this shell uses the old nickname \"%s\".  Run kset again with \"%s\" to use the new one.\n", oldNickname, newNickname)
+			break
+		}
+	}
+}
+
+func init() {
+	_, err := parser.AddCommand("rename",
+		"Rename a nickname",
+		"Renames a nickname in the kconfig.yaml file, keeping its definition, comments, and place in "+
+			"the file.  The definitions of nicknames that extend it with the --extends option are "+
+			"changed to extend the new name, and its nickname_env and nickname_descriptions entries "+
+			"are renamed too, as are the default_nickname preference and danger_nicknames patterns "+
+			"that name it exactly.  The namespace history, the kset history, and the saved kset "+
+			"environments are also changed to use the new name.",
+		&renameOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
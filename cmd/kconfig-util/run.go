@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type runCommandOptions struct {
+	config.KconfigOptions
+}
+
+var runOptions runCommandOptions
+
+func (o *runCommandOptions) Usage() string {
+	return "nickname [override-options] -- command [arguments...]"
+}
+
+func (o *runCommandOptions) Execute(args []string) error {
+	commandProcessor = runProcessor
+	commandName = "run"
+
+	if len(args) < 2 {
+		return fmt.Errorf("A nickname and the command to run must be specified.")
+	}
+
+	return nil
+}
+
+// runProcessor runs a command with the environment kset would set up for the nickname, possibly
+// modified by override options, without changing the kset environment of the shell.  The
+// nickname's kubectl config file is written to a private temporary directory that's removed when
+// the command exits.  The process exits with the command's exit status.
+func runProcessor(positionalArgs []string) {
+	nickname := config.ExpandNickname(positionalArgs[0])
+	createResults, err := config.GetKconfig().ResolveLocalKubectlConfig(nickname, &runOptions.KconfigOptions)
+	if err != nil {
+		config.ExitWithError(err)
+	}
+
+	workDir, err := os.MkdirTemp("", "kconfig-run-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to create a temporary directory: %v\n", err)
+		os.Exit(1)
+	}
+	exitStatus := runWithNickname(positionalArgs[1:], nickname, &runOptions.KconfigOptions, createResults, workDir)
+	os.RemoveAll(workDir)
+	os.Exit(exitStatus)
+}
+
+func init() {
+	_, err := parser.AddCommand("run",
+		"Run a command with the environment of a nickname",
+		"Runs a command, like helm or a script, with the environment kset would set up for the "+
+			"nickname, possibly modified by override options, without changing the kset environment "+
+			"of the shell.  Put \"--\" before the command if any of its arguments are options.  The "+
+			"nickname's kubectl config file is written to a temporary directory that's removed when "+
+			"the command exits, and the command's exit status is returned.",
+		&runOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
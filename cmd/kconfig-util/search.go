@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type searchCommandOptions struct {
+	parallelOptions
+	Tags []string `long:"tag" value-name:"TAG" description:"Only search the nicknames that have this tag.  If the option is repeated, the nicknames must have all the tags."`
+}
+
+var searchOptions searchCommandOptions
+
+func (o *searchCommandOptions) Usage() string {
+	return "[--tag TAG]... [--parallel N] [--timeout DURATION] TERM"
+}
+
+func (o *searchCommandOptions) Execute(args []string) error {
+	commandProcessor = searchProcessor
+	commandName = "search"
+
+	if len(args) != 1 {
+		return fmt.Errorf("A single search term must be specified.")
+	}
+
+	return o.check()
+}
+
+// searchMatch describes a nickname found by the search subcommand.
+type searchMatch struct {
+	nickname  string
+	context   string
+	cluster   string
+	server    string
+	namespace string
+	user      string
+}
+
+// fields returns the values that are compared with the search term.
+func (m *searchMatch) fields() []string {
+	return []string{m.nickname, m.context, m.cluster, m.server, m.namespace, m.user}
+}
+
+// searchProcessor resolves every nickname and lists those whose name, kubectl context, cluster,
+// API server URL, namespace, or user contains the search term, ignoring case.  Nicknames that can't
+// be resolved are skipped with a warning.  The nicknames are resolved several at a time.  The
+// process exits with a failure status if no nicknames match.
+func searchProcessor(positionalArgs []string) {
+	term := strings.ToLower(positionalArgs[0])
+
+	kconfig := config.GetKconfig()
+	nicknames, err := kconfig.MatchNicknames("*")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var tagged []string
+	for _, nickname := range nicknames {
+		if kconfig.NicknameHasTags(nickname, searchOptions.Tags) {
+			tagged = append(tagged, nickname)
+		}
+	}
+
+	var matches []*searchMatch
+	forEachNickname(tagged, &searchOptions.parallelOptions, describeSearchNickname, func(nickname string, match *searchMatch, err error) {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Skipping nickname \"%s\": %v\n", nickname, err)
+			return
+		}
+		for _, field := range match.fields() {
+			if strings.Contains(strings.ToLower(field), term) {
+				matches = append(matches, match)
+				break
+			}
+		}
+	})
+
+	if len(matches) == 0 {
+		fmt.Fprintf(os.Stderr, "No nicknames%s match \"%s\".\n", describeTags(searchOptions.Tags), positionalArgs[0])
+		os.Exit(1)
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "NICKNAME\tCONTEXT\tCLUSTER\tSERVER\tNAMESPACE\tUSER")
+	for _, match := range matches {
+		fmt.Fprintln(writer, strings.Join(match.fields(), "\t"))
+	}
+	writer.Flush()
+}
+
+// describeSearchNickname resolves the nickname and returns the values the search term is compared
+// with.
+func describeSearchNickname(nickname string) (*searchMatch, error) {
+	createResults, err := config.GetKconfig().ResolveLocalKubectlConfig(nickname, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := createResults.MergedConfig()
+	match := &searchMatch{
+		nickname:  nickname,
+		context:   createResults.BaseContext,
+		namespace: createResults.ContextNamespace,
+	}
+	if context, exists := merged.Contexts[merged.CurrentContext]; exists {
+		match.cluster = context.Cluster
+		match.user = context.AuthInfo
+		if cluster, exists := merged.Clusters[context.Cluster]; exists {
+			match.server = cluster.Server
+		}
+	}
+	return match, nil
+}
+
+func init() {
+	_, err := parser.AddCommand("search",
+		"Find the nicknames that use a cluster, context, namespace, or user",
+		"Resolves every nickname and lists those whose name, kubectl context, cluster, API server "+
+			"URL, namespace, or user contains the search term, ignoring case.  For example, "+
+			"\"kconfig-util search api.prod.example.com\" lists the nicknames that use that API "+
+			"server.  Each kubectl config search path is read once, from the cache of merged "+
+			"kubectl configurations if the cache_kubeconfig preference enables it.  Several "+
+			"nicknames are resolved at a time (--parallel), and one that takes longer than "+
+			"--timeout is skipped with a warning.",
+		&searchOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type secretCommandOptions struct {
+}
+
+type secretSetCommandOptions struct {
+}
+
+type secretGetCommandOptions struct {
+}
+
+type secretRmCommandOptions struct {
+}
+
+var secretOptions secretCommandOptions
+var secretSetOptions secretSetCommandOptions
+var secretGetOptions secretGetCommandOptions
+var secretRmOptions secretRmCommandOptions
+
+func (o *secretSetCommandOptions) Usage() string {
+	return "name"
+}
+
+func (o *secretSetCommandOptions) Execute(args []string) error {
+	commandProcessor = secretSetProcessor
+	commandName = "secret set"
+	return checkSecretName(args)
+}
+
+func (o *secretGetCommandOptions) Usage() string {
+	return "name"
+}
+
+func (o *secretGetCommandOptions) Execute(args []string) error {
+	commandProcessor = secretGetProcessor
+	commandName = "secret get"
+	return checkSecretName(args)
+}
+
+func (o *secretRmCommandOptions) Usage() string {
+	return "name"
+}
+
+func (o *secretRmCommandOptions) Execute(args []string) error {
+	commandProcessor = secretRmProcessor
+	commandName = "secret rm"
+	return checkSecretName(args)
+}
+
+func checkSecretName(args []string) error {
+	switch len(args) {
+	case 0:
+		return fmt.Errorf("The name of the secret must be specified.")
+	case 1:
+		return nil
+	default:
+		return fmt.Errorf("Unrecognized positional arguments provided after the name of the secret.")
+	}
+}
+
+// secretSetProcessor stores a secret in the OS keychain under the name.  The secret is read from
+// the terminal without echoing it, or from the first line of standard input if it isn't a
+// terminal, so it doesn't appear in the shell history.
+func secretSetProcessor(positionalArgs []string) {
+	name := positionalArgs[0]
+
+	var value string
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprintf(os.Stderr, "Secret for \"%s\": ", name)
+		input, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading the secret: %v\n", err)
+			os.Exit(1)
+		}
+		value = string(input)
+	} else {
+		input, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			fmt.Fprintf(os.Stderr, "Error reading the secret: %v\n", err)
+			os.Exit(1)
+		}
+		value = strings.TrimRight(input, "\r\n")
+	}
+
+	if value == "" {
+		config.ExitWithError(config.NewCodedError(config.ErrorCodeUsage, errors.New("The secret is empty.")))
+	}
+	err := config.SetSecret(name, value)
+	if err != nil {
+		config.ExitWithError(err)
+	}
+}
+
+// secretGetProcessor prints the secret stored in the OS keychain under the name.
+func secretGetProcessor(positionalArgs []string) {
+	value, err := config.GetSecret(positionalArgs[0])
+	if err != nil {
+		config.ExitWithError(err)
+	}
+	fmt.Println(value)
+}
+
+// secretRmProcessor removes the secret stored in the OS keychain under the name.
+func secretRmProcessor(positionalArgs []string) {
+	err := config.DeleteSecret(positionalArgs[0])
+	if err != nil {
+		config.ExitWithError(err)
+	}
+}
+
+func init() {
+	secretCommand, err := parser.AddCommand("secret",
+		"Manage the secrets kconfig keeps in the OS keychain",
+		"Manage the secrets, like the tokens named by the --keychain-token option of nickname "+
+			"definitions, that kconfig keeps in the OS keychain:  the login keychain on macOS, or the "+
+			"Secret Service (like GNOME Keyring or KWallet), through the secret-tool command, "+
+			"elsewhere.",
+		&secretOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+
+	_, err = secretCommand.AddCommand("set",
+		"Store a secret in the OS keychain",
+		"Stores a secret in the OS keychain under the name, replacing any secret already stored "+
+			"under it.  The secret is read from the terminal without echoing it, or from the first "+
+			"line of standard input.",
+		&secretSetOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+
+	_, err = secretCommand.AddCommand("get",
+		"Print a secret stored in the OS keychain",
+		"Prints the secret stored in the OS keychain under the name.",
+		&secretGetOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+
+	_, err = secretCommand.AddCommand("rm",
+		"Remove a secret from the OS keychain",
+		"Removes the secret stored in the OS keychain under the name.",
+		&secretRmOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type sessionsCommandOptions struct {
+	Delete string `long:"delete" value-name:"NAME" description:"Delete the kset environment saved under this name, instead of listing them."`
+}
+
+var sessionsOptions sessionsCommandOptions
+
+func (o *sessionsCommandOptions) Usage() string {
+	return "[--delete NAME]"
+}
+
+func (o *sessionsCommandOptions) Execute(args []string) error {
+	commandProcessor = sessionsProcessor
+	commandName = "sessions"
+
+	if len(args) > 0 {
+		return fmt.Errorf("Unrecognized positional arguments provided.")
+	}
+
+	return nil
+}
+
+// sessionsProcessor lists the kset environments saved with "kset --save", sorted by name, or
+// deletes one.
+func sessionsProcessor(positionalArgs []string) {
+	if sessionsOptions.Delete != "" {
+		if !config.DeleteSavedSession(sessionsOptions.Delete) {
+			fmt.Fprintf(os.Stderr, "No kset environment is saved as \"%s\".\n", sessionsOptions.Delete)
+			os.Exit(1)
+		}
+		return
+	}
+
+	sessions := config.GetSavedSessions()
+	names := make([]string, 0, len(sessions))
+	for name := range sessions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		session := sessions[name]
+		fmt.Printf("%s  %s  %s\n", name, session.Time.Local().Format("2006-01-02 15:04:05"), formatKsetHistoryArgs(session.Args))
+	}
+}
+
+// restoreSavedSession handles the kset --restore option.  It returns the nickname of the kset
+// environment saved under the name, and updates the override options to be the saved ones, as
+// overridden by those already given.  The saved --also nicknames are used unless some are already
+// given.  If there's no such environment, the process is exited.
+func restoreSavedSession(name string, kconfigOptions *config.KconfigOptions, alsoNicknames *[]string) string {
+	session := config.GetSavedSession(name)
+	if session == nil || len(session.Args) == 0 {
+		config.ExitWithError(config.NewCodedError(config.ErrorCodeUsage, fmt.Errorf("No kset environment is saved as \"%s\".", name)))
+	}
+
+	savedOptions, savedAlso, err := config.ParseKsetArgs(session.Args)
+	if err != nil {
+		config.ExitWithError(fmt.Errorf("The kset environment saved as \"%s\" can't be restored: %v", name, err))
+	}
+
+	savedOptions.Merge(kconfigOptions)
+	*kconfigOptions = *savedOptions
+	if len(*alsoNicknames) == 0 {
+		*alsoNicknames = savedAlso
+	}
+	return session.Args[0]
+}
+
+func init() {
+	_, err := parser.AddCommand("sessions",
+		"List the saved kset environments",
+		"Lists the kset environments saved with \"kset --save NAME\", which can be activated "+
+			"again in any shell with \"kset --restore NAME\".  The --delete option deletes one.",
+		&sessionsOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
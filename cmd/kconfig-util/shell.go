@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/jphx/kconfig/config"
+)
+
+// subshellEnvVar names the environment variable that's set to the nickname in a shell started by
+// the shell subcommand.
+const subshellEnvVar = "_KCONFIG_SUBSHELL"
+
+type shellCommandOptions struct {
+	config.KconfigOptions
+}
+
+var shellOptions shellCommandOptions
+
+func (o *shellCommandOptions) Usage() string {
+	return "nickname [override-options]"
+}
+
+func (o *shellCommandOptions) Execute(args []string) error {
+	commandProcessor = shellProcessor
+	commandName = "shell"
+
+	switch len(args) {
+	case 0:
+		return fmt.Errorf("A nickname must be specified.")
+	case 1:
+		// Good
+	default:
+		return fmt.Errorf("Unrecognized positional arguments provided after the nickname.")
+	}
+
+	return nil
+}
+
+// shellProcessor starts the user's shell with the environment kset would set up for the nickname,
+// possibly modified by override options, and with the shell prompt changed as kset changes it.
+// The session-local kubectl config file is removed when the shell exits, so nothing needs to be
+// undone.  The process exits with the shell's exit status.
+func shellProcessor(positionalArgs []string) {
+	nickname := config.ExpandNickname(positionalArgs[0])
+	if outer := os.Getenv(subshellEnvVar); outer != "" {
+		fmt.Fprintf(os.Stderr, "Warning: Starting a shell for nickname \"%s\" within the shell for nickname \"%s\".\n", nickname, outer)
+	}
+
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+
+	// A new session-local file is created, rather than replacing the one of any kset environment
+	// of the shell this is run from.
+	os.Unsetenv("KUBECONFIG")
+	createResults := config.CreateLocalKubectlConfigFile(nickname, &shellOptions.KconfigOptions, nil, true, "")
+	localConfigFilename := config.GetExistingSessionLocalFilename(createResults.NewKubeconfigEnvVar)
+	ksetArgs := append([]string{nickname}, shellOptions.KconfigOptions.Args()...)
+	config.RecordNamespace(nickname, createResults.ContextNamespace)
+	config.RecordKset(ksetArgs)
+
+	// The work directory holds the shell's startup files.
+	workDir, err := os.MkdirTemp("", "kconfig-shell-")
+	var cmd *exec.Cmd
+	if err == nil {
+		cmd, err = subshellCommand(shell, getPromptPrefix(nickname, createResults), createResults.Danger, workDir)
+	}
+	if err == nil {
+		cmd.Env = append(ksetEnvironment(createResults, createKsetArgs(ksetArgs)), cmd.Env...)
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", subshellEnvVar, nickname))
+	}
+
+	exitStatus := 1
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to set up the shell: %v\n", err)
+	} else {
+		exitStatus = runAttached(cmd)
+	}
+
+	err = config.RemoveLocalKubectlConfigFile(localConfigFilename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Unable to remove the local kubectl config file \"%s\": %v\n", localConfigFilename, err)
+	}
+	os.RemoveAll(workDir)
+	os.Exit(exitStatus)
+}
+
+// subshellCommand returns the command that starts the interactive shell with the prompt prefix, if
+// there is one.  For bash and zsh, startup files written to the work directory run the user's own
+// startup files and then change the prompt, as the kset shell function does.  For other shells, the
+// PS1 environment variable is set, which they may or may not use.  Any environment variables the
+// command needs are set in its Env field.
+func subshellCommand(shell string, promptPrefix string, danger bool, workDir string) (*exec.Cmd, error) {
+	var color string
+	if danger {
+		color = config.GetKconfig().DangerPromptColor()
+	}
+
+	switch filepath.Base(shell) {
+	case "bash":
+		coloredPrefix := promptPrefix
+		if color != "" {
+			coloredPrefix = `\[\e[` + color + `m\]` + promptPrefix + `\[\e[0m\]`
+		}
+		rcFilename := filepath.Join(workDir, "bashrc")
+		err := os.WriteFile(rcFilename, []byte(fmt.Sprintf(`if [[ -f ~/.bashrc ]]; then
+   source ~/.bashrc
+fi
+%s`, subshellPromptCommands(promptPrefix, coloredPrefix))), 0600)
+		if err != nil {
+			return nil, err
+		}
+		return exec.Command(shell, "--rcfile", rcFilename, "-i"), nil
+
+	case "zsh":
+		coloredPrefix := promptPrefix
+		if color != "" {
+			coloredPrefix = "%{\x1b[" + color + "m%}" + promptPrefix + "%{\x1b[0m%}"
+		}
+		userDir := os.Getenv("ZDOTDIR")
+		if userDir == "" {
+			userDir = os.Getenv("HOME")
+		}
+
+		// zsh reads its startup files from ZDOTDIR.  Each of these runs the user's own file of the
+		// same name, with ZDOTDIR set as the user expects.  After .zshrc, the last one read by an
+		// interactive shell that isn't a login shell, it's left that way.
+		for _, name := range []string{".zshenv", ".zshrc"} {
+			contents := fmt.Sprintf(`ZDOTDIR=%s
+if [[ -f "$ZDOTDIR/%s" ]]; then
+   source "$ZDOTDIR/%s"
+fi
+`, shellQuote(userDir), name, name)
+			if name == ".zshrc" {
+				contents += subshellPromptCommands(promptPrefix, coloredPrefix)
+			} else {
+				contents += fmt.Sprintf("ZDOTDIR=%s\n", shellQuote(workDir))
+			}
+			err := os.WriteFile(filepath.Join(workDir, name), []byte(contents), 0600)
+			if err != nil {
+				return nil, err
+			}
+		}
+		cmd := exec.Command(shell, "-i")
+		cmd.Env = []string{"ZDOTDIR=" + workDir}
+		return cmd, nil
+
+	default:
+		cmd := exec.Command(shell, "-i")
+		if promptPrefix != "" {
+			ps1 := os.Getenv("PS1")
+			if ps1 == "" {
+				ps1 = "$ "
+			}
+			cmd.Env = []string{fmt.Sprintf("PS1=(%s) %s", promptPrefix, ps1)}
+		}
+		return cmd, nil
+	}
+}
+
+// subshellPromptCommands returns the shell commands that prefix the shell prompt, as the kset shell
+// function does, or nothing if there's no prompt prefix.
+func subshellPromptCommands(promptPrefix string, coloredPrefix string) string {
+	if promptPrefix == "" {
+		return ""
+	}
+	return fmt.Sprintf("_KCONFIG_OLD_PS1=\"$PS1\"\nPS1=%s\"$PS1\"\n", shellQuote("("+coloredPrefix+") "))
+}
+
+func init() {
+	_, err := parser.AddCommand("shell",
+		"Start a shell with the environment of a nickname",
+		"Starts your shell, as named by the SHELL environment variable, with the environment kset "+
+			"would set up for the nickname, possibly modified by override options, and with the "+
+			"shell prompt changed as kset changes it.  Exiting the shell returns to the environment "+
+			"you started it from, and the session-local kubectl config file is removed.  It's an "+
+			"alternative to the kset shell function that doesn't need the setup script.",
+		&shellOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jphx/kconfig/config"
+)
+
+// printTerminalTitleUpdate prints the shell command that sets the title of the terminal window or
+// tab to the given text, if the user has asked for it with the set_terminal_title preference.  If
+// saveTitle is true, the command first asks the terminal to save the current title on its title
+// stack so that printTerminalTitleRestore() can bring it back.
+func printTerminalTitleUpdate(title string, saveTitle bool) {
+	if !config.GetKconfig().Preferences.SetTerminalTitle {
+		return
+	}
+
+	if saveTitle {
+		fmt.Println(`printf '\033[22;0t'`)
+	}
+	fmt.Printf("printf '\\033]0;%%s\\007' %s\n", shellQuote(title))
+}
+
+// printTerminalTitleRestore prints the shell command that restores the title saved by
+// printTerminalTitleUpdate(), if the user has asked for terminal title updates.  The title is first
+// cleared, so that terminals that don't support the title stack at least fall back to their
+// default title.
+func printTerminalTitleRestore() {
+	if !config.GetKconfig().Preferences.SetTerminalTitle {
+		return
+	}
+
+	fmt.Println(`printf '\033]0;\007\033[23;0t'`)
+}
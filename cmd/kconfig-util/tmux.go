@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/jphx/kconfig/config"
+)
+
+// The tmux user options that hold the kset environment and the status line text.  They're set for
+// the pane and for its window.  The window's are used to re-apply the environment in new panes.
+const (
+	tmuxKsetOption   = "@kconfig_kset"
+	tmuxStatusOption = "@kconfig_status"
+)
+
+type tmuxCommandOptions struct {
+}
+
+type tmuxRestoreCommandOptions struct {
+	shellFunctionNames
+}
+
+var tmuxOptions tmuxCommandOptions
+var tmuxRestoreOptions tmuxRestoreCommandOptions
+
+func (o *tmuxRestoreCommandOptions) Usage() string {
+	return "[--kset-name NAME] [--koff-name NAME]"
+}
+
+func (o *tmuxRestoreCommandOptions) Execute(args []string) error {
+	commandProcessor = tmuxRestoreProcessor
+	commandName = "tmux restore"
+
+	if len(args) > 0 {
+		return fmt.Errorf("Unrecognized positional arguments provided.")
+	}
+
+	return o.check()
+}
+
+// tmuxRestoreProcessor is run by the setup script in a new shell in a tmux pane.  It prints a kset
+// command that re-applies the kset environment last recorded for the pane's window, if any.
+func tmuxRestoreProcessor(positionalArgs []string) {
+	pane := getTmuxPane()
+	if pane == "" || os.Getenv("_KCONFIG_KSET") != "" {
+		return
+	}
+
+	output, err := exec.Command("tmux", "show-options", "-wqv", "-t", pane, tmuxKsetOption).Output()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: unable to read the kset environment of the tmux window: %v\n", err)
+		return
+	}
+
+	ksetDescription := strings.TrimSuffix(string(output), "\n")
+	if ksetDescription != "" {
+		fmt.Printf("%s %s\n", tmuxRestoreOptions.KsetName, shellQuoteArgs(config.SplitKsetArgs(ksetDescription)))
+	}
+}
+
+// getTmuxPane returns the ID of the tmux pane this command is running in, or an empty string if
+// it's not running in tmux or the tmux_integration preference isn't enabled.
+func getTmuxPane() string {
+	if !config.GetKconfig().Preferences.TmuxIntegration || os.Getenv("TMUX") == "" {
+		return ""
+	}
+	return os.Getenv("TMUX_PANE")
+}
+
+// updateTmux records the kset environment and the status line text in the tmux options of the
+// pane and its window, and refreshes the status line.  Empty values unset the options, as for
+// koff.  Nothing is done unless running in tmux with the tmux_integration preference enabled.
+// Problems are reported as warnings, since tmux integration is only a convenience.
+func updateTmux(ksetDescription string, status string) {
+	pane := getTmuxPane()
+	if pane == "" {
+		return
+	}
+
+	var args []string
+	for _, scope := range []string{"-p", "-w"} {
+		for _, option := range [][2]string{{tmuxKsetOption, ksetDescription}, {tmuxStatusOption, status}} {
+			if option[1] == "" {
+				args = append(args, "set-option", scope, "-u", "-t", pane, option[0], ";")
+			} else {
+				args = append(args, "set-option", scope, "-t", pane, option[0], option[1], ";")
+			}
+		}
+	}
+	args = append(args, "refresh-client", "-S")
+
+	output, err := exec.Command("tmux", args...).CombinedOutput()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: unable to update the tmux options: %v: %s\n", err, strings.TrimSpace(string(output)))
+	}
+}
+
+func init() {
+	tmuxCommand, err := parser.AddCommand("tmux",
+		"Helpers for tmux integration",
+		"Helpers for the integration with tmux that's enabled by the tmux_integration preference.  "+
+			"kset and koff record the kset environment of each tmux pane and its window in tmux "+
+			"options, and the setup script re-applies the window's environment in new panes.",
+		&tmuxOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+
+	_, err = tmuxCommand.AddCommand("restore",
+		"Print a kset command for the environment of the tmux window",
+		"Called by the setup script in a new tmux pane to print the kset command that re-applies "+
+			"the kset environment last recorded for the pane's window.",
+		&tmuxRestoreOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type uninstallCommandOptions struct {
+	Shell  string `long:"shell" value-name:"SHELL" description:"The shell whose initialization file to change.  If not specified, the files of all the shells are changed."`
+	RcFile string `long:"rc-file" value-name:"FILE" description:"The shell initialization file to change, if install was given the --rc-file option."`
+}
+
+var uninstallOptions uninstallCommandOptions
+
+func (o *uninstallCommandOptions) Usage() string {
+	return "[--shell bash|zsh|fish] [--rc-file FILE]"
+}
+
+func (o *uninstallCommandOptions) Execute(args []string) error {
+	commandProcessor = uninstallProcessor
+	commandName = "uninstall"
+
+	if len(args) > 0 {
+		return fmt.Errorf("Unrecognized positional arguments provided.")
+	}
+	if o.Shell != "" && o.RcFile != "" {
+		return fmt.Errorf("The --shell and --rc-file options can't both be given.")
+	}
+	if o.Shell != "" {
+		return checkInstallShell(o.Shell)
+	}
+
+	return nil
+}
+
+// uninstallProcessor removes the lines that install added from the shell initialization files,
+// leaving the rest of the files alone.
+func uninstallProcessor(positionalArgs []string) {
+	var rcFilenames []string
+	switch {
+	case uninstallOptions.RcFile != "":
+		rcFilenames = []string{uninstallOptions.RcFile}
+	case uninstallOptions.Shell != "":
+		rcFilenames = []string{defaultRcFilename(uninstallOptions.Shell)}
+	default:
+		for _, shell := range installShells {
+			rcFilenames = append(rcFilenames, defaultRcFilename(shell))
+		}
+	}
+
+	removed := false
+	for _, rcFilename := range rcFilenames {
+		contents, err := os.ReadFile(rcFilename)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to read \"%s\": %v\n", rcFilename, err)
+			os.Exit(1)
+		}
+		before, _, after, found := findInstalledBlock(string(contents))
+		if !found {
+			continue
+		}
+
+		// Install separates the lines from the rest of the file with an empty line.
+		if strings.HasSuffix(before, "\n\n") {
+			before = before[:len(before)-1]
+		}
+		err = writeRcFile(rcFilename, before+after)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write \"%s\": %v\n", rcFilename, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Removed the kconfig setup from \"%s\".\n", rcFilename)
+		removed = true
+	}
+
+	if !removed {
+		fmt.Println("kconfig isn't set up in any shell initialization file.")
+	}
+}
+
+func init() {
+	_, err := parser.AddCommand("uninstall",
+		"Remove the shell functions from shell initialization files",
+		"Removes the lines that the install subcommand added from the initialization file of the "+
+			"bash, zsh, or fish shell, or of all of them if --shell isn't given, or from the file "+
+			"named by --rc-file.  The rest of the file is left alone.",
+		&uninstallOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
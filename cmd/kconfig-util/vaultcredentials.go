@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type vaultCredentialsCommandOptions struct {
+}
+
+var vaultCredentialsOptions vaultCredentialsCommandOptions
+
+func (o *vaultCredentialsCommandOptions) Usage() string {
+	return "path"
+}
+
+func (o *vaultCredentialsCommandOptions) Execute(args []string) error {
+	commandProcessor = vaultCredentialsProcessor
+	commandName = "vault-credentials"
+
+	if len(args) != 1 {
+		return fmt.Errorf("The Vault path must be specified.")
+	}
+
+	return nil
+}
+
+// vaultCredentialsProcessor prints an ExecCredential holding the credentials read from the path in
+// HashiCorp Vault, or cached from an earlier read.  kubectl runs it as an exec credential plugin
+// for nicknames whose definitions have the --vault-path option.  The expiration of the credentials
+// is included, so kubectl runs it again when they need to be read again.
+func vaultCredentialsProcessor(positionalArgs []string) {
+	apiVersion, _ := getExecCredentialRequest()
+
+	credentials, err := config.GetVaultCredentials(positionalArgs[0])
+	if err != nil {
+		config.ExitWithError(err)
+	}
+
+	response := execCredentialResponse{
+		APIVersion: apiVersion,
+		Kind:       "ExecCredential",
+		Status: execCredentialResponseStatus{
+			Token:                 credentials.Token,
+			ClientCertificateData: credentials.ClientCertificateData,
+			ClientKeyData:         credentials.ClientKeyData,
+			ExpirationTimestamp:   credentials.Expiry.UTC().Format(time.RFC3339),
+		},
+	}
+	printExecCredential(&response)
+}
+
+func init() {
+	_, err := parser.AddCommand("vault-credentials",
+		"Get credentials from HashiCorp Vault for kubectl",
+		"Run by kubectl as an exec credential plugin for nicknames whose definitions have the "+
+			"--vault-path option.  Prints the token, or client certificate and key, read from the "+
+			"path in Vault, with the server and token given by the VAULT_ADDR and VAULT_TOKEN "+
+			"environment variables or ~/.vault-token, as for the vault command.  They're cached in "+
+			"~/.kube/kconfig-cache until their lease expires, or for the time given by the "+
+			"vault_cache_ttl preference.",
+		&vaultCredentialsOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
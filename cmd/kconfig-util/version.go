@@ -2,17 +2,27 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
 
 	"github.com/jphx/kconfig/common"
 )
 
+// shellProtocolEnvVar names the environment variable in which the setup script exports the version
+// of the interface it implements.
+const shellProtocolEnvVar = "_KCONFIG_SHELL_PROTOCOL"
+
 type versionCommandOptions struct {
+	Long  bool `long:"long" description:"Also print the commit and date it was built from, the Go version, and the shell protocol version."`
+	Check bool `long:"check" description:"Check that the shell functions in use match this version of kconfig-util, failing if they don't."`
 }
 
 var versionOptions versionCommandOptions
 
 func (o *versionCommandOptions) Usage() string {
-	return ""
+	return "[--long | --check]"
 }
 
 func (o *versionCommandOptions) Execute(args []string) error {
@@ -23,17 +33,89 @@ func (o *versionCommandOptions) Execute(args []string) error {
 		return fmt.Errorf("Unrecognized positional arguments provided.")
 	}
 
+	if o.Long && o.Check {
+		return fmt.Errorf("The --long option can't be used with the --check option.")
+	}
+
 	return nil
 }
 
+// versionProcessor prints the kconfig version, along with the build information with --long, or
+// checks the version of the shell functions.
 func versionProcessor(positionalArgs []string) {
-	fmt.Println(common.Version)
+	if versionOptions.Check {
+		problem := checkShellProtocol()
+		if problem != "" {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", problem)
+			os.Exit(1)
+		}
+		fmt.Printf("The shell functions match this version of kconfig-util (shell protocol %d).\n", common.ShellProtocolVersion)
+		return
+	}
+
+	if !versionOptions.Long {
+		fmt.Println(common.Version)
+		return
+	}
+
+	commit, buildDate := getBuildInfo()
+	fmt.Printf("Version:        %s\n", valueOrUnknown(common.Version))
+	fmt.Printf("Commit:         %s\n", valueOrUnknown(commit))
+	fmt.Printf("Build date:     %s\n", valueOrUnknown(buildDate))
+	fmt.Printf("Go version:     %s\n", runtime.Version())
+	fmt.Printf("Shell protocol: %d\n", common.ShellProtocolVersion)
+}
+
+// getBuildInfo returns the commit the program was built from and when it was built.  Values not
+// set when the program was linked are taken from the version control information recorded by the
+// Go toolchain, if there is any.
+func getBuildInfo() (string, string) {
+	commit := common.Commit
+	buildDate := common.BuildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && commit == "":
+				commit = setting.Value
+			case setting.Key == "vcs.time" && buildDate == "":
+				buildDate = setting.Value
+			}
+		}
+	}
+	return commit, buildDate
+}
+
+func valueOrUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
+
+// checkShellProtocol compares the version of the interface implemented by the shell functions, as
+// exported by the setup script, with the one kconfig-util implements.  It returns a description of
+// the problem if they don't match, or an empty string if they do.
+func checkShellProtocol() string {
+	value := os.Getenv(shellProtocolEnvVar)
+	if value == "" {
+		return "The shell functions don't report their version, so they're probably from an older " +
+			"version of kconfig.  Source the setup script again."
+	}
+
+	if value != strconv.Itoa(common.ShellProtocolVersion) {
+		return fmt.Sprintf("The shell functions use shell protocol %s, but kconfig-util uses %d.  "+
+			"Source the setup script of this version of kconfig again.", value, common.ShellProtocolVersion)
+	}
+	return ""
 }
 
 func init() {
 	_, err := parser.AddCommand("version",
 		"Print the kconfig version",
-		"Print the kconfig version to standard output.",
+		"Print the kconfig version to standard output.  With --long, the commit and date it was "+
+			"built from, the Go version, and the version of the interface with the shell functions "+
+			"are printed too.  With --check, the shell functions in use are checked to match this "+
+			"version of kconfig-util.",
 		&versionOptions)
 
 	if err != nil {
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jphx/kconfig/config"
+)
+
+type watchCommandOptions struct {
+	Interval time.Duration `long:"interval" value-name:"DURATION" default:"2s" description:"How often to check whether the files the nickname files are generated from have changed."`
+	Once     bool          `long:"once" description:"Generate the nickname files again once, and exit."`
+}
+
+var watchOptions watchCommandOptions
+
+func (o *watchCommandOptions) Usage() string {
+	return "[--interval DURATION] [--once]"
+}
+
+func (o *watchCommandOptions) Execute(args []string) error {
+	commandProcessor = watchProcessor
+	commandName = "watch"
+
+	if len(args) != 0 {
+		return fmt.Errorf("No arguments are expected.")
+	}
+
+	if o.Interval <= 0 {
+		return fmt.Errorf("The --interval option must be a positive duration, like \"2s\".")
+	}
+
+	return nil
+}
+
+// watchProcessor keeps the local kubectl config files of the nicknames up to date, checking for
+// changes to the files they're generated from until it's interrupted.
+func watchProcessor(positionalArgs []string) {
+	var watcher config.NicknameFileWatcher
+	for {
+		changes, err := watcher.Check()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		} else {
+			reportNicknameFileChanges(changes)
+		}
+
+		if watchOptions.Once {
+			if err != nil || len(changes.Problems) > 0 {
+				os.Exit(1)
+			}
+			return
+		}
+		time.Sleep(watchOptions.Interval)
+	}
+}
+
+func reportNicknameFileChanges(changes *config.NicknameFileChanges) {
+	if len(changes.Regenerated) > 0 {
+		fmt.Printf("Regenerated the kubectl config files of nicknames: %s\n", strings.Join(changes.Regenerated, ", "))
+	}
+	if len(changes.Removed) > 0 {
+		fmt.Printf("Removed the kubectl config files of nicknames: %s\n", strings.Join(changes.Removed, ", "))
+	}
+	for _, problem := range changes.Problems {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", problem)
+	}
+}
+
+func init() {
+	_, err := parser.AddCommand("watch",
+		"Keep the kubectl config files of nicknames up to date",
+		"Generates the local kubectl config files that \"kubectl -k\" creates for nicknames again "+
+			"whenever kconfig.yaml, or any of the kubectl config files they're generated from, changes, "+
+			"so tools that read them directly never get ones that are out of date.  The files of "+
+			"nicknames that are no longer defined are removed.  It runs until it's interrupted, so "+
+			"it can be run in the background or as a service.  With --once, the files are generated "+
+			"again once.",
+		&watchOptions)
+
+	if err != nil {
+		panic(fmt.Sprintf("Error adding command for parsing: %v", err))
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jphx/kconfig/config"
+)
+
+// runWithAuditLog runs the kubectl executable as a child process, instead of replacing this
+// process with it, so that its exit status can be recorded in the audit log file.  It returns the
+// exit status to exit with.
+func runWithAuditLog(auditLogFilename string, nickname string, executable string, argsToPassToKubectl []string) int {
+	entry := &config.AuditLogEntry{
+		Time:     time.Now(),
+		Nickname: nickname,
+		Args:     config.RedactKubectlArgs(argsToPassToKubectl),
+	}
+	entry.Context, entry.Cluster, entry.Namespace = config.LookupContext(config.KubectlFlagValue(argsToPassToKubectl, "--context"))
+	if namespace := config.KubectlFlagValue(argsToPassToKubectl, "-n", "--namespace"); namespace != "" {
+		entry.Namespace = namespace
+	}
+
+	cmd := exec.Command(executable, argsToPassToKubectl...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// The terminal sends interrupts to the child process too, so they're only caught here to keep
+	// this process running long enough to record the exit status.  Other signals are forwarded.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	err := cmd.Start()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGTERM || sig == syscall.SIGHUP {
+				_ = cmd.Process.Signal(sig)
+			}
+		}
+	}()
+
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		entry.ExitStatus = 0
+	case errors.As(err, &exitErr):
+		entry.ExitStatus = exitErr.ExitCode()
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			entry.ExitStatus = 128 + int(status.Signal())
+		}
+	default:
+		fmt.Fprintln(os.Stderr, err.Error())
+		entry.ExitStatus = 1
+	}
+
+	err = config.AppendAuditLogEntry(auditLogFilename, entry)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: unable to write to the audit log file \"%s\": %v\n", auditLogFilename, err)
+	}
+
+	return entry.ExitStatus
+}
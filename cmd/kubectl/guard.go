@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jphx/kconfig/config"
+)
+
+// checkMutation enforces the nickname's policy for kubectl commands that change the cluster.  For
+// a read-only nickname, the process is exited.  For one that requires confirmation, the user is
+// asked to confirm the command, and the process is exited if they don't, or if there's no terminal
+// to ask with.
+func checkMutation(nickname string, argsToPassToKubectl []string) {
+	if nickname == "" || !config.IsMutatingKubectlCommand(argsToPassToKubectl) {
+		return
+	}
+
+	policy, err := config.GetKconfig().NicknameMutationPolicy(nickname)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	commandLine := "kubectl " + strings.Join(argsToPassToKubectl, " ")
+	switch policy {
+	case config.MutationsRefused:
+		fmt.Fprintf(os.Stderr, "Nickname \"%s\" is read-only.  Refusing to run: %s\n", nickname, commandLine)
+		os.Exit(1)
+
+	case config.MutationsNeedConfirmation:
+		if os.Getenv(config.NoConfirmEnvVar) == "" {
+			confirmMutation(nickname, commandLine)
+		}
+	}
+}
+
+// confirmMutation asks the user on the terminal to confirm the command.  If they don't, or if
+// there's no terminal to ask with, the process is exited.
+func confirmMutation(nickname string, commandLine string) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Nickname \"%s\" requires confirmation of \"%s\", but there's no terminal to ask with.  Set %s=1 to skip the confirmation.\n", nickname, commandLine, config.NoConfirmEnvVar)
+		os.Exit(1)
+	}
+	defer tty.Close()
+
+	fmt.Fprintf(tty, "Nickname \"%s\" requires confirmation to run: %s\nContinue? [y/N] ", nickname, commandLine)
+	answer, _ := bufio.NewReader(tty).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if answer != "y" && answer != "yes" {
+		fmt.Fprintln(os.Stderr, "Not confirmed.  The command was not run.")
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jphx/kconfig/config"
+)
+
+// kconfigHelpOption is the option that prints help for this program, rather than for the real
+// kubectl executable, which gets the --help option.
+const kconfigHelpOption = "--kconfig-help"
+
+// printKconfigHelp prints help for the options this program handles itself, followed by the
+// nickname in effect, the real kubectl executable that would be run, and the nicknames that can be
+// given with the -k option.
+func printKconfigHelp(me string) {
+	fmt.Print(`This kubectl program is installed with kconfig.  It runs the real kubectl executable of the
+kconfig nickname in effect, passing on its arguments.  These options are handled by this program,
+and only as the first option:
+
+  -k, --kconfig NICKNAME   Use the kconfig nickname for this command only, instead of the nickname
+                           of the kset environment or of a .kconfig file.  If the nickname is
+                           omitted, the one named by the default_nickname preference is used.
+      --kconfig-help       Print this help.
+
+If the nickname can't be used and the KCONFIG_WRAPPER_LENIENT environment variable is set, a
+warning is printed and the real kubectl executable is run as though it hadn't been given.
+
+`)
+
+	nickname, source := currentNickname()
+	kubectlExecutable := defaultKubectlExecutable()
+	if nickname == "" {
+		fmt.Println("Nickname in effect:       none")
+	} else {
+		fmt.Printf("Nickname in effect:       %s (%s)\n", nickname, source)
+		if source != "kset environment" {
+			createResults, err := config.GetKconfig().ResolveLocalKubectlConfig(nickname, nil)
+			if err != nil {
+				fmt.Printf("kubectl executable:       unknown: %v\n", err)
+				kubectlExecutable = ""
+			} else {
+				kubectlExecutable = createResults.KubectlExecutable
+			}
+		}
+	}
+
+	if kubectlExecutable != "" {
+		executable, err := findExecutable(kubectlExecutable, me)
+		if err != nil {
+			fmt.Printf("kubectl executable:       %s (%v)\n", kubectlExecutable, err)
+		} else {
+			fmt.Printf("kubectl executable:       %s\n", executable)
+		}
+	}
+
+	nicknames, err := config.GetKconfig().MatchNicknames("*")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if len(nicknames) == 0 {
+		fmt.Printf("\nNo nicknames are defined in %s.\n", config.DefaultKconfigFilename())
+		return
+	}
+	fmt.Println("\nNicknames:")
+	for _, nickname := range nicknames {
+		line := "  " + nickname
+		if aliases := config.GetKconfig().AliasesOf(nickname); len(aliases) > 0 {
+			line += fmt.Sprintf(" (aliases: %s)", strings.Join(aliases, ", "))
+		}
+		if description := config.GetKconfig().NicknameDescription(nickname); description != "" {
+			line += " - " + description
+		}
+		fmt.Println(line)
+	}
+}
+
+// currentNickname returns the nickname that a kubectl command without the -k option would use, and
+// where it comes from, or an empty string if there isn't one.
+func currentNickname() (string, string) {
+	nickname := maybeGetDirectoryNickname()
+	if nickname != "" {
+		return nickname, ".kconfig file"
+	}
+
+	nickname = config.KsetNickname(os.Getenv("_KCONFIG_KSET"))
+	if nickname != "" {
+		return nickname, "kset environment"
+	}
+	return "", ""
+}
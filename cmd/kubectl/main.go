@@ -20,16 +20,29 @@ func main() {
 	//fmt.Fprintf(os.Stderr, "my absolute path is: %s\n", me)
 
 	argsToPassToKubectl := os.Args[1:]
-	argsToPassToKubectl, kubectlExecutable := maybeCreateLocalConfigFile(argsToPassToKubectl)
+	if len(argsToPassToKubectl) > 0 && argsToPassToKubectl[0] == kconfigHelpOption {
+		printKconfigHelp(me)
+		return
+	}
+
+	argsToPassToKubectl, nickname := maybeGetKconfigNickname(argsToPassToKubectl)
+	if nickname == "" {
+		nickname = maybeGetDirectoryNickname()
+	}
+
+	var kubectlExecutable string
+	if nickname != "" {
+		nickname, kubectlExecutable = useNickname(nickname)
+	}
+	if nickname == "" {
+		nickname = config.KsetNickname(os.Getenv("_KCONFIG_KSET"))
+		refreshKsetEnvironment()
+	}
+
+	checkMutation(nickname, argsToPassToKubectl)
 
 	if kubectlExecutable == "" {
-		kubectlExecutable = os.Getenv("_KCONFIG_KUBECTL")
-		if kubectlExecutable == "" {
-			kubectlExecutable = config.GetKconfig().Preferences.DefaultKubectl
-			if kubectlExecutable == "" {
-				kubectlExecutable = "kubectl"
-			}
-		}
+		kubectlExecutable = defaultKubectlExecutable()
 	}
 
 	//fmt.Fprintf(os.Stderr, "Looking up executable: %s\n", kubectlExecutable)
@@ -39,6 +52,14 @@ func main() {
 		os.Exit(1)
 	}
 	//fmt.Fprintf(os.Stderr, "Found executable at: %s\n", executable)
+	addPluginPath(nickname, executable)
+	argsToPassToKubectl = applyClientSettings(nickname, argsToPassToKubectl)
+	translateKubeconfigForWindows(executable)
+
+	auditLogFilename := config.GetKconfig().AuditLogFilename()
+	if auditLogFilename != "" {
+		os.Exit(runWithAuditLog(auditLogFilename, nickname, executable, argsToPassToKubectl))
+	}
 
 	var argv []string
 	argv = append(argv, executable)
@@ -48,8 +69,12 @@ func main() {
 	os.Exit(1)
 }
 
-func maybeCreateLocalConfigFile(argsToPassToKubectl []string) ([]string, string) {
-	if len(argsToPassToKubectl) < 2 {
+// maybeGetKconfigNickname looks for the -k (--kconfig) option at the start of the arguments.  If
+// it's there, the nickname it names is returned, along with the remaining arguments to pass to the
+// kubectl executable.  If it isn't given a nickname, the one named by the default_nickname
+// preference is used.
+func maybeGetKconfigNickname(argsToPassToKubectl []string) ([]string, string) {
+	if len(argsToPassToKubectl) == 0 {
 		return argsToPassToKubectl, ""
 	}
 
@@ -58,21 +83,165 @@ func maybeCreateLocalConfigFile(argsToPassToKubectl []string) ([]string, string)
 		return argsToPassToKubectl, ""
 	}
 
-	nickname := argsToPassToKubectl[1]
-	if strings.HasPrefix(nickname, "-") {
-		fmt.Fprintf(os.Stderr, "The kconfig nickname is missing after the \"%s\" option.", firstArg)
+	if len(argsToPassToKubectl) < 2 || strings.HasPrefix(argsToPassToKubectl[1], "-") {
+		if defaultNickname := config.GetKconfig().Preferences.DefaultNickname; defaultNickname != "" {
+			return argsToPassToKubectl[1:], defaultNickname
+		}
+		fmt.Fprintf(os.Stderr, "The kconfig nickname is missing after the \"%s\" option.  Run \"kubectl %s\" for help.\n", firstArg, kconfigHelpOption)
 		os.Exit(1)
 	}
+	nickname := argsToPassToKubectl[1]
 
 	argsToPassToKubectl = argsToPassToKubectl[2:]
 
-	createResults := config.CreateLocalKubectlConfigFile(nickname, nil, false)
+	return argsToPassToKubectl, nickname
+}
+
+// maybeGetDirectoryNickname looks for a ".kconfig" file in the current directory or its ancestors
+// when no kset environment is in effect.  If one is found, the nickname it names is returned.
+// Only the nickname is used.  Any override options in the file apply only to the kset command.
+func maybeGetDirectoryNickname() string {
+	if os.Getenv("_KCONFIG_KSET") != "" {
+		return ""
+	}
+
+	filename, directoryArgs := config.FindDirectoryKconfig()
+	if filename == "" {
+		return ""
+	}
+
+	return directoryArgs[0]
+}
+
+// refreshKsetEnvironment generates the session-local kubectl config file of the kset environment in
+// effect again if the kubectl config files it was generated from have changed, so kubectl doesn't
+// use contexts, clusters, or users that are out of date.  If it can't be, a warning is printed.
+func refreshKsetEnvironment() {
+	kubeconfigEnvVar := os.Getenv("KUBECONFIG")
+	localConfigFilename := config.GetExistingSessionLocalFilename(kubeconfigEnvVar)
+	if localConfigFilename == "" {
+		return
+	}
+
+	changed := config.ChangedKubeconfigSources(localConfigFilename)
+	if len(changed) == 0 {
+		return
+	}
+
+	err := config.RefreshSessionLocalKubectlConfigFile(localConfigFilename, kubeconfigEnvVar, config.SplitKsetArgs(os.Getenv("_KCONFIG_KSET")))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %s changed since kset set up this environment, and the environment couldn't be updated: %v  Run kset again.\n", strings.Join(changed, ", "), err)
+	}
+}
+
+// addPluginPath puts the directories named by the --plugin-path option of the nickname at the start
+// of the PATH environment variable, so the kubectl executable finds its plugins there first.
+func addPluginPath(nickname string, executable string) {
+	if nickname == "" {
+		return
+	}
+
+	resolution, err := config.GetKconfig().ResolveNickname(nickname)
+	if err != nil {
+		// The kset environment's nickname might no longer be defined.  It doesn't need plugins.
+		return
+	}
+	directories := resolution.PluginDirectories(executable)
+	if len(directories) == 0 {
+		return
+	}
+
+	path := strings.Join(append(directories, os.Getenv("PATH")), string(os.PathListSeparator))
+	err = os.Setenv("PATH", path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting the PATH environment variable: %s", err)
+		os.Exit(1)
+	}
+}
+
+// applyClientSettings sets the environment variables of the nickname's --env and --cache-dir
+// options for the kubectl executable, which kset has already set in a kset environment, and returns
+// the arguments to pass it with the nickname's --request-timeout option added.
+func applyClientSettings(nickname string, argsToPassToKubectl []string) []string {
+	if nickname == "" {
+		return argsToPassToKubectl
+	}
+
+	resolution, err := config.GetKconfig().ResolveNickname(nickname)
+	if err != nil {
+		// The kset environment's nickname might no longer be defined.
+		return argsToPassToKubectl
+	}
+	err = resolution.SetEnvironment()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	return resolution.KubectlArgs(argsToPassToKubectl)
+}
+
+// translateKubeconfigForWindows changes the KUBECONFIG environment variable to name the same files
+// with Windows paths when the kubectl executable is a Windows program run from WSL, like
+// kubectl.exe, according to the path_style preference.  WSL only passes the environment variables
+// named by WSLENV on to Windows programs, so KUBECONFIG and TELEPORT_PROXY are added to it.
+func translateKubeconfigForWindows(executable string) {
+	kubeconfigEnvVar := os.Getenv("KUBECONFIG")
+	if kubeconfigEnvVar == "" {
+		return
+	}
+	useWindowsPaths, err := config.GetKconfig().UseWindowsPaths(executable)
+	if err != nil {
+		config.ExitWithError(err)
+	}
+	if !useWindowsPaths {
+		return
+	}
+
+	windowsKubeconfig, err := config.WindowsKubeconfigEnvVar(kubeconfigEnvVar)
+	if err == nil {
+		err = os.Setenv("KUBECONFIG", windowsKubeconfig)
+	}
+	if err == nil {
+		err = config.AddToWSLEnv("KUBECONFIG", "TELEPORT_PROXY")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error giving the KUBECONFIG environment variable to \"%s\": %v\n", executable, err)
+		os.Exit(1)
+	}
+}
+
+// lenientEnvVar names the environment variable that, when set to a non-empty value, makes a
+// nickname that can't be used a warning rather than an error.  kubectl is then run as though the
+// nickname hadn't been given, so wrappers in CI don't fail outright.
+const lenientEnvVar = "KCONFIG_WRAPPER_LENIENT"
+
+// useNickname creates the local kubectl config file for the nickname and sets the environment
+// variables that cause the target kubectl executable to use it.  It returns the nickname, expanded
+// if it's abbreviated, and the kubectl executable to use for it.  If the nickname can't be used,
+// the program exits, unless the lenient environment variable is set, in which case a warning is
+// printed and an empty nickname is returned.
+func useNickname(nickname string) (string, string) {
+	kconfig := config.GetKconfig()
+	expanded, err := kconfig.ExpandNickname(nickname)
+	var createResults *config.CreateConfigResults
+	if err == nil {
+		nickname = expanded
+		createResults, _, err = kconfig.PrecomputeNicknameKubectlConfigFile(nickname)
+	}
+	if err != nil {
+		err = kconfig.AddNicknameSuggestions(nickname, err)
+		if os.Getenv(lenientEnvVar) == "" {
+			config.ExitWithError(err)
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %v\nRunning kubectl without the nickname, since %s is set.\n", err, lenientEnvVar)
+		return "", ""
+	}
 
 	// Set the KUBECONFIG environment variable, which will be in the environment passed to the
 	// kubectl executable.  This will cause it to use this local kubectl configuration file.
-	err := os.Setenv("KUBECONFIG", createResults.NewKubeconfigEnvVar)
+	err = os.Setenv("KUBECONFIG", createResults.NewKubeconfigEnvVar)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "The kconfig nickname is missing after the \"%s\" option.", firstArg)
+		fmt.Fprintf(os.Stderr, "Error setting the KUBECONFIG environment variable: %s", err)
 		os.Exit(1)
 	}
 
@@ -86,7 +255,21 @@ func maybeCreateLocalConfigFile(argsToPassToKubectl []string) ([]string, string)
 		}
 	}
 
-	return argsToPassToKubectl, createResults.KubectlExecutable
+	return nickname, createResults.KubectlExecutable
+}
+
+// defaultKubectlExecutable returns the name of the kubectl executable to use when a nickname isn't
+// given with the -k option or a .kconfig file:  the one of the kset environment, or else the
+// default.
+func defaultKubectlExecutable() string {
+	kubectlExecutable := os.Getenv("_KCONFIG_KUBECTL")
+	if kubectlExecutable == "" {
+		kubectlExecutable = config.GetKconfig().Preferences.DefaultKubectl
+		if kubectlExecutable == "" {
+			kubectlExecutable = "kubectl"
+		}
+	}
+	return kubectlExecutable
 }
 
 func findExecutable(name string, skip string) (string, error) {
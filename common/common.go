@@ -1,6 +1,12 @@
 package common
 
-import "go.uber.org/zap"
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"go.uber.org/zap"
+)
 
 // Version contains the kconfig version.  It's used by the "version" subcommand.  It's intended to
 // be set as a build-time option using the "ldflags" option to "go build".  E.g.,
@@ -8,36 +14,172 @@ import "go.uber.org/zap"
 // go build -o bin/ -ldflags="-X github.com/jphx/kconfig/common.Version=${VERSION}" ./...
 var Version string
 
+// Commit and BuildDate describe the source the program was built from and when.  Like Version,
+// they're intended to be set with the "ldflags" option to "go build".  If they aren't, the version
+// control information recorded by the Go toolchain is used, if there is any.
+var Commit string
+var BuildDate string
+
+// ShellProtocolVersion is the version of the interface between kconfig-util and the shell functions
+// of the setup script:  the variables kset prints for them to evaluate, and the subcommands and
+// options they run.  It's increased whenever a change requires the shell functions to be sourced
+// again.  The setup script exports the version it implements in the _KCONFIG_SHELL_PROTOCOL
+// environment variable.
+const ShellProtocolVersion = 9
+
 // CommonOptions describes the command-line options for the program that are common to all
 // subcommands.
 var CommonOptions struct {
-	Debug bool `long:"debug" description:"Enable debug-level messages"`
+	Debug      bool   `long:"debug" description:"Enable debug-level messages"`
+	ErrorCodes bool   `long:"error-codes" description:"Follow error messages with a line like \"_KCONFIG_ERROR=unknown-nickname\" that classifies the error"`
+	LogFile    string `long:"log-file" value-name:"PATH" description:"Append log messages to this file instead of writing them to standard error.  The KCONFIG_LOG_FILE environment variable can be used instead"`
+	LogFormat  string `long:"log-format" value-name:"FORMAT" description:"Write log messages in this format, console (the default) or json.  The KCONFIG_LOG_FORMAT environment variable can be used instead"`
+	NoCache    bool   `long:"no-cache" description:"Don't use the cache of merged kubectl configurations, even if the cache_kubeconfig preference enables it"`
 }
 
+// LogFileEnvVar and LogFormatEnvVar name the environment variables that can be used instead of the
+// --log-file and --log-format options.  They're convenient for the shell functions, which don't
+// take the options common to all kconfig-util subcommands.
+const LogFileEnvVar = "KCONFIG_LOG_FILE"
+const LogFormatEnvVar = "KCONFIG_LOG_FORMAT"
+
+// logFile and logFormat are where and how log messages are written, as set by ConfigureLogging().
+var logFile string
+var logFormat = "console"
+
 // RootLogger is the root logger for the application.
-var RootLogger = initializeLogger()
+var RootLogger = &Logger{}
 
 // LoggingLevel controls the current logging level.  It's initially set to Info.
-var LoggingLevel zap.AtomicLevel
+var LoggingLevel = zap.NewAtomicLevelAt(zap.InfoLevel)
+
+// rootZapLogger is the zap logger underlying all of the Loggers.  It's built by the first Logger
+// that needs it.
+var rootZapLogger *zap.SugaredLogger
+var rootZapLoggerOnce sync.Once
+
+// Logger is a named logger.  The underlying zap logger is only built when a message is logged at
+// an enabled level, since building it takes a noticeable part of the time needed by fast commands
+// like those used for shell completion and prompts, which usually log nothing.
+type Logger struct {
+	name    string
+	once    sync.Once
+	sugared *zap.SugaredLogger
+}
 
 // CreateLogger creates a named child logger of the root logger.
-func CreateLogger(name string) *zap.SugaredLogger {
-	return RootLogger.Named(name)
+func CreateLogger(name string) *Logger {
+	return &Logger{name: name}
+}
+
+// sugaredLogger returns the underlying zap logger, building it if necessary.
+func (l *Logger) sugaredLogger() *zap.SugaredLogger {
+	l.once.Do(func() {
+		rootZapLoggerOnce.Do(func() {
+			rootZapLogger = initializeLogger()
+		})
+		l.sugared = rootZapLogger
+		if l.name != "" {
+			l.sugared = rootZapLogger.Named(l.name)
+		}
+	})
+	return l.sugared
+}
+
+// Debug logs the arguments at the debug level, if it's enabled.
+func (l *Logger) Debug(args ...interface{}) {
+	if LoggingLevel.Enabled(zap.DebugLevel) {
+		l.sugaredLogger().Debug(args...)
+	}
+}
+
+// Debugf logs the formatted message at the debug level, if it's enabled.
+func (l *Logger) Debugf(template string, args ...interface{}) {
+	if LoggingLevel.Enabled(zap.DebugLevel) {
+		l.sugaredLogger().Debugf(template, args...)
+	}
+}
+
+// Infof logs the formatted message at the info level, if it's enabled.
+func (l *Logger) Infof(template string, args ...interface{}) {
+	if LoggingLevel.Enabled(zap.InfoLevel) {
+		l.sugaredLogger().Infof(template, args...)
+	}
+}
+
+// Warnf logs the formatted message at the warning level, if it's enabled.
+func (l *Logger) Warnf(template string, args ...interface{}) {
+	if LoggingLevel.Enabled(zap.WarnLevel) {
+		l.sugaredLogger().Warnf(template, args...)
+	}
+}
+
+// Errorf logs the formatted message at the error level, if it's enabled.
+func (l *Logger) Errorf(template string, args ...interface{}) {
+	if LoggingLevel.Enabled(zap.ErrorLevel) {
+		l.sugaredLogger().Errorf(template, args...)
+	}
+}
+
+// Panic logs the arguments and then panics.
+func (l *Logger) Panic(args ...interface{}) {
+	l.sugaredLogger().Panic(args...)
+}
+
+// Sync flushes any buffered log entries.  It does nothing if the logger was never used.
+func (l *Logger) Sync() error {
+	if rootZapLogger == nil {
+		return nil
+	}
+	return rootZapLogger.Sync()
+}
+
+// ConfigureLogging applies the --log-file and --log-format options, or the environment variables
+// that can be used instead.  It must be called before anything is logged.
+func ConfigureLogging() error {
+	logFile = CommonOptions.LogFile
+	if logFile == "" {
+		logFile = os.Getenv(LogFileEnvVar)
+	}
+
+	format := CommonOptions.LogFormat
+	if format == "" {
+		format = os.Getenv(LogFormatEnvVar)
+	}
+	switch format {
+	case "":
+		// Keep the default.
+	case "console", "json":
+		logFormat = format
+	default:
+		return fmt.Errorf("Unrecognized log format \"%s\".  It must be console or json.", format)
+	}
+
+	return nil
 }
 
 func initializeLogger() *zap.SugaredLogger {
 	zapConfig := zap.NewProductionConfig()
-	LoggingLevel = zapConfig.Level
+	zapConfig.Level = LoggingLevel
 	//if debug {
 	//	loggingLevel.SetLevel(zap.DebugLevel)
 	//}
 
-	zapConfig.Encoding = "console"
+	zapConfig.Encoding = logFormat
+	if logFile != "" {
+		zapConfig.OutputPaths = []string{logFile}
+	}
 	//zapConfig.Development = debug
 	zapConfig.DisableCaller = true
 	//zapConfig.DisableStacktrace = true
 
 	zapLogger, err := zapConfig.Build()
+	if err != nil && logFile != "" {
+		// Log to standard error rather than lose the messages.
+		fmt.Fprintf(os.Stderr, "Warning: Unable to write log messages to \"%s\": %v\n", logFile, err)
+		zapConfig.OutputPaths = []string{"stderr"}
+		zapLogger, err = zapConfig.Build()
+	}
 	if err != nil {
 		panic("Unable to set up logger")
 	}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// aliasesLock protects the aliases remembered by each Kconfig, since nicknames can be resolved
+// concurrently.
+var aliasesLock sync.Mutex
+
+// NicknameAliases returns the aliases given by the --alias options of the nickname definitions,
+// mapped to the nicknames they're aliases of.  An alias that's also a nickname, or that's given by
+// more than one definition, is ignored, as are the aliases of definitions that can't be parsed.
+func (k *Kconfig) NicknameAliases() map[string]string {
+	aliasesLock.Lock()
+	defer aliasesLock.Unlock()
+	if k.aliases == nil {
+		k.aliases, _ = k.collectAliases()
+	}
+	return k.aliases
+}
+
+// AliasesOf returns the aliases of the nickname, in sorted order.
+func (k *Kconfig) AliasesOf(nickname string) []string {
+	var aliases []string
+	for alias, target := range k.NicknameAliases() {
+		if target == nickname {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// collectAliases returns the aliases of the nicknames, mapped to the nicknames they're aliases of,
+// along with descriptions of the aliases that are ignored.
+func (k *Kconfig) collectAliases() (map[string]string, []string) {
+	nicknames := make([]string, 0, len(k.Nicknames))
+	for nickname := range k.Nicknames {
+		nicknames = append(nicknames, nickname)
+	}
+	sort.Strings(nicknames)
+
+	aliasedBy := make(map[string][]string)
+	var aliases []string
+	for _, nickname := range nicknames {
+		definitionOptions, _, err := parseNicknameDefinition(k.Nicknames[nickname])
+		if err != nil {
+			continue
+		}
+		for _, alias := range definitionOptions.Aliases {
+			by := aliasedBy[alias]
+			if len(by) == 0 {
+				aliases = append(aliases, alias)
+			}
+			if len(by) == 0 || by[len(by)-1] != nickname {
+				aliasedBy[alias] = append(by, nickname)
+			}
+		}
+	}
+	sort.Strings(aliases)
+
+	targets := make(map[string]string)
+	var problems []string
+	for _, alias := range aliases {
+		_, isNickname := k.Nicknames[alias]
+		switch {
+		case isNickname:
+			problems = append(problems, fmt.Sprintf("alias \"%s\" of nickname \"%s\" is also a nickname", alias, aliasedBy[alias][0]))
+		case len(aliasedBy[alias]) > 1:
+			problems = append(problems, fmt.Sprintf("alias \"%s\" is given by more than one nickname: %s", alias, strings.Join(aliasedBy[alias], ", ")))
+		default:
+			targets[alias] = aliasedBy[alias][0]
+		}
+	}
+	return targets, problems
+}
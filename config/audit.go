@@ -0,0 +1,125 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// AuditLogEntry describes one line of the audit log file, which is written in the JSON Lines
+// format.
+type AuditLogEntry struct {
+	Time       time.Time `json:"time"`
+	Nickname   string    `json:"nickname,omitempty"`
+	Context    string    `json:"context,omitempty"`
+	Cluster    string    `json:"cluster,omitempty"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Args       []string  `json:"args"`
+	ExitStatus int       `json:"exit_status"`
+}
+
+// redactedValue replaces the values of the credential options in the arguments of the audit log.
+const redactedValue = "REDACTED"
+
+// credentialFlags are the kubectl options whose values are credentials, which aren't written to the
+// audit log file.
+var credentialFlags = map[string]bool{
+	"--client-certificate-data": true,
+	"--client-key-data":         true,
+	"--docker-password":         true,
+	"--from-literal":            true,
+	"--password":                true,
+	"--token":                   true,
+}
+
+// RedactKubectlArgs returns a copy of the kubectl command-line arguments with the values of the
+// options that hold credentials, like --token and --password, replaced, for the audit log file.
+// Either the "--option value" or "--option=value" form is recognized.  Arguments after a "--" are
+// left alone, since they're for another program.
+func RedactKubectlArgs(args []string) []string {
+	redacted := append([]string{}, args...)
+	for idx := 0; idx < len(redacted); idx++ {
+		arg := redacted[idx]
+		if arg == "--" {
+			break
+		}
+		name, _, hasValue := strings.Cut(arg, "=")
+		if !credentialFlags[name] {
+			continue
+		}
+		if hasValue {
+			redacted[idx] = name + "=" + redactedValue
+		} else if idx+1 < len(redacted) {
+			idx++
+			redacted[idx] = redactedValue
+		}
+	}
+	return redacted
+}
+
+func getDefaultAuditLogFilename() string {
+	return filepath.Join(getHomeDirectory(), ".kube", "kconfig-audit.jsonl")
+}
+
+// AuditLogFilename returns the name of the audit log file, or an empty string if the audit_log
+// preference isn't set.
+func (k *Kconfig) AuditLogFilename() string {
+	if !k.Preferences.AuditLog {
+		return ""
+	}
+	if k.Preferences.AuditLogFile != "" {
+		return k.Preferences.AuditLogFile
+	}
+	return getDefaultAuditLogFilename()
+}
+
+// AppendAuditLogEntry appends the entry to the audit log file, creating the file if necessary.
+func AppendAuditLogEntry(filename string, entry *AuditLogEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(filename), 0700)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(append(line, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// LookupContext returns the names of a context, its cluster, and its namespace from the kubectl
+// configuration, as found using the KUBECONFIG environment variable.  If the context name is
+// empty, the current context is used.  Empty strings are returned if the configuration can't be
+// read or there's no such context.
+func LookupContext(contextName string) (string, string, string) {
+	kubeconfig, err := readKubeConfig()
+	if err != nil {
+		return "", "", ""
+	}
+	if contextName == "" {
+		contextName = kubeconfig.CurrentContext
+	}
+
+	context, exists := kubeconfig.Contexts[contextName]
+	if !exists {
+		return contextName, "", ""
+	}
+
+	namespace := context.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	return contextName, context.Cluster, namespace
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedactKubectlArgs(t *testing.T) {
+	testCases := []struct {
+		Args   []string
+		Expect []string
+	}{
+		{[]string{"get", "pods"}, []string{"get", "pods"}},
+		{[]string{"--token", "abc123", "get", "pods"}, []string{"--token", "REDACTED", "get", "pods"}},
+		{[]string{"--token=abc123", "get", "pods"}, []string{"--token=REDACTED", "get", "pods"}},
+		{[]string{"--username", "admin", "--password", "s3cret", "get", "pods"}, []string{"--username", "admin", "--password", "REDACTED", "get", "pods"}},
+		{[]string{"create", "secret", "generic", "db", "--from-literal=password=s3cret"}, []string{"create", "secret", "generic", "db", "--from-literal=REDACTED"}},
+		{[]string{"config", "set-credentials", "me", "--client-key-data", "a2V5"}, []string{"config", "set-credentials", "me", "--client-key-data", "REDACTED"}},
+		{[]string{"get", "pods", "--token"}, []string{"get", "pods", "--token"}},
+		{[]string{"exec", "pod", "--", "login", "--password", "s3cret"}, []string{"exec", "pod", "--", "login", "--password", "s3cret"}},
+		{[]string{"get", "pods", "--tokens=x"}, []string{"get", "pods", "--tokens=x"}},
+	}
+	for _, testCase := range testCases {
+		original := append([]string{}, testCase.Args...)
+		actual := RedactKubectlArgs(testCase.Args)
+		if !reflect.DeepEqual(actual, testCase.Expect) {
+			t.Errorf("RedactKubectlArgs(%q) returned %q, expected %q", testCase.Args, actual, testCase.Expect)
+		}
+		if !reflect.DeepEqual(testCase.Args, original) {
+			t.Errorf("RedactKubectlArgs(%q) changed its argument", original)
+		}
+	}
+}
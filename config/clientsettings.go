@@ -0,0 +1,144 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// cacheDirEnvVar names the environment variable that kubectl reads the directory of its discovery
+// and HTTP caches from, which the --cache-dir option sets.
+const cacheDirEnvVar = "KUBECACHEDIR"
+
+// envVarNamePattern matches the names of environment variables that the --env option can set.
+var envVarNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// reservedEnvVars lists the environment variables that kset sets itself, so the --env option can't.
+var reservedEnvVars = map[string]bool{
+	"KUBECONFIG":     true,
+	"TELEPORT_PROXY": true,
+}
+
+// addDefinitionEnvironment adds the environment variables that the --env and --cache-dir options of
+// a nickname definition, and the nickname's entry in the nickname_env block, set to the
+// environment, unless a definition that extends it already set them.  The --env option takes
+// precedence over the block.
+func addDefinitionEnvironment(environment map[string]string, definitionOptions *nicknameDefinitionOptions, blockEnvironment map[string]string) error {
+	definitionEnvironment := make(map[string]string)
+	for name, value := range blockEnvironment {
+		if !envVarNamePattern.MatchString(name) {
+			return fmt.Errorf("The nickname_env entry \"%s\" isn't the name of an environment variable.", name)
+		}
+		if reservedEnvVars[name] || strings.HasPrefix(name, "_KCONFIG_") {
+			return fmt.Errorf("The nickname_env entry can't set %s, since kset sets it.", name)
+		}
+		definitionEnvironment[name] = value
+	}
+	for _, env := range definitionOptions.Env {
+		name, value, found := strings.Cut(env, "=")
+		if !found || !envVarNamePattern.MatchString(name) {
+			return fmt.Errorf("The --env option \"%s\" isn't like NAME=VALUE.", env)
+		}
+		if reservedEnvVars[name] || strings.HasPrefix(name, "_KCONFIG_") {
+			return fmt.Errorf("The --env option can't set %s, since kset sets it.", name)
+		}
+		definitionEnvironment[name] = value
+	}
+	if definitionOptions.CacheDir != "" {
+		cacheDir, err := expandCacheDir(definitionOptions.CacheDir)
+		if err != nil {
+			return err
+		}
+		definitionEnvironment[cacheDirEnvVar] = cacheDir
+	}
+
+	for name, value := range definitionEnvironment {
+		if _, exists := environment[name]; !exists {
+			environment[name] = value
+		}
+	}
+	return nil
+}
+
+// expandCacheDir returns the directory named by the --cache-dir option as an absolute path, with a
+// leading "~" replaced by the home directory, since kubectl can be run from any directory.
+func expandCacheDir(cacheDir string) (string, error) {
+	if cacheDir == "~" || strings.HasPrefix(cacheDir, "~/") {
+		cacheDir = filepath.Join(getHomeDirectory(), cacheDir[1:])
+	}
+	absolute, err := filepath.Abs(cacheDir)
+	if err != nil {
+		return "", fmt.Errorf("Unable to determine the absolute path of \"%s\": %v", cacheDir, err)
+	}
+	return absolute, nil
+}
+
+// sortedEnvironment returns the environment variables like "NAME=VALUE", sorted by name.
+func sortedEnvironment(environment map[string]string) []string {
+	names := make([]string, 0, len(environment))
+	for name := range environment {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var env []string
+	for _, name := range names {
+		env = append(env, name+"="+environment[name])
+	}
+	return env
+}
+
+// checkRequestTimeout returns an error if the --request-timeout option isn't a value kubectl
+// accepts:  a duration like "30s" or "2m", or a whole number of seconds.
+func checkRequestTimeout(requestTimeout string) error {
+	if requestTimeout == "" || strings.Trim(requestTimeout, "0123456789") == "" {
+		return nil
+	}
+	_, err := time.ParseDuration(requestTimeout)
+	if err != nil {
+		return fmt.Errorf("The --request-timeout option \"%s\" isn't a duration like \"30s\".", requestTimeout)
+	}
+	return nil
+}
+
+// KubectlArgs returns the arguments to pass to the kubectl executable for the nickname, given those
+// the kubectl program was run with.  The --request-timeout option of the nickname's definition is
+// added, unless the arguments already have one, just before any "--", which separates the
+// arguments of a command that kubectl runs.  Shell completion requests are left alone.
+func (r *NicknameResolution) KubectlArgs(args []string) []string {
+	if r.RequestTimeout == "" || len(args) == 0 || strings.HasPrefix(args[0], "__complete") {
+		return args
+	}
+
+	end := len(args)
+	for idx, arg := range args {
+		if arg == "--" {
+			end = idx
+			break
+		}
+		if arg == "--request-timeout" || strings.HasPrefix(arg, "--request-timeout=") {
+			return args
+		}
+	}
+
+	withTimeout := append([]string{}, args[:end]...)
+	withTimeout = append(withTimeout, "--request-timeout="+r.RequestTimeout)
+	return append(withTimeout, args[end:]...)
+}
+
+// SetEnvironment sets the environment variables of the nickname's --env and --cache-dir options in
+// this process, so the programs it runs get them.
+func (r *NicknameResolution) SetEnvironment() error {
+	for _, env := range r.Environment {
+		name, value, _ := strings.Cut(env, "=")
+		err := os.Setenv(name, value)
+		if err != nil {
+			return fmt.Errorf("Error setting the %s environment variable: %v", name, err)
+		}
+	}
+	return nil
+}
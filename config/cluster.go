@@ -0,0 +1,272 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// clusterRequestTimeout limits how long requests to the Kubernetes API server can take.
+const clusterRequestTimeout = 5 * time.Second
+
+// MergedConfig returns the kubectl configuration that kubectl would see when using the local
+// kubectl config file:  the configuration read from the search path, with the local file's
+// current context (and any context and user it defines) taking precedence.
+func (r *CreateConfigResults) MergedConfig() *clientcmdapi.Config {
+	merged := r.BaseConfig.DeepCopy()
+	for name, context := range r.ConfigContent.Contexts {
+		merged.Contexts[name] = context
+	}
+	for name, authInfo := range r.ConfigContent.AuthInfos {
+		merged.AuthInfos[name] = authInfo
+	}
+	for name, cluster := range r.ConfigContent.Clusters {
+		merged.Clusters[name] = cluster
+	}
+	merged.CurrentContext = r.ConfigContent.CurrentContext
+	return merged
+}
+
+// RESTConfig returns the configuration for making requests to the Kubernetes API server selected by
+// the local kubectl config file.
+func (r *CreateConfigResults) RESTConfig() (*rest.Config, error) {
+	return clientcmd.NewDefaultClientConfig(*r.MergedConfig(), &clientcmd.ConfigOverrides{}).ClientConfig()
+}
+
+// ListClusterNamespaces asks the Kubernetes API server for the names of its namespaces.  For an
+// OpenShift cluster, it asks for the projects the user can see instead.
+func (r *CreateConfigResults) ListClusterNamespaces() ([]string, error) {
+	if r.OpenShift {
+		projects, err := r.listOpenShiftProjects()
+		sort.Strings(projects)
+		return projects, err
+	}
+
+	var namespaceList struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}
+
+	err := r.getFromCluster("/api/v1/namespaces", &namespaceList)
+	if err != nil {
+		return nil, err
+	}
+
+	var namespaces []string
+	for _, item := range namespaceList.Items {
+		namespaces = append(namespaces, item.Metadata.Name)
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+// NamespaceExists asks the Kubernetes API server whether the namespace exists.  For an OpenShift
+// cluster, it asks whether the user can see the project.
+func (r *CreateConfigResults) NamespaceExists(namespace string) (bool, error) {
+	if r.OpenShift {
+		return r.openShiftProjectExists(namespace)
+	}
+
+	var namespaceObject map[string]interface{}
+	err := r.getFromCluster("/api/v1/namespaces/"+url.PathEscape(namespace), &namespaceObject)
+	var requestErr *clusterRequestError
+	if errors.As(err, &requestErr) && requestErr.statusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ServerVersion asks the Kubernetes API server for its version, like "v1.27.3".
+func (r *CreateConfigResults) ServerVersion() (string, error) {
+	var version struct {
+		GitVersion string `json:"gitVersion"`
+	}
+	err := r.requestFromCluster(http.MethodGet, "/version", nil, &version)
+	if err != nil {
+		return "", err
+	}
+	return version.GitVersion, nil
+}
+
+// ServerURL returns the URL of the Kubernetes API server selected by the local kubectl config
+// file, or an empty string if there isn't one.
+func (r *CreateConfigResults) ServerURL() string {
+	merged := r.MergedConfig()
+	context, exists := merged.Contexts[merged.CurrentContext]
+	if !exists {
+		return ""
+	}
+	cluster, exists := merged.Clusters[context.Cluster]
+	if !exists {
+		return ""
+	}
+	return cluster.Server
+}
+
+// UserName returns the name of the user in the kubectl config files that the local kubectl config
+// file's current context refers to, or an empty string if it doesn't name one.
+func (r *CreateConfigResults) UserName() string {
+	merged := r.MergedConfig()
+	context, exists := merged.Contexts[merged.CurrentContext]
+	if !exists {
+		return ""
+	}
+	return context.AuthInfo
+}
+
+// ClusterPing describes the results of checking that the Kubernetes API server can be reached and
+// accepts the user's credentials.
+type ClusterPing struct {
+	// ServerVersion is the Kubernetes version reported by the API server.
+	ServerVersion string
+
+	// Latency is how long the API server took to report its version.
+	Latency time.Duration
+
+	// Username is the name the API server knows the user by, if the API server supports
+	// SelfSubjectReview requests.
+	Username string
+}
+
+// Ping checks that the Kubernetes API server can be reached, by asking for its version, and that it
+// accepts the user's credentials, with a SelfSubjectReview request.  API servers too old to support
+// SelfSubjectReview requests are instead asked for their API versions, which needs credentials on
+// any API server that doesn't allow anonymous access.
+func (r *CreateConfigResults) Ping() (*ClusterPing, error) {
+	start := time.Now()
+	serverVersion, err := r.ServerVersion()
+	if err != nil {
+		return nil, err
+	}
+	ping := &ClusterPing{
+		ServerVersion: serverVersion,
+		Latency:       time.Since(start),
+	}
+
+	review := map[string]interface{}{
+		"apiVersion": "authentication.k8s.io/v1",
+		"kind":       "SelfSubjectReview",
+	}
+	var reviewResult struct {
+		Status struct {
+			UserInfo struct {
+				Username string `json:"username"`
+			} `json:"userInfo"`
+		} `json:"status"`
+	}
+	err = r.requestFromCluster(http.MethodPost, "/apis/authentication.k8s.io/v1/selfsubjectreviews", review, &reviewResult)
+	var requestErr *clusterRequestError
+	if errors.As(err, &requestErr) && requestErr.statusCode == http.StatusNotFound {
+		var apiVersions map[string]interface{}
+		err = r.requestFromCluster(http.MethodGet, "/api", nil, &apiVersions)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ping.Username = reviewResult.Status.UserInfo.Username
+	return ping, nil
+}
+
+// clusterRequestError is returned when the API server responds to a request with an unexpected
+// status.
+type clusterRequestError struct {
+	url        string
+	statusCode int
+	status     string
+	body       string
+}
+
+func (e *clusterRequestError) Error() string {
+	switch e.statusCode {
+	case http.StatusUnauthorized:
+		return fmt.Sprintf("The API server didn't accept the credentials (%s): %s", e.status, e.body)
+	case http.StatusForbidden:
+		return fmt.Sprintf("Request for %s was forbidden (%s): %s", e.url, e.status, e.body)
+	}
+	return fmt.Sprintf("Request for %s failed with status %s: %s", e.url, e.status, e.body)
+}
+
+// isAuthFailure says whether the API server rejected the request because of the credentials, or
+// because the user isn't allowed to make it.
+func (e *clusterRequestError) isAuthFailure() bool {
+	return e.statusCode == http.StatusUnauthorized || e.statusCode == http.StatusForbidden
+}
+
+// getFromCluster issues a GET request for the API server path and decodes the JSON response.
+func (r *CreateConfigResults) getFromCluster(path string, result interface{}) error {
+	return r.requestFromCluster(http.MethodGet, path, nil, result)
+}
+
+// requestFromCluster issues a request for the API server path, with the body encoded as JSON if
+// it isn't nil, and decodes the JSON response.
+func (r *CreateConfigResults) requestFromCluster(method string, path string, body interface{}, result interface{}) error {
+	restConfig, err := r.RESTConfig()
+	if err != nil {
+		return err
+	}
+
+	httpClient, err := rest.HTTPClientFor(restConfig)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), clusterRequestTimeout)
+	defer cancel()
+
+	var requestBody io.Reader
+	if body != nil {
+		encodedBody, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		requestBody = bytes.NewReader(encodedBody)
+	}
+
+	requestURL := strings.TrimSuffix(restConfig.Host, "/") + restConfig.APIPath + path
+	request, err := http.NewRequestWithContext(ctx, method, requestURL, requestBody)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	logger.Debugf("Requesting: %s %s", method, requestURL)
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		responseBody, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return &clusterRequestError{
+			url:        requestURL,
+			statusCode: response.StatusCode,
+			status:     response.Status,
+			body:       strings.TrimSpace(string(responseBody)),
+		}
+	}
+
+	return json.NewDecoder(response.Body).Decode(result)
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	clientauthv1 "k8s.io/client-go/pkg/apis/clientauthentication/v1"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// execInfoEnvVar is the environment variable kubectl uses to pass the ExecCredential request to an
+// exec credential plugin.
+const execInfoEnvVar = "KUBERNETES_EXEC_INFO"
+
+// CredentialStatus describes the credentials an exec credential plugin returned for a kubectl
+// user.
+type CredentialStatus struct {
+	// Context is the name of the kubectl context whose user was checked.
+	Context string
+
+	// User is the name of the kubectl user.
+	User string
+
+	// Command is the plugin command line, for display.
+	Command string
+
+	// Expiration is when the credentials expire.  It's the zero time if the plugin didn't say.
+	Expiration time.Time
+
+	// Token says whether the plugin returned a bearer token, as opposed to a client certificate.
+	Token bool
+}
+
+// ExecCredentialUser returns the name of the user of the current context of the kubectl
+// configuration, and the user's exec credential plugin configuration, which is nil if the user
+// doesn't use one.
+func ExecCredentialUser(kubeconfig *clientcmdapi.Config) (string, *clientcmdapi.ExecConfig) {
+	context := kubeconfig.Contexts[kubeconfig.CurrentContext]
+	if context == nil {
+		return "", nil
+	}
+	authInfo := kubeconfig.AuthInfos[context.AuthInfo]
+	if authInfo == nil {
+		return context.AuthInfo, nil
+	}
+	return context.AuthInfo, authInfo.Exec
+}
+
+// GetExecCredentialStatus runs the exec credential plugin of the user of the current context of the
+// kubectl configuration once, the way kubectl would, and reports on the credentials it returns.
+// Running it also lets plugins that cache their credentials, like most cloud-provider plugins,
+// refresh an expired token or perform an interactive login ahead of the first kubectl command.  If
+// interactive is true, the plugin can prompt the user on the terminal.  It returns nil and no
+// error if the user doesn't use an exec credential plugin.
+func GetExecCredentialStatus(kubeconfig *clientcmdapi.Config, interactive bool) (*CredentialStatus, error) {
+	userName, execConfig := ExecCredentialUser(kubeconfig)
+	if execConfig == nil {
+		return nil, nil
+	}
+
+	status := &CredentialStatus{
+		Context: kubeconfig.CurrentContext,
+		User:    userName,
+		Command: strings.Join(append([]string{execConfig.Command}, execConfig.Args...), " "),
+	}
+
+	if execConfig.InteractiveMode == clientcmdapi.NeverExecInteractiveMode {
+		interactive = false
+	}
+
+	request := clientauthv1.ExecCredential{
+		Spec: clientauthv1.ExecCredentialSpec{Interactive: interactive},
+	}
+	request.APIVersion = execConfig.APIVersion
+	request.Kind = "ExecCredential"
+	if execConfig.ProvideClusterInfo {
+		request.Spec.Cluster = execCredentialCluster(kubeconfig)
+	}
+
+	requestJSON, err := json.Marshal(&request)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(execConfig.Command, execConfig.Args...)
+	cmd.Env = os.Environ()
+	for _, envVar := range execConfig.Env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", envVar.Name, envVar.Value))
+	}
+	cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", execInfoEnvVar, requestJSON))
+	if interactive {
+		cmd.Stdin = os.Stdin
+	}
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+
+	logger.Debugf("Running exec credential plugin for user \"%s\": %s", userName, status.Command)
+	err = cmd.Run()
+	if err != nil {
+		if execConfig.InstallHint != "" && isNotFound(err) {
+			return nil, codedErrorf(ErrorCodeAuthFailure, "Exec credential plugin \"%s\" for user \"%s\" wasn't found:  %s", execConfig.Command, userName, execConfig.InstallHint)
+		}
+		return nil, codedErrorf(ErrorCodeAuthFailure, "Exec credential plugin \"%s\" for user \"%s\" failed: %w", execConfig.Command, userName, err)
+	}
+
+	var response clientauthv1.ExecCredential
+	err = json.Unmarshal(stdout.Bytes(), &response)
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding the output of exec credential plugin \"%s\" for user \"%s\": %w", execConfig.Command, userName, err)
+	}
+	if response.Status == nil {
+		return nil, codedErrorf(ErrorCodeAuthFailure, "Exec credential plugin \"%s\" for user \"%s\" didn't return any credentials", execConfig.Command, userName)
+	}
+
+	status.Token = response.Status.Token != ""
+	if response.Status.ExpirationTimestamp != nil {
+		status.Expiration = response.Status.ExpirationTimestamp.Time
+	}
+	return status, nil
+}
+
+// execCredentialCluster returns the description of the cluster of the current context that's
+// passed to exec credential plugins that ask for it.
+func execCredentialCluster(kubeconfig *clientcmdapi.Config) *clientauthv1.Cluster {
+	context := kubeconfig.Contexts[kubeconfig.CurrentContext]
+	cluster := kubeconfig.Clusters[context.Cluster]
+	if cluster == nil {
+		return nil
+	}
+
+	caData := cluster.CertificateAuthorityData
+	if len(caData) == 0 && cluster.CertificateAuthority != "" {
+		caData, _ = os.ReadFile(cluster.CertificateAuthority)
+	}
+	return &clientauthv1.Cluster{
+		Server:                   cluster.Server,
+		TLSServerName:            cluster.TLSServerName,
+		InsecureSkipTLSVerify:    cluster.InsecureSkipTLSVerify,
+		CertificateAuthorityData: caData,
+		ProxyURL:                 cluster.ProxyURL,
+	}
+}
+
+// isNotFound says whether running a command failed because the executable wasn't found.
+func isNotFound(err error) bool {
+	return errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist)
+}
@@ -0,0 +1,336 @@
+package config
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// daemonProtocolVersion identifies the format of the requests and responses that kconfig-util
+// exchanges with the daemon, so a daemon left running after an upgrade isn't misunderstood.
+const daemonProtocolVersion = 1
+
+// daemonRequestTimeout is how long kconfig-util waits for the daemon to respond before reading the
+// files itself.
+const daemonRequestTimeout = 2 * time.Second
+
+// The kinds of daemon requests.
+const (
+	// daemonRequestFile asks for the contents of a file, like kconfig.yaml.
+	daemonRequestFile = "file"
+	// daemonRequestKubeconfig asks for the merged kubectl configuration of a search path.
+	daemonRequestKubeconfig = "kubeconfig"
+	// daemonRequestStatus asks for a description of the daemon.
+	daemonRequestStatus = "status"
+	// daemonRequestStop asks the daemon to exit.
+	daemonRequestStop = "stop"
+)
+
+// daemonRequest describes the format of a request sent to the daemon, as a line of JSON.
+type daemonRequest struct {
+	Version int    `json:"version"`
+	Kind    string `json:"kind"`
+	Path    string `json:"path,omitempty"`
+}
+
+// daemonResponse describes the format of the daemon's response to a request, as a line of JSON.
+type daemonResponse struct {
+	Exists   bool   `json:"exists,omitempty"`
+	Contents []byte `json:"contents,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Status   string `json:"status,omitempty"`
+}
+
+// daemonCacheEntry holds a file, or the merged kubectl configuration of a search path, that the
+// daemon read, along with what was known about the files when they were read.
+type daemonCacheEntry struct {
+	stamps   []kubeconfigFileStamp
+	exists   bool
+	contents []byte
+}
+
+// Daemon keeps kconfig.yaml and the merged kubectl configurations of search paths in memory, and
+// hands them to kconfig-util processes that ask for them over a Unix socket, so they don't each
+// have to read and merge the files, which is slow on a network file system.  The files are
+// checked for changes on every request, so a response is never out of date.
+type Daemon struct {
+	listener net.Listener
+	started  time.Time
+
+	lock  sync.Mutex
+	cache map[string]*daemonCacheEntry
+	hits  int
+}
+
+// DaemonSocketFilename returns the name of the Unix socket the daemon listens on:  "daemon.sock"
+// in the state directory.  Since kconfig.yaml is read through the daemon, its state_dir preference
+// isn't used for this, so the socket can be found without reading it.
+func DaemonSocketFilename() string {
+	stateDir := defaultStateDirectory()
+	if envStateDir := os.Getenv("KCONFIG_STATE_DIR"); envStateDir != "" {
+		if absStateDir, err := filepath.Abs(envStateDir); err == nil {
+			stateDir = absStateDir
+		}
+	}
+	return filepath.Join(stateDir, "daemon.sock")
+}
+
+// NewDaemon starts listening on the daemon's socket.  It's an error if another daemon is already
+// running.  A socket left behind by a daemon that didn't exit cleanly is replaced.
+func NewDaemon() (*Daemon, error) {
+	socketFilename := DaemonSocketFilename()
+	if status, err := DaemonStatus(); err == nil {
+		return nil, fmt.Errorf("The kconfig daemon is already running: %s", status)
+	}
+
+	// Like makeStateDirectory(), a state directory named by KCONFIG_STATE_DIR is left alone.
+	var err error
+	if os.Getenv("KCONFIG_STATE_DIR") != "" {
+		err = os.MkdirAll(filepath.Dir(socketFilename), 0700)
+	} else {
+		err = makePrivateDirectory(filepath.Dir(socketFilename))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create directory \"%s\" for the daemon's socket: %v", filepath.Dir(socketFilename), err)
+	}
+	err = os.Remove(socketFilename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("Unable to remove the old socket \"%s\": %v", socketFilename, err)
+	}
+	listener, err := net.Listen("unix", socketFilename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to listen on \"%s\": %v", socketFilename, err)
+	}
+	err = os.Chmod(socketFilename, 0600)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("Unable to restrict the permissions of \"%s\": %v", socketFilename, err)
+	}
+
+	return &Daemon{
+		listener: listener,
+		started:  time.Now(),
+		cache:    make(map[string]*daemonCacheEntry),
+	}, nil
+}
+
+// Serve handles requests until Close() is called or a stop request is received.  The socket is
+// removed when it returns.
+func (d *Daemon) Serve() error {
+	defer os.Remove(DaemonSocketFilename())
+	for {
+		conn, err := d.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Error accepting a connection to the daemon: %v", err)
+		}
+		go d.handle(conn)
+	}
+}
+
+// Close stops the daemon from handling requests.
+func (d *Daemon) Close() {
+	d.listener.Close()
+}
+
+// handle responds to the request on the connection.
+func (d *Daemon) handle(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Minute))
+
+	var request daemonRequest
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err == nil {
+		err = json.Unmarshal(line, &request)
+	}
+	var response daemonResponse
+	switch {
+	case err != nil:
+		response.Error = fmt.Sprintf("invalid request: %v", err)
+	case request.Version != daemonProtocolVersion:
+		response.Error = fmt.Sprintf("the daemon understands version %d of the protocol, not %d", daemonProtocolVersion, request.Version)
+	case request.Kind == daemonRequestFile:
+		response.Exists, response.Contents, err = d.readFile(request.Path)
+	case request.Kind == daemonRequestKubeconfig:
+		response.Exists, response.Contents, err = d.readKubeconfig(request.Path)
+	case request.Kind == daemonRequestStatus:
+		response.Status = d.status()
+	case request.Kind == daemonRequestStop:
+		response.Status = "stopping"
+		defer d.Close()
+	default:
+		response.Error = fmt.Sprintf("unknown request \"%s\"", request.Kind)
+	}
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	contents, err := json.Marshal(&response)
+	if err == nil {
+		_, err = conn.Write(append(contents, '\n'))
+	}
+	if err != nil {
+		logger.Debugf("Unable to respond to a daemon request: %v", err)
+	}
+}
+
+// readFile returns whether the named file exists, and its contents, from memory if it hasn't
+// changed since it was last read.
+func (d *Daemon) readFile(filename string) (bool, []byte, error) {
+	return d.cached("file:"+filename, []string{filename}, func() (bool, []byte, error) {
+		contents, err := os.ReadFile(filename)
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil, nil
+		}
+		return err == nil, contents, err
+	})
+}
+
+// readKubeconfig returns the merged kubectl configuration of the search path, in the format of a
+// kubectl config file, from memory if none of its files have changed since they were last read.
+func (d *Daemon) readKubeconfig(searchPath string) (bool, []byte, error) {
+	return d.cached("kubeconfig:"+searchPath, newLoadingRules(searchPath).Precedence, func() (bool, []byte, error) {
+		kubeconfig, err := readKubeConfigFromSearchPath(searchPath)
+		if err != nil {
+			return false, nil, err
+		}
+		contents, err := clientcmd.Write(*kubeconfig)
+		return err == nil, contents, err
+	})
+}
+
+// cached returns the cache entry with the key if none of the files have changed since it was
+// made, and otherwise replaces it with the result of calling read.  Errors aren't cached.
+func (d *Daemon) cached(key string, files []string, read func() (bool, []byte, error)) (bool, []byte, error) {
+	stamps := getKubeconfigFileStamps(files)
+	d.lock.Lock()
+	entry, exists := d.cache[key]
+	if exists && kubeconfigFileStampsEqual(entry.stamps, stamps) {
+		d.hits++
+		d.lock.Unlock()
+		return entry.exists, entry.contents, nil
+	}
+	d.lock.Unlock()
+
+	fileExists, contents, err := read()
+	if err != nil {
+		return false, nil, err
+	}
+	d.lock.Lock()
+	d.cache[key] = &daemonCacheEntry{stamps: stamps, exists: fileExists, contents: contents}
+	d.lock.Unlock()
+	return fileExists, contents, nil
+}
+
+// status describes the daemon.
+func (d *Daemon) status() string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return fmt.Sprintf("process %d, up since %s, %d cached files and search paths, %d requests answered from memory",
+		os.Getpid(), d.started.Local().Format("2006-01-02 15:04:05"), len(d.cache), d.hits)
+}
+
+// askDaemon sends the request to the daemon and returns its response.  An error is returned if
+// there's no daemon, it doesn't respond in time, or it can't handle the request, in which case the
+// caller does the work itself.
+func askDaemon(request *daemonRequest) (*daemonResponse, error) {
+	request.Version = daemonProtocolVersion
+	conn, err := net.DialTimeout("unix", DaemonSocketFilename(), daemonRequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(daemonRequestTimeout))
+
+	contents, err := json.Marshal(request)
+	if err == nil {
+		_, err = conn.Write(append(contents, '\n'))
+	}
+	var line []byte
+	if err == nil {
+		line, err = bufio.NewReader(conn).ReadBytes('\n')
+	}
+	var response daemonResponse
+	if err == nil {
+		err = json.Unmarshal(line, &response)
+	}
+	if err == nil && response.Error != "" {
+		err = errors.New(response.Error)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("The kconfig daemon didn't handle the request: %v", err)
+	}
+	return &response, nil
+}
+
+// useDaemon says whether kconfig-util asks the daemon for files, which it does if the daemon's
+// socket exists, unless noCache is true, as for the --no-cache option.
+func useDaemon(noCache bool) bool {
+	if noCache {
+		return false
+	}
+	_, err := os.Stat(DaemonSocketFilename())
+	return err == nil
+}
+
+// readFileFromDaemon returns whether the named file exists, and its contents, as the daemon has
+// them.  The returned bool is false if the daemon isn't used, in which case the caller reads the
+// file itself.
+func readFileFromDaemon(filename string, noCache bool) (bool, []byte, bool) {
+	if !useDaemon(noCache) || !filepath.IsAbs(filename) {
+		return false, nil, false
+	}
+	response, err := askDaemon(&daemonRequest{Kind: daemonRequestFile, Path: filename})
+	if err != nil {
+		logger.Debugf("Reading \"%s\" without the daemon: %v", filename, err)
+		return false, nil, false
+	}
+	logger.Debugf("Got \"%s\" from the daemon.", filename)
+	return response.Exists, response.Contents, true
+}
+
+// readKubeconfigFromDaemon returns the merged kubectl configuration of the search path, as the
+// daemon has it, in the format of a kubectl config file, or nil if the daemon isn't used.  Since
+// the daemon can run in a different directory, it's only used for search paths of absolute paths.
+func readKubeconfigFromDaemon(searchPath string, noCache bool) []byte {
+	if !useDaemon(noCache) {
+		return nil
+	}
+	for _, filename := range newLoadingRules(searchPath).Precedence {
+		if !filepath.IsAbs(filename) {
+			return nil
+		}
+	}
+	response, err := askDaemon(&daemonRequest{Kind: daemonRequestKubeconfig, Path: searchPath})
+	if err != nil {
+		logger.Debugf("Reading the kubectl configuration without the daemon: %v", err)
+		return nil
+	}
+	logger.Debugf("Got the kubectl configuration of search path \"%s\" from the daemon.", searchPath)
+	return response.Contents
+}
+
+// DaemonStatus returns a description of the running daemon, or an error if there isn't one.
+func DaemonStatus() (string, error) {
+	response, err := askDaemon(&daemonRequest{Kind: daemonRequestStatus})
+	if err != nil {
+		return "", err
+	}
+	return response.Status, nil
+}
+
+// StopDaemon asks the running daemon to exit.
+func StopDaemon() error {
+	_, err := askDaemon(&daemonRequest{Kind: daemonRequestStop})
+	return err
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// DefaultDangerPromptColor gives the ANSI SGR parameters used to color the shell prompt prefix of
+// dangerous nicknames, if the danger_prompt_color preference isn't set.  It's bold red.
+const DefaultDangerPromptColor = "1;31"
+
+// sgrParametersRegexp matches a valid list of ANSI SGR parameters.
+var sgrParametersRegexp = regexp.MustCompile(`^[0-9]+(;[0-9]+)*$`)
+
+// IsDangerNickname says whether the nickname is tagged as dangerous, either by the --danger option
+// in its resolved definition or by matching one of the patterns in the danger_nicknames
+// preference.
+func (k *Kconfig) IsDangerNickname(nickname string, resolution *NicknameResolution) bool {
+	if resolution != nil && resolution.Danger {
+		return true
+	}
+
+	for _, pattern := range k.Preferences.DangerNicknames {
+		matched, err := filepath.Match(pattern, nickname)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: invalid pattern \"%s\" in the danger_nicknames preference: %v\n", pattern, err)
+			continue
+		}
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DangerPromptColor returns the ANSI SGR parameters to use to color the shell prompt prefix of
+// dangerous nicknames.  An invalid danger_prompt_color preference is reported as a warning and the
+// default is used instead.
+func (k *Kconfig) DangerPromptColor() string {
+	color := k.Preferences.DangerPromptColor
+	if color == "" {
+		return DefaultDangerPromptColor
+	}
+
+	if !sgrParametersRegexp.MatchString(color) {
+		fmt.Fprintf(os.Stderr, "Warning: the danger_prompt_color preference \"%s\" isn't a list of ANSI SGR parameters like \"1;31\".\n", color)
+		return DefaultDangerPromptColor
+	}
+
+	return color
+}
+
+// NoConfirmEnvVar names the environment variable that, when set to a non-empty value, skips the
+// confirmation of commands that change the cluster.  It's intended for scripts.  It doesn't
+// affect read-only nicknames.
+const NoConfirmEnvVar = "KCONFIG_NO_CONFIRM"
+
+// MutationPolicy says how the kubectl program treats commands that change the cluster.
+type MutationPolicy int
+
+const (
+	// MutationsAllowed says commands that change the cluster are run normally.
+	MutationsAllowed MutationPolicy = iota
+
+	// MutationsNeedConfirmation says the user has to confirm commands that change the cluster.
+	MutationsNeedConfirmation
+
+	// MutationsRefused says commands that change the cluster aren't run.
+	MutationsRefused
+)
+
+// NicknameMutationPolicy returns how commands that change the cluster are treated for the
+// nickname.  They're refused when its resolved definition has the --read-only option.  They need
+// confirmation when it has the --confirm-mutations option, or when the nickname is dangerous and
+// the confirm_mutations preference is set.
+func (k *Kconfig) NicknameMutationPolicy(nickname string) (MutationPolicy, error) {
+	resolution, err := k.ResolveNickname(nickname)
+	if err != nil {
+		return MutationsAllowed, err
+	}
+
+	switch {
+	case resolution.ReadOnly:
+		return MutationsRefused, nil
+	case resolution.ConfirmMutations:
+		return MutationsNeedConfirmation, nil
+	case k.Preferences.ConfirmMutations && k.IsDangerNickname(nickname, resolution):
+		return MutationsNeedConfirmation, nil
+	default:
+		return MutationsAllowed, nil
+	}
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/shlex"
+	"github.com/jessevdk/go-flags"
+)
+
+// DirectoryKconfigFilename is the name of the file that selects a nickname for a directory tree.
+const DirectoryKconfigFilename = ".kconfig"
+
+// FindDirectoryKconfig searches the current working directory and its ancestors for a ".kconfig"
+// file, if the directory_kconfig preference is enabled.  If one is found, its name is returned
+// along with the kset arguments it contains, the first of which is the nickname.  Otherwise an
+// empty string and a nil slice are returned.  If the file can't be read or parsed, the process is
+// exited with an error message.
+func FindDirectoryKconfig() (string, []string) {
+	if !GetKconfig().Preferences.DirectoryKconfig {
+		return "", nil
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		logger.Debugf("Unable to determine the current directory: %v", err)
+		return "", nil
+	}
+
+	for {
+		filename := filepath.Join(dir, DirectoryKconfigFilename)
+		args, err := ReadDirectoryKconfig(filename)
+		if err == nil {
+			logger.Debugf("Found directory kconfig file \"%s\" with arguments: %v", filename, args)
+			return filename, args
+		}
+
+		if !errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "Error reading directory kconfig file \"%s\": %v\n", filename, err)
+			os.Exit(1)
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			logger.Debug("No directory kconfig file found")
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// ReadDirectoryKconfig reads and parses the named ".kconfig" file.  The file contains a nickname,
+// optionally followed by kset override and --also options, using shell-like quoting.  Lines
+// starting with "#" are comments.  Since the file can come from a repository, anything else is an
+// error rather than being passed on to kset.
+func ReadDirectoryKconfig(filename string) ([]string, error) {
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	args, err := shlex.Split(string(contents))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return nil, fmt.Errorf("The file must start with a kconfig nickname.")
+	}
+
+	var options struct {
+		KconfigOptions
+		Also []string `long:"also"`
+	}
+	extraArgs, err := flags.NewParser(&options, flags.None).ParseArgs(args[1:])
+	if err != nil {
+		return nil, fmt.Errorf("The kset options in the file can't be parsed: %v", err)
+	}
+	if len(extraArgs) > 0 {
+		return nil, fmt.Errorf("The file can only hold a nickname and kset options, but it also has: %s", strings.Join(extraArgs, " "))
+	}
+
+	return args, nil
+}
@@ -0,0 +1,278 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	// EncryptionAge says to encrypt credentials with the age command, using the identity file
+	// named by the age_identity_file preference.
+	EncryptionAge = "age"
+
+	// EncryptionKeychain says to encrypt credentials with AES-256-GCM, using a key kept in the OS
+	// keychain:  the login keychain on macOS, and the Secret Service (through secret-tool)
+	// elsewhere.
+	EncryptionKeychain = "keychain"
+)
+
+// encryptedCredentialsSuffix is appended to the name of a local kubectl config file to name the
+// file that holds its encrypted credentials.
+const encryptedCredentialsSuffix = ".credentials"
+
+// keychainService and keychainAccount identify the key kept in the OS keychain.
+const (
+	keychainService = "kconfig"
+	keychainAccount = "credentials-key"
+)
+
+// StoredCredentials are the credentials of a kubectl user that are kept encrypted instead of in a
+// local kubectl config file.
+type StoredCredentials struct {
+	Token                 string `json:"token,omitempty"`
+	ClientCertificateData []byte `json:"client_certificate_data,omitempty"`
+	ClientKeyData         []byte `json:"client_key_data,omitempty"`
+}
+
+// protectCredentials handles the encrypt_credentials preference for the local kubectl config file
+// about to be written with the configuration.  The tokens and client keys of its users are
+// encrypted into a file next to it, and the configuration returned has users that run
+// "kconfig-util decrypt-credentials" as an exec credential plugin to get them instead.  Without
+// the preference, the configuration is returned unchanged, and any such file is removed.
+func protectCredentials(filename string, kubeconfig *clientcmdapi.Config, preferences *KconfigPreferences) (*clientcmdapi.Config, error) {
+	credentialsFilename := filename + encryptedCredentialsSuffix
+	method := preferences.EncryptCredentials
+	if method == "" {
+		err := os.Remove(credentialsFilename)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		return kubeconfig, nil
+	}
+
+	protected := kubeconfig.DeepCopy()
+	credentials := make(map[string]*StoredCredentials)
+	for userName, authInfo := range protected.AuthInfos {
+		if authInfo.Token == "" && len(authInfo.ClientKeyData) == 0 {
+			continue
+		}
+		if authInfo.Exec != nil || authInfo.AuthProvider != nil {
+			logger.Debugf("Not encrypting the credentials of user \"%s\", which already has a credential plugin.", userName)
+			continue
+		}
+
+		// An exec credential plugin has to return the client certificate along with the key.
+		stored := &StoredCredentials{
+			Token:                 authInfo.Token,
+			ClientCertificateData: authInfo.ClientCertificateData,
+			ClientKeyData:         authInfo.ClientKeyData,
+		}
+		if len(stored.ClientKeyData) > 0 && len(stored.ClientCertificateData) == 0 && authInfo.ClientCertificate != "" {
+			certificate, err := os.ReadFile(authInfo.ClientCertificate)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to read the client certificate of user \"%s\": %v", userName, err)
+			}
+			stored.ClientCertificateData = certificate
+		}
+		credentials[userName] = stored
+
+		authInfo.Token = ""
+		authInfo.ClientCertificate = ""
+		authInfo.ClientCertificateData = nil
+		authInfo.ClientKeyData = nil
+		authInfo.Exec = &clientcmdapi.ExecConfig{
+			APIVersion:      "client.authentication.k8s.io/v1",
+			Command:         "kconfig-util",
+			Args:            []string{"decrypt-credentials", credentialsFilename, userName},
+			InstallHint:     "kconfig-util is installed with kconfig.  Make sure it's on your PATH.",
+			InteractiveMode: clientcmdapi.IfAvailableExecInteractiveMode,
+		}
+	}
+
+	if len(credentials) == 0 {
+		err := os.Remove(credentialsFilename)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		return protected, nil
+	}
+
+	plaintext, err := json.Marshal(credentials)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := encryptCredentials(method, plaintext, preferences)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to encrypt the credentials with method \"%s\": %w", method, err)
+	}
+
+	// The method is recorded in the file, so the credentials can still be decrypted if the
+	// preference changes.
+	err = writeFileAtomically(credentialsFilename, append([]byte(method+"\n"), ciphertext...), 0600)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("Encrypted the credentials of %d users in \"%s\".", len(credentials), credentialsFilename)
+	return protected, nil
+}
+
+// DecryptCredentials returns the credentials of the user kept in the named file of encrypted
+// credentials, as written for a local kubectl config file with the encrypt_credentials preference.
+func DecryptCredentials(credentialsFilename string, userName string) (*StoredCredentials, error) {
+	contents, err := os.ReadFile(credentialsFilename)
+	if err != nil {
+		return nil, err
+	}
+
+	method, ciphertext, found := bytes.Cut(contents, []byte("\n"))
+	if !found {
+		return nil, fmt.Errorf("The file \"%s\" doesn't hold encrypted credentials.", credentialsFilename)
+	}
+	plaintext, err := decryptCredentials(string(method), ciphertext, &GetKconfig().Preferences)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decrypt the credentials in \"%s\": %w", credentialsFilename, err)
+	}
+
+	var credentials map[string]*StoredCredentials
+	err = json.Unmarshal(plaintext, &credentials)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing the credentials in \"%s\": %v", credentialsFilename, err)
+	}
+	stored, exists := credentials[userName]
+	if !exists {
+		return nil, fmt.Errorf("The file \"%s\" has no credentials for user \"%s\".", credentialsFilename, userName)
+	}
+	return stored, nil
+}
+
+func encryptCredentials(method string, plaintext []byte, preferences *KconfigPreferences) ([]byte, error) {
+	switch method {
+	case EncryptionAge:
+		identityFilename, err := ageIdentityFilename(preferences)
+		if err != nil {
+			return nil, err
+		}
+		return runFilter(plaintext, "age", "--encrypt", "--identity", identityFilename)
+
+	case EncryptionKeychain:
+		key, err := keychainKey(true)
+		if err != nil {
+			return nil, err
+		}
+		gcm, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, gcm.NonceSize())
+		_, err = rand.Read(nonce)
+		if err != nil {
+			return nil, err
+		}
+		return gcm.Seal(nonce, nonce, plaintext, nil), nil
+
+	default:
+		return nil, fmt.Errorf("The encryption method must be \"%s\" or \"%s\".", EncryptionAge, EncryptionKeychain)
+	}
+}
+
+func decryptCredentials(method string, ciphertext []byte, preferences *KconfigPreferences) ([]byte, error) {
+	switch method {
+	case EncryptionAge:
+		identityFilename, err := ageIdentityFilename(preferences)
+		if err != nil {
+			return nil, err
+		}
+		return runFilter(ciphertext, "age", "--decrypt", "--identity", identityFilename)
+
+	case EncryptionKeychain:
+		key, err := keychainKey(false)
+		if err != nil {
+			return nil, err
+		}
+		gcm, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(ciphertext) < gcm.NonceSize() {
+			return nil, errors.New("The encrypted credentials are truncated.")
+		}
+		return gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], nil)
+
+	default:
+		return nil, fmt.Errorf("Unrecognized encryption method \"%s\".", method)
+	}
+}
+
+// ageIdentityFilename returns the name of the age identity file named by the age_identity_file
+// preference, or "~/.kube/kconfig-age.txt".
+func ageIdentityFilename(preferences *KconfigPreferences) (string, error) {
+	filename := preferences.AgeIdentityFile
+	if filename == "" {
+		filename = filepath.Join(getHomeDirectory(), ".kube", "kconfig-age.txt")
+	}
+	if _, err := os.Stat(filename); err != nil {
+		return "", fmt.Errorf("The age identity file is needed: %v.  Create it with \"age-keygen -o %s\".", err, filename)
+	}
+	return filename, nil
+}
+
+// keychainKey returns the AES-256 key kept in the OS keychain.  If there isn't one, and create is
+// true, a random one is generated and stored there.
+func keychainKey(create bool) ([]byte, error) {
+	hexKey, err := keychainLookup(keychainService, keychainAccount)
+	if err == nil {
+		return hex.DecodeString(strings.TrimSpace(hexKey))
+	}
+	if !create {
+		return nil, fmt.Errorf("No key for service \"%s\" is in the keychain: %w", keychainService, err)
+	}
+
+	key := make([]byte, 32)
+	_, err = rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("Storing a new key for service \"%s\" in the keychain.", keychainService)
+	err = keychainStore(keychainService, keychainAccount, "kconfig credentials key", hex.EncodeToString(key))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to store a key in the keychain: %w", err)
+	}
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// runFilter runs the command with the input on its standard input, returning its standard output.
+// Its standard error is included in the error if it fails.
+func runFilter(input []byte, commandArgs ...string) ([]byte, error) {
+	cmd := exec.Command(commandArgs[0], commandArgs[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("%s failed: %v: %s", commandArgs[0], err, message)
+		}
+		return nil, fmt.Errorf("%s failed: %v", commandArgs[0], err)
+	}
+	return output, nil
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/jphx/kconfig/common"
+)
+
+// ErrorCode classifies an error, so that scripts and shell functions that wrap kset can react to
+// it without parsing the message meant for people.
+type ErrorCode string
+
+const (
+	// ErrorCodeUnknownNickname says that a nickname, or one it extends, isn't defined.
+	ErrorCodeUnknownNickname ErrorCode = "unknown-nickname"
+	// ErrorCodeAmbiguousNickname says that an abbreviated nickname matches more than one nickname.
+	ErrorCodeAmbiguousNickname ErrorCode = "ambiguous-nickname"
+	// ErrorCodeMissingContext says that the kubectl context to use doesn't exist, or that there
+	// isn't a current context.
+	ErrorCodeMissingContext ErrorCode = "missing-context"
+	// ErrorCodeMissingCluster says that the cluster to use doesn't exist.
+	ErrorCodeMissingCluster ErrorCode = "missing-cluster"
+	// ErrorCodeMissingUser says that the user to use doesn't exist.
+	ErrorCodeMissingUser ErrorCode = "missing-user"
+	// ErrorCodeMissingNamespace says that the namespace to use doesn't exist, or that there's no
+	// previous namespace for "-n -".
+	ErrorCodeMissingNamespace ErrorCode = "missing-namespace"
+	// ErrorCodeAuthFailure says that credentials couldn't be obtained, or that the API server
+	// rejected them.
+	ErrorCodeAuthFailure ErrorCode = "auth-failure"
+	// ErrorCodeRestricted says that the context or cluster isn't allowed by the allowed_* and
+	// denied_* preferences.
+	ErrorCodeRestricted ErrorCode = "restricted"
+	// ErrorCodeUsage says that the command line is wrong.
+	ErrorCodeUsage ErrorCode = "usage"
+	// ErrorCodeOther is used for any other error.
+	ErrorCodeOther ErrorCode = "error"
+)
+
+// ErrorCodeEnvVar names the environment variable that, if it's set to a nonempty value, enables
+// error codes like the --error-codes option does.  It's convenient for the shell functions, which
+// don't take the options common to all kconfig-util subcommands.
+const ErrorCodeEnvVar = "KCONFIG_ERROR_CODES"
+
+// codedError is an error with an error code.
+type codedError struct {
+	code ErrorCode
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// NewCodedError returns an error with the message of the passed error, classified by the error
+// code.
+func NewCodedError(code ErrorCode, err error) error {
+	return &codedError{code: code, err: err}
+}
+
+// codedErrorf is like fmt.Errorf(), but the error is classified by the error code.
+func codedErrorf(code ErrorCode, format string, args ...interface{}) error {
+	return NewCodedError(code, fmt.Errorf(format, args...))
+}
+
+// GetErrorCode returns the code of the error, or of the first error it wraps that has a code.
+// ErrorCodeOther is returned if there isn't one.
+func GetErrorCode(err error) ErrorCode {
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+
+	var requestErr *clusterRequestError
+	if errors.As(err, &requestErr) && requestErr.isAuthFailure() {
+		return ErrorCodeAuthFailure
+	}
+
+	return ErrorCodeOther
+}
+
+// ErrorCodesEnabled says whether error codes are printed with error messages, as asked for by the
+// --error-codes option or the KCONFIG_ERROR_CODES environment variable.
+func ErrorCodesEnabled() bool {
+	return common.CommonOptions.ErrorCodes || os.Getenv(ErrorCodeEnvVar) != ""
+}
+
+// ExitWithError prints the error to standard error and exits the process.  If error codes are
+// enabled, the message is followed by a final line like "_KCONFIG_ERROR=unknown-nickname".
+func ExitWithError(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	if ErrorCodesEnabled() {
+		fmt.Fprintf(os.Stderr, "_KCONFIG_ERROR=%s\n", GetErrorCode(err))
+	}
+	os.Exit(1)
+}
@@ -0,0 +1,187 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// StandaloneConfig returns a self-contained kubectl configuration for the local kubectl config file
+// described by the results:  just its current context, and the cluster and user that it refers to,
+// all named contextName, with the contents of any certificate, key, and token files they name
+// embedded.  Unlike the local file, it doesn't depend on the kubectl config files in the search
+// path, so it can be handed to a CI system, a container, or a colleague.
+func (r *CreateConfigResults) StandaloneConfig(contextName string) (*clientcmdapi.Config, error) {
+	merged := r.MergedConfig()
+	baseContext, exists := merged.Contexts[merged.CurrentContext]
+	if !exists {
+		return nil, codedErrorf(ErrorCodeMissingContext, "Context \"%s\" doesn't exist.", merged.CurrentContext)
+	}
+	baseCluster, exists := merged.Clusters[baseContext.Cluster]
+	if !exists {
+		return nil, codedErrorf(ErrorCodeMissingCluster, "Cluster \"%s\" doesn't exist.", baseContext.Cluster)
+	}
+
+	standalone := clientcmdapi.NewConfig()
+	context := baseContext.DeepCopy()
+	context.LocationOfOrigin = ""
+	cluster := baseCluster.DeepCopy()
+	cluster.LocationOfOrigin = ""
+	context.Cluster = contextName
+	standalone.Clusters[contextName] = cluster
+
+	// A context can name a user that isn't defined, which kubectl allows, so that's left alone.
+	if baseUser, exists := merged.AuthInfos[context.AuthInfo]; exists {
+		user := baseUser.DeepCopy()
+		user.LocationOfOrigin = ""
+		context.AuthInfo = contextName
+		standalone.AuthInfos[contextName] = user
+	}
+
+	standalone.Contexts[contextName] = context
+	standalone.CurrentContext = contextName
+
+	err := flattenConfig(standalone)
+	if err != nil {
+		return nil, err
+	}
+	return standalone, nil
+}
+
+// MakeSelfContained copies the context, clusters, and users that the local kubectl config file
+// refers to from the search path into it, and embeds the contents of the certificate, key, and
+// token files they name, like "kubectl config view --flatten --minify" does, for the
+// --self-contained option.  Their names are kept.  The versions of the embedded files are recorded
+// first, so that a precomputed file can be generated again when one of them, like a token file,
+// is replaced.
+func (r *CreateConfigResults) MakeSelfContained() error {
+	r.SelfContained = true
+	content := r.ConfigContent
+	if _, exists := content.Contexts[content.CurrentContext]; !exists {
+		baseContext, exists := r.BaseConfig.Contexts[content.CurrentContext]
+		if !exists {
+			return codedErrorf(ErrorCodeMissingContext, "Context \"%s\" doesn't exist.", content.CurrentContext)
+		}
+		context := baseContext.DeepCopy()
+		context.LocationOfOrigin = ""
+		content.Contexts[content.CurrentContext] = context
+	}
+
+	for _, context := range content.Contexts {
+		if baseCluster, exists := r.BaseConfig.Clusters[context.Cluster]; exists && content.Clusters[context.Cluster] == nil {
+			cluster := baseCluster.DeepCopy()
+			cluster.LocationOfOrigin = ""
+			content.Clusters[context.Cluster] = cluster
+		}
+		if baseUser, exists := r.BaseConfig.AuthInfos[context.AuthInfo]; exists && content.AuthInfos[context.AuthInfo] == nil {
+			user := baseUser.DeepCopy()
+			user.LocationOfOrigin = ""
+			content.AuthInfos[context.AuthInfo] = user
+		}
+	}
+
+	r.embeddedFiles = getKubeconfigFileStamps(embeddedFilenames(content))
+	return flattenConfig(content)
+}
+
+// embeddedFilenames returns the names of the certificate, key, and token files named by the
+// clusters and users of the kubectl configuration, which flattenConfig() embeds, sorted.
+func embeddedFilenames(kubeconfig *clientcmdapi.Config) []string {
+	var filenames []string
+	for _, cluster := range kubeconfig.Clusters {
+		if cluster.CertificateAuthority != "" {
+			filenames = append(filenames, cluster.CertificateAuthority)
+		}
+	}
+	for _, user := range kubeconfig.AuthInfos {
+		for _, filename := range []string{user.ClientCertificate, user.ClientKey, user.TokenFile} {
+			if filename != "" {
+				filenames = append(filenames, filename)
+			}
+		}
+	}
+	sort.Strings(filenames)
+	return filenames
+}
+
+// flattenConfig embeds the contents of the certificate, key, and token files named by the clusters
+// and users of the kubectl configuration, so it can be used without them.
+func flattenConfig(kubeconfig *clientcmdapi.Config) error {
+	for name, user := range kubeconfig.AuthInfos {
+		if user.TokenFile != "" {
+			token, err := os.ReadFile(user.TokenFile)
+			if err != nil {
+				return fmt.Errorf("Unable to read the token file of user \"%s\": %v", name, err)
+			}
+			user.Token = strings.TrimSpace(string(token))
+			user.TokenFile = ""
+		}
+	}
+
+	err := clientcmdapi.FlattenConfig(kubeconfig)
+	if err != nil {
+		return fmt.Errorf("Unable to embed the files named by the kubectl configuration: %v", err)
+	}
+	return nil
+}
+
+// KubeconfigEnvVar returns the value of the KUBECONFIG environment variable that uses the local
+// kubectl config file with this name:  the file, followed by the search path, unless the file is
+// self-contained.
+func (r *CreateConfigResults) KubeconfigEnvVar(localConfigFilename string) string {
+	if r.SelfContained {
+		return localConfigFilename
+	}
+	return fmt.Sprintf("%s%c%s", localConfigFilename, os.PathListSeparator, r.SearchPath)
+}
+
+// WriteStandaloneConfigFile writes the self-contained kubectl configuration to the named file,
+// readable only by the user, since it holds credentials.  Like WriteLocalKubectlConfigFile(), it
+// refuses to replace one of the kubectl config files in the search path.
+func (r *CreateConfigResults) WriteStandaloneConfigFile(outputFilename string, standalone *clientcmdapi.Config) error {
+	outputFilename, err := checkOutputFilename(outputFilename, r.SearchPath)
+	if err != nil {
+		return err
+	}
+
+	contents, err := clientcmd.Write(*standalone)
+	if err == nil {
+		err = writeFileAtomically(outputFilename, contents, 0600)
+	}
+	if err != nil {
+		return fmt.Errorf("Error writing the kubectl configuration file \"%s\": %v", outputFilename, err)
+	}
+	return nil
+}
+
+// WriteContainerConfigFile writes the self-contained local kubectl config file described by the
+// results to a file named after the nickname in the state directory, for the docker-args command
+// to mount into a container, and returns its path.  The file is replaced each time, and the
+// credentials in it aren't encrypted, since kconfig-util usually isn't available in the container
+// to decrypt them.
+func (r *CreateConfigResults) WriteContainerConfigFile(nickname string) (string, error) {
+	if !r.SelfContained {
+		panic("Call to WriteContainerConfigFile for a local kubectl config file that isn't self-contained")
+	}
+
+	containerDir := getContainerDirectory()
+	err := makeStateDirectory()
+	if err == nil {
+		err = makePrivateDirectory(containerDir)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Unable to create directory \"%s\" for the kubectl config file: %v", containerDir, err)
+	}
+
+	filename := filepath.Join(containerDir, nickname+".yaml")
+	err = r.WriteStandaloneConfigFile(filename, r.ConfigContent)
+	if err != nil {
+		return "", err
+	}
+	return filename, nil
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Feature describes a behavior-changing addition to kconfig that users must opt in to, either with
+// the "features" preference in kconfig.yaml or with the KCONFIG_FEATURES environment variable.
+// This lets new behavior be staged without breaking existing shell setups.
+type Feature struct {
+	Name        string
+	Description string
+}
+
+// The names of the known features.
+const (
+	FeatureKsetStack            = "kset-stack"
+	FeatureSelfContained        = "self-contained"
+	FeatureAlsoContexts         = "also-contexts"
+	FeatureInheritKubeconfigEnv = "inherit-kubeconfig-env"
+)
+
+// KnownFeatures lists the features that can be enabled.  A feature is removed from this list once
+// its behavior becomes the default or is abandoned.
+var KnownFeatures = []Feature{
+	{
+		Name: FeatureKsetStack,
+		Description: "\"kset -\" and koff keep a stack of earlier kset commands, which \"kset -\", " +
+			"\"kset @N\", and \"kconfig-util kstack\" use.  Without it, \"kset -\" toggles between " +
+			"the current and previous kset commands.",
+	},
+	{
+		Name: FeatureSelfContained,
+		Description: "The --self-contained option, in a kset command or nickname definition, writes " +
+			"a session file with everything it needs embedded in it.",
+	},
+	{
+		Name: FeatureAlsoContexts,
+		Description: "The --also option of kset adds the contexts of other nicknames to the session " +
+			"file.",
+	},
+	{
+		Name: FeatureInheritKubeconfigEnv,
+		Description: "The inherit_kubeconfig_env preference makes the KUBECONFIG environment " +
+			"variable part of the default search path.",
+	},
+}
+
+// FeatureEnabled says whether or not the named feature is enabled.  The KCONFIG_FEATURES
+// environment variable takes precedence over the "features" preference.  An error is returned if
+// the name isn't one of KnownFeatures.
+func FeatureEnabled(name string) (bool, error) {
+	return GetKconfig().FeatureEnabled(name)
+}
+
+// GetFeatureSetting says whether or not the named feature is enabled, and where the setting came
+// from:  "environment", "preferences", or "default".
+func GetFeatureSetting(name string) (bool, string, error) {
+	return GetKconfig().GetFeatureSetting(name)
+}
+
+// FeatureEnabled says whether or not the named feature is enabled, using the preferences of this
+// kconfig rather than the one loaded from the default location.
+func (k *Kconfig) FeatureEnabled(name string) (bool, error) {
+	enabled, _, err := k.GetFeatureSetting(name)
+	return enabled, err
+}
+
+// GetFeatureSetting is like the function of the same name, but uses the preferences of this
+// kconfig rather than the one loaded from the default location.
+func (k *Kconfig) GetFeatureSetting(name string) (bool, string, error) {
+	if !isKnownFeature(name) {
+		return false, "", fmt.Errorf("Unknown feature: \"%s\".", name)
+	}
+
+	if enabled, exists := parseFeaturesEnvVar(os.Getenv("KCONFIG_FEATURES"))[name]; exists {
+		return enabled, "environment", nil
+	}
+
+	if enabled, exists := k.Preferences.Features[name]; exists {
+		return enabled, "preferences", nil
+	}
+
+	return false, "default", nil
+}
+
+// requireFeature returns an error if the named feature isn't enabled, describing what was
+// attempted.
+func (k *Kconfig) requireFeature(name string, what string) error {
+	enabled, err := k.FeatureEnabled(name)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return codedErrorf(ErrorCodeUsage, "%s requires the \"%s\" feature, which can be enabled "+
+			"with the \"features\" preference or the KCONFIG_FEATURES environment variable.", what, name)
+	}
+	return nil
+}
+
+// GetUnknownFeatures returns the names of features mentioned in the preferences or the
+// KCONFIG_FEATURES environment variable that aren't known, perhaps because they're meant for a
+// different version of kconfig.
+func GetUnknownFeatures() []string {
+	var unknown []string
+	names := parseFeaturesEnvVar(os.Getenv("KCONFIG_FEATURES"))
+	for name := range GetKconfig().Preferences.Features {
+		names[name] = true
+	}
+	for name := range names {
+		if !isKnownFeature(name) {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// parseFeaturesEnvVar parses the value of the KCONFIG_FEATURES environment variable, which is a
+// comma-separated list of feature names.  A name can be prefixed with a dash to disable the
+// feature, or it can be followed by "=true" or "=false".
+func parseFeaturesEnvVar(value string) map[string]bool {
+	features := make(map[string]bool)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if strings.HasPrefix(entry, "-") {
+			features[entry[1:]] = false
+			continue
+		}
+
+		name, setting, hasSetting := strings.Cut(entry, "=")
+		enabled := true
+		if hasSetting {
+			parsed, err := strconv.ParseBool(setting)
+			if err != nil {
+				logger.Debugf("Ignoring feature \"%s\" in KCONFIG_FEATURES with invalid setting \"%s\"", name, setting)
+				continue
+			}
+			enabled = parsed
+		}
+		features[name] = enabled
+	}
+	return features
+}
+
+func isKnownFeature(name string) bool {
+	for _, feature := range KnownFeatures {
+		if feature.Name == name {
+			return true
+		}
+	}
+	return false
+}
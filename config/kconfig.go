@@ -1,11 +1,14 @@
 package config
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"syscall"
 
 	"github.com/google/shlex"
 	"github.com/jessevdk/go-flags"
@@ -21,13 +24,44 @@ var logger = common.CreateLogger("kconfig")
 
 const kconfigContextName = "kconfig_context"
 
-var kconfigTmpSessionDir = filepath.Join(os.TempDir(), "kconfig", "sessions")
-var kconfigTmpNicknameDir = filepath.Join(os.TempDir(), "kconfig", "nicks")
+// kconfigUserName is the name of the kubectl user that the local kubectl config file defines when
+// the user referenced by the context has to be changed, such as for impersonation, or taken from
+// the file named by the --prefer-file option.
+const kconfigUserName = "kconfig_user"
 
-// Kconfig describes the format of the ~/.kube/kconfig.yaml file.
+// kconfigClusterName is the name of the kubectl cluster that the local kubectl config file defines
+// when the way the API server is reached has to be changed, such as with the --server option, or
+// when the cluster is taken from the file named by the --prefer-file option.
+const kconfigClusterName = "kconfig_cluster"
+
+// Kconfig describes the format of the kconfig.yaml file, usually ~/.kube/kconfig.yaml.
 type Kconfig struct {
+	// APIVersion and Kind optionally identify the format of the file, as KconfigAPIVersion and
+	// KconfigKind.
+	APIVersion string `yaml:"apiVersion,omitempty"`
+	Kind       string `yaml:"kind,omitempty"`
+
 	Preferences KconfigPreferences `yaml:"preferences,omitempty"`
 	Nicknames   map[string]string  `yaml:"nicknames,omitempty"`
+
+	// NicknameEnv maps nicknames to environment variables that kset sets for them, like those
+	// the --env option of their definitions sets, for tools like the Argo CD and Flux CLIs.
+	NicknameEnv map[string]map[string]string `yaml:"nickname_env,omitempty"`
+
+	// NicknameDescriptions maps nicknames to descriptions of what they're for, which are shown
+	// along with them, like in completion, since a name like "dev-e2" doesn't say much.
+	NicknameDescriptions map[string]string `yaml:"nickname_descriptions,omitempty"`
+
+	// kubeconfigs holds the kubectl configurations already read, by search path, so commands that
+	// resolve many nicknames read each search path once.
+	kubeconfigs map[string]*clientcmdapi.Config
+
+	// aliases holds the aliases of the nicknames, once they've been collected.
+	aliases map[string]string
+
+	// noCache says whether the daemon and the cache of kubectl configurations are bypassed, as the
+	// --no-cache option asks for.
+	noCache bool
 }
 
 // KconfigPreferences describes the format of the kconfig.yaml file.
@@ -36,6 +70,27 @@ type KconfigPreferences struct {
 	// nickname definition doesn't explicitly provide one.  If not specified, the default is "kubectl".
 	DefaultKubectl string `yaml:"default_kubectl,omitempty"`
 
+	// AutoKubectlVersion says whether or not kset and "kubectl -k" select the kubectl executable
+	// that matches the minor version of the cluster's API server, like "kubectl-1.27" in the
+	// KubectlBinDirectory, for nicknames whose definitions don't name a kubectl executable.  The
+	// API server's version is cached for a day.  If unspecified, the default is false.
+	AutoKubectlVersion bool `yaml:"auto_kubectl_version,omitempty"`
+
+	// KubectlBinDirectory names the directory that holds the kubectl executables selected by
+	// AutoKubectlVersion, and those installed by "kconfig-util fetch-kubectl" for nickname
+	// definitions to name like "kubectl@1.28".  If unspecified, the default is
+	// "~/.kube/kubectl-bins".
+	KubectlBinDirectory string `yaml:"kubectl_bin_directory,omitempty"`
+
+	// DownloadKubectl says whether or not the kubectl executable selected by AutoKubectlVersion, or
+	// named like "kubectl@1.28", is downloaded into the KubectlBinDirectory if it isn't there.  If
+	// unspecified, the default is false.
+	DownloadKubectl bool `yaml:"download_kubectl,omitempty"`
+
+	// KubectlDownloadURL gives the base URL of the kubectl releases that are downloaded, for
+	// using a mirror.  If unspecified, the default is "https://dl.k8s.io/release".
+	KubectlDownloadURL string `yaml:"kubectl_download_url,omitempty"`
+
 	// ChangePrompt says whether or not the kset subcommand emits shell code to modify the PS1 shell
 	// variable.  If unspecified, the default is true.
 	ChangePrompt *bool `yaml:"change_prompt,omitempty"`
@@ -49,18 +104,204 @@ type KconfigPreferences struct {
 	// is false.
 	AlwaysShowNamespaceInPrompt bool `yaml:"always_show_namespace_in_prompt,omitempty"`
 
+	// AdoptNamespaceChanges says whether or not a namespace change made by another tool to the
+	// session-local kubectl config file, like "kubectl config set-context --current --namespace
+	// foo", is adopted as a namespace override of the kset environment when "kconfig-util prompt"
+	// notices it, so that the environment keeps it when it's refreshed or returned to.  If
+	// unspecified, the default is false.
+	AdoptNamespaceChanges bool `yaml:"adopt_namespace_changes,omitempty"`
+
 	// The default KUBECONFIG environment variable setting to be used.  If not specified, it
 	// defaults to the empty string, which kubectl interprets as "~/.kube/config".
 	BaseKubeconfig string `yaml:"base_kubeconfig,omitempty"`
+
+	// InheritKubeconfigEnv says whether or not the search path the user set in the KUBECONFIG
+	// environment variable before kset is used instead of BaseKubeconfig, so the session-local
+	// kubectl config file is prepended to it rather than replacing it.  If unspecified, the
+	// default is false.
+	InheritKubeconfigEnv bool `yaml:"inherit_kubeconfig_env,omitempty"`
+
+	// DirectoryKconfig says whether or not kset and the kubectl executable look for a ".kconfig"
+	// file in the current directory or its ancestors, naming the nickname (and possibly override
+	// options) to use when no kset environment is in effect.  If unspecified, the default is false.
+	DirectoryKconfig bool `yaml:"directory_kconfig,omitempty"`
+
+	// DefaultNickname names the nickname that a plain kset command uses when no kset environment is
+	// in effect and no ".kconfig" file is found, and that the kubectl executable's -k option uses
+	// when it isn't given a nickname.  If unspecified, a nickname must be given.
+	DefaultNickname string `yaml:"default_nickname,omitempty"`
+
+	// CompleteFromCluster says whether or not shell completion of namespaces asks the cluster for
+	// its namespaces, in addition to those found in the kubectl configuration.  If unspecified, the
+	// default is false.
+	CompleteFromCluster bool `yaml:"complete_from_cluster,omitempty"`
+
+	// ValidateNamespace says whether or not kset asks the cluster whether the namespace given with
+	// the -n option exists, failing if it doesn't, or if the cluster rejects the credentials.  If
+	// the cluster can't be asked, only a warning is printed.  If unspecified, the default is false.
+	ValidateNamespace bool `yaml:"validate_namespace,omitempty"`
+
+	// AbbreviatedNicknames says whether or not nicknames given on the command line can be given in
+	// any case, and abbreviated to any prefix that only one nickname has.  If unspecified, the
+	// default is false.
+	AbbreviatedNicknames bool `yaml:"abbreviated_nicknames,omitempty"`
+
+	// DangerNicknames lists patterns, in the syntax of filepath.Match(), of nicknames that are
+	// tagged as dangerous, in addition to those whose definitions have the --danger option.
+	DangerNicknames []string `yaml:"danger_nicknames,omitempty"`
+
+	// AllowedContexts lists regular expressions, each of which must match an entire name, of the
+	// kubectl contexts that nicknames may resolve to.  If unspecified, any context is allowed.
+	AllowedContexts []string `yaml:"allowed_contexts,omitempty"`
+
+	// DeniedContexts lists regular expressions, each of which must match an entire name, of the
+	// kubectl contexts that nicknames may not resolve to.  It takes precedence over
+	// AllowedContexts.
+	DeniedContexts []string `yaml:"denied_contexts,omitempty"`
+
+	// AllowedClusters lists regular expressions, each of which must match an entire cluster name or
+	// API server URL, of the clusters that nicknames may resolve to.  If unspecified, any cluster
+	// is allowed.
+	AllowedClusters []string `yaml:"allowed_clusters,omitempty"`
+
+	// DeniedClusters lists regular expressions, each of which must match an entire cluster name or
+	// API server URL, of the clusters that nicknames may not resolve to.  It takes precedence over
+	// AllowedClusters.
+	DeniedClusters []string `yaml:"denied_clusters,omitempty"`
+
+	// DangerPromptColor gives the ANSI SGR parameters (e.g., "1;31" for bold red) used to color
+	// the shell prompt prefix of dangerous nicknames.  If unspecified, the default is "1;31".
+	DangerPromptColor string `yaml:"danger_prompt_color,omitempty"`
+
+	// ConfirmMutations says whether or not the kubectl program included with kconfig asks for
+	// confirmation before running commands that change the cluster, like delete or apply, for
+	// dangerous nicknames.  Nicknames whose definitions have the --confirm-mutations option always
+	// require confirmation.  If unspecified, the default is false.
+	ConfirmMutations bool `yaml:"confirm_mutations,omitempty"`
+
+	// AnnounceTarget says whether or not kset prints a one-line summary of the API server,
+	// namespace, and user to standard error when it switches to a dangerous nickname, as a last
+	// check that it's the intended cluster.  If unspecified, the default is false.
+	AnnounceTarget bool `yaml:"announce_target,omitempty"`
+
+	// HelmEnv says whether or not kset sets the HELM_NAMESPACE environment variable to the
+	// namespace of the kset environment, so helm uses the same namespace as kubectl, and koff
+	// unsets it.  If unspecified, the default is true.
+	HelmEnv *bool `yaml:"helm_env,omitempty"`
+
+	// HelmKubeContext says whether or not kset also sets the HELM_KUBECONTEXT environment
+	// variable to the context of the session-local kubectl config file, for helm.  It has no
+	// effect if HelmEnv is false.  If unspecified, the default is false.
+	HelmKubeContext bool `yaml:"helm_kubecontext,omitempty"`
+
+	// AuditLog says whether or not the kubectl program included with kconfig appends a record of
+	// each command it runs to the audit log file.  If unspecified, the default is false.
+	AuditLog bool `yaml:"audit_log,omitempty"`
+
+	// AuditLogFile gives the name of the audit log file.  If unspecified, the default is
+	// "~/.kube/kconfig-audit.jsonl".
+	AuditLogFile string `yaml:"audit_log_file,omitempty"`
+
+	// TmuxIntegration says whether or not kset and koff record the kset environment of each tmux
+	// pane in tmux options, for use in the status line and to re-apply it in new panes of the same
+	// window.  If unspecified, the default is false.
+	TmuxIntegration bool `yaml:"tmux_integration,omitempty"`
+
+	// SetTerminalTitle says whether or not kset sets the title of the terminal window or tab to the
+	// nickname, and koff restores the previous title.  If unspecified, the default is false.
+	SetTerminalTitle bool `yaml:"set_terminal_title,omitempty"`
+
+	// PrewarmCredentials says whether or not kset runs the exec credential plugin of the selected
+	// user once, so any login happens, and any expired credentials are refreshed, before the first
+	// kubectl command.  kset reports when the credentials expire.  If unspecified, the default is
+	// false.
+	PrewarmCredentials bool `yaml:"prewarm_credentials,omitempty"`
+
+	// OnSwitchCommand gives a shell command that kset and koff run after switching the
+	// environment, with details in KCONFIG_* environment variables.  It can be used to show a
+	// desktop notification, update a terminal tab title, or log switches.
+	OnSwitchCommand string `yaml:"on_switch_command,omitempty"`
+
+	// OnSwitchTimeout gives how long the OnSwitchCommand can run before it's stopped, like "5s".
+	// If unspecified, the default is 5 seconds.
+	OnSwitchTimeout string `yaml:"on_switch_timeout,omitempty"`
+
+	// ExecTool gives the command that "kconfig-util exec-tool" runs when the --tool option isn't
+	// given, like "k9s --readonly".  If unspecified, the default is "k9s".
+	ExecTool string `yaml:"exec_tool,omitempty"`
+
+	// EmitConfig says whether or not kset prints the content of the session-local kubectl config
+	// file for the kset shell function to keep open on a file descriptor, instead of writing it to
+	// the state directory, as the --emit-config option does.  If unspecified, the default is false.
+	EmitConfig bool `yaml:"emit_config,omitempty"`
+
+	// EncryptCredentials says how the tokens and client keys of the local kubectl config files
+	// that kconfig creates are encrypted, so they aren't stored in plain text:  "age" or
+	// "keychain".  kubectl gets them from "kconfig-util decrypt-credentials", run as an exec
+	// credential plugin.  If unspecified, they aren't encrypted.
+	EncryptCredentials string `yaml:"encrypt_credentials,omitempty"`
+
+	// AgeIdentityFile names the age identity file used when EncryptCredentials is "age".  If
+	// unspecified, the default is "~/.kube/kconfig-age.txt".
+	AgeIdentityFile string `yaml:"age_identity_file,omitempty"`
+
+	// VaultAddress gives the URL of the HashiCorp Vault server that the --vault-path option of
+	// nickname definitions refers to.  The VAULT_ADDR environment variable takes precedence.
+	VaultAddress string `yaml:"vault_address,omitempty"`
+
+	// VaultCacheTTL gives how long credentials read from Vault are cached when Vault doesn't give
+	// them a lease duration, like "10m".  If unspecified, the default is 10 minutes.
+	VaultCacheTTL string `yaml:"vault_cache_ttl,omitempty"`
+
+	// CacheKubeconfig says whether or not the merged kubectl configuration read from the search
+	// path is cached under ~/.cache/kconfig, to be reused until one of the files in the search path
+	// changes.  This speeds up kset and completion for long search paths.  The --no-cache option
+	// bypasses the cache.  If unspecified, the default is false.
+	CacheKubeconfig bool `yaml:"cache_kubeconfig,omitempty"`
+
+	// StateDir names the directory that holds the local kubectl config files that kconfig creates.
+	// The KCONFIG_STATE_DIR environment variable takes precedence.  If neither is set, the default
+	// is "kconfig" in $XDG_STATE_HOME or $XDG_RUNTIME_DIR, if one is set, or "kconfig-UID" in the
+	// temporary directory.
+	StateDir string `yaml:"state_dir,omitempty"`
+
+	// SessionDir names the directory that holds the session-local kubectl config files that kset
+	// creates, like "~/.kube/kconfig-sessions", so they're on a file system that's shared with VS
+	// Code remote or dev container terminals.  A leading "~" is replaced by the home directory.  If
+	// unspecified, the default is the "sessions" subdirectory of the state directory.
+	SessionDir string `yaml:"session_dir,omitempty"`
+
+	// PathStyle says whether the kubectl program gives the kubectl executable the paths in the
+	// KUBECONFIG environment variable as Windows paths, for running kubectl.exe from WSL:  "auto",
+	// to do so for an executable whose name ends with ".exe" when running in WSL, "windows", to
+	// always do so in WSL, or "wsl", to never do so.  If unspecified, the default is "auto".
+	PathStyle string `yaml:"path_style,omitempty"`
+
+	// Features enables or disables behavior-changing features by name.  See KnownFeatures.  The
+	// KCONFIG_FEATURES environment variable takes precedence over these settings.
+	Features map[string]bool `yaml:"features,omitempty"`
 }
 
 // KconfigOptions describes the options that can appear in the kconfig nickname definition
 type KconfigOptions struct {
-	KubeConfig    string `long:"kubeconfig" value-name:"FILE" description:"Path to the kubectl config file to use.  If not specified, the default is ~/.kube/config."`
-	Context       string `long:"context" value-name:"NAME" description:"The name of the context to use from the kubectl config file.  If not specified, the default context is used."`
-	Namespace     string `short:"n" long:"namespace" value-name:"NAME" description:"The namespace to use.  If not specified, the namespace associated the specified or default context is used."`
-	User          string `long:"user" value-name:"NAME" description:"The user name to use.  If not specified, the user associated the specified or default context is used."`
-	TeleportProxy string `long:"teleport-proxy" value-name:"PROXYHOST" description:"The Teleport host and optionally the port to use with context.  This is used to set the TELEPORT_PROXY environment variable."`
+	KubeConfig    string   `long:"kubeconfig" value-name:"FILE" description:"Path to the kubectl config file to use.  If not specified, the default is ~/.kube/config."`
+	PreferFile    string   `long:"prefer-file" value-name:"FILE" description:"A kubectl config file whose definitions of the context, and of the cluster and user it refers to, are used instead of those with the same names in the files of the search path."`
+	Context       string   `long:"context" value-name:"NAME" description:"The name of the context to use from the kubectl config file.  If not specified, the default context is used."`
+	Namespace     string   `short:"n" long:"namespace" value-name:"NAME" description:"The namespace to use.  If not specified, the namespace associated the specified or default context is used."`
+	User          string   `long:"user" value-name:"NAME" description:"The user name to use.  If not specified, the user associated the specified or default context is used."`
+	Cluster       string   `long:"cluster" value-name:"NAME" description:"The name of the cluster to use from the kubectl config file.  If not specified, the cluster associated the specified or default context is used."`
+	TeleportProxy string   `long:"teleport-proxy" value-name:"PROXYHOST" description:"The Teleport host and optionally the port to use with context.  This is used to set the TELEPORT_PROXY environment variable."`
+	As            string   `long:"as" value-name:"USERNAME" description:"The user to impersonate for Kubernetes operations."`
+	AsGroups      []string `long:"as-group" value-name:"GROUP" description:"A group to impersonate for Kubernetes operations.  This option can be repeated to specify multiple groups."`
+
+	Server                string `long:"server" value-name:"URL" description:"The URL of the Kubernetes API server to use instead of the cluster's, such as a port-forwarded or tunneled address."`
+	CertificateAuthority  string `long:"certificate-authority" value-name:"FILE" description:"Path to a certificate file for the certificate authority that signed the API server's certificate."`
+	InsecureSkipTLSVerify bool   `long:"insecure-skip-tls-verify" description:"Don't check the API server's certificate.  This makes your connections insecure."`
+	TLSServerName         string `long:"tls-server-name" value-name:"NAME" description:"The server name to use to validate the API server's certificate, if it doesn't match the host in the server URL."`
+
+	Set []string `long:"set" value-name:"PATH=VALUE" description:"Set a field of the local kubectl config file that there's no other option for, like \"cluster.tls-server-name=api.internal\".  PATH starts with \"cluster\", \"user\", or \"context\" for the ones the context uses, or names one like \"clusters[NAME]\", and is followed by the field's name in a kubectl config file.  An empty VALUE removes the field.  This option can be repeated."`
+
+	SelfContained bool `long:"self-contained" description:"Copy the context, cluster, and user into the local kubectl config file, with the certificates, keys, and tokens they refer to embedded, so KUBECONFIG names only that file.  This is for tools that don't support a search path in KUBECONFIG."`
 }
 
 func getHomeDirectory() string {
@@ -96,13 +337,54 @@ func GetKconfig() *Kconfig {
 	return cachedKconfig
 }
 
+// ForgetKconfig discards the configuration read by GetKconfig(), so the next call reads the
+// kconfig.yaml file again, such as after it's been changed.
+func ForgetKconfig() {
+	cachedKconfig = nil
+	cachedKconfigError = nil
+}
+
+// ReloadKconfig reads the kconfig.yaml file again, replacing the configuration returned by
+// GetKconfig(), such as for a long-running command that notices the file has changed.  Unlike
+// GetKconfig(), an error is returned instead of exiting, and the previous configuration is kept.
+func ReloadKconfig() (*Kconfig, error) {
+	kconfig, err := readKconfig()
+	if err != nil {
+		return nil, err
+	}
+	cachedKconfig = kconfig
+	cachedKconfigError = nil
+	return kconfig, nil
+}
+
 func readKconfig() (*Kconfig, error) {
+	return loadKconfig(DefaultKconfigFilename(), common.CommonOptions.NoCache)
+}
+
+// LoadKconfig reads and parses the named kconfig.yaml file.  If the file doesn't exist, an empty
+// configuration is returned.  If the kconfig daemon is running, the file is read through it.
+func LoadKconfig(kconfigYamlFilename string) (*Kconfig, error) {
+	return loadKconfig(kconfigYamlFilename, false)
+}
+
+// loadKconfig does the work of LoadKconfig().  With noCache, the file isn't read through the
+// daemon, and neither are the kubectl config files read for the configuration.
+func loadKconfig(kconfigYamlFilename string, noCache bool) (*Kconfig, error) {
 	kconfig := &Kconfig{
 		Nicknames: make(map[string]string),
+		noCache:   noCache,
 	}
 
-	kconfigYamlFilename := filepath.Join(getHomeDirectory(), ".kube", "kconfig.yaml")
-	configFile, err := os.Open(kconfigYamlFilename)
+	var contents []byte
+	var err error
+	if exists, daemonContents, fromDaemon := readFileFromDaemon(kconfigYamlFilename, noCache); fromDaemon {
+		contents = daemonContents
+		if !exists {
+			err = os.ErrNotExist
+		}
+	} else {
+		contents, err = os.ReadFile(kconfigYamlFilename)
+	}
 	if err != nil {
 		if !errors.Is(err, os.ErrNotExist) {
 			return nil, err
@@ -112,11 +394,11 @@ func readKconfig() (*Kconfig, error) {
 
 	} else {
 		// Read and parse the Kconfig file.
-		err := yaml.NewDecoder(configFile).Decode(kconfig)
-		configFile.Close()
+		err := yaml.NewDecoder(bytes.NewReader(contents)).Decode(kconfig)
 		if err != nil {
 			return nil, err
 		}
+		checkKconfigSchema(kconfigYamlFilename, kconfig, contents)
 		//logger.Debugf("Read kconfig.yaml config from file \"%s\".", kconfigYamlFilename)
 
 		if kconfig.Nicknames == nil {
@@ -132,32 +414,55 @@ func readKconfig() (*Kconfig, error) {
 	return kconfig, nil
 }
 
-func lookupKconfigNickname(nickname string) string {
-	kconfig := GetKconfig()
-	defn, exists := kconfig.Nicknames[nickname]
-	if !exists {
-		fmt.Fprintf(os.Stderr, "Nickname \"%s\" is not defined.\n", nickname)
-		os.Exit(1)
-	}
+func (k *Kconfig) lookupNickname(nickname string) (string, bool) {
+	defn, exists := k.Nicknames[nickname]
+	return defn, exists
+}
+
+// nicknameDefinitionOptions describes the options that can appear in a nickname definition.  These
+// are the options that can also be used as overrides on the kset command line, plus those that
+// only make sense in a definition.
+type nicknameDefinitionOptions struct {
+	KconfigOptions
+	Extends string `long:"extends" value-name:"NICKNAME" description:"The nickname whose definition this one is based on.  Options in this definition override those of the other one."`
+	Danger  bool   `long:"danger" description:"Tag the nickname as dangerous, such as one for a production cluster, so the shell prompt is highlighted."`
 
-	return defn
+	OpenShift bool `long:"openshift" description:"Treat the cluster as an OpenShift cluster, whose namespaces are projects, even if the kubectl executable isn't oc."`
+	OCLogin   bool `long:"oc-login" description:"Run \"oc login\" when kset finds that the OpenShift API server rejects the user's token, such as when it has expired."`
+
+	ConfirmMutations bool `long:"confirm-mutations" description:"Require the kubectl program to get confirmation before running commands that change the cluster."`
+	ReadOnly         bool `long:"read-only" description:"Make the kubectl program refuse to run commands that change the cluster."`
+
+	Tags []string `long:"tag" value-name:"TAG" description:"A tag for the nickname, like \"prod\" or \"us-east\", used to select nicknames with the --tag option of other commands.  This option can be repeated."`
+
+	Aliases []string `long:"alias" value-name:"NAME" description:"Another name the nickname can be given by on the command line, such as a shorter one, or one it used to have.  It isn't inherited by nicknames that extend this one.  This option can be repeated."`
+
+	PluginPath string `long:"plugin-path" value-name:"DIRS" description:"Directories, separated like those in PATH, that the kubectl program puts at the start of PATH, so the kubectl executable finds its plugins there first.  Relative directories are relative to the directory of the kubectl executable."`
+
+	OIDCIssuer   string `long:"oidc-issuer" value-name:"URL" description:"Log in to this OpenID Connect provider to get the credentials kubectl uses, instead of using the user from the kubectl config file."`
+	OIDCClientID string `long:"oidc-client-id" value-name:"ID" description:"The OAuth client ID to use when logging in to the OpenID Connect provider."`
+	OIDCScopes   string `long:"oidc-scopes" value-name:"SCOPES" description:"A comma-separated list of the scopes to request when logging in to the OpenID Connect provider.  If not specified, the default is \"openid,offline_access\"."`
+
+	VaultPath     string `long:"vault-path" value-name:"PATH" description:"Read the credentials kubectl uses from this path in HashiCorp Vault, like \"secret/data/k8s/prod\", instead of using the user from the kubectl config file."`
+	KeychainToken string `long:"keychain-token" value-name:"NAME" description:"Use the token stored in the OS keychain under this name with \"kconfig-util secret set\" as the credentials kubectl uses, instead of using the user from the kubectl config file."`
+
+	RequestTimeout string   `long:"request-timeout" value-name:"DURATION" description:"The length of time, like \"30s\", that the kubectl program tells the kubectl executable to wait for a single request to the API server, unless the command line gives one, for clusters that are slow to respond."`
+	CacheDir       string   `long:"cache-dir" value-name:"DIR" description:"The directory that kubectl keeps its discovery and HTTP caches in, given to it with the KUBECACHEDIR environment variable, so a busy cluster's cache can be kept apart."`
+	Env            []string `long:"env" value-name:"NAME=VALUE" description:"An environment variable that kset sets, and the kubectl program sets for the kubectl executable, for settings that kubectl config files can't express, like the client-side rate limits of tools that read KUBECTL_* variables.  This option can be repeated."`
 }
 
-func parseNicknameDefinition(definition string) (*KconfigOptions, string) {
-	kubectlExecutable := GetKconfig().Preferences.DefaultKubectl
-	if kubectlExecutable == "" {
-		kubectlExecutable = "kubectl"
-	}
+// parseNicknameDefinition parses a nickname definition.  It returns the options and the kubectl
+// executable named in the definition, which is an empty string if the definition doesn't name one.
+func parseNicknameDefinition(definition string) (*nicknameDefinitionOptions, string, error) {
+	var kubectlExecutable string
 
 	defnArgs, err := shlex.Split(definition)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing kconfig specification \"%s\": %v\n", definition, err)
-		os.Exit(1)
+		return nil, "", fmt.Errorf("Error parsing kconfig specification \"%s\": %v", definition, err)
 	}
 
 	if len(defnArgs) == 0 {
-		fmt.Fprint(os.Stderr, "The kconfig specification is empty\n")
-		os.Exit(1)
+		return nil, "", fmt.Errorf("The kconfig specification is empty")
 	}
 
 	if len(defnArgs[0]) > 0 && defnArgs[0][0] != '-' {
@@ -165,20 +470,25 @@ func parseNicknameDefinition(definition string) (*KconfigOptions, string) {
 		defnArgs = defnArgs[1:]
 	}
 
-	var kconfigOptions KconfigOptions
-	positionalArgs, err := flags.ParseArgs(&kconfigOptions, defnArgs)
+	var definitionOptions nicknameDefinitionOptions
+	positionalArgs, err := flags.NewParser(&definitionOptions, flags.PassDoubleDash).ParseArgs(defnArgs)
 	if err != nil {
-		os.Exit(1)
+		return nil, "", fmt.Errorf("Error parsing kconfig specification \"%s\": %v", definition, err)
 	}
 
 	if len(positionalArgs) > 0 {
-		fmt.Fprintf(os.Stderr, "The kconfig specification has unrecognized arguments: %s\n", strings.Join(positionalArgs, " "))
-		// In the above, shlex.Join() would be better, but the shlex library doesn't provide that function.
-		os.Exit(1)
+		// In the following, shlex.Join() would be better, but the shlex library doesn't provide that function.
+		return nil, "", fmt.Errorf("The kconfig specification has unrecognized arguments: %s", strings.Join(positionalArgs, " "))
 	}
 
-	logger.Debugf("Parsed kconfig defn.  kubectl executable is \"%s\".  Options are: %#v", kubectlExecutable, kconfigOptions)
-	return &kconfigOptions, kubectlExecutable
+	logger.Debugf("Parsed kconfig defn.  kubectl executable is \"%s\".  Options are: %#v", kubectlExecutable, definitionOptions)
+	return &definitionOptions, kubectlExecutable, nil
+}
+
+// CheckNicknameDefinition returns an error if the nickname definition can't be parsed.
+func CheckNicknameDefinition(definition string) error {
+	_, _, err := parseNicknameDefinition(definition)
+	return err
 }
 
 // CreateConfigResults holds information resulting from a call to CreateLocalKubectlConfigFile(),
@@ -190,6 +500,66 @@ type CreateConfigResults struct {
 	KubectlExecutable    string
 	OverridesDescription string
 	ContextNamespace     string
+
+	// SearchPath is the kubectl config search path that follows the local kubectl config file in
+	// the new KUBECONFIG environment variable.
+	SearchPath string
+
+	// PreferFile is the kubectl config file named by the --prefer-file option, whose definitions
+	// were used instead of those in the search path, or empty if there wasn't one.
+	PreferFile string
+
+	// SelfContained says whether the local kubectl config file doesn't depend on the files in the
+	// search path, because of the --self-contained option, so KUBECONFIG names only that file.
+	SelfContained bool
+
+	// BaseContext is the name of the context in the kubectl config files that the local kubectl
+	// config file is based on.
+	BaseContext string
+
+	// ClusterName is the name of the cluster in the kubectl config files that the context refers
+	// to, possibly changed by the --cluster or --set options.  If options like --server change how
+	// the API server is reached, the local kubectl config file refers to a copy of it with another
+	// name.
+	ClusterName string
+
+	// ConfigContent is the content of the local kubectl config file.
+	ConfigContent *clientcmdapi.Config
+
+	// BaseConfig is the kubectl configuration read from the search path.
+	BaseConfig *clientcmdapi.Config
+
+	// Danger says whether the nickname is tagged as dangerous, such as one for a production
+	// cluster, so that the shell prompt can be highlighted.
+	Danger bool
+
+	// Environment lists the environment variables, like "NAME=VALUE", that kset sets for the
+	// nickname, because of its --env and --cache-dir options.
+	Environment []string
+
+	// OpenShift says whether the cluster is an OpenShift cluster, because the kubectl executable
+	// is oc or the nickname has the --openshift option, so its projects are used as namespaces.
+	OpenShift bool
+
+	// OCLogin says whether kset runs "oc login" when the API server rejects the user's token,
+	// because the nickname has the --oc-login option.
+	OCLogin bool
+
+	// explicitKubectl says whether the nickname's definition names the kubectl executable, so the
+	// auto_kubectl_version preference doesn't apply.
+	explicitKubectl bool
+
+	// kubectlVersion is the version of the kubectl executable named like "kubectl@1.28", if it's
+	// named that way.
+	kubectlVersion string
+
+	// preferences are the preferences of the kconfig configuration the results were resolved
+	// with, which say how the local kubectl config file is written.
+	preferences *KconfigPreferences
+
+	// embeddedFiles describes the versions of the certificate, key, and token files whose
+	// contents were embedded for the --self-contained option.
+	embeddedFiles []kubeconfigFileStamp
 }
 
 // CreateLocalKubectlConfigFile creates or replaces a local kubectl configuration file.  To figure
@@ -197,63 +567,324 @@ type CreateConfigResults struct {
 // To create a session-local file, specify sessionFile as true.  In this case, the file name will be
 // derived from the current KUBECONFIG environment variable, or if one isn't named there, created
 // with a random name.  When creating a non-session-local file, specify kconfigOptions as nil, since
-// overrides are not allowed in that case.  If an error occurs, the process is exited with an error
-// message.  On success, the new value to be used as the KUBECONFIG environment variable is
+// overrides are not allowed in that case.  Any alsoNicknames get contexts of their own in the file,
+// named after them, so tools can switch to them.  If an error occurs, the process is exited with an
+// error message.  On success, the new value to be used as the KUBECONFIG environment variable is
 // returned, as well as the kubectl executable that should be used for this nickname, and a short
 // description of any overrides used (in case the caller want that information for the shell
-// prompt).
-func CreateLocalKubectlConfigFile(nickname string, kconfigOptions *KconfigOptions, sessionFile bool) *CreateConfigResults {
-	if !sessionFile {
-		if kconfigOptions != nil {
-			panic("Call to CreateLocalKubectlConfigFile specified a non-nil KconfigOptions")
+// prompt).  If outputFilename isn't empty, the file is written to that path instead, replacing any
+// existing file, and it's named in the new KUBECONFIG value.  Such a file isn't cleaned up by koff.
+func CreateLocalKubectlConfigFile(nickname string, kconfigOptions *KconfigOptions, alsoNicknames []string, sessionFile bool, outputFilename string) *CreateConfigResults {
+	if !sessionFile && kconfigOptions != nil {
+		panic("Call to CreateLocalKubectlConfigFile specified a non-nil KconfigOptions")
+	}
+
+	if !sessionFile && outputFilename == "" {
+		results, _, err := GetKconfig().PrecomputeNicknameKubectlConfigFile(nickname)
+		exitOnError(err)
+		return results
+	}
+
+	results := ResolveLocalKubectlConfig(nickname, kconfigOptions, alsoNicknames)
+	GetKconfig().selectKubectl(results)
+
+	if outputFilename != "" {
+		exitOnError(WriteLocalKubectlConfigFile(outputFilename, results))
+		return results
+	}
+
+	fileIsEmpty := false
+	localConfigFilename := GetExistingSessionLocalFilename(os.Getenv("KUBECONFIG"))
+
+	parentDir, err := makeSessionDirectory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to create temporary directory \"%s\" for local kubectl config file: %v\n", parentDir, err)
+		os.Exit(1)
+	}
+
+	if localConfigFilename != "" {
+		if err := CheckSessionLocalFile(localConfigFilename); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Not replacing the file named by KUBECONFIG: %v\n", err)
+			localConfigFilename = ""
 		}
-		kconfigOptions = &KconfigOptions{} // So we don't have keep checking for nil
 	}
+	if localConfigFilename == "" {
+		// Must be a session file that we need to create.
+		localConfigFilename = createSessionKubeconfigFile(parentDir)
+		// This actually creates an empty file.  Remember that so we can clean it up if we encounter
+		// a failure before writing the file.
+		fileIsEmpty = true
+	}
+
+	// Create or replace the local kubectl config file.  It's locked while it's written, so
+	// concurrent kset commands in the same shell, or kubectl commands using the same nickname,
+	// write it one at a time.
+	unlock, err := lockFile(localConfigFilename)
+	if err == nil {
+		err = writeKubeconfigFile(localConfigFilename, results)
+		unlock()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating the local kubectl configuration file \"%s\": %v\n", localConfigFilename, err)
+		if fileIsEmpty {
+			RemoveLocalKubectlConfigFile(localConfigFilename)
+		}
+		os.Exit(1)
+	}
+
+	// Record what the session-local file was generated from, so the kubectl program can warn when
+	// it's out of date.  The files for nicknames don't need this, since their names are keyed by
+	// what they're generated from.
+	err = writeKubeconfigSources(localConfigFilename, sourceFiles(results.SearchPath, results.PreferFile))
+	if err != nil {
+		logger.Debugf("Unable to record the sources of \"%s\": %v", localConfigFilename, err)
+	}
+
+	verb := "Replaced"
+	if fileIsEmpty {
+		verb = "Created"
+	}
+	logger.Debugf("%s local config file: %s", verb, localConfigFilename)
+
+	// Work out the new KUBECONFIG environment variable value to use.
+	results.NewKubeconfigEnvVar = results.KubeconfigEnvVar(localConfigFilename)
+	return results
+}
+
+// WriteLocalKubectlConfigFile writes the local kubectl config file described by the results to a
+// path named by the caller, and sets the NewKubeconfigEnvVar field of the results to refer to it.
+// Since the whole file is replaced, the path may not name one of the kubectl config files in the
+// search path.
+func WriteLocalKubectlConfigFile(outputFilename string, results *CreateConfigResults) error {
+	outputFilename, err := checkOutputFilename(outputFilename, results.SearchPath)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(outputFilename), os.ModePerm)
+	if err == nil {
+		err = writeKubeconfigFile(outputFilename, results)
+	}
+	if err != nil {
+		return fmt.Errorf("Error writing the kubectl configuration file \"%s\": %v", outputFilename, err)
+	}
+	logger.Debugf("Wrote local config file: %s", outputFilename)
+
+	results.NewKubeconfigEnvVar = results.KubeconfigEnvVar(outputFilename)
+	return nil
+}
+
+// checkOutputFilename returns the absolute path of a file that a kubectl configuration is to be
+// written to, or an error if it's one of the kubectl config files in the search path, which
+// mustn't be replaced.
+func checkOutputFilename(outputFilename string, searchPath string) (string, error) {
+	outputFilename, err := filepath.Abs(outputFilename)
+	if err != nil {
+		return "", fmt.Errorf("Unable to determine the absolute path of \"%s\": %v", outputFilename, err)
+	}
+
+	for _, searchPathFilename := range filepath.SplitList(searchPath) {
+		absSearchPathFilename, err := filepath.Abs(searchPathFilename)
+		if err == nil && absSearchPathFilename == outputFilename {
+			return "", fmt.Errorf("The output file \"%s\" is in the kubectl config search path and won't be replaced.", outputFilename)
+		}
+	}
+	return outputFilename, nil
+}
+
+// writeKubeconfigFile replaces the named local kubectl config file with the configuration described
+// by the results.  The file is replaced atomically, so a kubectl command reading it never sees a
+// partly written file.
+func writeKubeconfigFile(filename string, results *CreateConfigResults) error {
+	kubeconfig, err := protectCredentials(filename, results.ConfigContent, results.preferences)
+	if err != nil {
+		return err
+	}
+
+	contents, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomically(filename, contents, 0600)
+}
+
+// RemoveLocalKubectlConfigFile removes the named local kubectl config file, such as a
+// session-local file, along with the record of its sources and any encrypted credentials.  It's not
+// an error if the files don't exist.  Its lock file is left in place, like lockFile() leaves it,
+// so a concurrent kset waiting for the lock doesn't end up holding a lock on a removed file.
+func RemoveLocalKubectlConfigFile(filename string) error {
+	err := os.Remove(filename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, suffix := range []string{kubeconfigSourcesSuffix, encryptedCredentialsSuffix} {
+		err = os.Remove(filename + suffix)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
 
-	defn := lookupKconfigNickname(nickname)
-	logger.Debugf("The definition is nickname \"%s\" is: %s", nickname, defn)
+// RemoveNicknameKubectlConfigFile removes the local kubectl config file that the kubectl program's
+// --kconfig option creates for the nickname, if there is one.  It's created again the next time
+// the nickname is used.
+func RemoveNicknameKubectlConfigFile(nickname string) error {
+	return removeNicknameFile(filepath.Join(getNicknameDirectory(), fmt.Sprintf("%s.yaml", nickname)))
+}
 
-	// Parse the nickname's definition
-	nicknameOptions, kubectlExecutable := parseNicknameDefinition(defn)
-	var overrides []string
+// OrigKubeconfigEnvVar names the environment variable in which kset records the value KUBECONFIG
+// had before the first kset in the shell, so koff can restore it.
+const OrigKubeconfigEnvVar = "_KCONFIG_ORIG_KUBECONFIG"
 
-	// We're going to need the current value of the KUBECONFIG environment variable later, so fetch
-	// it before we change it.
-	kubeconfigEnvVar, kubeconfigEnvVarIsSet := os.LookupEnv("KUBECONFIG")
+// inheritedKubeconfig returns the search path the user set in the KUBECONFIG environment variable
+// themselves.  In a kset environment, KUBECONFIG names the session-local kubectl config file, so
+// it's the value KUBECONFIG had before the first kset.  It's empty if there isn't one.
+func (k *Kconfig) inheritedKubeconfig() string {
+	if os.Getenv("_KCONFIG_KSET") != "" {
+		return os.Getenv(OrigKubeconfigEnvVar)
+	}
+	kubeconfigEnvVar := os.Getenv("KUBECONFIG")
+	if existingSessionLocalFilename(k.sessionDirectory(), kubeconfigEnvVar) != "" {
+		return ""
+	}
+	return kubeconfigEnvVar
+}
 
-	// When reading the kube config using ReadKubeConfig(), the library it calls will read and use
-	// the KUBECONFIG env var.  We'd like it to use a "fresh" value that doesn't include any
-	// session-local kubectl config file or a temporary search path that's related to the
-	// session-local file.  We therefore set it here for this process so it gets used during the
-	// parsing.  If there's an override --kubeconfig option, use that.  Otherwise if the nickname
-	// definition has the --kubeconfig option, use that.  Otherwise use an empty value to ask for
-	// the default search path.
-	searchPath := GetKconfig().Preferences.BaseKubeconfig
+// baseSearchPath returns the search path of the kubectl config files that a nickname's
+// configuration is based on, from the override options, the options of the nickname's definition,
+// the KUBECONFIG environment variable with the inherit_kubeconfig_env preference, or the
+// base_kubeconfig preference.  It's empty for the default search path.
+func (k *Kconfig) baseSearchPath(nicknameOptions *KconfigOptions, kconfigOptions *KconfigOptions) string {
+	searchPath := k.Preferences.BaseKubeconfig
+	if k.Preferences.InheritKubeconfigEnv && k.inheritKubeconfigEnvEnabled() {
+		if inherited := k.inheritedKubeconfig(); inherited != "" {
+			searchPath = inherited
+		}
+	}
 	if nicknameOptions.KubeConfig != "" {
 		searchPath = nicknameOptions.KubeConfig
 	}
 	if kconfigOptions.KubeConfig != "" {
 		searchPath = kconfigOptions.KubeConfig
 	}
-	logger.Debugf("Search path for reading config is: %s", searchPath)
-	err := os.Setenv("KUBECONFIG", searchPath)
+	return searchPath
+}
+
+// inheritKubeconfigEnvEnabled says whether or not the inherit_kubeconfig_env preference has any
+// effect, which it only does with the "inherit-kubeconfig-env" feature.
+func (k *Kconfig) inheritKubeconfigEnvEnabled() bool {
+	enabled, _ := k.FeatureEnabled(FeatureInheritKubeconfigEnv)
+	return enabled
+}
+
+// NicknameSearchPath returns the search path of the kubectl config files that the nickname's
+// configuration is based on, with the same precedence that kset uses, but without any override
+// options.  It's empty for the default search path.
+func (k *Kconfig) NicknameSearchPath(nickname string) (string, error) {
+	resolution, err := k.ResolveNickname(nickname)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to update the KUBECONFIG environment variable: %v\n", err)
-		os.Exit(1)
+		return "", err
+	}
+	return k.baseSearchPath(resolution.Options, &KconfigOptions{}), nil
+}
+
+// RemoveNicknameKubectlConfigFiles removes the local kubectl config files that the kubectl
+// program's --kconfig option has created for every nickname, such as after the nickname
+// definitions are changed.  They're created again the next time the nicknames are used.
+func RemoveNicknameKubectlConfigFiles() error {
+	filenames, err := filepath.Glob(filepath.Join(getNicknameDirectory(), "*.yaml"))
+	if err != nil {
+		return err
+	}
+	for _, filename := range filenames {
+		err = removeNicknameFile(filename)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Precomputed files that no nickname's file links to anymore.
+	filenames, err = filepath.Glob(filepath.Join(getPrecomputedDirectory(), "*.yaml"))
+	if err != nil {
+		return err
+	}
+	for _, filename := range filenames {
+		err = removePrecomputedFile(filename)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResolveLocalKubectlConfig works out the content of the local kubectl configuration file for the
+// provided nickname and override options, without writing any file.  Specify kconfigOptions as nil
+// if there are no overrides.  Any alsoNicknames get contexts of their own, as for
+// CreateLocalKubectlConfigFile().  The NewKubeconfigEnvVar field of the result is left empty, since
+// the name of the local file isn't known.  If an error occurs, the process is exited with an error
+// message.
+func ResolveLocalKubectlConfig(nickname string, kconfigOptions *KconfigOptions, alsoNicknames []string) *CreateConfigResults {
+	kconfig := GetKconfig()
+	results, err := kconfig.ResolveLocalKubectlConfig(nickname, kconfigOptions)
+	if err == nil {
+		err = kconfig.addNicknameContexts(results, alsoNicknames)
+	}
+	exitOnError(err)
+	return results
+}
+
+// ResolveLocalKubectlConfig works out the content of the local kubectl configuration file for the
+// provided nickname and override options using this kconfig configuration, without writing any
+// file.  It's like the function of the same name, except that errors are returned.
+func (k *Kconfig) ResolveLocalKubectlConfig(nickname string, kconfigOptions *KconfigOptions) (*CreateConfigResults, error) {
+	if kconfigOptions == nil {
+		kconfigOptions = &KconfigOptions{} // So we don't have keep checking for nil
 	}
 
+	// Resolve the nickname's definition, including any definitions it extends.
+	resolution, err := k.ResolveNickname(nickname)
+	if err != nil {
+		return nil, err
+	}
+	nicknameOptions := resolution.Options
+	kubectlExecutable := resolution.KubectlExecutable
+
+	// Work out the search path of the kubectl config files that establish the configuration we're
+	// working with.  It shouldn't include any session-local kubectl config file, or a temporary
+	// search path that's related to the session-local file, so the KUBECONFIG environment variable
+	// isn't used as is.  If there's an override --kubeconfig option, use that.  Otherwise if the
+	// nickname definition has the --kubeconfig option, use that.  Otherwise use the search path the
+	// user set in KUBECONFIG before kset, if the inherit_kubeconfig_env preference asks for it, or
+	// the base_kubeconfig preference, which is usually empty to ask for the default search path.
+	searchPath := k.baseSearchPath(nicknameOptions, kconfigOptions)
+	logger.Debugf("Search path for reading config is: %s", searchPath)
+
 	// Read the kubectl config information that establishes the configuration we're working with.
-	kubeconfig := ReadKubeConfig()
+	kubeconfig, err := k.readKubeConfigWithCache(searchPath)
+	if err != nil {
+		return nil, err
+	}
 
-	// Restore the KUBECONFIG environment variable, in case it's important to the caller.
-	if !kubeconfigEnvVarIsSet {
-		err = os.Unsetenv("KUBECONFIG")
-	} else {
-		err = os.Setenv("KUBECONFIG", kubeconfigEnvVar)
+	// With the --prefer-file option, the definitions in that file take precedence over those with
+	// the same names in the search path, where the first file to define a name would otherwise win.
+	preferFile := nicknameOptions.PreferFile
+	if kconfigOptions.PreferFile != "" {
+		preferFile = kconfigOptions.PreferFile
 	}
+	var preferred *clientcmdapi.Config
+	if preferFile != "" {
+		preferred, err = readPreferredKubeConfig(preferFile)
+		if err != nil {
+			return nil, err
+		}
+		kubeconfig = preferKubeConfig(kubeconfig, preferred)
+	}
+
+	err = resolution.checkCredentialOptions()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error restoring the KUBECONFIG environment variable: %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("Nickname \"%s\" can't be used: %v", nickname, err)
 	}
 
 	// Figure out what kubectl context we should refer to.
@@ -268,14 +899,12 @@ func CreateLocalKubectlConfigFile(nickname string, kconfigOptions *KconfigOption
 	logger.Debugf("Context after overriding is: %s", baseContext)
 
 	if baseContext == "" {
-		fmt.Fprintf(os.Stderr, "There is no current context in search path: %s\n", searchPath)
-		os.Exit(1)
+		return nil, codedErrorf(ErrorCodeMissingContext, "There is no current context in search path: %s", searchPath)
 	}
 
 	contextDefn, exists := kubeconfig.Contexts[baseContext]
 	if !exists {
-		fmt.Fprintf(os.Stderr, "Context \"%s\" doesn't exist.\n", baseContext)
-		os.Exit(1)
+		return nil, codedErrorf(ErrorCodeMissingContext, "Context \"%s\" doesn't exist.", baseContext)
 	}
 
 	// Keep track of the effective namespace, in case the user always wants to show the namespace
@@ -285,10 +914,26 @@ func CreateLocalKubectlConfigFile(nickname string, kconfigOptions *KconfigOption
 		contextNamespace = "default"
 	}
 
+	// Keep track of the effective cluster, for the allowed_clusters and denied_clusters
+	// preferences, and for the results.
+	clusterName := contextDefn.Cluster
+	if nicknameOptions.Cluster != "" {
+		clusterName = nicknameOptions.Cluster
+	}
+	if kconfigOptions.Cluster != "" {
+		clusterName = kconfigOptions.Cluster
+	}
+
 	// See if our new config file can be a simple "current-context" entry or if it must define
 	// a new context so that namespace or user can be overridden.
 	needNewContext := nicknameOptions.Namespace != "" || nicknameOptions.User != "" ||
-		kconfigOptions.Namespace != "" || kconfigOptions.User != ""
+		kconfigOptions.Namespace != "" || kconfigOptions.User != "" || resolution.OIDC.IsSet() || resolution.KeychainToken != "" || resolution.VaultPath != "" ||
+		nicknameOptions.hasClusterOptions() || kconfigOptions.hasClusterOptions() ||
+		nicknameOptions.Cluster != "" || kconfigOptions.Cluster != "" ||
+		nicknameOptions.As != "" || len(nicknameOptions.AsGroups) > 0 ||
+		kconfigOptions.As != "" || len(kconfigOptions.AsGroups) > 0 ||
+		len(nicknameOptions.Set) > 0 || len(kconfigOptions.Set) > 0 ||
+		preferred != nil
 	logger.Debugf("Need new context?: %v", needNewContext)
 
 	// Create the content for the session-local kubectl config file
@@ -311,89 +956,133 @@ func CreateLocalKubectlConfigFile(nickname string, kconfigOptions *KconfigOption
 		if kconfigOptions.Namespace != "" {
 			newContext.Namespace = kconfigOptions.Namespace
 			contextNamespace = kconfigOptions.Namespace
-			overrides = append(overrides, fmt.Sprintf("ns=%s", kconfigOptions.Namespace))
+		}
+
+		// Set the cluster
+		newContext.Cluster = clusterName
+
+		// Set up any change to how the API server is reached.  The cluster is copied to one that's
+		// defined in the local kubectl config file, with the changes applied.
+		clusterOptions := &KconfigOptions{}
+		clusterOptions.Merge(nicknameOptions)
+		clusterOptions.Merge(kconfigOptions)
+
+		baseCluster, clusterExists := kubeconfig.Clusters[newContext.Cluster]
+		if clusterOptions.Cluster != "" && !clusterExists && clusterOptions.Server == "" {
+			return nil, codedErrorf(ErrorCodeMissingCluster, "Cluster \"%s\" doesn't exist.", newContext.Cluster)
+		}
+		if clusterOptions.hasClusterOptions() {
+			cluster := clientcmdapi.NewCluster()
+			if clusterExists {
+				cluster = baseCluster.DeepCopy()
+				// So our change doesn't get written back to the file where the cluster is defined:
+				cluster.LocationOfOrigin = ""
+			} else if clusterOptions.Server == "" {
+				return nil, codedErrorf(ErrorCodeMissingCluster, "Cluster \"%s\" doesn't exist, and no --server option is given.", newContext.Cluster)
+			}
+
+			err = clusterOptions.applyClusterOptions(cluster)
+			if err != nil {
+				return nil, err
+			}
+			newContext.Cluster = kconfigClusterName
+			newConfigFileContent.Clusters[kconfigClusterName] = cluster
+		} else if preferred != nil && preferred.Clusters[newContext.Cluster] != nil {
+			// The cluster is copied from the preferred file, since kubectl would otherwise use the
+			// first one with the same name in the search path.
+			cluster := preferred.Clusters[newContext.Cluster].DeepCopy()
+			cluster.LocationOfOrigin = ""
+			newContext.Cluster = kconfigClusterName
+			newConfigFileContent.Clusters[kconfigClusterName] = cluster
 		}
 
 		// Set the user
 		if nicknameOptions.User != "" {
 			newContext.AuthInfo = nicknameOptions.User
 		}
+		if resolution.OIDC.IsSet() {
+			newContext.AuthInfo = oidcUserName
+			newConfigFileContent.AuthInfos[oidcUserName] = oidcAuthInfo(resolution.OIDC)
+		}
+		if resolution.KeychainToken != "" {
+			newContext.AuthInfo = keychainTokenUserName
+			newConfigFileContent.AuthInfos[keychainTokenUserName] = keychainTokenAuthInfo(resolution.KeychainToken)
+		}
+		if resolution.VaultPath != "" {
+			newContext.AuthInfo = vaultUserName
+			newConfigFileContent.AuthInfos[vaultUserName] = vaultAuthInfo(resolution.VaultPath)
+		}
 		if kconfigOptions.User != "" {
 			newContext.AuthInfo = kconfigOptions.User
-			overrides = append(overrides, fmt.Sprintf("u=%s", kconfigOptions.User))
+		}
+
+		// Likewise, a user from the preferred file is copied.
+		if preferred != nil && preferred.AuthInfos[newContext.AuthInfo] != nil && newConfigFileContent.AuthInfos[newContext.AuthInfo] == nil {
+			user := preferred.AuthInfos[newContext.AuthInfo].DeepCopy()
+			user.LocationOfOrigin = ""
+			newContext.AuthInfo = kconfigUserName
+			newConfigFileContent.AuthInfos[kconfigUserName] = user
+		}
+
+		// Set up any impersonation.  Impersonation is a property of the kubectl user, so the user
+		// is copied to one that's defined in the local kubectl config file, unless it's already
+		// defined there.
+		impersonate := nicknameOptions.As
+		impersonateGroups := nicknameOptions.AsGroups
+		if kconfigOptions.As != "" {
+			impersonate = kconfigOptions.As
+		}
+		if len(kconfigOptions.AsGroups) > 0 {
+			impersonateGroups = kconfigOptions.AsGroups
+		}
+		if impersonate != "" || len(impersonateGroups) > 0 {
+			user := newConfigFileContent.AuthInfos[newContext.AuthInfo]
+			if user == nil {
+				baseUser, exists := kubeconfig.AuthInfos[newContext.AuthInfo]
+				if !exists {
+					return nil, codedErrorf(ErrorCodeMissingUser, "User \"%s\" doesn't exist, so it can't be used for impersonation.", newContext.AuthInfo)
+				}
+				user = baseUser.DeepCopy()
+				// So our change doesn't get written back to the file where the user is defined:
+				user.LocationOfOrigin = ""
+				newContext.AuthInfo = kconfigUserName
+				newConfigFileContent.AuthInfos[kconfigUserName] = user
+			}
+			user.Impersonate = impersonate
+			user.ImpersonateGroups = impersonateGroups
 		}
 		logger.Debugf("Context after overrides: %#v", newContext)
 
 		// Add it to the config and make it the current context
 		newConfigFileContent.CurrentContext = kconfigContextName
 		newConfigFileContent.Contexts[kconfigContextName] = newContext
-	}
 
-	parentDir := kconfigTmpNicknameDir
-	fileIsEmpty := false
-	localConfigFilename := filepath.Join(kconfigTmpNicknameDir, fmt.Sprintf("%s.yaml", nickname))
-	if sessionFile {
-		parentDir = kconfigTmpSessionDir
-		localConfigFilename = GetExistingSessionLocalFilename(kubeconfigEnvVar)
-	}
-
-	err = os.MkdirAll(parentDir, os.ModePerm)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to create temporary directory \"%s\" for local kubectl config file: %v\n", parentDir, err)
-		os.Exit(1)
-	}
-
-	if localConfigFilename == "" {
-		// Must be a session file that we need to create.
-		localConfigFilename = createSessionKubeconfigFile(parentDir)
-		// This actually creates an empty file.  Remember that so we can clean it up if we encounter
-		// a failure before writing the file.
-		fileIsEmpty = true
-	}
-
-	// Create or replace the current session-local kubectl config file.
-	configAccess := &clientcmd.PathOptions{
-		GlobalFile:   localConfigFilename,
-		EnvVar:       "",
-		LoadingRules: clientcmd.NewDefaultClientConfigLoadingRules(),
-	}
-
-	// Suppress any warning that might result of a missing target file.
-	configAccess.LoadingRules.WarnIfAllMissing = false
-
-	err = clientcmd.ModifyConfig(configAccess, *newConfigFileContent, true)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating the session-local kubectl configuration file \"%s\": %v\n", localConfigFilename, err)
-		if fileIsEmpty {
-			os.Remove(localConfigFilename)
+		// Apply any --set options last, so they can change what the other options did.  Those
+		// of the definition come first, so the overrides take precedence.
+		err = applySetOptions(newConfigFileContent, kubeconfig, append(append([]string{}, nicknameOptions.Set...), kconfigOptions.Set...))
+		if err != nil {
+			return nil, err
+		}
+		if namespace := newConfigFileContent.Contexts[kconfigContextName].Namespace; namespace != "" {
+			contextNamespace = namespace
+		}
+		// They can also point the context at another cluster, which is then the one the
+		// restrictions are checked against.
+		if cluster := newConfigFileContent.Contexts[kconfigContextName].Cluster; cluster != kconfigClusterName {
+			clusterName = cluster
 		}
-		os.Exit(1)
-	}
-
-	verb := "Replaced"
-	if fileIsEmpty {
-		verb = "Created"
 	}
-	logger.Debugf("%s local config file: %s", verb, localConfigFilename)
-
-	// Work out the new KUBECONFIG environment variable value to use.
 
+	// Work out the search path that follows the local kubectl config file in the new KUBECONFIG
+	// environment variable value.
 	if searchPath == "" {
 		homeDir, err := os.UserHomeDir()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Unable to find user's home directory: %v\n", err)
-			if fileIsEmpty {
-				// It isn't empty anymore, but the KUBECONFIG env var doesn't name it, so it's
-				// effectively orphaned.
-				os.Remove(localConfigFilename)
-			}
-			os.Exit(1)
+			return nil, fmt.Errorf("Unable to find user's home directory: %v", err)
 		}
 		searchPath = filepath.Join(homeDir, ".kube", "config")
 	}
 
-	newKubeconfigEnvVar := fmt.Sprintf("%s%c%s", localConfigFilename, os.PathListSeparator, searchPath)
-
 	var teleportProxyEnvVar string
 	if nicknameOptions.TeleportProxy != "" {
 		teleportProxyEnvVar = nicknameOptions.TeleportProxy
@@ -402,12 +1091,50 @@ func CreateLocalKubectlConfigFile(nickname string, kconfigOptions *KconfigOption
 		teleportProxyEnvVar = kconfigOptions.TeleportProxy
 	}
 
-	return &CreateConfigResults{
-		NewKubeconfigEnvVar:  newKubeconfigEnvVar,
+	results := &CreateConfigResults{
 		TeleportProxyEnvVar:  teleportProxyEnvVar,
 		KubectlExecutable:    kubectlExecutable,
-		OverridesDescription: strings.Join(overrides, ","),
+		OverridesDescription: kconfigOptions.OverridesDescription(),
 		ContextNamespace:     contextNamespace,
+		SearchPath:           searchPath,
+		PreferFile:           preferFile,
+		SelfContained:        nicknameOptions.SelfContained || kconfigOptions.SelfContained,
+		BaseContext:          baseContext,
+		ClusterName:          clusterName,
+		ConfigContent:        newConfigFileContent,
+		BaseConfig:           kubeconfig,
+		Danger:               k.IsDangerNickname(nickname, resolution),
+		Environment:          resolution.Environment,
+		OpenShift:            resolution.OpenShift || isOpenShiftExecutable(kubectlExecutable),
+		OCLogin:              resolution.OCLogin,
+		explicitKubectl:      resolution.ExplicitKubectl,
+		kubectlVersion:       resolution.KubectlVersion,
+		preferences:          &k.Preferences,
+	}
+	if results.OCLogin && !results.OpenShift {
+		return nil, fmt.Errorf("Nickname \"%s\" can't be used: The --oc-login option needs the oc executable or the --openshift option.", nickname)
+	}
+	err = k.checkRestrictions(nickname, baseContext, clusterName, results.ServerURL())
+	if err != nil {
+		return nil, err
+	}
+	if results.SelfContained {
+		err = k.requireFeature(FeatureSelfContained, "The --self-contained option")
+		if err == nil {
+			err = results.MakeSelfContained()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// exitOnError prints the error to standard error and exits the process, if there is an error.  See
+// ExitWithError().
+func exitOnError(err error) {
+	if err != nil {
+		ExitWithError(err)
 	}
 }
 
@@ -415,9 +1142,15 @@ func CreateLocalKubectlConfigFile(nickname string, kconfigOptions *KconfigOption
 // value.  If the first entry in the search path refers to a session-local kubectl config file, its
 // name is returned.  Otherwise an empty string is returned.
 func GetExistingSessionLocalFilename(kubeconfigEnvVar string) string {
+	return existingSessionLocalFilename(getSessionDirectory(), kubeconfigEnvVar)
+}
+
+// existingSessionLocalFilename is like GetExistingSessionLocalFilename(), for session-local
+// kubectl config files in the named directory.
+func existingSessionLocalFilename(sessionDir string, kubeconfigEnvVar string) string {
 	//kubeconfigEnvVar := os.Getenv("KUBECONFIG")
 	logger.Debugf("Fetched KUBECONFIG of: %s", kubeconfigEnvVar)
-	if kubeconfigEnvVar == "" || !strings.HasPrefix(kubeconfigEnvVar, kconfigTmpSessionDir) {
+	if kubeconfigEnvVar == "" || !strings.HasPrefix(kubeconfigEnvVar, sessionDir+string(os.PathSeparator)) {
 		logger.Debug("Doesn't contain a session config file name")
 		return ""
 	}
@@ -431,6 +1164,43 @@ func GetExistingSessionLocalFilename(kubeconfigEnvVar string) string {
 	return filename
 }
 
+// sessionLocalFilePattern matches the names that createSessionKubeconfigFile() gives the
+// session-local kubectl config files.
+var sessionLocalFilePattern = regexp.MustCompile(`^[0-9]+\.yaml$`)
+
+// CheckSessionLocalFile returns an error if the file isn't a session-local kubectl config file
+// created by kset:  a regular file owned by the user, directly in the session directory, and named
+// the way kset names them.  This keeps a file that only happens to be under the session directory,
+// like one written by hand, from being removed or replaced.  It's not an error if the file doesn't
+// exist.
+func CheckSessionLocalFile(filename string) error {
+	return checkSessionLocalFileIn(getSessionDirectory(), filename)
+}
+
+// checkSessionLocalFileIn is like CheckSessionLocalFile(), for a session-local kubectl config file
+// in the named directory, which can be the one that held them before the session_dir preference
+// changed.
+func checkSessionLocalFileIn(sessionDir string, filename string) error {
+	if filepath.Dir(filename) != sessionDir || !sessionLocalFilePattern.MatchString(filepath.Base(filename)) {
+		return fmt.Errorf("\"%s\" isn't named like a session-local kubectl config file.", filename)
+	}
+
+	info, err := os.Lstat(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("\"%s\" isn't a regular file.", filename)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("\"%s\" is owned by another user.", filename)
+	}
+	return nil
+}
+
 func createSessionKubeconfigFile(kconfigTmpDir string) string {
 	sessionKubeconfigFile, err := os.CreateTemp(kconfigTmpDir, "*.yaml")
 	if err != nil {
@@ -0,0 +1,462 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// KconfigEditor changes the nicknames in a kconfig.yaml file while it's being edited by
+// EditKconfigFile().  It works on the parsed YAML document rather than on a Kconfig, so the
+// comments and the order of the entries in the file are preserved.
+type KconfigEditor struct {
+	root *yaml.Node
+}
+
+// EditKconfigFile edits the named kconfig.yaml file, creating it if it doesn't exist.  The edit
+// function makes the changes using the editor.  The file is locked from the time it's read until
+// it's replaced, so that concurrent edits, such as from commands run in several terminals, are
+// made one after the other rather than overwriting each other.  If the edit function returns an
+// error, the file isn't changed.  The file is also left alone if the edited file can't be parsed
+// as a kconfig.yaml file.  If the file is a symbolic link, the file it refers to is changed.
+func EditKconfigFile(filename string, edit func(editor *KconfigEditor) error) error {
+	filename, err := resolveKconfigFilename(filename)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(filename), 0700)
+	if err != nil {
+		return fmt.Errorf("Unable to create the directory for \"%s\": %v", filename, err)
+	}
+
+	unlock, err := lockFile(filename)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	perm := os.FileMode(0600)
+	var document yaml.Node
+	contents, err := os.ReadFile(filename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("Unable to read \"%s\": %v", filename, err)
+	}
+	if err == nil {
+		info, err := os.Stat(filename)
+		if err == nil {
+			perm = info.Mode().Perm()
+		}
+		err = yaml.Unmarshal(contents, &document)
+		if err != nil {
+			return fmt.Errorf("Unable to parse \"%s\": %v", filename, err)
+		}
+	}
+
+	// An empty file has no document node.  A new file starts with the header that identifies its
+	// format.
+	newFile := document.Kind == 0
+	if newFile {
+		document = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("The file \"%s\" doesn't hold a YAML mapping.", filename)
+	}
+	editor := &KconfigEditor{root: root}
+	if newFile {
+		editor.setHeader("apiVersion", KconfigAPIVersion)
+		editor.setHeader("kind", KconfigKind)
+	}
+
+	err = edit(editor)
+	if err != nil {
+		return err
+	}
+
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	err = encoder.Encode(&document)
+	if err == nil {
+		err = encoder.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to format the edited \"%s\": %v", filename, err)
+	}
+
+	// Make sure the result can still be read.
+	err = yaml.Unmarshal(buffer.Bytes(), &Kconfig{})
+	if err != nil {
+		return fmt.Errorf("The edited \"%s\" can't be parsed, so it's not written: %v", filename, err)
+	}
+
+	err = writeFileAtomically(filename, buffer.Bytes(), perm)
+	if err != nil {
+		return fmt.Errorf("Unable to write \"%s\": %v", filename, err)
+	}
+	logger.Debugf("Wrote the edited kconfig file: %s", filename)
+	return nil
+}
+
+// ValidateKconfig checks the contents of a kconfig.yaml file, returning the problems found:  YAML
+// that can't be parsed, entries that don't mean anything, like misspelled preference names,
+// invalid patterns in the preferences, and nicknames whose definitions can't be resolved.
+func ValidateKconfig(contents []byte) []string {
+	kconfig := &Kconfig{}
+	err := yaml.Unmarshal(contents, kconfig)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	problems := kconfigSchemaProblems(kconfig, contents)
+
+	for _, preference := range []struct {
+		name     string
+		patterns []string
+	}{
+		{"allowed_contexts", kconfig.Preferences.AllowedContexts},
+		{"denied_contexts", kconfig.Preferences.DeniedContexts},
+		{"allowed_clusters", kconfig.Preferences.AllowedClusters},
+		{"denied_clusters", kconfig.Preferences.DeniedClusters},
+	} {
+		if _, err := matchPatterns(preference.patterns, nil); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid pattern in the %s preference: %v", preference.name, err))
+		}
+	}
+	for _, pattern := range kconfig.Preferences.DangerNicknames {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid pattern \"%s\" in the danger_nicknames preference: %v", pattern, err))
+		}
+	}
+
+	_, aliasProblems := kconfig.collectAliases()
+	problems = append(problems, aliasProblems...)
+
+	nicknames := make([]string, 0, len(kconfig.Nicknames))
+	for nickname := range kconfig.Nicknames {
+		nicknames = append(nicknames, nickname)
+	}
+	sort.Strings(nicknames)
+	for _, nickname := range nicknames {
+		resolution, err := kconfig.ResolveNickname(nickname)
+		if err == nil {
+			err = resolution.checkCredentialOptions()
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("nickname \"%s\": %v", nickname, err))
+		}
+	}
+	return problems
+}
+
+// ReplaceKconfigFile replaces the named kconfig.yaml file with the contents, keeping its
+// permissions.  So that changes made by others in the meantime aren't lost, it's an error if the
+// file no longer has the original contents, which are nil if it didn't exist.  If the file is a
+// symbolic link, the file it refers to is replaced.
+func ReplaceKconfigFile(filename string, original []byte, contents []byte) error {
+	filename, err := resolveKconfigFilename(filename)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(filename), 0700)
+	if err != nil {
+		return fmt.Errorf("Unable to create the directory for \"%s\": %v", filename, err)
+	}
+
+	unlock, err := lockFile(filename)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := os.ReadFile(filename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("Unable to read \"%s\": %v", filename, err)
+	}
+	if !bytes.Equal(current, original) || (err == nil) != (original != nil) {
+		return fmt.Errorf("The file \"%s\" was changed by something else while it was being edited.", filename)
+	}
+
+	perm := os.FileMode(0600)
+	if info, err := os.Stat(filename); err == nil {
+		perm = info.Mode().Perm()
+	}
+	err = writeFileAtomically(filename, contents, perm)
+	if err != nil {
+		return fmt.Errorf("Unable to write \"%s\": %v", filename, err)
+	}
+	logger.Debugf("Replaced the kconfig file: %s", filename)
+	return nil
+}
+
+// resolveKconfigFilename returns the name of the file that the named kconfig.yaml file refers to,
+// following any symbolic links, such as one into a repository of dotfiles.  The file is replaced by
+// renaming a new one over it, which would otherwise replace the link rather than the file.  A link
+// to a file that doesn't exist yet resolves to that file.
+func resolveKconfigFilename(filename string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(filename)
+	if err == nil {
+		return resolved, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("Unable to resolve \"%s\": %v", filename, err)
+	}
+
+	target, err := os.Readlink(filename)
+	if err != nil {
+		// It isn't a link, so it's a file that doesn't exist yet.
+		return filename, nil
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(filename), target)
+	}
+	return resolveKconfigFilename(target)
+}
+
+// Nickname returns the definition of the nickname, and whether it's defined.
+func (e *KconfigEditor) Nickname(nickname string) (string, bool) {
+	nicknames := e.nicknamesNode(false)
+	if nicknames == nil {
+		return "", false
+	}
+	_, value := findMappingEntry(nicknames, nickname)
+	if value == nil {
+		return "", false
+	}
+	return value.Value, true
+}
+
+// SetNickname defines the nickname, replacing any existing definition in place.  A new nickname is
+// added after the others.
+func (e *KconfigEditor) SetNickname(nickname string, definition string) error {
+	nicknames := e.nicknamesNode(true)
+	if nicknames == nil {
+		return fmt.Errorf("The \"nicknames\" entry isn't a mapping.")
+	}
+	_, value := findMappingEntry(nicknames, nickname)
+	if value != nil {
+		value.Kind = yaml.ScalarNode
+		value.Tag = "!!str"
+		value.Value = definition
+		value.Content = nil
+		return nil
+	}
+
+	nicknames.Content = append(nicknames.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: nickname},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: definition})
+	return nil
+}
+
+// RemoveNickname removes the definition of the nickname.  It returns false if the nickname wasn't
+// defined.
+func (e *KconfigEditor) RemoveNickname(nickname string) bool {
+	nicknames := e.nicknamesNode(false)
+	if nicknames == nil {
+		return false
+	}
+	idx, _ := findMappingEntry(nicknames, nickname)
+	if idx < 0 {
+		return false
+	}
+	nicknames.Content = append(nicknames.Content[:idx], nicknames.Content[idx+2:]...)
+
+	for _, block := range e.nicknameBlockNodes() {
+		if idx, _ := findMappingEntry(block, nickname); idx >= 0 {
+			block.Content = append(block.Content[:idx], block.Content[idx+2:]...)
+		}
+	}
+	return true
+}
+
+// CommentOutNickname removes the definition of the nickname, leaving it in a comment in its place,
+// so it can easily be restored.  It returns false if the nickname wasn't defined.
+func (e *KconfigEditor) CommentOutNickname(nickname string) bool {
+	nicknames := e.nicknamesNode(false)
+	if nicknames == nil {
+		return false
+	}
+	idx, value := findMappingEntry(nicknames, nickname)
+	if idx < 0 {
+		return false
+	}
+
+	// The comments of the entry are kept along with it.
+	line := "# " + nickname + ": " + value.Value
+	if value.LineComment != "" {
+		line += " " + value.LineComment
+	}
+	comment := joinComments(joinComments(nicknames.Content[idx].HeadComment, line), value.FootComment)
+	switch {
+	case idx+2 < len(nicknames.Content):
+		next := nicknames.Content[idx+2]
+		next.HeadComment = joinComments(comment, next.HeadComment)
+	case idx > 0:
+		previous := nicknames.Content[idx-1]
+		previous.FootComment = joinComments(previous.FootComment, comment)
+	default:
+		nicknames.FootComment = joinComments(nicknames.FootComment, comment)
+	}
+	nicknames.Content = append(nicknames.Content[:idx], nicknames.Content[idx+2:]...)
+	return true
+}
+
+// joinComments joins the comments of a YAML node, one after the other, skipping empty ones.
+func joinComments(first string, second string) string {
+	if first == "" {
+		return second
+	}
+	if second == "" {
+		return first
+	}
+	return first + "\n" + second
+}
+
+// RenameNickname renames the nickname, keeping its definition in place, along with its entries in
+// the nickname_env and nickname_descriptions blocks.  Any definitions that extend the nickname with
+// the --extends option are changed to extend the new name, and their nicknames are returned,
+// sorted.  It's an error if the old nickname isn't defined or the new one already is.
+func (e *KconfigEditor) RenameNickname(oldNickname string, newNickname string) ([]string, error) {
+	nicknames := e.nicknamesNode(false)
+	idx := -1
+	if nicknames != nil {
+		idx, _ = findMappingEntry(nicknames, oldNickname)
+	}
+	if idx < 0 {
+		return nil, fmt.Errorf("Nickname \"%s\" is not defined.", oldNickname)
+	}
+	if other, _ := findMappingEntry(nicknames, newNickname); other >= 0 {
+		return nil, fmt.Errorf("Nickname \"%s\" is already defined.", newNickname)
+	}
+	nicknames.Content[idx].Value = newNickname
+	for _, block := range e.nicknameBlockNodes() {
+		if idx, _ := findMappingEntry(block, oldNickname); idx >= 0 {
+			block.Content[idx].Value = newNickname
+		}
+	}
+
+	var extending []string
+	for idx := 0; idx+1 < len(nicknames.Content); idx += 2 {
+		value := nicknames.Content[idx+1]
+		if value.Kind != yaml.ScalarNode {
+			continue
+		}
+		definition, changed := renameExtendedNickname(value.Value, oldNickname, newNickname)
+		if changed {
+			value.Value = definition
+			value.Style = 0
+			extending = append(extending, nicknames.Content[idx].Value)
+		}
+	}
+	sort.Strings(extending)
+	return extending, nil
+}
+
+// RenameNicknameInPreferences changes the preferences that name the nickname to name the new one
+// instead, and returns the names of those that were changed:  the default_nickname preference, if
+// it's the nickname, and the danger_nicknames preference, if one of its patterns is exactly the
+// nickname.  Patterns with wildcards are left alone.
+func (e *KconfigEditor) RenameNicknameInPreferences(oldNickname string, newNickname string) []string {
+	_, preferences := findMappingEntry(e.root, "preferences")
+	if preferences == nil || preferences.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	var changed []string
+	if _, value := findMappingEntry(preferences, "default_nickname"); value != nil && value.Kind == yaml.ScalarNode && value.Value == oldNickname {
+		value.Value = newNickname
+		changed = append(changed, "default_nickname")
+	}
+	if _, patterns := findMappingEntry(preferences, "danger_nicknames"); patterns != nil && patterns.Kind == yaml.SequenceNode {
+		renamed := false
+		for _, pattern := range patterns.Content {
+			if pattern.Kind == yaml.ScalarNode && pattern.Value == oldNickname {
+				pattern.Value = newNickname
+				renamed = true
+			}
+		}
+		if renamed {
+			changed = append(changed, "danger_nicknames")
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// RemapContext changes the definitions that select the context named oldContext with the --context
+// option to select newContext instead, and returns their nicknames, sorted.
+func (e *KconfigEditor) RemapContext(oldContext string, newContext string) []string {
+	nicknames := e.nicknamesNode(false)
+	if nicknames == nil {
+		return nil
+	}
+
+	var changed []string
+	for idx := 0; idx+1 < len(nicknames.Content); idx += 2 {
+		value := nicknames.Content[idx+1]
+		if value.Kind != yaml.ScalarNode {
+			continue
+		}
+		definition, replaced := replaceOptionValue(value.Value, "--context", oldContext, newContext)
+		if replaced {
+			value.Value = definition
+			value.Style = 0
+			changed = append(changed, nicknames.Content[idx].Value)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// nicknamesNode returns the mapping node of the "nicknames" entry.  If there isn't one, it's added
+// when create is true, and otherwise nil is returned.
+func (e *KconfigEditor) nicknamesNode(create bool) *yaml.Node {
+	_, nicknames := findMappingEntry(e.root, "nicknames")
+	if nicknames == nil {
+		if !create {
+			return nil
+		}
+		nicknames = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		e.root.Content = append(e.root.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "nicknames"}, nicknames)
+	}
+
+	// An entry with no value, like "nicknames:", is a null scalar.
+	if nicknames.Kind == yaml.ScalarNode && nicknames.Tag == "!!null" && create {
+		nicknames.Kind = yaml.MappingNode
+		nicknames.Tag = "!!map"
+		nicknames.Value = ""
+		nicknames.Style = 0
+	}
+	if nicknames.Kind != yaml.MappingNode {
+		return nil
+	}
+	return nicknames
+}
+
+// nicknameBlockNodes returns the mapping nodes of the entries besides "nicknames" that are keyed by
+// nickname, "nickname_env" and "nickname_descriptions", that are present.
+func (e *KconfigEditor) nicknameBlockNodes() []*yaml.Node {
+	var blocks []*yaml.Node
+	for _, key := range []string{"nickname_env", "nickname_descriptions"} {
+		if _, block := findMappingEntry(e.root, key); block != nil && block.Kind == yaml.MappingNode {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+// findMappingEntry returns the index of the key node of the entry with the key in the mapping
+// node, along with its value node.  If there's no such entry, it returns -1 and nil.
+func findMappingEntry(mapping *yaml.Node, key string) (int, *yaml.Node) {
+	for idx := 0; idx+1 < len(mapping.Content); idx += 2 {
+		if mapping.Content[idx].Value == key {
+			return idx, mapping.Content[idx+1]
+		}
+	}
+	return -1, nil
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// secretKeychainService is the service of the secrets managed by "kconfig-util secret", like the
+// tokens named by the --keychain-token option, in the OS keychain.
+const secretKeychainService = "kconfig-secret"
+
+// keychainTokenUserName is the name of the kubectl user that the local kubectl config file defines
+// for a nickname with the --keychain-token option.
+const keychainTokenUserName = "kconfig_keychain_user"
+
+// keychainTokenAuthInfo returns the kubectl user that runs "kconfig-util keychain-token" as an exec
+// credential plugin to get the token kept in the OS keychain under the name.
+func keychainTokenAuthInfo(name string) *clientcmdapi.AuthInfo {
+	authInfo := clientcmdapi.NewAuthInfo()
+	authInfo.Exec = &clientcmdapi.ExecConfig{
+		APIVersion:      "client.authentication.k8s.io/v1",
+		Command:         "kconfig-util",
+		Args:            []string{"keychain-token", name},
+		InstallHint:     "kconfig-util is installed with kconfig.  Make sure it's on your PATH.",
+		InteractiveMode: clientcmdapi.NeverExecInteractiveMode,
+	}
+	return authInfo
+}
+
+// GetSecret returns the secret kept in the OS keychain under the name by "kconfig-util secret
+// set".
+func GetSecret(name string) (string, error) {
+	value, err := keychainLookup(secretKeychainService, name)
+	if err != nil {
+		return "", fmt.Errorf("Unable to get secret \"%s\" from the keychain: %w", name, err)
+	}
+	return value, nil
+}
+
+// SetSecret keeps the secret in the OS keychain under the name, replacing any secret already kept
+// under it.
+func SetSecret(name string, value string) error {
+	err := keychainStore(secretKeychainService, name, fmt.Sprintf("kconfig secret %s", name), value)
+	if err != nil {
+		return fmt.Errorf("Unable to store secret \"%s\" in the keychain: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteSecret removes the secret kept in the OS keychain under the name.
+func DeleteSecret(name string) error {
+	var err error
+	if runtime.GOOS == "darwin" {
+		_, err = runFilter(nil, "security", "delete-generic-password", "-s", secretKeychainService, "-a", name)
+	} else {
+		_, err = runFilter(nil, "secret-tool", "clear", "service", secretKeychainService, "account", name)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to remove secret \"%s\" from the keychain: %w", name, err)
+	}
+	return nil
+}
+
+// keychainLookup returns the password of the OS keychain item with the service and account:  a
+// generic password in the login keychain on macOS, and an item of the Secret Service, through
+// secret-tool, elsewhere.  It's an error if there isn't one.
+func keychainLookup(service string, account string) (string, error) {
+	lookupArgs := []string{"secret-tool", "lookup", "service", service, "account", account}
+	if runtime.GOOS == "darwin" {
+		lookupArgs = []string{"security", "find-generic-password", "-s", service, "-a", account, "-w"}
+	}
+
+	output, err := runFilter(nil, lookupArgs...)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSuffix(string(output), "\n")
+	if value == "" {
+		return "", fmt.Errorf("No item for account \"%s\" of service \"%s\" is in the keychain.", account, service)
+	}
+	return value, nil
+}
+
+// keychainStore creates or replaces the OS keychain item with the service and account.
+func keychainStore(service string, account string, label string, value string) error {
+	var err error
+	if runtime.GOOS == "darwin" {
+		// The security command only reads the password from its arguments or the terminal.
+		_, err = runFilter(nil, "security", "add-generic-password", "-U", "-s", service, "-a", account, "-l", label, "-w", value)
+	} else {
+		_, err = runFilter([]byte(value), "secret-tool", "store", "--label", label, "service", service, "account", account)
+	}
+	return err
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 
 	"k8s.io/client-go/tools/clientcmd"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
@@ -11,18 +13,197 @@ import (
 // ReadKubeConfig reads the current kubectl configuration using the default search path, possibly as
 // modified by the current KUBECONFIG env var value.
 func ReadKubeConfig() *clientcmdapi.Config {
-	configAccess := clientcmd.NewDefaultPathOptions()
-	config, err := configAccess.GetStartingConfig()
+	config, err := readKubeConfig()
+	exitOnError(err)
+	return config
+}
+
+// readKubeConfig is like ReadKubeConfig(), except that errors are returned.
+func readKubeConfig() (*clientcmdapi.Config, error) {
+	return readKubeConfigFromSearchPath(os.Getenv("KUBECONFIG"))
+}
+
+// readKubeConfigFromSearchPath reads the kubectl configuration from the files in the search path,
+// which has the format of the KUBECONFIG environment variable.  An empty search path means
+// ~/.kube/config.
+func readKubeConfigFromSearchPath(searchPath string) (*clientcmdapi.Config, error) {
+	config, err := newLoadingRules(searchPath).Load()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading kubectl config file(s): %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("Error reading kubectl config file(s): %v", err)
 	}
 
-	//fmt.Printf("There are %d contexts\n", len(config.Contexts))
-	////for name, _ := range config.Contexts {
-	////	fmt.Println(name)
-	////}
-	//fmt.Printf("The active context is \"%s\"\n", config.CurrentContext)
+	return config, nil
+}
 
-	return config
+// readPreferredKubeConfig reads the kubectl config file named by the --prefer-file option.  Unlike
+// the files of a search path, it's an error if it doesn't exist.
+func readPreferredKubeConfig(filename string) (*clientcmdapi.Config, error) {
+	config, err := (&clientcmd.ClientConfigLoadingRules{ExplicitPath: filename}).Load()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading kubectl config file \"%s\": %v", filename, err)
+	}
+
+	return config, nil
+}
+
+// preferKubeConfig returns a copy of the merged kubectl configuration in which the contexts,
+// clusters, and users defined in the preferred configuration replace those with the same names.
+// The merged configuration isn't changed, since it might be cached.
+func preferKubeConfig(merged *clientcmdapi.Config, preferred *clientcmdapi.Config) *clientcmdapi.Config {
+	result := *merged
+	result.Contexts = make(map[string]*clientcmdapi.Context, len(merged.Contexts))
+	for name, context := range merged.Contexts {
+		result.Contexts[name] = context
+	}
+	for name, context := range preferred.Contexts {
+		result.Contexts[name] = context
+	}
+	result.Clusters = make(map[string]*clientcmdapi.Cluster, len(merged.Clusters))
+	for name, cluster := range merged.Clusters {
+		result.Clusters[name] = cluster
+	}
+	for name, cluster := range preferred.Clusters {
+		result.Clusters[name] = cluster
+	}
+	result.AuthInfos = make(map[string]*clientcmdapi.AuthInfo, len(merged.AuthInfos))
+	for name, user := range merged.AuthInfos {
+		result.AuthInfos[name] = user
+	}
+	for name, user := range preferred.AuthInfos {
+		result.AuthInfos[name] = user
+	}
+	return &result
+}
+
+// newLoadingRules returns the clientcmd rules for loading the files in the search path, which has
+// the format of the KUBECONFIG environment variable.  An empty search path means ~/.kube/config.
+// Unlike clientcmd.NewDefaultClientConfigLoadingRules(), this doesn't look at the KUBECONFIG
+// environment variable of the process, so a search path can be read without changing it.  Like
+// that function, it only warns that none of the files exist for an explicit search path, not for
+// a missing ~/.kube/config.
+func newLoadingRules(searchPath string) *clientcmd.ClientConfigLoadingRules {
+	var precedence []string
+	seen := map[string]bool{}
+	for _, filename := range filepath.SplitList(searchPath) {
+		if filename != "" && !seen[filename] {
+			seen[filename] = true
+			precedence = append(precedence, filename)
+		}
+	}
+	explicit := len(precedence) > 0
+	if !explicit {
+		precedence = []string{clientcmd.RecommendedHomeFile}
+	}
+
+	return &clientcmd.ClientConfigLoadingRules{
+		Precedence:       precedence,
+		WarnIfAllMissing: explicit,
+	}
+}
+
+// CurrentNamespace returns the namespace of the current context of the kubectl configuration in the
+// files in the search path, which has the format of the KUBECONFIG environment variable.  It's
+// "default" if the context doesn't name one.  Unlike the namespace kset configured, it reflects any
+// change made since by another tool, like "kubectl config set-context --current --namespace foo".
+func CurrentNamespace(searchPath string) (string, error) {
+	config, err := readKubeConfigFromSearchPath(searchPath)
+	if err != nil {
+		return "", err
+	}
+
+	context, exists := config.Contexts[config.CurrentContext]
+	if !exists {
+		return "", codedErrorf(ErrorCodeMissingContext, "Context \"%s\" doesn't exist.", config.CurrentContext)
+	}
+	if context.Namespace == "" {
+		return "default", nil
+	}
+	return context.Namespace, nil
+}
+
+// KubeconfigContext describes a context that kubectl sees after merging the kubectl config files
+// of a search path.
+type KubeconfigContext struct {
+	Name      string
+	Cluster   string
+	Namespace string
+	User      string
+
+	// File is the kubectl config file whose definition of the context is used.
+	File string
+
+	// ShadowedFiles are the files later in the search path that define a context with the same
+	// name.  kubectl ignores their definitions, since the first file to define a name wins.
+	ShadowedFiles []string
+}
+
+// ListKubeconfigContexts returns the contexts that kubectl sees after merging the kubectl config
+// files in the search path, which has the format of the KUBECONFIG environment variable, sorted by
+// name.  The name of the current context is returned too.
+func ListKubeconfigContexts(searchPath string) ([]*KubeconfigContext, string, error) {
+	merged, err := readKubeConfigFromSearchPath(searchPath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	contexts := make([]*KubeconfigContext, 0, len(merged.Contexts))
+	byName := make(map[string]*KubeconfigContext, len(merged.Contexts))
+	for name, context := range merged.Contexts {
+		info := &KubeconfigContext{
+			Name:      name,
+			Cluster:   context.Cluster,
+			Namespace: context.Namespace,
+			User:      context.AuthInfo,
+			File:      context.LocationOfOrigin,
+		}
+		contexts = append(contexts, info)
+		byName[name] = info
+	}
+	sort.Slice(contexts, func(i, j int) bool { return contexts[i].Name < contexts[j].Name })
+
+	// Each file is read by itself to find the definitions that the merge hid.  The merge already
+	// read them successfully, so a file that can't be read now is skipped.
+	for _, filename := range newLoadingRules(searchPath).Precedence {
+		fileConfig, err := clientcmd.LoadFromFile(filename)
+		if err != nil {
+			continue
+		}
+		for name := range fileConfig.Contexts {
+			if info, exists := byName[name]; exists && info.File != filename {
+				info.ShadowedFiles = append(info.ShadowedFiles, filename)
+			}
+		}
+	}
+
+	return contexts, merged.CurrentContext, nil
+}
+
+// RenameKubeconfigContext renames a context in the kubectl config file, like "kubectl config
+// rename-context" does, changing its current context too if it's the old one.  It's an error if
+// the file doesn't define the old context, or already defines the new one.
+func RenameKubeconfigContext(filename string, oldContext string, newContext string) error {
+	kubeconfig, err := clientcmd.LoadFromFile(filename)
+	if err != nil {
+		return fmt.Errorf("Unable to read the kubectl config file \"%s\": %v", filename, err)
+	}
+
+	context, exists := kubeconfig.Contexts[oldContext]
+	if !exists {
+		return codedErrorf(ErrorCodeMissingContext, "Context \"%s\" doesn't exist in \"%s\".", oldContext, filename)
+	}
+	if _, exists := kubeconfig.Contexts[newContext]; exists {
+		return fmt.Errorf("Context \"%s\" already exists in \"%s\".", newContext, filename)
+	}
+
+	delete(kubeconfig.Contexts, oldContext)
+	kubeconfig.Contexts[newContext] = context
+	if kubeconfig.CurrentContext == oldContext {
+		kubeconfig.CurrentContext = newContext
+	}
+
+	err = clientcmd.WriteToFile(*kubeconfig, filename)
+	if err != nil {
+		return fmt.Errorf("Error writing the kubectl config file \"%s\": %v", filename, err)
+	}
+	return nil
 }
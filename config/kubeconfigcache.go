@@ -0,0 +1,179 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// kubeconfigCacheEntry describes the format of a file in the kubectl configuration cache.  It holds
+// the merged configuration read from the files of a search path, along with what was known about
+// those files when they were read.
+type kubeconfigCacheEntry struct {
+	Files      []kubeconfigFileStamp `json:"files"`
+	Kubeconfig []byte                `json:"kubeconfig"`
+}
+
+// kubeconfigFileStamp identifies the version of a kubectl config file in the search path.
+type kubeconfigFileStamp struct {
+	Path    string    `json:"path"`
+	Exists  bool      `json:"exists"`
+	ModTime time.Time `json:"mod_time"`
+	Size    int64     `json:"size"`
+}
+
+// kubeconfigsLock protects the kubectl configurations remembered by each Kconfig, since nicknames
+// can be resolved concurrently.
+var kubeconfigsLock sync.Mutex
+
+// GetKubeconfigCacheDirectory returns the name of the directory where merged kubectl configurations
+// are cached, when the cache_kubeconfig preference is enabled.
+func GetKubeconfigCacheDirectory() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, "kconfig", "kubeconfig"), nil
+}
+
+// readKubeConfigWithCache is like readKubeConfigFromSearchPath(), except that if the
+// cache_kubeconfig preference is enabled, and the --no-cache option wasn't given, the merged
+// configuration is taken from the cache when none of the files in the search path have changed
+// since it was cached.  Merging a long search path of large files with clientcmd is slow, and the
+// same search path is usually used by many nicknames.  Problems with the cache are only logged,
+// since the configuration can always be read the normal way.  The configurations are also
+// remembered for the life of the process, so commands that resolve many nicknames, like foreach and
+// search, read each search path once.  The returned configuration must not be changed.
+func (k *Kconfig) readKubeConfigWithCache(searchPath string) (*clientcmdapi.Config, error) {
+	kubeconfigsLock.Lock()
+	defer kubeconfigsLock.Unlock()
+	if kubeconfig, exists := k.kubeconfigs[searchPath]; exists {
+		return kubeconfig, nil
+	}
+
+	kubeconfig, err := k.readKubeConfigFromFilesOrCache(searchPath)
+	if err != nil {
+		return nil, err
+	}
+	if k.kubeconfigs == nil {
+		k.kubeconfigs = make(map[string]*clientcmdapi.Config)
+	}
+	k.kubeconfigs[searchPath] = kubeconfig
+	return kubeconfig, nil
+}
+
+// forgetKubeConfigs makes this process read the kubectl config files again, after something like
+// "oc login" has changed them.
+func (k *Kconfig) forgetKubeConfigs() {
+	kubeconfigsLock.Lock()
+	defer kubeconfigsLock.Unlock()
+	k.kubeconfigs = nil
+}
+
+// readKubeConfigFromFilesOrCache does the work of readKubeConfigWithCache(), apart from remembering
+// the configurations already read by this process.  If the kconfig daemon is running, the merged
+// configuration is taken from it instead.
+func (k *Kconfig) readKubeConfigFromFilesOrCache(searchPath string) (*clientcmdapi.Config, error) {
+	if contents := readKubeconfigFromDaemon(searchPath, k.noCache); contents != nil {
+		kubeconfig, err := clientcmd.Load(contents)
+		if err == nil {
+			return kubeconfig, nil
+		}
+		logger.Debugf("The kubectl configuration from the daemon can't be used: %v", err)
+	}
+
+	if !k.Preferences.CacheKubeconfig || k.noCache {
+		return readKubeConfigFromSearchPath(searchPath)
+	}
+
+	cacheDir, err := GetKubeconfigCacheDirectory()
+	if err != nil {
+		logger.Debugf("Not caching the kubectl configuration: %v", err)
+		return readKubeConfigFromSearchPath(searchPath)
+	}
+
+	precedence := newLoadingRules(searchPath).Precedence
+	stamps := getKubeconfigFileStamps(precedence)
+	key := sha256.Sum256([]byte(strings.Join(precedence, "\n")))
+	cacheFilename := filepath.Join(cacheDir, hex.EncodeToString(key[:16])+".json")
+
+	contents, err := os.ReadFile(cacheFilename)
+	if err == nil {
+		var entry kubeconfigCacheEntry
+		var kubeconfig *clientcmdapi.Config
+		err = json.Unmarshal(contents, &entry)
+		if err == nil && !kubeconfigFileStampsEqual(entry.Files, stamps) {
+			err = errors.New("a file in the search path has changed")
+		}
+		if err == nil {
+			kubeconfig, err = clientcmd.Load(entry.Kubeconfig)
+		}
+		if err == nil {
+			logger.Debugf("Using the kubectl configuration cached in \"%s\".", cacheFilename)
+			return kubeconfig, nil
+		}
+		logger.Debugf("The kubectl configuration cached in \"%s\" can't be used: %v", cacheFilename, err)
+	}
+
+	kubeconfig, err := readKubeConfigFromSearchPath(searchPath)
+	if err != nil {
+		return nil, err
+	}
+
+	serialized, err := clientcmd.Write(*kubeconfig)
+	if err == nil {
+		contents, err = json.Marshal(&kubeconfigCacheEntry{Files: stamps, Kubeconfig: serialized})
+	}
+	if err == nil {
+		err = os.MkdirAll(cacheDir, 0700)
+	}
+	if err == nil {
+		err = os.WriteFile(cacheFilename, contents, 0600)
+	}
+	if err != nil {
+		logger.Debugf("Unable to cache the kubectl configuration in \"%s\": %v", cacheFilename, err)
+	} else {
+		logger.Debugf("Cached the kubectl configuration in \"%s\".", cacheFilename)
+	}
+	return kubeconfig, nil
+}
+
+// getKubeconfigFileStamps returns the stamps of the files in the search path.
+func getKubeconfigFileStamps(precedence []string) []kubeconfigFileStamp {
+	stamps := make([]kubeconfigFileStamp, 0, len(precedence))
+	for _, filename := range precedence {
+		stamp := kubeconfigFileStamp{Path: filename}
+		info, err := os.Stat(filename)
+		if err == nil {
+			stamp.Exists = true
+			stamp.ModTime = info.ModTime().UTC()
+			stamp.Size = info.Size()
+		}
+		stamps = append(stamps, stamp)
+	}
+	return stamps
+}
+
+// kubeconfigFileStampsEqual says whether the two lists of stamps describe the same versions of the
+// same files.
+func kubeconfigFileStampsEqual(stamps1 []kubeconfigFileStamp, stamps2 []kubeconfigFileStamp) bool {
+	if len(stamps1) != len(stamps2) {
+		return false
+	}
+	for idx := range stamps1 {
+		if stamps1[idx].Path != stamps2[idx].Path || stamps1[idx].Exists != stamps2[idx].Exists ||
+			!stamps1[idx].ModTime.Equal(stamps2[idx].ModTime) || stamps1[idx].Size != stamps2[idx].Size {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,302 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// defaultKubectlDownloadURL is the base URL of the official kubectl releases.
+const defaultKubectlDownloadURL = "https://dl.k8s.io/release"
+
+// kubectlDownloadTimeout limits how long downloading a kubectl executable can take.
+const kubectlDownloadTimeout = 5 * time.Minute
+
+// serverVersionCacheTTL is how long the version of an API server is remembered.
+const serverVersionCacheTTL = 24 * time.Hour
+
+// serverVersionPattern matches the versions reported by API servers, like "v1.27.3-eks-a5565ad",
+// capturing the major and minor versions.
+var serverVersionPattern = regexp.MustCompile(`^v?(\d+)\.(\d+)\.`)
+
+// kubectlReleasePattern matches the kubectl release names published by the download site.
+var kubectlReleasePattern = regexp.MustCompile(`^v\d+\.\d+\.\d+$`)
+
+// kubectlVersionPattern matches the kubectl versions that can be fetched, either a minor version
+// like "1.28", for its latest patch release, or a release like "1.28.3", with an optional "v"
+// prefix.  The version without the prefix is captured.
+var kubectlVersionPattern = regexp.MustCompile(`^v?(\d+\.\d+(?:\.\d+)?)$`)
+
+// managedKubectlPrefix starts the kubectl executable name, in a nickname definition or the
+// default_kubectl preference, that names a kubectl executable in the kubectl bin directory by
+// version, like "kubectl@1.28".
+const managedKubectlPrefix = "kubectl@"
+
+// serverVersionCacheEntry describes the format of an entry in the file where the versions of API
+// servers are cached, by API server URL.
+type serverVersionCacheEntry struct {
+	Version   string    `json:"version"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// KubectlBinDirectory returns the name of the directory that holds the kubectl executables selected
+// by the auto_kubectl_version preference, and those installed by FetchKubectl().
+func (k *Kconfig) KubectlBinDirectory() string {
+	if k.Preferences.KubectlBinDirectory != "" {
+		return k.Preferences.KubectlBinDirectory
+	}
+	return filepath.Join(getHomeDirectory(), ".kube", "kubectl-bins")
+}
+
+// ManagedKubectlFilename returns the name of the file in the kubectl bin directory that holds the
+// version of kubectl, like "1.28" or "v1.28.3", as installed by FetchKubectl().
+func (k *Kconfig) ManagedKubectlFilename(version string) (string, error) {
+	matches := kubectlVersionPattern.FindStringSubmatch(version)
+	if matches == nil {
+		return "", fmt.Errorf("\"%s\" isn't a kubectl version like 1.28 or 1.28.3.", version)
+	}
+	return filepath.Join(k.KubectlBinDirectory(), "kubectl-"+matches[1]), nil
+}
+
+// FetchKubectl downloads the version of kubectl, like "1.28" for its latest patch release, or
+// "1.28.3", for the operating system and architecture of this host, and installs it in the kubectl
+// bin directory, replacing any executable already there.  The release downloaded and the name of
+// the installed file are returned.
+func (k *Kconfig) FetchKubectl(version string) (string, string, error) {
+	filename, err := k.ManagedKubectlFilename(version)
+	if err != nil {
+		return "", "", err
+	}
+	release, err := k.downloadKubectl(strings.TrimPrefix(version, "v"), filename)
+	if err != nil {
+		return "", "", err
+	}
+	return release, filename, nil
+}
+
+// kubectlDownloadURL returns the base URL of the kubectl releases that are downloaded.
+func (k *Kconfig) kubectlDownloadURL() string {
+	if k.Preferences.KubectlDownloadURL != "" {
+		return strings.TrimSuffix(k.Preferences.KubectlDownloadURL, "/")
+	}
+	return defaultKubectlDownloadURL
+}
+
+// selectKubectl chooses the kubectl executable that kset and "kubectl -k" use for the results.  A
+// kubectl executable named by version, like "kubectl@1.28", is downloaded if it isn't installed
+// and the download_kubectl preference allows it.  Otherwise the auto_kubectl_version preference
+// is applied.
+func (k *Kconfig) selectKubectl(results *CreateConfigResults) {
+	if results.kubectlVersion == "" {
+		k.selectKubectlForServerVersion(results)
+		return
+	}
+
+	_, err := os.Stat(results.KubectlExecutable)
+	if !errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if !k.Preferences.DownloadKubectl {
+		fmt.Fprintf(os.Stderr, "Warning: kubectl %s isn't installed.  Run \"kconfig-util fetch-kubectl %s\" to install it.\n", results.kubectlVersion, results.kubectlVersion)
+		return
+	}
+	_, err = k.downloadKubectl(results.kubectlVersion, results.KubectlExecutable)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Unable to download kubectl %s: %v\n", results.kubectlVersion, err)
+	}
+}
+
+// selectKubectlForServerVersion handles the auto_kubectl_version preference.  Unless the nickname's
+// definition names the kubectl executable, the executable in the results is replaced with the one
+// in the kubectl bin directory that matches the minor version of the cluster's API server.  It's
+// downloaded if it isn't there and the download_kubectl preference allows it.  If the executable
+// can't be selected, a warning is printed and the default is kept, since it might still work.
+func (k *Kconfig) selectKubectlForServerVersion(results *CreateConfigResults) {
+	if !k.Preferences.AutoKubectlVersion || results.explicitKubectl {
+		return
+	}
+
+	executable, err := k.findKubectlForServerVersion(results)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Using kubectl executable \"%s\", since one matching the cluster's version can't be selected: %v\n", results.KubectlExecutable, err)
+		return
+	}
+	logger.Debugf("Selected kubectl executable \"%s\" for the cluster's version.", executable)
+	results.KubectlExecutable = executable
+}
+
+// findKubectlForServerVersion returns the name of the kubectl executable that matches the minor
+// version of the cluster's API server, downloading it if necessary and allowed.
+func (k *Kconfig) findKubectlForServerVersion(results *CreateConfigResults) (string, error) {
+	serverVersion, err := results.cachedServerVersion()
+	if err != nil {
+		return "", fmt.Errorf("Unable to get the version of the API server: %w", err)
+	}
+
+	matches := serverVersionPattern.FindStringSubmatch(serverVersion)
+	if matches == nil {
+		return "", fmt.Errorf("The API server reports an unrecognized version \"%s\".", serverVersion)
+	}
+	minorVersion := matches[1] + "." + matches[2]
+
+	filename := filepath.Join(k.KubectlBinDirectory(), "kubectl-"+minorVersion)
+	if _, err := os.Stat(filename); err == nil {
+		return filename, nil
+	}
+	if !k.Preferences.DownloadKubectl {
+		return "", fmt.Errorf("\"%s\" doesn't exist, and the download_kubectl preference doesn't allow downloading it.", filename)
+	}
+
+	_, err = k.downloadKubectl(minorVersion, filename)
+	if err != nil {
+		return "", err
+	}
+	return filename, nil
+}
+
+// cachedServerVersion returns the version of the API server, which is cached for a day, by API
+// server URL, so kset doesn't ask the API server every time.  Problems with the cache are only
+// logged.
+func (r *CreateConfigResults) cachedServerVersion() (string, error) {
+	serverURL := r.ServerURL()
+	if serverURL == "" {
+		return "", errors.New("The context doesn't name an API server.")
+	}
+
+	cacheFilename := filepath.Join(GetKconfigCacheDirectory(), "server-versions.json")
+	entries := make(map[string]serverVersionCacheEntry)
+	contents, err := os.ReadFile(cacheFilename)
+	if err == nil {
+		err = json.Unmarshal(contents, &entries)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Debugf("Unable to read the cached API server versions in \"%s\": %v", cacheFilename, err)
+	}
+
+	entry, exists := entries[serverURL]
+	if exists && time.Since(entry.CheckedAt) < serverVersionCacheTTL {
+		logger.Debugf("Using cached version \"%s\" of API server %s.", entry.Version, serverURL)
+		return entry.Version, nil
+	}
+
+	serverVersion, err := r.ServerVersion()
+	if err != nil {
+		return "", err
+	}
+
+	entries[serverURL] = serverVersionCacheEntry{Version: serverVersion, CheckedAt: time.Now().UTC()}
+	contents, err = json.Marshal(entries)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(cacheFilename), 0700)
+	}
+	if err == nil {
+		err = writeFileAtomically(cacheFilename, contents, 0600)
+	}
+	if err != nil {
+		logger.Debugf("Unable to cache the API server versions in \"%s\": %v", cacheFilename, err)
+	}
+	return serverVersion, nil
+}
+
+// downloadKubectl downloads a version of kubectl for the operating system and architecture of this
+// host to the named file, returning the release downloaded.  For a minor version, like "1.27", the
+// latest patch release is downloaded.  Otherwise the version is a release, like "1.27.3".  The
+// download is verified with the SHA-256 checksum published with the release.
+func (k *Kconfig) downloadKubectl(version string, filename string) (string, error) {
+	client := &http.Client{Timeout: kubectlDownloadTimeout}
+	baseURL := k.kubectlDownloadURL()
+
+	release := "v" + version
+	if strings.Count(version, ".") == 1 {
+		var err error
+		release, err = downloadText(client, fmt.Sprintf("%s/stable-%s.txt", baseURL, version))
+		if err != nil {
+			return "", err
+		}
+	}
+	if !kubectlReleasePattern.MatchString(release) {
+		return "", fmt.Errorf("The latest release of kubectl %s is reported as \"%s\", which isn't a release name.", version, release)
+	}
+
+	binaryURL := fmt.Sprintf("%s/%s/bin/%s/%s/kubectl", baseURL, release, runtime.GOOS, runtime.GOARCH)
+	checksum, err := downloadText(client, binaryURL+".sha256")
+	if err != nil {
+		return "", err
+	}
+	checksumFields := strings.Fields(checksum)
+	if len(checksumFields) == 0 {
+		return "", fmt.Errorf("The checksum at %s.sha256 is empty.", binaryURL)
+	}
+
+	fmt.Fprintf(os.Stderr, "Downloading kubectl %s to \"%s\".\n", release, filename)
+	response, err := client.Get(binaryURL)
+	if err != nil {
+		return "", fmt.Errorf("Unable to download %s: %v", binaryURL, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Request for %s failed with status %s", binaryURL, response.Status)
+	}
+
+	err = os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return "", err
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	tempFilename := tempFile.Name()
+	defer os.Remove(tempFilename)
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tempFile, hash), response.Body)
+	if err == nil {
+		err = tempFile.Chmod(0755)
+	}
+	closeErr := tempFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("Error downloading %s: %v", binaryURL, err)
+	}
+
+	if !strings.EqualFold(hex.EncodeToString(hash.Sum(nil)), checksumFields[0]) {
+		return "", fmt.Errorf("The checksum of %s doesn't match the one published with it.", binaryURL)
+	}
+	err = os.Rename(tempFilename, filename)
+	if err != nil {
+		return "", err
+	}
+	return release, nil
+}
+
+// downloadText returns the contents of the small text file at the URL, without surrounding white
+// space.
+func downloadText(client *http.Client, url string) (string, error) {
+	response, err := client.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("Unable to download %s: %v", url, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Request for %s failed with status %s", url, response.Status)
+	}
+
+	contents, err := io.ReadAll(io.LimitReader(response.Body, 4096))
+	if err != nil {
+		return "", fmt.Errorf("Error downloading %s: %v", url, err)
+	}
+	return strings.TrimSpace(string(contents)), nil
+}
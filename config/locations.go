@@ -0,0 +1,180 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// GetStateDirectory returns the directory that holds the local kubectl config files that kconfig
+// creates, in its "sessions" and "nicks" subdirectories.  It's named by the KCONFIG_STATE_DIR
+// environment variable if it's set, and otherwise by the state_dir preference.  If neither is set,
+// it's the "kconfig" directory in $XDG_STATE_HOME or $XDG_RUNTIME_DIR, if one of those variables
+// is set, or "kconfig-UID" in the temporary directory (usually /tmp) otherwise.
+func GetStateDirectory() string {
+	return GetKconfig().stateDirectory()
+}
+
+// stateDirectory is like GetStateDirectory(), using the state_dir preference of this kconfig.
+func (k *Kconfig) stateDirectory() string {
+	stateDir := k.chosenStateDirectory()
+	if stateDir == "" {
+		return defaultStateDirectory()
+	}
+	return stateDir
+}
+
+// chosenStateDirectory returns the state directory named by the KCONFIG_STATE_DIR environment
+// variable or the state_dir preference of this kconfig, or an empty string if neither is set.
+func (k *Kconfig) chosenStateDirectory() string {
+	stateDir := os.Getenv("KCONFIG_STATE_DIR")
+	if stateDir == "" {
+		stateDir = k.Preferences.StateDir
+	}
+	if stateDir == "" {
+		return ""
+	}
+
+	// The files are named in the KUBECONFIG environment variable, so they must be found from any
+	// directory.
+	absStateDir, err := filepath.Abs(stateDir)
+	if err != nil {
+		return stateDir
+	}
+	return absStateDir
+}
+
+// defaultStateDirectory returns the state directory that's used when neither the
+// KCONFIG_STATE_DIR environment variable nor the state_dir preference is set.
+func defaultStateDirectory() string {
+	if xdgStateHome := os.Getenv("XDG_STATE_HOME"); xdgStateHome != "" {
+		return filepath.Join(xdgStateHome, "kconfig")
+	}
+	if xdgRuntimeDir := os.Getenv("XDG_RUNTIME_DIR"); xdgRuntimeDir != "" {
+		return filepath.Join(xdgRuntimeDir, "kconfig")
+	}
+	// The temporary directory is usually shared by all users, so each gets their own directory.
+	return filepath.Join(os.TempDir(), fmt.Sprintf("kconfig-%d", os.Getuid()))
+}
+
+// getSessionDirectory returns the directory that holds the session-local kubectl config files
+// created by kset.  It's named by the session_dir preference if it's set, and is otherwise the
+// "sessions" subdirectory of the state directory.
+func getSessionDirectory() string {
+	return GetKconfig().sessionDirectory()
+}
+
+// sessionDirectory is like getSessionDirectory(), using the preferences of this kconfig.
+func (k *Kconfig) sessionDirectory() string {
+	sessionDir := k.Preferences.SessionDir
+	if sessionDir == "" {
+		return filepath.Join(k.stateDirectory(), "sessions")
+	}
+	if sessionDir == "~" || strings.HasPrefix(sessionDir, "~/") {
+		sessionDir = filepath.Join(getHomeDirectory(), sessionDir[1:])
+	}
+
+	// Like the state directory, it's named in the KUBECONFIG environment variable.
+	absSessionDir, err := filepath.Abs(sessionDir)
+	if err != nil {
+		return sessionDir
+	}
+	return absSessionDir
+}
+
+// DefaultSessionDirectory returns the directory that holds the session-local kubectl config files
+// when the session_dir preference isn't set.
+func DefaultSessionDirectory() string {
+	return filepath.Join(GetStateDirectory(), "sessions")
+}
+
+// makeSessionDirectory creates the session directory, readable only by the user, and returns its
+// name.  The state directory that holds it by default is created too, but not the parent of one
+// named by the session_dir preference, like ~/.kube.
+func makeSessionDirectory() (string, error) {
+	sessionDir := getSessionDirectory()
+	var err error
+	if sessionDir == DefaultSessionDirectory() {
+		err = makeStateDirectory()
+	}
+	if err == nil {
+		err = makePrivateDirectory(sessionDir)
+	}
+	return sessionDir, err
+}
+
+// getNicknameDirectory returns the directory that holds the kubectl config files created for
+// nicknames by the kubectl program's --kconfig option.
+func getNicknameDirectory() string {
+	return filepath.Join(GetStateDirectory(), "nicks")
+}
+
+// getContainerDirectory returns the directory that holds the kubectl config files written by the
+// docker-args command, to be mounted into containers.
+func getContainerDirectory() string {
+	return filepath.Join(GetStateDirectory(), "containers")
+}
+
+// getPrecomputedDirectory returns the directory that holds the precomputed kubectl config files of
+// nicknames, named by their keys.
+func getPrecomputedDirectory() string {
+	return filepath.Join(getNicknameDirectory(), "precomputed")
+}
+
+// makeStateDirectory creates the state directory.  The default one is made private, like the
+// directories kconfig creates in it.  One named by the KCONFIG_STATE_DIR environment variable or
+// the state_dir preference is the user's choice, so it's only created, readable only by the user,
+// if it's missing, and its permissions and owner are left alone.
+func makeStateDirectory() error {
+	if stateDir := GetKconfig().chosenStateDirectory(); stateDir != "" {
+		return os.MkdirAll(stateDir, 0700)
+	}
+	return makePrivateDirectory(defaultStateDirectory())
+}
+
+// makePrivateDirectory creates the directory, and any missing parents, readable only by the user.
+// Since the local kubectl config files in it can hold credentials, it's an error if the directory
+// already exists and is owned by someone else, and its permissions are restricted to the user if
+// they aren't already.
+func makePrivateDirectory(dir string) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("\"%s\" isn't a directory.", dir)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("The directory \"%s\" is owned by another user.", dir)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		err = os.Chmod(dir, info.Mode().Perm()&0700)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DefaultKconfigFilename returns the name of the kconfig.yaml file.  It's named by the
+// KCONFIG_CONFIG environment variable if it's set.  Otherwise it's $XDG_CONFIG_HOME/kconfig/
+// kconfig.yaml if XDG_CONFIG_HOME is set and that file exists, or ~/.kube/kconfig.yaml.
+func DefaultKconfigFilename() string {
+	if filename := os.Getenv("KCONFIG_CONFIG"); filename != "" {
+		return filename
+	}
+	if xdgConfigHome := os.Getenv("XDG_CONFIG_HOME"); xdgConfigHome != "" {
+		filename := filepath.Join(xdgConfigHome, "kconfig", "kconfig.yaml")
+		if _, err := os.Stat(filename); err == nil {
+			return filename
+		}
+	}
+	return filepath.Join(getHomeDirectory(), ".kube", "kconfig.yaml")
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockFile takes an exclusive lock on the lock file that goes with the named file, waiting for any
+// other process holding it to release it.  The lock file is named after the file, with ".lock"
+// appended, and is left in place when the lock is released, since removing it would let two
+// processes lock different files of the same name.  The returned function releases the lock.
+func lockFile(filename string) (func(), error) {
+	lockFilename := filename + ".lock"
+	file, err := os.OpenFile(lockFilename, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open the lock file \"%s\": %v", lockFilename, err)
+	}
+
+	err = unix.Flock(int(file.Fd()), unix.LOCK_EX)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Unable to lock the lock file \"%s\": %v", lockFilename, err)
+	}
+
+	return func() {
+		unix.Flock(int(file.Fd()), unix.LOCK_UN)
+		file.Close()
+	}, nil
+}
+
+// writeFileAtomically replaces the named file with the contents.  The contents are written to a
+// temporary file in the same directory, which is then renamed, so other processes reading the file
+// see either the old contents or the new contents, never a partly written file.
+func writeFileAtomically(filename string, contents []byte, perm os.FileMode) error {
+	tempFile, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tempFilename := tempFile.Name()
+
+	_, err = tempFile.Write(contents)
+	if err == nil {
+		err = tempFile.Chmod(perm)
+	}
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	closeErr := tempFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempFilename, filename)
+	}
+	if err != nil {
+		os.Remove(tempFilename)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+)
+
+// addNicknameContexts adds a context for each of the nicknames to the local kubectl config file
+// described by the results, so that tools that switch contexts, like "kubectl config use-context"
+// or k9s, can switch between the nicknames within one kset environment.  Each context is named
+// after its nickname, and the nickname's cluster and user are copied to the local file, since the
+// nickname might use other kubectl config files than the main one.  The current context isn't
+// changed.  Dangerous nicknames, and those whose kubectl commands are refused or need
+// confirmation, can't be added, since the kubectl program only enforces the main nickname's policy,
+// so switching to their contexts would escape it.
+func (k *Kconfig) addNicknameContexts(results *CreateConfigResults, nicknames []string) error {
+	if len(nicknames) > 0 {
+		err := k.requireFeature(FeatureAlsoContexts, "The --also option")
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, nickname := range nicknames {
+		if nickname == results.ConfigContent.CurrentContext {
+			return fmt.Errorf("The nickname \"%s\" can't be added with --also, since it's the name of the current context.", nickname)
+		}
+
+		policy, err := k.NicknameMutationPolicy(nickname)
+		if err != nil {
+			return err
+		}
+		if policy != MutationsAllowed {
+			return codedErrorf(ErrorCodeUsage, "The nickname \"%s\" can't be added with --also, since its kubectl commands are refused or need confirmation.", nickname)
+		}
+
+		nicknameResults, err := k.ResolveLocalKubectlConfig(nickname, nil)
+		if err != nil {
+			return err
+		}
+		if nicknameResults.Danger {
+			return codedErrorf(ErrorCodeUsage, "The nickname \"%s\" can't be added with --also, since it's dangerous.", nickname)
+		}
+		merged := nicknameResults.MergedConfig()
+		context := merged.Contexts[merged.CurrentContext].DeepCopy()
+		context.LocationOfOrigin = ""
+
+		cluster, exists := merged.Clusters[context.Cluster]
+		if !exists {
+			return codedErrorf(ErrorCodeMissingCluster, "Cluster \"%s\" of nickname \"%s\" doesn't exist.", context.Cluster, nickname)
+		}
+		cluster = cluster.DeepCopy()
+		cluster.LocationOfOrigin = ""
+		context.Cluster = fmt.Sprintf("%s_%s", kconfigClusterName, nickname)
+		results.ConfigContent.Clusters[context.Cluster] = cluster
+
+		// A context can name a user that isn't defined, which kubectl allows, so that's left alone.
+		if user, exists := merged.AuthInfos[context.AuthInfo]; exists {
+			user = user.DeepCopy()
+			user.LocationOfOrigin = ""
+			context.AuthInfo = fmt.Sprintf("%s_%s", kconfigUserName, nickname)
+			results.ConfigContent.AuthInfos[context.AuthInfo] = user
+		}
+
+		results.ConfigContent.Contexts[nickname] = context
+	}
+	if results.SelfContained {
+		return flattenConfig(results.ConfigContent)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"strings"
+)
+
+// readOnlyVerbs are the kubectl subcommands that don't change the state of the cluster.  Any other
+// subcommand, including plugins and ones added by newer versions of kubectl, is assumed to change
+// it, so a read-only nickname only allows what's known to be safe.
+var readOnlyVerbs = map[string]bool{
+	"api-resources": true,
+	"api-versions":  true,
+	"cluster-info":  true,
+	"completion":    true,
+	"config":        true,
+	"describe":      true,
+	"diff":          true,
+	"events":        true,
+	"explain":       true,
+	"get":           true,
+	"help":          true,
+	"kustomize":     true,
+	"logs":          true,
+	"options":       true,
+	"plugin":        true,
+	"top":           true,
+	"version":       true,
+	"wait":          true,
+}
+
+// readOnlySubcommands are the subcommands of the kubectl subcommands that don't change anything,
+// for the subcommands that otherwise can.
+var readOnlySubcommands = map[string]map[string]bool{
+	"auth": {
+		"can-i":  true,
+		"whoami": true,
+	},
+	"rollout": {
+		"history": true,
+		"status":  true,
+	},
+}
+
+// flagsWithValues are the kubectl global options that take a separate value argument when they're
+// not written in the --option=value form.  They need to be known to find the subcommand.
+var flagsWithValues = map[string]bool{
+	"--as":                    true,
+	"--as-group":              true,
+	"--as-uid":                true,
+	"--cache-dir":             true,
+	"--certificate-authority": true,
+	"--client-certificate":    true,
+	"--client-key":            true,
+	"--cluster":               true,
+	"--context":               true,
+	"--kubeconfig":            true,
+	"--log-file":              true,
+	"--namespace":             true,
+	"--password":              true,
+	"--profile":               true,
+	"--profile-output":        true,
+	"--request-timeout":       true,
+	"--server":                true,
+	"--tls-server-name":       true,
+	"--token":                 true,
+	"--user":                  true,
+	"--username":              true,
+	"-n":                      true,
+	"-s":                      true,
+	"-v":                      true,
+}
+
+// kubectlCommandWords returns the subcommand words of the kubectl command-line arguments, which are
+// the positional arguments.  The values of global options that take a value are skipped.
+func kubectlCommandWords(args []string) []string {
+	var words []string
+	for idx := 0; idx < len(args); idx++ {
+		arg := args[idx]
+		if arg == "--" {
+			break
+		}
+		if strings.HasPrefix(arg, "-") {
+			if flagsWithValues[arg] {
+				idx++
+			}
+			continue
+		}
+		words = append(words, arg)
+	}
+	return words
+}
+
+// IsMutatingKubectlCommand says whether the kubectl command-line arguments describe a command that
+// changes the state of the cluster, or might, like exec, cp, or port-forward.  Commands run with a
+// client or server dry run don't.  It's used by the kubectl program included with kconfig and by
+// the foreach subcommand of kconfig-util to apply the nicknames' mutation policies.
+func IsMutatingKubectlCommand(args []string) bool {
+	words := kubectlCommandWords(args)
+	if len(words) == 0 || readOnlyVerbs[words[0]] {
+		return false
+	}
+
+	if len(words) > 1 && readOnlySubcommands[words[0]][words[1]] {
+		return false
+	}
+
+	return !isDryRun(args)
+}
+
+// dryRunValues are the values of the --dry-run option that ask for a dry run.  kubectl treats
+// "false" and its other spellings as "none".  Any value that isn't known is taken as a real run.
+var dryRunValues = map[string]bool{
+	"client": true,
+	"server": true,
+	"true":   true,
+}
+
+// notDryRunValues are the values that could follow a plain --dry-run option as a separate
+// argument, meaning no dry run.
+var notDryRunValues = map[string]bool{
+	"none":  true,
+	"false": true,
+	"False": true,
+	"FALSE": true,
+	"f":     true,
+	"F":     true,
+	"0":     true,
+}
+
+// isDryRun says whether the kubectl command-line arguments ask for a client or server dry run, with
+// the last --dry-run option.  Since a "none" or "false" after a plain --dry-run option could be
+// meant as its value, it's taken as one, so the command isn't mistaken for a dry run.
+func isDryRun(args []string) bool {
+	dryRun := false
+	for idx := 0; idx < len(args); idx++ {
+		arg := args[idx]
+		if arg == "--" {
+			break
+		}
+		if arg == "--dry-run" {
+			dryRun = idx+1 == len(args) || !notDryRunValues[args[idx+1]]
+		} else if strings.HasPrefix(arg, "--dry-run=") {
+			dryRun = dryRunValues[strings.TrimPrefix(arg, "--dry-run=")]
+		}
+	}
+	return dryRun
+}
+
+// KubectlFlagValue returns the value of the last occurrence of any of the named options in the
+// kubectl command-line arguments, in either the "--option value" or "--option=value" form, or an
+// empty string if there isn't one.
+func KubectlFlagValue(args []string, names ...string) string {
+	var value string
+	for idx := 0; idx < len(args); idx++ {
+		arg := args[idx]
+		if arg == "--" {
+			break
+		}
+		for _, name := range names {
+			if arg == name && idx+1 < len(args) {
+				value = args[idx+1]
+			} else if strings.HasPrefix(arg, name+"=") {
+				value = strings.TrimPrefix(arg, name+"=")
+			}
+		}
+	}
+	return value
+}
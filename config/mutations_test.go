@@ -0,0 +1,82 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestIsMutatingKubectlCommand(t *testing.T) {
+	testCases := []struct {
+		Args   []string
+		Expect bool
+	}{
+		{[]string{"get", "pods"}, false},
+		{[]string{"delete", "pod", "foo"}, true},
+		{[]string{"-n", "delete", "get", "pods"}, false},
+		{[]string{"--namespace=foo", "apply", "-f", "x.yaml"}, true},
+		{[]string{"--context", "prod", "scale", "deploy/foo", "--replicas=0"}, true},
+		{[]string{"apply", "-f", "x.yaml", "--dry-run=client"}, false},
+		{[]string{"apply", "-f", "x.yaml", "--dry-run=none"}, true},
+		{[]string{"apply", "-f", "x.yaml", "--dry-run", "none"}, true},
+		{[]string{"apply", "-f", "x.yaml", "--dry-run", "server"}, false},
+		{[]string{"apply", "-f", "x.yaml", "--dry-run=false"}, true},
+		{[]string{"apply", "-f", "x.yaml", "--dry-run", "false"}, true},
+		{[]string{"apply", "-f", "x.yaml", "--dry-run=FALSE"}, true},
+		{[]string{"apply", "-f", "x.yaml", "--dry-run", "0"}, true},
+		{[]string{"apply", "-f", "x.yaml", "--dry-run=true"}, false},
+		{[]string{"apply", "-f", "x.yaml", "--dry-run=server"}, false},
+		{[]string{"apply", "-f", "x.yaml", "--dry-run=bogus"}, true},
+		{[]string{"apply", "-f", "x.yaml", "--dry-run"}, false},
+		{[]string{"apply", "-f", "x.yaml", "--dry-run=server", "--dry-run=none"}, true},
+		{[]string{"apply", "-f", "x.yaml", "--dry-run-x"}, true},
+		{[]string{"rollout", "status", "deploy/foo"}, false},
+		{[]string{"rollout", "restart", "deploy/foo"}, true},
+		{[]string{"exec", "foo", "--", "rm", "-rf", "/tmp/x"}, true},
+		{[]string{"exec", "foo", "--", "ls", "--dry-run"}, true},
+		{[]string{"cp", "foo:/data", "/tmp/data"}, true},
+		{[]string{"debug", "foo", "-it", "--image=busybox"}, true},
+		{[]string{"attach", "foo"}, true},
+		{[]string{"port-forward", "svc/foo", "8080:80"}, true},
+		{[]string{"proxy"}, true},
+		{[]string{"certificate", "approve", "csr-1"}, true},
+		{[]string{"auth", "reconcile", "-f", "rbac.yaml"}, true},
+		{[]string{"auth", "can-i", "delete", "pods"}, false},
+		{[]string{"auth", "whoami"}, false},
+		{[]string{"rollout", "history", "deploy/foo"}, false},
+		{[]string{"rollout"}, true},
+		{[]string{"some-plugin", "do-something"}, true},
+		{[]string{"logs", "-f", "foo"}, false},
+		{[]string{"describe", "node", "foo"}, false},
+		{[]string{"top", "pods"}, false},
+		{[]string{"config", "view"}, false},
+		{[]string{"version", "--client"}, false},
+		{[]string{"--help"}, false},
+		{[]string{}, false},
+	}
+
+	for _, testCase := range testCases {
+		actual := IsMutatingKubectlCommand(testCase.Args)
+		if actual != testCase.Expect {
+			t.Errorf("IsMutatingKubectlCommand(%q) returned %v, expected %v", testCase.Args, actual, testCase.Expect)
+		}
+	}
+}
+
+func TestKubectlFlagValue(t *testing.T) {
+	testCases := []struct {
+		Args   []string
+		Expect string
+	}{
+		{[]string{"get", "pods"}, ""},
+		{[]string{"get", "pods", "-n", "foo"}, "foo"},
+		{[]string{"--namespace=foo", "get", "pods"}, "foo"},
+		{[]string{"-n", "foo", "get", "pods", "--namespace", "bar"}, "bar"},
+		{[]string{"exec", "pod", "--", "cmd", "-n", "foo"}, ""},
+	}
+
+	for _, testCase := range testCases {
+		actual := KubectlFlagValue(testCase.Args, "-n", "--namespace")
+		if actual != testCase.Expect {
+			t.Errorf("KubectlFlagValue(%q) returned \"%s\", expected \"%s\"", testCase.Args, actual, testCase.Expect)
+		}
+	}
+}
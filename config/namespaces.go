@@ -0,0 +1,103 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// namespaceCacheTTL is how long the namespaces of a cluster are remembered.  It's short, since
+// namespaces come and go, but long enough for the repeated requests of shell completion.
+const namespaceCacheTTL = 2 * time.Minute
+
+// namespaceCacheEntry describes the format of an entry in the file where the namespaces of clusters
+// are cached, by API server URL and user.
+type namespaceCacheEntry struct {
+	Namespaces []string  `json:"namespaces"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+// CachedClusterNamespaces is like ListClusterNamespaces(), but the namespaces are cached for a
+// couple of minutes, by API server URL and user, so shell completion doesn't ask the API server
+// every time.  Problems with the cache are only logged.
+func (r *CreateConfigResults) CachedClusterNamespaces() ([]string, error) {
+	merged := r.MergedConfig()
+	var user string
+	if context, exists := merged.Contexts[merged.CurrentContext]; exists {
+		user = context.AuthInfo
+	}
+	cacheKey := user + "@" + r.ServerURL()
+
+	cacheFilename := filepath.Join(GetKconfigCacheDirectory(), "namespaces.json")
+	entries := make(map[string]namespaceCacheEntry)
+	contents, err := os.ReadFile(cacheFilename)
+	if err == nil {
+		err = json.Unmarshal(contents, &entries)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Debugf("Unable to read the cached namespaces in \"%s\": %v", cacheFilename, err)
+	}
+
+	entry, exists := entries[cacheKey]
+	if exists && time.Since(entry.CheckedAt) < namespaceCacheTTL {
+		logger.Debugf("Using cached namespaces of %s.", cacheKey)
+		return entry.Namespaces, nil
+	}
+
+	namespaces, err := r.ListClusterNamespaces()
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop expired entries, so the file doesn't grow with every cluster ever used.
+	for key, entry := range entries {
+		if time.Since(entry.CheckedAt) >= namespaceCacheTTL {
+			delete(entries, key)
+		}
+	}
+	entries[cacheKey] = namespaceCacheEntry{Namespaces: namespaces, CheckedAt: time.Now().UTC()}
+	contents, err = json.Marshal(entries)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(cacheFilename), 0700)
+	}
+	if err == nil {
+		err = writeFileAtomically(cacheFilename, contents, 0600)
+	}
+	if err != nil {
+		logger.Debugf("Unable to cache the namespaces in \"%s\": %v", cacheFilename, err)
+	}
+	return namespaces, nil
+}
+
+// GetNamespacesSeen returns the namespaces that kconfig remembers being used with the nickname,
+// from its namespace history and from the -n options of the kset history, sorted.
+func GetNamespacesSeen(nickname string) []string {
+	state := ReadKconfigState()
+	seen := make(map[string]bool)
+	if history := state.NamespaceHistory[nickname]; history != nil {
+		seen[history.Current] = true
+		seen[history.Previous] = true
+	}
+	for _, entry := range state.KsetHistory {
+		if len(entry.Args) == 0 || entry.Args[0] != nickname {
+			continue
+		}
+		kconfigOptions, _, err := ParseKsetArgs(entry.Args)
+		if err != nil {
+			logger.Debugf("Unable to parse the kset history entry %v: %v", entry.Args, err)
+			continue
+		}
+		seen[kconfigOptions.Namespace] = true
+	}
+	delete(seen, "")
+
+	namespaces := make([]string, 0, len(seen))
+	for namespace := range seen {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
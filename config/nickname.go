@@ -0,0 +1,682 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/shlex"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// MaxNicknameChainLength is the maximum number of nicknames that can be chained together with the
+// --extends option.
+const MaxNicknameChainLength = 10
+
+// NicknameResolution describes the result of resolving a nickname's definition, including the
+// definitions of any nicknames it extends.
+type NicknameResolution struct {
+	// Chain lists the nicknames that were consulted, starting with the requested nickname and
+	// followed by the nickname each one extends.
+	Chain []string
+
+	// Definitions holds the definition of each nickname in Chain.
+	Definitions []string
+
+	// Options are the effective options after merging the definitions.  The Extends option isn't
+	// included.
+	Options *KconfigOptions
+
+	// KubectlExecutable is the effective kubectl executable for the nickname.
+	KubectlExecutable string
+
+	// ExplicitKubectl says whether a definition in the chain names the kubectl executable, rather
+	// than it being the default.
+	ExplicitKubectl bool
+
+	// KubectlVersion is the version of kubectl named by a kubectl executable name like
+	// "kubectl@1.28", in which case KubectlExecutable is the file in the kubectl bin directory that
+	// holds that version.
+	KubectlVersion string
+
+	// Danger says whether any definition in the chain has the --danger option.
+	Danger bool
+
+	// ConfirmMutations says whether any definition in the chain has the --confirm-mutations
+	// option.
+	ConfirmMutations bool
+
+	// ReadOnly says whether any definition in the chain has the --read-only option.
+	ReadOnly bool
+
+	// OpenShift says whether any definition in the chain has the --openshift option.
+	OpenShift bool
+
+	// OCLogin says whether any definition in the chain has the --oc-login option.
+	OCLogin bool
+
+	// Tags lists the --tag options of all the definitions in the chain, without duplicates.
+	Tags []string
+
+	// PluginPath is the effective --plugin-path option, which, like the kubectl executable, is
+	// taken from the first definition in the chain that has one.
+	PluginPath string
+
+	// OIDC holds the effective --oidc-issuer, --oidc-client-id, and --oidc-scopes options.  If
+	// they're set, kconfig logs in to the OIDC provider for kubectl.
+	OIDC *OIDCSettings
+
+	// KeychainToken is the effective --keychain-token option, which names the token kept in the OS
+	// keychain that kubectl uses.  Like the plugin path, it's taken from the first definition in
+	// the chain that has one.
+	KeychainToken string
+
+	// VaultPath is the effective --vault-path option, which names the path in HashiCorp Vault that
+	// kubectl gets its credentials from.  It's taken from the first definition in the chain that
+	// has one.
+	VaultPath string
+
+	// RequestTimeout is the effective --request-timeout option, which the kubectl program passes
+	// on to the kubectl executable.  It's taken from the first definition in the chain that has
+	// one.
+	RequestTimeout string
+
+	// Environment lists the environment variables, like "NAME=VALUE", that kset sets for the
+	// nickname, sorted by name:  those of the --env options, and KUBECACHEDIR for the --cache-dir
+	// option.  A definition's value takes precedence over the value of one it extends.
+	Environment []string
+}
+
+// MatchNicknames returns the defined nicknames that match the pattern, in the syntax of
+// filepath.Match(), in sorted order.
+func (k *Kconfig) MatchNicknames(pattern string) ([]string, error) {
+	var nicknames []string
+	for nickname := range k.Nicknames {
+		matched, err := filepath.Match(pattern, nickname)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid nickname pattern \"%s\": %v", pattern, err)
+		}
+		if matched {
+			nicknames = append(nicknames, nickname)
+		}
+	}
+	sort.Strings(nicknames)
+	return nicknames, nil
+}
+
+// ExpandNickname returns the defined nickname that the nickname given on the command line names.
+// It's the nickname itself, or the nickname it's an alias of.  If the abbreviated_nicknames
+// preference is true, it can also be the only nickname that matches it ignoring case, or the only
+// nickname that starts with it ignoring case.  If more than one nickname matches, an error listing
+// them is returned.  If none does, the nickname is returned as is, so resolving it reports that it
+// isn't defined.
+func (k *Kconfig) ExpandNickname(nickname string) (string, error) {
+	if _, defined := k.Nicknames[nickname]; defined {
+		return nickname, nil
+	}
+	if target, isAlias := k.NicknameAliases()[nickname]; isAlias {
+		logger.Debugf("Nickname \"%s\" is an alias of nickname \"%s\".", nickname, target)
+		return target, nil
+	}
+	if !k.Preferences.AbbreviatedNicknames {
+		return nickname, nil
+	}
+
+	lowerNickname := strings.ToLower(nickname)
+	var sameCase, prefixed []string
+	for defined := range k.Nicknames {
+		lowerDefined := strings.ToLower(defined)
+		if lowerDefined == lowerNickname {
+			sameCase = append(sameCase, defined)
+		} else if strings.HasPrefix(lowerDefined, lowerNickname) {
+			prefixed = append(prefixed, defined)
+		}
+	}
+	matches := sameCase
+	if len(matches) == 0 {
+		matches = prefixed
+	}
+	switch len(matches) {
+	case 0:
+		return nickname, nil
+	case 1:
+		logger.Debugf("Expanded nickname \"%s\" to \"%s\".", nickname, matches[0])
+		return matches[0], nil
+	}
+	sort.Strings(matches)
+	return "", codedErrorf(ErrorCodeAmbiguousNickname, "Nickname \"%s\" is ambiguous.  It matches: %s", nickname, strings.Join(matches, ", "))
+}
+
+// ExpandNickname expands the nickname given on the command line, like the method of the same
+// name.  If it's ambiguous, the process is exited with an error message that lists the matches.
+func ExpandNickname(nickname string) string {
+	expanded, err := GetKconfig().ExpandNickname(nickname)
+	exitOnError(err)
+	return expanded
+}
+
+// MaxNicknameSuggestions is the most nicknames suggested in place of one that isn't defined.
+const MaxNicknameSuggestions = 3
+
+// AddNicknameSuggestions adds the defined nicknames that are close to the nickname to the error
+// from resolving it, if the nickname isn't defined, in case it was mistyped.  The error's code is
+// kept.
+func (k *Kconfig) AddNicknameSuggestions(nickname string, err error) error {
+	_, defined := k.Nicknames[nickname]
+	_, isAlias := k.NicknameAliases()[nickname]
+	if defined || isAlias || GetErrorCode(err) != ErrorCodeUnknownNickname {
+		return err
+	}
+	suggestions := k.SuggestNicknames(nickname, MaxNicknameSuggestions)
+	if len(suggestions) == 0 {
+		return err
+	}
+	return fmt.Errorf("%w\nDid you mean: %s?", err, strings.Join(suggestions, ", "))
+}
+
+// SuggestNicknames returns the defined nicknames that are close to a nickname that isn't defined,
+// closest first, for suggesting what might have been meant.  A nickname is close if it's only a
+// few edits away, or if it contains the nickname.  At most count are returned.
+func (k *Kconfig) SuggestNicknames(nickname string, count int) []string {
+	type candidate struct {
+		nickname string
+		distance int
+	}
+	var candidates []candidate
+	limit := len(nickname) / 3
+	if limit < 1 {
+		limit = 1
+	}
+	lowerNickname := strings.ToLower(nickname)
+	for defined := range k.Nicknames {
+		distance := editDistance(lowerNickname, strings.ToLower(defined))
+		if distance > limit && !(len(nickname) > 1 && strings.Contains(strings.ToLower(defined), lowerNickname)) {
+			continue
+		}
+		candidates = append(candidates, candidate{defined, distance})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].nickname < candidates[j].nickname
+	})
+
+	var suggestions []string
+	for _, c := range candidates {
+		if len(suggestions) == count {
+			break
+		}
+		suggestions = append(suggestions, c.nickname)
+	}
+	return suggestions
+}
+
+// editDistance returns the number of single character insertions, deletions, substitutions, and
+// swaps of adjacent characters it takes to turn one string into the other.  Swaps are counted as
+// one edit because they're such a common typo.
+func editDistance(a string, b string) int {
+	ar, br := []rune(a), []rune(b)
+	d := make([][]int, len(ar)+1)
+	for i := range d {
+		d[i] = make([]int, len(br)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			d[i][j] = d[i-1][j-1] + cost
+			if d[i-1][j]+1 < d[i][j] {
+				d[i][j] = d[i-1][j] + 1
+			}
+			if d[i][j-1]+1 < d[i][j] {
+				d[i][j] = d[i][j-1] + 1
+			}
+			if i > 1 && j > 1 && ar[i-1] == br[j-2] && ar[i-2] == br[j-1] && d[i-2][j-2]+1 < d[i][j] {
+				d[i][j] = d[i-2][j-2] + 1
+			}
+		}
+	}
+	return d[len(ar)][len(br)]
+}
+
+// HasTag says whether the resolved nickname has the tag.
+func (r *NicknameResolution) HasTag(tag string) bool {
+	for _, nicknameTag := range r.Tags {
+		if nicknameTag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// PluginDirectories returns the directories named by the --plugin-path option, for the kubectl
+// executable at the path.  Relative directories are taken to be relative to the directory of the
+// executable, so plugins can be installed alongside it.
+func (r *NicknameResolution) PluginDirectories(kubectlPath string) []string {
+	var directories []string
+	for _, directory := range filepath.SplitList(r.PluginPath) {
+		if directory == "" {
+			continue
+		}
+		if !filepath.IsAbs(directory) {
+			directory = filepath.Join(filepath.Dir(kubectlPath), directory)
+		}
+		directories = append(directories, directory)
+	}
+	return directories
+}
+
+// NicknameHasTags says whether the nickname has all of the tags, from the --tag options of its
+// definition or the definitions it extends.  A nickname that can't be resolved has no tags.
+func (k *Kconfig) NicknameHasTags(nickname string, tags []string) bool {
+	if len(tags) == 0 {
+		return true
+	}
+
+	resolution, err := k.ResolveNickname(nickname)
+	if err != nil {
+		logger.Debugf("Unable to resolve nickname \"%s\" for its tags: %v", nickname, err)
+		return false
+	}
+	for _, tag := range tags {
+		if !resolution.HasTag(tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// NicknameDescription returns the description of the nickname from the nickname_descriptions
+// block, on a single line, or an empty string if it doesn't have one.  Unlike tags, descriptions
+// aren't inherited by nicknames that extend the nickname.
+func (k *Kconfig) NicknameDescription(nickname string) string {
+	return strings.Join(strings.Fields(k.NicknameDescriptions[nickname]), " ")
+}
+
+// ResolveNickname looks up the nickname's definition and follows any chain of --extends options.
+// Options in a definition override those of the definition it extends.  If the nickname (or one it
+// extends) isn't defined, if the chain contains a cycle, or if the chain is longer than
+// MaxNicknameChainLength, the process is exited with an error message that shows the chain.
+func ResolveNickname(nickname string) *NicknameResolution {
+	resolution, err := GetKconfig().ResolveNickname(nickname)
+	exitOnError(err)
+	return resolution
+}
+
+// ResolveNickname resolves the nickname using this kconfig configuration.  It's like the function
+// of the same name, except that errors are returned.
+func (k *Kconfig) ResolveNickname(nickname string) (*NicknameResolution, error) {
+	resolution := &NicknameResolution{
+		Options: &KconfigOptions{},
+		OIDC:    &OIDCSettings{},
+	}
+
+	var kubectlExecutable string
+	environment := make(map[string]string)
+	var chainedOptions []*KconfigOptions
+	var chainedOIDCSettings []*OIDCSettings
+	for current := nickname; current != ""; {
+		for _, seen := range resolution.Chain {
+			if seen == current {
+				return nil, fmt.Errorf("Nickname \"%s\" has an --extends cycle: %s", nickname, formatNicknameChain(append(resolution.Chain, current)))
+			}
+		}
+
+		if len(resolution.Chain) == MaxNicknameChainLength {
+			return nil, fmt.Errorf("Nickname \"%s\" has an --extends chain longer than %d nicknames: %s ...", nickname, MaxNicknameChainLength, formatNicknameChain(resolution.Chain))
+		}
+
+		defn, exists := k.lookupNickname(current)
+		if target, isAlias := k.NicknameAliases()[current]; !exists && isAlias {
+			logger.Debugf("Nickname \"%s\" is an alias of nickname \"%s\".", current, target)
+			current = target
+			defn, exists = k.lookupNickname(current)
+		}
+		if !exists {
+			if len(resolution.Chain) == 0 {
+				return nil, codedErrorf(ErrorCodeUnknownNickname, "Nickname \"%s\" is not defined.", current)
+			}
+			return nil, codedErrorf(ErrorCodeUnknownNickname, "Nickname \"%s\" is not defined.  It's referenced by: %s", current, formatNicknameChain(resolution.Chain))
+		}
+		logger.Debugf("The definition is nickname \"%s\" is: %s", current, defn)
+
+		resolution.Chain = append(resolution.Chain, current)
+		resolution.Definitions = append(resolution.Definitions, defn)
+
+		definitionOptions, definitionExecutable, err := parseNicknameDefinition(defn)
+		if err != nil {
+			return nil, err
+		}
+		chainedOptions = append(chainedOptions, &definitionOptions.KconfigOptions)
+		chainedOIDCSettings = append(chainedOIDCSettings, &OIDCSettings{
+			Issuer:   definitionOptions.OIDCIssuer,
+			ClientID: definitionOptions.OIDCClientID,
+			Scopes:   definitionOptions.OIDCScopes,
+		})
+		if definitionOptions.Danger {
+			resolution.Danger = true
+		}
+		if definitionOptions.ConfirmMutations {
+			resolution.ConfirmMutations = true
+		}
+		if definitionOptions.ReadOnly {
+			resolution.ReadOnly = true
+		}
+		if definitionOptions.OpenShift {
+			resolution.OpenShift = true
+		}
+		if definitionOptions.OCLogin {
+			resolution.OCLogin = true
+		}
+		for _, tag := range definitionOptions.Tags {
+			if !resolution.HasTag(tag) {
+				resolution.Tags = append(resolution.Tags, tag)
+			}
+		}
+		if kubectlExecutable == "" {
+			kubectlExecutable = definitionExecutable
+		}
+		if resolution.PluginPath == "" {
+			resolution.PluginPath = definitionOptions.PluginPath
+		}
+		if resolution.KeychainToken == "" {
+			resolution.KeychainToken = definitionOptions.KeychainToken
+		}
+		if resolution.VaultPath == "" {
+			resolution.VaultPath = definitionOptions.VaultPath
+		}
+		if resolution.RequestTimeout == "" {
+			resolution.RequestTimeout = definitionOptions.RequestTimeout
+		}
+		err = addDefinitionEnvironment(environment, definitionOptions, k.NicknameEnv[current])
+		if err != nil {
+			return nil, fmt.Errorf("Nickname \"%s\" can't be used: %v", current, err)
+		}
+
+		current = definitionOptions.Extends
+	}
+
+	// Apply the options starting from the end of the chain, so that the definitions that extend
+	// others take precedence.
+	for idx := len(chainedOptions) - 1; idx >= 0; idx-- {
+		resolution.Options.Merge(chainedOptions[idx])
+		resolution.OIDC.Merge(chainedOIDCSettings[idx])
+	}
+
+	err := checkRequestTimeout(resolution.RequestTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("Nickname \"%s\" can't be used: %v", nickname, err)
+	}
+	resolution.Environment = sortedEnvironment(environment)
+
+	resolution.ExplicitKubectl = kubectlExecutable != ""
+	if kubectlExecutable == "" {
+		kubectlExecutable = k.Preferences.DefaultKubectl
+		if kubectlExecutable == "" {
+			kubectlExecutable = "kubectl"
+		}
+	}
+	if strings.HasPrefix(kubectlExecutable, managedKubectlPrefix) {
+		resolution.KubectlVersion = strings.TrimPrefix(strings.TrimPrefix(kubectlExecutable, managedKubectlPrefix), "v")
+		kubectlExecutable, err = k.ManagedKubectlFilename(resolution.KubectlVersion)
+		if err != nil {
+			return nil, fmt.Errorf("Nickname \"%s\" names an unrecognized kubectl version: %v", nickname, err)
+		}
+	}
+	resolution.KubectlExecutable = kubectlExecutable
+
+	logger.Debugf("Resolved nickname \"%s\" through chain %v.  kubectl executable is \"%s\".  Options are: %#v", nickname, resolution.Chain, kubectlExecutable, *resolution.Options)
+	return resolution, nil
+}
+
+// checkCredentialOptions checks the options of the resolved definition that say where the
+// credentials come from:  the --oidc-* options have to be complete, and only one source can be
+// given.
+func (r *NicknameResolution) checkCredentialOptions() error {
+	if r.OIDC.IsSet() {
+		err := r.OIDC.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	credentialSources := 0
+	for _, isSet := range []bool{r.OIDC.IsSet(), r.KeychainToken != "", r.VaultPath != ""} {
+		if isSet {
+			credentialSources++
+		}
+	}
+	if credentialSources > 1 {
+		return errors.New("Only one of the --oidc-*, --keychain-token, and --vault-path options can be used.")
+	}
+	return nil
+}
+
+// Merge copies the options that are set in other to this set of options, replacing any existing
+// values.
+func (o *KconfigOptions) Merge(other *KconfigOptions) {
+	if other.KubeConfig != "" {
+		o.KubeConfig = other.KubeConfig
+	}
+	if other.PreferFile != "" {
+		o.PreferFile = other.PreferFile
+	}
+	if other.Context != "" {
+		o.Context = other.Context
+	}
+	if other.Namespace != "" {
+		o.Namespace = other.Namespace
+	}
+	if other.User != "" {
+		o.User = other.User
+	}
+	if other.Cluster != "" {
+		o.Cluster = other.Cluster
+	}
+	if other.TeleportProxy != "" {
+		o.TeleportProxy = other.TeleportProxy
+	}
+	if other.As != "" {
+		o.As = other.As
+	}
+	if len(other.AsGroups) > 0 {
+		o.AsGroups = other.AsGroups
+	}
+	if other.Server != "" {
+		o.Server = other.Server
+	}
+	if other.CertificateAuthority != "" {
+		o.CertificateAuthority = other.CertificateAuthority
+	}
+	if other.InsecureSkipTLSVerify {
+		o.InsecureSkipTLSVerify = true
+	}
+	if other.TLSServerName != "" {
+		o.TLSServerName = other.TLSServerName
+	}
+	if other.SelfContained {
+		o.SelfContained = true
+	}
+	o.Set = append(o.Set, other.Set...)
+}
+
+// Args returns the command-line arguments that express the options that are set.
+func (o *KconfigOptions) Args() []string {
+	var args []string
+	if o.KubeConfig != "" {
+		args = append(args, "--kubeconfig", o.KubeConfig)
+	}
+	if o.PreferFile != "" {
+		args = append(args, "--prefer-file", o.PreferFile)
+	}
+	if o.Context != "" {
+		args = append(args, "--context", o.Context)
+	}
+	if o.Namespace != "" {
+		args = append(args, "-n", o.Namespace)
+	}
+	if o.User != "" {
+		args = append(args, "--user", o.User)
+	}
+	if o.Cluster != "" {
+		args = append(args, "--cluster", o.Cluster)
+	}
+	if o.TeleportProxy != "" {
+		args = append(args, "--teleport-proxy", o.TeleportProxy)
+	}
+	if o.As != "" {
+		args = append(args, "--as", o.As)
+	}
+	for _, group := range o.AsGroups {
+		args = append(args, "--as-group", group)
+	}
+	if o.Server != "" {
+		args = append(args, "--server", o.Server)
+	}
+	if o.CertificateAuthority != "" {
+		args = append(args, "--certificate-authority", o.CertificateAuthority)
+	}
+	if o.InsecureSkipTLSVerify {
+		args = append(args, "--insecure-skip-tls-verify")
+	}
+	if o.TLSServerName != "" {
+		args = append(args, "--tls-server-name", o.TLSServerName)
+	}
+	if o.SelfContained {
+		args = append(args, "--self-contained")
+	}
+	for _, set := range o.Set {
+		args = append(args, "--set", set)
+	}
+	return args
+}
+
+// OverridesDescription returns the short description of the override options that are set, like
+// "ns=foo,u=admin", that kset shows in the shell prompt.  It's an empty string if none are set.
+func (o *KconfigOptions) OverridesDescription() string {
+	var overrides []string
+	if o.Namespace != "" {
+		overrides = append(overrides, fmt.Sprintf("ns=%s", o.Namespace))
+	}
+	if o.Cluster != "" {
+		overrides = append(overrides, fmt.Sprintf("c=%s", o.Cluster))
+	}
+	if o.Server != "" {
+		overrides = append(overrides, fmt.Sprintf("server=%s", o.Server))
+	}
+	if o.CertificateAuthority != "" {
+		overrides = append(overrides, fmt.Sprintf("ca=%s", o.CertificateAuthority))
+	}
+	if o.InsecureSkipTLSVerify {
+		overrides = append(overrides, "insecure")
+	}
+	if o.TLSServerName != "" {
+		overrides = append(overrides, fmt.Sprintf("tls=%s", o.TLSServerName))
+	}
+	if o.User != "" {
+		overrides = append(overrides, fmt.Sprintf("u=%s", o.User))
+	}
+	if o.As != "" {
+		overrides = append(overrides, fmt.Sprintf("as=%s", o.As))
+	}
+	for _, group := range o.AsGroups {
+		overrides = append(overrides, fmt.Sprintf("as-group=%s", group))
+	}
+	for _, set := range o.Set {
+		path, _, _ := strings.Cut(set, "=")
+		overrides = append(overrides, fmt.Sprintf("set=%s", path))
+	}
+	return strings.Join(overrides, ",")
+}
+
+// hasClusterOptions says whether any of the options that change how the API server is reached are
+// set.
+func (o *KconfigOptions) hasClusterOptions() bool {
+	return o.Server != "" || o.CertificateAuthority != "" || o.InsecureSkipTLSVerify || o.TLSServerName != ""
+}
+
+// applyClusterOptions changes the kubectl cluster according to the options that change how the API
+// server is reached.  Since kubectl doesn't allow a certificate authority along with the
+// --insecure-skip-tls-verify option, that option takes precedence and any certificate authority is
+// dropped.
+func (o *KconfigOptions) applyClusterOptions(cluster *clientcmdapi.Cluster) error {
+	if o.Server != "" {
+		cluster.Server = o.Server
+	}
+	if o.CertificateAuthority != "" {
+		certificateAuthority, err := filepath.Abs(o.CertificateAuthority)
+		if err != nil {
+			return fmt.Errorf("Unable to determine the absolute path of \"%s\": %v", o.CertificateAuthority, err)
+		}
+		cluster.CertificateAuthority = certificateAuthority
+		cluster.CertificateAuthorityData = nil
+		cluster.InsecureSkipTLSVerify = false
+	}
+	if o.InsecureSkipTLSVerify {
+		cluster.InsecureSkipTLSVerify = true
+		cluster.CertificateAuthority = ""
+		cluster.CertificateAuthorityData = nil
+	}
+	if o.TLSServerName != "" {
+		cluster.TLSServerName = o.TLSServerName
+	}
+	return nil
+}
+
+func formatNicknameChain(chain []string) string {
+	return strings.Join(chain, " -> ")
+}
+
+// renameExtendedNickname returns the nickname definition with any --extends option that names the
+// old nickname changed to name the new one, and whether it was changed.
+func renameExtendedNickname(definition string, oldNickname string, newNickname string) (string, bool) {
+	return replaceOptionValue(definition, "--extends", oldNickname, newNickname)
+}
+
+// replaceOptionValue returns the nickname definition with the option changed to have the new value
+// wherever it has the old one, and whether it was changed.  A changed definition is put back
+// together from its arguments, quoting those that need it.
+func replaceOptionValue(definition string, option string, oldValue string, newValue string) (string, bool) {
+	defnArgs, err := shlex.Split(definition)
+	if err != nil {
+		return definition, false
+	}
+
+	changed := false
+	for idx, arg := range defnArgs {
+		if arg == option+"="+oldValue {
+			defnArgs[idx] = option + "=" + newValue
+			changed = true
+		} else if arg == option && idx+1 < len(defnArgs) && defnArgs[idx+1] == oldValue {
+			defnArgs[idx+1] = newValue
+			changed = true
+		}
+	}
+	if !changed {
+		return definition, false
+	}
+
+	quoted := make([]string, 0, len(defnArgs))
+	for _, arg := range defnArgs {
+		quoted = append(quoted, QuoteDefinitionArg(arg))
+	}
+	return strings.Join(quoted, " "), true
+}
+
+// QuoteDefinitionArg quotes an argument of a nickname definition with single quotes, if it contains
+// characters that would otherwise be interpreted when the definition is parsed.  It's for putting
+// together definitions from values like file names.
+func QuoteDefinitionArg(arg string) string {
+	if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.,:/=@+%$") == "" {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
@@ -0,0 +1,434 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// oidcUserName is the name of the kubectl user that the local kubectl config file defines for a
+// nickname with OIDC settings.
+const oidcUserName = "kconfig_oidc_user"
+
+// DefaultOIDCScopes are the scopes requested when a nickname's definition doesn't name any.  The
+// offline_access scope asks for a refresh token, so logins aren't needed as often.
+const DefaultOIDCScopes = "openid,offline_access"
+
+// oidcTokenExpirySlack is how long before its expiration a cached ID token is considered expired,
+// so that it doesn't expire while a kubectl command is using it.
+const oidcTokenExpirySlack = time.Minute
+
+// oidcRequestTimeout limits how long individual requests to the OIDC provider can take.
+const oidcRequestTimeout = 30 * time.Second
+
+// OIDCSettings describes the OpenID Connect provider that kconfig logs in to, on behalf of kubectl,
+// for a nickname whose definition has the --oidc-issuer option.
+type OIDCSettings struct {
+	// Issuer is the URL of the OIDC provider.
+	Issuer string
+
+	// ClientID is the OAuth client ID registered with the provider for the cluster.
+	ClientID string
+
+	// Scopes is a comma-separated list of the scopes to request.  The "openid" scope is always
+	// requested.
+	Scopes string
+}
+
+// Merge copies the settings that are set in other to these settings, replacing any existing values.
+func (s *OIDCSettings) Merge(other *OIDCSettings) {
+	if other.Issuer != "" {
+		s.Issuer = other.Issuer
+	}
+	if other.ClientID != "" {
+		s.ClientID = other.ClientID
+	}
+	if other.Scopes != "" {
+		s.Scopes = other.Scopes
+	}
+}
+
+// IsSet says whether any of the settings are set.
+func (s *OIDCSettings) IsSet() bool {
+	return s.Issuer != "" || s.ClientID != "" || s.Scopes != ""
+}
+
+// Validate checks that the settings are complete.
+func (s *OIDCSettings) Validate() error {
+	if s.Issuer == "" || s.ClientID == "" {
+		return fmt.Errorf("Both the --oidc-issuer and --oidc-client-id options are needed to log in with OIDC.")
+	}
+	return nil
+}
+
+// scopeList returns the scopes to request, always including "openid".
+func (s *OIDCSettings) scopeList() []string {
+	scopes := s.Scopes
+	if scopes == "" {
+		scopes = DefaultOIDCScopes
+	}
+
+	list := []string{"openid"}
+	for _, scope := range strings.Split(scopes, ",") {
+		scope = strings.TrimSpace(scope)
+		if scope != "" && scope != "openid" {
+			list = append(list, scope)
+		}
+	}
+	return list
+}
+
+// oidcAuthInfo returns the kubectl user that runs "kconfig-util oidc-token" as an exec credential
+// plugin to get an ID token for the settings.
+func oidcAuthInfo(settings *OIDCSettings) *clientcmdapi.AuthInfo {
+	authInfo := clientcmdapi.NewAuthInfo()
+	authInfo.Exec = &clientcmdapi.ExecConfig{
+		APIVersion: "client.authentication.k8s.io/v1",
+		Command:    "kconfig-util",
+		Args: []string{"oidc-token",
+			"--issuer", settings.Issuer,
+			"--client-id", settings.ClientID,
+			"--scopes", strings.Join(settings.scopeList(), ",")},
+		InstallHint:     "kconfig-util is installed with kconfig.  Make sure it's on your PATH.",
+		InteractiveMode: clientcmdapi.IfAvailableExecInteractiveMode,
+	}
+	return authInfo
+}
+
+// OIDCToken is an ID token obtained from an OIDC provider, as cached in the kconfig cache
+// directory.
+type OIDCToken struct {
+	IDToken      string    `json:"id_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+// oidcProviderMetadata holds the parts of the provider's discovery document that kconfig uses.
+type oidcProviderMetadata struct {
+	TokenEndpoint               string `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+}
+
+// oidcTokenResponse is the response from the provider's token endpoint, successful or not.
+type oidcTokenResponse struct {
+	IDToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	Error        string `json:"error"`
+	Description  string `json:"error_description"`
+}
+
+// oidcDeviceAuthorization is the response from the provider's device authorization endpoint.
+type oidcDeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// GetKconfigCacheDirectory returns the name of the directory where kconfig caches credentials.
+func GetKconfigCacheDirectory() string {
+	return filepath.Join(getHomeDirectory(), ".kube", "kconfig-cache")
+}
+
+// oidcCacheFilename returns the name of the file where the token for the settings is cached.
+func oidcCacheFilename(settings *OIDCSettings) string {
+	key := sha256.Sum256([]byte(strings.Join([]string{settings.Issuer, settings.ClientID, strings.Join(settings.scopeList(), ",")}, "\n")))
+	return filepath.Join(GetKconfigCacheDirectory(), "oidc", hex.EncodeToString(key[:16])+".json")
+}
+
+// GetOIDCToken returns an unexpired ID token for the settings.  A cached token is used if possible.
+// Otherwise the cached refresh token, if any, is used to get a new one.  As a last resort, the user
+// is asked to log in with the OAuth device authorization flow, if interactive is true.  Messages
+// for the user are written to standard error.
+func GetOIDCToken(settings *OIDCSettings, interactive bool) (*OIDCToken, error) {
+	err := settings.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	cacheFilename := oidcCacheFilename(settings)
+	cached := readCachedOIDCToken(cacheFilename)
+	if cached != nil && time.Now().Add(oidcTokenExpirySlack).Before(cached.Expiry) {
+		logger.Debugf("Using cached OIDC token from \"%s\", which expires at %v.", cacheFilename, cached.Expiry)
+		return cached, nil
+	}
+
+	metadata, err := discoverOIDCProvider(settings.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	var token *OIDCToken
+	if cached != nil && cached.RefreshToken != "" {
+		logger.Debugf("Refreshing the OIDC token cached in \"%s\".", cacheFilename)
+		token, err = refreshOIDCToken(settings, metadata, cached.RefreshToken)
+		if err != nil {
+			logger.Debugf("Unable to refresh the OIDC token: %v", err)
+		}
+	}
+
+	if token == nil {
+		if !interactive {
+			return nil, codedErrorf(ErrorCodeAuthFailure, "A login to OIDC issuer \"%s\" is needed, but kconfig-util oidc-token isn't running interactively.  Run \"kconfig-util creds-status\" to log in.", settings.Issuer)
+		}
+		token, err = loginWithOIDCDeviceFlow(settings, metadata)
+		if err != nil {
+			return nil, NewCodedError(ErrorCodeAuthFailure, err)
+		}
+	}
+
+	err = writeCachedOIDCToken(cacheFilename, token)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: unable to cache the OIDC token in \"%s\": %v\n", cacheFilename, err)
+	}
+	return token, nil
+}
+
+// readCachedOIDCToken reads the cached token, returning nil if there isn't one or it can't be read.
+func readCachedOIDCToken(cacheFilename string) *OIDCToken {
+	contents, err := os.ReadFile(cacheFilename)
+	if err != nil {
+		return nil
+	}
+
+	var token OIDCToken
+	if json.Unmarshal(contents, &token) != nil || token.IDToken == "" {
+		return nil
+	}
+	return &token
+}
+
+// writeCachedOIDCToken writes the token to the cache file, which only the user can read.
+func writeCachedOIDCToken(cacheFilename string, token *OIDCToken) error {
+	contents, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(cacheFilename), 0700)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(cacheFilename, contents, 0600)
+}
+
+// discoverOIDCProvider fetches the provider's discovery document.
+func discoverOIDCProvider(issuer string) (*oidcProviderMetadata, error) {
+	discoveryURL := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	ctx, cancel := context.WithTimeout(context.Background(), oidcRequestTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("Requesting: %s", discoveryURL)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to reach OIDC issuer \"%s\": %v", issuer, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Request for %s failed with status %s", discoveryURL, response.Status)
+	}
+
+	var metadata oidcProviderMetadata
+	err = json.NewDecoder(response.Body).Decode(&metadata)
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding the discovery document of OIDC issuer \"%s\": %v", issuer, err)
+	}
+	if metadata.TokenEndpoint == "" {
+		return nil, fmt.Errorf("The discovery document of OIDC issuer \"%s\" doesn't name a token endpoint", issuer)
+	}
+	return &metadata, nil
+}
+
+// refreshOIDCToken uses the refresh token to get a new ID token.
+func refreshOIDCToken(settings *OIDCSettings, metadata *oidcProviderMetadata, refreshToken string) (*OIDCToken, error) {
+	response, err := postOIDCForm(metadata.TokenEndpoint, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+		"client_id":     {settings.ClientID},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var tokenResponse oidcTokenResponse
+	err = json.Unmarshal(response, &tokenResponse)
+	if err != nil {
+		return nil, err
+	}
+	if tokenResponse.Error != "" {
+		return nil, fmt.Errorf("%s: %s", tokenResponse.Error, tokenResponse.Description)
+	}
+
+	// Providers don't always issue a new refresh token.  Keep using the old one if not.
+	if tokenResponse.RefreshToken == "" {
+		tokenResponse.RefreshToken = refreshToken
+	}
+	return newOIDCToken(&tokenResponse)
+}
+
+// loginWithOIDCDeviceFlow asks the user to log in with the OAuth device authorization flow
+// (RFC 8628), in which the user visits a URL in a browser and enters a code, and waits for the
+// login to complete.
+func loginWithOIDCDeviceFlow(settings *OIDCSettings, metadata *oidcProviderMetadata) (*OIDCToken, error) {
+	if metadata.DeviceAuthorizationEndpoint == "" {
+		return nil, fmt.Errorf("OIDC issuer \"%s\" doesn't support the device authorization flow", settings.Issuer)
+	}
+
+	response, err := postOIDCForm(metadata.DeviceAuthorizationEndpoint, url.Values{
+		"client_id": {settings.ClientID},
+		"scope":     {strings.Join(settings.scopeList(), " ")},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var authorization oidcDeviceAuthorization
+	err = json.Unmarshal(response, &authorization)
+	if err != nil || authorization.DeviceCode == "" {
+		return nil, fmt.Errorf("Unexpected response from the device authorization endpoint of OIDC issuer \"%s\": %s", settings.Issuer, strings.TrimSpace(string(response)))
+	}
+
+	if authorization.VerificationURIComplete != "" {
+		fmt.Fprintf(os.Stderr, "To log in to %s, visit:\n    %s\nand confirm the code %s.\n", settings.Issuer, authorization.VerificationURIComplete, authorization.UserCode)
+	} else {
+		fmt.Fprintf(os.Stderr, "To log in to %s, visit:\n    %s\nand enter the code %s.\n", settings.Issuer, authorization.VerificationURI, authorization.UserCode)
+	}
+
+	interval := time.Duration(authorization.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	expiresIn := time.Duration(authorization.ExpiresIn) * time.Second
+	if expiresIn <= 0 {
+		expiresIn = 10 * time.Minute
+	}
+	deadline := time.Now().Add(expiresIn)
+
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+
+		response, err := postOIDCForm(metadata.TokenEndpoint, url.Values{
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+			"device_code": {authorization.DeviceCode},
+			"client_id":   {settings.ClientID},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var tokenResponse oidcTokenResponse
+		err = json.Unmarshal(response, &tokenResponse)
+		if err != nil {
+			return nil, fmt.Errorf("Error decoding the response of the token endpoint of OIDC issuer \"%s\": %v", settings.Issuer, err)
+		}
+
+		switch tokenResponse.Error {
+		case "":
+			fmt.Fprintln(os.Stderr, "Logged in.")
+			return newOIDCToken(&tokenResponse)
+		case "authorization_pending":
+			// Keep waiting
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, fmt.Errorf("The login to OIDC issuer \"%s\" failed: %s %s", settings.Issuer, tokenResponse.Error, tokenResponse.Description)
+		}
+	}
+
+	return nil, fmt.Errorf("The login to OIDC issuer \"%s\" wasn't completed in time", settings.Issuer)
+}
+
+// postOIDCForm posts the form to the provider endpoint and returns the body of the response.  The
+// token endpoint reports errors like "authorization_pending" with a 400 status, so those responses
+// are returned to the caller as long as they're JSON.
+func postOIDCForm(endpoint string, form url.Values) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), oidcRequestTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+
+	logger.Debugf("Posting to: %s", endpoint)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK && !strings.HasPrefix(response.Header.Get("Content-Type"), "application/json") {
+		return nil, fmt.Errorf("Request for %s failed with status %s: %s", endpoint, response.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// newOIDCToken returns the token described by a successful token endpoint response.  The
+// expiration is taken from the ID token itself, if possible.
+func newOIDCToken(tokenResponse *oidcTokenResponse) (*OIDCToken, error) {
+	if tokenResponse.IDToken == "" {
+		return nil, errors.New("The OIDC provider didn't return an ID token.  Make sure the openid scope is allowed.")
+	}
+
+	token := &OIDCToken{
+		IDToken:      tokenResponse.IDToken,
+		RefreshToken: tokenResponse.RefreshToken,
+		Expiry:       jwtExpiry(tokenResponse.IDToken),
+	}
+	if token.Expiry.IsZero() && tokenResponse.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(tokenResponse.ExpiresIn) * time.Second)
+	}
+	token.Expiry = token.Expiry.UTC().Truncate(time.Second)
+	return token, nil
+}
+
+// jwtExpiry returns the time in the "exp" claim of the JWT, or the zero time if it can't be
+// found.  The signature isn't checked, since the token came straight from the provider and is only
+// passed along to the API server, which checks it.
+func jwtExpiry(jwt string) time.Time {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if json.Unmarshal(payload, &claims) != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// openShiftProjectsPath is the API server path of the OpenShift projects that the user can see.
+// Unlike namespaces, users that aren't cluster administrators can list them.
+const openShiftProjectsPath = "/apis/project.openshift.io/v1/projects"
+
+// openShiftCurrentUserPath is the API server path of the OpenShift user that the credentials
+// belong to, which is only available with valid credentials.
+const openShiftCurrentUserPath = "/apis/user.openshift.io/v1/users/~"
+
+// isOpenShiftExecutable says whether the kubectl executable is the OpenShift client, oc, perhaps
+// with a version in its name like "oc-4.14".
+func isOpenShiftExecutable(executable string) bool {
+	name := strings.TrimSuffix(filepath.Base(executable), ".exe")
+	return name == "oc" || strings.HasPrefix(name, "oc-")
+}
+
+// listOpenShiftProjects asks the OpenShift API server for the names of the projects the user can
+// see, in place of namespaces.
+func (r *CreateConfigResults) listOpenShiftProjects() ([]string, error) {
+	var projectList struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}
+
+	err := r.getFromCluster(openShiftProjectsPath, &projectList)
+	if err != nil {
+		return nil, err
+	}
+
+	var projects []string
+	for _, item := range projectList.Items {
+		projects = append(projects, item.Metadata.Name)
+	}
+	return projects, nil
+}
+
+// NeedsOpenShiftLogin says whether the OpenShift API server rejects the credentials of the local
+// kubectl config file, such as because the token that "oc login" got has expired.
+func (r *CreateConfigResults) NeedsOpenShiftLogin() (bool, error) {
+	var user map[string]interface{}
+	err := r.getFromCluster(openShiftCurrentUserPath, &user)
+	var requestErr *clusterRequestError
+	if errors.As(err, &requestErr) && requestErr.statusCode == http.StatusUnauthorized {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// OpenShiftLogin runs "oc login" for the API server of the local kubectl config file, so the user
+// can get a new token.  It uses the kubectl config files of the search path, so the token is kept
+// where the nickname's user is defined, rather than in the local file.  Since its prompts mustn't
+// be taken for shell commands, its output goes to standard error.  "oc login" makes its own
+// context the current one, so the current context it replaced is restored afterward.
+func (r *CreateConfigResults) OpenShiftLogin() error {
+	server := r.ServerURL()
+	if server == "" {
+		return errors.New("There's no API server to log in to.")
+	}
+
+	var previousContext string
+	if kubeconfig, err := readKubeConfigFromSearchPath(r.SearchPath); err == nil {
+		previousContext = kubeconfig.CurrentContext
+	}
+
+	fmt.Fprintf(os.Stderr, "Logging in to %s with \"%s login\".\n", server, r.KubectlExecutable)
+	err := r.runOpenShiftClient("login", "--server="+server)
+	if err != nil {
+		return fmt.Errorf("Unable to log in to %s: %v", server, err)
+	}
+
+	GetKconfig().forgetKubeConfigs()
+
+	kubeconfig, err := readKubeConfigFromSearchPath(r.SearchPath)
+	if err == nil && previousContext != "" && kubeconfig.CurrentContext != previousContext {
+		err = r.runOpenShiftClient("config", "use-context", previousContext)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Unable to make \"%s\" the current context again after logging in: %v\n", previousContext, err)
+		}
+	}
+	return nil
+}
+
+// runOpenShiftClient runs the nickname's oc executable with the kubectl config search path, with
+// its output going to standard error.
+func (r *CreateConfigResults) runOpenShiftClient(args ...string) error {
+	cmd := exec.Command(r.KubectlExecutable, args...)
+	cmd.Env = append(os.Environ(), "KUBECONFIG="+r.SearchPath)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// openShiftProjectExists asks the OpenShift API server whether the user can see the project.
+func (r *CreateConfigResults) openShiftProjectExists(project string) (bool, error) {
+	var projectObject map[string]interface{}
+	err := r.getFromCluster(openShiftProjectsPath+"/"+url.PathEscape(project), &projectObject)
+	var requestErr *clusterRequestError
+	if errors.As(err, &requestErr) && (requestErr.statusCode == http.StatusNotFound || requestErr.statusCode == http.StatusForbidden) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
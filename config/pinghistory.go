@@ -0,0 +1,104 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// PingHistory describes the format of an entry in the file where the results of checking an API
+// server with "kconfig-util ping" are kept, by API server URL, so "kconfig-util prune" can tell
+// how long it's been unreachable.
+type PingHistory struct {
+	// LastReached is when the API server was last reached, even if it didn't accept the
+	// credentials.
+	LastReached time.Time `json:"last_reached,omitempty"`
+
+	// UnreachableSince is when the API server was first found to be unreachable after it was
+	// last reached, or zero if it was reachable the last time it was checked.
+	UnreachableSince time.Time `json:"unreachable_since,omitempty"`
+
+	// LastError describes the problem found the last time it was unreachable.
+	LastError string `json:"last_error,omitempty"`
+}
+
+func getPingHistoryFilename() string {
+	return filepath.Join(GetKconfigCacheDirectory(), "ping.json")
+}
+
+// readPingHistory reads the ping history file.  Problems are only logged, and an empty history is
+// returned.
+func readPingHistory() map[string]*PingHistory {
+	history := make(map[string]*PingHistory)
+	filename := getPingHistoryFilename()
+	contents, err := os.ReadFile(filename)
+	if err == nil {
+		err = json.Unmarshal(contents, &history)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Debugf("Unable to read the ping history in \"%s\": %v", filename, err)
+	}
+	return history
+}
+
+// GetPingHistory returns the ping history of the API server with the URL, or nil if it was never
+// checked.
+func GetPingHistory(serverURL string) *PingHistory {
+	return readPingHistory()[serverURL]
+}
+
+// RecordPing records the result of checking the API server with the URL in the ping history.  An
+// error means it's unreachable only if no response was received from it, since an API server that
+// rejects the credentials still exists.  Problems with the file are only logged.
+func RecordPing(serverURL string, pingErr error) {
+	if serverURL == "" {
+		return
+	}
+	history := readPingHistory()
+	entry := history[serverURL]
+	if entry == nil {
+		entry = &PingHistory{}
+		history[serverURL] = entry
+	}
+
+	now := time.Now().UTC()
+	if pingErr != nil && isUnreachableError(pingErr) {
+		if entry.UnreachableSince.IsZero() {
+			entry.UnreachableSince = now
+		}
+		entry.LastError = pingErr.Error()
+	} else {
+		entry.LastReached = now
+		entry.UnreachableSince = time.Time{}
+		entry.LastError = ""
+	}
+
+	filename := getPingHistoryFilename()
+	contents, err := json.Marshal(history)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(filename), 0700)
+	}
+	if err == nil {
+		err = writeFileAtomically(filename, contents, 0600)
+	}
+	if err != nil {
+		logger.Debugf("Unable to record the ping history in \"%s\": %v", filename, err)
+	}
+}
+
+// isUnreachableError says whether the error from a request for an API server means no response
+// was received from it, like a failed DNS lookup, a refused connection, or a timeout, rather than
+// a problem like a credential plugin that failed.
+func isUnreachableError(err error) bool {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(urlErr.Err, &netErr) || errors.Is(urlErr.Err, context.DeadlineExceeded)
+}
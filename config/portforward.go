@@ -0,0 +1,182 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// portForwardRecordFilename is the name of the file, in the directory of a port forward, that
+// records it.
+const portForwardRecordFilename = "forward.yaml"
+
+// PortForward records a "kubectl port-forward" process that the pf command started in the
+// background.  Each has a directory of its own in the port forward directory, which holds the
+// record, the kubectl config file the process uses, and its output.
+type PortForward struct {
+	// PID is the process ID of the kubectl process, which identifies the port forward.
+	PID int `yaml:"pid"`
+
+	// Nickname is the nickname whose cluster the port forward is for.
+	Nickname string `yaml:"nickname"`
+
+	// Namespace is the namespace of the resource.
+	Namespace string `yaml:"namespace,omitempty"`
+
+	// Resource is the pod or other resource that's forwarded to, like "svc/foo".
+	Resource string `yaml:"resource"`
+
+	// Args holds the other arguments given to "kubectl port-forward", like the ports.
+	Args []string `yaml:"args,omitempty"`
+
+	// Started is when the port forward was started.
+	Started time.Time `yaml:"started"`
+
+	// ProcessStarted is when the kubectl process started, as returned by ProcessStartTime(), so a
+	// process that's later given the same process ID isn't mistaken for it.
+	ProcessStarted string `yaml:"process_started,omitempty"`
+
+	// dir is the directory of the port forward.
+	dir string
+}
+
+// getPortForwardDirectory returns the directory that holds the directories of the port forwards
+// started by the pf command.
+func getPortForwardDirectory() string {
+	return filepath.Join(GetStateDirectory(), "port-forwards")
+}
+
+// NewPortForwardDirectory creates a directory, readable only by the user, for a port forward that's
+// about to be started, and returns its name.
+func NewPortForwardDirectory() (string, error) {
+	portForwardDir := getPortForwardDirectory()
+	err := makeStateDirectory()
+	if err == nil {
+		err = makePrivateDirectory(portForwardDir)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Unable to create directory \"%s\" for the port forward: %v", portForwardDir, err)
+	}
+	dir, err := os.MkdirTemp(portForwardDir, "pf-")
+	if err != nil {
+		return "", fmt.Errorf("Unable to create a directory for the port forward: %v", err)
+	}
+	return dir, nil
+}
+
+// SavePortForward records the port forward in its directory, which was returned by
+// NewPortForwardDirectory().
+func SavePortForward(dir string, portForward *PortForward) error {
+	portForward.dir = dir
+	contents, err := yaml.Marshal(portForward)
+	if err == nil {
+		err = writeFileAtomically(filepath.Join(dir, portForwardRecordFilename), contents, 0600)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to record the port forward: %v", err)
+	}
+	return nil
+}
+
+// ListPortForwards returns the recorded port forwards, sorted by when they were started.
+// Directories without a record, left behind by a pf command that failed, are skipped.
+func ListPortForwards() ([]*PortForward, error) {
+	entries, err := os.ReadDir(getPortForwardDirectory())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the port forward directory: %v", err)
+	}
+
+	var portForwards []*PortForward
+	for _, entry := range entries {
+		dir := filepath.Join(getPortForwardDirectory(), entry.Name())
+		contents, err := os.ReadFile(filepath.Join(dir, portForwardRecordFilename))
+		if err != nil {
+			continue
+		}
+		portForward := &PortForward{dir: dir}
+		err = yaml.Unmarshal(contents, portForward)
+		if err != nil {
+			logger.Debugf("Skipping the port forward in \"%s\": %v", dir, err)
+			continue
+		}
+		portForwards = append(portForwards, portForward)
+	}
+	sort.Slice(portForwards, func(i, j int) bool {
+		return portForwards[i].Started.Before(portForwards[j].Started)
+	})
+	return portForwards, nil
+}
+
+// PortForwardLogFilename returns the name of the file, in the directory of a port forward, that
+// holds the output of its process.
+func PortForwardLogFilename(dir string) string {
+	return filepath.Join(dir, "output.log")
+}
+
+// LogFilename returns the name of the file that holds the output of the port forward's process.
+func (p *PortForward) LogFilename() string {
+	return PortForwardLogFilename(p.dir)
+}
+
+// IsRunning says whether the port forward's process, or any process it started, is still running.
+// The process leads a session of its own, so its process group ID is its process ID.  Since the
+// process ID can be reused once the process group is gone, a process with that ID only counts if
+// it started when the recorded one did.  While processes it started are left in its group after
+// it exits, the ID isn't reused.
+func (p *PortForward) IsRunning() bool {
+	if syscall.Kill(-p.PID, 0) != nil {
+		return false
+	}
+	if err := syscall.Kill(p.PID, 0); errors.Is(err, syscall.ESRCH) {
+		return true
+	}
+	return p.ProcessStarted != "" && ProcessStartTime(p.PID) == p.ProcessStarted
+}
+
+// ProcessStartTime returns when the process with the ID started, as reported by the ps command,
+// or an empty string if that can't be determined.
+func ProcessStartTime(pid int) string {
+	cmd := exec.Command("ps", "-o", "lstart=", "-p", strconv.Itoa(pid))
+	cmd.Env = append(os.Environ(), "LC_ALL=C", "TZ=UTC")
+	output, err := cmd.Output()
+	if err != nil {
+		logger.Debugf("Unable to get the start time of process %d: %v", pid, err)
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// Stop ends the port forward's process, and any process it started, if they're still running, and
+// removes its directory.  A process that has been given its process ID since it exited is left
+// alone.
+func (p *PortForward) Stop() error {
+	if p.IsRunning() {
+		err := syscall.Kill(-p.PID, syscall.SIGTERM)
+		if err != nil && !errors.Is(err, syscall.ESRCH) {
+			return fmt.Errorf("Unable to stop the port forward with process ID %d: %v", p.PID, err)
+		}
+	}
+	return RemovePortForwardDirectory(p.dir)
+}
+
+// RemovePortForwardDirectory removes the directory of a port forward, along with the kubectl config
+// file and output in it.
+func RemovePortForwardDirectory(dir string) error {
+	err := os.RemoveAll(dir)
+	if err != nil {
+		return fmt.Errorf("Unable to remove the port forward directory \"%s\": %v", dir, err)
+	}
+	return nil
+}
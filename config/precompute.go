@@ -0,0 +1,240 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// precomputedFormat is part of the key of every precomputed file, so that a change to how the files
+// are generated keeps the old ones from being used.
+const precomputedFormat = "2"
+
+// precomputedManifestSuffix is appended to the key of a precomputed file to name the file that
+// describes it.
+const precomputedManifestSuffix = ".json"
+
+// precomputedManifest describes the format of the file that describes a precomputed kubectl config
+// file.  It records what the kubectl program needs to use the file without generating it again.
+type precomputedManifest struct {
+	Nickname          string `json:"nickname"`
+	SHA256            string `json:"sha256"`
+	SearchPath        string `json:"search_path"`
+	KubectlExecutable string `json:"kubectl_executable"`
+	TeleportProxy     string `json:"teleport_proxy,omitempty"`
+	PreferFile        string `json:"prefer_file,omitempty"`
+	SelfContained     bool   `json:"self_contained,omitempty"`
+
+	// EmbeddedFiles describes the versions of the files whose contents are embedded in a
+	// self-contained file.  They aren't part of the key, since they're only known once the file
+	// is generated, so the file is generated again if any of them has changed.
+	EmbeddedFiles []kubeconfigFileStamp `json:"embedded_files,omitempty"`
+}
+
+// precomputedKey returns the key that names the precomputed kubectl config file of the nickname:
+// a hash of its resolved definition, the preferences, and the versions of the kubectl config files
+// it's generated from.  Any change to those gives a different key, so a file that's out of date is
+// never used.  Only the files' metadata is read, which is fast even on slow file systems.
+func (k *Kconfig) precomputedKey(nickname string, resolution *NicknameResolution) (string, error) {
+	searchPath := k.baseSearchPath(resolution.Options, &KconfigOptions{})
+	preferences, err := json.Marshal(k.Preferences)
+	if err != nil {
+		return "", err
+	}
+	stamps, err := json.Marshal(getKubeconfigFileStamps(sourceFiles(searchPath, resolution.Options.PreferFile)))
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	parts := append([]string{precomputedFormat, nickname, searchPath, string(preferences), string(stamps)}, resolution.Definitions...)
+	for _, part := range parts {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil)[:16]), nil
+}
+
+// usePrecomputedFile returns the results for the precomputed kubectl config file with the key, if
+// there is one and its contents are what its manifest says they should be.  Otherwise it returns
+// nil.
+func usePrecomputedFile(key string) *CreateConfigResults {
+	filename := filepath.Join(getPrecomputedDirectory(), key+".yaml")
+	manifestContents, err := os.ReadFile(filepath.Join(getPrecomputedDirectory(), key+precomputedManifestSuffix))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Debugf("Unable to read the manifest of \"%s\": %v", filename, err)
+		}
+		return nil
+	}
+	var manifest precomputedManifest
+	err = json.Unmarshal(manifestContents, &manifest)
+	if err != nil {
+		logger.Debugf("Unable to parse the manifest of \"%s\": %v", filename, err)
+		return nil
+	}
+
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		logger.Debugf("Unable to read \"%s\": %v", filename, err)
+		return nil
+	}
+	sum := sha256.Sum256(contents)
+	if hex.EncodeToString(sum[:]) != manifest.SHA256 {
+		fmt.Fprintf(os.Stderr, "Warning: the precomputed kubectl config file \"%s\" has been changed, so it's being generated again.\n", filename)
+		return nil
+	}
+	if len(manifest.EmbeddedFiles) > 0 {
+		var embeddedFilenames []string
+		for _, stamp := range manifest.EmbeddedFiles {
+			embeddedFilenames = append(embeddedFilenames, stamp.Path)
+		}
+		if !kubeconfigFileStampsEqual(manifest.EmbeddedFiles, getKubeconfigFileStamps(embeddedFilenames)) {
+			logger.Debugf("A file embedded in \"%s\" has changed, so it's being generated again.", filename)
+			return nil
+		}
+	}
+
+	logger.Debugf("Using precomputed local config file: %s", filename)
+	results := &CreateConfigResults{
+		TeleportProxyEnvVar: manifest.TeleportProxy,
+		KubectlExecutable:   manifest.KubectlExecutable,
+		SearchPath:          manifest.SearchPath,
+		PreferFile:          manifest.PreferFile,
+		SelfContained:       manifest.SelfContained,
+	}
+	results.NewKubeconfigEnvVar = results.KubeconfigEnvVar(filename)
+	return results
+}
+
+// PrecomputeNicknameKubectlConfigFile makes sure the precomputed kubectl config file of the
+// nickname is up to date, generating it if it isn't, and returns the results for it.  It also
+// reports whether the file was generated.
+func (k *Kconfig) PrecomputeNicknameKubectlConfigFile(nickname string) (*CreateConfigResults, bool, error) {
+	resolution, err := k.ResolveNickname(nickname)
+	if err != nil {
+		return nil, false, err
+	}
+	key, err := k.precomputedKey(nickname, resolution)
+	if err != nil {
+		return nil, false, err
+	}
+	if results := usePrecomputedFile(key); results != nil {
+		linkNicknameFile(nickname, key)
+		return results, false, nil
+	}
+
+	results, err := k.ResolveLocalKubectlConfig(nickname, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	k.selectKubectl(results)
+
+	err = makeStateDirectory()
+	if err == nil {
+		err = makePrivateDirectory(getNicknameDirectory())
+	}
+	if err == nil {
+		err = makePrivateDirectory(getPrecomputedDirectory())
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("Unable to create the directory for precomputed kubectl config files: %v", err)
+	}
+
+	// The file is locked while it's written, so concurrent kubectl commands using the same
+	// nickname write it one at a time.
+	filename := filepath.Join(getPrecomputedDirectory(), key+".yaml")
+	unlock, err := lockFile(filename)
+	if err != nil {
+		return nil, false, err
+	}
+	defer unlock()
+	err = writeKubeconfigFile(filename, results)
+	var contents []byte
+	if err == nil {
+		contents, err = os.ReadFile(filename)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("Error creating the local kubectl configuration file \"%s\": %v", filename, err)
+	}
+
+	sum := sha256.Sum256(contents)
+	manifestContents, err := json.Marshal(&precomputedManifest{
+		Nickname:          nickname,
+		SHA256:            hex.EncodeToString(sum[:]),
+		SearchPath:        results.SearchPath,
+		KubectlExecutable: results.KubectlExecutable,
+		TeleportProxy:     results.TeleportProxyEnvVar,
+		PreferFile:        results.PreferFile,
+		SelfContained:     results.SelfContained,
+		EmbeddedFiles:     results.embeddedFiles,
+	})
+	if err == nil {
+		err = writeFileAtomically(filepath.Join(getPrecomputedDirectory(), key+precomputedManifestSuffix), manifestContents, 0600)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("Unable to write the manifest of \"%s\": %v", filename, err)
+	}
+	logger.Debugf("Precomputed local config file: %s", filename)
+
+	results.NewKubeconfigEnvVar = results.KubeconfigEnvVar(filename)
+	linkNicknameFile(nickname, key)
+	return results, true, nil
+}
+
+// linkNicknameFile makes the file named after the nickname in the nickname directory a symbolic
+// link to its precomputed file with the key, so tools can use the nickname's current file by a
+// name that doesn't change.  The precomputed file it linked to before is removed.  Since the
+// kubectl program doesn't need the link, problems are only logged.
+func linkNicknameFile(nickname string, key string) {
+	linkFilename := filepath.Join(getNicknameDirectory(), nickname+".yaml")
+	target := filepath.Join("precomputed", key+".yaml")
+	previous, err := os.Readlink(linkFilename)
+	if err == nil && previous == target {
+		return
+	}
+
+	tempFilename := fmt.Sprintf("%s.%d.tmp", linkFilename, os.Getpid())
+	err = os.Symlink(target, tempFilename)
+	if err == nil {
+		err = os.Rename(tempFilename, linkFilename)
+	}
+	if err != nil {
+		os.Remove(tempFilename)
+		logger.Debugf("Unable to link \"%s\" to its precomputed file: %v", linkFilename, err)
+		return
+	}
+	if previous != "" {
+		err = removePrecomputedFile(filepath.Join(getNicknameDirectory(), previous))
+		if err != nil {
+			logger.Debugf("Unable to remove the previous precomputed file \"%s\": %v", previous, err)
+		}
+	}
+}
+
+// removeNicknameFile removes the file named after a nickname in the nickname directory, along with
+// the precomputed file it links to.
+func removeNicknameFile(linkFilename string) error {
+	target, err := os.Readlink(linkFilename)
+	if err == nil {
+		err = removePrecomputedFile(filepath.Join(filepath.Dir(linkFilename), target))
+		if err != nil {
+			return err
+		}
+	}
+	return RemoveLocalKubectlConfigFile(linkFilename)
+}
+
+// removePrecomputedFile removes the precomputed kubectl config file, along with its manifest.
+func removePrecomputedFile(filename string) error {
+	err := os.Remove(strings.TrimSuffix(filename, ".yaml") + precomputedManifestSuffix)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return RemoveLocalKubectlConfigFile(filename)
+}
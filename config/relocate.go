@@ -0,0 +1,128 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// RelocateSessionFiles moves the session-local kubectl config files that kset created in the
+// directory, such as the one that held them before the session_dir preference was set, to the
+// session directory.  Each is replaced by a symbolic link to its new location, so the shells whose
+// KUBECONFIG environment variable still names it keep working.  The files are copied, since the
+// directories are usually on different file systems, along with the record of their sources and
+// any encrypted credentials.  The new names of the files moved are returned, keyed by their old
+// names.  Files that were already moved, or that don't look like they were created by kset, are
+// left alone.
+func RelocateSessionFiles(fromDir string) (map[string]string, error) {
+	fromDir, err := filepath.Abs(fromDir)
+	if err != nil {
+		return nil, err
+	}
+	toDir := getSessionDirectory()
+	if fromDir == toDir {
+		return nil, fmt.Errorf("The session-local kubectl config files are already in \"%s\".", toDir)
+	}
+
+	filenames, err := filepath.Glob(filepath.Join(fromDir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	moved := make(map[string]string)
+	for _, filename := range filenames {
+		info, err := os.Lstat(filename)
+		if err != nil || info.Mode()&os.ModeSymlink != 0 || checkSessionLocalFileIn(fromDir, filename) != nil {
+			continue
+		}
+		if len(moved) == 0 {
+			_, err = makeSessionDirectory()
+			if err != nil {
+				return moved, fmt.Errorf("Unable to create directory \"%s\" for the session-local kubectl config files: %v", toDir, err)
+			}
+		}
+
+		newFilename, err := relocateSessionFile(filename, toDir)
+		if err != nil {
+			return moved, fmt.Errorf("Unable to move \"%s\" to \"%s\": %v", filename, toDir, err)
+		}
+		moved[filename] = newFilename
+		logger.Debugf("Moved session-local config file \"%s\" to \"%s\"", filename, newFilename)
+	}
+	return moved, nil
+}
+
+// relocateSessionFile moves a session-local kubectl config file to the directory, keeping its name
+// unless another file there already has it, and returns its new name.
+func relocateSessionFile(filename string, toDir string) (string, error) {
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+
+	newFilename := filepath.Join(toDir, filepath.Base(filename))
+	if _, err := os.Lstat(newFilename); err == nil {
+		newFilename = createSessionKubeconfigFile(toDir)
+	}
+
+	// The users whose credentials are encrypted name the file that holds them in the arguments of
+	// their exec credential plugin, so they're changed to name the new one.
+	credentials, err := os.ReadFile(filename + encryptedCredentialsSuffix)
+	if err == nil {
+		contents, err = renameCredentialsFile(contents, filename+encryptedCredentialsSuffix, newFilename+encryptedCredentialsSuffix)
+		if err == nil {
+			err = writeFileAtomically(newFilename+encryptedCredentialsSuffix, credentials, 0600)
+		}
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	if sources, err := os.ReadFile(filename + kubeconfigSourcesSuffix); err == nil {
+		err = writeFileAtomically(newFilename+kubeconfigSourcesSuffix, sources, 0600)
+		if err != nil {
+			return "", err
+		}
+	}
+	err = writeFileAtomically(newFilename, contents, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	err = RemoveLocalKubectlConfigFile(filename)
+	if err == nil {
+		err = os.Symlink(newFilename, filename)
+	}
+	if err != nil {
+		return "", err
+	}
+	return newFilename, nil
+}
+
+// renameCredentialsFile returns the contents of a local kubectl config file, with the users that
+// run "kconfig-util decrypt-credentials" to get their credentials from the file named oldFilename
+// changed to get them from newFilename.
+func renameCredentialsFile(contents []byte, oldFilename string, newFilename string) ([]byte, error) {
+	kubeconfig, err := clientcmd.Load(contents)
+	if err != nil {
+		return nil, err
+	}
+	for _, authInfo := range kubeconfig.AuthInfos {
+		if authInfo.Exec != nil && len(authInfo.Exec.Args) > 1 && authInfo.Exec.Args[0] == "decrypt-credentials" && authInfo.Exec.Args[1] == oldFilename {
+			authInfo.Exec.Args[1] = newFilename
+		}
+	}
+	return clientcmd.Write(*kubeconfig)
+}
+
+// RelocatedSessionFile returns the session-local kubectl config file in the session directory
+// that the symbolic link left behind by RelocateSessionFiles() refers to, or an empty string if
+// the file isn't such a link.
+func RelocatedSessionFile(filename string) string {
+	target, err := os.Readlink(filename)
+	if err != nil || CheckSessionLocalFile(target) != nil {
+		return ""
+	}
+	return target
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// checkRestrictions enforces the allowed_contexts, denied_contexts, allowed_clusters, and
+// denied_clusters preferences for the context and cluster that the nickname resolves to.  The
+// cluster patterns are matched against both the cluster's name and the URL of its API server, so a
+// --server option can't get around them:  a deny list refuses the cluster if either one matches,
+// and an allow list only allows it if both do.  An invalid pattern is an error rather than a
+// warning, so that a mistake in the preferences doesn't silently lift the restrictions.
+func (k *Kconfig) checkRestrictions(nickname string, contextName string, clusterName string, serverURL string) error {
+	restrictions := []struct {
+		preference string
+		patterns   []string
+		allow      bool
+		kind       string
+		values     []string
+	}{
+		{"denied_contexts", k.Preferences.DeniedContexts, false, "context", []string{contextName}},
+		{"allowed_contexts", k.Preferences.AllowedContexts, true, "context", []string{contextName}},
+		{"denied_clusters", k.Preferences.DeniedClusters, false, "cluster", []string{clusterName, serverURL}},
+		{"allowed_clusters", k.Preferences.AllowedClusters, true, "cluster", []string{clusterName, serverURL}},
+	}
+
+	for _, restriction := range restrictions {
+		if len(restriction.patterns) == 0 {
+			continue
+		}
+		var refused string
+		var err error
+		if restriction.allow {
+			refused, err = unmatchedValue(restriction.patterns, restriction.values)
+		} else {
+			refused, err = matchPatterns(restriction.patterns, restriction.values)
+		}
+		if err != nil {
+			return fmt.Errorf("Invalid pattern in the %s preference: %v", restriction.preference, err)
+		}
+		if refused != "" {
+			return codedErrorf(ErrorCodeRestricted, "Nickname \"%s\" can't be used: %s \"%s\" isn't allowed by the %s preference.",
+				nickname, restriction.kind, refused, restriction.preference)
+		}
+	}
+
+	return nil
+}
+
+// unmatchedValue returns the first of the nonempty values that doesn't entirely match any of the
+// regular expressions, or "" if they all do.
+func unmatchedValue(patterns []string, values []string) (string, error) {
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		matched, err := matchPatterns(patterns, []string{value})
+		if err != nil {
+			return "", err
+		}
+		if matched == "" {
+			return value, nil
+		}
+	}
+	return "", nil
+}
+
+// matchPatterns returns the first of the nonempty values that entirely matches any of the regular
+// expressions, or "" if none does.
+func matchPatterns(patterns []string, values []string) (string, error) {
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return "", err
+		}
+		for _, value := range values {
+			if value != "" && re.MatchString(value) {
+				return value, nil
+			}
+		}
+	}
+	return "", nil
+}
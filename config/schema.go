@@ -0,0 +1,228 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// KconfigAPIVersion is the version of the kconfig.yaml format that this version of kconfig
+	// understands, as given by the optional apiVersion entry at the top of the file.  A file
+	// without one is taken to be in this format.
+	KconfigAPIVersion = "kconfig/v1"
+
+	// KconfigKind is the kind given by the optional kind entry at the top of kconfig.yaml.
+	KconfigKind = "Kconfig"
+)
+
+// kconfigAPIVersionRegexp matches a value of the apiVersion entry, capturing the version number.
+var kconfigAPIVersionRegexp = regexp.MustCompile(`^kconfig/v([0-9]+)$`)
+
+// unknownFieldRegexp matches the error that the YAML decoder reports for an entry that doesn't
+// correspond to a field, capturing the line number, the entry, and the Go type being decoded.
+var unknownFieldRegexp = regexp.MustCompile(`^line ([0-9]+): field (.+) not found in type config\.(\w+)$`)
+
+// kconfigMigration changes a kconfig.yaml file from one version of its format to the next.
+type kconfigMigration struct {
+	// from is the version the migration starts from, with "" for a file without an apiVersion.
+	from string
+	// to is the version the file is in afterward.
+	to string
+	// migrate changes the file.  The apiVersion and kind entries are changed separately.
+	migrate func(editor *KconfigEditor) error
+}
+
+// kconfigMigrations lists the migrations in order.  Files written before the apiVersion entry was
+// introduced are already in the first version of the format, so only the header is added.
+var kconfigMigrations = []kconfigMigration{
+	{from: "", to: KconfigAPIVersion, migrate: func(editor *KconfigEditor) error { return nil }},
+}
+
+// checkKconfigSchema reports, as warnings on standard error, the problems with the contents of the
+// named kconfig.yaml file that don't keep it from being used.
+func checkKconfigSchema(filename string, kconfig *Kconfig, contents []byte) {
+	for _, message := range kconfigSchemaProblems(kconfig, contents) {
+		fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", filename, message)
+	}
+}
+
+// kconfigSchemaProblems returns messages describing an apiVersion that's newer than this version
+// of kconfig understands or isn't recognized, a wrong kind, and entries that don't mean anything,
+// like misspelled preference names, which would otherwise be silently ignored.
+func kconfigSchemaProblems(kconfig *Kconfig, contents []byte) []string {
+	var messages []string
+	if kconfig.Kind != "" && kconfig.Kind != KconfigKind {
+		messages = append(messages, fmt.Sprintf("the kind is \"%s\" rather than \"%s\".", kconfig.Kind, KconfigKind))
+	}
+
+	switch compareKconfigAPIVersion(kconfig.APIVersion) {
+	case 1:
+		// Entries unknown to this version are expected.
+		return append(messages, fmt.Sprintf("the apiVersion \"%s\" is newer than \"%s\", which this version of kconfig understands, so some settings may be ignored.  Consider upgrading kconfig.",
+			kconfig.APIVersion, KconfigAPIVersion))
+	case -1:
+		messages = append(messages, fmt.Sprintf("the apiVersion \"%s\" is older than \"%s\".  Run \"kconfig-util migrate\" to upgrade the file.",
+			kconfig.APIVersion, KconfigAPIVersion))
+	case 2:
+		messages = append(messages, fmt.Sprintf("the apiVersion \"%s\" isn't recognized.  It should be \"%s\".", kconfig.APIVersion, KconfigAPIVersion))
+	}
+
+	if kconfig.Preferences.InheritKubeconfigEnv && !kconfig.inheritKubeconfigEnvEnabled() {
+		messages = append(messages, fmt.Sprintf("the inherit_kubeconfig_env preference is ignored unless the \"%s\" feature is enabled.", FeatureInheritKubeconfigEnv))
+	}
+
+	return append(messages, unknownKconfigFields(contents)...)
+}
+
+// compareKconfigAPIVersion compares the apiVersion with KconfigAPIVersion, returning -1, 0, or 1
+// if it's older, the same, or newer, and 2 if it isn't recognized.  An empty apiVersion is the
+// same as KconfigAPIVersion.
+func compareKconfigAPIVersion(apiVersion string) int {
+	if apiVersion == "" || apiVersion == KconfigAPIVersion {
+		return 0
+	}
+	match := kconfigAPIVersionRegexp.FindStringSubmatch(apiVersion)
+	currentMatch := kconfigAPIVersionRegexp.FindStringSubmatch(KconfigAPIVersion)
+	if match == nil {
+		return 2
+	}
+	version, err1 := strconv.Atoi(match[1])
+	currentVersion, err2 := strconv.Atoi(currentMatch[1])
+	switch {
+	case err1 != nil || err2 != nil:
+		return 2
+	case version < currentVersion:
+		return -1
+	case version > currentVersion:
+		return 1
+	}
+	return 0
+}
+
+// unknownKconfigFields decodes the contents of a kconfig.yaml file strictly, returning a message
+// for each entry that doesn't correspond to anything kconfig understands.
+func unknownKconfigFields(contents []byte) []string {
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	err := decoder.Decode(&Kconfig{})
+
+	var typeError *yaml.TypeError
+	if !errors.As(err, &typeError) {
+		return nil
+	}
+	var messages []string
+	for _, message := range typeError.Errors {
+		match := unknownFieldRegexp.FindStringSubmatch(message)
+		if match == nil {
+			continue
+		}
+		switch match[3] {
+		case "Kconfig":
+			messages = append(messages, fmt.Sprintf("line %s: unknown entry \"%s\".", match[1], match[2]))
+		case "KconfigPreferences":
+			messages = append(messages, fmt.Sprintf("line %s: unknown preference \"%s\".", match[1], match[2]))
+		default:
+			messages = append(messages, fmt.Sprintf("line %s: unknown entry \"%s\" in %s.", match[1], match[2], match[3]))
+		}
+	}
+	return messages
+}
+
+// MigrateKconfigFile upgrades the named kconfig.yaml file to the version of the format that this
+// version of kconfig understands, keeping its comments and the order of its entries.  It returns
+// the versions the file went through, which are empty if it was already up to date.  If only the
+// header entries had to be fixed, like a missing kind, the file's version is returned alone.  It's
+// an error if the file is newer than this version of kconfig understands.
+func MigrateKconfigFile(filename string) ([]string, error) {
+	if _, err := os.Stat(filename); err != nil {
+		return nil, fmt.Errorf("Unable to read \"%s\": %v", filename, err)
+	}
+
+	var applied []string
+	err := EditKconfigFile(filename, func(editor *KconfigEditor) error {
+		version := editor.header("apiVersion")
+		switch compareKconfigAPIVersion(version) {
+		case 1:
+			return fmt.Errorf("The apiVersion \"%s\" of \"%s\" is newer than \"%s\", which this version of kconfig understands.", version, filename, KconfigAPIVersion)
+		case 2:
+			return fmt.Errorf("The apiVersion \"%s\" of \"%s\" isn't recognized.", version, filename)
+		}
+
+		for _, migration := range kconfigMigrations {
+			if migration.from != version {
+				continue
+			}
+			err := migration.migrate(editor)
+			if err != nil {
+				return fmt.Errorf("Unable to migrate \"%s\" to %s: %w", filename, migration.to, err)
+			}
+			version = migration.to
+			applied = append(applied, version)
+		}
+		if editor.header("apiVersion") == version && editor.header("kind") == KconfigKind {
+			return errUnchanged
+		}
+		if len(applied) == 0 {
+			applied = append(applied, version)
+		}
+
+		editor.setHeader("apiVersion", version)
+		editor.setHeader("kind", KconfigKind)
+		return nil
+	})
+	if errors.Is(err, errUnchanged) {
+		return nil, nil
+	}
+	return applied, err
+}
+
+// errUnchanged is returned by the edit function of MigrateKconfigFile to leave the file alone.
+var errUnchanged = errors.New("unchanged")
+
+// header returns the value of the top-level entry with the key, like "apiVersion", or "".
+func (e *KconfigEditor) header(key string) string {
+	_, value := findMappingEntry(e.root, key)
+	if value == nil || value.Kind != yaml.ScalarNode {
+		return ""
+	}
+	return strings.TrimSpace(value.Value)
+}
+
+// setHeader sets the top-level entry with the key, like "apiVersion", adding it at the top of the
+// file, after any other header entries, if it isn't there already.
+func (e *KconfigEditor) setHeader(key string, value string) {
+	_, node := findMappingEntry(e.root, key)
+	if node != nil {
+		node.Kind = yaml.ScalarNode
+		node.Tag = "!!str"
+		node.Value = value
+		node.Content = nil
+		return
+	}
+
+	position := 0
+	for position+1 < len(e.root.Content) {
+		existing := e.root.Content[position].Value
+		if existing != "apiVersion" && existing != "kind" {
+			break
+		}
+		position += 2
+	}
+	entry := []*yaml.Node{
+		{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		{Kind: yaml.ScalarNode, Tag: "!!str", Value: value},
+	}
+	// A comment at the top of the file stays at the top.
+	if position == 0 && len(e.root.Content) > 0 && e.root.Content[0].HeadComment != "" {
+		entry[0].HeadComment = e.root.Content[0].HeadComment
+		e.root.Content[0].HeadComment = ""
+	}
+	e.root.Content = append(e.root.Content[:position], append(entry, e.root.Content[position:]...)...)
+}
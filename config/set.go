@@ -0,0 +1,324 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// setOption is a parsed --set option, which changes a field of the local kubectl config file that
+// kconfig doesn't otherwise have an option for, like "cluster.tls-server-name=api.internal".
+type setOption struct {
+	// kind is "clusters", "users", or "contexts", as the list is named in a kubectl config file.
+	kind string
+
+	// name is the name of the cluster, user, or context, or an empty string for the one that the
+	// current context is or refers to.
+	name string
+
+	// field is the path of the field within the cluster, user, or context, as it's named in a
+	// kubectl config file, like ["tls-server-name"] or ["exec", "command"].
+	field []string
+
+	// value is the new value of the field.  If it's empty, the field is removed.
+	value string
+}
+
+// key returns a string that identifies the field that the --set option changes.
+func (o *setOption) key() string {
+	return fmt.Sprintf("%s[%s].%s", o.kind, o.name, strings.Join(o.field, "."))
+}
+
+// setOptionKinds maps the names a --set option can start with to the lists they select.
+var setOptionKinds = map[string]string{
+	"cluster":  "clusters",
+	"user":     "users",
+	"context":  "contexts",
+	"clusters": "clusters",
+	"users":    "users",
+	"contexts": "contexts",
+}
+
+// parseSetOption parses a --set option, like "cluster.tls-server-name=api.internal" or
+// "users[admin].exec.command=aws".
+func parseSetOption(option string) (*setOption, error) {
+	path, value, found := strings.Cut(option, "=")
+	if !found {
+		return nil, fmt.Errorf("The --set option \"%s\" isn't like PATH=VALUE.", option)
+	}
+
+	object, field, found := strings.Cut(path, ".")
+	if !found || field == "" {
+		return nil, fmt.Errorf("The --set option \"%s\" doesn't name a field.", option)
+	}
+
+	parsed := &setOption{field: strings.Split(field, "."), value: value}
+	if open := strings.Index(object, "["); open >= 0 {
+		if !strings.HasSuffix(object, "]") || open == len(object)-2 {
+			return nil, fmt.Errorf("The --set option \"%s\" has an invalid name in brackets.", option)
+		}
+		parsed.name = object[open+1 : len(object)-1]
+		object = object[:open]
+		if !strings.HasSuffix(object, "s") {
+			return nil, fmt.Errorf("The --set option \"%s\" must name a cluster, user, or context like \"%ss[NAME]\".", option, object)
+		}
+	} else if strings.HasSuffix(object, "s") {
+		return nil, fmt.Errorf("The --set option \"%s\" must name a %s in brackets, like \"%s[NAME]\".", option, strings.TrimSuffix(object, "s"), object)
+	}
+
+	kind, exists := setOptionKinds[object]
+	if !exists {
+		return nil, fmt.Errorf("The --set option \"%s\" must start with \"cluster\", \"user\", \"context\", or one of them named in brackets, like \"clusters[NAME]\".", option)
+	}
+	for _, name := range parsed.field {
+		if name == "" || name == "name" {
+			return nil, fmt.Errorf("The --set option \"%s\" has an invalid field.", option)
+		}
+	}
+	parsed.kind = kind
+	return parsed, nil
+}
+
+// applySetOptions changes the fields of the local kubectl configuration as the --set options say.
+// The current context must be defined in it.  A cluster, user, or context that's only defined in
+// the search path is first copied to the local configuration:  the cluster and user of the current
+// context get names of their own, like they do for other override options, and those named in
+// brackets keep their names, so they take the place of those in the search path.  It's an error if
+// a field isn't one that kubectl knows of, so a typo doesn't go unnoticed.
+func applySetOptions(content *clientcmdapi.Config, base *clientcmdapi.Config, options []string) error {
+	if len(options) == 0 {
+		return nil
+	}
+
+	var parsedOptions []*setOption
+	for _, option := range options {
+		parsed, err := parseSetOption(option)
+		if err != nil {
+			return err
+		}
+		err = copySetTarget(content, base, parsed)
+		if err != nil {
+			return err
+		}
+		parsedOptions = append(parsedOptions, parsed)
+	}
+
+	// The fields are changed in the form the kubectl config file takes, since that's how they're
+	// named in the options.
+	document, err := kubeconfigDocument(content)
+	if err != nil {
+		return err
+	}
+	for idx, parsed := range parsedOptions {
+		err = setDocumentField(document, parsed)
+		if err != nil {
+			return fmt.Errorf("Unable to apply the --set option \"%s\": %v", options[idx], err)
+		}
+	}
+
+	contents, err := yaml.Marshal(document)
+	if err != nil {
+		return fmt.Errorf("Error formatting the kubectl configuration: %v", err)
+	}
+	changed, err := clientcmd.Load(contents)
+	if err != nil {
+		return fmt.Errorf("The --set options make an invalid kubectl configuration: %v", err)
+	}
+
+	// Fields that kubectl doesn't know of are dropped when the configuration is read, so check
+	// that the values are still there.  Only the last option for each field counts.
+	check, err := kubeconfigDocument(changed)
+	if err != nil {
+		return err
+	}
+	lastOption := make(map[string]int)
+	for idx, parsed := range parsedOptions {
+		lastOption[parsed.key()] = idx
+	}
+	for idx, parsed := range parsedOptions {
+		if parsed.value == "" || lastOption[parsed.key()] != idx {
+			continue
+		}
+		value, _ := documentField(check, parsed)
+		if !reflect.DeepEqual(value, setOptionValue(parsed.value)) {
+			return fmt.Errorf("The --set option \"%s\" doesn't name a field of a kubectl %s.", options[idx], strings.TrimSuffix(parsed.kind, "s"))
+		}
+	}
+
+	*content = *changed
+	return nil
+}
+
+// copySetTarget copies the cluster, user, or context that the --set option changes from the search
+// path to the local kubectl configuration, unless it's already there, and names it in the option.
+func copySetTarget(content *clientcmdapi.Config, base *clientcmdapi.Config, option *setOption) error {
+	context := content.Contexts[content.CurrentContext]
+	switch {
+	case option.kind == "contexts" && option.name == "":
+		option.name = content.CurrentContext
+
+	case option.kind == "contexts":
+		if content.Contexts[option.name] == nil {
+			baseContext, exists := base.Contexts[option.name]
+			if !exists {
+				return codedErrorf(ErrorCodeMissingContext, "Context \"%s\" doesn't exist.", option.name)
+			}
+			copied := baseContext.DeepCopy()
+			copied.LocationOfOrigin = ""
+			content.Contexts[option.name] = copied
+		}
+
+	case option.kind == "clusters" && option.name == "":
+		option.name = context.Cluster
+		if content.Clusters[option.name] == nil {
+			baseCluster, exists := base.Clusters[context.Cluster]
+			if !exists {
+				return codedErrorf(ErrorCodeMissingCluster, "Cluster \"%s\" doesn't exist.", context.Cluster)
+			}
+			copied := baseCluster.DeepCopy()
+			copied.LocationOfOrigin = ""
+			option.name = kconfigClusterName
+			context.Cluster = kconfigClusterName
+			content.Clusters[kconfigClusterName] = copied
+		}
+
+	case option.kind == "clusters":
+		if content.Clusters[option.name] == nil {
+			baseCluster, exists := base.Clusters[option.name]
+			if !exists {
+				return codedErrorf(ErrorCodeMissingCluster, "Cluster \"%s\" doesn't exist.", option.name)
+			}
+			copied := baseCluster.DeepCopy()
+			copied.LocationOfOrigin = ""
+			content.Clusters[option.name] = copied
+		}
+
+	case option.kind == "users" && option.name == "":
+		// A context can name a user that isn't defined, or none at all, which kubectl allows, so
+		// then the user starts out empty.
+		option.name = context.AuthInfo
+		if content.AuthInfos[option.name] == nil {
+			copied := clientcmdapi.NewAuthInfo()
+			if baseUser, exists := base.AuthInfos[context.AuthInfo]; exists {
+				copied = baseUser.DeepCopy()
+				copied.LocationOfOrigin = ""
+			}
+			option.name = kconfigUserName
+			context.AuthInfo = kconfigUserName
+			content.AuthInfos[kconfigUserName] = copied
+		}
+
+	case option.kind == "users":
+		if content.AuthInfos[option.name] == nil {
+			baseUser, exists := base.AuthInfos[option.name]
+			if !exists {
+				return codedErrorf(ErrorCodeMissingUser, "User \"%s\" doesn't exist.", option.name)
+			}
+			copied := baseUser.DeepCopy()
+			copied.LocationOfOrigin = ""
+			content.AuthInfos[option.name] = copied
+		}
+	}
+	return nil
+}
+
+// kubeconfigDocument returns the kubectl configuration in the form it takes in a kubectl config
+// file.
+func kubeconfigDocument(kubeconfig *clientcmdapi.Config) (map[string]interface{}, error) {
+	contents, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("Error formatting the kubectl configuration: %v", err)
+	}
+	var document map[string]interface{}
+	err = yaml.Unmarshal(contents, &document)
+	if err != nil {
+		return nil, fmt.Errorf("Error formatting the kubectl configuration: %v", err)
+	}
+	return document, nil
+}
+
+// namedDocumentObject returns the cluster, user, or context that the --set option changes, within
+// the kubectl configuration in the form it takes in a kubectl config file, or nil if it isn't
+// there.
+func namedDocumentObject(document map[string]interface{}, option *setOption) map[string]interface{} {
+	list, _ := document[option.kind].([]interface{})
+	for _, item := range list {
+		entry, _ := item.(map[string]interface{})
+		if entry != nil && entry["name"] == option.name {
+			object, _ := entry[strings.TrimSuffix(option.kind, "s")].(map[string]interface{})
+			if object == nil {
+				object = make(map[string]interface{})
+				entry[strings.TrimSuffix(option.kind, "s")] = object
+			}
+			return object
+		}
+	}
+	return nil
+}
+
+// setDocumentField changes the field that the --set option names in the kubectl configuration in
+// the form it takes in a kubectl config file, creating any objects along the way.
+func setDocumentField(document map[string]interface{}, option *setOption) error {
+	object := namedDocumentObject(document, option)
+	if object == nil {
+		return fmt.Errorf("There's no %s named \"%s\".", strings.TrimSuffix(option.kind, "s"), option.name)
+	}
+
+	last := len(option.field) - 1
+	for _, name := range option.field[:last] {
+		child, exists := object[name]
+		if !exists || child == nil {
+			if option.value == "" {
+				return nil
+			}
+			child = make(map[string]interface{})
+			object[name] = child
+		}
+		var isObject bool
+		object, isObject = child.(map[string]interface{})
+		if !isObject {
+			return fmt.Errorf("Field \"%s\" doesn't hold other fields.", name)
+		}
+	}
+
+	if option.value == "" {
+		delete(object, option.field[last])
+	} else {
+		object[option.field[last]] = setOptionValue(option.value)
+	}
+	return nil
+}
+
+// documentField returns the value of the field that the --set option names in the kubectl
+// configuration in the form it takes in a kubectl config file, and whether it's there.
+func documentField(document map[string]interface{}, option *setOption) (interface{}, bool) {
+	object := namedDocumentObject(document, option)
+	last := len(option.field) - 1
+	for _, name := range option.field[:last] {
+		if object == nil {
+			return nil, false
+		}
+		object, _ = object[name].(map[string]interface{})
+	}
+	if object == nil {
+		return nil, false
+	}
+	value, exists := object[option.field[last]]
+	return value, exists
+}
+
+// setOptionValue returns the value of a --set option as it's put in the kubectl configuration:
+// "true" and "false" are booleans, and anything else is a string.
+func setOptionValue(value string) interface{} {
+	switch value {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return value
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// kubeconfigSourcesSuffix is appended to the name of a session-local kubectl config file to name
+// the file that records the versions of the kubectl config files it was generated from.
+const kubeconfigSourcesSuffix = ".sources"
+
+// sourceFiles returns the kubectl config files that a local kubectl config file is generated from:
+// the files in the search path, followed by the file named by the --prefer-file option, if any.
+func sourceFiles(searchPath string, preferFile string) []string {
+	files := newLoadingRules(searchPath).Precedence
+	if preferFile != "" {
+		for _, file := range files {
+			if file == preferFile {
+				return files
+			}
+		}
+		files = append(files, preferFile)
+	}
+	return files
+}
+
+// writeKubeconfigSources records the versions of the kubectl config files that the local kubectl
+// config file was generated from.
+func writeKubeconfigSources(localConfigFilename string, sources []string) error {
+	contents, err := json.Marshal(getKubeconfigFileStamps(sources))
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(localConfigFilename+kubeconfigSourcesSuffix, contents, 0600)
+}
+
+// ChangedKubeconfigSources returns the names of the kubectl config files that the local kubectl
+// config file was generated from that have changed, been created, or been removed since it was
+// generated.  Nothing is returned if the versions of the files weren't recorded, as for files
+// written by older versions of kconfig.
+func ChangedKubeconfigSources(localConfigFilename string) []string {
+	contents, err := os.ReadFile(localConfigFilename + kubeconfigSourcesSuffix)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Debugf("Unable to read the sources of \"%s\": %v", localConfigFilename, err)
+		}
+		return nil
+	}
+
+	var recorded []kubeconfigFileStamp
+	err = json.Unmarshal(contents, &recorded)
+	if err != nil {
+		logger.Debugf("Unable to parse the sources of \"%s\": %v", localConfigFilename, err)
+		return nil
+	}
+
+	var changed []string
+	for _, stamp := range recorded {
+		current := getKubeconfigFileStamps([]string{stamp.Path})
+		if !kubeconfigFileStampsEqual(current, []kubeconfigFileStamp{stamp}) {
+			changed = append(changed, stamp.Path)
+		}
+	}
+	return changed
+}
+
+// RefreshSessionLocalKubectlConfigFile generates the session-local kubectl config file again, for
+// the kset environment described by the kset arguments:  the nickname, followed by any override
+// and --also options.  It's used when the kubectl config files it was generated from have changed,
+// so kubectl doesn't use contexts, clusters, or users that are out of date.  Since the environment
+// variables set by kset can't be changed, it's an error if the file's search path would change,
+// which is what the KUBECONFIG environment variable says.
+func RefreshSessionLocalKubectlConfigFile(localConfigFilename string, kubeconfigEnvVar string, ksetArgs []string) error {
+	if len(ksetArgs) == 0 {
+		return errors.New("No kset environment is in effect.")
+	}
+
+	kconfigOptions, alsoNicknames, err := ParseKsetArgs(ksetArgs)
+	if err != nil {
+		return err
+	}
+
+	kconfig := GetKconfig()
+	results, err := kconfig.ResolveLocalKubectlConfig(ksetArgs[0], kconfigOptions)
+	if err == nil {
+		err = kconfig.addNicknameContexts(results, alsoNicknames)
+	}
+	if err != nil {
+		return err
+	}
+	if results.KubeconfigEnvVar(localConfigFilename) != kubeconfigEnvVar {
+		return errors.New("The kubectl config search path of the kset environment has changed.")
+	}
+
+	unlock, err := lockFile(localConfigFilename)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = writeKubeconfigFile(localConfigFilename, results)
+	if err != nil {
+		return fmt.Errorf("Error replacing the local kubectl configuration file \"%s\": %v", localConfigFilename, err)
+	}
+	logger.Debugf("Refreshed local config file: %s", localConfigFilename)
+	return writeKubeconfigSources(localConfigFilename, sourceFiles(results.SearchPath, results.PreferFile))
+}
+
+// KsetArgsDelimiter delimits the kset arguments in the _KCONFIG_KSET environment variable when any
+// of them contains a blank.  It's the "unit separator" ASCII control code, which shouldn't appear
+// in an argument.
+const KsetArgsDelimiter = "\x1F"
+
+// SplitKsetArgs splits the value of the _KCONFIG_KSET environment variable into the kset arguments
+// that describe the kset environment:  the nickname, followed by any options.  They're delimited
+// by blanks, or by KsetArgsDelimiter if it appears.  An empty value has no arguments.
+func SplitKsetArgs(ksetEnvValue string) []string {
+	if ksetEnvValue == "" {
+		return nil
+	}
+	delimiter := " "
+	if strings.Contains(ksetEnvValue, KsetArgsDelimiter) {
+		delimiter = KsetArgsDelimiter
+	}
+	return strings.Split(ksetEnvValue, delimiter)
+}
+
+// KsetNickname returns the nickname of the kset environment described by the value of the
+// _KCONFIG_KSET environment variable, or an empty string if there isn't one.
+func KsetNickname(ksetEnvValue string) string {
+	ksetArgs := SplitKsetArgs(ksetEnvValue)
+	if len(ksetArgs) == 0 {
+		return ""
+	}
+	return ksetArgs[0]
+}
+
+// ParseKsetArgs parses the kset arguments that describe a kset environment:  the nickname, followed
+// by any override, --also, and --output-file options.  The override options and the --also
+// nicknames are returned.
+func ParseKsetArgs(ksetArgs []string) (*KconfigOptions, []string, error) {
+	if len(ksetArgs) == 0 {
+		return nil, nil, errors.New("No kset environment is in effect.")
+	}
+
+	var options struct {
+		KconfigOptions
+		Also       []string `long:"also"`
+		OutputFile string   `long:"output-file"`
+	}
+	_, err := flags.NewParser(&options, flags.PassDoubleDash).ParseArgs(ksetArgs[1:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error parsing the kset environment \"%s\": %v", strings.Join(ksetArgs, " "), err)
+	}
+	return &options.KconfigOptions, options.Also, nil
+}
@@ -0,0 +1,248 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// KconfigState describes the format of the ~/.kube/kconfig-state.yaml file, which holds
+// information that kconfig remembers from one command to the next.  If the KCONFIG_STATE_DIR
+// environment variable is set, the file is in that directory instead.  Unlike kconfig.yaml, this
+// file is maintained by kconfig and isn't intended to be edited by the user.
+type KconfigState struct {
+	// NamespaceHistory maps a nickname to the namespaces most recently used with it.
+	NamespaceHistory map[string]*NamespaceHistory `yaml:"namespace_history,omitempty"`
+
+	// KsetHistory lists the most recent kset environments, most recent first.  It holds at most
+	// MaxKsetHistory entries.
+	KsetHistory []*KsetHistoryEntry `yaml:"kset_history,omitempty"`
+
+	// SavedSessions maps the names given to the kset --save option to the environments saved under
+	// them.
+	SavedSessions map[string]*SavedSession `yaml:"saved_sessions,omitempty"`
+}
+
+// NamespaceHistory records the namespaces most recently used with a nickname.
+type NamespaceHistory struct {
+	Current  string `yaml:"current,omitempty"`
+	Previous string `yaml:"previous,omitempty"`
+}
+
+// KsetHistoryEntry records a kset environment that was activated.
+type KsetHistoryEntry struct {
+	// Args holds the nickname followed by any override options.
+	Args []string `yaml:"args"`
+
+	// Time is when the environment was last activated.
+	Time time.Time `yaml:"time"`
+}
+
+// SavedSession records a kset environment saved with the kset --save option.
+type SavedSession struct {
+	// Args holds the nickname followed by any override options.
+	Args []string `yaml:"args"`
+
+	// Time is when the environment was saved.
+	Time time.Time `yaml:"time"`
+}
+
+// MaxKsetHistory is the maximum number of kset environments remembered in the history.
+const MaxKsetHistory = 50
+
+// getStateFilename returns the name of the kconfig state file.  Like the daemon's socket, it's
+// moved by the KCONFIG_STATE_DIR environment variable but not by the state_dir preference, so it
+// can be found without reading kconfig.yaml.
+func getStateFilename() string {
+	if envStateDir := os.Getenv("KCONFIG_STATE_DIR"); envStateDir != "" {
+		return filepath.Join(envStateDir, "kconfig-state.yaml")
+	}
+	return filepath.Join(getHomeDirectory(), ".kube", "kconfig-state.yaml")
+}
+
+// ReadKconfigState reads the kconfig state file.  If the file doesn't exist or can't be read, an
+// empty state is returned.  The state is only a convenience, so problems reading it are reported
+// as warnings rather than failing the command.
+func ReadKconfigState() *KconfigState {
+	state := &KconfigState{}
+	stateFilename := getStateFilename()
+	contents, err := os.ReadFile(stateFilename)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "Warning: unable to read kconfig state file \"%s\": %v\n", stateFilename, err)
+		}
+		return state
+	}
+
+	err = yaml.Unmarshal(contents, state)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: unable to parse kconfig state file \"%s\": %v\n", stateFilename, err)
+		return &KconfigState{}
+	}
+
+	return state
+}
+
+// WriteKconfigState writes the kconfig state file.  Problems are reported as warnings.
+func WriteKconfigState(state *KconfigState) {
+	stateFilename := getStateFilename()
+	contents, err := yaml.Marshal(state)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(stateFilename), 0700)
+	}
+	if err == nil {
+		err = os.WriteFile(stateFilename, contents, 0600)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: unable to write kconfig state file \"%s\": %v\n", stateFilename, err)
+	}
+}
+
+// GetPreviousNamespace returns the namespace that was in use for the nickname before the current
+// one, or an empty string if none is recorded.
+func GetPreviousNamespace(nickname string) string {
+	history := ReadKconfigState().NamespaceHistory[nickname]
+	if history == nil {
+		return ""
+	}
+	return history.Previous
+}
+
+// RecordNamespace records that the namespace is now in use for the nickname.  If it's different
+// than the namespace recorded as current, that one becomes the previous namespace.
+func RecordNamespace(nickname string, namespace string) {
+	state := ReadKconfigState()
+	if state.NamespaceHistory == nil {
+		state.NamespaceHistory = make(map[string]*NamespaceHistory)
+	}
+
+	history := state.NamespaceHistory[nickname]
+	if history == nil {
+		history = &NamespaceHistory{}
+		state.NamespaceHistory[nickname] = history
+	}
+
+	if history.Current == namespace {
+		return
+	}
+
+	logger.Debugf("Recording namespace \"%s\" for nickname \"%s\".  Previous is \"%s\".", namespace, nickname, history.Current)
+	history.Previous = history.Current
+	history.Current = namespace
+	WriteKconfigState(state)
+}
+
+// GetKsetHistory returns the history of kset environments, most recent first.
+func GetKsetHistory() []*KsetHistoryEntry {
+	return ReadKconfigState().KsetHistory
+}
+
+// RecordKset records that the kset environment described by the arguments (a nickname followed by
+// any override options) was activated.  If it's the same as the most recent one, only its time is
+// updated.
+func RecordKset(args []string) {
+	state := ReadKconfigState()
+	entry := &KsetHistoryEntry{
+		Args: args,
+		Time: time.Now().UTC().Truncate(time.Second),
+	}
+
+	if len(state.KsetHistory) > 0 && reflect.DeepEqual(state.KsetHistory[0].Args, args) {
+		state.KsetHistory[0] = entry
+	} else {
+		state.KsetHistory = append([]*KsetHistoryEntry{entry}, state.KsetHistory...)
+		if len(state.KsetHistory) > MaxKsetHistory {
+			state.KsetHistory = state.KsetHistory[:MaxKsetHistory]
+		}
+	}
+
+	logger.Debugf("Recording kset environment %v in the history.", args)
+	WriteKconfigState(state)
+}
+
+// GetSavedSession returns the kset environment saved under the name, or nil if there isn't one.
+func GetSavedSession(name string) *SavedSession {
+	return ReadKconfigState().SavedSessions[name]
+}
+
+// GetSavedSessions returns all of the saved kset environments, by name.
+func GetSavedSessions() map[string]*SavedSession {
+	return ReadKconfigState().SavedSessions
+}
+
+// SaveSession saves the kset environment described by the arguments (a nickname followed by any
+// override options) under the name, replacing any environment already saved under it.
+func SaveSession(name string, args []string) {
+	state := ReadKconfigState()
+	if state.SavedSessions == nil {
+		state.SavedSessions = make(map[string]*SavedSession)
+	}
+
+	logger.Debugf("Saving kset environment %v as \"%s\".", args, name)
+	state.SavedSessions[name] = &SavedSession{
+		Args: args,
+		Time: time.Now().UTC().Truncate(time.Second),
+	}
+	WriteKconfigState(state)
+}
+
+// DeleteSavedSession deletes the kset environment saved under the name.  It returns false if there
+// isn't one.
+func DeleteSavedSession(name string) bool {
+	state := ReadKconfigState()
+	if _, exists := state.SavedSessions[name]; !exists {
+		return false
+	}
+
+	delete(state.SavedSessions, name)
+	WriteKconfigState(state)
+	return true
+}
+
+// RenameNicknameInState changes the references to the old nickname in the state to refer to the
+// new one:  its namespace history, and the kset environments in the history or saved with the kset
+// --save option that use it, as their nickname or with the --also option.
+func RenameNicknameInState(oldNickname string, newNickname string) {
+	state := ReadKconfigState()
+	changed := false
+
+	if history, exists := state.NamespaceHistory[oldNickname]; exists {
+		delete(state.NamespaceHistory, oldNickname)
+		state.NamespaceHistory[newNickname] = history
+		changed = true
+	}
+	for _, entry := range state.KsetHistory {
+		if renameNicknameInArgs(entry.Args, oldNickname, newNickname) {
+			changed = true
+		}
+	}
+	for _, session := range state.SavedSessions {
+		if renameNicknameInArgs(session.Args, oldNickname, newNickname) {
+			changed = true
+		}
+	}
+
+	if changed {
+		logger.Debugf("Renaming nickname \"%s\" to \"%s\" in the state.", oldNickname, newNickname)
+		WriteKconfigState(state)
+	}
+}
+
+// renameNicknameInArgs changes the arguments of a kset environment (a nickname followed by any
+// override and --also options) to refer to the new nickname instead of the old one.  It returns
+// whether anything was changed.
+func renameNicknameInArgs(args []string, oldNickname string, newNickname string) bool {
+	changed := false
+	for idx, arg := range args {
+		if (idx == 0 || args[idx-1] == "--also") && arg == oldNickname {
+			args[idx] = newNickname
+			changed = true
+		}
+	}
+	return changed
+}
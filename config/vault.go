@@ -0,0 +1,293 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// vaultUserName is the name of the kubectl user that the local kubectl config file defines for a
+// nickname with the --vault-path option.
+const vaultUserName = "kconfig_vault_user"
+
+// defaultVaultCacheTTL is how long credentials read from Vault are cached when Vault doesn't give
+// them a lease duration, as for the KV secrets engine, and the vault_cache_ttl preference isn't
+// set.
+const defaultVaultCacheTTL = 10 * time.Minute
+
+// vaultRequestTimeout limits how long requests to Vault can take.
+const vaultRequestTimeout = 30 * time.Second
+
+// VaultCredentials are the credentials of a kubectl user read from Vault, as cached in the kconfig
+// cache directory.
+type VaultCredentials struct {
+	Token                 string    `json:"token,omitempty"`
+	ClientCertificateData string    `json:"client_certificate_data,omitempty"`
+	ClientKeyData         string    `json:"client_key_data,omitempty"`
+	Expiry                time.Time `json:"expiry"`
+}
+
+// vaultSecretFields are the fields of a Vault secret that kconfig looks for, in order of
+// preference, for each of the credentials.  The later names are those used by the Kubernetes and
+// PKI secrets engines.
+var vaultSecretFields = struct {
+	token, certificate, key []string
+}{
+	token:       []string{"token", "service_account_token"},
+	certificate: []string{"client_certificate", "certificate"},
+	key:         []string{"client_key", "private_key"},
+}
+
+// vaultAuthInfo returns the kubectl user that runs "kconfig-util vault-credentials" as an exec
+// credential plugin to get the credentials stored in Vault at the path.
+func vaultAuthInfo(path string) *clientcmdapi.AuthInfo {
+	authInfo := clientcmdapi.NewAuthInfo()
+	authInfo.Exec = &clientcmdapi.ExecConfig{
+		APIVersion:      "client.authentication.k8s.io/v1",
+		Command:         "kconfig-util",
+		Args:            []string{"vault-credentials", path},
+		InstallHint:     "kconfig-util is installed with kconfig.  Make sure it's on your PATH.",
+		InteractiveMode: clientcmdapi.NeverExecInteractiveMode,
+	}
+	return authInfo
+}
+
+// vaultAddress returns the URL of the Vault server, from the VAULT_ADDR environment variable or
+// the vault_address preference.
+func vaultAddress() (string, error) {
+	address := os.Getenv("VAULT_ADDR")
+	if address == "" {
+		address = GetKconfig().Preferences.VaultAddress
+	}
+	if address == "" {
+		return "", errors.New("The Vault server isn't known.  Set the VAULT_ADDR environment variable or the vault_address preference.")
+	}
+	return strings.TrimSuffix(address, "/"), nil
+}
+
+// vaultToken returns the Vault token to use, from the VAULT_TOKEN environment variable or the
+// ~/.vault-token file written by "vault login".
+func vaultToken() (string, error) {
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token, nil
+	}
+	contents, err := os.ReadFile(filepath.Join(getHomeDirectory(), ".vault-token"))
+	if err != nil || strings.TrimSpace(string(contents)) == "" {
+		return "", errors.New("There's no Vault token.  Run \"vault login\" or set the VAULT_TOKEN environment variable.")
+	}
+	return strings.TrimSpace(string(contents)), nil
+}
+
+// vaultCacheTTL returns how long credentials without a lease duration are cached, from the
+// vault_cache_ttl preference.  An invalid preference is reported as a warning and the default is
+// used.
+func vaultCacheTTL() time.Duration {
+	ttl := GetKconfig().Preferences.VaultCacheTTL
+	if ttl == "" {
+		return defaultVaultCacheTTL
+	}
+	parsedTTL, err := time.ParseDuration(ttl)
+	if err != nil || parsedTTL <= 0 {
+		fmt.Fprintf(os.Stderr, "Warning: the vault_cache_ttl preference \"%s\" isn't a positive duration like \"10m\".\n", ttl)
+		return defaultVaultCacheTTL
+	}
+	return parsedTTL
+}
+
+// GetVaultCredentials returns unexpired credentials read from Vault at the path.  They're cached
+// until the lease Vault gives them expires, or for the time given by the vault_cache_ttl preference
+// if there's no lease, so rotated credentials are picked up without reading Vault for every
+// kubectl command.
+func GetVaultCredentials(path string) (*VaultCredentials, error) {
+	address, err := vaultAddress()
+	if err != nil {
+		return nil, err
+	}
+
+	preferences := &GetKconfig().Preferences
+	key := sha256.Sum256([]byte(strings.Join([]string{address, os.Getenv("VAULT_NAMESPACE"), path}, "\n")))
+	cacheFilename := filepath.Join(GetKconfigCacheDirectory(), "vault", hex.EncodeToString(key[:16])+".json")
+	cached := readVaultCache(cacheFilename, preferences)
+	if cached != nil && time.Now().Add(oidcTokenExpirySlack).Before(cached.Expiry) {
+		logger.Debugf("Using cached Vault credentials from \"%s\", which expire at %v.", cacheFilename, cached.Expiry)
+		return cached, nil
+	}
+
+	credentials, err := readVaultCredentials(address, path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeVaultCache(cacheFilename, credentials, preferences)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: unable to cache the Vault credentials in \"%s\": %v\n", cacheFilename, err)
+	}
+	return credentials, nil
+}
+
+// readVaultCache returns the credentials cached in the file, or nil if there aren't any that can
+// be used.  If the encrypt_credentials preference is set, the cache is encrypted like the
+// credentials of local kubectl config files, with the method on the first line, and a cache that
+// isn't encrypted with that method is ignored.
+func readVaultCache(cacheFilename string, preferences *KconfigPreferences) *VaultCredentials {
+	contents, err := os.ReadFile(cacheFilename)
+	if err != nil {
+		return nil
+	}
+	if method := preferences.EncryptCredentials; method != "" {
+		cachedMethod, ciphertext, found := bytes.Cut(contents, []byte("\n"))
+		if !found || string(cachedMethod) != method {
+			return nil
+		}
+		contents, err = decryptCredentials(method, ciphertext, preferences)
+		if err != nil {
+			logger.Debugf("Unable to decrypt the cached Vault credentials in \"%s\": %v", cacheFilename, err)
+			return nil
+		}
+	}
+
+	var cached VaultCredentials
+	if json.Unmarshal(contents, &cached) != nil {
+		return nil
+	}
+	return &cached
+}
+
+// writeVaultCache writes the credentials to the cache file, encrypted if the encrypt_credentials
+// preference is set.
+func writeVaultCache(cacheFilename string, credentials *VaultCredentials, preferences *KconfigPreferences) error {
+	contents, err := json.Marshal(credentials)
+	if err != nil {
+		return err
+	}
+	if method := preferences.EncryptCredentials; method != "" {
+		ciphertext, err := encryptCredentials(method, contents, preferences)
+		if err != nil {
+			return err
+		}
+		contents = append([]byte(method+"\n"), ciphertext...)
+	}
+
+	err = os.MkdirAll(filepath.Dir(cacheFilename), 0700)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(cacheFilename, contents, 0600)
+}
+
+// readVaultCredentials reads the secret at the path from Vault and picks the credentials out of
+// it.  For the KV version 2 secrets engine, the fields are in a nested "data" object.
+func readVaultCredentials(address string, path string) (*VaultCredentials, error) {
+	token, err := vaultToken()
+	if err != nil {
+		return nil, err
+	}
+	client, err := vaultHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), vaultRequestTimeout)
+	defer cancel()
+	secretURL := fmt.Sprintf("%s/v1/%s", address, strings.TrimPrefix(path, "/"))
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, secretURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("X-Vault-Token", token)
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		request.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	logger.Debugf("Requesting: %s", secretURL)
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to reach Vault at \"%s\": %v", address, err)
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, codedErrorf(ErrorCodeAuthFailure, "Reading Vault path \"%s\" failed with status %s: %s", path, response.Status, strings.TrimSpace(string(body)))
+	}
+
+	var secret struct {
+		LeaseDuration int                    `json:"lease_duration"`
+		Data          map[string]interface{} `json:"data"`
+	}
+	err = json.Unmarshal(body, &secret)
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding the secret at Vault path \"%s\": %v", path, err)
+	}
+	fields := secret.Data
+	if nested, ok := fields["data"].(map[string]interface{}); ok {
+		if _, isKV2 := fields["metadata"]; isKV2 {
+			fields = nested
+		}
+	}
+
+	credentials := &VaultCredentials{
+		Token:                 vaultSecretField(fields, vaultSecretFields.token),
+		ClientCertificateData: vaultSecretField(fields, vaultSecretFields.certificate),
+		ClientKeyData:         vaultSecretField(fields, vaultSecretFields.key),
+	}
+	if credentials.Token == "" && (credentials.ClientCertificateData == "" || credentials.ClientKeyData == "") {
+		return nil, fmt.Errorf("The secret at Vault path \"%s\" has neither a \"token\" field nor \"client_certificate\" and \"client_key\" fields.", path)
+	}
+
+	ttl := vaultCacheTTL()
+	if secret.LeaseDuration > 0 {
+		ttl = time.Duration(secret.LeaseDuration) * time.Second
+	}
+	credentials.Expiry = time.Now().Add(ttl).UTC().Truncate(time.Second)
+	return credentials, nil
+}
+
+// vaultSecretField returns the first of the named fields that's a nonempty string.
+func vaultSecretField(fields map[string]interface{}, names []string) string {
+	for _, name := range names {
+		if value, ok := fields[name].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// vaultHTTPClient returns the client used for requests to Vault, which trusts the certificate
+// authority named by the VAULT_CACERT environment variable, if it's set, as the vault command
+// does.
+func vaultHTTPClient() (*http.Client, error) {
+	caFilename := os.Getenv("VAULT_CACERT")
+	if caFilename == "" {
+		return http.DefaultClient, nil
+	}
+
+	caCertificates, err := os.ReadFile(caFilename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the Vault CA certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCertificates) {
+		return nil, fmt.Errorf("The file \"%s\" named by VAULT_CACERT has no PEM certificates.", caFilename)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: transport}, nil
+}
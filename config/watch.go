@@ -0,0 +1,91 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// NicknameFileWatcher keeps the local kubectl config files that the kubectl program's --kconfig
+// option creates for nicknames up to date, for tools that read them directly.  The files are
+// generated again when kconfig.yaml, or any of the kubectl config files they're generated from,
+// changes.
+type NicknameFileWatcher struct {
+	// stamps are the versions of the files that the nickname files were last generated from, or
+	// nil if they haven't been generated yet.
+	stamps []kubeconfigFileStamp
+}
+
+// NicknameFileChanges describes what a check by a NicknameFileWatcher did.
+type NicknameFileChanges struct {
+	// Regenerated lists the nicknames whose files were generated again.
+	Regenerated []string
+	// Removed lists the nicknames whose files were removed, since the nickname is no longer
+	// defined or can no longer be resolved.
+	Removed []string
+	// Problems describes the nicknames that can no longer be resolved.
+	Problems []string
+}
+
+// Check generates the nickname files again if the files they were generated from have changed
+// since the last check, and their precomputed files are out of date.  Files of nicknames that are
+// no longer defined, or that can no longer be resolved, are removed, so a tool never gets one
+// that's out of date.  Only the files that already exist are generated; the kubectl program
+// creates the others when they're used.  If kconfig.yaml can't be read, such as while it's being
+// edited, an error is returned and the files are left alone until the next check.
+func (w *NicknameFileWatcher) Check() (*NicknameFileChanges, error) {
+	if w.stamps != nil {
+		files := make([]string, 0, len(w.stamps))
+		for _, stamp := range w.stamps {
+			files = append(files, stamp.Path)
+		}
+		if kubeconfigFileStampsEqual(w.stamps, getKubeconfigFileStamps(files)) {
+			return &NicknameFileChanges{}, nil
+		}
+	}
+
+	kconfigFilename := DefaultKconfigFilename()
+	sources := map[string]bool{kconfigFilename: true}
+	stamps := getKubeconfigFileStamps([]string{kconfigFilename})
+	kconfig, err := ReloadKconfig()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read \"%s\": %v", kconfigFilename, err)
+	}
+
+	filenames, err := filepath.Glob(filepath.Join(getNicknameDirectory(), "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	changes := &NicknameFileChanges{}
+	for _, filename := range filenames {
+		nickname := strings.TrimSuffix(filepath.Base(filename), ".yaml")
+		results, generated, err := kconfig.PrecomputeNicknameKubectlConfigFile(nickname)
+		if err == nil {
+			for _, source := range sourceFiles(results.SearchPath, results.PreferFile) {
+				if !sources[source] {
+					sources[source] = true
+					stamps = append(stamps, getKubeconfigFileStamps([]string{source})...)
+				}
+			}
+			if generated {
+				changes.Regenerated = append(changes.Regenerated, nickname)
+			}
+			continue
+		}
+
+		if _, defined := kconfig.lookupNickname(nickname); defined {
+			changes.Problems = append(changes.Problems, fmt.Sprintf("Nickname \"%s\": %v", nickname, err))
+		}
+		removeErr := removeNicknameFile(filename)
+		if removeErr != nil {
+			changes.Problems = append(changes.Problems, fmt.Sprintf("Unable to remove \"%s\": %v", filename, removeErr))
+			continue
+		}
+		changes.Removed = append(changes.Removed, nickname)
+	}
+
+	sort.Slice(stamps, func(i, j int) bool { return stamps[i].Path < stamps[j].Path })
+	w.stamps = stamps
+	return changes, nil
+}
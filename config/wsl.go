@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// PathStyleAuto says to give a kubectl executable Windows paths when it's a Windows program
+	// run from WSL, as judged by its ".exe" suffix.
+	PathStyleAuto = "auto"
+
+	// PathStyleWindows says to always give the kubectl executable Windows paths when running in
+	// WSL, such as when it's a script that runs kubectl.exe.
+	PathStyleWindows = "windows"
+
+	// PathStyleWSL says to never translate paths.
+	PathStyleWSL = "wsl"
+)
+
+// wslInteropFilename is registered by WSL to run Windows programs, so it only exists in WSL.
+const wslInteropFilename = "/proc/sys/fs/binfmt_misc/WSLInterop"
+
+// RunningInWSL says whether this process is running in the Windows Subsystem for Linux.
+func RunningInWSL() bool {
+	if os.Getenv("WSL_DISTRO_NAME") != "" {
+		return true
+	}
+	_, err := os.Stat(wslInteropFilename)
+	return err == nil
+}
+
+// UseWindowsPaths says whether the paths in the KUBECONFIG environment variable must be translated
+// to Windows paths for the kubectl executable, according to the path_style preference:  whether
+// it's a Windows program like kubectl.exe run from WSL, which can't read a value like
+// "/tmp/kconfig-1000/sessions/123.yaml:/home/me/.kube/config".
+func (k *Kconfig) UseWindowsPaths(executable string) (bool, error) {
+	switch k.Preferences.PathStyle {
+	case "", PathStyleAuto:
+		return RunningInWSL() && strings.HasSuffix(strings.ToLower(executable), ".exe"), nil
+	case PathStyleWindows:
+		return RunningInWSL(), nil
+	case PathStyleWSL:
+		return false, nil
+	default:
+		return false, fmt.Errorf("The path_style preference must be \"%s\", \"%s\", or \"%s\".", PathStyleAuto, PathStyleWindows, PathStyleWSL)
+	}
+}
+
+// WindowsKubeconfigEnvVar translates a value of the KUBECONFIG environment variable to the one a
+// Windows program sees the same files with, using the wslpath command.  The paths are separated
+// by semicolons, and the files in the Linux file system are named like
+// "\\wsl.localhost\Ubuntu\home\me\.kube\config".  wslpath only translates files that exist, so
+// the others are left out, since kubectl ignores them anyway.
+func WindowsKubeconfigEnvVar(kubeconfigEnvVar string) (string, error) {
+	var windowsPaths []string
+	for _, filename := range filepath.SplitList(kubeconfigEnvVar) {
+		if filename == "" {
+			continue
+		}
+		if _, err := os.Stat(filename); err != nil {
+			logger.Debugf("Leaving \"%s\" out of the Windows KUBECONFIG: %v", filename, err)
+			continue
+		}
+		output, err := exec.Command("wslpath", "-w", filename).Output()
+		if err != nil {
+			return "", fmt.Errorf("Unable to translate \"%s\" to a Windows path with wslpath: %v", filename, err)
+		}
+		windowsPaths = append(windowsPaths, strings.TrimRight(string(output), "\r\n"))
+	}
+	return strings.Join(windowsPaths, ";"), nil
+}
+
+// AddToWSLEnv adds the names of environment variables to the WSLENV environment variable, unless
+// they're already there, so they're passed on to Windows programs run from WSL.  Their values are
+// passed as they are.
+func AddToWSLEnv(names ...string) error {
+	wslEnv := os.Getenv("WSLENV")
+	present := make(map[string]bool)
+	for _, entry := range strings.Split(wslEnv, ":") {
+		// An entry can have flags after a slash, like "GOPATH/l".
+		present[strings.SplitN(entry, "/", 2)[0]] = true
+	}
+	for _, name := range names {
+		if !present[name] {
+			if wslEnv != "" {
+				wslEnv += ":"
+			}
+			wslEnv += name
+		}
+	}
+	return os.Setenv("WSLENV", wslEnv)
+}
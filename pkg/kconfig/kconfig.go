@@ -0,0 +1,177 @@
+// Package kconfig is the supported Go API for resolving kconfig nicknames.  It lets other programs
+// reuse the kconfig semantics, turning a nickname and optional overrides into a kubectl
+// configuration and environment settings, without running kconfig-util.  Unlike the internal
+// packages, the functions in this package never exit the process.  Problems are returned as errors.
+//
+// A typical use is:
+//
+//	kc, err := kconfig.Load(kconfig.LoadOptions{})
+//	...
+//	resolved, err := kc.Resolve("dev", &kconfig.Overrides{Namespace: "app1"})
+//	...
+//	kubeconfigEnvVar, err := kconfig.WriteSessionConfig(resolved, "/path/to/session.yaml")
+package kconfig
+
+import (
+	"sort"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/jphx/kconfig/config"
+)
+
+// LoadOptions controls how the kconfig configuration is loaded.
+type LoadOptions struct {
+	// Filename is the kconfig.yaml file to read.  If empty, ~/.kube/kconfig.yaml is read.
+	Filename string
+}
+
+// Kconfig is a loaded kconfig configuration.
+type Kconfig struct {
+	kconfig *config.Kconfig
+}
+
+// Overrides are the options that can override a nickname's definition, as on the kset command line.
+type Overrides = config.KconfigOptions
+
+// Resolved describes the result of resolving a nickname.
+type Resolved struct {
+	// Nickname is the nickname that was resolved.
+	Nickname string
+
+	// KubectlExecutable is the kubectl executable to use for the nickname.
+	KubectlExecutable string
+
+	// Namespace is the effective Kubernetes namespace.
+	Namespace string
+
+	// SearchPath is the kubectl config search path that should follow the session config file in
+	// the KUBECONFIG environment variable.
+	SearchPath string
+
+	// TeleportProxy is the value for the TELEPORT_PROXY environment variable, if any.
+	TeleportProxy string
+
+	// OverridesDescription is a short description of the overrides, as shown in the kset prompt.
+	OverridesDescription string
+
+	// Danger says whether the nickname is tagged as dangerous, such as one for a production
+	// cluster.
+	Danger bool
+
+	// Config is the content of the session kubectl config file.
+	Config *clientcmdapi.Config
+
+	results *config.CreateConfigResults
+}
+
+// Load reads the kconfig configuration.  A missing file results in an empty configuration.
+func Load(opts LoadOptions) (*Kconfig, error) {
+	kconfig, err := config.LoadKconfig(kconfigFilename(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Kconfig{kconfig: kconfig}, nil
+}
+
+// SetNickname defines the nickname in the kconfig.yaml file, replacing any existing definition.
+// The definition is checked first.  The comments in the file are preserved, and the file is locked
+// while it's changed, so concurrent changes by other programs aren't lost.
+func SetNickname(opts LoadOptions, nickname string, definition string) error {
+	if err := config.CheckNicknameDefinition(definition); err != nil {
+		return err
+	}
+
+	return config.EditKconfigFile(kconfigFilename(opts), func(editor *config.KconfigEditor) error {
+		return editor.SetNickname(nickname, definition)
+	})
+}
+
+// RemoveNickname removes the nickname from the kconfig.yaml file, in the same way that
+// SetNickname() changes it.  It returns false if the nickname wasn't defined.
+func RemoveNickname(opts LoadOptions, nickname string) (bool, error) {
+	removed := false
+	err := config.EditKconfigFile(kconfigFilename(opts), func(editor *config.KconfigEditor) error {
+		removed = editor.RemoveNickname(nickname)
+		return nil
+	})
+	return removed, err
+}
+
+// RenameNickname renames the nickname in the kconfig.yaml file, in the same way that SetNickname()
+// changes it.  The definitions of nicknames that extend it are changed to extend the new name, and
+// those nicknames are returned.  It's an error if the old nickname isn't defined or the new one
+// already is.
+func RenameNickname(opts LoadOptions, oldNickname string, newNickname string) ([]string, error) {
+	var extending []string
+	err := config.EditKconfigFile(kconfigFilename(opts), func(editor *config.KconfigEditor) error {
+		var err error
+		extending, err = editor.RenameNickname(oldNickname, newNickname)
+		return err
+	})
+	return extending, err
+}
+
+// kconfigFilename returns the name of the kconfig.yaml file described by the options.
+func kconfigFilename(opts LoadOptions) string {
+	if opts.Filename == "" {
+		return config.DefaultKconfigFilename()
+	}
+	return opts.Filename
+}
+
+// Nicknames returns the defined nicknames, sorted.
+func (k *Kconfig) Nicknames() []string {
+	nicknames := make([]string, 0, len(k.kconfig.Nicknames))
+	for nickname := range k.kconfig.Nicknames {
+		nicknames = append(nicknames, nickname)
+	}
+	sort.Strings(nicknames)
+	return nicknames
+}
+
+// Resolve works out the kubectl configuration for the nickname, with any overrides applied.  The
+// overrides may be nil.  Nothing is written.
+func (k *Kconfig) Resolve(nickname string, overrides *Overrides) (*Resolved, error) {
+	results, err := k.kconfig.ResolveLocalKubectlConfig(nickname, overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Resolved{
+		Nickname:             nickname,
+		KubectlExecutable:    results.KubectlExecutable,
+		Namespace:            results.ContextNamespace,
+		SearchPath:           results.SearchPath,
+		TeleportProxy:        results.TeleportProxyEnvVar,
+		OverridesDescription: results.OverridesDescription,
+		Danger:               results.Danger,
+		Config:               results.ConfigContent,
+		results:              results,
+	}, nil
+}
+
+// WriteSessionConfig writes the resolved kubectl configuration to the named file, replacing it if
+// it exists.  It returns the value to use for the KUBECONFIG environment variable.
+func WriteSessionConfig(resolved *Resolved, filename string) (string, error) {
+	err := config.WriteLocalKubectlConfigFile(filename, resolved.results)
+	if err != nil {
+		return "", err
+	}
+
+	return resolved.results.NewKubeconfigEnvVar, nil
+}
+
+// Env returns the environment variable settings that kset would make for the resolved nickname,
+// given the KUBECONFIG value returned by WriteSessionConfig().
+func (r *Resolved) Env(kubeconfigEnvVar string) map[string]string {
+	env := map[string]string{
+		"KUBECONFIG":       kubeconfigEnvVar,
+		"_KCONFIG_KUBECTL": r.KubectlExecutable,
+	}
+	if r.TeleportProxy != "" {
+		env["TELEPORT_PROXY"] = r.TeleportProxy
+	}
+	return env
+}
@@ -0,0 +1,327 @@
+package kconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    server: http://dev-cluster/
+  name: dev
+contexts:
+- context:
+    cluster: dev
+    namespace: devnamespace1
+    user: devuser1
+  name: dev
+current-context: dev
+users:
+- name: devuser1
+  user:
+    token: devuser1-token
+`
+
+func writeTestFiles(t *testing.T) (string, string) {
+	dir := t.TempDir()
+	kubeconfigFilename := filepath.Join(dir, "config")
+	kconfigFilename := filepath.Join(dir, "kconfig.yaml")
+
+	err := os.WriteFile(kubeconfigFilename, []byte(testKubeconfig), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kconfigContent := fmt.Sprintf("nicknames:\n  dev: kubectl-dev --kubeconfig %s --context dev\n  app: --extends dev -n app\n", kubeconfigFilename)
+	err = os.WriteFile(kconfigFilename, []byte(kconfigContent), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, kconfigFilename
+}
+
+func TestResolveAndWrite(t *testing.T) {
+	dir, kconfigFilename := writeTestFiles(t)
+	// Resolution shouldn't read or change the KUBECONFIG environment variable.
+	otherKubeconfig := filepath.Join(dir, "other-config")
+	t.Setenv("KUBECONFIG", otherKubeconfig)
+
+	kc, err := Load(LoadOptions{Filename: kconfigFilename})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(kc.Nicknames(), []string{"app", "dev"}) {
+		t.Errorf("Unexpected nicknames: %v", kc.Nicknames())
+	}
+
+	resolved, err := kc.Resolve("app", &Overrides{User: "devuser2"})
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+
+	if resolved.KubectlExecutable != "kubectl-dev" || resolved.Namespace != "app" || resolved.OverridesDescription != "u=devuser2" {
+		t.Errorf("Unexpected resolution: %#v", resolved)
+	}
+
+	if os.Getenv("KUBECONFIG") != otherKubeconfig {
+		t.Errorf("Resolve changed the KUBECONFIG environment variable to: %s", os.Getenv("KUBECONFIG"))
+	}
+
+	context := resolved.Config.Contexts[resolved.Config.CurrentContext]
+	if context == nil || context.AuthInfo != "devuser2" || context.Cluster != "dev" {
+		t.Errorf("Unexpected context: %#v", context)
+	}
+
+	sessionFilename := filepath.Join(dir, "session.yaml")
+	kubeconfigEnvVar, err := WriteSessionConfig(resolved, sessionFilename)
+	if err != nil {
+		t.Fatalf("WriteSessionConfig failed: %v", err)
+	}
+
+	if !strings.HasPrefix(kubeconfigEnvVar, sessionFilename+string(os.PathListSeparator)) {
+		t.Errorf("Unexpected KUBECONFIG value: %s", kubeconfigEnvVar)
+	}
+
+	_, err = os.Stat(sessionFilename)
+	if err != nil {
+		t.Errorf("Session file wasn't written: %v", err)
+	}
+
+	if resolved.Env(kubeconfigEnvVar)["_KCONFIG_KUBECTL"] != "kubectl-dev" {
+		t.Errorf("Unexpected environment: %v", resolved.Env(kubeconfigEnvVar))
+	}
+}
+
+func TestWriteUsesLoadedPreferences(t *testing.T) {
+	dir, kconfigFilename := writeTestFiles(t)
+	// The kconfig.yaml file in the home directory, which has no preferences, mustn't be used.
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("KCONFIG_FEATURES", "self-contained")
+
+	contents, err := os.ReadFile(kconfigFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identityFilename := filepath.Join(dir, "missing-identity.txt")
+	preferences := fmt.Sprintf("preferences:\n  encrypt_credentials: age\n  age_identity_file: %s\n", identityFilename)
+	err = os.WriteFile(kconfigFilename, append([]byte(preferences), contents...), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kc, err := Load(LoadOptions{Filename: kconfigFilename})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	// The token is only in the session file, and so encrypted, when it's self-contained.
+	resolved, err := kc.Resolve("dev", &Overrides{SelfContained: true})
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+
+	_, err = WriteSessionConfig(resolved, filepath.Join(dir, "session.yaml"))
+	if err == nil || !strings.Contains(err.Error(), identityFilename) {
+		t.Errorf("Expected the encrypt_credentials preference of the loaded file to be used, got: %v", err)
+	}
+}
+
+func TestIgnoresDefaultKconfig(t *testing.T) {
+	dir, kconfigFilename := writeTestFiles(t)
+	// A kconfig.yaml file in the home directory that can't be parsed would make kconfig-util exit,
+	// but it has nothing to do with the loaded configuration.
+	homeDir := t.TempDir()
+	t.Setenv("HOME", homeDir)
+	err := os.MkdirAll(filepath.Join(homeDir, ".kube"), 0700)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(homeDir, ".kube", "kconfig.yaml"), []byte("nicknames: [\n"), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := os.ReadFile(kconfigFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	preferences := fmt.Sprintf("preferences:\n  inherit_kubeconfig_env: true\n  state_dir: %s\n", filepath.Join(dir, "state"))
+	err = os.WriteFile(kconfigFilename, append([]byte(preferences), contents...), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("KCONFIG_FEATURES", "inherit-kubeconfig-env")
+	t.Setenv("KUBECONFIG", filepath.Join(dir, "state", "sessions", "1.yaml"))
+
+	kc, err := Load(LoadOptions{Filename: kconfigFilename})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	_, err = kc.Resolve("app", nil)
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	_, kconfigFilename := writeTestFiles(t)
+
+	kc, err := Load(LoadOptions{Filename: kconfigFilename})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	_, err = kc.Resolve("doesnt-exist", nil)
+	if err == nil || !strings.Contains(err.Error(), "is not defined") {
+		t.Errorf("Expected an undefined nickname error, got: %v", err)
+	}
+
+	_, err = kc.Resolve("dev", &Overrides{Context: "missing"})
+	if err == nil || !strings.Contains(err.Error(), "Context \"missing\" doesn't exist.") {
+		t.Errorf("Expected a missing context error, got: %v", err)
+	}
+}
+
+func TestEditNicknames(t *testing.T) {
+	dir := t.TempDir()
+	kconfigFilename := filepath.Join(dir, "kconfig.yaml")
+	original := `# My kconfig file.
+preferences:
+  change_prompt: false
+nicknames:
+  # The development cluster.
+  dev: --context dev
+  stage: --context stage # Rarely used.
+`
+	err := os.WriteFile(kconfigFilename, []byte(original), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := LoadOptions{Filename: kconfigFilename}
+
+	err = SetNickname(opts, "dev", "--context dev -n app")
+	if err != nil {
+		t.Fatalf("SetNickname failed: %v", err)
+	}
+	err = SetNickname(opts, "prod", "--context prod --danger")
+	if err != nil {
+		t.Fatalf("SetNickname failed: %v", err)
+	}
+	removed, err := RemoveNickname(opts, "stage")
+	if err != nil || !removed {
+		t.Fatalf("RemoveNickname failed: %v, %v", removed, err)
+	}
+	removed, err = RemoveNickname(opts, "stage")
+	if err != nil || removed {
+		t.Errorf("RemoveNickname of a missing nickname returned: %v, %v", removed, err)
+	}
+
+	err = SetNickname(opts, "bad", "--no-such-option")
+	if err == nil {
+		t.Errorf("SetNickname accepted a bad definition.")
+	}
+
+	contents, err := os.ReadFile(kconfigFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `# My kconfig file.
+preferences:
+  change_prompt: false
+nicknames:
+  # The development cluster.
+  dev: --context dev -n app
+  prod: --context prod --danger
+`
+	if string(contents) != expected {
+		t.Errorf("Unexpected kconfig.yaml contents:\n%s", string(contents))
+	}
+
+	// Edits of a missing file create it.
+	opts.Filename = filepath.Join(dir, "new", "kconfig.yaml")
+	err = SetNickname(opts, "dev", "--context dev")
+	if err != nil {
+		t.Fatalf("SetNickname failed: %v", err)
+	}
+	kc, err := Load(opts)
+	if err != nil || !reflect.DeepEqual(kc.Nicknames(), []string{"dev"}) {
+		t.Errorf("Unexpected result of creating a file: %v", err)
+	}
+}
+
+func TestRenameNickname(t *testing.T) {
+	kconfigFilename := filepath.Join(t.TempDir(), "kconfig.yaml")
+	original := `nicknames:
+  # The development cluster.
+  dev: --context dev
+  dev-admin: --extends dev --user admin
+  dev-quoted: --extends=dev --as-group 'my group'
+  development: --context development
+`
+	err := os.WriteFile(kconfigFilename, []byte(original), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := LoadOptions{Filename: kconfigFilename}
+
+	extending, err := RenameNickname(opts, "dev", "devel")
+	if err != nil {
+		t.Fatalf("RenameNickname failed: %v", err)
+	}
+	if !reflect.DeepEqual(extending, []string{"dev-admin", "dev-quoted"}) {
+		t.Errorf("Unexpected extending nicknames: %v", extending)
+	}
+
+	contents, err := os.ReadFile(kconfigFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `nicknames:
+  # The development cluster.
+  devel: --context dev
+  dev-admin: --extends devel --user admin
+  dev-quoted: --extends=devel --as-group 'my group'
+  development: --context development
+`
+	if string(contents) != expected {
+		t.Errorf("Unexpected kconfig.yaml contents:\n%s", string(contents))
+	}
+
+	for _, names := range [][]string{{"dev", "other"}, {"devel", "development"}} {
+		_, err = RenameNickname(opts, names[0], names[1])
+		if err == nil {
+			t.Errorf("Renaming \"%s\" to \"%s\" should fail.", names[0], names[1])
+		}
+	}
+}
+
+func TestConcurrentEdits(t *testing.T) {
+	opts := LoadOptions{Filename: filepath.Join(t.TempDir(), "kconfig.yaml")}
+
+	errs := make(chan error)
+	for i := 0; i < 10; i++ {
+		nickname := fmt.Sprintf("nick%d", i)
+		go func() {
+			errs <- SetNickname(opts, nickname, "--context dev")
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("SetNickname failed: %v", err)
+		}
+	}
+
+	kc, err := Load(opts)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(kc.Nicknames()) != 10 {
+		t.Errorf("Some edits were lost.  The nicknames are: %v", kc.Nicknames())
+	}
+}
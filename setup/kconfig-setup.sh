@@ -18,32 +18,111 @@
 # executable supplied with kconfig to forward the operation to the selected kubectl executable.  If
 # an executable name isn't provided, "kubectl" is used.
 
+# The version of the interface between these shell functions and kconfig-util.  kconfig-util warns
+# when it doesn't match its own, which means these functions need to be sourced again after an
+# upgrade.  "kconfig-util version --check" checks it too.
+export _KCONFIG_SHELL_PROTOCOL=9
+
 # The user can type "koff" to undo the effects of kconfig and to restore the command prompt.
 function koff() {
-   # Restore the shell prompt.
+   # With the --dry-run option, kconfig-util only says what would be done, so don't evaluate it.
+   local _KARG
+   for _KARG in "$@"; do
+      if [[ "$_KARG" == "--dry-run" ]]; then
+         kconfig-util koff "$@"
+         return
+      fi
+   done
+
+   # Restore the shell prompt, which also removes any coloring for a dangerous nickname.
    if [[ -n "$_KCONFIG_OLD_PS1" ]]; then
       PS1="$_KCONFIG_OLD_PS1"
       unset _KCONFIG_OLD_PS1
    fi
 
    # Remove any session-local kubectl configuration file and unset or restore the KUBECONFIG env var.
-   if [[ -n "$KUBECONFIG" ]]; then
+   # With --all, the previous kset environments are forgotten too.
+   if [[ -n "$KUBECONFIG" || "$1" == "--all" ]]; then
       eval "$(kconfig-util koff "$@")"
    fi
 
+   # Close the file descriptor of any session-local kubectl configuration file kept open by kset.
+   _kconfig_close_config_fd
+
    # More cleanup
-   unset _KCONFIG_KUBECTL _KCONFIG_KSET TELEPORT_PROXY
+   unset _KCONFIG_KUBECTL _KCONFIG_KSET _KCONFIG_NAMESPACE _KCONFIG_CONTEXT _KCONFIG_CLUSTER _KCONFIG_SERVER _KCONFIG_ORIG_KUBECONFIG _KCONFIG_ENV _KCONFIG_OPENSHIFT TELEPORT_PROXY
 }
 
 # The main kset command.  See the prologue comments.
 function kset() {
+   # With the --print-only option, kconfig-util only prints information, so don't evaluate it.
+   local _KARG
+   for _KARG in "$@"; do
+      if [[ "$_KARG" == "--print-only" ]]; then
+         kconfig-util kset "$@"
+         return
+      fi
+   done
+
    # Run the service utility to create the session-local config file.  Evaluate any statements it
-   # sends to standard output, which we expect are to set environment variables.
-   local _KP
-   eval "$(kconfig-util kset "$@")"
+   # sends to standard output, which we expect are to set environment variables.  The exit status
+   # of kconfig-util is returned, so scripts can tell whether it failed.
+   local _KP _KPC _KCFG _KSP _KOUT _KSTATUS
+   _KOUT="$(kconfig-util kset "$@")"
+   _KSTATUS=$?
+   eval "$_KOUT"
+   _kconfig_set_kubeconfig || _KSTATUS=$?
+   _kconfig_set_prompt
+   return $_KSTATUS
+}
+
+# Keeps the session-local kubectl config file open on a file descriptor when kconfig-util prints
+# its content, base64-encoded, in the _KCFG variable, because of the --emit-config option or the
+# emit_config preference.  It's written to a file in memory-backed storage that's removed as soon
+# as it's opened, so it doesn't remain in any directory.  The KUBECONFIG env var refers to it as
+# /dev/fd/N, followed by the search path in the _KSP variable, if there is one.  This relies on
+# Linux, where each kubectl command opens /dev/fd/N afresh.  When kset writes a file instead, the
+# file descriptor is closed.
+function _kconfig_set_kubeconfig() {
+   if [[ -z "$_KCFG" ]]; then
+      if [[ -n "$_KCONFIG_FD" && "$KUBECONFIG" != "/dev/fd/$_KCONFIG_FD" && "$KUBECONFIG" != "/dev/fd/$_KCONFIG_FD:"* ]]; then
+         _kconfig_close_config_fd
+      fi
+      return 0
+   fi
+
+   if [[ -z "$_KCONFIG_FD" ]]; then
+      local _KFILE
+      _KFILE="$(mktemp "${XDG_RUNTIME_DIR:-/dev/shm}/kconfig.XXXXXX")" || return 1
+      exec {_KCONFIG_FD}<>"$_KFILE"
+      rm -f "$_KFILE"
+   fi
+   printf '%s' "$_KCFG" | base64 -d >"/dev/fd/$_KCONFIG_FD" || return 1
+   export KUBECONFIG="/dev/fd/$_KCONFIG_FD${_KSP:+:$_KSP}"
+}
+
+# Closes the file descriptor of the session-local kubectl config file, if kset keeps one open.
+function _kconfig_close_config_fd() {
+   if [[ -n "$_KCONFIG_FD" ]]; then
+      exec {_KCONFIG_FD}>&-
+      unset _KCONFIG_FD
+   fi
+}
 
-   # kconfig-util sets the _KP variable with the shell prompt info
+# Updates the shell prompt from the variables set by kconfig-util.  kconfig-util sets the _KP
+# variable with the shell prompt info.  For a dangerous nickname, it also sets the _KPC variable
+# with the ANSI color parameters for it.  The escape sequences are wrapped so the shell doesn't
+# count them in the length of the prompt.
+function _kconfig_set_prompt() {
    if [[ -n "$_KP" ]]; then
+      if [[ -n "$_KPC" ]]; then
+         if [[ -n "$ZSH_VERSION" ]]; then
+            _KP=$'%{\e['"$_KPC"$'m%}'"$_KP"$'%{\e[0m%}'
+         else
+            _KP='\[\e['"$_KPC"'m\]'"$_KP"'\[\e[0m\]'
+         fi
+      fi
+
       if [[ -z "$_KCONFIG_OLD_PS1" ]]; then
          _KCONFIG_OLD_PS1="$PS1"
          PS1="($_KP) $PS1"
@@ -53,21 +132,61 @@ function kset() {
    fi
 }
 
-# A bash command completion function, to complete alias names.
-function _kconfig_cmpl {
-   local -i idx=0
-   for name in $(kconfig-util complete "$2"); do
-      COMPREPLY[$idx]="$name"
-      idx=$idx+1
-   done
+# Prints the nickname of the current kset environment, or nothing, with a nonzero exit status, if
+# there isn't one.  It's fast enough to use in a shell prompt.
+function kcurrent() {
+   kconfig-util current
+}
+
+# A shell prompt hook that switches to the nickname named by a ".kconfig" file in the current
+# directory or its ancestors, when the directory_kconfig preference is enabled.  To use it, add it
+# to PROMPT_COMMAND (bash) or precmd_functions (zsh).
+function _kconfig_direnv_hook() {
+   eval "$(kconfig-util direnv-hook)"
+}
+
+# A shell prompt hook that updates the shell prompt when another tool changes the namespace of the
+# kset environment, like "kubectl config set-context --current --namespace foo" does.  To use it,
+# add it to PROMPT_COMMAND (bash) or precmd_functions (zsh).
+function _kconfig_prompt_hook() {
+   if [[ -n "$_KCONFIG_KSET" ]]; then
+      local _KP _KPC
+      eval "$(kconfig-util prompt --fast)"
+      _kconfig_set_prompt
+   fi
 }
 
-complete -F _kconfig_cmpl kset
+# Set up command completion for kset, koff, and kubectl.  The completion functions are generated by
+# kconfig-util.  In zsh, the native completion script is used if compinit has been run.  Otherwise
+# the bash script is used, which zsh can run after bashcompinit has been run.
+if command -v kconfig-util >/dev/null 2>&1; then
+   if [[ -n "$ZSH_VERSION" ]] && (( $+functions[compdef] )); then
+      eval "$(kconfig-util completion zsh)"
+   else
+      eval "$(kconfig-util completion bash)"
+   fi
+fi
+
+# In a new tmux pane, re-apply the kset environment last used in the pane's window, when the
+# tmux_integration preference is enabled.
+if [[ -n "$TMUX" && -z "$_KCONFIG_KSET" && "$1" != "clean" ]] && command -v kconfig-util >/dev/null 2>&1; then
+   eval "$(kconfig-util tmux restore)"
+fi
 
 if [[ "$1" == "clean" ]]; then
    koff
    unset kset
-   unset _kconfig_cmpl
+   unset kcurrent
+   unset _kconfig_set_prompt _kconfig_set_kubeconfig _kconfig_close_config_fd _kconfig_direnv_hook _kconfig_prompt_hook _KCONFIG_SHELL_PROTOCOL
    unset koff
-   complete -r kset
+   if [[ -n "$ZSH_VERSION" ]] && (( $+functions[compdef] )); then
+      compdef -d kset koff kubectl
+      unset _kconfig_describe _kconfig_kset _kconfig_kubectl
+   else
+      complete -r kset koff kubectl
+      unset _kconfig_cmpl _kconfig_kubectl_cmpl
+      if declare -F __start_kubectl >/dev/null; then
+         complete -o default -F __start_kubectl kubectl
+      fi
+   fi
 fi